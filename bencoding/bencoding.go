@@ -3,28 +3,111 @@ package bencoding
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"unicode"
 )
 
+// ErrTrailingData is returned by Decode if the input contains
+// data after the first complete bencoded value.
+var ErrTrailingData = errors.New("trailing data after bencoded value")
+
+// Decode decodes a single bencoded value from src. Whitespace around
+// the value is ignored, any other data following it is rejected.
 func Decode(src io.Reader) (Value, error) {
-	b, err := io.ReadAll(src)
+	v, trailing, err := decode(src)
 	if err != nil {
 		return nil, err
 	}
+	if len(bytes.TrimSpace(trailing)) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTrailingData, len(trailing))
+	}
+	return v, nil
+}
+
+// DecodeLenient decodes the first complete bencoded value from src and
+// ignores any data following it, as sent by some misbehaving servers.
+// It returns the number of bytes that were ignored.
+func DecodeLenient(src io.Reader) (Value, int, error) {
+	v, trailing, err := decode(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, len(trailing), nil
+}
+
+// decode decodes the first value of src and returns the data following it.
+func decode(src io.Reader) (Value, []byte, error) {
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	b = bytes.TrimSpace(b)
+	b = bytes.TrimLeftFunc(b, unicode.IsSpace)
 	if len(b) == 0 {
-		return nil, errors.New("no bencoded value in input")
+		return nil, nil, errors.New("no bencoded value in input")
+	}
+
+	if err := checkDepth(b, MaxDepth); err != nil {
+		return nil, nil, err
 	}
 
 	v := nextValue(b[0])
 	if v == nil {
-		return nil, errors.New("no bencoded value in input")
+		return nil, nil, errors.New("no bencoded value in input")
 	}
 
-	if _, err := v.(Decoder).Decode(b, 0); err != nil {
-		return nil, err
+	end, err := v.(Decoder).Decode(b, 0)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return v, nil
+	return v, b[end+1:], nil
+}
+
+// RawValue returns the value of key in the bencoded dictionary src as
+// found in src. It may differ from the value decoded and encoded again,
+// e.g. if the keys of a dictionary within it are not sorted. If src has
+// the key more than once, the last one is returned, as by Decode. ok is
+// false if src has no such key.
+func RawValue(src []byte, key string) (raw []byte, ok bool, err error) {
+	src = bytes.TrimLeftFunc(src, unicode.IsSpace)
+	if len(src) == 0 || src[0] != byte(dictionaryBegin) {
+		return nil, false, errors.New("no bencoded dictionary in input")
+	}
+	if err := checkDepth(src, MaxDepth); err != nil {
+		return nil, false, err
+	}
+
+	position := 0
+	for {
+		position++
+		if position >= len(src) {
+			return nil, false, errors.New("un-proper formatted dictionary")
+		}
+		if src[position] == byte(valueEnd) {
+			return raw, ok, nil
+		}
+
+		k := new(ByteString)
+		if position, err = k.Decode(src, position); err != nil {
+			return nil, false, err
+		}
+
+		position++
+		if position >= len(src) {
+			return nil, false, errors.New("un-proper formatted dictionary")
+		}
+		start := position
+		v := nextValue(src[position])
+		if v == nil {
+			return nil, false, fmt.Errorf("expected value, found unrecognized token: %q", src[position])
+		}
+		if position, err = v.(Decoder).Decode(src, position); err != nil {
+			return nil, false, err
+		}
+		if string(*k) == key {
+			raw, ok = src[start:position+1], true
+		}
+	}
 }
@@ -14,3 +14,65 @@ func TestDecodeEncode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, str, v.Literal())
 }
+
+func TestDecode_TrailingData(t *testing.T) {
+	tests := []struct {
+		name        string
+		src         string
+		wantStrict  error
+		wantIgnored int
+		wantLiteral string
+	}{
+		{name: "exact", src: "d1:ai1ee", wantLiteral: "d1:ai1ee"},
+		{name: "surrounding whitespace", src: "\n d1:ai1ee\r\n", wantLiteral: "d1:ai1ee", wantIgnored: 2},
+		{name: "integer", src: "i42e", wantLiteral: "i42e"},
+		{name: "string", src: "4:spam", wantLiteral: "4:spam"},
+		{name: "html comment", src: "d1:ai1ee<!-- served by tracker -->\n", wantLiteral: "d1:ai1ee", wantIgnored: 27, wantStrict: bencoding.ErrTrailingData},
+		{name: "second value", src: "i1ei2e", wantLiteral: "i1e", wantIgnored: 3, wantStrict: bencoding.ErrTrailingData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := bencoding.Decode(strings.NewReader(tt.src))
+			if tt.wantStrict != nil {
+				assert.ErrorIs(t, err, tt.wantStrict)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantLiteral, v.Literal())
+			}
+
+			v, ignored, err := bencoding.DecodeLenient(strings.NewReader(tt.src))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLiteral, v.Literal())
+			assert.Equal(t, tt.wantIgnored, ignored)
+		})
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		key     string
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "unsorted keys kept", src: "d4:infod1:bi1e1:ai2ee1:xi0ee", key: "info", want: "d1:bi1e1:ai2ee", wantOK: true},
+		{name: "list", src: " d1:al1:x1:yee", key: "a", want: "l1:x1:ye", wantOK: true},
+		{name: "last duplicate", src: "d1:ai1e1:ai2ee", key: "a", want: "i2e", wantOK: true},
+		{name: "missing", src: "d1:ai1ee", key: "info"},
+		{name: "not a dictionary", src: "l1:ae", key: "a", wantErr: true},
+		{name: "truncated", src: "d1:ai1e", key: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, ok, err := bencoding.RawValue([]byte(tt.src), tt.key)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, string(raw))
+		})
+	}
+}
@@ -1,10 +1,7 @@
 package bencoding
 
 import (
-	"fmt"
-	"maps"
 	"reflect"
-	"slices"
 	"strings"
 )
 
@@ -17,18 +14,7 @@ func (d *Dictionary) Type() Type { return DictionaryType }
 
 func (d *Dictionary) Literal() string {
 	b := &strings.Builder{}
-
-	b.WriteByte(byte(dictionaryBegin))
-	if d != nil {
-		k := slices.Collect(maps.Keys(d.Dict))
-		slices.Sort(k)
-		for _, k := range k {
-			b.WriteString(fmt.Sprintf("%d:%s", len(k), k))
-			b.WriteString(d.Dict[k].Literal())
-		}
-	}
-	b.WriteByte(byte(valueEnd))
-
+	_ = encode(b, d, 0)
 	return b.String()
 }
 
@@ -0,0 +1,131 @@
+package bencoding
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxDepth is the maximum number of lists and dictionaries nested within
+// each other that Decode and Encode accept. No torrent comes close to it.
+const MaxDepth = 128
+
+// ErrMaxDepth is returned by Decode and Encode for values nested deeper than MaxDepth.
+var ErrMaxDepth = errors.New("bencoded value nested too deeply")
+
+// Encode writes the bencoded v to w. Unlike Literal it rejects values
+// nested deeper than MaxDepth, such as values supplied by peers.
+func Encode(w io.Writer, v Value) error {
+	b := &strings.Builder{}
+	if err := encode(b, v, MaxDepth); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// encode writes the bencoded v to b. Lists and dictionaries are encoded
+// with an explicit stack, so that deeply nested values do not grow the
+// goroutine stack. A maxDepth of zero or less does not limit the nesting.
+func encode(b *strings.Builder, v Value, maxDepth int) error {
+	type container struct {
+		list List
+		dict map[string]Value
+		keys []string
+		next int
+	}
+
+	var stack []*container
+	for {
+		switch c := v.(type) {
+		case nil:
+		case *List:
+			b.WriteByte(byte(listBegin))
+			s := &container{}
+			if c != nil {
+				s.list = *c
+			}
+			stack = append(stack, s)
+		case *Dictionary:
+			b.WriteByte(byte(dictionaryBegin))
+			s := &container{}
+			if c != nil {
+				s.dict = c.Dict
+				s.keys = slices.Sorted(maps.Keys(c.Dict))
+			}
+			stack = append(stack, s)
+		default:
+			b.WriteString(c.Literal())
+		}
+		v = nil
+
+		if maxDepth > 0 && len(stack) > maxDepth {
+			return fmt.Errorf("%w: more than %d levels", ErrMaxDepth, maxDepth)
+		}
+
+		for v == nil {
+			if len(stack) == 0 {
+				return nil
+			}
+			top := stack[len(stack)-1]
+			switch {
+			case top.next < len(top.list):
+				v = top.list[top.next]
+				top.next++
+			case top.next < len(top.keys):
+				k := top.keys[top.next]
+				b.WriteString(fmt.Sprintf("%d:%s", len(k), k))
+				v = top.dict[k]
+				top.next++
+			default:
+				b.WriteByte(byte(valueEnd))
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
+
+// checkDepth returns ErrMaxDepth if the lists and dictionaries in src are
+// nested deeper than maxDepth, before the recursive decoders get to see it.
+// Malformed input is left for the decoders to report.
+func checkDepth(src []byte, maxDepth int) error {
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case byte(listBegin), byte(dictionaryBegin):
+			if depth++; depth > maxDepth {
+				return fmt.Errorf("%w: more than %d levels", ErrMaxDepth, maxDepth)
+			}
+		case byte(valueEnd):
+			if depth--; depth == 0 {
+				return nil
+			}
+		case byte(integerBegin):
+			end, err := advanceUntil(src, i, valueEnd)
+			if err != nil {
+				return nil
+			}
+			i = end
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			colon, err := advanceUntil(src, i, valueDelimiter)
+			if err != nil {
+				return nil
+			}
+			n, err := strconv.Atoi(string(src[i:colon]))
+			if err != nil || n < 0 {
+				return nil
+			}
+			i = colon + n
+		default:
+			return nil
+		}
+		if depth == 0 {
+			return nil // a single integer or string.
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package bencoding_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Despire/tinytorrent/bencoding"
+	"github.com/stretchr/testify/assert"
+)
+
+// nested returns depth lists and dictionaries nested within each other.
+func nested(depth int) (bencoding.Value, string) {
+	var v bencoding.Value = new(bencoding.Integer)
+	var prefix []string
+	for i := range depth {
+		if i%2 == 0 {
+			v = &bencoding.List{v}
+			prefix = append(prefix, "l")
+		} else {
+			v = &bencoding.Dictionary{Dict: map[string]bencoding.Value{"a": v}}
+			prefix = append(prefix, "d1:a")
+		}
+	}
+	slices.Reverse(prefix)
+	return v, strings.Join(prefix, "") + "i0e" + strings.Repeat("e", depth)
+}
+
+func TestEncode_MaxDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		depth   int
+		wantErr error
+	}{
+		{name: "flat", depth: 0},
+		{name: "at limit", depth: bencoding.MaxDepth},
+		{name: "past limit", depth: bencoding.MaxDepth + 1, wantErr: bencoding.ErrMaxDepth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, literal := nested(tt.depth)
+
+			b := &strings.Builder{}
+			err := bencoding.Encode(b, v)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, literal, b.String())
+			} else {
+				assert.Empty(t, b.String(), "nothing is written on error")
+			}
+
+			decoded, err := bencoding.Decode(strings.NewReader(literal))
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, literal, decoded.Literal())
+			}
+		})
+	}
+}
+
+func TestDecode_MaxDepthIgnoresStrings(t *testing.T) {
+	// brackets within strings and integers do not count as nesting.
+	src := "l" + "300:" + strings.Repeat("l", 300) + "i-1e" + "e"
+	v, err := bencoding.Decode(strings.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, src, v.Literal())
+}
+
+func TestLiteral_Deep(t *testing.T) {
+	v, literal := nested(100_000)
+	assert.Equal(t, literal, v.Literal())
+}
+
+func TestEncode_Empty(t *testing.T) {
+	tests := []struct {
+		name  string
+		value bencoding.Value
+		want  string
+	}{
+		{name: "nil list", value: (*bencoding.List)(nil), want: "le"},
+		{name: "nil dictionary", value: (*bencoding.Dictionary)(nil), want: "de"},
+		{name: "empty dictionary", value: &bencoding.Dictionary{}, want: "de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			assert.NoError(t, bencoding.Encode(b, tt.value))
+			assert.Equal(t, tt.want, b.String())
+			assert.Equal(t, tt.want, tt.value.Literal())
+		})
+	}
+}
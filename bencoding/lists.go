@@ -12,15 +12,7 @@ func (l *List) Type() Type { return ListType }
 
 func (l *List) Literal() string {
 	b := &strings.Builder{}
-
-	b.WriteByte(byte(listBegin))
-	if l != nil {
-		for _, v := range *l {
-			b.WriteString(v.Literal())
-		}
-	}
-	b.WriteByte(byte(valueEnd))
-
+	_ = encode(b, l, 0)
 	return b.String()
 }
 
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// check cross-verifies the on-disk data against a torrent file
+// without adding the torrent to a client or contacting its swarm.
+//
+// Usage: tinytorrent check <file.torrent> <data-path>
+func check(ctx context.Context, out io.Writer, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tinytorrent check <file.torrent> <data-path>")
+	}
+
+	mi, err := loadTorrent(args[0])
+	if err != nil {
+		return err
+	}
+
+	report, err := torrent.VerifyData(ctx, mi, args[1], torrent.VerifyOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to verify data at %q: %w", args[1], err)
+	}
+
+	fmt.Fprintf(out, "pieces: %d ok, %d bad, %d missing (of %d)\n", report.OK, report.Bad, report.Missing, len(report.Pieces))
+	fmt.Fprintln(out, "files:")
+	for _, f := range report.Files {
+		fmt.Fprintf(out, "  %6.2f%%  %s (%d bytes)\n", f.Completeness(), f.Path, f.Length)
+	}
+	if len(report.BadRanges) > 0 {
+		fmt.Fprintln(out, "differing byte ranges:")
+		for _, r := range report.BadRanges {
+			fmt.Fprintf(out, "  [%d, %d)\n", r.Offset, r.Offset+r.Length)
+		}
+	}
+
+	if report.Bad > 0 || report.Missing > 0 {
+		return fmt.Errorf("data at %q does not match the torrent", args[1])
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+)
+
+// announceState is what the announce loop of a torrent keeps from the
+// responses of the tracker, which may change any of it at any time.
+type announceState struct {
+	// interval is the effective time between regular announces.
+	interval time.Duration
+	// trackerID is sent with every announce once received. Responses
+	// that omit it do not clear it.
+	trackerID *string
+	// seeders, leechers and downloaded are the last counts reported by the tracker.
+	seeders, leechers, downloaded *int64
+	// minInterval is the time the tracker asks to wait at least between announces.
+	minInterval time.Duration
+	// last is the time of the last successful announce, early
+	// the time of the last announce requested by the tracker.
+	last, early time.Time
+}
+
+// earlyAnnounceBackoff is the minimum time between two early announces.
+const earlyAnnounceBackoff = 5 * time.Minute
+
+// numWant returns the number of peers the next announce of t asks for,
+// as decided by the torrent from the peers it has, and records it.
+func numWant(t *status.TorrentSession) *int64 {
+	n := t.NumWant()
+	t.RecordNumWant(n)
+	return &n
+}
+
+// logPeersReturned logs how many peers the last announce of t
+// asked for, and how many the tracker responded with.
+func logPeersReturned(logger *slog.Logger, t *status.TorrentSession, resp *tracker.Response) {
+	logger.Debug("tracker returned peers",
+		slog.Int64("requested", t.TrackerStatus().NumWant),
+		slog.Int("returned", len(resp.Peers)),
+	)
+}
+
+// allowEarly reports whether an announce can be sent before the interval
+// elapsed, respecting the min interval of the tracker and earlyAnnounceBackoff.
+func (s *announceState) allowEarly(now time.Time) bool {
+	if now.Sub(s.last) < s.minInterval {
+		return false
+	}
+	return s.early.IsZero() || now.Sub(s.early) >= earlyAnnounceBackoff
+}
+
+// update applies a successful response and
+// reports whether the interval changed.
+func (s *announceState) update(resp *tracker.Response) bool {
+	if resp.TrackerID != nil {
+		s.trackerID = resp.TrackerID
+	}
+	if resp.Complete != nil {
+		s.seeders = resp.Complete
+	}
+	if resp.Incomplete != nil {
+		s.leechers = resp.Incomplete
+	}
+	if resp.Downloaded != nil {
+		s.downloaded = resp.Downloaded
+	}
+
+	if resp.MinInterval != nil {
+		s.minInterval = time.Duration(*resp.MinInterval) * time.Second
+	}
+
+	if resp.Interval == nil || *resp.Interval <= 0 {
+		return false
+	}
+	interval := max(time.Duration(*resp.Interval)*time.Second, s.minInterval)
+	if interval == s.interval {
+		return false
+	}
+	s.interval = interval
+	return true
+}
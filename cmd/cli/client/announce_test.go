@@ -0,0 +1,248 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/cmd/cli/client/tortest"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnounceState(t *testing.T) {
+	// the tracker issues an id with the first response only
+	// and raises the interval from 30s to 1800s mid-session.
+	responses := []string{
+		"d8:intervali30e10:tracker id3:abc8:completei5e10:incompletei7e5:peers0:e",
+		"d8:intervali30e10:downloadedi9e5:peers0:e",
+		"d8:intervali1800e8:completei6e5:peers0:e",
+		"d5:peers0:e",
+		"d8:intervali60e12:min intervali900e5:peers0:e",
+	}
+	tests := []struct {
+		wantChanged  bool
+		wantInterval time.Duration
+		wantSeeders  int64
+		wantLeechers int64
+		// wantDownloaded is -1 until the tracker reported it.
+		wantDownloaded int64
+	}{
+		{wantChanged: true, wantInterval: 30 * time.Second, wantSeeders: 5, wantLeechers: 7, wantDownloaded: -1},
+		{wantInterval: 30 * time.Second, wantSeeders: 5, wantLeechers: 7, wantDownloaded: 9},
+		{wantChanged: true, wantInterval: 1800 * time.Second, wantSeeders: 6, wantLeechers: 7, wantDownloaded: 9},
+		{wantInterval: 1800 * time.Second, wantSeeders: 6, wantLeechers: 7, wantDownloaded: 9},
+		{wantChanged: true, wantInterval: 900 * time.Second, wantSeeders: 6, wantLeechers: 7, wantDownloaded: 9},
+	}
+
+	var (
+		l          sync.Mutex
+		trackerIDs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		trackerIDs = append(trackerIDs, r.URL.Query().Get("trackerid"))
+		fmt.Fprint(rw, responses[len(trackerIDs)-1])
+	}))
+	t.Cleanup(srv.Close)
+
+	var state announceState
+	for i, tt := range tests {
+		resp, err := new(tracker.Client).CreateRequest(context.Background(), srv.URL, &tracker.RequestParams{
+			InfoHash:  "01234567890123456789",
+			PeerID:    "-TT0100-000000000000",
+			Port:      6881,
+			TrackerID: state.trackerID,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, tt.wantChanged, state.update(resp), "response %d", i)
+		assert.Equal(t, tt.wantInterval, state.interval, "response %d", i)
+		assert.Equal(t, tt.wantSeeders, *state.seeders, "response %d", i)
+		assert.Equal(t, tt.wantLeechers, *state.leechers, "response %d", i)
+		if tt.wantDownloaded < 0 {
+			assert.Nil(t, state.downloaded, "response %d", i)
+		} else {
+			assert.Equal(t, tt.wantDownloaded, *state.downloaded, "response %d", i)
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	assert.Equal(t, []string{"", "abc", "abc", "abc", "abc"}, trackerIDs)
+}
+
+func TestAnnounceState_AllowEarly(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		state announceState
+		want  bool
+	}{
+		{name: "no min interval", state: announceState{last: now.Add(-time.Second)}, want: true},
+		{name: "within min interval", state: announceState{last: now.Add(-time.Minute), minInterval: 15 * time.Minute}},
+		{name: "after min interval", state: announceState{last: now.Add(-15 * time.Minute), minInterval: 15 * time.Minute}, want: true},
+		{name: "recent early announce", state: announceState{last: now.Add(-time.Hour), early: now.Add(-time.Minute)}},
+		{name: "early announce backed off", state: announceState{last: now.Add(-time.Hour), early: now.Add(-earlyAnnounceBackoff)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.state.allowEarly(now))
+		})
+	}
+}
+
+func TestClient_TrackerTLS(t *testing.T) {
+	agents := make(chan string, 10)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+
+	// the certificate of the tracker is self-signed.
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{name: "unknown authority", wantErr: "certificate"},
+		{name: "custom CA", opts: []Option{WithTrackerTLS(&tls.Config{RootCAs: roots})}},
+		{name: "custom client", opts: []Option{WithTrackerHTTPClient(srv.Client())}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{
+				WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+				WithDownloadDir(t.TempDir()),
+				WithUserAgent("tinytorrent/1.0"),
+			}, tt.opts...)
+			c, err := New(opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			t.Cleanup(func() { c.Close(context.Background()) })
+
+			_, err = c.trackers.CreateRequest(context.Background(), srv.URL+"/announce", &tracker.RequestParams{
+				InfoHash: "01234567890123456789",
+				PeerID:   "-TT0100-000000000000",
+				Port:     6881,
+			})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "tinytorrent/1.0", <-agents)
+		})
+	}
+}
+
+func TestClient_TrackerTimeout(t *testing.T) {
+	// the tracker never responds.
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithDownloadDir(t.TempDir()),
+		WithTrackerTimeout(50*time.Millisecond),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	start := time.Now()
+	_, err = c.trackers.CreateRequest(context.Background(), srv.URL+"/announce", &tracker.RequestParams{
+		InfoHash: "01234567890123456789",
+		PeerID:   "-TT0100-000000000000",
+		Port:     6881,
+	})
+	var nerr net.Error
+	if assert.ErrorAs(t, err, &nerr) {
+		assert.True(t, nerr.Timeout())
+	}
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestClient_AnnouncePort(t *testing.T) {
+	tr := tortest.NewTracker()
+	t.Cleanup(tr.Close)
+	newTorrent := func(b byte) *torrent.MetaInfoFile {
+		mi, err := tortest.NewTorrent(tr.URL, messagesv1.RequestSize, bytes.Repeat([]byte{b}, messagesv1.RequestSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mi
+	}
+	global, override := newTorrent('a'), newTorrent('b')
+
+	c, err := New(
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithDownloadDir(t.TempDir()),
+		WithAction(Both),
+		WithPort(0),
+		WithAnnouncePort(51413),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	globalID, err := c.WorkOn(global)
+	assert.NoError(t, err)
+	overrideID, err := c.WorkOnWithOptions(override, TorrentWithAnnouncePort(40000))
+	assert.NoError(t, err)
+
+	ports := func() map[string]int64 {
+		out := make(map[string]int64)
+		for _, a := range tr.Announces() {
+			out[a.InfoHash] = a.Port
+		}
+		return out
+	}
+	assert.Eventually(t, func() bool { return len(ports()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]int64{globalID: 51413, overrideID: 40000}, ports())
+
+	st, err := c.Status(globalID)
+	assert.NoError(t, err)
+	assert.Equal(t, 51413, st.AnnouncePort)
+	assert.Equal(t, int(c.listenPort()), st.ListenPort)
+	assert.NotEqual(t, st.AnnouncePort, st.ListenPort)
+
+	// the inbound connections still arrive on the port the client is bound to.
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(st.ListenPort)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	hs := &messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: globalID, PeerID: "-TT0100-000000000001"}
+	_, err = conn.Write(hs.Serialize())
+	assert.NoError(t, err)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var buf [messagesv1.HandshakeLength]byte
+	_, err = io.ReadFull(conn, buf[:])
+	assert.NoError(t, err)
+	remote := new(messagesv1.Handshake)
+	if assert.NoError(t, remote.Deserialize(buf[:])) {
+		assert.Equal(t, globalID, remote.InfoHash)
+	}
+}
@@ -2,37 +2,37 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/blocklist"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/dht"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/dnscache"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/socks5"
 	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
 	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
 	"github.com/Despire/tinytorrent/torrent"
 )
 
-var (
-	// TorrentDir is the directory where the torrent files will
-	// be downloaded.
-	TorrentDir = os.Getenv("TORRENT_DIR")
-)
-
-func init() {
-	if TorrentDir == "" {
-		TorrentDir = "./tinytorrendDownloads"
-		if _, err := os.Stat(TorrentDir); errors.Is(err, os.ErrNotExist) {
-			if err := os.Mkdir(TorrentDir, os.ModePerm); err != nil {
-				panic(err)
-			}
-		}
-	}
-}
+// DefaultDownloadDir is the directory the torrents are downloaded to,
+// unless overridden by the TORRENT_DIR environment variable or by
+// WithDownloadDir.
+const DefaultDownloadDir = "./tinytorrendDownloads"
 
 type Action string
 
@@ -45,33 +45,262 @@ const (
 // the BitTorrent network.
 type Client struct {
 	id   string
+	key  string
 	port int
+	// publicPort is announced instead of the listen port if positive,
+	// see WithAnnouncePort.
+	publicPort int
 
 	logger *slog.Logger
 
-	handler chan string
+	handler chan *download
 	done    chan struct{}
 
+	// ctx is canceled once the client is closed, which stops
+	// the downloads of all torrents.
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	torrentsDownloading sync.Map
 	action              Action
 	seedServer          net.Listener
 
+	// downloadDir is the directory where the torrents are
+	// downloaded to, unless overridden per torrent.
+	downloadDir string
+
+	// numWant is the number of peers the first announce
+	// of each torrent asks for, see status.WithNumWant.
+	numWant int64
+
+	// endgameBlocks is the fixed endgame threshold passed
+	// to each torrent, if positive.
+	endgameBlocks int
+
+	// blockLogSampling is the rate the debug lines of the
+	// blocks are sampled at, see status.WithBlockLogSampling.
+	blockLogSampling int
+
+	// seedRatio and seedTime are the seeding goals
+	// after which a downloaded torrent stops seeding.
+	seedRatio float64
+	seedTime  time.Duration
+
+	// maxActivePieces is the number of pieces of each torrent
+	// downloaded concurrently, if positive.
+	maxActivePieces int
+
+	// compactPieceStates holds the piece states of every
+	// torrent in a table, see status.WithCompactPieceStates.
+	compactPieceStates bool
+
+	// coordinator shares the active pieces and connections among the
+	// downloading torrents, of which at most maxActiveTorrents download
+	// at a time if positive, within memoryBudget bytes if positive.
+	coordinator       *status.Coordinator
+	maxActiveTorrents int
+	memoryBudget      int64
+
+	// buffers bounds the memory held by the pieces of all
+	// torrents that are downloaded, verified or flushed.
+	buffers      *status.BufferBudget
+	bufferBudget int64
+
+	// readBack verifies the flushed pieces of each torrent again.
+	readBack bool
+
+	// conns bounds the peer connections of all torrents, by
+	// maxConnections unless the file descriptor limit is lower.
+	conns          *status.ConnLimit
+	maxConnections int
+
+	// disk is shared among the torrents so that uploads of
+	// one torrent cannot starve the flushes of another.
+	disk     *storage.Scheduler
+	diskOpts []storage.SchedulerOption
+	// cache holds the pieces read to serve the uploads of all
+	// torrents, nil if pieceCacheSize is not positive.
+	cache          *storage.PieceCache
+	pieceCacheSize int64
+
+	// downloads holds the *download of each started torrent, keyed by info hash.
+	downloads sync.Map
+	// l serializes adding, pausing, resuming and removing torrents,
+	// and closing done, so that no torrent is added once it is closed.
+	l sync.Mutex
+	// ops holds the lock of each torrent an operation runs on,
+	// which serializes the operations on the same torrent.
+	ops  map[string]*opLock
+	opsL sync.Mutex
+
+	// clock tells the time of the client and its torrents, see WithClock.
+	clock Clock
+	// randSeed seeds the random choices of each torrent, if set.
+	randSeed *uint64
+
+	// newStorage returns the storage of each torrent, if set.
+	newStorage func(t *torrent.MetaInfoFile) storage.Storage
+
+	// control serves the control API, if enabled.
+	control      *http.Server
+	controlAddr  string
+	controlToken string
+	controlLn    net.Listener
+	// metrics serves the metrics on the control API, see WithMetrics.
+	metrics bool
+	retired retiredMetrics
+
+	// blocklist holds the addresses of peers that are not contacted,
+	// blocked counts the connections refused because of it.
+	blocklist    atomic.Pointer[blocklist.List]
+	blocklistSrc io.Reader
+	blocked      atomic.Int64
+
+	// resolver resolves host names, cached by dns.
+	resolver Resolver
+	dns      *dnscache.Cache
+	// proxy is the SOCKS5 proxy the outbound connections go through, if
+	// set. If it fails closed, no connection is established without it.
+	proxyURL        string
+	proxyFailClosed bool
+	proxy           *socks5.Dialer
+	// dial establishes the connections to trackers, web seeds and seeders.
+	dial peer.DialFunc
+	// trackers sends the requests to trackers through dial.
+	trackers *tracker.Client
+
+	// trackerHTTP, if set, replaces the client built from the
+	// TLS config, timeout and dial for the requests to trackers.
+	trackerHTTP    *http.Client
+	trackerTLS     *tls.Config
+	trackerTimeout time.Duration
+	userAgent      string
+
+	// rejectCredentials refuses torrents with credentials in announce URLs.
+	rejectCredentials bool
+
+	// restore holds the tracked torrents persisted in the
+	// download directory, if sessionRestore is set.
+	sessionRestore bool
+	restore        map[string]*restoreTorrent
+	restoreL       sync.Mutex
+
+	// dht finds the peers of torrents that are not private, if enabled.
+	dhtEnabled   bool
+	dhtBootstrap []string
+	dht          *dht.Server
+
+	// onComplete is called with the id of each torrent that completed, if set.
+	onComplete func(id string, e TorrentCompleted)
+
+	// watchPath is the directory scanned for torrent files every
+	// watchInterval, if set.
+	watchPath     string
+	watchInterval time.Duration
+
+	closeOnce sync.Once
+	closeErr  error
+
 	wg sync.WaitGroup
 }
 
-func New(opts ...Option) (*Client, error) {
+func New(opts ...Option) (_ *Client, err error) {
 	p := &Client{
-		handler: make(chan string),
+		handler: make(chan *download),
 		done:    make(chan struct{}),
 	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	defaults(p)
 
 	for _, o := range opts {
 		o(p)
 	}
+	logBuildInformation(p)
+
+	if p.id == "" {
+		id, err := GeneratePeerID()
+		if err != nil {
+			return nil, err
+		}
+		p.id = id
+	}
+	if err := validatePeerID(p.id); err != nil {
+		return nil, fmt.Errorf("invalid peer id: %w", err)
+	}
+
+	if err := os.MkdirAll(p.downloadDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	if p.watchPath != "" {
+		fi, err := os.Stat(p.watchPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch directory: %w", err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("invalid watch directory: %s is not a directory", p.watchPath)
+		}
+		if p.watchInterval <= 0 {
+			p.watchInterval = defaultWatchInterval
+		}
+	}
+
+	p.initResolver()
+	if err := p.initDial(); err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+
+	if p.blocklistSrc != nil {
+		if err := p.LoadBlocklist(p.blocklistSrc, false); err != nil {
+			return nil, fmt.Errorf("failed to load blocklist: %w", err)
+		}
+	}
+
+	key, err := loadOrCreateKey(p.downloadDir)
+	if err != nil {
+		return nil, err
+	}
+	p.key = key
 
-	if p.action != Leech {
-		var err error
+	fds, known := fdLimit()
+	conns, exceeded := connectionLimit(p.maxConnections, fds, known)
+	files := openFileLimit(conns, fds, known)
+	if exceeded {
+		p.logger.Warn("configured peer connections exceed the file descriptor limit of the process, lowering them",
+			slog.Int("configured", p.maxConnections),
+			slog.Int("connections", conns),
+			slog.Int("open_files", files),
+			slog.Uint64("fd_limit", fds),
+		)
+	}
+
+	p.disk = storage.NewScheduler(append(p.diskOpts, storage.WithMaxOpenFiles(files))...)
+	defer func() {
+		if err != nil {
+			p.release()
+		}
+	}()
+	if p.pieceCacheSize > 0 {
+		p.cache = storage.NewPieceCache(p.pieceCacheSize)
+	}
+	p.buffers = status.NewBufferBudget(p.bufferBudget)
+
+	p.conns = status.NewConnLimit(conns)
+	p.coordinator = status.NewCoordinator(p.conns, p.maxActiveTorrents, p.memoryBudget)
+
+	if p.dhtEnabled && p.proxy != nil {
+		p.logger.Warn("not starting the dht, as it cannot be used through the proxy")
+	}
+	if p.dhtEnabled && p.proxy == nil {
+		if err := p.startDHT(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.action != Leech && p.proxy != nil {
+		p.logger.Warn("not accepting connections from leechers, as they cannot reach the client through the proxy")
+	}
+	if p.action != Leech && p.proxy == nil {
 		if p.seedServer, err = net.Listen("tcp", fmt.Sprintf("0.0.0.0:%v", p.port)); err != nil {
 			return nil, fmt.Errorf("failed to announce listener server to the network: %w", err)
 		}
@@ -79,49 +308,501 @@ func New(opts ...Option) (*Client, error) {
 		go p.acceptLeechers()
 	}
 
+	if p.controlAddr != "" {
+		if p.controlLn, err = net.Listen("tcp", p.controlAddr); err != nil {
+			return nil, fmt.Errorf("failed to listen for the control API: %w", err)
+		}
+		p.control = &http.Server{Handler: newControlAPI(p, p.controlToken)}
+	}
+
 	p.wg.Add(1)
 	go p.watch()
 
+	// the torrents of the previous run are added before any new ones.
+	if p.sessionRestore {
+		p.restore = make(map[string]*restoreTorrent)
+		p.restoreSession()
+		p.saveRestore()
+	}
+
+	if p.control != nil {
+		p.wg.Add(1)
+		go p.serveControlAPI()
+	}
+
+	if p.watchPath != "" {
+		p.wg.Add(1)
+		go p.watchDir()
+	}
+
 	return p, nil
 }
 
-func (p *Client) Close() error {
+// closeDisk stops the workers of the disk scheduler, waiting for the
+// operations in progress until ctx is done.
+func (p *Client) closeDisk(ctx context.Context) {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		p.disk.Close()
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		p.logger.Warn("disk operations did not finish before shutdown", slog.Any("err", ctx.Err()))
+	}
+}
+
+// release stops the workers, the listeners and the dht started
+// by New before it failed.
+func (p *Client) release() {
 	if p.seedServer != nil {
 		p.seedServer.Close()
 	}
+	if p.controlLn != nil {
+		p.controlLn.Close()
+	}
+	if p.dht != nil {
+		if err := p.dht.Close(); err != nil {
+			p.logger.Error("failed to stop dht", slog.Any("err", err))
+		}
+	}
 	close(p.done)
+	p.cancel()
 	p.wg.Wait()
+	p.disk.Close()
+}
+
+// Close stops all torrents. Each torrent announces the stopped event to
+// its tracker and persists its resume data. Close returns once everything
+// was shut down or ctx is done, in which case the error lists the torrents
+// that did not shut down cleanly. It is safe to call Close more than once.
+func (p *Client) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() { p.closeErr = p.shutdown(ctx) })
+	return p.closeErr
+}
+
+func (p *Client) shutdown(ctx context.Context) error {
+	// the disk is released once the workers were waited on or given up on.
+	defer p.closeDisk(ctx)
+
+	if p.seedServer != nil {
+		p.seedServer.Close()
+	}
+	if p.control != nil {
+		if err := p.control.Shutdown(ctx); err != nil {
+			p.logger.Error("failed to shut down control API", slog.Any("err", err))
+		}
+	}
+	// torrents being added are stored before done is closed, the
+	// torrents ranged over below are all that are ever added.
+	p.l.Lock()
+	close(p.done)
+	p.l.Unlock()
 
+	if p.dht != nil {
+		if err := p.dht.Close(); err != nil {
+			p.logger.Error("failed to stop dht", slog.Any("err", err))
+		}
+	}
+
+	closed := make(map[string]chan struct{})
 	p.torrentsDownloading.Range(func(key, value any) bool {
 		id := key.(string)
-		tr := value.(*status.Tracker)
-		if err := tr.Close(); err != nil {
-			p.logger.Error("failed to stop torrent", slog.String("torrent", id))
-		}
+		tr := value.(*status.TorrentSession)
+		done := make(chan struct{})
+		closed[id] = done
+		go func() {
+			defer close(done)
+			// the tracker is closed only after the stopped event was announced.
+			if d, ok := p.downloads.Load(id); ok {
+				<-d.(*download).stopped
+			}
+			if err := tr.Close(); err != nil {
+				p.logger.Error("failed to stop torrent", slog.String("torrent", hex.EncodeToString([]byte(id))), slog.Any("err", err))
+			}
+		}()
 		return true
 	})
+
+	var unclean []string
+	for id, done := range closed {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			unclean = append(unclean, hex.EncodeToString([]byte(id)))
+		}
+	}
+	// with the final counters of the closed torrents.
+	p.saveRestore()
+
+	waited := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+	}
+
+	if len(unclean) > 0 {
+		slices.Sort(unclean)
+		return fmt.Errorf("torrents %s did not shut down cleanly: %w", strings.Join(unclean, ", "), ctx.Err())
+	}
+	select {
+	case <-waited:
+	default:
+		return fmt.Errorf("client did not shut down cleanly: %w", ctx.Err())
+	}
+
 	p.torrentsDownloading.Clear()
 	return nil
 }
 
-func (p *Client) WorkOn(t *torrent.MetaInfoFile) (string, error) {
+// DiskStats returns the metrics of the disk shared among the torrents.
+func (p *Client) DiskStats() storage.Stats { return p.disk.Stats() }
+
+// CacheStats returns the counters of the piece cache shared among
+// the torrents, zero if the cache is disabled.
+func (p *Client) CacheStats() storage.CacheStats {
+	if p.cache == nil {
+		return storage.CacheStats{}
+	}
+	return p.cache.Stats()
+}
+
+// WorkOn starts downloading the torrent with the client wide settings,
+// see WorkOnWithOptions.
+func (p *Client) WorkOn(t *torrent.MetaInfoFile) (string, error) { return p.WorkOnWithOptions(t) }
+
+// WorkOnWithOptions starts downloading the torrent, with the client
+// wide settings overridden by opts. It returns the id of the torrent.
+func (p *Client) WorkOnWithOptions(t *torrent.MetaInfoFile, opts ...TorrentOption) (string, error) {
 	h := string(t.Metadata.Hash[:])
 
+	// a torrent being removed is not added again before its data is deleted.
+	unlock := p.lockTorrent(h)
+	defer unlock()
+
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	select {
+	case <-p.done:
+		return "", ErrClosed
+	default:
+	}
 	if _, ok := p.torrentsDownloading.Load(h); ok {
-		return "", fmt.Errorf("torrent with hash %s is already tracked", h)
+		return "", fmt.Errorf("torrent with id %x: %w", h, ErrAlreadyTracked)
+	}
+
+	if err := p.checkTrackerCredentials(t); err != nil {
+		return "", err
+	}
+	if err := p.checkMetainfo(h, t); err != nil {
+		return "", err
+	}
+
+	o := torrentOptions{
+		dir:             p.downloadDir,
+		maxActivePieces: p.maxActivePieces,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := os.MkdirAll(o.dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	seedRatio := p.seedRatio
+	if o.seedRatio != nil {
+		seedRatio = *o.seedRatio
 	}
 
-	tr, err := status.NewTracker(p.id, p.logger, t, TorrentDir)
+	trackerOpts := []status.Option{
+		status.WithEndgameThreshold(p.endgameBlocks),
+		status.WithBlockLogSampling(p.blockLogSampling),
+		status.WithSeedRatio(seedRatio),
+		status.WithSeedTime(p.seedTime),
+		status.WithDiskScheduler(p.disk),
+		status.WithPieceCache(p.cache),
+		status.WithBufferBudget(p.buffers),
+		status.WithConnLimit(p.conns),
+		status.WithCoordinator(p.coordinator, o.priority),
+		status.WithPeerFilter(p.isBlocked),
+		status.WithDialer(p.dial),
+		status.WithListenAddrs(p.listenAddrs()...),
+		status.WithNumWant(p.numWant),
+		status.WithAnnouncePort(o.announcePort),
+		status.WithMaxActivePieces(o.maxActivePieces),
+		status.WithMaxPeers(o.maxPeers),
+		status.WithMaxPipeline(o.maxPipeline),
+		status.WithClock(p.clock),
+	}
+	if p.randSeed != nil {
+		trackerOpts = append(trackerOpts, status.WithRand(rand.NewPCG(*p.randSeed, 0)))
+	}
+	if o.paused {
+		trackerOpts = append(trackerOpts, status.WithStartPaused())
+	}
+	if o.uploaded > 0 {
+		trackerOpts = append(trackerOpts, status.WithUploaded(o.uploaded))
+	}
+	if !o.added.IsZero() {
+		trackerOpts = append(trackerOpts, status.WithAdded(o.added))
+	}
+	if o.moveTo != "" {
+		trackerOpts = append(trackerOpts, status.WithMoveOnComplete(o.moveTo))
+	}
+	if p.readBack {
+		trackerOpts = append(trackerOpts, status.WithReadBackVerification())
+	}
+	if p.compactPieceStates {
+		trackerOpts = append(trackerOpts, status.WithCompactPieceStates())
+	}
+	if p.newStorage != nil {
+		trackerOpts = append(trackerOpts, status.WithStorage(p.newStorage(t)))
+	}
+	if o.peerList != "" {
+		// with write back enabled the file is created once peers are discovered.
+		if _, err := os.Stat(o.peerList); err != nil && !o.peerListWriteBack {
+			return "", fmt.Errorf("invalid peer list file: %w", err)
+		}
+		trackerOpts = append(trackerOpts, status.WithPeerList(o.peerList, o.peerListWriteBack))
+	}
+	if p.onComplete != nil {
+		trackerOpts = append(trackerOpts, status.WithOnComplete(func(e TorrentCompleted) { p.onComplete(h, e) }))
+	}
+	if p.dht != nil && !private(t) {
+		trackerOpts = append(trackerOpts, status.WithDHTNodeHandler(p.dht.AddNode))
+	}
+
+	tr, err := status.NewTorrentSession(p.id, p.logger, t, o.dir, trackerOpts...)
 	if err != nil {
 		return "", err
 	}
 
 	p.torrentsDownloading.Store(h, tr)
+	p.trackRestore(h, t, &o)
+	defer p.saveRestore()
 
-	p.handler <- h
+	if tr.Paused() {
+		p.logger.Info("torrent added paused, waiting for resume", slog.String("infoHash", h))
+		return h, nil
+	}
+
+	if err := p.startDownload(h, tr); err != nil {
+		return "", err
+	}
 	return h, nil
 }
 
+// checkMetainfo validates the torrent, refusing it if a violation makes it
+// unsafe to download, such as a path escaping the download directory. The
+// cosmetic violations are only logged.
+func (p *Client) checkMetainfo(id string, t *torrent.MetaInfoFile) error {
+	var unsafe []error
+	for _, err := range t.Validate() {
+		var v *torrent.ValidationError
+		if errors.As(err, &v) && v.Severity == torrent.Security {
+			unsafe = append(unsafe, err)
+			continue
+		}
+		p.logger.Warn("torrent violates the specification",
+			slog.String("infoHash", hex.EncodeToString([]byte(id))),
+			slog.Any("err", err),
+		)
+	}
+	if len(unsafe) > 0 {
+		return fmt.Errorf("refusing unsafe torrent %x: %w", id, errors.Join(unsafe...))
+	}
+	return nil
+}
+
+var (
+	// ErrTorrentNotFound is returned for ids of torrents the client does not track.
+	ErrTorrentNotFound = errors.New("torrent not found")
+	// ErrAlreadyTracked is returned by WorkOn if the torrent is already tracked.
+	ErrAlreadyTracked = errors.New("torrent is already tracked")
+	// ErrClosed is returned by WorkOn and the waits on torrents once the
+	// client is shutting down.
+	ErrClosed = errors.New("client shutting down")
+	// ErrNotPaused is returned by Resume if the torrent is not paused.
+	ErrNotPaused = status.ErrNotPaused
+	// ErrPaused is returned by Pause if the torrent is already paused.
+	ErrPaused = status.ErrPaused
+	// ErrNotFailed is returned by Retry if the torrent did not fail.
+	ErrNotFailed = status.ErrNotFailed
+	// ErrTrackerCredentials is returned by WorkOn for torrents with announce
+	// URLs embedding credentials, if WithRejectTrackerCredentials is set.
+	ErrTrackerCredentials = errors.New("announce url embeds credentials")
+)
+
+func (p *Client) tracker(id string) (*status.TorrentSession, error) {
+	id = torrentKey(id)
+	s, ok := p.torrentsDownloading.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("torrent with id %x: %w", id, ErrTorrentNotFound)
+	}
+	return s.(*status.TorrentSession), nil
+}
+
+// Pause stops downloading the torrent with the given id and announces
+// the stopped event to its tracker. The torrent stays paused across
+// restarts of the client until Resume is called.
+func (p *Client) Pause(id string) error {
+	return p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		p.l.Lock()
+		defer p.l.Unlock()
+
+		if tr.Paused() {
+			return fmt.Errorf("failed to pause torrent with id %x: %w", id, ErrPaused)
+		}
+
+		p.stopDownload(id)
+		if err := tr.Pause(); err != nil {
+			if errors.Is(err, status.ErrPaused) {
+				return fmt.Errorf("failed to pause torrent with id %x: %w", id, err)
+			}
+			// the torrent was paused, only its state was not persisted.
+			p.logger.Error("failed to persist paused torrent", slog.String("infoHash", id), slog.Any("err", err))
+		}
+		p.saveRestore()
+		return nil
+	})
+}
+
+// Resume starts a paused torrent, that was either paused by Pause,
+// added by WithStartPaused or paused in a previous run of the client.
+func (p *Client) Resume(id string) error {
+	return p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		p.l.Lock()
+		defer p.l.Unlock()
+
+		err := tr.Resume()
+		if errors.Is(err, status.ErrNotPaused) {
+			return fmt.Errorf("failed to resume torrent with id %x: %w", id, err)
+		}
+		if err != nil {
+			// the torrent was resumed, only its state was not persisted.
+			p.logger.Error("failed to persist resumed torrent", slog.String("infoHash", id), slog.Any("err", err))
+		}
+		defer p.saveRestore()
+		return p.startDownload(id, tr)
+	})
+}
+
+// Retry starts the torrent with the given id again after it failed, e.g.
+// once space was freed on the full disk it failed on. The torrent is
+// announced again, and WaitFor has to be called again to wait for it.
+func (p *Client) Retry(id string) error {
+	return p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		p.l.Lock()
+		defer p.l.Unlock()
+
+		if tr.Err() == nil {
+			return fmt.Errorf("failed to retry torrent with id %x: %w", id, ErrNotFailed)
+		}
+		// the announce loop stopped once the torrent failed.
+		p.stopDownload(id)
+		if err := tr.Retry(); err != nil {
+			return fmt.Errorf("failed to retry torrent with id %x: %w", id, err)
+		}
+		return p.startDownload(id, tr)
+	})
+}
+
+// Paused reports whether the torrent with the given id is paused.
+func (p *Client) Paused(id string) (bool, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return false, err
+	}
+	return tr.Paused(), nil
+}
+
+// Remove stops the torrent with the given id, announces the stopped event
+// to its tracker and no longer tracks it. Its resume data is kept so that
+// adding the torrent again continues where it left off, unless deleteData
+// is set, in which case the download directory of the torrent is deleted.
+func (p *Client) Remove(id string, deleteData bool) error {
+	return p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		p.l.Lock()
+		p.stopDownload(id)
+		p.torrentsDownloading.Delete(id)
+		p.l.Unlock()
+		p.untrackRestore(id)
+		p.saveRestore()
+
+		var errAll error
+		if err := tr.Close(); err != nil {
+			errAll = errors.Join(errAll, fmt.Errorf("failed to stop torrent: %w", err))
+		}
+		p.retireMetrics(tr)
+		if deleteData {
+			if err := os.RemoveAll(tr.DownloadDir()); err != nil {
+				errAll = errors.Join(errAll, fmt.Errorf("failed to delete downloaded data: %w", err))
+			}
+		}
+		if errAll != nil {
+			return fmt.Errorf("failed to remove torrent with id %x: %w", id, errAll)
+		}
+		p.logger.Info("removed torrent", slog.String("infoHash", id), slog.Bool("deleteData", deleteData))
+		return nil
+	})
+}
+
+// download is the announce loop of a started torrent, run by watch.
+type download struct {
+	id     string
+	tr     *status.TorrentSession
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopped is closed once the torrent stopped
+	// downloading and seeding.
+	stopped chan struct{}
+	// announce asks the announce loop to announce the torrent, see Reannounce.
+	announce chan struct{}
+}
+
+// startDownload hands the torrent over to watch, which announces it to
+// its tracker and connects to its peers until stopDownload is called or
+// the client is closed.
+func (p *Client) startDownload(id string, tr *status.TorrentSession) error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	d := &download{
+		id:       id,
+		tr:       tr,
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		announce: make(chan struct{}, 1),
+	}
+	p.downloads.Store(id, d)
+
+	select {
+	case <-p.done:
+		cancel()
+		close(d.stopped)
+		return ErrClosed
+	case p.handler <- d:
+		return nil
+	}
+}
+
+// stopDownload stops the announce loop of the torrent and waits
+// until it announced the stopped event.
+func (p *Client) stopDownload(id string) {
+	d, ok := p.downloads.LoadAndDelete(id)
+	if !ok {
+		return
+	}
+	d.(*download).cancel()
+	<-d.(*download).stopped
+}
+
 func (p *Client) WaitFor(id string) <-chan error {
 	r := make(chan error, 1)
 	p.wg.Add(1)
@@ -134,142 +815,39 @@ func (p *Client) WaitFor(id string) <-chan error {
 			return
 		}
 
-		tr := s.(*status.Tracker)
-		for {
-			select {
-			case <-p.done:
-				r <- errors.New("client shutting down")
-				return
-			case <-tr.WaitUntilDownloaded():
-				switch {
-				case tr.Torrent.InfoSingleFile != nil:
-					final, err := os.Create(filepath.Join(tr.DownloadDir, tr.Torrent.InfoSingleFile.Name))
-					if err != nil {
-						r <- fmt.Errorf("failed to create torrent file for merging pieces: %w", err)
-						break
-					}
-
-					pcs := tr.BitField.ExistingPieces()
-
-					copied := int64(0)
-					var errAll error
-					for _, i := range pcs {
-						path := filepath.Join(tr.DownloadDir, fmt.Sprintf("%v.bin", i))
-						f, err := os.Open(path)
-						if err != nil {
-							errAll = errors.Join(errAll, fmt.Errorf("failed to open file for piece %v", i))
-							continue
-						}
-						defer f.Close()
-
-						w, err := io.Copy(final, f)
-						if err != nil {
-							errAll = errors.Join(errAll, fmt.Errorf("failed to copy piece %v to final merging file: %w", i, err))
-							continue
-						}
-
-						copied += w
-					}
-
-					if err := final.Close(); err != nil {
-						errAll = errors.Join(errAll, err)
-					}
-
-					if copied != tr.Torrent.BytesToDownload() {
-						errAll = errors.Join(errAll, fmt.Errorf("failed to reconstruct torrent from downloaded pieces %d out of %d reconstructed", copied, tr.Torrent.BytesToDownload()))
-					}
-
-					if errAll != nil {
-						r <- fmt.Errorf("failed to reconstruct downloaded torrent: %w", errAll)
-						break
-					}
-				case tr.Torrent.InfoMultiFile != nil:
-					parent := filepath.Join(tr.DownloadDir, tr.Torrent.InfoMultiFile.Name)
-					// create parent dir.
-					if _, err := os.Stat(parent); errors.Is(err, os.ErrNotExist) {
-						if err := os.Mkdir(parent, os.ModePerm); err != nil {
-							r <- fmt.Errorf("failed to create parent directory for assembling multi file torrent")
-							break
-						}
-					}
-
-					// create torrent dir structure.
-					var errAll error
-					var files []io.WriteCloser
-					for _, fi := range tr.Torrent.InfoMultiFile.Files {
-						dir, filename := filepath.Split(fi.Path)
-						if dir != "" {
-							if err := os.MkdirAll(filepath.Join(parent, dir), os.ModePerm); err != nil {
-								errAll = errors.Join(errAll, fmt.Errorf("failed to create parent dir for path %s: %w", fi.Path, err))
-								continue
-							}
-						}
-
-						file, err := os.Create(filepath.Join(parent, dir, filename))
-						if err != nil {
-							errAll = errors.Join(errAll, fmt.Errorf("failed to create torrent file for merging pieces: %w", err))
-							continue
-						}
-						defer file.Close()
-
-						files = append(files, file)
-					}
-
-					if errAll != nil {
-						r <- fmt.Errorf("failed to reconstruct multi-file torrent: %w", errAll)
-						break
-					}
-
-					// prepare handles to pieces.
-					pcs := tr.BitField.ExistingPieces()
-					pieces := make([]io.ReadCloser, 0, len(pcs))
-
-					for _, i := range pcs {
-						path := filepath.Join(tr.DownloadDir, fmt.Sprintf("%v.bin", i))
-						f, err := os.Open(path)
-						if err != nil {
-							errAll = errors.Join(errAll, fmt.Errorf("failed to open file for piece %v", i))
-							continue
-						}
-						defer f.Close()
-						pieces = append(pieces, f)
-
-					}
-
-					if errAll != nil {
-						r <- fmt.Errorf("failed to reconstruct multi-file torrent: %w", errAll)
-						break
-					}
-
-					tc, cp, l := int64(0), 0, tr.Torrent.PieceLength
-					for i, fi := range tr.Torrent.InfoMultiFile.Files {
-						for c := int64(0); c < fi.Length; {
-							w, err := io.CopyN(files[i], pieces[cp], min(l, fi.Length-c))
-							if err != nil {
-								errAll = errors.Join(errAll, fmt.Errorf("failed to copy piece %v to final merging file %s: %w", i, fi.Path, err))
-								continue
-							}
-							l -= w
-							if l == 0 {
-								cp++ // move to next piece.
-								l = tr.Torrent.PieceLength
-							}
-							c += w
-						}
-						tc += fi.Length
-					}
-
-					if tc != tr.Torrent.BytesToDownload() {
-						errAll = errors.Join(errAll, fmt.Errorf("failed to reconstruct torrent from downloaded pieces %d out of %d reconstructed", tc, tr.Torrent.BytesToDownload()))
-					}
-
-					if errAll != nil {
-						r <- fmt.Errorf("failed to reconstruct multi-file torrent: %w", errAll)
-						break
-					}
-				}
-				return
-			}
+		// the files are assembled by the session before it completes.
+		tr := s.(*status.TorrentSession)
+		select {
+		case <-p.done:
+			r <- ErrClosed
+		case <-tr.Failed():
+			r <- fmt.Errorf("torrent with id %s failed: %w", id, tr.Err())
+		case <-tr.WaitUntilDownloaded():
+		}
+	}()
+	return r
+}
+
+// WaitForSeeding returns a channel that is closed once the torrent
+// with the given id was downloaded and reached its seeding goals.
+// If no goals were configured the torrent seeds until the client
+// is closed.
+func (p *Client) WaitForSeeding(id string) <-chan error {
+	r := make(chan error, 1)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(r)
+		s, ok := p.torrentsDownloading.Load(id)
+		if !ok {
+			r <- fmt.Errorf("torrent with id %s was not found, its possible that it was tracked but was deleted midway", id)
+			return
+		}
+
+		select {
+		case <-p.done:
+			r <- ErrClosed
+		case <-s.(*status.TorrentSession).WaitUntilSeeded():
 		}
 	}()
 	return r
@@ -277,42 +855,81 @@ func (p *Client) WaitFor(id string) <-chan error {
 
 func (p *Client) acceptLeechers() {
 	defer p.wg.Done()
+
+	var delay time.Duration
 	for {
 		conn, err := p.seedServer.Accept()
 		if err != nil {
-			p.logger.Error("failed to accept new incoming connections", slog.Any("err", err))
 			if errors.Is(err, net.ErrClosed) {
-				break
+				return
+			}
+			// back off instead of spinning, e.g. while no file descriptors are left.
+			if delay == 0 {
+				p.logger.Error("failed to accept new incoming connections, backing off", slog.Any("err", err))
+				delay = acceptRetryMin
+			} else {
+				delay = min(2*delay, acceptRetryMax)
+			}
+			select {
+			case <-p.done:
+				return
+			case <-time.After(delay):
 			}
+			continue
+		}
+		delay = 0
+
+		if p.isBlockedConn(conn) {
+			p.logger.Debug("peer is on the blocklist, rejecting it", slog.String("addr", conn.RemoteAddr().String()))
+			conn.Close()
+			continue
+		}
+		if !p.conns.TryAcquire() {
+			p.logger.Debug("connection limit reached, rejecting peer", slog.String("addr", conn.RemoteAddr().String()))
+			conn.Close()
+			continue
 		}
 
 		p.wg.Add(1)
-		go p.handlePeer(conn)
+		go p.handlePeer(&limitedConn{Conn: conn, release: p.conns.Release})
 	}
 }
 
 func (p *Client) watch() {
 	defer p.wg.Done()
-	ctx, cancel := context.WithCancel(context.Background())
 
 	for {
 		select {
-		case infoHash := <-p.handler:
-			t, _ := p.torrentsDownloading.Load(infoHash)
+		case d := <-p.handler:
 			p.wg.Add(1)
-			go p.downloadTorrent(ctx, infoHash, t.(*status.Tracker))
+			go func() {
+				defer close(d.stopped)
+				defer d.cancel()
+				p.downloadTorrent(d.ctx, d.id, d.tr, d.announce)
+			}()
+			switch {
+			case p.dht != nil && !private(d.tr.Torrent()):
+				p.wg.Add(1)
+				go p.dhtLookups(d.ctx, d.id, d.tr)
+			case len(d.tr.Torrent().Nodes) > 0:
+				p.logger.Warn("torrent lists dht nodes to find its peers, but the dht is not enabled",
+					slog.String("infoHash", hex.EncodeToString([]byte(d.id))),
+				)
+			}
 		case <-p.done:
 			p.logger.Info("received signal to stop, issueing cancel to all torrents")
-			cancel()
+			p.cancel()
 			return
 		}
 	}
 }
 
-func (c *Client) downloadTorrent(ctx context.Context, infoHash string, t *status.Tracker) {
-	logger := c.logger.With(slog.String("url", t.Torrent.Announce), slog.String("infoHash", infoHash))
-	const defaultPeerCount = 15
-
+func (c *Client) downloadTorrent(ctx context.Context, infoHash string, t *status.TorrentSession, announce <-chan struct{}) {
+	logger := c.logger.With(slog.String("url", tracker.RedactURL(t.AnnounceURL())), slog.String("infoHash", infoHash))
+	if t.AnnounceURL() == "" {
+		c.withoutTracker(ctx, logger, t)
+		return
+	}
 	var start *tracker.Response
 
 tracker:
@@ -324,34 +941,48 @@ tracker:
 		default:
 			logger.Debug("initiating communication with tracker")
 
+			// the later announces report the transfers since this one.
+			counters := t.StartAnnounce()
 			var err error
-			start, err = tracker.CreateRequest(ctx, t.Torrent.Announce, &tracker.RequestParams{
+			start, err = c.trackers.CreateRequest(ctx, t.AnnounceURL(), &tracker.RequestParams{
 				InfoHash:   infoHash,
 				PeerID:     c.id,
-				Port:       int64(c.port),
-				Uploaded:   0,
-				Downloaded: 0,
-				Left:       t.Torrent.BytesToDownload(),
+				Port:       c.announcePort(t),
+				Uploaded:   counters.Uploaded,
+				Downloaded: counters.Downloaded,
+				Left:       counters.Left,
+				Corrupt:    tracker.Optional(counters.Corrupt),
 				Compact:    tracker.Optional[int64](1),
 				Event:      tracker.Optional(tracker.EventStarted),
-				NumWant:    tracker.Optional[int64](defaultPeerCount),
+				NumWant:    numWant(t),
+				Key:        tracker.Optional(c.key),
 			})
 			if err != nil {
+				t.RecordAnnounce(nil, err, c.clock.Now().Add(10*time.Second))
 				logger.Error("failed to contact tracker", slog.Any("err", err))
-				time.Sleep(10 * time.Second)
+				retry := c.clock.NewTimer(10 * time.Second)
+				select {
+				case <-ctx.Done():
+				case <-retry.C():
+				}
+				retry.Stop()
 				continue
 			}
 			break tracker
 		}
 	}
 
-	if start.Interval == nil {
+	state := announceState{last: c.clock.Now()}
+	if !state.update(start) {
+		t.RecordAnnounce(start, errors.New("tracker did not return an announce interval"), time.Time{})
 		logger.Error("tracker did not returned announce interval, aborting.")
 		c.wg.Done()
 		return
 	}
+	t.RecordAnnounce(start, nil, c.clock.Now().Add(state.interval))
+	logPeersReturned(logger, t, start)
 
-	logger.Info("received valid interval at which updates will be published to the tracker", slog.String("interval", fmt.Sprint(*start.Interval)))
+	logger.Info("received valid interval at which updates will be published to the tracker", slog.String("interval", state.interval.String()))
 
 	if err := t.UpdateSeeders(start); err != nil {
 		logger.Error("failed to update peers, attempting to continue", slog.Any("err", err))
@@ -359,80 +990,219 @@ tracker:
 
 	logger.Debug("entering update loop")
 
-	ticker := time.NewTicker(time.Duration(*start.Interval) * time.Second)
+	downloaded := t.WaitUntilDownloaded()
+	downloading := true
+
+	ticker := c.clock.NewTicker(state.interval)
+	defer ticker.Stop()
+
+	// applies a successful response to the state.
+	update := func(resp *tracker.Response) {
+		state.last = c.clock.Now()
+		logPeersReturned(logger, t, resp)
+		if state.update(resp) {
+			logger.Info("tracker changed the announce interval", slog.String("interval", state.interval.String()))
+			ticker.Reset(state.interval)
+		}
+	}
+	// sends an update before the interval elapsed, asking for more peers.
+	announceEarly := func() {
+		counters := t.AnnounceCounters()
+		resp, err := c.trackers.CreateRequest(ctx, t.AnnounceURL(), &tracker.RequestParams{
+			InfoHash:   infoHash,
+			PeerID:     c.id,
+			Port:       c.announcePort(t),
+			Uploaded:   counters.Uploaded,
+			Downloaded: counters.Downloaded,
+			Left:       counters.Left,
+			Corrupt:    tracker.Optional(counters.Corrupt),
+			Compact:    tracker.Optional[int64](1),
+			NumWant:    numWant(t),
+			Key:        tracker.Optional(c.key),
+			TrackerID:  state.trackerID,
+		})
+		if err != nil {
+			t.RecordAnnounce(resp, err, c.clock.Now().Add(state.interval))
+			logger.Error("failed early update to tracker", slog.Any("err", err))
+			return
+		}
+		update(resp)
+		// the early announce replaces the next regular one.
+		ticker.Reset(state.interval)
+		t.RecordAnnounce(resp, nil, c.clock.Now().Add(state.interval))
+		if err := t.UpdateSeeders(resp); err != nil {
+			logger.Error("failed to update peers, attempting to continue", slog.Any("err", err))
+		}
+	}
+	// requested fires once an announce requested
+	// before the min interval elapsed can be sent.
+	var requested <-chan time.Time
+	var delayed Timer
+	defer func() {
+		if delayed != nil {
+			delayed.Stop()
+		}
+	}()
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("sending stop event on torrent")
-			_, err := tracker.CreateRequest(context.Background(), t.Torrent.Announce, &tracker.RequestParams{
-				InfoHash:   infoHash,
-				PeerID:     c.id,
-				Port:       int64(c.port),
-				Uploaded:   t.Uploaded.Load(),
-				Downloaded: t.Downloaded.Load(),
-				Left:       t.Torrent.BytesToDownload() - t.Downloaded.Load(),
-				Compact:    tracker.Optional[int64](1),
-				Event:      tracker.Optional(tracker.EventStopped),
-				TrackerID:  start.TrackerID,
-			})
-			if err != nil {
-				logger.Error("failed announce stop to tracker", slog.Any("err", err))
-			}
+			c.announceStopped(logger, t, infoHash, state.trackerID)
 
-			t.CancelDownload()
+			if downloading {
+				t.CancelDownload()
+			}
 			c.wg.Done()
 
 			logger.Info("stopping download, context canceled")
 			return
-		case <-t.WaitUntilDownloaded():
-			logger.Info("sending completed update, finished downloaded torrent")
-			_, err := tracker.CreateRequest(context.Background(), t.Torrent.Announce, &tracker.RequestParams{
-				InfoHash:   infoHash,
-				PeerID:     c.id,
-				Port:       int64(c.port),
-				Uploaded:   t.Uploaded.Load(),
-				Downloaded: t.Downloaded.Load(),
-				Left:       t.Torrent.BytesToDownload() - t.Downloaded.Load(),
-				Compact:    tracker.Optional[int64](1),
-				Event:      tracker.Optional(tracker.EventCompleted),
-				TrackerID:  start.TrackerID,
-			})
-			if err != nil {
-				logger.Error("failed announce completed event to tracker", slog.Any("err", err))
-			}
+		case <-t.Failed():
+			logger.Error("torrent failed, sending stop event on torrent", slog.Any("err", t.Err()))
+			c.announceStopped(logger, t, infoHash, state.trackerID)
 			t.CancelDownload()
 			c.wg.Done()
-			logger.Info("download completed")
 			return
-		case <-ticker.C:
+		case <-t.WaitUntilSeeded():
+			logger.Info("seeding goals reached, sending stop event on torrent")
+			c.announceStopped(logger, t, infoHash, state.trackerID)
+			t.CancelUpload()
+			c.wg.Done()
+			return
+		case <-downloaded:
+			downloaded = nil // the completion is handled only once.
+			downloading = false
+			t.CancelDownload()
+
+			if t.ShouldAnnounceCompleted() {
+				logger.Info("sending completed update, finished downloaded torrent")
+				counters := t.AnnounceCounters()
+				resp, err := c.trackers.CreateRequest(context.Background(), t.AnnounceURL(), &tracker.RequestParams{
+					InfoHash:   infoHash,
+					PeerID:     c.id,
+					Port:       c.announcePort(t),
+					Uploaded:   counters.Uploaded,
+					Downloaded: counters.Downloaded,
+					Left:       0,
+					Corrupt:    tracker.Optional(counters.Corrupt),
+					Compact:    tracker.Optional[int64](1),
+					Event:      tracker.Optional(tracker.EventCompleted),
+					NumWant:    numWant(t),
+					Key:        tracker.Optional(c.key),
+					TrackerID:  state.trackerID,
+				})
+				if err == nil {
+					update(resp)
+				}
+				t.RecordAnnounce(resp, err, c.clock.Now().Add(state.interval))
+				if err != nil {
+					logger.Error("failed announce completed event to tracker", slog.Any("err", err))
+				} else if err := t.MarkCompletedAnnounced(); err != nil {
+					logger.Error("failed to persist completed announce", slog.Any("err", err))
+				}
+			} else {
+				logger.Info("torrent was already complete, not announcing completed event")
+			}
+
+			if c.action == Leech {
+				logger.Info("torrent downloaded, not seeding as client only leeches, sending stop event")
+				c.announceStopped(logger, t, infoHash, state.trackerID)
+				c.wg.Done()
+				return
+			}
+			logger.Info("torrent downloaded, continuing in seeding mode")
+		case <-t.Reannounce():
+			now := c.clock.Now()
+			if !state.allowEarly(now) {
+				logger.Debug("running out of peers, but too early to announce again")
+				continue
+			}
+			state.early = now
+			t.RecordEarlyAnnounce()
+
+			logger.Info("running out of peers, sending early update")
+			announceEarly()
+		case <-announce:
+			if wait := state.minInterval - c.clock.Now().Sub(state.last); wait > 0 {
+				logger.Info("announce requested before the min interval elapsed, delaying it", slog.String("wait", wait.String()))
+				if delayed != nil {
+					delayed.Stop()
+				}
+				delayed = c.clock.NewTimer(wait)
+				requested = delayed.C()
+				continue
+			}
+			logger.Info("announce requested, sending early update")
+			announceEarly()
+		case <-requested:
+			requested = nil
+			logger.Info("sending requested early update")
+			announceEarly()
+		case <-ticker.C():
 			logger.Info("sending regular update based on interval")
 			var event *tracker.Event
-			if completed := (t.Torrent.BytesToDownload() - t.Downloaded.Load()) == 0; completed {
+			if t.ShouldAnnounceCompleted() { // previous attempt to announce completion failed.
 				event = tracker.Optional(tracker.EventCompleted)
 			}
-			update, err := tracker.CreateRequest(context.Background(), t.Torrent.Announce, &tracker.RequestParams{
+			counters := t.AnnounceCounters()
+			resp, err := c.trackers.CreateRequest(context.Background(), t.AnnounceURL(), &tracker.RequestParams{
 				InfoHash:   infoHash,
 				PeerID:     c.id,
-				Port:       int64(c.port),
-				Uploaded:   t.Uploaded.Load(),
-				Downloaded: t.Downloaded.Load(),
-				Left:       t.Torrent.BytesToDownload() - t.Downloaded.Load(),
+				Port:       c.announcePort(t),
+				Uploaded:   counters.Uploaded,
+				Downloaded: counters.Downloaded,
+				Left:       counters.Left,
+				Corrupt:    tracker.Optional(counters.Corrupt),
 				Compact:    tracker.Optional[int64](1),
 				Event:      event,
-				TrackerID:  start.TrackerID,
+				NumWant:    numWant(t),
+				Key:        tracker.Optional(c.key),
+				TrackerID:  state.trackerID,
 			})
+			if err == nil {
+				update(resp)
+			}
+			t.RecordAnnounce(resp, err, c.clock.Now().Add(state.interval))
 			if err != nil {
 				logger.Error("failed announce regular update to tracker", slog.Any("err", err))
+				continue
 			}
 			if event != nil {
-				logger.Info("completed downloading torrent file")
-				t.CancelDownload()
-				c.wg.Done()
-				return
+				if err := t.MarkCompletedAnnounced(); err != nil {
+					logger.Error("failed to persist completed announce", slog.Any("err", err))
+				}
 			}
-			if err := t.UpdateSeeders(update); err != nil {
+			if err := t.UpdateSeeders(resp); err != nil {
 				logger.Error("failed to update peers, attempting to continue", slog.Any("err", err))
 			}
 		}
 	}
 }
+
+// stoppedTimeout bounds the announce of the stopped event,
+// so that an unresponsive tracker does not block the shutdown.
+const stoppedTimeout = 10 * time.Second
+
+func (c *Client) announceStopped(logger *slog.Logger, t *status.TorrentSession, infoHash string, trackerID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stoppedTimeout)
+	defer cancel()
+
+	counters := t.AnnounceCounters()
+	resp, err := c.trackers.CreateRequest(ctx, t.AnnounceURL(), &tracker.RequestParams{
+		InfoHash:   infoHash,
+		PeerID:     c.id,
+		Port:       c.announcePort(t),
+		Uploaded:   counters.Uploaded,
+		Downloaded: counters.Downloaded,
+		Left:       counters.Left,
+		Corrupt:    tracker.Optional(counters.Corrupt),
+		Compact:    tracker.Optional[int64](1),
+		Event:      tracker.Optional(tracker.EventStopped),
+		Key:        tracker.Optional(c.key),
+		TrackerID:  trackerID,
+	})
+	// no further announces are scheduled.
+	t.RecordAnnounce(resp, err, time.Time{})
+	if err != nil {
+		logger.Error("failed announce stop to tracker", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,352 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_ReleasesOnError(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer occupied.Close()
+
+	before := runtime.NumGoroutine()
+	_, err = New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithPort(0), WithControlAPI(occupied.Addr().String()))
+	assert.ErrorContains(t, err, "failed to listen for the control API")
+
+	// the disk scheduler and the leecher listener are stopped, polled
+	// without assert.Eventually as it checks in another goroutine.
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "goroutines of the failed client are left")
+}
+
+func TestNew_DownloadDir(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	dir := filepath.Join(t.TempDir(), "nested", "downloads")
+	c, err := New(WithLogger(logger), WithDownloadDir(dir))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Close(context.Background()))
+
+	_, err = os.Stat(filepath.Join(dir, keyFile))
+	assert.NoError(t, err, "download directory is created by New")
+
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, err = New(WithLogger(logger), WithDownloadDir(filepath.Join(file, "downloads")))
+	assert.ErrorContains(t, err, "failed to create download directory")
+}
+
+// newTestTorrent returns a single piece torrent announced to announce.
+func newTestTorrent(announce string) *torrent.MetaInfoFile {
+	data := make([]byte, 16*1024)
+	hash := sha1.Sum(data)
+	return &torrent.MetaInfoFile{
+		Info: torrent.Info{
+			InfoSingleFile: &torrent.InfoSingleFile{Name: "test.bin", Length: int64(len(data))},
+			PieceLength:    int64(len(data)),
+			Pieces:         hex.EncodeToString(hash[:]),
+		},
+		Announce: announce,
+	}
+}
+
+func TestClient_StartPaused(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var dials atomic.Int64
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	// the tracker returns the listener as the only peer.
+	addr := l.Addr().(*net.TCPAddr)
+	peer := binary.BigEndian.AppendUint16([]byte(addr.IP.To4()), uint16(addr.Port))
+	var announces atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		fmt.Fprintf(rw, "d8:intervali60e5:peers%d:%se", len(peer), peer)
+	}))
+	t.Cleanup(srv.Close)
+
+	mi := newTestTorrent(srv.URL + "/announce")
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	id, err := c.WorkOnWithOptions(mi, WithStartPaused())
+	assert.NoError(t, err)
+	paused, err := c.Paused(id)
+	assert.NoError(t, err)
+	assert.True(t, paused)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, announces.Load(), "paused torrent was announced")
+	assert.Zero(t, dials.Load(), "paused torrent dialed a peer")
+
+	assert.NoError(t, c.Resume(id))
+	assert.ErrorIs(t, c.Resume(id), ErrNotPaused)
+	assert.Eventually(t, func() bool {
+		return announces.Load() > 0 && dials.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	paused, err = c.Paused(id)
+	assert.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestClient_Close(t *testing.T) {
+	tests := []struct {
+		name string
+		// hang blocks the stopped announce until the test ends.
+		hang    bool
+		wantErr bool
+	}{
+		{name: "stopped announced"},
+		{name: "deadline exceeded", hang: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			var started, stopped atomic.Int64
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("event") {
+				case "started":
+					started.Add(1)
+				case "stopped":
+					if tt.hang {
+						select {
+						case <-release:
+						case <-r.Context().Done():
+						}
+						return
+					}
+					stopped.Add(1)
+				}
+				fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+			}))
+			t.Cleanup(srv.Close)
+			t.Cleanup(func() { close(release) })
+
+			dir := t.TempDir()
+			c, err := New(WithLogger(logger), WithDownloadDir(dir))
+			assert.NoError(t, err)
+
+			mi := newTestTorrent(srv.URL + "/announce")
+			id, err := c.WorkOn(mi)
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				st, err := c.TrackerStatus(id)
+				return err == nil && !st.LastAnnounce.IsZero()
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, int64(1), started.Load())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err = c.Close(ctx)
+
+			// the disk scheduler is stopped, whether the shutdown was clean or not.
+			disk := c.disk.Wrap(storage.NewMemory())
+			assert.Eventually(t, func() bool {
+				return errors.Is(disk.WritePiece(0, []byte{1}), storage.ErrSchedulerClosed)
+			}, 5*time.Second, 10*time.Millisecond)
+			_, errAdd := c.WorkOn(newTestTorrent(srv.URL + "/other"))
+			assert.ErrorIs(t, errAdd, ErrClosed)
+
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), stopped.Load())
+				_, err = os.Stat(filepath.Join(dir, hex.EncodeToString([]byte(id)), "resume.json"))
+				assert.NoError(t, err, "resume data is persisted")
+				assert.NoError(t, c.Close(context.Background()), "closing twice is safe")
+				return
+			}
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.ErrorContains(t, err, hex.EncodeToString([]byte(id)))
+			assert.Equal(t, err, c.Close(context.Background()), "closing twice is safe")
+		})
+	}
+}
+
+func TestClient_SkipsUnsupportedTrackers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var started atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("event") == "started" {
+			started.Add(1)
+		}
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	// only the last tracker of the tier is supported.
+	mi := newTestTorrent("wss://tracker.example/announce")
+	mi.AnnounceTiers = [][]string{{"wss://tracker.example/announce", "ws://tracker.example/announce", srv.URL + "/announce"}}
+	id, err := c.WorkOn(mi)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		st, err := c.TrackerStatus(id)
+		return err == nil && !st.LastAnnounce.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), started.Load())
+
+	st, err := c.TrackerStatus(id)
+	assert.NoError(t, err)
+	assert.Empty(t, st.Error)
+	assert.Equal(t, []string{"wss://tracker.example/announce", "ws://tracker.example/announce"}, st.Skipped)
+}
+
+func TestClient_EmptyFiles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	c, err := New(WithLogger(logger), WithDownloadDir(dir))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	f, err := os.Open("../../../torrent/test_data/empty.torrent")
+	assert.NoError(t, err)
+	defer f.Close()
+	mi, err := torrent.From(f)
+	assert.NoError(t, err)
+	mi.Announce = srv.URL + "/announce"
+
+	// a torrent without pieces completes right away.
+	id, err := c.WorkOn(mi)
+	assert.NoError(t, err)
+	select {
+	case err := <-c.WaitFor(id):
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent of empty files did not complete")
+	}
+
+	tr, err := c.tracker(id)
+	assert.NoError(t, err)
+	for _, fi := range mi.InfoMultiFile.Files {
+		st, err := os.Stat(filepath.Join(tr.DownloadDir(), "empty", fi.Path))
+		assert.NoError(t, err)
+		if err == nil {
+			assert.Zero(t, st.Size())
+		}
+	}
+}
+
+func TestClient_RefusesUnsafeTorrent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	c, err := New(WithLogger(logger), WithDownloadDir(dir))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	// the only file would be assembled at /etc/passwd, relative to the torrent directory.
+	data := make([]byte, 16)
+	hash := sha1.Sum(data)
+	mi, err := torrent.From(bytes.NewReader(fmt.Appendf(nil,
+		"d8:announce25:http://localhost/announce4:infod5:filesld6:lengthi16e4:pathl2:..2:..2:..2:..3:etc6:passwdeee"+
+			"4:name3:dir12:piece lengthi16e6:pieces20:%see", hash[:])))
+	assert.NoError(t, err)
+
+	_, err = c.WorkOn(mi)
+	assert.ErrorIs(t, err, torrent.ErrPathTraversal)
+	assert.Empty(t, c.Statuses())
+}
+
+func TestClient_MaxActiveTorrents(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var announces atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithMaxActiveTorrents(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	add := func(data string, opts ...TorrentOption) string {
+		mi, err := torrent.From(bytes.NewReader(bencodeTorrent(srv.URL, []byte(data))))
+		assert.NoError(t, err)
+		id, err := c.WorkOnWithOptions(mi, opts...)
+		assert.NoError(t, err)
+		return id
+	}
+	state := func(id string) TorrentState {
+		s, err := c.Status(id)
+		assert.NoError(t, err)
+		return s.State
+	}
+
+	first := add("first")
+	normal := add("normal")
+	high := add("high", TorrentWithPriority(PriorityHigh))
+	assert.Equal(t, StateDownloading, state(first))
+	assert.Equal(t, StateQueued, state(normal))
+	assert.Equal(t, StateQueued, state(high))
+
+	// queued torrents are announced nonetheless.
+	assert.Eventually(t, func() bool { return announces.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
+
+	// the torrent of higher priority takes the freed slot.
+	assert.NoError(t, c.Pause(first))
+	assert.Equal(t, StateDownloading, state(high))
+	assert.Equal(t, StateQueued, state(normal))
+
+	// resumed torrents queue up behind the others.
+	assert.NoError(t, c.Resume(first))
+	assert.Equal(t, StateQueued, state(first))
+
+	assert.NoError(t, c.Remove(high, false))
+	assert.Equal(t, StateDownloading, state(normal))
+	assert.Equal(t, StateQueued, state(first))
+}
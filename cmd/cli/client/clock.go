@@ -0,0 +1,13 @@
+package client
+
+import "github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+
+// The clock the client and its torrents tell the time with is
+// re-exported here so that it can be replaced, see WithClock.
+type (
+	Clock  = status.Clock
+	Timer  = status.Timer
+	Ticker = status.Ticker
+	// SystemClock is the Clock used unless WithClock is passed.
+	SystemClock = status.SystemClock
+)
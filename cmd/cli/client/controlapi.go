@@ -0,0 +1,511 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// maxTorrentFileSize bounds the size of the torrent files added by the control API.
+const maxTorrentFileSize = torrent.DefaultMaxSize
+
+// errMagnetUnsupported is returned for magnet links added by the control
+// API, as the metadata of a torrent cannot be fetched from its peers.
+var errMagnetUnsupported = errors.New("magnet links are not supported, upload the .torrent file instead")
+
+// badRequestError is an error caused by an invalid request to the control API.
+type badRequestError struct{ err error }
+
+func (e *badRequestError) Error() string { return e.err.Error() }
+func (e *badRequestError) Unwrap() error { return e.err }
+
+// controlAPI serves the JSON endpoints of the control API. The
+// handlers call the exported methods of the client, so that
+// the API behaves the same as the client used as a library.
+type controlAPI struct {
+	client *Client
+	logger *slog.Logger
+	token  string
+}
+
+func newControlAPI(c *Client, token string) http.Handler {
+	api := &controlAPI{client: c, logger: c.logger.With(slog.String("component", "control")), token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /torrents", api.list)
+	mux.HandleFunc("POST /torrents", api.add)
+	mux.HandleFunc("GET /torrents/{hash}", api.get)
+	mux.HandleFunc("DELETE /torrents/{hash}", api.remove)
+	mux.HandleFunc("PATCH /torrents/{hash}/limits", api.setLimits)
+	mux.HandleFunc("POST /torrents/{hash}/pause", api.pause)
+	mux.HandleFunc("POST /torrents/{hash}/resume", api.resume)
+	mux.HandleFunc("POST /torrents/{hash}/retry", api.retry)
+	mux.HandleFunc("POST /torrents/{hash}/recheck", api.recheck)
+	mux.HandleFunc("DELETE /torrents/{hash}/recheck", api.cancelRecheck)
+	mux.HandleFunc("POST /torrents/{hash}/reannounce", api.reannounce)
+	mux.HandleFunc("POST /torrents/{hash}/pieces/{index}/abandon", api.abandonPiece)
+	mux.HandleFunc("POST /torrents/{hash}/pieces/{index}/reclaim", api.reclaimPiece)
+	mux.HandleFunc("GET /torrents/{hash}/peers", api.peers)
+	mux.HandleFunc("GET /torrents/{hash}/availability", api.availability)
+	mux.HandleFunc("GET /torrents/{hash}/bans", api.bans)
+	mux.HandleFunc("GET /torrents/{hash}/slots", api.slots)
+	mux.HandleFunc("GET /session", api.exportSession)
+	mux.HandleFunc("POST /session", api.importSession)
+	if c.metrics {
+		mux.HandleFunc("GET /metrics", api.metrics)
+	}
+
+	if token == "" {
+		return mux
+	}
+	return api.authenticate(mux)
+}
+
+func (p *Client) serveControlAPI() {
+	defer p.wg.Done()
+	p.logger.Info("serving control API", slog.String("addr", p.controlLn.Addr().String()))
+	if err := p.control.Serve(p.controlLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		p.logger.Error("control API stopped", slog.Any("err", err))
+	}
+}
+
+// ControlAddr returns the address the control API listens on,
+// or an empty string if it is not enabled, see WithControlAPI.
+func (p *Client) ControlAddr() string {
+	if p.controlLn == nil {
+		return ""
+	}
+	return p.controlLn.Addr().String()
+}
+
+func (a *controlAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			a.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *controlAPI) list(w http.ResponseWriter, _ *http.Request) {
+	statuses := a.client.Statuses()
+	if statuses == nil {
+		statuses = []TorrentStatus{}
+	}
+	a.writeJSON(w, http.StatusOK, statuses)
+}
+
+func (a *controlAPI) get(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) add(w http.ResponseWriter, r *http.Request) {
+	var opts []TorrentOption
+	if paused, err := boolQuery(r, "paused"); err != nil {
+		a.writeError(w, err)
+		return
+	} else if paused {
+		opts = append(opts, WithStartPaused())
+	}
+
+	mi, err := readTorrent(w, r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	id, err := a.client.WorkOnWithOptions(mi, opts...)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusCreated, id)
+}
+
+func (a *controlAPI) remove(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	deleteData, err := boolQuery(r, "deleteData")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.Remove(id, deleteData); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *controlAPI) pause(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.Pause(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) resume(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.Resume(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) retry(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.Retry(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) recheck(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	// closing the request does not cancel the recheck, see cancelRecheck.
+	stats, err := a.client.Recheck(context.WithoutCancel(r.Context()), id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, stats)
+}
+
+func (a *controlAPI) cancelRecheck(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.CancelRecheck(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	// the recheck persists the pieces verified so far before it returns.
+	a.writeStatus(w, http.StatusAccepted, id)
+}
+
+func (a *controlAPI) reannounce(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if err := a.client.Reannounce(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusAccepted, id)
+}
+
+// limitsRequest changes the limits of a torrent, those that are
+// omitted are kept, see TorrentLimits.
+type limitsRequest struct {
+	MaxPeers        *int `json:"maxPeers"`
+	MaxActivePieces *int `json:"maxActivePieces"`
+	MaxPipeline     *int `json:"maxPipeline"`
+}
+
+func (a *controlAPI) setLimits(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	var req limitsRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		a.writeError(w, &badRequestError{fmt.Errorf("invalid limits: %w", err)})
+		return
+	}
+
+	// the limits are validated before any of them is changed.
+	setters := []struct {
+		n   *int
+		set func(id string, n int) error
+	}{
+		{req.MaxPeers, a.client.SetMaxPeers},
+		{req.MaxActivePieces, a.client.SetMaxActivePieces},
+		{req.MaxPipeline, a.client.SetMaxPipeline},
+	}
+	for _, s := range setters {
+		if s.n != nil && *s.n < 0 {
+			a.writeError(w, fmt.Errorf("invalid limit %d: %w", *s.n, ErrInvalidLimit))
+			return
+		}
+	}
+	for _, s := range setters {
+		if s.n == nil {
+			continue
+		}
+		if err := s.set(id, *s.n); err != nil {
+			a.writeError(w, err)
+			return
+		}
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) peers(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	peers, err := a.client.PeerStats(id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if peers == nil {
+		peers = []PeerStat{}
+	}
+	a.writeJSON(w, http.StatusOK, peers)
+}
+
+// availabilityResponse is the availability of the pieces of a torrent.
+type availabilityResponse struct {
+	DistributedCopies float64 `json:"distributedCopies"`
+	// Pieces is the number of connected peers having each piece.
+	Pieces []int `json:"pieces"`
+}
+
+func (a *controlAPI) availability(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	pieces, err := a.client.Availability(id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, availabilityResponse{
+		DistributedCopies: DistributedCopies(pieces),
+		Pieces:            pieces,
+	})
+}
+
+func (a *controlAPI) bans(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	bans, err := a.client.BannedPeers(id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if bans.Addrs == nil {
+		bans.Addrs = []string{}
+	}
+	if bans.PeerIDs == nil {
+		bans.PeerIDs = []string{}
+	}
+	a.writeJSON(w, http.StatusOK, bans)
+}
+
+func (a *controlAPI) slots(w http.ResponseWriter, r *http.Request) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	slots, err := a.client.Slots(id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if slots == nil {
+		slots = []SlotInfo{}
+	}
+	a.writeJSON(w, http.StatusOK, slots)
+}
+
+func (a *controlAPI) abandonPiece(w http.ResponseWriter, r *http.Request) {
+	a.pieceOp(w, r, a.client.AbandonPiece)
+}
+
+func (a *controlAPI) reclaimPiece(w http.ResponseWriter, r *http.Request) {
+	a.pieceOp(w, r, a.client.ReclaimPiece)
+}
+
+// pieceOp runs op on the piece in the path and responds with the status of its torrent.
+func (a *controlAPI) pieceOp(w http.ResponseWriter, r *http.Request, op func(id string, index int64) error) {
+	id, err := torrentID(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	index, err := strconv.ParseInt(r.PathValue("index"), 10, 64)
+	if err != nil {
+		a.writeError(w, &badRequestError{fmt.Errorf("invalid piece index %q", r.PathValue("index"))})
+		return
+	}
+	if err := op(id, index); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeStatus(w, http.StatusOK, id)
+}
+
+func (a *controlAPI) exportSession(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	// the archive is streamed, failures can only be logged.
+	if err := a.client.Export(w); err != nil {
+		a.logger.Error("failed to export session", slog.Any("err", err))
+	}
+}
+
+func (a *controlAPI) importSession(w http.ResponseWriter, r *http.Request) {
+	dataRoot := r.URL.Query().Get("dataRoot")
+	if dataRoot == "" {
+		a.writeError(w, &badRequestError{errors.New("missing dataRoot")})
+		return
+	}
+	imported, err := a.client.ImportSession(r.Body, dataRoot)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if imported == nil {
+		imported = []ImportedTorrent{}
+	}
+	a.writeJSON(w, http.StatusOK, imported)
+}
+
+func (a *controlAPI) writeStatus(w http.ResponseWriter, code int, id string) {
+	s, err := a.client.Status(id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	a.writeJSON(w, code, s)
+}
+
+func (a *controlAPI) writeError(w http.ResponseWriter, err error) {
+	var bad *badRequestError
+	code := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &bad), errors.Is(err, ErrInvalidArchive), errors.Is(err, ErrInvalidPiece), errors.Is(err, ErrInvalidLimit):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrTorrentNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrAlreadyTracked), errors.Is(err, ErrPaused), errors.Is(err, ErrNotPaused),
+		errors.Is(err, ErrNotFailed), errors.Is(err, ErrRechecking), errors.Is(err, ErrNotRechecking), errors.Is(err, ErrRecheckCompleted),
+		errors.Is(err, ErrNotAnnounced), errors.Is(err, ErrPieceVerified), errors.Is(err, ErrPieceNotAbandoned):
+		code = http.StatusConflict
+	case errors.Is(err, errMagnetUnsupported):
+		code = http.StatusNotImplemented
+	}
+	if code == http.StatusInternalServerError {
+		a.logger.Error("control API request failed", slog.Any("err", err))
+	}
+	a.writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (a *controlAPI) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Debug("failed to write control API response", slog.Any("err", err))
+	}
+}
+
+// torrentID returns the id of the torrent whose hex encoded info hash is in the path.
+func torrentID(r *http.Request) (string, error) {
+	h, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil || len(h) != sha1.Size {
+		return "", &badRequestError{fmt.Errorf("invalid info hash %q", r.PathValue("hash"))}
+	}
+	return string(h), nil
+}
+
+func boolQuery(r *http.Request, key string) (bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &badRequestError{fmt.Errorf("invalid value %q for %s", v, key)}
+	}
+	return b, nil
+}
+
+// readTorrent reads the torrent file either from the "torrent" part of a
+// multipart form, or from the body. A magnet link in the body is rejected.
+func readTorrent(w http.ResponseWriter, r *http.Request) (*torrent.MetaInfoFile, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTorrentFileSize)
+
+	var src io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		f, _, err := r.FormFile("torrent")
+		if err != nil {
+			return nil, &badRequestError{fmt.Errorf("failed to read torrent file from form: %w", err)}
+		}
+		defer f.Close()
+		src = f
+	}
+
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return nil, &badRequestError{fmt.Errorf("failed to read torrent file: %w", err)}
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("magnet:")) {
+		return nil, errMagnetUnsupported
+	}
+
+	mi, err := torrent.LoadBytes(b)
+	if err != nil {
+		return nil, &badRequestError{fmt.Errorf("invalid torrent file: %w", err)}
+	}
+	return mi, nil
+}
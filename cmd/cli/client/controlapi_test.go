@@ -0,0 +1,252 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+// bencodeTorrent returns the torrent file of a single piece torrent of data.
+func bencodeTorrent(announce string, data []byte) []byte {
+	hash := sha1.Sum(data)
+	return fmt.Appendf(nil, "d8:announce%d:%s4:infod6:lengthi%de4:name8:test.bin12:piece lengthi%de6:pieces20:%see",
+		len(announce), announce, len(data), len(data), hash[:])
+}
+
+func TestControlAPI(t *testing.T) {
+	const token = "secret"
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var stopped atomic.Int64
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("event") == "stopped" {
+			stopped.Add(1)
+		}
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	dir := t.TempDir()
+	c, err := New(
+		WithLogger(logger),
+		WithDownloadDir(dir),
+		WithStorage(func(*torrent.MetaInfoFile) storage.Storage { return storage.NewMemory() }),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	srv := httptest.NewServer(newControlAPI(c, token))
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, body io.Reader, contentType string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, body)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := srv.Client().Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, b
+	}
+	decodeStatus := func(b []byte) TorrentStatus {
+		t.Helper()
+		var s TorrentStatus
+		assert.NoError(t, json.Unmarshal(b, &s))
+		return s
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		resp, err := srv.Client().Get(srv.URL + "/torrents")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	file := bencodeTorrent(tracker.URL+"/announce", make([]byte, 16*1024))
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("torrent", "test.torrent")
+	assert.NoError(t, err)
+	_, err = fw.Write(file)
+	assert.NoError(t, err)
+	assert.NoError(t, mw.Close())
+
+	resp, b := do(http.MethodPost, "/torrents", bytes.NewReader(form.Bytes()), mw.FormDataContentType())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, string(b))
+	added := decodeStatus(b)
+	assert.Equal(t, "test.bin", added.Name)
+	assert.Equal(t, StateDownloading, added.State)
+	hash := added.InfoHash
+
+	resp, _ = do(http.MethodPost, "/torrents", bytes.NewReader(file), "application/x-bittorrent")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "torrent added twice")
+
+	resp, b = do(http.MethodGet, "/torrents", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var list []TorrentStatus
+	assert.NoError(t, json.Unmarshal(b, &list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, hash, list[0].InfoHash)
+	}
+
+	resp, b = do(http.MethodGet, "/torrents/"+hash+"/peers", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, "[]", string(b))
+
+	resp, b = do(http.MethodGet, "/torrents/"+hash+"/availability", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"distributedCopies": 0, "pieces": [0]}`, string(b))
+
+	resp, b = do(http.MethodGet, "/torrents/"+hash+"/bans", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"addrs": [], "peerIds": []}`, string(b))
+
+	// no piece is downloaded without peers.
+	resp, b = do(http.MethodGet, "/torrents/"+hash+"/slots", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, "[]", string(b))
+
+	// the torrent announces itself before it is paused.
+	id, _ := hex.DecodeString(hash)
+	assert.Eventually(t, func() bool {
+		st, err := c.TrackerStatus(string(id))
+		return err == nil && !st.LastAnnounce.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/pause", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Equal(t, StatePaused, decodeStatus(b).State)
+	assert.Equal(t, int64(1), stopped.Load(), "pausing announces the stopped event")
+
+	resp, _ = do(http.MethodPost, "/torrents/"+hash+"/pause", nil, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/resume", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Equal(t, StateDownloading, decodeStatus(b).State)
+
+	resp, _ = do(http.MethodPost, "/torrents/"+hash+"/retry", nil, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "the torrent did not fail")
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/recheck", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	var stats RecheckStats
+	assert.NoError(t, json.Unmarshal(b, &stats))
+	assert.Equal(t, RecheckStats{}, stats, "nothing was downloaded")
+
+	resp, _ = do(http.MethodDelete, "/torrents/"+hash+"/recheck", nil, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "no recheck is running")
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/reannounce", nil, "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, string(b))
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/pieces/0/abandon", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Equal(t, []int64{0}, decodeStatus(b).Abandoned)
+
+	resp, _ = do(http.MethodPost, "/torrents/"+hash+"/pieces/1/abandon", nil, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no such piece")
+
+	resp, _ = do(http.MethodPost, "/torrents/"+hash+"/pieces/x/abandon", nil, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, b = do(http.MethodPost, "/torrents/"+hash+"/pieces/0/reclaim", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Empty(t, decodeStatus(b).Abandoned)
+
+	resp, _ = do(http.MethodPost, "/torrents/"+hash+"/pieces/0/reclaim", nil, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "piece is not abandoned")
+
+	resp, b = do(http.MethodPatch, "/torrents/"+hash+"/limits", strings.NewReader(`{"maxPeers": 2, "maxPipeline": 8}`), "application/json")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Equal(t, TorrentLimits{MaxPeers: 2, MaxPipeline: 8}, decodeStatus(b).Limits)
+
+	// omitted limits are kept, invalid ones change none.
+	resp, b = do(http.MethodPatch, "/torrents/"+hash+"/limits", strings.NewReader(`{"maxActivePieces": 3, "maxPipeline": 0}`), "application/json")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	assert.Equal(t, TorrentLimits{MaxPeers: 2, MaxActivePieces: 3}, decodeStatus(b).Limits)
+	resp, _ = do(http.MethodPatch, "/torrents/"+hash+"/limits", strings.NewReader(`{"maxPeers": 5, "maxPipeline": -1}`), "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(http.MethodPatch, "/torrents/"+hash+"/limits", strings.NewReader(`{"maxConns": 5}`), "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown limit")
+	limits, err := c.Limits(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, TorrentLimits{MaxPeers: 2, MaxActivePieces: 3}, limits)
+
+	resp, _ = do(http.MethodDelete, "/torrents/"+hash+"?deleteData=maybe", nil, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = do(http.MethodDelete, "/torrents/"+hash+"?deleteData=true", nil, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, err = os.Stat(filepath.Join(dir, hash))
+	assert.ErrorIs(t, err, os.ErrNotExist, "data is deleted")
+
+	resp, _ = do(http.MethodGet, "/torrents/"+hash, nil, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(http.MethodGet, "/torrents/xyz", nil, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = do(http.MethodPost, "/torrents", strings.NewReader("magnet:?xt=urn:btih:"+hash), "text/plain")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp, _ = do(http.MethodPost, "/torrents", strings.NewReader("not a torrent"), "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, b = do(http.MethodGet, "/session", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, gr)
+	assert.NoError(t, err)
+
+	resp, _ = do(http.MethodPost, "/session", bytes.NewReader(b), "application/gzip")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "missing dataRoot")
+}
+
+func TestClient_ControlAPI(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithControlAPI("127.0.0.1:0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	resp, err := http.Get("http://" + c.ControlAddr() + "/torrents")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, "[]", string(b))
+
+	assert.NoError(t, c.Close(context.Background()))
+	_, err = http.Get("http://" + c.ControlAddr() + "/torrents")
+	assert.Error(t, err, "control API is shut down with the client")
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+// logBuffer collects the output of a logger used by several goroutines.
+type logBuffer struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.String()
+}
+
+func TestClient_TrackerCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		reject  bool
+		wantErr error
+	}{
+		{name: "redacted"},
+		{name: "rejected", reject: true, wantErr: ErrTrackerCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := make(chan string, 10)
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				_, pass, _ := r.BasicAuth()
+				auth <- pass
+				rw.WriteHeader(http.StatusForbidden)
+			}))
+			t.Cleanup(srv.Close)
+			announce := strings.Replace(srv.URL, "http://", "http://user:s3cr3t@", 1) + "/announce"
+
+			logs := new(logBuffer)
+			opts := []Option{
+				WithLogger(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+				WithDownloadDir(t.TempDir()),
+			}
+			if tt.reject {
+				opts = append(opts, WithRejectTrackerCredentials())
+			}
+			c, err := New(opts...)
+			assert.NoError(t, err)
+
+			mi, err := torrent.From(bytes.NewReader(bencodeTorrent(announce, []byte("credentials"))))
+			assert.NoError(t, err)
+			_, err = c.WorkOn(mi)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				select {
+				case pass := <-auth:
+					assert.Equal(t, "s3cr3t", pass, "credentials are sent in the Authorization header")
+				case <-time.After(5 * time.Second):
+					t.Fatal("tracker was not contacted")
+				}
+				assert.Eventually(t, func() bool {
+					return strings.Contains(logs.String(), "failed to contact tracker")
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+			assert.NoError(t, c.Close(context.Background()))
+
+			assert.NotContains(t, logs.String(), "s3cr3t")
+			assert.NotContains(t, fmt.Sprint(err), "s3cr3t")
+		})
+	}
+}
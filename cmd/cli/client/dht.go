@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/dht"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// DefaultDHTBootstrap are the DHT nodes the routing table is built
+// from, unless others are passed to WithDHT.
+var DefaultDHTBootstrap = dht.DefaultBootstrap
+
+const (
+	// dhtFile is the file within the download directory
+	// the id and the routing table of the DHT node are kept in.
+	dhtFile = "dht.json"
+	// dhtInterval is how often the peers of a torrent are looked up.
+	dhtInterval = 15 * time.Minute
+	// dhtLookupTimeout bounds a single lookup.
+	dhtLookupTimeout = 1 * time.Minute
+)
+
+// startDHT starts the DHT node on the UDP port of the listen port.
+func (p *Client) startDHT() error {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf("0.0.0.0:%v", p.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the dht: %w", err)
+	}
+	p.dht, err = dht.New(p.logger.With(slog.String("component", "dht")), conn, dht.Config{
+		Bootstrap: p.dhtBootstrap,
+		StatePath: filepath.Join(p.downloadDir, dhtFile),
+		Resolve:   p.dns.Resolve,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// DHTNodes returns the number of nodes in the routing table
+// of the DHT node, 0 if the DHT is not enabled.
+func (p *Client) DHTNodes() int {
+	if p.dht == nil {
+		return 0
+	}
+	return p.dht.Nodes()
+}
+
+// dhtLookups looks up the peers of the torrent in the DHT every dhtInterval
+// until ctx is done, announcing the listen port if leechers are accepted.
+// The peers found are connected to like those returned by the tracker. The
+// nodes listed by the torrent are pinged first, to bootstrap from them too.
+func (p *Client) dhtLookups(ctx context.Context, infoHash string, t *status.TorrentSession) {
+	defer p.wg.Done()
+
+	logger := p.logger.With(slog.String("infoHash", hex.EncodeToString([]byte(infoHash))))
+	if nodes := t.Torrent().Nodes; len(nodes) > 0 {
+		pingCtx, cancel := context.WithTimeout(ctx, dhtLookupTimeout)
+		n := p.dht.PingNodes(pingCtx, nodes)
+		cancel()
+		logger.Debug("pinged dht nodes of torrent", slog.Int("nodes", len(nodes)), slog.Int("responded", n))
+	}
+
+	var port int
+	if p.seedServer != nil {
+		port = int(p.announcePort(t))
+	}
+
+	for {
+		lookupCtx, cancel := context.WithTimeout(ctx, dhtLookupTimeout)
+		peers, err := p.dht.GetPeers(lookupCtx, dht.ID([]byte(infoHash)), port)
+		cancel()
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Debug("failed to look up peers in the dht", slog.Any("err", err))
+		case len(peers) > 0:
+			logger.Debug("found peers in the dht", slog.Int("peers", len(peers)))
+			resp := new(tracker.Response)
+			for _, peer := range peers {
+				resp.Peers = append(resp.Peers, struct {
+					PeerID string
+					IP     string
+					Port   int64
+				}{IP: peer.Addr().String(), Port: int64(peer.Port())})
+			}
+			if err := t.AddPeers(status.SourceDHT, resp); err != nil {
+				logger.Error("failed to update peers from the dht", slog.Any("err", err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dhtInterval):
+		}
+	}
+}
+
+// withoutTracker follows the download of a trackerless torrent, whose peers
+// are only found in the DHT, until ctx is done or the torrent stops, the
+// way downloadTorrent does for torrents announced to a tracker.
+func (c *Client) withoutTracker(ctx context.Context, logger *slog.Logger, t *status.TorrentSession) {
+	defer c.wg.Done()
+
+	logger.Info("torrent has no tracker, its peers are only found in the dht")
+	downloaded := t.WaitUntilDownloaded()
+	downloading := true
+	for {
+		select {
+		case <-ctx.Done():
+			if downloading {
+				t.CancelDownload()
+			}
+			logger.Info("stopping download, context canceled")
+			return
+		case <-t.Failed():
+			logger.Error("torrent failed", slog.Any("err", t.Err()))
+			t.CancelDownload()
+			return
+		case <-t.WaitUntilSeeded():
+			logger.Info("seeding goals reached")
+			t.CancelUpload()
+			return
+		case <-downloaded:
+			downloaded = nil // the completion is handled only once.
+			downloading = false
+			t.CancelDownload()
+			if c.action == Leech {
+				logger.Info("torrent downloaded, not seeding as client only leeches")
+				return
+			}
+			logger.Info("torrent downloaded, continuing in seeding mode")
+		}
+	}
+}
+
+// private reports whether the peers of the torrent must only be
+// obtained from its trackers, in which case the DHT is not used.
+func private(t *torrent.MetaInfoFile) bool {
+	return t.Private != nil && *t.Private == 1
+}
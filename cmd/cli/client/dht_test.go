@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/dht"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_WithDHT(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	newNode := func(bootstrap ...string) *dht.Server {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+		s, err := dht.New(logger, conn, dht.Config{Bootstrap: bootstrap})
+		assert.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	router := newNode()
+
+	// the peer is only known to the dht.
+	seeder, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { seeder.Close() })
+	contacted := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := seeder.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			select {
+			case contacted <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	mi, err := torrent.From(bytes.NewReader(bencodeTorrent(tracker.URL, []byte("trackerless peers"))))
+	assert.NoError(t, err)
+
+	announcer := newNode(router.Addr().String())
+	assert.NoError(t, announcer.Bootstrap(context.Background()))
+	_, err = announcer.GetPeers(context.Background(), dht.ID(mi.Metadata.Hash), seeder.Addr().(*net.TCPAddr).Port)
+	assert.NoError(t, err)
+
+	port := freePort(t)
+	dir := t.TempDir()
+	c, err := New(WithLogger(logger), WithDownloadDir(dir), WithPort(port), WithDHT(router.Addr().String()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	_, err = c.WorkOn(mi)
+	assert.NoError(t, err)
+	select {
+	case <-contacted:
+	case <-time.After(10 * time.Second):
+		t.Fatal("peer found in the dht was not contacted")
+	}
+	assert.Positive(t, c.DHTNodes())
+
+	// the routing table is kept across restarts.
+	assert.NoError(t, c.Close(context.Background()))
+	_, err = os.Stat(filepath.Join(dir, dhtFile))
+	assert.NoError(t, err)
+}
+
+func TestClient_DHTNodesOfTorrent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	router, err := dht.New(logger, conn, dht.Config{})
+	assert.NoError(t, err)
+	t.Cleanup(func() { router.Close() })
+
+	seeder, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { seeder.Close() })
+	contacted := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := seeder.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			select {
+			case contacted <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// a trackerless torrent, whose only node is unknown to the client.
+	mi, err := torrent.From(bytes.NewReader(bencodeTorrent("http://localhost/announce", []byte("trackerless torrent"))))
+	assert.NoError(t, err)
+	mi.Announce = ""
+	mi.Nodes = []string{router.Addr().String()}
+
+	conn, err = net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	announcer, err := dht.New(logger, conn, dht.Config{Bootstrap: mi.Nodes})
+	assert.NoError(t, err)
+	t.Cleanup(func() { announcer.Close() })
+	assert.NoError(t, announcer.Bootstrap(context.Background()))
+	_, err = announcer.GetPeers(context.Background(), dht.ID(mi.Metadata.Hash), seeder.Addr().(*net.TCPAddr).Port)
+	assert.NoError(t, err)
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithPort(freePort(t)), WithDHT("127.0.0.1:1"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	_, err = c.WorkOn(mi)
+	assert.NoError(t, err)
+	select {
+	case <-contacted:
+	case <-time.After(10 * time.Second):
+		t.Fatal("peer found through the nodes of the torrent was not contacted")
+	}
+}
+
+// freePort returns a port that is free for both TCP and UDP.
+func freePort(t *testing.T) int {
+	for {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+		port := ln.Addr().(*net.TCPAddr).Port
+		ln.Close()
+		conn, err := net.ListenPacket("udp4", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return port
+		}
+	}
+}
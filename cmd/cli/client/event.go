@@ -0,0 +1,41 @@
+package client
+
+import "github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+
+// The events emitted for tracked torrents are re-exported here so
+// that consumers outside of the client can act on them.
+type (
+	Event           = status.Event
+	BlockRange      = status.BlockRange
+	Contribution    = status.Contribution
+	PieceHashFailed = status.PieceHashFailed
+	// DiskVerificationFailed is only emitted with WithReadBackVerification.
+	DiskVerificationFailed = status.DiskVerificationFailed
+	// TorrentCompleted is emitted once per torrent downloaded, after any move.
+	TorrentCompleted = status.TorrentCompleted
+	// Moved and MoveFailed are only emitted with TorrentWithMoveOnComplete.
+	Moved      = status.Moved
+	MoveFailed = status.MoveFailed
+	// TorrentFailed is emitted once the download failed, see Client.Retry.
+	TorrentFailed = status.TorrentFailed
+)
+
+// ErrDiskCorruption is the reason a torrent failed if too many of
+// its pieces did not read back from disk as they were written.
+var ErrDiskCorruption = status.ErrDiskCorruption
+
+// ErrFlushFailed is the reason a torrent failed if its verified pieces
+// could not be written, right away for a full disk or missing permissions.
+var ErrFlushFailed = status.ErrFlushFailed
+
+// Subscribe registers fn to be called for every event emitted by the
+// torrent with the given id. The handler is called synchronously and
+// must not block.
+func (p *Client) Subscribe(id string, fn func(Event)) error {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return err
+	}
+	tr.Subscribe(fn)
+	return nil
+}
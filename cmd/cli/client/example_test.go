@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/cmd/cli/client/tortest"
+)
+
+// Example downloads a torrent from an in-process swarm, as
+// examples/simpledownload does from a real one.
+func Example() {
+	data := bytes.Repeat([]byte("tinytorrent"), 8<<10)
+	tracker := tortest.NewTracker()
+	defer tracker.Close()
+	mi, err := tortest.NewTorrent(tracker.URL, 32<<10, data)
+	if err != nil {
+		panic(err)
+	}
+	seeder, err := tortest.NewSeeder(mi, data)
+	if err != nil {
+		panic(err)
+	}
+	defer seeder.Close()
+	tracker.SetPeers(seeder.Addr)
+
+	dir, err := os.MkdirTemp("", "tinytorrent-example")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(dir))
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close(context.Background())
+
+	// the torrent starts paused, so that no event is missed.
+	id, err := c.WorkOnWithOptions(mi, client.WithStartPaused())
+	if err != nil {
+		panic(err)
+	}
+	completed := make(chan client.TorrentCompleted, 1)
+	err = c.Subscribe(id, func(e client.Event) {
+		if e, ok := e.(client.TorrentCompleted); ok {
+			completed <- e
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := c.Resume(id); err != nil {
+		panic(err)
+	}
+	if err := <-c.WaitFor(id); err != nil {
+		panic(err)
+	}
+
+	e := <-completed
+	got, err := os.ReadFile(e.Paths[0])
+	if err != nil {
+		panic(err)
+	}
+	s, err := c.Status(id)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s: %d of %d bytes, intact: %t\n", filepath.Base(e.Paths[0]), s.Downloaded, s.Size, bytes.Equal(got, data))
+	// Output: data.bin: 90112 of 90112 bytes, intact: true
+}
@@ -0,0 +1,49 @@
+package client
+
+const (
+	// defaultMaxConnections is the number of peer connections of
+	// all torrents, unless the file descriptor limit is lower.
+	defaultMaxConnections = 512
+	// reservedFDs are the file descriptors kept free for the files of the
+	// storage, the listeners, the control API and the tracker announces.
+	reservedFDs = 64
+	// defaultMaxOpenFiles is the number of files the storages of all
+	// torrents open concurrently, unless the file descriptor limit is lower.
+	defaultMaxOpenFiles = 64
+)
+
+// connectionLimit returns the number of peer connections allowed by the file
+// descriptor limit fds, if known, and whether the configured number exceeds
+// it. A non-positive configured number uses the default.
+func connectionLimit(configured int, fds uint64, known bool) (limit int, exceeded bool) {
+	limit = configured
+	if limit <= 0 {
+		limit = defaultMaxConnections
+	}
+	if !known {
+		return limit, false
+	}
+
+	// a quarter of the remaining descriptors is left as headroom for
+	// connections that are being closed and for bursts of file opens.
+	allowed := 1
+	if fds > reservedFDs {
+		allowed = max(int(min(fds-reservedFDs, 1<<30)*3/4), 1)
+	}
+	if limit <= allowed {
+		return limit, false
+	}
+	return allowed, configured > 0
+}
+
+// openFileLimit returns the number of files the storages open concurrently
+// within the file descriptor limit fds, if known, beside the given number
+// of peer connections. Half of the descriptors left by the connections are
+// used, the others remain for the listeners and the tracker announces.
+func openFileLimit(connections int, fds uint64, known bool) int {
+	if !known {
+		return defaultMaxOpenFiles
+	}
+	left := max(int64(min(fds, 1<<30))-int64(connections), 0)
+	return int(min(max(left/2, 1), defaultMaxOpenFiles))
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package client
+
+// fdLimit reports false, as the file descriptors are not limited per process.
+func fdLimit() (uint64, bool) { return 0, false }
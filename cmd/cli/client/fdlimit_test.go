@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		configured   int
+		fds          uint64
+		known        bool
+		want         int
+		wantExceeded bool
+	}{
+		{name: "unknown limit", known: false, want: defaultMaxConnections},
+		{name: "unknown limit configured", configured: 10_000, known: false, want: 10_000},
+		{name: "default within limit", fds: 1 << 20, known: true, want: defaultMaxConnections},
+		{name: "default lowered", fds: 256, known: true, want: 144},
+		{name: "configured within limit", configured: 100, fds: 1024, known: true, want: 100},
+		{name: "configured exceeds limit", configured: 1000, fds: 1024, known: true, want: 720, wantExceeded: true},
+		{name: "limit below reserved", configured: 10, fds: 32, known: true, want: 1, wantExceeded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exceeded := connectionLimit(tt.configured, tt.fds, tt.known)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantExceeded, exceeded)
+		})
+	}
+}
+
+func TestOpenFileLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections int
+		fds         uint64
+		known       bool
+		want        int
+	}{
+		{name: "unknown limit", known: false, want: defaultMaxOpenFiles},
+		{name: "default within limit", connections: defaultMaxConnections, fds: 1 << 20, known: true, want: defaultMaxOpenFiles},
+		{name: "lowered with connections", connections: 144, fds: 256, known: true, want: 56},
+		{name: "limit below reserved", connections: 1, fds: 32, known: true, want: 15},
+		{name: "no descriptors left", connections: 10, fds: 8, known: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, openFileLimit(tt.connections, tt.fds, tt.known))
+		})
+	}
+}
+
+func TestClient_MaxConnections(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithAction(Both), WithPort(0), WithMaxConnections(1))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+	addr := c.seedServer.Addr().String()
+
+	// the first connection holds the only slot while its handshake is awaited.
+	first, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		used, _ := c.ConnStats()
+		return used == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rejected, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer rejected.Close()
+	assert.NoError(t, rejected.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "connection over the limit is closed")
+
+	// an invalid handshake closes the first connection, releasing its slot.
+	_, err = first.Write(make([]byte, 68))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		used, _ := c.ConnStats()
+		return used == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	first.Close()
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package client
+
+import "syscall"
+
+// fdLimit returns the soft limit of the file descriptors of the process.
+func fdLimit() (uint64, bool) {
+	var r syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &r); err != nil {
+		return 0, false
+	}
+	return uint64(r.Cur), true
+}
@@ -0,0 +1,178 @@
+// Package blocklist parses IP blocklists and matches addresses against them.
+package blocklist
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ipRange is an inclusive range of addresses of the same family.
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// List is an immutable set of blocked address ranges. Overlapping and
+// adjacent ranges are merged, so that an address is matched with a
+// binary search over the sorted ranges of its family.
+type List struct {
+	v4, v6 []ipRange
+}
+
+// Parse reads a blocklist with one entry per line. An entry is either
+// a range in the eMule/PeerGuardian .p2p format "description:from-to",
+// a range "from-to", a CIDR prefix or a single address. Empty lines and
+// lines starting with # or // are ignored.
+func Parse(r io.Reader) (*List, error) {
+	var v4, v6 []ipRange
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		entry := strings.TrimSpace(s.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") || strings.HasPrefix(entry, "//") {
+			continue
+		}
+		rng, err := parseEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist entry at line %d: %w", line, err)
+		}
+		if rng.from.Is4() {
+			v4 = append(v4, rng)
+		} else {
+			v6 = append(v6, rng)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+
+	return &List{v4: merge(v4), v6: merge(v6)}, nil
+}
+
+func parseEntry(entry string) (ipRange, error) {
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		prefix = prefix.Masked()
+		return ipRange{from: prefix.Addr().Unmap(), to: lastAddr(prefix).Unmap()}, nil
+	}
+
+	from, to, ok := parseRange(entry)
+	if !ok {
+		// the description of the .p2p format precedes the last colon.
+		if i := strings.LastIndexByte(entry, ':'); i >= 0 {
+			from, to, ok = parseRange(entry[i+1:])
+		}
+	}
+	if ok {
+		if from.Is4() != to.Is4() {
+			return ipRange{}, fmt.Errorf("range %q mixes address families", entry)
+		}
+		if to.Less(from) {
+			return ipRange{}, fmt.Errorf("range %q ends before it starts", entry)
+		}
+		return ipRange{from: from, to: to}, nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return ipRange{}, fmt.Errorf("%q is neither a range, a prefix nor an address", entry)
+	}
+	addr = addr.Unmap()
+	return ipRange{from: addr, to: addr}, nil
+}
+
+// parseRange parses "from-to", the addresses of .p2p lists
+// may be zero padded, e.g. 001.002.003.000.
+func parseRange(s string) (netip.Addr, netip.Addr, bool) {
+	f, t, ok := strings.Cut(s, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	from, err := parseAddr(strings.TrimSpace(f))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	to, err := parseAddr(strings.TrimSpace(t))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	return from, to, true
+}
+
+func parseAddr(s string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), nil
+	}
+	octets := strings.Split(s, ".")
+	if len(octets) != 4 {
+		return netip.Addr{}, fmt.Errorf("invalid address %q", s)
+	}
+	for i, o := range octets {
+		if t := strings.TrimLeft(o, "0"); t != "" {
+			octets[i] = t
+		} else {
+			octets[i] = "0"
+		}
+	}
+	return netip.ParseAddr(strings.Join(octets, "."))
+}
+
+// lastAddr returns the last address within the masked prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// merge sorts the ranges and merges the overlapping and adjacent ones.
+func merge(ranges []ipRange) []ipRange {
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return cmp.Or(a.from.Compare(b.from), a.to.Compare(b.to))
+	})
+
+	var out []ipRange
+	for _, r := range ranges {
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if next := last.to.Next(); !next.IsValid() || r.from.Compare(next) <= 0 {
+				if last.to.Less(r.to) {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Contains reports whether addr is within any of the ranges of the list.
+func (l *List) Contains(addr netip.Addr) bool {
+	if l == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	ranges := l.v6
+	if addr.Is4() {
+		ranges = l.v4
+	}
+	// the first range that does not end before addr.
+	i, _ := slices.BinarySearchFunc(ranges, addr, func(r ipRange, a netip.Addr) int {
+		return r.to.Compare(a)
+	})
+	return i < len(ranges) && ranges[i].from.Compare(addr) <= 0
+}
+
+// Len returns the number of ranges of the list, after merging.
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.v4) + len(l.v6)
+}
@@ -0,0 +1,96 @@
+package blocklist
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	list, err := Parse(strings.NewReader(`
+# comment
+// another comment
+Some Org: with colons:001.002.003.000-001.002.003.255
+Bad-Actor:10.0.0.5-10.0.0.9
+192.168.0.0/16
+192.168.10.0/24
+8.8.8.8
+172.16.0.0 - 172.16.0.10
+2001:db8::/32
+2001:db9::1-2001:db9::ff
+`))
+	assert.NoError(t, err)
+	// the prefixes of 192.168.0.0/16 are merged.
+	assert.Equal(t, 7, list.Len())
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"1.2.3.0", true},
+		{"1.2.3.128", true},
+		{"1.2.3.255", true},
+		{"1.2.4.0", false},
+		{"1.2.2.255", false},
+		{"10.0.0.4", false},
+		{"10.0.0.5", true},
+		{"10.0.0.9", true},
+		{"10.0.0.10", false},
+		{"192.168.255.255", true},
+		{"192.169.0.0", false},
+		{"8.8.8.8", true},
+		{"8.8.8.9", false},
+		{"172.16.0.10", true},
+		{"::ffff:8.8.8.8", true},
+		{"2001:db8:ffff::1", true},
+		{"2001:db9::80", true},
+		{"2001:db9::100", false},
+		{"::1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, list.Contains(netip.MustParseAddr(tt.addr)), tt.addr)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []string{
+		"not an address",
+		"desc:1.2.3.4-",
+		"1.2.3.9-1.2.3.0",
+		"1.2.3.4-2001:db8::1",
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader("1.1.1.1\n" + tt))
+		assert.ErrorContains(t, err, "line 2", tt)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	list, err := Parse(strings.NewReader("10.0.0.0-10.0.0.10\n10.0.0.11-10.0.0.20\n10.0.0.5-10.0.0.6\n10.0.0.30\n255.255.255.0/24\n255.255.255.255"))
+	assert.NoError(t, err)
+	assert.Equal(t, []ipRange{
+		{netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.20")},
+		{netip.MustParseAddr("10.0.0.30"), netip.MustParseAddr("10.0.0.30")},
+		{netip.MustParseAddr("255.255.255.0"), netip.MustParseAddr("255.255.255.255")},
+	}, list.v4)
+}
+
+func BenchmarkContains(b *testing.B) {
+	var sb strings.Builder
+	for i := range 300_000 {
+		fmt.Fprintf(&sb, "range %d:%d.%d.%d.0-%d.%d.%d.127\n", i, 1+i>>16, i>>8&0xff, i&0xff, 1+i>>16, i>>8&0xff, i&0xff)
+	}
+	list, err := Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := netip.MustParseAddr("3.100.7.200")
+
+	b.ResetTimer()
+	for range b.N {
+		list.Contains(addr)
+	}
+}
@@ -0,0 +1,697 @@
+// Package dht implements a node of the BitTorrent DHT (BEP 5), with
+// which the peers of torrents are found without contacting a tracker.
+// Only IPv4 nodes are supported.
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/bencoding"
+)
+
+// DefaultBootstrap are the nodes the routing table is built from, while it is empty.
+var DefaultBootstrap = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// ErrNoNodes is returned by lookups if neither the routing
+// table nor the bootstrap nodes know any node to query.
+var ErrNoNodes = errors.New("no dht nodes known")
+
+var errTimeout = errors.New("dht query timed out")
+
+const (
+	// queryTimeout is how long the response to a query is waited for.
+	queryTimeout = 2 * time.Second
+	// alpha is the number of queries a lookup has in flight.
+	alpha = 3
+	// tokenRotation is how often the secret of the announce tokens
+	// changes, tokens of the previous secret are still accepted.
+	tokenRotation = 5 * time.Minute
+	// peerTTL is how long the peers announced to this node are kept.
+	peerTTL = 30 * time.Minute
+	// maxPeersPerHash and maxHashes bound the announced peers kept.
+	maxPeersPerHash = 100
+	maxHashes       = 1000
+	// maintenanceInterval is how often tokens and announced peers are
+	// maintained and the routing table is bootstrapped if nearly empty.
+	maintenanceInterval = 1 * time.Minute
+	// maxPacketSize is the largest message that is read.
+	maxPacketSize = 8192
+)
+
+// Config configures a Server.
+type Config struct {
+	// Bootstrap are the host:port of the nodes queried while the
+	// routing table holds less than a bucket of nodes.
+	Bootstrap []string
+	// StatePath is the file the id and the routing table are
+	// persisted to across restarts, if set.
+	StatePath string
+	// Resolve resolves the host names of the bootstrap nodes,
+	// with net.DefaultResolver if nil.
+	Resolve func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+}
+
+type pendingQuery struct {
+	addr netip.AddrPort
+	resp chan *msg
+}
+
+// Server is a DHT node listening on a UDP socket. It answers the queries
+// of other nodes and looks up the peers of torrents.
+type Server struct {
+	logger *slog.Logger
+	conn   net.PacketConn
+	id     ID
+	table  *table
+	cfg    Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	l       sync.Mutex
+	pending map[string]*pendingQuery
+	tid     uint16
+	// secrets are the current and the previous secret of the tokens.
+	secrets [2][16]byte
+	// peers holds the peers announced to this node, with the time
+	// of the announce, by info hash.
+	peers map[ID]map[netip.AddrPort]time.Time
+	// closed is set once Close was called, after which no pings are started.
+	closed bool
+
+	closeOnce sync.Once
+	closeErr  error
+	wg        sync.WaitGroup
+}
+
+// New starts a node serving on conn, with the id and the routing
+// table restored from cfg.StatePath. The routing table is bootstrapped
+// in the background. The server takes ownership of conn.
+func New(logger *slog.Logger, conn net.PacketConn, cfg Config) (*Server, error) {
+	s := &Server{
+		logger:  logger,
+		conn:    conn,
+		cfg:     cfg,
+		pending: make(map[string]*pendingQuery),
+		peers:   make(map[ID]map[netip.AddrPort]time.Time),
+	}
+	if s.cfg.Resolve == nil {
+		s.cfg.Resolve = resolveSystem
+	}
+
+	id, nodes, err := loadState(cfg.StatePath)
+	if err != nil {
+		// a lost routing table is rebuilt from the bootstrap nodes.
+		logger.Warn("failed to restore dht state", slog.Any("err", err))
+	}
+	if id == (ID{}) {
+		if id, err = RandomID(); err != nil {
+			return nil, err
+		}
+	}
+	s.id = id
+	s.table = newTable(id)
+	for _, n := range nodes {
+		s.table.add(n.id, n.addr)
+	}
+	s.rotateSecret()
+	s.rotateSecret()
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(2)
+	go s.serve()
+	go s.maintain()
+
+	return s, nil
+}
+
+// ID returns the id of the node.
+func (s *Server) ID() ID { return s.id }
+
+// Addr returns the address the node listens on.
+func (s *Server) Addr() net.Addr { return s.conn.LocalAddr() }
+
+// Nodes returns the number of nodes in the routing table.
+func (s *Server) Nodes() int { return s.table.len() }
+
+// Close stops the node and persists its routing table.
+// It is safe to call Close more than once.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.l.Lock()
+		s.closed = true
+		s.l.Unlock()
+
+		s.cancel()
+		err := s.conn.Close()
+		s.wg.Wait()
+		s.closeErr = errors.Join(err, s.saveState())
+	})
+	return s.closeErr
+}
+
+// AddNode pings the node at addr, e.g. as a peer advertised it, which
+// adds it to the routing table once it responds.
+func (s *Server) AddNode(addr netip.AddrPort) {
+	if !addr.Addr().Unmap().Is4() {
+		return
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if _, _, err := s.query(s.ctx, addr, "ping", dict{}); err != nil {
+			s.logger.Debug("failed to ping dht node", slog.String("addr", addr.String()), slog.Any("err", err))
+		}
+	}()
+}
+
+// PingNodes pings the nodes given as host:port, e.g. those listed by a
+// torrent, and waits for their responses. The nodes that respond are added
+// to the routing table. It returns the number of nodes that responded.
+func (s *Server) PingNodes(ctx context.Context, nodes []string) int {
+	var responded atomic.Int64
+	var wg sync.WaitGroup
+	for _, addr := range s.resolveNodes(ctx, nodes) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := s.query(ctx, addr, "ping", dict{}); err != nil {
+				s.logger.Debug("failed to ping dht node", slog.String("addr", addr.String()), slog.Any("err", err))
+				return
+			}
+			responded.Add(1)
+		}()
+	}
+	wg.Wait()
+	return int(responded.Load())
+}
+
+// Bootstrap fills the routing table with the nodes closest to the own id.
+func (s *Server) Bootstrap(ctx context.Context) error {
+	_, _, err := s.lookup(ctx, s.id, false)
+	return err
+}
+
+// GetPeers looks up the peers of the torrent with infoHash. If announcePort
+// is positive, the node announces itself as a peer listening on that port to
+// the nodes closest to infoHash.
+func (s *Server) GetPeers(ctx context.Context, infoHash ID, announcePort int) ([]netip.AddrPort, error) {
+	closest, peers, err := s.lookup(ctx, infoHash, true)
+	if err != nil {
+		return nil, err
+	}
+	if announcePort <= 0 || announcePort > 65535 {
+		return peers, nil
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range closest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.query(ctx, c.addr, "announce_peer", dict{
+				"info_hash":    str(string(infoHash[:])),
+				"port":         integer(int64(announcePort)),
+				"token":        str(c.token),
+				"implied_port": integer(0),
+			})
+			if err != nil {
+				s.logger.Debug("failed to announce to dht node", slog.String("addr", c.addr.String()), slog.Any("err", err))
+			}
+		}()
+	}
+	wg.Wait()
+	return peers, nil
+}
+
+type candidate struct {
+	nodeInfo
+	// known is set once the id of the node is known,
+	// the bootstrap nodes are queried without it.
+	known     bool
+	queried   bool
+	responded bool
+	failed    bool
+	token     string
+}
+
+// lookup iteratively queries the nodes closest to target, until the
+// closest that did not fail were all queried. It returns those that
+// responded with a token, and for get_peers queries the peers found.
+func (s *Server) lookup(ctx context.Context, target ID, getPeers bool) ([]*candidate, []netip.AddrPort, error) {
+	seen := make(map[netip.AddrPort]bool)
+	var candidates []*candidate
+	add := func(n nodeInfo, known bool) {
+		if seen[n.addr] || (known && n.id == s.id) {
+			return
+		}
+		seen[n.addr] = true
+		candidates = append(candidates, &candidate{nodeInfo: n, known: known})
+	}
+
+	for _, n := range s.table.closest(target, bucketSize) {
+		add(n, true)
+	}
+	if len(candidates) < bucketSize {
+		for _, addr := range s.bootstrapAddrs(ctx) {
+			add(nodeInfo{addr: addr}, false)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, ErrNoNodes
+	}
+
+	q, args := "find_node", dict{"target": str(string(target[:]))}
+	if getPeers {
+		q, args = "get_peers", dict{"info_hash": str(string(target[:]))}
+	}
+
+	type result struct {
+		c    *candidate
+		id   ID
+		resp *bencoding.Dictionary
+		err  error
+	}
+	// buffered, so that queries in flight do not block once the lookup returned.
+	results := make(chan result, alpha)
+	var inFlight int
+
+	var peers []netip.AddrPort
+	seenPeers := make(map[netip.AddrPort]bool)
+
+	for {
+		// failed nodes last, then the nodes without id, then by distance.
+		slices.SortStableFunc(candidates, func(a, b *candidate) int {
+			switch {
+			case a.failed != b.failed:
+				if a.failed {
+					return 1
+				}
+				return -1
+			case a.known != b.known:
+				if a.known {
+					return 1
+				}
+				return -1
+			case closer(target, a.id, b.id):
+				return -1
+			case closer(target, b.id, a.id):
+				return 1
+			}
+			return 0
+		})
+		for _, c := range candidates[:min(bucketSize, len(candidates))] {
+			if inFlight == alpha {
+				break
+			}
+			if c.queried || c.failed {
+				continue
+			}
+			c.queried = true
+			inFlight++
+			go func() {
+				id, resp, err := s.query(ctx, c.addr, q, copyArgs(args))
+				results <- result{c: c, id: id, resp: resp, err: err}
+			}()
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		var res result
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case res = <-results:
+		}
+		inFlight--
+
+		c := res.c
+		if res.err != nil {
+			c.failed = true
+			if c.known {
+				s.table.failed(c.id)
+			}
+			continue
+		}
+		c.id, c.known, c.responded = res.id, true, true
+		c.token, _ = getString(res.resp, "token")
+
+		if nodes, ok := getString(res.resp, "nodes"); ok {
+			for _, n := range decodeNodes(nodes) {
+				add(n, true)
+			}
+		}
+		if values, ok := res.resp.Dict["values"].(*bencoding.List); ok && getPeers {
+			for _, v := range *values {
+				b, ok := v.(*bencoding.ByteString)
+				if !ok {
+					continue
+				}
+				if p, ok := decodePeer(string(*b)); ok && !seenPeers[p] {
+					seenPeers[p] = true
+					peers = append(peers, p)
+				}
+			}
+		}
+	}
+
+	var closest []*candidate
+	for _, c := range candidates {
+		if c.responded && c.token != "" && len(closest) < bucketSize {
+			closest = append(closest, c)
+		}
+	}
+	return closest, peers, nil
+}
+
+// copyArgs copies the arguments of a query, as each query adds its own id.
+func copyArgs(args dict) dict {
+	out := make(dict, len(args)+1)
+	for k, v := range args {
+		out[k] = v
+	}
+	return out
+}
+
+// query sends the query to the node at addr and returns the id of the
+// node and the values of its response.
+func (s *Server) query(ctx context.Context, addr netip.AddrPort, q string, args dict) (ID, *bencoding.Dictionary, error) {
+	args["id"] = str(string(s.id[:]))
+
+	s.l.Lock()
+	s.tid++
+	t := string(binary.BigEndian.AppendUint16(nil, s.tid))
+	p := &pendingQuery{addr: addr, resp: make(chan *msg, 1)}
+	s.pending[t] = p
+	s.l.Unlock()
+
+	defer func() {
+		s.l.Lock()
+		delete(s.pending, t)
+		s.l.Unlock()
+	}()
+
+	m := &msg{T: t, Y: typeQuery, Q: q, Args: &bencoding.Dictionary{Dict: args}}
+	if err := s.send(addr, m); err != nil {
+		return ID{}, nil, err
+	}
+
+	timeout := time.NewTimer(queryTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ID{}, nil, ctx.Err()
+	case <-timeout.C:
+		return ID{}, nil, errTimeout
+	case resp := <-p.resp:
+		if resp.Err != nil {
+			return ID{}, nil, resp.Err
+		}
+		id, ok := getID(resp.Args, "id")
+		if !ok {
+			return ID{}, nil, errors.New("dht response without node id")
+		}
+		s.table.update(id, addr, time.Now())
+		return id, resp.Args, nil
+	}
+}
+
+func (s *Server) send(addr netip.AddrPort, m *msg) error {
+	if _, err := s.conn.WriteTo(m.encode(), net.UDPAddrFromAddrPort(addr)); err != nil {
+		return fmt.Errorf("failed to send dht message to %s: %w", addr, err)
+	}
+	return nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Debug("failed to read dht message", slog.Any("err", err))
+			continue
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		addr := udp.AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+		m, err := decodeMsg(buf[:n])
+		if err != nil {
+			s.logger.Debug("received malformed dht message", slog.String("addr", addr.String()), slog.Any("err", err))
+			continue
+		}
+		if m.Y == typeQuery {
+			s.handleQuery(addr, m)
+			continue
+		}
+
+		s.l.Lock()
+		p, ok := s.pending[m.T]
+		if ok && p.addr == addr {
+			delete(s.pending, m.T)
+		}
+		s.l.Unlock()
+		if ok && p.addr == addr {
+			p.resp <- m
+		}
+	}
+}
+
+func (s *Server) handleQuery(from netip.AddrPort, m *msg) {
+	reply := func(r dict) {
+		r["id"] = str(string(s.id[:]))
+		if err := s.send(from, &msg{T: m.T, Y: typeResponse, Args: &bencoding.Dictionary{Dict: r}}); err != nil {
+			s.logger.Debug("failed to respond to dht query", slog.Any("err", err))
+		}
+	}
+	fail := func(code int64, message string) {
+		if err := s.send(from, &msg{T: m.T, Y: typeError, Err: &Error{Code: code, Message: message}}); err != nil {
+			s.logger.Debug("failed to respond to dht query", slog.Any("err", err))
+		}
+	}
+
+	id, ok := getID(m.Args, "id")
+	if !ok {
+		fail(errProtocol, "missing id")
+		return
+	}
+	s.table.update(id, from, time.Now())
+
+	switch m.Q {
+	case "ping":
+		reply(dict{})
+	case "find_node":
+		target, ok := getID(m.Args, "target")
+		if !ok {
+			fail(errProtocol, "missing target")
+			return
+		}
+		reply(dict{"nodes": str(encodeNodes(s.table.closest(target, bucketSize)))})
+	case "get_peers":
+		infoHash, ok := getID(m.Args, "info_hash")
+		if !ok {
+			fail(errProtocol, "missing info_hash")
+			return
+		}
+		r := dict{
+			"token": str(s.token(from.Addr(), 0)),
+			"nodes": str(encodeNodes(s.table.closest(infoHash, bucketSize))),
+		}
+		if peers := s.announced(infoHash); len(peers) > 0 {
+			values := make(bencoding.List, 0, len(peers))
+			for _, p := range peers {
+				values = append(values, str(encodePeer(p)))
+			}
+			r["values"] = &values
+		}
+		reply(r)
+	case "announce_peer":
+		infoHash, ok := getID(m.Args, "info_hash")
+		token, _ := getString(m.Args, "token")
+		port, _ := getInt(m.Args, "port")
+		if implied, _ := getInt(m.Args, "implied_port"); implied != 0 {
+			port = int64(from.Port())
+		}
+		if !ok || port <= 0 || port > 65535 {
+			fail(errProtocol, "invalid announce")
+			return
+		}
+		if !s.validToken(token, from.Addr()) {
+			fail(errProtocol, "invalid token")
+			return
+		}
+		s.announce(infoHash, netip.AddrPortFrom(from.Addr(), uint16(port)))
+		reply(dict{})
+	default:
+		fail(errMethodUnknown, "method unknown")
+	}
+}
+
+// token returns the token of addr for the secret at index i.
+func (s *Server) token(addr netip.Addr, i int) string {
+	s.l.Lock()
+	secret := s.secrets[i]
+	s.l.Unlock()
+
+	ip := addr.As16()
+	sum := sha1.Sum(append(secret[:], ip[:]...))
+	return string(sum[:8])
+}
+
+func (s *Server) validToken(token string, addr netip.Addr) bool {
+	return token != "" && (token == s.token(addr, 0) || token == s.token(addr, 1))
+}
+
+func (s *Server) rotateSecret() {
+	var secret [16]byte
+	rand.Read(secret[:])
+
+	s.l.Lock()
+	s.secrets[1], s.secrets[0] = s.secrets[0], secret
+	s.l.Unlock()
+}
+
+func (s *Server) announce(infoHash ID, peer netip.AddrPort) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	peers, ok := s.peers[infoHash]
+	if !ok {
+		if len(s.peers) >= maxHashes {
+			return
+		}
+		peers = make(map[netip.AddrPort]time.Time)
+		s.peers[infoHash] = peers
+	}
+	if _, ok := peers[peer]; ok || len(peers) < maxPeersPerHash {
+		peers[peer] = time.Now()
+	}
+}
+
+func (s *Server) announced(infoHash ID) []netip.AddrPort {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var out []netip.AddrPort
+	for p := range s.peers[infoHash] {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (s *Server) expirePeers(now time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for infoHash, peers := range s.peers {
+		for p, announced := range peers {
+			if now.Sub(announced) > peerTTL {
+				delete(peers, p)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.peers, infoHash)
+		}
+	}
+}
+
+// maintain rotates the secrets of the tokens, expires the announced peers
+// and bootstraps the routing table while it holds less than a bucket.
+func (s *Server) maintain() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	rotated := time.Now()
+	for {
+		if s.table.len() < bucketSize {
+			if err := s.Bootstrap(s.ctx); err != nil && s.ctx.Err() == nil {
+				s.logger.Debug("failed to bootstrap dht", slog.Any("err", err))
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(rotated) >= tokenRotation {
+				s.rotateSecret()
+				rotated = now
+			}
+			s.expirePeers(now)
+		}
+	}
+}
+
+// bootstrapAddrs resolves the IPv4 addresses of the bootstrap nodes.
+func (s *Server) bootstrapAddrs(ctx context.Context) []netip.AddrPort {
+	return s.resolveNodes(ctx, s.cfg.Bootstrap)
+}
+
+// resolveNodes resolves the IPv4 addresses of the nodes given as host:port.
+func (s *Server) resolveNodes(ctx context.Context, nodes []string) []netip.AddrPort {
+	var out []netip.AddrPort
+	for _, b := range nodes {
+		host, port, err := net.SplitHostPort(b)
+		if err != nil {
+			s.logger.Debug("invalid dht node", slog.String("node", b), slog.Any("err", err))
+			continue
+		}
+		p, err := net.LookupPort("udp", port)
+		if err != nil {
+			s.logger.Debug("invalid dht node", slog.String("node", b), slog.Any("err", err))
+			continue
+		}
+		addrs, _, err := s.cfg.Resolve(ctx, host)
+		if err != nil {
+			s.logger.Debug("failed to resolve dht node", slog.String("node", b), slog.Any("err", err))
+			continue
+		}
+		for _, a := range addrs {
+			if a = a.Unmap(); a.Is4() {
+				out = append(out, netip.AddrPortFrom(a, uint16(p)))
+			}
+		}
+	}
+	return out
+}
+
+func resolveSystem(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, 0, nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	return addrs, 0, err
+}
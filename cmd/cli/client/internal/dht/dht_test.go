@@ -0,0 +1,180 @@
+package dht
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/bencoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, cfg Config) *Server {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), conn, cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestServer_GetPeers(t *testing.T) {
+	router := newTestServer(t, Config{})
+	cfg := Config{Bootstrap: []string{router.Addr().String()}}
+
+	var nodes []*Server
+	for range 5 {
+		nodes = append(nodes, newTestServer(t, cfg))
+	}
+	for _, n := range nodes {
+		assert.NoError(t, n.Bootstrap(context.Background()))
+		assert.Positive(t, n.Nodes())
+	}
+
+	infoHash, err := RandomID()
+	assert.NoError(t, err)
+
+	peers, err := nodes[0].GetPeers(context.Background(), infoHash, 6881)
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+
+	peers, err = nodes[4].GetPeers(context.Background(), infoHash, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}, peers)
+}
+
+func TestServer_NoNodes(t *testing.T) {
+	s := newTestServer(t, Config{})
+	_, err := s.GetPeers(context.Background(), ID{1}, 0)
+	assert.ErrorIs(t, err, ErrNoNodes)
+}
+
+func TestServer_Queries(t *testing.T) {
+	s := newTestServer(t, Config{})
+	client := newTestServer(t, Config{})
+	addr := netip.MustParseAddrPort(s.Addr().String())
+
+	id, _, err := client.query(context.Background(), addr, "ping", dict{})
+	assert.NoError(t, err)
+	assert.Equal(t, s.ID(), id)
+	// both nodes learned about each other.
+	assert.Equal(t, 1, client.Nodes())
+	assert.Equal(t, 1, s.Nodes())
+
+	_, _, err = client.query(context.Background(), addr, "vote", dict{})
+	assert.Equal(t, &Error{Code: errMethodUnknown, Message: "method unknown"}, err)
+
+	infoHash := ID{1, 2, 3}
+	_, _, err = client.query(context.Background(), addr, "announce_peer", dict{
+		"info_hash": str(string(infoHash[:])),
+		"port":      integer(6881),
+		"token":     str("forged"),
+	})
+	assert.Equal(t, &Error{Code: errProtocol, Message: "invalid token"}, err)
+
+	_, resp, err := client.query(context.Background(), addr, "get_peers", dict{"info_hash": str(string(infoHash[:]))})
+	assert.NoError(t, err)
+	token, ok := getString(resp, "token")
+	assert.True(t, ok)
+
+	_, _, err = client.query(context.Background(), addr, "announce_peer", dict{
+		"info_hash":    str(string(infoHash[:])),
+		"port":         integer(1),
+		"token":        str(token),
+		"implied_port": integer(1),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.AddrPort{netip.MustParseAddrPort(client.Addr().String())}, s.announced(infoHash))
+
+	// tokens of the previous secret are still accepted.
+	s.rotateSecret()
+	assert.True(t, s.validToken(token, netip.MustParseAddr("127.0.0.1")))
+	s.rotateSecret()
+	assert.False(t, s.validToken(token, netip.MustParseAddr("127.0.0.1")))
+}
+
+func TestServer_AddNode(t *testing.T) {
+	s := newTestServer(t, Config{})
+	other := newTestServer(t, Config{})
+
+	s.AddNode(netip.MustParseAddrPort(other.Addr().String()))
+	assert.Eventually(t, func() bool { return s.Nodes() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_PingNodes(t *testing.T) {
+	s := newTestServer(t, Config{})
+	other := newTestServer(t, Config{})
+
+	// malformed and unreachable nodes are skipped.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	n := s.PingNodes(ctx, []string{other.Addr().String(), "no port", "127.0.0.1:1"})
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, s.Nodes())
+}
+
+func TestServer_State(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dht.json")
+	other := newTestServer(t, Config{})
+
+	s := newTestServer(t, Config{StatePath: path})
+	_, _, err := s.query(context.Background(), netip.MustParseAddrPort(other.Addr().String()), "ping", dict{})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	restored := newTestServer(t, Config{StatePath: path})
+	assert.Equal(t, s.ID(), restored.ID())
+	assert.Equal(t, []nodeInfo{{id: other.ID(), addr: netip.MustParseAddrPort(other.Addr().String())}}, restored.table.nodes())
+}
+
+func TestTable(t *testing.T) {
+	tb := newTable(ID{})
+	now := time.Now()
+
+	// all ids share no prefix with the own id and fall into the same bucket.
+	for i := range bucketSize + 1 {
+		tb.update(ID{0x80, byte(i)}, netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), 6881), now)
+	}
+	assert.Equal(t, bucketSize, tb.len())
+
+	// a node that stopped responding is replaced.
+	for range maxFailures - 1 {
+		tb.failed(ID{0x80, 0})
+	}
+	tb.update(ID{0x80, 0xff}, netip.MustParseAddrPort("10.0.0.255:6881"), now)
+	assert.Equal(t, bucketSize, tb.len())
+	assert.Equal(t, ID{0x80, 0xff}, tb.closest(ID{0x80, 0xff}, 1)[0].id)
+
+	for range maxFailures {
+		tb.failed(ID{0x80, 1})
+	}
+	assert.Equal(t, bucketSize-1, tb.len())
+
+	closest := tb.closest(ID{0x80, 3}, 3)
+	assert.Equal(t, []ID{{0x80, 3}, {0x80, 2}, {0x80, 7}}, []ID{closest[0].id, closest[1].id, closest[2].id})
+}
+
+func TestMsg(t *testing.T) {
+	nodes := []nodeInfo{{id: ID{1}, addr: netip.MustParseAddrPort("1.2.3.4:6881")}}
+	m := &msg{T: "aa", Y: typeResponse, Args: &bencoding.Dictionary{Dict: dict{
+		"id":    str(string(make([]byte, 20))),
+		"nodes": str(encodeNodes(nodes)),
+	}}}
+	got, err := decodeMsg(m.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, "aa", got.T)
+	s, _ := getString(got.Args, "nodes")
+	assert.Equal(t, nodes, decodeNodes(s))
+
+	got, err = decodeMsg((&msg{T: "bb", Y: typeError, Err: &Error{Code: errProtocol, Message: "bad"}}).encode())
+	assert.NoError(t, err)
+	assert.Equal(t, &Error{Code: errProtocol, Message: "bad"}, got.Err)
+
+	_, err = decodeMsg([]byte("d1:y1:qe"))
+	assert.Error(t, err)
+}
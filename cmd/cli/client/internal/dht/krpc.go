@@ -0,0 +1,225 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+
+	"github.com/Despire/tinytorrent/bencoding"
+)
+
+// ID identifies nodes and torrents within the DHT.
+type ID [20]byte
+
+// RandomID returns a random node id.
+func RandomID() (ID, error) {
+	var id ID
+	if _, err := rand.Read(id[:]); err != nil {
+		return ID{}, fmt.Errorf("failed to generate node id: %w", err)
+	}
+	return id, nil
+}
+
+func (id ID) String() string { return hex.EncodeToString(id[:]) }
+
+// closer reports whether a is closer to target than b by the XOR metric.
+func closer(target, a, b ID) bool {
+	for i := range target {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// commonPrefixLen returns the number of leading bits a and b share.
+func commonPrefixLen(a, b ID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
+
+// KRPC message types.
+const (
+	typeQuery    = "q"
+	typeResponse = "r"
+	typeError    = "e"
+)
+
+// KRPC error codes.
+const (
+	errProtocol      = 203
+	errMethodUnknown = 204
+)
+
+// msg is a KRPC message, the arguments of a query or the
+// values of a response are kept as a bencoded dictionary.
+type msg struct {
+	T    string
+	Y    string
+	Q    string
+	Args *bencoding.Dictionary
+	// Err is the code and the message of an error.
+	Err *Error
+}
+
+// Error is the error a node responded with.
+type Error struct {
+	Code    int64
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht node responded with error %d: %s", e.Code, e.Message)
+}
+
+func (m *msg) encode() []byte {
+	d := dict{"t": str(m.T), "y": str(m.Y)}
+	switch m.Y {
+	case typeQuery:
+		d["q"] = str(m.Q)
+		d["a"] = m.Args
+	case typeResponse:
+		d["r"] = m.Args
+	case typeError:
+		d["e"] = &bencoding.List{integer(m.Err.Code), str(m.Err.Message)}
+	}
+	return []byte((&bencoding.Dictionary{Dict: d}).Literal())
+}
+
+func decodeMsg(b []byte) (*msg, error) {
+	v, err := bencoding.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(*bencoding.Dictionary)
+	if !ok {
+		return nil, errors.New("message is not a dictionary")
+	}
+
+	m := &msg{}
+	if m.T, ok = getString(d, "t"); !ok {
+		return nil, errors.New("message without transaction id")
+	}
+	m.Y, _ = getString(d, "y")
+	switch m.Y {
+	case typeQuery:
+		m.Q, _ = getString(d, "q")
+		if m.Args, ok = d.Dict["a"].(*bencoding.Dictionary); !ok {
+			return nil, errors.New("query without arguments")
+		}
+	case typeResponse:
+		if m.Args, ok = d.Dict["r"].(*bencoding.Dictionary); !ok {
+			return nil, errors.New("response without values")
+		}
+	case typeError:
+		l, ok := d.Dict["e"].(*bencoding.List)
+		if !ok || len(*l) != 2 {
+			return nil, errors.New("malformed error")
+		}
+		code, _ := (*l)[0].(*bencoding.Integer)
+		message, _ := (*l)[1].(*bencoding.ByteString)
+		if code == nil || message == nil {
+			return nil, errors.New("malformed error")
+		}
+		m.Err = &Error{Code: int64(*code), Message: string(*message)}
+	default:
+		return nil, fmt.Errorf("unknown message type %q", m.Y)
+	}
+	return m, nil
+}
+
+type dict = map[string]bencoding.Value
+
+func str(s string) *bencoding.ByteString {
+	b := bencoding.ByteString(s)
+	return &b
+}
+
+func integer(i int64) *bencoding.Integer {
+	v := bencoding.Integer(i)
+	return &v
+}
+
+func getString(d *bencoding.Dictionary, key string) (string, bool) {
+	s, ok := d.Dict[key].(*bencoding.ByteString)
+	if !ok {
+		return "", false
+	}
+	return string(*s), true
+}
+
+func getInt(d *bencoding.Dictionary, key string) (int64, bool) {
+	i, ok := d.Dict[key].(*bencoding.Integer)
+	if !ok {
+		return 0, false
+	}
+	return int64(*i), true
+}
+
+func getID(d *bencoding.Dictionary, key string) (ID, bool) {
+	s, ok := getString(d, key)
+	if !ok || len(s) != len(ID{}) {
+		return ID{}, false
+	}
+	return ID([]byte(s)), true
+}
+
+// compactNodeLen is the length of the compact node info of an IPv4 node.
+const compactNodeLen = 26
+
+type nodeInfo struct {
+	id   ID
+	addr netip.AddrPort
+}
+
+func encodeNodes(nodes []nodeInfo) string {
+	b := make([]byte, 0, len(nodes)*compactNodeLen)
+	for _, n := range nodes {
+		if !n.addr.Addr().Is4() {
+			continue
+		}
+		b = append(b, n.id[:]...)
+		b = append(b, encodePeer(n.addr)...)
+	}
+	return string(b)
+}
+
+func decodeNodes(s string) []nodeInfo {
+	var out []nodeInfo
+	for i := 0; i+compactNodeLen <= len(s); i += compactNodeLen {
+		addr, ok := decodePeer(s[i+20 : i+compactNodeLen])
+		if !ok {
+			continue
+		}
+		out = append(out, nodeInfo{id: ID([]byte(s[i : i+20])), addr: addr})
+	}
+	return out
+}
+
+// encodePeer returns the compact peer info of an IPv4 address.
+func encodePeer(addr netip.AddrPort) string {
+	ip := addr.Addr().Unmap().As4()
+	return string(binary.BigEndian.AppendUint16(ip[:], addr.Port()))
+}
+
+func decodePeer(s string) (netip.AddrPort, bool) {
+	if len(s) != 6 {
+		return netip.AddrPort{}, false
+	}
+	addr := netip.AddrFrom4([4]byte([]byte(s[:4])))
+	port := binary.BigEndian.Uint16([]byte(s[4:]))
+	if port == 0 || addr.IsUnspecified() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr, port), true
+}
@@ -0,0 +1,85 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+
+	"github.com/Despire/tinytorrent/cmd/cli/internal/atomicfile"
+)
+
+// state is the persisted id and routing table of a node.
+type state struct {
+	ID    string      `json:"id"`
+	Nodes []stateNode `json:"nodes"`
+}
+
+type stateNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// loadState restores the id and the nodes persisted at path. Nothing
+// is restored if path is empty or the file does not exist yet.
+func loadState(path string) (ID, []nodeInfo, error) {
+	if path == "" {
+		return ID{}, nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ID{}, nil, nil
+	}
+	if err != nil {
+		return ID{}, nil, fmt.Errorf("failed to read dht state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return ID{}, nil, fmt.Errorf("failed to decode dht state: %w", err)
+	}
+
+	id, ok := parseID(st.ID)
+	if !ok {
+		return ID{}, nil, fmt.Errorf("invalid node id %q in dht state", st.ID)
+	}
+	var nodes []nodeInfo
+	for _, n := range st.Nodes {
+		nid, ok := parseID(n.ID)
+		addr, err := netip.ParseAddrPort(n.Addr)
+		if !ok || err != nil {
+			continue
+		}
+		nodes = append(nodes, nodeInfo{id: nid, addr: addr})
+	}
+	return id, nodes, nil
+}
+
+func parseID(s string) (ID, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(ID{}) {
+		return ID{}, false
+	}
+	return ID(b), true
+}
+
+// saveState persists the id and the routing table, if a path is set.
+func (s *Server) saveState() error {
+	if s.cfg.StatePath == "" {
+		return nil
+	}
+	st := state{ID: s.id.String()}
+	for _, n := range s.table.nodes() {
+		st.Nodes = append(st.Nodes, stateNode{ID: n.id.String(), Addr: n.addr.String()})
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.WriteFile(s.cfg.StatePath, b, 0o600); err != nil {
+		return fmt.Errorf("failed to persist dht state: %w", err)
+	}
+	return nil
+}
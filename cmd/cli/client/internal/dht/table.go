@@ -0,0 +1,141 @@
+package dht
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketSize is the number of nodes per bucket, K of Kademlia.
+	bucketSize = 8
+	// questionableAfter is how long a node that did not respond is
+	// kept in favor of new nodes.
+	questionableAfter = 15 * time.Minute
+	// maxFailures is the number of queries a node can fail to respond
+	// to in a row before it is removed from the table.
+	maxFailures = 3
+)
+
+type node struct {
+	id       ID
+	addr     netip.AddrPort
+	lastSeen time.Time
+	failures int
+}
+
+func (n *node) good(now time.Time) bool {
+	return n.failures == 0 && now.Sub(n.lastSeen) < questionableAfter
+}
+
+// table is the routing table of the node. The buckets are indexed by the
+// length of the prefix the ids of their nodes share with the own id.
+type table struct {
+	self ID
+
+	l       sync.Mutex
+	buckets [len(ID{}) * 8][]*node
+}
+
+func newTable(self ID) *table { return &table{self: self} }
+
+func (t *table) bucket(id ID) int {
+	return min(commonPrefixLen(t.self, id), len(t.buckets)-1)
+}
+
+// update records that the node responded or queried this node. It is
+// added if its bucket has room or if it replaces a questionable node.
+func (t *table) update(id ID, addr netip.AddrPort, now time.Time) {
+	if id == t.self || !addr.IsValid() {
+		return
+	}
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	b := &t.buckets[t.bucket(id)]
+	if i := slices.IndexFunc(*b, func(n *node) bool { return n.id == id }); i >= 0 {
+		n := (*b)[i]
+		n.addr, n.lastSeen, n.failures = addr, now, 0
+		// the most recently seen node is the last one.
+		*b = append(slices.Delete(*b, i, i+1), n)
+		return
+	}
+	n := &node{id: id, addr: addr, lastSeen: now}
+	if len(*b) < bucketSize {
+		*b = append(*b, n)
+		return
+	}
+	if i := slices.IndexFunc(*b, func(n *node) bool { return !n.good(now) }); i >= 0 {
+		*b = append(slices.Delete(*b, i, i+1), n)
+	}
+}
+
+// add inserts a node restored from a previous run, unless its bucket is full.
+func (t *table) add(id ID, addr netip.AddrPort) {
+	if id == t.self || !addr.IsValid() {
+		return
+	}
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	b := &t.buckets[t.bucket(id)]
+	if len(*b) < bucketSize && !slices.ContainsFunc(*b, func(n *node) bool { return n.id == id }) {
+		*b = append(*b, &node{id: id, addr: addr})
+	}
+}
+
+// failed records that the node did not respond to a query,
+// removing it after maxFailures.
+func (t *table) failed(id ID) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	b := &t.buckets[t.bucket(id)]
+	i := slices.IndexFunc(*b, func(n *node) bool { return n.id == id })
+	if i < 0 {
+		return
+	}
+	if (*b)[i].failures++; (*b)[i].failures >= maxFailures {
+		*b = slices.Delete(*b, i, i+1)
+	}
+}
+
+// closest returns up to n nodes closest to target.
+func (t *table) closest(target ID, n int) []nodeInfo {
+	all := t.nodes()
+	slices.SortFunc(all, func(a, b nodeInfo) int {
+		switch {
+		case closer(target, a.id, b.id):
+			return -1
+		case closer(target, b.id, a.id):
+			return 1
+		}
+		return 0
+	})
+	return all[:min(n, len(all))]
+}
+
+func (t *table) nodes() []nodeInfo {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	var out []nodeInfo
+	for _, b := range t.buckets {
+		for _, n := range b {
+			out = append(out, nodeInfo{id: n.id, addr: n.addr})
+		}
+	}
+	return out
+}
+
+func (t *table) len() int {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	var n int
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	return n
+}
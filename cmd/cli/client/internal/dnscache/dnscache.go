@@ -0,0 +1,220 @@
+// Package dnscache resolves host names through a cache that honors
+// the TTL of the answers and remembers names that do not exist.
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long the answers of System are cached,
+	// as the TTL of the records is not exposed by the standard library.
+	DefaultTTL = 5 * time.Minute
+	// DefaultNegativeTTL is how long names that do not exist are cached.
+	DefaultNegativeTTL = 30 * time.Second
+	// DefaultMaxTTL bounds the TTL of the answers.
+	DefaultMaxTTL = 1 * time.Hour
+	// maxStale is how long after an answer expired it is still
+	// returned if resolving the name again fails temporarily.
+	maxStale = 1 * time.Hour
+	// pruneThreshold is the number of entries above which expired
+	// entries are removed, to bound the memory of the cache.
+	pruneThreshold = 1024
+)
+
+// Resolver resolves host names to addresses.
+type Resolver interface {
+	// Resolve returns the addresses of host and for how long they may
+	// be cached. A *net.DNSError with IsNotFound set is returned if the
+	// host does not exist.
+	Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+}
+
+// System resolves host names with net.DefaultResolver.
+type System struct{}
+
+func (System) Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, DefaultTTL, nil
+}
+
+// Stats are the metrics of a Cache.
+type Stats struct {
+	// Hits are the lookups answered with cached addresses.
+	Hits int64 `json:"hits"`
+	// NegativeHits are the lookups answered with a cached not found error.
+	NegativeHits int64 `json:"negativeHits"`
+	// Misses are the lookups passed to the underlying resolver.
+	Misses int64 `json:"misses"`
+	// Stale are the lookups answered with expired addresses,
+	// as the underlying resolver failed temporarily.
+	Stale int64 `json:"stale"`
+	// Entries is the number of cached names.
+	Entries int `json:"entries"`
+}
+
+type entry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// Option configures a Cache.
+type Option func(c *Cache)
+
+// WithNegativeTTL sets how long names that do not exist are cached.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(c *Cache) { c.negativeTTL = d }
+}
+
+// WithMaxTTL bounds how long the answers are cached, regardless of their TTL.
+func WithMaxTTL(d time.Duration) Option {
+	return func(c *Cache) { c.maxTTL = d }
+}
+
+// Cache is a Resolver caching the answers of another Resolver.
+// It is safe for concurrent use.
+type Cache struct {
+	resolver    Resolver
+	negativeTTL time.Duration
+	maxTTL      time.Duration
+	dialer      net.Dialer
+	now         func() time.Time
+
+	l       sync.Mutex
+	entries map[string]*entry
+
+	hits, negativeHits, misses, stale atomic.Int64
+}
+
+// New returns a cache in front of r.
+func New(r Resolver, opts ...Option) *Cache {
+	c := &Cache{
+		resolver:    r,
+		negativeTTL: DefaultNegativeTTL,
+		maxTTL:      DefaultMaxTTL,
+		now:         time.Now,
+		entries:     make(map[string]*entry),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Resolve returns the cached addresses of host while they did not expire,
+// otherwise it resolves the host again. IP addresses are returned as is.
+func (c *Cache) Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, 0, nil
+	}
+
+	now := c.now()
+	c.l.Lock()
+	cached, ok := c.entries[host]
+	c.l.Unlock()
+
+	if ok && now.Before(cached.expires) {
+		if cached.err != nil {
+			c.negativeHits.Add(1)
+			return nil, 0, cached.err
+		}
+		c.hits.Add(1)
+		return cached.addrs, cached.expires.Sub(now), nil
+	}
+
+	c.misses.Add(1)
+	addrs, ttl, err := c.resolver.Resolve(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			c.store(host, &entry{err: err, expires: now.Add(c.negativeTTL)})
+			return nil, 0, err
+		}
+		if ok && cached.err == nil && now.Sub(cached.expires) < maxStale {
+			c.stale.Add(1)
+			return cached.addrs, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	ttl = min(ttl, c.maxTTL)
+	if ttl > 0 && len(addrs) > 0 {
+		c.store(host, &entry{addrs: addrs, expires: now.Add(ttl)})
+	}
+	return addrs, ttl, nil
+}
+
+func (c *Cache) store(host string, e *entry) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.entries[host] = e
+	if len(c.entries) <= pruneThreshold {
+		return
+	}
+	now := c.now()
+	for h, e := range c.entries {
+		if now.Sub(e.expires) >= maxStale || (e.err != nil && !now.Before(e.expires)) {
+			delete(c.entries, h)
+		}
+	}
+}
+
+// Stats returns the metrics of the cache.
+func (c *Cache) Stats() Stats {
+	c.l.Lock()
+	entries := len(c.entries)
+	c.l.Unlock()
+
+	return Stats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Stale:        c.stale.Load(),
+		Entries:      entries,
+	}
+}
+
+// Dial connects to addr, resolving its host through the cache. The
+// resolved addresses are tried in order until a connection succeeds.
+// Its signature matches net.Dialer.DialContext.
+func (c *Cache) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+	addrs, _, err := c.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, a := range addrs {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no addresses for host %q", host)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,174 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver struct {
+	answers map[string][]netip.Addr
+	ttl     time.Duration
+	err     error
+	calls   int
+}
+
+func (f *fakeResolver) Resolve(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	addrs, ok := f.answers[host]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, f.ttl, nil
+}
+
+type fakeClock struct{ now time.Time }
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func newTestCache(r Resolver, opts ...Option) (*Cache, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	c := New(r, opts...)
+	c.now = clock.Now
+	return c, clock
+}
+
+func TestCache_TTL(t *testing.T) {
+	tracker := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	r := &fakeResolver{answers: map[string][]netip.Addr{"tracker.example": tracker}, ttl: time.Minute}
+	c, clock := newTestCache(r)
+
+	addrs, ttl, err := c.Resolve(context.Background(), "tracker.example")
+	assert.NoError(t, err)
+	assert.Equal(t, tracker, addrs)
+	assert.Equal(t, time.Minute, ttl)
+
+	clock.now = clock.now.Add(59 * time.Second)
+	addrs, ttl, err = c.Resolve(context.Background(), "tracker.example")
+	assert.NoError(t, err)
+	assert.Equal(t, tracker, addrs)
+	assert.Equal(t, time.Second, ttl)
+	assert.Equal(t, 1, r.calls)
+
+	// expired answers are resolved again.
+	clock.now = clock.now.Add(time.Second)
+	_, _, err = c.Resolve(context.Background(), "tracker.example")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.calls)
+
+	assert.Equal(t, Stats{Hits: 1, Misses: 2, Entries: 1}, c.Stats())
+}
+
+func TestCache_MaxTTL(t *testing.T) {
+	r := &fakeResolver{answers: map[string][]netip.Addr{"a.example": {netip.MustParseAddr("192.0.2.1")}}, ttl: 24 * time.Hour}
+	c, clock := newTestCache(r, WithMaxTTL(time.Minute))
+
+	_, ttl, err := c.Resolve(context.Background(), "a.example")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	clock.now = clock.now.Add(time.Minute)
+	_, _, err = c.Resolve(context.Background(), "a.example")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.calls)
+
+	// answers without a TTL are not cached.
+	r.ttl = 0
+	c, _ = newTestCache(r)
+	for range 2 {
+		_, _, err = c.Resolve(context.Background(), "a.example")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestCache_Negative(t *testing.T) {
+	r := &fakeResolver{answers: map[string][]netip.Addr{}, ttl: time.Minute}
+	c, clock := newTestCache(r, WithNegativeTTL(10*time.Second))
+
+	for range 3 {
+		_, _, err := c.Resolve(context.Background(), "missing.example")
+		var dnsErr *net.DNSError
+		assert.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+	}
+	assert.Equal(t, 1, r.calls)
+	assert.Equal(t, Stats{NegativeHits: 2, Misses: 1, Entries: 1}, c.Stats())
+
+	// the name is looked up again once the negative entry expired.
+	r.answers["missing.example"] = []netip.Addr{netip.MustParseAddr("192.0.2.7")}
+	clock.now = clock.now.Add(10 * time.Second)
+	addrs, _, err := c.Resolve(context.Background(), "missing.example")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.7")}, addrs)
+	assert.Equal(t, 2, r.calls)
+}
+
+func TestCache_Stale(t *testing.T) {
+	addr := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	r := &fakeResolver{answers: map[string][]netip.Addr{"a.example": addr}, ttl: time.Minute}
+	c, clock := newTestCache(r)
+
+	_, _, err := c.Resolve(context.Background(), "a.example")
+	assert.NoError(t, err)
+
+	// temporary failures are not cached, the expired answer is returned instead.
+	r.err = errors.New("server misbehaving")
+	clock.now = clock.now.Add(2 * time.Minute)
+	got, _, err := c.Resolve(context.Background(), "a.example")
+	assert.NoError(t, err)
+	assert.Equal(t, addr, got)
+	assert.Equal(t, int64(1), c.Stats().Stale)
+
+	clock.now = clock.now.Add(maxStale)
+	_, _, err = c.Resolve(context.Background(), "a.example")
+	assert.ErrorIs(t, err, r.err)
+
+	_, _, err = c.Resolve(context.Background(), "b.example")
+	assert.ErrorIs(t, err, r.err)
+	assert.Equal(t, 4, r.calls)
+}
+
+func TestCache_Dial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	r := &fakeResolver{answers: map[string][]netip.Addr{
+		// the first address refuses the connection.
+		"peer.example": {netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.1")},
+	}, ttl: time.Minute}
+	c, _ := newTestCache(r)
+
+	conn, err := c.Dial(context.Background(), "tcp", net.JoinHostPort("peer.example", port))
+	assert.NoError(t, err)
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+
+	// addresses are dialed without a lookup.
+	conn, err = c.Dial(context.Background(), "tcp", l.Addr().String())
+	assert.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 1, r.calls)
+
+	_, err = c.Dial(context.Background(), "tcp", net.JoinHostPort("missing.example", port))
+	assert.Error(t, err)
+}
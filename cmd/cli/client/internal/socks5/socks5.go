@@ -0,0 +1,237 @@
+// Package socks5 connects to TCP addresses through a SOCKS5 proxy
+// as specified by RFC 1928, with the username and password
+// authentication of RFC 1929.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrProxyUnreachable is returned if the connection to the proxy
+// itself could not be established, as opposed to the proxy failing
+// to connect to the destination.
+var ErrProxyUnreachable = errors.New("socks5 proxy unreachable")
+
+const version = 5
+
+const (
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// replies are the failures reported by the proxy, indexed by their code.
+var replies = [...]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// Dialer connects to addresses through the proxy at ProxyAddr.
+type Dialer struct {
+	// ProxyAddr is the host:port of the proxy.
+	ProxyAddr string
+	// Username and Password authenticate with the proxy, if Username is set.
+	Username, Password string
+	// Forward establishes the connection to the proxy, a net.Dialer if nil.
+	Forward func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// FromURL returns a dialer for the proxy at the URL
+// socks5://[user:pass@]host:port.
+func FromURL(raw string) (*Dialer, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, only socks5 is supported", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("proxy url %q must contain a host and a port", raw)
+	}
+	d := &Dialer{ProxyAddr: u.Host}
+	if u.User != nil {
+		d.Username = u.User.Username()
+		d.Password, _ = u.User.Password()
+		if len(d.Username) > 255 || len(d.Password) > 255 {
+			return nil, errors.New("proxy username and password must not exceed 255 bytes")
+		}
+	}
+	return d, nil
+}
+
+// DialContext connects to addr through the proxy. Host names are
+// resolved by the proxy. Only the tcp networks are supported.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("network %q is not supported through a socks5 proxy", network)
+	}
+
+	forward := d.Forward
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+	conn, err := forward(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProxyUnreachable, err)
+	}
+
+	// the handshake is aborted once ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	err = d.connect(conn, addr)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = errors.Join(err, ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s through proxy %s: %w", addr, d.ProxyAddr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *Dialer) connect(conn net.Conn, addr string) error {
+	req, err := connectRequest(addr)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{methodNoAuth}
+	if d.Username != "" {
+		methods = append(methods, methodUserPass)
+	}
+	if _, err := conn.Write(append([]byte{version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[0] != version {
+		return fmt.Errorf("unexpected proxy version %d", resp[0])
+	}
+	switch resp[1] {
+	case methodNoAuth:
+	case methodUserPass:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case methodNoAcceptable:
+		return errors.New("proxy accepts none of the authentication methods")
+	default:
+		return fmt.Errorf("proxy selected unsupported authentication method %d", resp[1])
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	return readReply(conn)
+}
+
+func (d *Dialer) authenticate(conn net.Conn) error {
+	req := []byte{1, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.New("proxy rejected the username and password")
+	}
+	return nil
+}
+
+// connectRequest encodes the CONNECT command for addr.
+func connectRequest(addr string) ([]byte, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", p)
+	}
+
+	req := []byte{version, cmdConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip = ip.Unmap(); ip.Is4() {
+			req = append(req, atypIPv4)
+		} else {
+			req = append(req, atypIPv6)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name %q too long", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(port)), nil
+}
+
+// readReply reads the reply to the CONNECT command, the bound address is discarded.
+func readReply(conn net.Conn) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != version {
+		return fmt.Errorf("unexpected proxy version %d", hdr[0])
+	}
+	if rep := hdr[1]; rep != 0 {
+		if int(rep) < len(replies) {
+			return errors.New(replies[rep])
+		}
+		return fmt.Errorf("proxy failed with unknown reply %d", rep)
+	}
+
+	var n int
+	switch hdr[3] {
+	case atypIPv4:
+		n = 4
+	case atypIPv6:
+		n = 16
+	case atypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("proxy replied with unknown address type %d", hdr[3])
+	}
+	// the address and the port.
+	_, err := io.CopyN(io.Discard, conn, int64(n+2))
+	return err
+}
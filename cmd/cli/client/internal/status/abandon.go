@@ -0,0 +1,111 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+var (
+	// ErrInvalidPiece is returned for piece indexes that do not belong to the torrent.
+	ErrInvalidPiece = errors.New("piece does not belong to the torrent")
+	// ErrPieceVerified is returned by AbandonPiece for pieces that were downloaded already.
+	ErrPieceVerified = errors.New("piece was downloaded already")
+	// ErrPieceNotAbandoned is returned by ReclaimPiece for pieces that were not abandoned.
+	ErrPieceNotAbandoned = errors.New("piece was not abandoned")
+)
+
+// AbandonPiece stops downloading the piece at index until ReclaimPiece
+// is called for it, e.g. as another client provides it. The requests for
+// the piece that were not answered yet are canceled, and the blocks
+// received for it are discarded. Abandoned pieces are not persisted,
+// they are downloaded again after a restart.
+func (t *TorrentSession) AbandonPiece(index int64) error {
+	if index < 0 || index >= t.meta.NumPieces() {
+		return fmt.Errorf("%w: %d", ErrInvalidPiece, index)
+	}
+	for {
+		if t.have.Check(index) {
+			return fmt.Errorf("%w: %d", ErrPieceVerified, index)
+		}
+		p := t.download.active.get(index)
+		if p == nil {
+			if t.download.active.abandon(index, nil) {
+				break
+			}
+			if t.download.active.state(index) == pieceVerified {
+				return fmt.Errorf("%w: %d", ErrPieceVerified, index)
+			}
+			continue // scheduled meanwhile.
+		}
+
+		p.l.Lock()
+		if p.readingBack {
+			p.l.Unlock()
+			// flushed already, only verified again.
+			return fmt.Errorf("%w: %d", ErrPieceVerified, index)
+		}
+		if !t.download.active.abandon(index, p) {
+			p.l.Unlock()
+			continue // verified or released meanwhile.
+		}
+		t.cancelRequests(p)
+		t.buffers.release(StageReceiving, p.Size)
+		t.downloaded.Add(-p.Downloaded)
+		t.download.waste.abandoned.Add(p.Downloaded)
+		p.l.Unlock()
+		break
+	}
+	t.logger.Info("abandoned piece", slog.Int64("piece", index))
+	return nil
+}
+
+// ReclaimPiece downloads the piece at index again, see AbandonPiece.
+func (t *TorrentSession) ReclaimPiece(index int64) error {
+	if index < 0 || index >= t.meta.NumPieces() {
+		return fmt.Errorf("%w: %d", ErrInvalidPiece, index)
+	}
+	if !t.download.active.reclaim(index) {
+		return fmt.Errorf("%w: %d", ErrPieceNotAbandoned, index)
+	}
+	t.logger.Info("reclaimed piece", slog.Int64("piece", index))
+	select {
+	case t.download.reclaimed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// AbandonedPieces returns the pieces that are abandoned, in ascending order.
+func (t *TorrentSession) AbandonedPieces() []int64 { return t.download.active.abandoned() }
+
+// cancelRequests cancels the requests of p that were not answered
+// yet with the peers they were sent to. The lock of p must be held.
+func (t *TorrentSession) cancelRequests(p *pendingPiece) {
+	for _, r := range p.InFlight {
+		if r.received {
+			continue
+		}
+		for _, addr := range r.peers {
+			v, ok := t.peers.seeders.Load(addr)
+			if !ok {
+				continue // web seeds, or disconnected.
+			}
+			s := v.(*peer.Peer)
+			if s.ConnectionStatus() != peer.ConnectionEstablished {
+				continue
+			}
+			err := s.SendCancel(&messagesv1.Cancel{
+				Index:  r.request.Index,
+				Begin:  r.request.Begin,
+				Length: r.request.Length,
+			})
+			if err != nil {
+				t.logger.Debug("failed to cancel request", slog.String("end_peer", addr), slog.Any("err", err))
+			}
+		}
+	}
+}
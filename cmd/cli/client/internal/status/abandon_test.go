@@ -0,0 +1,74 @@
+package status
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_AbandonPiece(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	pieces := [][]byte{data[:messagesv1.RequestSize], data[messagesv1.RequestSize:]}
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	// the requests for the first piece stay outstanding until it is abandoned.
+	var hold atomic.Bool
+	hold.Store(true)
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true, func(s *stubSeeder) {
+		s.ignore = func(req messagesv1.Request) bool { return req.Index == 0 && hold.Load() }
+	})
+	requested := func() int {
+		n := 0
+		for _, r := range seeder.received() {
+			if r.Index == 0 {
+				n++
+			}
+		}
+		return n
+	}
+
+	tr.download.wg.Add(2)
+	go tr.keepAliveSeeders(seeder.addr)
+	go tr.downloadScheduler()
+	t.Cleanup(tr.CancelDownload)
+
+	assert.Eventually(t, func() bool {
+		return tr.have.Check(1) && requested() > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, tr.AbandonPiece(0))
+	assert.Equal(t, []int64{0}, tr.AbandonedPieces())
+	assert.Nil(t, tr.download.active.get(0))
+	assert.Eventually(t, func() bool {
+		return len(seeder.canceled()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []messagesv1.Cancel{{Index: 0, Length: messagesv1.RequestSize}}, seeder.canceled())
+
+	// not requested again, also once the abandoned request would have timed out.
+	before := requested()
+	time.Sleep(requestTimeout + time.Second)
+	assert.Equal(t, before, requested())
+	assert.False(t, tr.have.Check(0))
+
+	assert.ErrorIs(t, tr.AbandonPiece(1), ErrPieceVerified)
+	assert.ErrorIs(t, tr.AbandonPiece(2), ErrInvalidPiece)
+	assert.ErrorIs(t, tr.ReclaimPiece(1), ErrPieceNotAbandoned)
+
+	hold.Store(false)
+	assert.NoError(t, tr.ReclaimPiece(0))
+	assert.Empty(t, tr.AbandonedPieces())
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("reclaimed piece was not downloaded")
+	}
+	assertPieces(t, tr, pieces)
+	assert.Greater(t, requested(), before)
+}
@@ -0,0 +1,250 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// TrackerStatus is the outcome of the announces of a torrent to its tracker.
+type TrackerStatus struct {
+	// LastAnnounce is the time of the last announce attempt.
+	LastAnnounce time.Time
+	// NextAnnounce is the time at which the next announce is scheduled.
+	NextAnnounce time.Time
+	// Failure is the failure reason the tracker responded
+	// with to the last announce, if any.
+	Failure string
+	// Error describes why the last announce did not reach
+	// the tracker or why its response was invalid, if any.
+	Error string
+	// Warning is the last warning message of the tracker.
+	Warning string
+	// Seeders and Leechers are the number of peers with and
+	// without the entire torrent, as reported by the tracker.
+	Seeders, Leechers int64
+	// Downloaded is the number of completed downloads of the
+	// torrent reported by the tracker, zero if it never did.
+	Downloaded int64
+	// EarlyAnnounces is the number of announces sent before the
+	// interval elapsed, as the torrent ran out of usable peers.
+	EarlyAnnounces int64
+	// NumWant is the number of peers the last announce asked for,
+	// PeersReturned the number of peers the tracker responded with.
+	NumWant       int64
+	PeersReturned int
+	// Skipped are the trackers passed over for the one announced to,
+	// as their URL scheme is not supported, see selectTracker. They
+	// are never contacted and not counted as failed announces.
+	Skipped []string
+}
+
+// String returns a human readable summary, such as
+// "working (34 seeds / 120 peers, 512 downloads)".
+func (s TrackerStatus) String() string {
+	if n := len(s.Skipped); n > 0 {
+		s.Skipped = nil
+		return fmt.Sprintf("%s, %d unsupported trackers skipped", s.String(), n)
+	}
+	switch {
+	case s.LastAnnounce.IsZero():
+		return "not contacted yet"
+	case s.Failure != "":
+		return "failure: " + s.Failure
+	case s.Error != "":
+		return "error: " + s.Error
+	case s.Downloaded > 0:
+		return fmt.Sprintf("working (%d seeds / %d peers, %d downloads)", s.Seeders, s.Leechers, s.Downloaded)
+	default:
+		return fmt.Sprintf("working (%d seeds / %d peers)", s.Seeders, s.Leechers)
+	}
+}
+
+type announceStatus struct {
+	l      sync.Mutex
+	status TrackerStatus
+	// url is the tracker announced to, see selectTracker.
+	url string
+	// numWant is the number of peers the first announce asks for.
+	numWant int64
+	// succeeded is set once an announce succeeded.
+	succeeded bool
+	// baseline are the session counters at the time the started
+	// event was sent, see StartAnnounce.
+	baseline AnnounceCounters
+}
+
+// RecordAnnounce updates the tracker status with the outcome of an
+// announce attempt, next is the time of the next scheduled announce.
+func (t *TorrentSession) RecordAnnounce(resp *tracker.Response, err error, next time.Time) {
+	t.announce.l.Lock()
+	defer t.announce.l.Unlock()
+
+	s := &t.announce.status
+	s.LastAnnounce = t.now()
+	s.NextAnnounce = next
+	s.Failure, s.Error = "", ""
+
+	if err != nil {
+		t.metrics.announceFailures.Add(1)
+	} else {
+		t.metrics.announceSuccesses.Add(1)
+	}
+
+	var failure *tracker.FailureError
+	switch {
+	case errors.As(err, &failure):
+		s.Failure = failure.Reason
+		return
+	case err != nil:
+		s.Error = err.Error()
+		return
+	}
+
+	t.announce.succeeded = true
+	if interval := next.Sub(s.LastAnnounce); interval > 0 {
+		t.peers.dials.setMaxAge(staleAnnounces * interval)
+	}
+	s.PeersReturned = len(resp.Peers)
+	if resp.TrailingBytes > 0 {
+		t.logger.Warn("ignored trailing data in tracker response", slog.Int("bytes", resp.TrailingBytes))
+	}
+	for _, w := range resp.Warnings {
+		t.logger.Warn("ignored oddly typed field in tracker response", slog.String("warning", w))
+	}
+	if resp.WarningMessage != nil {
+		s.Warning = *resp.WarningMessage
+	}
+	if resp.Complete != nil {
+		s.Seeders = *resp.Complete
+	}
+	if resp.Incomplete != nil {
+		s.Leechers = *resp.Incomplete
+	}
+	if resp.Downloaded != nil {
+		s.Downloaded = *resp.Downloaded
+	}
+}
+
+// TrackerStatus returns the outcome of the last announce of the torrent.
+func (t *TorrentSession) TrackerStatus() TrackerStatus {
+	t.announce.l.Lock()
+	defer t.announce.l.Unlock()
+	s := t.announce.status
+	s.Skipped = slices.Clone(s.Skipped)
+	return s
+}
+
+// AnnounceURL returns the tracker the torrent announces to, empty if it
+// has none that is supported, see selectTracker.
+func (t *TorrentSession) AnnounceURL() string { return t.announce.url }
+
+// selectTracker returns the tracker of the torrent to announce to, the
+// announce URL or else the first entry of the announce list, in the
+// order of its tiers, whose scheme is supported. The unsupported ones
+// before it are returned as skipped, such that mixed announce lists of
+// e.g. wss, udp and http trackers proceed to the next entry right away.
+func selectTracker(m *torrent.MetaInfoFile) (url string, skipped []string) {
+	candidates := []string{m.Announce}
+	for _, tier := range m.Tiers() {
+		candidates = append(candidates, tier...)
+	}
+	for _, c := range candidates {
+		switch {
+		case c == "" || slices.Contains(skipped, c):
+		case tracker.Supported(c):
+			return c, skipped
+		default:
+			skipped = append(skipped, c)
+		}
+	}
+	return "", skipped
+}
+
+// RecordEarlyAnnounce counts an announce sent upon Reannounce.
+func (t *TorrentSession) RecordEarlyAnnounce() {
+	t.announce.l.Lock()
+	defer t.announce.l.Unlock()
+	t.announce.status.EarlyAnnounces++
+}
+
+// AnnounceCounters are the transfer counters reported to the tracker.
+type AnnounceCounters struct {
+	// Uploaded and Downloaded are the bytes transferred since the
+	// started event was sent, as the specification requires.
+	Uploaded, Downloaded int64
+	// Left is the number of bytes of the files not yet verified.
+	Left int64
+	// Corrupt is the number of bytes discarded since the started
+	// event as they failed the hash check.
+	Corrupt int64
+}
+
+// StartAnnounce returns the counters of the started event, whose
+// transfers are zero, and makes the counters of the later announces
+// relative to it. It is called each time the started event is sent.
+func (t *TorrentSession) StartAnnounce() AnnounceCounters {
+	t.announce.l.Lock()
+	defer t.announce.l.Unlock()
+	t.announce.baseline = t.sessionCounters()
+	return AnnounceCounters{Left: t.Left()}
+}
+
+// AnnounceCounters returns the counters of an announce following the
+// started event. They never decrease within a session.
+func (t *TorrentSession) AnnounceCounters() AnnounceCounters {
+	t.announce.l.Lock()
+	base := t.announce.baseline
+	t.announce.l.Unlock()
+
+	c := t.sessionCounters()
+	return AnnounceCounters{
+		Uploaded:   max(c.Uploaded-base.Uploaded, 0),
+		Downloaded: max(c.Downloaded-base.Downloaded, 0),
+		Left:       t.Left(),
+		Corrupt:    max(c.Corrupt-base.Corrupt, 0),
+	}
+}
+
+// sessionCounters returns the bytes transferred and discarded since the
+// session started, which unlike Uploaded and Downloaded only increase.
+func (t *TorrentSession) sessionCounters() AnnounceCounters {
+	share := t.ShareStats()
+	return AnnounceCounters{
+		Uploaded:   share.SessionUploaded,
+		Downloaded: share.SessionDownloaded,
+		Corrupt:    t.CorruptBytes(),
+	}
+}
+
+// Left returns the number of bytes of the files of the torrent that lie
+// within pieces not verified yet. Padding files are left out, as they
+// are never downloaded, so it is zero once the torrent is downloaded.
+func (t *TorrentSession) Left() int64 {
+	left := t.meta.WantedBytes()
+	for _, i := range t.have.ExistingPieces() {
+		left -= t.meta.PieceSize(i)
+	}
+	if t.meta.InfoMultiFile == nil {
+		return max(left, 0)
+	}
+	// the padding within the verified pieces was subtracted above.
+	var offset int64
+	for _, f := range t.meta.InfoMultiFile.Files {
+		if f.IsPadding() {
+			for piece := offset / t.meta.PieceLength; piece*t.meta.PieceLength < offset+f.Length; piece++ {
+				if t.have.Check(piece) {
+					left += min((piece+1)*t.meta.PieceLength, offset+f.Length) - max(piece*t.meta.PieceLength, offset)
+				}
+			}
+		}
+		offset += f.Length
+	}
+	return max(left, 0)
+}
@@ -0,0 +1,151 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// assemble builds the files of the torrent within the download directory
+// from the piece files. Empty files are created as well while padding files
+// are not. It is a no-op if the pieces are not stored as files.
+func (t *TorrentSession) assemble() error {
+	if t.files == nil {
+		return nil
+	}
+
+	dir := t.DownloadDir()
+	switch {
+	case t.meta.InfoSingleFile != nil:
+		final, err := os.Create(filepath.Join(dir, t.meta.InfoSingleFile.Name))
+		if err != nil {
+			return fmt.Errorf("failed to create torrent file for merging pieces: %w", err)
+		}
+
+		pieces := &pieceReader{dir: dir, pieces: t.VerifiedPieces()}
+		defer pieces.Close()
+
+		var errAll error
+		copied, err := io.Copy(final, pieces)
+		if err != nil {
+			errAll = errors.Join(errAll, fmt.Errorf("failed to copy pieces to final merging file: %w", err))
+		}
+
+		if err := final.Close(); err != nil {
+			errAll = errors.Join(errAll, err)
+		}
+
+		if copied != t.meta.BytesToDownload() {
+			errAll = errors.Join(errAll, fmt.Errorf("failed to reconstruct torrent from downloaded pieces %d out of %d reconstructed", copied, t.meta.BytesToDownload()))
+		}
+
+		if errAll != nil {
+			return fmt.Errorf("failed to reconstruct downloaded torrent: %w", errAll)
+		}
+	case t.meta.InfoMultiFile != nil:
+		parent := filepath.Join(dir, t.meta.InfoMultiFile.Name)
+		// create parent dir, the download dir does not exist
+		// yet if the torrent has no pieces as all files are empty.
+		if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create parent directory for assembling multi file torrent: %w", err)
+		}
+
+		var errAll error
+		var files []io.Writer
+		for _, fi := range t.meta.InfoMultiFile.Files {
+			if fi.IsPadding() {
+				files = append(files, io.Discard)
+				continue
+			}
+			sub, filename := filepath.Split(fi.Path)
+			if sub != "" {
+				if err := os.MkdirAll(filepath.Join(parent, sub), os.ModePerm); err != nil {
+					errAll = errors.Join(errAll, fmt.Errorf("failed to create parent dir for path %s: %w", fi.Path, err))
+					continue
+				}
+			}
+
+			file, err := os.Create(filepath.Join(parent, sub, filename))
+			if err != nil {
+				errAll = errors.Join(errAll, fmt.Errorf("failed to create torrent file for merging pieces: %w", err))
+				continue
+			}
+			defer file.Close()
+
+			files = append(files, file)
+		}
+
+		if errAll != nil {
+			return fmt.Errorf("failed to reconstruct multi-file torrent: %w", errAll)
+		}
+
+		// the files are cut from the pieces read in order.
+		pieces := &pieceReader{dir: dir, pieces: t.VerifiedPieces()}
+		defer pieces.Close()
+
+		tc := int64(0)
+		for i, fi := range t.meta.InfoMultiFile.Files {
+			w, err := io.CopyN(files[i], pieces, fi.Length)
+			tc += w
+			if err != nil {
+				errAll = errors.Join(errAll, fmt.Errorf("failed to copy pieces to final merging file %s: %w", fi.Path, err))
+				break
+			}
+		}
+
+		if tc != t.meta.BytesToDownload() {
+			errAll = errors.Join(errAll, fmt.Errorf("failed to reconstruct torrent from downloaded pieces %d out of %d reconstructed", tc, t.meta.BytesToDownload()))
+		}
+
+		if errAll != nil {
+			return fmt.Errorf("failed to reconstruct multi-file torrent: %w", errAll)
+		}
+	}
+	return nil
+}
+
+// pieceReader reads the piece files within dir in the order of pieces.
+// Only the file of the current piece is open at a time, so that large
+// torrents do not exhaust the file descriptors of the process.
+type pieceReader struct {
+	dir    string
+	pieces []int64
+	cur    *os.File
+}
+
+func (r *pieceReader) Read(b []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.pieces) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(r.dir, fmt.Sprintf("%v.bin", r.pieces[0])))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open file for piece %v: %w", r.pieces[0], err)
+			}
+			r.cur, r.pieces = f, r.pieces[1:]
+		}
+
+		n, err := r.cur.Read(b)
+		if errors.Is(err, io.EOF) {
+			err = r.Close()
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the file of the current piece.
+func (r *pieceReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
@@ -0,0 +1,30 @@
+package status
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPieceReader(t *testing.T) {
+	dir := t.TempDir()
+	for i, data := range []string{"abc", "", "de", "f"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.bin", i)), []byte(data), 0o644))
+	}
+
+	r := &pieceReader{dir: dir, pieces: []int64{0, 1, 2, 3}}
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(b))
+	assert.NoError(t, r.Close())
+
+	r = &pieceReader{dir: dir, pieces: []int64{0, 7}}
+	b, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, "abc", string(b))
+	assert.NoError(t, r.Close())
+}
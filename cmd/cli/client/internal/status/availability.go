@@ -0,0 +1,76 @@
+package status
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// availability counts the connected peers that have each piece. The
+// counts are updated by the goroutines reading the messages of the peers
+// as their bitfields change, and are read without a lock so that a
+// snapshot of a torrent with many pieces never holds up the scheduler.
+type availability struct {
+	once   sync.Once
+	counts []atomic.Int32
+}
+
+// of returns the counters of the pieces of t, allocated on first use.
+func (a *availability) of(t *TorrentSession) []atomic.Int32 {
+	a.once.Do(func() { a.counts = make([]atomic.Int32, t.meta.NumPieces()) })
+	return a.counts
+}
+
+// updateAvailability adds delta to the counts of pieces, see peer.WithPiecesHandler.
+func (t *TorrentSession) updateAvailability(pieces []int64, delta int) {
+	counts := t.availability.of(t)
+	for _, i := range pieces {
+		if i >= 0 && i < int64(len(counts)) {
+			counts[i].Add(int32(delta))
+		}
+	}
+}
+
+// piecesHandler returns the option that keeps the availability
+// of the pieces up to date with the bitfield of a peer.
+func (t *TorrentSession) piecesHandler() peer.Option {
+	return peer.WithPiecesHandler(t.updateAvailability)
+}
+
+// Availability returns for each piece the number of connected peers that
+// have it. The counts are read one at a time and may mix updates that
+// happen during the call, which only ever moves them by the peers that
+// connected or disconnected meanwhile.
+func (t *TorrentSession) Availability() []int {
+	counts := t.availability.of(t)
+	out := make([]int, len(counts))
+	for i := range counts {
+		out[i] = int(max(counts[i].Load(), 0))
+	}
+	return out
+}
+
+// DistributedCopies returns the number of complete copies of the torrent
+// among the connected peers: the availability of the rarest piece, plus
+// the share of the pieces available more often than it.
+func (t *TorrentSession) DistributedCopies() float64 { return DistributedCopies(t.Availability()) }
+
+// DistributedCopies returns the number of complete copies of a torrent whose
+// pieces are available counts times, see TorrentSession.DistributedCopies.
+func DistributedCopies(counts []int) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	rarest := counts[0]
+	for _, c := range counts[1:] {
+		rarest = min(rarest, c)
+	}
+	var above int
+	for _, c := range counts {
+		if c > rarest {
+			above++
+		}
+	}
+	return float64(rarest) + float64(above)/float64(len(counts))
+}
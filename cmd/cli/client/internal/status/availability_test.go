@@ -0,0 +1,43 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTorrentSession_Availability(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1}, []byte{0x2}, []byte{0x3}, []byte{0x4})
+	assert.Equal(t, []int{0, 0, 0, 0}, tr.Availability())
+	assert.Zero(t, tr.DistributedCopies())
+
+	// two seeders, and a leecher with the first half.
+	tr.updateAvailability([]int64{0, 1, 2, 3}, 1)
+	tr.updateAvailability([]int64{0, 1, 2, 3}, 1)
+	tr.updateAvailability([]int64{0, 1}, 1)
+	assert.Equal(t, []int{3, 3, 2, 2}, tr.Availability())
+	assert.Equal(t, 2.5, tr.DistributedCopies())
+
+	// a seeder disconnects, indexes out of range are ignored.
+	tr.updateAvailability([]int64{0, 1, 2, 3, 4}, -1)
+	assert.Equal(t, []int{2, 2, 1, 1}, tr.Availability())
+	assert.Equal(t, 1.5, tr.DistributedCopies())
+}
+
+func TestDistributedCopies(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		want   float64
+	}{
+		{name: "no pieces", want: 0},
+		{name: "missing piece", counts: []int{0, 1, 1, 1}, want: 0.75},
+		{name: "complete copies", counts: []int{2, 2}, want: 2},
+		{name: "partial copy", counts: []int{4, 3, 3, 3}, want: 3.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DistributedCopies(tt.counts))
+		})
+	}
+}
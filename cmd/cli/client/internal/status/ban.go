@@ -0,0 +1,153 @@
+package status
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// minPeerIDSymbols is the number of distinct bytes the part of a peer
+// id following the client prefix needs at least to be banned, so that
+// the placeholder ids of clients hiding their identity, such as one of
+// zeros, which many honest peers share, are never banned.
+const minPeerIDSymbols = 4
+
+// errBannedLeecher is returned when a leecher connects with a banned peer id.
+var errBannedLeecher = errors.New("peer id is banned, not accepting leecher")
+
+// BannedPeers are the peers a torrent no longer exchanges data with,
+// as they delivered data failing verification too often.
+type BannedPeers struct {
+	// Addrs are the host:port of the banned peers.
+	Addrs []string `json:"addrs"`
+	// PeerIDs are the hex encoded banned peer ids, whose
+	// connections are dropped after the handshake at any address.
+	PeerIDs []string `json:"peerIds"`
+}
+
+// BannedPeers returns the addresses and peer ids the torrent banned, sorted.
+func (t *TorrentSession) BannedPeers() BannedPeers {
+	var out BannedPeers
+	t.peers.banned.Range(func(key, _ any) bool {
+		out.Addrs = append(out.Addrs, key.(string))
+		return true
+	})
+	t.peers.bannedIDs.Range(func(key, _ any) bool {
+		out.PeerIDs = append(out.PeerIDs, hex.EncodeToString([]byte(key.(string))))
+		return true
+	})
+	slices.Sort(out.Addrs)
+	slices.Sort(out.PeerIDs)
+	return out
+}
+
+// stablePeerID reports whether id identifies a single peer well enough
+// to be banned. Ids that are drawn anew for every connection never
+// collect enough strikes, see banContributors, but placeholder ids
+// are shared by unrelated peers.
+func stablePeerID(id string) bool {
+	if len(id) != 20 || id == webSeedID {
+		return false
+	}
+	suffix := id
+	if id[0] == '-' && id[7] == '-' {
+		suffix = id[8:] // Azureus-style client prefix, e.g. -TT0100-.
+	}
+	var seen [256]bool
+	var symbols int
+	for i := range len(suffix) {
+		if !seen[suffix[i]] {
+			seen[suffix[i]] = true
+			symbols++
+		}
+	}
+	return symbols >= minPeerIDSymbols
+}
+
+// isBannedID reports whether connections of peers with id are dropped.
+func (t *TorrentSession) isBannedID(id string) bool {
+	_, ok := t.peers.bannedIDs.Load(id)
+	return ok
+}
+
+// banContributors bans peers that repeatedly contributed to pieces that
+// failed verification. Their peer ids are banned as well, if the same id
+// contributed to as many of them, so that a peer cycling its address is
+// recognized once it handshakes again.
+func (t *TorrentSession) banContributors(e Event) {
+	failed, ok := e.(PieceHashFailed)
+	if !ok {
+		return
+	}
+	var ids []string
+	for _, c := range failed.Contributors {
+		strikes, _ := t.peers.strikes.LoadOrStore(c.Addr, new(atomic.Int64))
+		if strikes.(*atomic.Int64).Add(1) >= maxHashFailures {
+			t.banPeer(c.Addr, "banning peer, contributed to too many pieces that failed verification")
+		}
+		if stablePeerID(c.PeerID) && !slices.Contains(ids, c.PeerID) {
+			ids = append(ids, c.PeerID)
+		}
+	}
+	// strikes of an id count once per piece, even if it was delivered from several addresses.
+	for _, id := range ids {
+		strikes, _ := t.peers.idStrikes.LoadOrStore(id, new(atomic.Int64))
+		if strikes.(*atomic.Int64).Add(1) < maxHashFailures {
+			continue
+		}
+		if _, loaded := t.peers.bannedIDs.LoadOrStore(id, struct{}{}); loaded {
+			continue
+		}
+		t.logger.Warn("banning peer id, contributed to too many pieces that failed verification",
+			slog.String("peer_id", hex.EncodeToString([]byte(id))),
+		)
+		// peers with the id connected at other addresses are banned as well.
+		t.peers.seeders.Range(func(key, value any) bool {
+			if value.(*peer.Peer).Id == id {
+				t.banPeer(key.(string), "banning peer, connected with a banned peer id")
+			}
+			return true
+		})
+	}
+}
+
+// banPeer bans the peer at addr for reason, discards the blocks it delivered
+// for the incomplete pieces and closes the connection to it, if any.
+func (t *TorrentSession) banPeer(addr, reason string) {
+	if _, loaded := t.peers.banned.LoadOrStore(addr, struct{}{}); loaded {
+		return
+	}
+	t.logger.Warn(reason, slog.String("end_peer", addr))
+	// the peer is likely to have corrupted the incomplete pieces too. Their
+	// locks are taken separately, as the failed piece is locked while emitting.
+	go t.discardBlocksFrom(addr)
+	if p, ok := t.peers.seeders.Load(addr); ok {
+		// closing the peer waits for its listener which may be blocked
+		// on delivering a piece to the goroutine emitting this event.
+		go func() {
+			if err := p.(*peer.Peer).Close(); err != nil {
+				t.logger.Debug("failed to close banned peer", slog.Any("err", err))
+			}
+		}()
+	}
+}
+
+// rejectBannedID bans the address of a peer that handshaked with a
+// banned peer id, which is then no longer contacted, and reports
+// whether it did. No blocks were received from the peer yet.
+func (t *TorrentSession) rejectBannedID(addr, id string) bool {
+	if !t.isBannedID(id) {
+		return false
+	}
+	if _, loaded := t.peers.banned.LoadOrStore(addr, struct{}{}); !loaded {
+		t.logger.Info("banning peer, handshaked with a banned peer id",
+			slog.String("end_peer", addr),
+			slog.String("peer_id", hex.EncodeToString([]byte(id))),
+		)
+	}
+	return true
+}
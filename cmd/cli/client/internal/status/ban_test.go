@@ -0,0 +1,55 @@
+package status
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStablePeerID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "-TT0100-a1b2c3d4e5f6", want: true},
+		{id: "M7-2-1--k9s8d7f6g5h4", want: true},
+		{id: "-TS0001-000000000000"},
+		{id: "-TS0001-010101010101"},
+		{id: "00000000000000000000"},
+		{id: "short"},
+		{id: webSeedID},
+		{},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, stablePeerID(tt.id), tt.id)
+	}
+}
+
+func TestTracker_BanPeerID(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1})
+	const poisoner, placeholder = "-XX0100-a1b2c3d4e5f6", "-XX0100-000000000000"
+
+	// strikes of an id count once per piece, whatever its addresses.
+	fail := func(contributors ...Contribution) {
+		tr.banContributors(PieceHashFailed{Contributors: contributors})
+	}
+	fail(Contribution{PeerID: poisoner, Addr: "10.0.0.1:6881"}, Contribution{PeerID: poisoner, Addr: "10.0.0.2:6881"})
+	fail(Contribution{PeerID: poisoner, Addr: "10.0.0.3:6881"}, Contribution{PeerID: placeholder, Addr: "10.0.0.4:6881"})
+	assert.False(t, tr.isBannedID(poisoner))
+	fail(Contribution{PeerID: poisoner, Addr: "10.0.0.4:6881"}, Contribution{PeerID: placeholder, Addr: "10.0.0.4:6881"})
+	assert.True(t, tr.isBannedID(poisoner))
+
+	// placeholder ids are never banned, the address is.
+	fail(Contribution{PeerID: placeholder, Addr: "10.0.0.4:6881"})
+	assert.False(t, tr.isBannedID(placeholder))
+	assert.Equal(t, BannedPeers{
+		Addrs:   []string{"10.0.0.4:6881"},
+		PeerIDs: []string{hex.EncodeToString([]byte(poisoner))},
+	}, tr.BannedPeers())
+
+	// the poisoner handshaking from another address is rejected and banned there.
+	assert.False(t, tr.rejectBannedID("10.0.0.5:6881", placeholder))
+	assert.True(t, tr.rejectBannedID("10.0.0.6:6881", poisoner))
+	assert.Equal(t, []string{"10.0.0.4:6881", "10.0.0.6:6881"}, tr.BannedPeers().Addrs)
+}
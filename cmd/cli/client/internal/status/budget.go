@@ -0,0 +1,96 @@
+package status
+
+import "sync"
+
+// Stage is a step a downloaded piece passes through
+// while its data is held in memory.
+type Stage int
+
+const (
+	// StageReceiving holds the pieces whose blocks are being downloaded.
+	StageReceiving Stage = iota
+	// StageVerifying holds the complete pieces whose hash is being verified.
+	StageVerifying
+	// StageFlushing holds the verified pieces that are being written to storage.
+	StageFlushing
+
+	numStages
+)
+
+// BufferStats are the bytes of piece data held in memory per stage.
+type BufferStats struct {
+	Receiving, Verifying, Flushing int64
+	// Limit is the budget shared by the stages, 0 if unlimited.
+	Limit int64
+	// Peak is the highest number of bytes held at once.
+	Peak int64
+}
+
+// BufferBudget bounds the memory held by piece buffers across the stages
+// of all torrents sharing it. A piece reserves its size once it starts
+// downloading and keeps the reservation while it moves through the
+// stages, so that pieces completing at the same time cannot queue up
+// more data for verification and flushing than the budget allows. New
+// pieces are not started until enough of the reserved bytes are released.
+//
+// The budget only gates admission: handing a piece over to the next
+// stage never blocks, as its bytes were reserved already. The stages are
+// accounted to observe where the buffered bytes are held, see Stats.
+type BufferBudget struct {
+	l      sync.Mutex
+	limit  int64
+	used   int64
+	peak   int64
+	stages [numStages]int64
+}
+
+// NewBufferBudget returns a budget of limit bytes, a
+// non-positive limit does not bound the buffered bytes.
+func NewBufferBudget(limit int64) *BufferBudget {
+	return &BufferBudget{limit: max(limit, 0)}
+}
+
+// reserve reserves n bytes in the receiving stage, it reports false if
+// the budget is exhausted. A single piece larger than the whole budget
+// is admitted if nothing else is buffered, to not stall the download.
+func (b *BufferBudget) reserve(n int64) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	b.peak = max(b.peak, b.used)
+	b.stages[StageReceiving] += n
+	return true
+}
+
+// move hands n reserved bytes over from one stage to another.
+func (b *BufferBudget) move(from, to Stage, n int64) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.stages[from] -= n
+	b.stages[to] += n
+}
+
+// release frees n reserved bytes held in stage s.
+func (b *BufferBudget) release(s Stage, n int64) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.stages[s] -= n
+	b.used -= n
+}
+
+// Stats returns the bytes currently held in each stage, together with
+// the limit and the highest number of bytes held at once so far.
+func (b *BufferBudget) Stats() BufferStats {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return BufferStats{
+		Receiving: b.stages[StageReceiving],
+		Verifying: b.stages[StageVerifying],
+		Flushing:  b.stages[StageFlushing],
+		Limit:     b.limit,
+		Peak:      b.peak,
+	}
+}
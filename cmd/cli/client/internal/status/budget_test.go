@@ -0,0 +1,91 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferBudget(t *testing.T) {
+	b := NewBufferBudget(100)
+	assert.True(t, b.reserve(60))
+	assert.False(t, b.reserve(60), "over budget")
+	assert.True(t, b.reserve(40))
+
+	b.move(StageReceiving, StageVerifying, 60)
+	b.move(StageVerifying, StageFlushing, 60)
+	assert.Equal(t, BufferStats{Receiving: 40, Flushing: 60, Limit: 100, Peak: 100}, b.Stats())
+
+	b.release(StageFlushing, 60)
+	b.release(StageReceiving, 40)
+	assert.True(t, b.reserve(250), "a single piece larger than the budget is admitted")
+	assert.False(t, b.reserve(1))
+	b.release(StageReceiving, 250)
+
+	unlimited := NewBufferBudget(0)
+	assert.True(t, unlimited.reserve(1<<40))
+	assert.True(t, unlimited.reserve(1<<40))
+}
+
+func TestTracker_BufferBudgetBurst(t *testing.T) {
+	const (
+		numPieces   = 24
+		pieceLength = 2 * messagesv1.RequestSize
+		limit       = 3 * pieceLength
+	)
+	data := make([]byte, numPieces*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	var pieces [][]byte
+	for i := range numPieces {
+		pieces = append(pieces, data[i*pieceLength:(i+1)*pieceLength])
+	}
+
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	// slow flushes make the completed pieces pile up.
+	WithStorage(storagetest.NewFlakyStorage(storage.NewMemory(), 1,
+		storagetest.WithWriteFaults(storagetest.Faults{Latency: 20 * time.Millisecond}),
+	))(tr)
+	budget := NewBufferBudget(limit)
+	WithBufferBudget(budget)(tr)
+
+	var seeders []*stubSeeder
+	for range 4 {
+		s := newStubSeeder(t, pieceLength, data, 0, true)
+		seeders = append(seeders, s)
+		tr.download.wg.Add(1)
+		go tr.keepAliveSeeders(s.addr)
+	}
+	assert.Eventually(t, func() bool {
+		connected := 0
+		tr.peers.seeders.Range(func(_, value any) bool {
+			if value.(*peer.Peer).Bitfield.Check(0) {
+				connected++
+			}
+			return true
+		})
+		return connected == len(seeders)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.download.wg.Wait()
+
+	stats := budget.Stats()
+	assert.LessOrEqual(t, stats.Peak, int64(limit))
+	assert.Equal(t, BufferStats{Limit: limit, Peak: stats.Peak}, stats, "all buffers are released")
+	assert.Empty(t, tr.have.MissingPieces())
+}
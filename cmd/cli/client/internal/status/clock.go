@@ -0,0 +1,80 @@
+package status
+
+import "time"
+
+// Clock tells the time and creates the timers of a TorrentSession, so
+// that tests can control how time passes instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker created by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// SystemClock is the Clock of the system, used unless WithClock is passed.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time   { return t.t.C }
+func (t systemTicker) Stop()                 { t.t.Stop() }
+func (t systemTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// now returns the current time of the clock of the session.
+func (t *TorrentSession) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+// since returns the time elapsed since tm on the clock of the session.
+func (t *TorrentSession) since(tm time.Time) time.Duration { return t.now().Sub(tm) }
+
+// newTimer returns a timer of the clock of the session.
+func (t *TorrentSession) newTimer(d time.Duration) Timer {
+	if t.clock == nil {
+		return SystemClock{}.NewTimer(d)
+	}
+	return t.clock.NewTimer(d)
+}
+
+// newTicker returns a ticker of the clock of the session.
+func (t *TorrentSession) newTicker(d time.Duration) Ticker {
+	if t.clock == nil {
+		return SystemClock{}.NewTicker(d)
+	}
+	return t.clock.NewTicker(d)
+}
+
+// sleep pauses the calling goroutine for d on the clock of the session.
+func (t *TorrentSession) sleep(d time.Duration) {
+	timer := t.newTimer(d)
+	defer timer.Stop()
+	<-timer.C()
+}
@@ -0,0 +1,266 @@
+package status
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only passes on Advance.
+type fakeClock struct {
+	l      sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// fakeTimer is a timer, or with a period a ticker, of a fakeClock.
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (c *fakeClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.start(&fakeTimer{clock: c, c: make(chan time.Time, 1)}, d)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), period: d}
+	return fakeTicker{c.start(t, d)}
+}
+
+func (c *fakeClock) start(t *fakeTimer, d time.Duration) *fakeTimer {
+	c.l.Lock()
+	defer c.l.Unlock()
+	t.at = c.now.Add(d)
+	if !t.active {
+		t.active = true
+		c.timers = append(c.timers, t)
+	}
+	return t
+}
+
+// Advance moves the time forward by d and fires the timers and tickers
+// that are due. As with time.Ticker, ticks are dropped for slow readers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			t.at = t.at.Add((c.now.Sub(t.at)/t.period + 1) * t.period)
+		} else {
+			t.active = false
+		}
+	}
+	c.timers = slices.DeleteFunc(c.timers, func(t *fakeTimer) bool { return !t.active })
+}
+
+// waiters returns the number of timers and tickers that did not fire or stop.
+func (c *fakeClock) waiters() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.l.Lock()
+	defer t.clock.l.Unlock()
+	was := t.active
+	t.active = false
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(o *fakeTimer) bool { return o == t })
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	was := t.Stop()
+	t.clock.start(t, d)
+	return was
+}
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+func (t fakeTicker) Reset(d time.Duration) {
+	t.fakeTimer.Stop()
+	t.period = d
+	t.clock.start(t.fakeTimer, d)
+}
+
+// advanceUntil advances clk by step until cond holds.
+func advanceUntil(t *testing.T, clk *fakeClock, step time.Duration, cond func() bool) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		clk.Advance(step)
+		return cond()
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestFakeClock(t *testing.T) {
+	clk := newFakeClock()
+	timer := clk.NewTimer(time.Second)
+	ticker := clk.NewTicker(time.Second)
+
+	clk.Advance(999 * time.Millisecond)
+	assert.Empty(t, timer.C())
+	assert.Empty(t, ticker.C())
+
+	clk.Advance(time.Millisecond)
+	assert.Len(t, timer.C(), 1)
+	assert.Len(t, ticker.C(), 1)
+	assert.Equal(t, 1, clk.waiters())
+
+	// ticks are dropped while the previous one was not received.
+	clk.Advance(3 * time.Second)
+	assert.Len(t, ticker.C(), 1)
+	<-ticker.C()
+	clk.Advance(time.Second)
+	assert.Len(t, ticker.C(), 1)
+
+	ticker.Stop()
+	assert.False(t, timer.Stop())
+	assert.Zero(t, clk.waiters())
+}
+
+func TestTracker_RequestTimeout(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 5)
+	}
+	tr := newTestTracker(t, messagesv1.RequestSize, data)
+	tr.clientID = "-TT0100-000000000000"
+	clk := newFakeClock()
+	tr.clock = clk
+
+	// the first request is never answered.
+	var requests atomic.Int32
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true, func(s *stubSeeder) {
+		s.ignore = func(messagesv1.Request) bool { return requests.Add(1) == 1 }
+	})
+
+	tr.download.wg.Add(2)
+	go tr.keepAliveSeeders(seeder.addr)
+	go tr.downloadScheduler()
+	t.Cleanup(tr.CancelDownload)
+
+	advanceUntil(t, clk, 250*time.Millisecond, func() bool { return len(seeder.received()) == 1 })
+
+	clk.Advance(requestTimeout / 2)
+	assert.Never(t, func() bool { return len(seeder.received()) > 1 }, 50*time.Millisecond, time.Millisecond)
+	assert.Empty(t, seeder.canceled())
+
+	advanceUntil(t, clk, 250*time.Millisecond, func() bool { return tr.have.Check(0) })
+	req := messagesv1.Request{Index: 0, Length: messagesv1.RequestSize}
+	assert.Equal(t, []messagesv1.Request{req, req}, seeder.received())
+	assert.Equal(t, []messagesv1.Cancel{{Index: 0, Length: messagesv1.RequestSize}}, seeder.canceled())
+	assert.Equal(t, int64(1), tr.statsFor(seeder.addr).timeouts.Load())
+}
+
+func TestTracker_RateTicker(t *testing.T) {
+	data := make([]byte, 4096)
+	tr := newTestTracker(t, int64(len(data)), data)
+	clk := newFakeClock()
+	tr.clock = clk
+
+	const addr = "10.0.0.1:6881"
+	stats := tr.statsFor(addr)
+	stats.downloaded.Store(1000)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+	t.Cleanup(tr.CancelDownload)
+
+	// the rates are only updated once a tick elapsed.
+	assert.Eventually(t, func() bool { return clk.waiters() == 2 }, time.Second, time.Millisecond)
+	clk.Advance(rateTick - time.Millisecond)
+	assert.Never(t, func() bool { return stats.rate.Load() != 0 }, 50*time.Millisecond, time.Millisecond)
+
+	advanceUntil(t, clk, rateTick, func() bool { return stats.rate.Load() == 1000 })
+
+	// only the bytes downloaded since the last tick count.
+	stats.downloaded.Add(250)
+	advanceUntil(t, clk, rateTick, func() bool { return stats.rate.Load() == 250 })
+	advanceUntil(t, clk, rateTick, func() bool { return stats.rate.Load() == 0 })
+	assert.Equal(t, int64(1250), stats.last)
+}
+
+func TestTracker_KeepAliveCadence(t *testing.T) {
+	tr, _ := newUploadFixture(t, 1)
+	clk := newFakeClock()
+	tr.clock = clk
+	conn, _ := connectStubLeecher(t, tr)
+	assert.Eventually(t, func() bool { return clk.waiters() == 1 }, time.Second, time.Millisecond)
+
+	clk.Advance(2*time.Minute - time.Second)
+	assert.False(t, receivedKeepAlive(t, conn))
+
+	for range 3 {
+		clk.Advance(time.Second)
+		assert.True(t, receivedKeepAlive(t, conn))
+		clk.Advance(2*time.Minute - time.Second)
+		assert.False(t, receivedKeepAlive(t, conn))
+	}
+}
+
+// receivedKeepAlive reports whether a keep alive message was read from
+// conn within a short time, skipping other messages.
+func receivedKeepAlive(t *testing.T, conn net.Conn) bool {
+	t.Helper()
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	for {
+		msg, err := messagesv1.Identify(conn)
+		if err != nil {
+			assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+			return false
+		}
+		if msg.Type == messagesv1.KeepAliveType {
+			return true
+		}
+	}
+}
+
+func TestRatePicker_Rand(t *testing.T) {
+	candidates := []PeerCandidate{{Addr: "a", Rate: 10}, {Addr: "b", Rate: 20}, {Addr: "c", Rate: 30}}
+	picks := func(seed uint64) []int {
+		p := RatePicker{Rand: rand.New(rand.NewPCG(seed, 0))}
+		out := make([]int, 20)
+		for i := range out {
+			out[i] = p.Pick(candidates)
+		}
+		return out
+	}
+	assert.Equal(t, picks(1), picks(1))
+	assert.NotEqual(t, picks(1), picks(2))
+}
@@ -0,0 +1,46 @@
+package status
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// complete emits TorrentCompleted and then calls the hook of WithOnComplete.
+// It is called once all pieces were flushed, the download directory was
+// moved and the files were assembled, so that they are in place when the
+// handlers run.
+func (t *TorrentSession) complete() {
+	if t.restoredComplete {
+		return
+	}
+	e := TorrentCompleted{
+		Dir:   t.DownloadDir(),
+		Paths: filePaths(t.meta, t.DownloadDir()),
+	}
+	if !t.added.IsZero() {
+		e.Elapsed = t.since(t.added)
+	}
+	t.logger.Info("torrent completed, seeding", slog.Duration("elapsed", e.Elapsed))
+	t.emit(e)
+	if t.onComplete != nil {
+		t.onComplete(e)
+	}
+}
+
+// filePaths returns the paths within dir the files of the torrent
+// are assembled at. Padding files are not assembled.
+func filePaths(mi *torrent.MetaInfoFile, dir string) []string {
+	if mi.InfoMultiFile == nil {
+		return []string{filepath.Join(dir, mi.InfoSingleFile.Name)}
+	}
+	var out []string
+	for _, f := range mi.InfoMultiFile.Files {
+		if f.IsPadding() {
+			continue
+		}
+		out = append(out, filepath.Join(dir, mi.InfoMultiFile.Name, f.Path))
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+// connLimitRetry is the delay before a seeder is contacted
+// again, if no connection was available for it.
+const connLimitRetry = 10 * time.Second
+
+// ConnLimit bounds the peer connections of all torrents sharing it,
+// so that the process does not run out of file descriptors.
+type ConnLimit struct {
+	l     sync.Mutex
+	limit int
+	used  int
+}
+
+// NewConnLimit returns a limit of n connections, a
+// non-positive n does not bound the connections.
+func NewConnLimit(n int) *ConnLimit {
+	return &ConnLimit{limit: max(n, 0)}
+}
+
+// TryAcquire reserves a connection, it reports false if none is left.
+func (c *ConnLimit) TryAcquire() bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.limit > 0 && c.used >= c.limit {
+		return false
+	}
+	c.used++
+	return true
+}
+
+// Release frees a connection reserved by TryAcquire.
+func (c *ConnLimit) Release() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.used--
+}
+
+// Stats returns the number of reserved connections and
+// the limit, which is 0 if the connections are unbounded.
+func (c *ConnLimit) Stats() (used, limit int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.used, c.limit
+}
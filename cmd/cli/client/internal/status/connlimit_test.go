@@ -0,0 +1,105 @@
+package status
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimit(t *testing.T) {
+	c := NewConnLimit(2)
+	assert.True(t, c.TryAcquire())
+	assert.True(t, c.TryAcquire())
+	assert.False(t, c.TryAcquire())
+	c.Release()
+	assert.True(t, c.TryAcquire())
+	used, limit := c.Stats()
+	assert.Equal(t, 2, used)
+	assert.Equal(t, 2, limit)
+
+	unbounded := NewConnLimit(0)
+	for range 100 {
+		assert.True(t, unbounded.TryAcquire())
+	}
+}
+
+func TestTracker_ConnLimit(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.conns = NewConnLimit(1)
+
+	// the stubs never unchoke, so the torrent is not downloaded.
+	first := newStubSeeder(t, int64(len(data)), data, 0, false)
+	second := newStubSeeder(t, int64(len(data)), data, 0, false)
+	tr.download.wg.Add(2)
+	go tr.keepAliveSeeders(first.addr)
+	go tr.keepAliveSeeders(second.addr)
+
+	connected := func() int {
+		var n int
+		tr.peers.seeders.Range(func(_, _ any) bool { n++; return true })
+		return n
+	}
+	assert.Eventually(t, func() bool { return connected() == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, connected(), "connected to more seeders than the limit allows")
+
+	tr.CancelDownload()
+	used, _ := tr.conns.Stats()
+	assert.Zero(t, used, "connections are released once the download stopped")
+}
+
+func TestTracker_FileDescriptorsExhausted(t *testing.T) {
+	data := make([]byte, 4*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	var pieces [][]byte
+	for i := range 4 {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	// every other flush fails as if the process ran out of file descriptors.
+	disk := storage.NewScheduler()
+	t.Cleanup(disk.Close)
+	flaky := storagetest.NewFlakyStorage(storage.NewMemory(), 1,
+		storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 0.5, Err: syscall.EMFILE}),
+	)
+	tr.storage = disk.Wrap(flaky)
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-tr.Failed():
+		t.Fatalf("download failed: %v", tr.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.CancelDownload()
+
+	_, writes := flaky.Injected()
+	assert.Positive(t, writes)
+	assert.Equal(t, int64(writes), disk.Stats().FDRetries)
+	assert.Empty(t, tr.have.MissingPieces())
+	assert.Equal(t, int64(len(pieces)), tr.download.pipeline.verified.Load())
+}
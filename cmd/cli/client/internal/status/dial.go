@@ -0,0 +1,304 @@
+package status
+
+import (
+	"cmp"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// PeerSource is where the address of a peer was learned from.
+type PeerSource int
+
+const (
+	SourceTracker PeerSource = iota
+	SourceDHT
+	// SourcePeerList are the peers of the peer list file, see WithPeerList.
+	SourcePeerList
+)
+
+// Weights of the score by which the seeders waiting for a connection are
+// dialed, once the connections are scarce.
+const (
+	// seedScore is added for peers that had all pieces when last connected,
+	// neededScore scaled by the share of the missing pieces for the others.
+	seedScore   = 100
+	neededScore = 50
+	// unknownNeeded is the share of the missing pieces assumed for peers
+	// not connected to yet, ranking them above those known to be useless.
+	unknownNeeded = 0.2
+	// rateScore is added per doubling of the download rate
+	// the peer delivered when last connected, up to maxRateScore.
+	rateScore    = 2
+	maxRateScore = 40
+	// recentScore is added for peers last connected to now,
+	// decaying to nothing over recentWindow.
+	recentScore  = 10
+	recentWindow = 10 * time.Minute
+)
+
+// staleAnnounces is the number of announce intervals after which the
+// peers the tracker no longer lists are considered gone, and are neither
+// dialed nor retried anymore unless listed again.
+const staleAnnounces = 2
+
+// maxDialCandidates bounds the number of peers remembered by the dial queue,
+// as trackers, the DHT and PEX may list many more than are ever contacted.
+const maxDialCandidates = 4096
+
+// sourceScore prefers the peers listed explicitly over those
+// returned by the tracker, and those over the ones of the DHT.
+var sourceScore = map[PeerSource]float64{
+	SourcePeerList: 20,
+	SourceTracker:  10,
+	SourceDHT:      5,
+}
+
+// peerHistory is what was learned about a peer while connected to it.
+type peerHistory struct {
+	// known is set once the peer was connected to.
+	known bool
+	seed  bool
+	// needed is the share of the missing pieces the peer had.
+	needed float64
+	// rate is the download rate the peer delivered, in bytes per second.
+	rate int64
+	// seen is when the connection to the peer was closed.
+	seen time.Time
+}
+
+// dialCandidate is a seeder that may be connected to.
+type dialCandidate struct {
+	source PeerSource
+	// waiting is set while the candidate waits for a connection,
+	// seq orders the waiting candidates by the time they were listed.
+	waiting bool
+	seq     uint64
+	history peerHistory
+	// listed is when a tracker or the DHT last returned the peer.
+	listed time.Time
+	// added orders the candidates by the time they were first listed.
+	added uint64
+}
+
+// score ranks the candidate, those with higher scores are dialed first.
+func (c *dialCandidate) score(now time.Time) float64 {
+	s := sourceScore[c.source]
+	if !c.history.known {
+		return s + neededScore*unknownNeeded
+	}
+	if c.history.seed {
+		s += seedScore
+	} else {
+		s += neededScore * c.history.needed
+	}
+	if c.history.rate > 1 {
+		s += min(rateScore*math.Log2(float64(c.history.rate)), maxRateScore)
+	}
+	if age := now.Sub(c.history.seen); age < recentWindow {
+		s += recentScore * (1 - float64(age)/float64(recentWindow))
+	}
+	return s
+}
+
+// dialQueue orders the seeders waiting for a connection, so that the
+// best candidates are dialed first once connections are scarce.
+type dialQueue struct {
+	l     sync.Mutex
+	seq   uint64
+	added uint64
+	// candidates holds the seeders of the torrent keyed by address,
+	// kept after they connected to remember their source and history.
+	// Past limit, maxDialCandidates if zero, the oldest candidates that
+	// were never connected to are forgotten.
+	candidates map[string]*dialCandidate
+	limit      int
+	// maxAge is the time after which the candidates of the tracker
+	// that were not listed again are stale, if positive.
+	maxAge time.Duration
+}
+
+// candidate returns the candidate at addr, creating it if needed. The lock must be held.
+func (q *dialQueue) candidate(addr string, source PeerSource) *dialCandidate {
+	if q.candidates == nil {
+		q.candidates = make(map[string]*dialCandidate)
+	}
+	c, ok := q.candidates[addr]
+	if !ok {
+		if len(q.candidates) >= cmp.Or(q.limit, maxDialCandidates) {
+			q.evict()
+		}
+		q.added++
+		c = &dialCandidate{source: source, added: q.added}
+		q.candidates[addr] = c
+	}
+	if sourceScore[source] > sourceScore[c.source] {
+		c.source = source
+	}
+	return c
+}
+
+// evict forgets the oldest candidate that was never connected to, if any.
+// The lock must be held.
+func (q *dialQueue) evict() {
+	var oldest string
+	for addr, c := range q.candidates {
+		if c.history.known {
+			continue
+		}
+		if oldest == "" || c.added < q.candidates[oldest].added {
+			oldest = addr
+		}
+	}
+	if oldest != "" {
+		delete(q.candidates, oldest)
+	}
+}
+
+// wait queues the candidate, if not already waiting. The lock must be held.
+func (q *dialQueue) wait(c *dialCandidate) {
+	if !c.waiting {
+		c.waiting = true
+		q.seq++
+		c.seq = q.seq
+	}
+}
+
+// stale reports whether the candidate was returned by the tracker
+// longer than maxAge ago. Candidates of the peer list, of the DHT only
+// and those added without a source never are. The lock must be held.
+func (q *dialQueue) stale(c *dialCandidate, now time.Time) bool {
+	return q.maxAge > 0 && c.source == SourceTracker && !c.listed.IsZero() && now.Sub(c.listed) > q.maxAge
+}
+
+// setMaxAge sets the age after which the candidates of the tracker are stale.
+func (q *dialQueue) setMaxAge(d time.Duration) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.maxAge = d
+}
+
+// announced records that source returned the peer at addr at now.
+func (q *dialQueue) announced(addr string, source PeerSource, now time.Time) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.candidate(addr, source).listed = now
+}
+
+// reap forgets the candidate at addr if it is stale, so that it is no
+// longer contacted, and reports whether it was.
+func (q *dialQueue) reap(addr string, now time.Time) bool {
+	q.l.Lock()
+	defer q.l.Unlock()
+	c, ok := q.candidates[addr]
+	if !ok || !q.stale(c, now) {
+		return false
+	}
+	delete(q.candidates, addr)
+	return true
+}
+
+// list queues the peer listed by source for a connection.
+func (q *dialQueue) list(addr string, source PeerSource) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.wait(q.candidate(addr, source))
+}
+
+// ahead queues the peer at addr, if not already waiting, and reports
+// whether it is among the free best ranked waiting candidates. Stale
+// candidates do not hold back the others.
+func (q *dialQueue) ahead(addr string, free int, now time.Time) bool {
+	if free <= 0 {
+		return false
+	}
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	c := q.candidate(addr, SourceTracker)
+	q.wait(c)
+
+	score := c.score(now)
+	var better int
+	for _, o := range q.candidates {
+		if !o.waiting || o == c || q.stale(o, now) {
+			continue
+		}
+		if s := o.score(now); s > score || (s == score && o.seq < c.seq) {
+			if better++; better >= free {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// done no longer queues the peer at addr, as it connected or is not contacted anymore.
+func (q *dialQueue) done(addr string) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if c, ok := q.candidates[addr]; ok {
+		c.waiting = false
+	}
+}
+
+// record remembers what was learned about the peer at addr.
+func (q *dialQueue) record(addr string, h peerHistory) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.candidate(addr, SourceTracker).history = h
+}
+
+// freeConns returns the number of connections to seeders that are left
+// within the limit shared by all torrents and the limit of the torrent.
+func (t *TorrentSession) freeConns() int {
+	free := math.MaxInt
+	if used, limit := t.conns.Stats(); limit > 0 {
+		free = limit - used
+	}
+	if n := t.seederLimit(); n > 0 {
+		free = min(free, int(n-t.download.connected.Load()))
+	}
+	return free
+}
+
+// acquireDial reserves a connection to the seeder at addr, if it ranks
+// among the waiting candidates the free connections suffice for.
+func (t *TorrentSession) acquireDial(addr string) bool {
+	if !t.peers.dials.ahead(addr, t.freeConns(), t.now()) {
+		return false
+	}
+	if !t.acquireConn() {
+		return false
+	}
+	t.peers.dials.done(addr)
+	return true
+}
+
+// recordPeer remembers what was learned about the seeder during its
+// connection, which started at since with downloaded bytes received.
+func (t *TorrentSession) recordPeer(p *peer.Peer, since time.Time, downloaded int64) {
+	if p == nil || p.Bitfield == nil {
+		return
+	}
+	now := t.now()
+	h := peerHistory{known: true, seen: now}
+
+	missing := t.have.MissingPieces()
+	var has int
+	for _, idx := range missing {
+		if p.Bitfield.Check(idx) {
+			has++
+		}
+	}
+	if len(missing) > 0 {
+		h.needed = float64(has) / float64(len(missing))
+	}
+	h.seed = int64(len(p.Bitfield.ExistingPieces())) == t.meta.NumPieces()
+	if d := now.Sub(since); d >= time.Second {
+		h.rate = int64(float64(t.statsFor(p.Addr).downloaded.Load()-downloaded) / d.Seconds())
+	}
+	t.peers.dials.record(p.Addr, h)
+}
@@ -0,0 +1,232 @@
+package status
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialCandidate_Score(t *testing.T) {
+	now := time.Now()
+	seen := func(h peerHistory) peerHistory {
+		h.known = true
+		if h.seen.IsZero() {
+			h.seen = now.Add(-time.Hour)
+		}
+		return h
+	}
+
+	// each candidate must rank above the next one.
+	ranked := []struct {
+		name string
+		c    dialCandidate
+	}{
+		{name: "fast seed", c: dialCandidate{source: SourceDHT, history: seen(peerHistory{seed: true, rate: 1 << 20})}},
+		{name: "seed", c: dialCandidate{source: SourceDHT, history: seen(peerHistory{seed: true})}},
+		{name: "most needed pieces", c: dialCandidate{source: SourceTracker, history: seen(peerHistory{needed: 0.9})}},
+		{name: "half the needed pieces recently", c: dialCandidate{source: SourceTracker, history: seen(peerHistory{needed: 0.5, seen: now})}},
+		{name: "half the needed pieces", c: dialCandidate{source: SourceTracker, history: seen(peerHistory{needed: 0.5})}},
+		{name: "peer list", c: dialCandidate{source: SourcePeerList}},
+		{name: "tracker", c: dialCandidate{source: SourceTracker}},
+		{name: "dht", c: dialCandidate{source: SourceDHT}},
+		{name: "no needed pieces", c: dialCandidate{source: SourceDHT, history: seen(peerHistory{})}},
+	}
+	for i := 1; i < len(ranked); i++ {
+		prev, next := ranked[i-1], ranked[i]
+		assert.Greater(t, prev.c.score(now), next.c.score(now), "%s must rank above %s", prev.name, next.name)
+	}
+}
+
+func TestDialQueue_Ahead(t *testing.T) {
+	now := time.Now()
+	q := new(dialQueue)
+	q.list("leecher", SourceTracker)
+	q.list("other", SourceTracker)
+	q.list("seed", SourceDHT)
+	q.record("seed", peerHistory{known: true, seed: true, seen: now})
+
+	tests := []struct {
+		name string
+		addr string
+		free int
+		want bool
+	}{
+		{name: "no connections", addr: "seed", free: 0, want: false},
+		{name: "seed first", addr: "seed", free: 1, want: true},
+		{name: "leecher behind seed", addr: "leecher", free: 1, want: false},
+		{name: "leecher with enough connections", addr: "leecher", free: 2, want: true},
+		{name: "listing order among equals", addr: "other", free: 2, want: false},
+		{name: "unlisted peers are queued last", addr: "unlisted", free: 3, want: false},
+		{name: "everyone", addr: "unlisted", free: 4, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, q.ahead(tt.addr, tt.free, now))
+		})
+	}
+
+	// candidates no longer waiting do not hold back the others.
+	q.done("seed")
+	q.done("leecher")
+	assert.True(t, q.ahead("other", 1, now))
+}
+
+func TestDialQueue_Limit(t *testing.T) {
+	q := &dialQueue{limit: 3}
+	q.list("seed", SourceTracker)
+	q.record("seed", peerHistory{known: true, seed: true})
+	q.list("first", SourceTracker)
+	q.list("second", SourcePeerList)
+	q.list("third", SourceDHT)
+
+	// the oldest untried candidate is forgotten, not the known seed.
+	assert.Len(t, q.candidates, 3)
+	assert.Contains(t, q.candidates, "seed")
+	assert.NotContains(t, q.candidates, "first")
+
+	// listing a remembered candidate again evicts no one.
+	q.list("second", SourceTracker)
+	assert.Len(t, q.candidates, 3)
+	assert.Contains(t, q.candidates, "third")
+}
+
+func TestDialQueue_Stale(t *testing.T) {
+	now := time.Now()
+	q := &dialQueue{maxAge: 2 * time.Minute}
+	// the seed was listed by the last announces only.
+	q.announced("seed", SourceTracker, now.Add(-3*time.Minute))
+	q.list("seed", SourceTracker)
+	q.record("seed", peerHistory{known: true, seed: true, seen: now})
+	q.announced("fresh", SourceTracker, now)
+	q.list("fresh", SourceTracker)
+	q.announced("static", SourcePeerList, now.Add(-time.Hour))
+	q.list("static", SourcePeerList)
+	q.announced("dht", SourceDHT, now.Add(-time.Hour))
+	q.list("dht", SourceDHT)
+	q.list("unlisted", SourceTracker)
+
+	assert.True(t, q.ahead("fresh", 3, now), "stale seed holds back fresh peer")
+	assert.False(t, q.reap("fresh", now))
+	assert.False(t, q.reap("static", now))
+	assert.False(t, q.reap("dht", now))
+	assert.False(t, q.reap("unlisted", now))
+	assert.True(t, q.reap("seed", now))
+	assert.NotContains(t, q.candidates, "seed")
+
+	// listed again, the peer is fresh.
+	q.announced("fresh", SourceTracker, now.Add(2*time.Minute))
+	assert.False(t, q.reap("fresh", now.Add(3*time.Minute)))
+}
+
+// peersResponse returns a tracker response listing the peers at addrs.
+func peersResponse(t *testing.T, addrs ...string) *tracker.Response {
+	t.Helper()
+
+	resp := new(tracker.Response)
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		assert.NoError(t, err)
+		n, err := strconv.ParseInt(port, 10, 64)
+		assert.NoError(t, err)
+		resp.Peers = append(resp.Peers, struct {
+			PeerID string
+			IP     string
+			Port   int64
+		}{IP: host, Port: n})
+	}
+	return resp
+}
+
+func TestTracker_StalePeers(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	clk := newFakeClock()
+	tr.clock = clk
+	tr.download.reconnect = reconnectPolicy{interval: time.Second, max: time.Second, maxHandshakeFailures: 5}
+	t.Cleanup(tr.CancelDownload)
+
+	// the peer of the first announce is gone.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	gone := ln.Addr().String()
+	ln.Close()
+
+	first := peersResponse(t, gone)
+	tr.RecordAnnounce(first, nil, clk.Now().Add(time.Minute))
+	assert.NoError(t, tr.AddPeers(SourceTracker, first))
+
+	// the following announces list another peer, which never unchokes.
+	seeder := newStubSeeder(t, int64(len(data)), data, 0, false)
+	for range 3 {
+		clk.Advance(time.Minute)
+		second := peersResponse(t, seeder.addr)
+		tr.RecordAnnounce(second, nil, clk.Now().Add(time.Minute))
+		assert.NoError(t, tr.AddPeers(SourceTracker, second))
+	}
+	advanceUntil(t, clk, time.Second, func() bool {
+		_, connecting := tr.peers.connecting.Load(gone)
+		return !connecting
+	})
+	tr.peers.dials.l.Lock()
+	assert.NotContains(t, tr.peers.dials.candidates, gone)
+	assert.Contains(t, tr.peers.dials.candidates, seeder.addr)
+	tr.peers.dials.l.Unlock()
+	_, connecting := tr.peers.connecting.Load(seeder.addr)
+	assert.True(t, connecting)
+
+	// listed again, the peer is contacted again.
+	assert.NoError(t, tr.AddPeers(SourceTracker, first))
+	_, connecting = tr.peers.connecting.Load(gone)
+	assert.True(t, connecting)
+
+	// only usable peers keep the torrent from requesting an early announce.
+	tr.checkStarvation(clk.Now())
+	tr.checkStarvation(clk.Now().Add(starvationTimeout))
+	select {
+	case <-tr.Reannounce():
+	default:
+		t.Error("early announce was not requested")
+	}
+}
+
+func TestTracker_DialsSeedsFirst(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.conns = NewConnLimit(1)
+
+	// the stubs never unchoke, so the torrent is not downloaded.
+	leecher := newStubSeeder(t, int64(len(data)), data, 0, false)
+	seed := newStubSeeder(t, int64(len(data)), data, 0, false)
+	// the seed is known from an earlier connection.
+	tr.peers.dials.record(seed.addr, peerHistory{known: true, seed: true, seen: time.Now()})
+
+	resp := new(tracker.Response)
+	for _, s := range []*stubSeeder{leecher, seed} {
+		host, port, err := net.SplitHostPort(s.addr)
+		assert.NoError(t, err)
+		n, err := strconv.ParseInt(port, 10, 64)
+		assert.NoError(t, err)
+		resp.Peers = append(resp.Peers, struct {
+			PeerID string
+			IP     string
+			Port   int64
+		}{IP: host, Port: n})
+	}
+	assert.NoError(t, tr.UpdateSeeders(resp))
+	t.Cleanup(tr.CancelDownload)
+
+	assert.Eventually(t, func() bool {
+		_, ok := tr.peers.seeders.Load(seed.addr)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "seed was not connected")
+	time.Sleep(200 * time.Millisecond)
+	_, ok := tr.peers.seeders.Load(leecher.addr)
+	assert.False(t, ok, "leecher listed first took the only connection")
+}
@@ -4,35 +4,99 @@ import (
 	"bytes"
 	"cmp"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand/v2"
-	"net"
+	"math"
 	"slices"
 	"time"
 
 	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
 	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
 )
 
-func (t *Tracker) CancelDownload()                      { close(t.download.cancel); t.download.wg.Wait() }
-func (t *Tracker) WaitUntilDownloaded() <-chan struct{} { return t.download.completed }
+// requestTimeout is the time after which a request that was not answered
+// is sent again, possibly to another peer. Requests discarded by a peer
+// that chokes this client are re-queued right away, see requeueChoked.
+// Requests are queued to the writer of the peer, see peer.QueueRequest,
+// and the time they wait in its queue counts toward the timeout.
+const requestTimeout = 8 * time.Second
+
+func (t *TorrentSession) WaitUntilDownloaded() <-chan struct{} { return t.download.completed }
+
+// canceled returns a channel that is closed once the running
+// download is canceled.
+func (t *TorrentSession) canceled() <-chan struct{} { return t.download.cancel.Load().canceled }
+
+// CancelDownload stops downloading the torrent and waits for the
+// download goroutines to return.
+func (t *TorrentSession) CancelDownload() {
+	// a queued download must not be started by the coordinator anymore.
+	t.coordinator.leave(t)
+	t.download.cancel.Load().cancel()
+	t.download.wg.Wait()
+}
+
+// UpdateSeeders connects to the seeders returned by the tracker.
+func (t *TorrentSession) UpdateSeeders(resp *tracker.Response) error {
+	return t.AddPeers(SourceTracker, resp)
+}
 
-func (t *Tracker) UpdateSeeders(resp *tracker.Response) error {
-	if t.Downloaded.Load() == t.Torrent.BytesToDownload() {
+// AddPeers connects to the seeders learned from source. Once connections
+// are scarce, the best ranked seeders are connected to first.
+func (t *TorrentSession) AddPeers(source PeerSource, resp *tracker.Response) error {
+	if t.downloaded.Load() == t.meta.BytesToDownload() {
+		return nil
+	}
+	if t.queued.Load() {
+		// contacted once the torrent is dequeued.
+		t.download.queuedPeers.Store(resp)
 		return nil
 	}
 
 	var errAll error
 
+	now := t.now()
+	var addrs []string
 	for _, r := range resp.Peers {
-		addr := net.JoinHostPort(r.IP, fmt.Sprint(r.Port))
+		addr, ok := peerAddr(r.IP, r.Port)
+		if !ok {
+			t.logger.Debug("skipping peer with invalid address", slog.String("ip", r.IP), slog.Int64("port", r.Port))
+			continue
+		}
+		if (r.PeerID != "" && r.PeerID == t.clientID) || t.isSelf(addr) {
+			t.logger.Debug("skipping peer, as it is this client", slog.String("addr", addr))
+			continue
+		}
+		if r.PeerID != "" && t.isBannedID(r.PeerID) {
+			t.logger.Debug("skipping peer with banned peer id", slog.String("addr", addr))
+			continue
+		}
 		t.logger.Debug("initiating connection to peer", slog.String("addr", addr))
+		if _, ok := t.peers.banned.Load(addr); ok {
+			t.logger.Debug("skipping banned peer", slog.String("addr", addr))
+			continue
+		}
+		if t.isBlocked(addr) {
+			t.logger.Debug("skipping blocked peer", slog.String("addr", addr))
+			continue
+		}
+		t.peers.dials.announced(addr, source, now)
 		if _, ok := t.peers.seeders.Load(addr); ok {
 			continue
 		}
+		if _, ok := t.peers.connecting.LoadOrStore(addr, struct{}{}); ok {
+			continue // already being contacted, possibly backing off.
+		}
+		t.peers.dials.list(addr, source)
+		addrs = append(addrs, addr)
+	}
 
+	// all seeders are queued before any is dialed, so that the
+	// first ones listed do not take the connections of better ones.
+	for _, addr := range addrs {
 		t.download.wg.Add(1)
 		go t.keepAliveSeeders(addr)
 	}
@@ -40,173 +104,157 @@ func (t *Tracker) UpdateSeeders(resp *tracker.Response) error {
 	return errAll
 }
 
-func (t *Tracker) downloadScheduler() {
+func (t *TorrentSession) downloadScheduler() {
 	defer t.download.wg.Done()
+	defer t.coordinator.leave(t)
 
-	unverified := make(map[uint32]struct{})
-	for _, i := range t.BitField.MissingPieces() {
-		unverified[i] = struct{}{}
-	}
+	t.download.active.reset(t.meta.NumPieces(), t.have.ExistingPieces())
 
-	currentRate := int64(0)
-	rateTicker := time.NewTicker(rateTick)
+	rateTicker := t.newTicker(rateTick)
+	defer rateTicker.Stop()
 	for {
 		select {
 		case <-t.stop:
 			t.logger.Info("shutting down piece downloader, closed tracker")
+			t.releaseActive()
 			return
-		case <-t.download.cancel:
+		case <-t.canceled():
 			t.logger.Info("shutting down piece downloader, canceled download")
+			t.releaseActive()
 			return
-		case <-rateTicker.C:
-			newRate := t.Downloaded.Load()
-			diff := max(0, newRate-currentRate)
-			t.download.rate.Store(diff)
-			currentRate = newRate
+		case <-rateTicker.C():
+			t.updatePeerRates()
+			t.updatePipelineRates()
+			t.updateSnubbed(t.outstandingRequests(), t.now())
+			t.checkStarvation(t.now())
+			t.checkSync(t.now())
 		default:
-			freeSlots := 0
-			for i := range t.download.requests {
-				p := t.download.requests[i].Load()
-				if p == nil {
-					freeSlots++
-					continue
+			seeders := make(map[string]*peer.Peer)
+			var peers []stepPeer
+			t.peers.seeders.Range(func(_, value any) bool {
+				p := value.(*peer.Peer)
+				if p.ConnectionStatus() == peer.ConnectionEstablished {
+					seeders[p.Addr] = p
+					peers = append(peers, t.stepPeerOf(p))
 				}
+				return true
+			})
 
+			outstanding := t.outstandingRequests()
+			for _, p := range t.download.active.snapshot() {
 				p.l.Lock()
-
-				// reschedule long running requests.
-				for send := 0; send < len(p.InFlight); send++ {
-					if req := p.InFlight[send]; !req.received && time.Since(req.send) > 8*time.Second {
-						t.peers.seeders.Range(func(_, value any) bool {
-							p := value.(*peer.Peer)
-							canCancel := p.ConnectionStatus() == peer.ConnectionEstablished
-							canCancel = canCancel && p.Status.Remote.Load() == uint32(peer.UnChoked)
-							if canCancel {
-								err := p.SendCancel(&messagesv1.Cancel{
-									Index:  req.request.Index,
-									Begin:  req.request.Begin,
-									Length: req.request.Length,
-								})
-								if err != nil {
-									t.logger.Error("failed to cancel request",
-										slog.Any("err", err),
-										slog.String("end_peer", p.Id),
-										slog.String("req", fmt.Sprintf("%#v", req)),
-									)
-								}
-							}
-							return true
-						})
-
-						p.Pending = append(p.Pending, &messagesv1.Request{
-							Index:  req.request.Index,
-							Begin:  req.request.Begin,
-							Length: req.request.Length,
-						})
-						p.InFlight[send] = nil
-					}
+				if t.download.active.get(p.Index) != p {
+					p.l.Unlock()
+					continue // abandoned or released meanwhile.
 				}
-				p.InFlight = slices.DeleteFunc(p.InFlight, func(r *timedDownloadRequest) bool { return r == nil })
-
-				// schedule pending requests to peers.
-				for send := 0; send < len(p.Pending); send++ {
-					piece := p.Pending[send]
-					// select peer to contact for piece.
-					var peers []*peer.Peer
-
-					t.peers.seeders.Range(func(_, value any) bool {
-						p := value.(*peer.Peer)
-						canRequest := p.ConnectionStatus() == peer.ConnectionEstablished
-						canRequest = canRequest && p.Status.Remote.Load() == uint32(peer.UnChoked)
-						canRequest = canRequest && p.Bitfield.Check(piece.Index)
-						if canRequest {
-							peers = append(peers, p)
-						}
-						return true
-					})
-
-					if len(peers) == 0 {
-						t.logger.Debug("no peers online that contain needed piece",
-							slog.String("piece", fmt.Sprint(piece.Index)),
-							slog.String("req", fmt.Sprintf("%#v", piece)),
-						)
-						continue
-					}
-
-					chosen := rand.IntN(len(peers))
-					t.logger.Debug("sending request for piece",
-						slog.String("end_peer", peers[chosen].Id),
-						slog.String("req", fmt.Sprintf("%#v", piece)),
-					)
-
-					if err := peers[chosen].SendRequest(piece); err != nil {
-						t.logger.Error("failed to issue request",
-							slog.Any("err", err),
-							slog.String("end_peer", peers[chosen].Id),
-							slog.String("req", fmt.Sprintf("%#v", piece)),
-						)
-						continue
-					}
-
-					p.Pending[send] = nil
-					p.InFlight = append(p.InFlight, &timedDownloadRequest{
-						request: *piece,
-						send:    time.Now(),
-					})
+				piece := t.stepPieceOf(p)
+				actions := step(stepInput{
+					Now:         t.now(),
+					Peers:       peers,
+					Pieces:      []stepPiece{piece},
+					Outstanding: outstanding,
+					MaxPipeline: int(t.download.maxPipeline.Load()),
+				}, t.download.picker)
+				for _, a := range actions {
+					t.execute(p, piece, a, seeders, outstanding)
 				}
+				p.InFlight = slices.DeleteFunc(p.InFlight, func(r *timedDownloadRequest) bool { return r == nil })
 				p.Pending = slices.DeleteFunc(p.Pending, func(r *messagesv1.Request) bool { return r == nil })
 				p.l.Unlock()
 			}
 
-			if len(unverified) == 0 { // we can't process any new pieces, wait for pending to finish.
-				if freeSlots == len(t.download.requests) {
+			// abandoned pieces are not downloaded until they are reclaimed.
+			remaining := t.download.active.remaining()
+			t.endgame(int(remaining))
+
+			if remaining == 0 { // we can't process any new pieces, wait for pending to finish.
+				if t.download.active.len() == 0 {
 					t.logger.Info("Downloaded all pieces shutting down piece downloader")
+					// all pieces are made durable before the torrent completes.
+					if t.durability.unsynced.Load() > 0 {
+						if err := t.saveResume(); err != nil {
+							t.logger.Error("failed to persist resume data", slog.Any("err", err))
+						}
+					}
+					if t.moveTo != "" {
+						t.moveDownload()
+					}
+					// the torrent completes once its files are in place, torrents
+					// that were complete when restored keep seeding their pieces.
+					if err := t.assemble(); err != nil {
+						if !t.restoredComplete {
+							t.fail(err)
+							return
+						}
+						t.logger.Error("failed to assemble restored torrent", slog.Any("err", err))
+					}
 					close(t.download.completed)
+					t.complete()
 					return
 				}
-				time.Sleep(250 * time.Millisecond)
+				t.sleep(250 * time.Millisecond)
 				continue
 			}
 
-			slot := -1
-			for i := range t.download.requests {
-				if t.download.requests[i].Load() == nil {
-					slot = i
-					break
-				}
-			}
-			if slot < 0 {
+			if t.download.active.full() {
 				// no free slot
-				time.Sleep(250 * time.Millisecond)
+				t.sleep(250 * time.Millisecond)
 				continue
 			}
 
-			index := int64(-1)
-			// find the next missing piece that can be downloaded
-			for unverified := range unverified {
-				t.peers.seeders.Range(func(_, value any) bool {
-					p := value.(*peer.Peer)
-					if p.Bitfield.Check(unverified) {
-						index = int64(unverified)
-						return false
+			// the missing pieces not held by a slot, from a random one.
+			missing := func(yield func(int64) bool) {
+				from := int64(intN(t.rand, int(t.meta.NumPieces())))
+				for i := range t.download.active.unscheduled(from) {
+					if t.have.Check(i) {
+						// verified since the scheduler started, e.g. by a recheck.
+						t.download.active.settle(i)
+						continue
 					}
-					return true
-				})
+					if !yield(i) {
+						return
+					}
+				}
+			}
+			index := int64(-1)
+			counts := t.availability.of(t)
+			actions := step(stepInput{
+				Now:         t.now(),
+				Peers:       peers,
+				Outstanding: outstanding,
+				MaxPipeline: int(t.download.maxPipeline.Load()),
+				Missing:     missing,
+				FreeSlot:    true,
+				WebSeed:     t.webSeedAvailable(),
+				Startable: func(i int64) bool {
+					return !t.have.Check(i) && t.download.active.state(i) == pieceUnscheduled
+				},
+				Availability: func(i int64) int { return int(counts[i].Load()) },
+			}, t.download.picker)
+			for _, a := range actions {
+				if a.Kind == actionStart {
+					index = a.Piece
+				}
 			}
 
 			if index < 0 {
 				// no peers available for any piece to download
-				time.Sleep(5 * time.Second)
+				idle := t.newTimer(5 * time.Second)
+				select {
+				case <-t.stop:
+				case <-t.canceled():
+				case <-t.download.reclaimed:
+				case <-idle.C():
+				}
+				idle.Stop()
 				continue
 			}
 
-			pieceStart := index * t.Torrent.PieceLength
-			pieceEnd := pieceStart + t.Torrent.PieceLength
-			pieceEnd = min(pieceEnd, t.Torrent.BytesToDownload())
-			pieceSize := pieceEnd - pieceStart
+			pieceSize := t.meta.PieceSize(index)
 
 			pending := &pendingPiece{
-				Index:      uint32(index),
+				Index:      index,
+				Attempt:    1,
 				Downloaded: 0,
 				Size:       pieceSize,
 				Received:   nil,
@@ -214,63 +262,290 @@ func (t *Tracker) downloadScheduler() {
 				InFlight:   nil,
 			}
 
-			for p := int64(0); p < pieceSize; {
+			var err error
+			for p := int64(0); p < pieceSize && err == nil; {
 				nextBlockSize := int64(messagesv1.RequestSize)
 				if pieceSize < p+nextBlockSize {
 					nextBlockSize = pieceSize - p
 				}
 
-				pending.Pending = append(pending.Pending, &messagesv1.Request{
-					Index:  pending.Index,
-					Begin:  uint32(p),
-					Length: uint32(nextBlockSize),
-				})
+				var req *messagesv1.Request
+				if req, err = messagesv1.NewRequest(pending.Index, uint32(p), uint32(nextBlockSize)); err == nil {
+					pending.Pending = append(pending.Pending, req)
+				}
 
 				p += nextBlockSize
 			}
+			if err != nil {
+				// never happens for torrents that passed validation.
+				t.logger.Error("piece cannot be requested, abandoning", slog.Int64("piece", index), slog.Any("err", err))
+				t.download.active.abandon(index, nil)
+				continue
+			}
+
+			if !t.buffers.reserve(pieceSize) {
+				// wait for buffered pieces to be verified and flushed.
+				t.sleep(250 * time.Millisecond)
+				continue
+			}
 
-			if !t.download.requests[slot].CompareAndSwap(nil, pending) {
+			if !t.download.active.add(pending) {
+				t.buffers.release(StageReceiving, pieceSize)
 				continue // slot was taken away.
 			}
+		}
+	}
+}
 
-			delete(unverified, uint32(index))
+// releaseActive stops tracking the pieces that are downloaded and
+// releases their buffers, once no more blocks will be scheduled.
+func (t *TorrentSession) releaseActive() {
+	for _, p := range t.download.active.snapshot() {
+		p.l.Lock()
+		if p.readingBack {
+			// released by readBack, unless it verified the piece.
+			p.l.Unlock()
+			continue
 		}
+		if t.download.active.remove(p) {
+			t.buffers.release(StageReceiving, p.Size)
+			// the received blocks are downloaded again once resumed.
+			t.downloaded.Add(-p.Downloaded)
+			t.download.waste.shutdown.Add(p.Downloaded)
+		}
+		p.l.Unlock()
 	}
 }
 
-func (t *Tracker) recvPieces(logger *slog.Logger, pieces <-chan *messagesv1.Piece) {
-	defer t.download.wg.Done()
+// endgame requests the outstanding blocks redundantly from idle peers
+// if the endgame policy decides that duplication is cheaper than waiting.
+func (t *TorrentSession) endgame(unassigned int) {
+	if unassigned > 0 {
+		return // avoid building the snapshot while there are pieces left to assign.
+	}
+
+	snapshot := endgameSnapshot{
+		Unassigned:  unassigned,
+		Outstanding: make(map[string]int64),
+		Rates:       make(map[string]int64),
+	}
+
+	active := t.download.active.snapshot()
+	for _, p := range active {
+
+		p.l.Lock()
+		snapshot.Remaining += len(p.Pending)
+		for _, r := range p.InFlight {
+			if r.received {
+				continue
+			}
+			snapshot.Remaining++
+			for _, addr := range r.peers {
+				snapshot.Outstanding[addr] += int64(r.request.Length)
+			}
+		}
+		p.l.Unlock()
+	}
+
+	var candidates []*peer.Peer
+	t.peers.seeders.Range(func(_, value any) bool {
+		p := value.(*peer.Peer)
+		canRequest := p.ConnectionStatus() == peer.ConnectionEstablished
+		canRequest = canRequest && p.Status.Remote.Load() == uint32(peer.UnChoked)
+		canRequest = canRequest && !t.isSnubbed(p.Addr)
+		if canRequest {
+			rate := t.peerRate(p.Addr)
+			snapshot.Rates[p.Addr] = rate
+			if _, busy := snapshot.Outstanding[p.Addr]; !busy {
+				snapshot.FastestIdle = max(snapshot.FastestIdle, rate)
+			}
+			candidates = append(candidates, p)
+		}
+		return true
+	})
+
+	if !shouldEnterEndgame(snapshot, t.download.endgameBlocks) {
+		return
+	}
+
+	// prefer the fastest peers for the duplicate requests.
+	slices.SortFunc(candidates, func(a, b *peer.Peer) int {
+		return cmp.Compare(snapshot.Rates[b.Addr], snapshot.Rates[a.Addr])
+	})
+
+	for _, p := range active {
+		p.l.Lock()
+		if t.fallbackToWebSeed(p) {
+			p.l.Unlock()
+			continue // peers already served corrupt data for the piece.
+		}
+		for _, r := range p.InFlight {
+			if r.received || len(r.peers) > 1 {
+				continue // already duplicated.
+			}
+			for _, c := range candidates {
+				if slices.Contains(r.peers, c.Addr) || !c.Bitfield.Check(r.request.PieceIndex()) {
+					continue
+				}
+				req := r.request
+				chokes := c.ChokeCount()
+				if err := c.QueueRequest(&req); err != nil {
+					t.logger.Debug("failed to issue endgame request",
+						slog.Any("err", err),
+						slog.String("end_peer", c.Id),
+					)
+					continue
+				}
+				t.logger.Debug("sent endgame request",
+					slog.String("end_peer", c.Id),
+					slog.String("req", fmt.Sprintf("%#v", req)),
+				)
+				if snapshot.Outstanding[c.Addr] == 0 {
+					t.statsFor(c.Addr).lastBlock.Store(t.now().UnixNano())
+				}
+				snapshot.Outstanding[c.Addr] += int64(req.Length)
+				r.peers = append(r.peers, c.Addr)
+				r.chokes = append(r.chokes, chokes)
+				break
+			}
+		}
+		p.l.Unlock()
+	}
+}
+
+// cancelDuplicates cancels the request at the peers, other than from,
+// it was redundantly sent to during the endgame.
+func (t *TorrentSession) cancelDuplicates(logger *slog.Logger, r *timedDownloadRequest, from string) {
+	for _, addr := range r.peers {
+		if addr == from {
+			continue
+		}
+		v, ok := t.peers.seeders.Load(addr)
+		if !ok {
+			continue
+		}
+		p := v.(*peer.Peer)
+		if p.ConnectionStatus() != peer.ConnectionEstablished {
+			continue
+		}
+		err := p.SendCancel(&messagesv1.Cancel{
+			Index:  r.request.Index,
+			Begin:  r.request.Begin,
+			Length: r.request.Length,
+		})
+		if err != nil {
+			logger.Debug("failed to cancel duplicate request", slog.Any("err", err), slog.String("end_peer", p.Id))
+		}
+	}
+}
+
+// requeueChoked moves the requests in-flight at the peer at addr back to
+// pending, as a peer that chokes this client discards unanswered requests.
+// Only the requests sent before the peer choked this client for the n-th
+// time are moved, the ones sent after it unchoked this client again are
+// answered, as multiple chokes are signaled once.
+func (t *TorrentSession) requeueChoked(logger *slog.Logger, addr string, n uint64) {
+	requeued := t.requeueSentBefore(addr, n)
+	logger.Debug("peer choked, re-queued in-flight requests", slog.Int("requests", requeued))
+}
+
+// requeueInFlight moves the requests in-flight at the peer at addr,
+// that were not requested from other peers, back to pending.
+func (t *TorrentSession) requeueInFlight(addr string) int {
+	return t.requeueSentBefore(addr, math.MaxUint64)
+}
+
+// requeueSentBefore moves the requests in-flight at the peer at addr that
+// were sent before its n-th choke, and not requested from other peers,
+// back to pending.
+func (t *TorrentSession) requeueSentBefore(addr string, n uint64) int {
+	requeued := 0
+	for _, p := range t.download.active.snapshot() {
+
+		p.l.Lock()
+		for j, r := range p.InFlight {
+			if r.received || !slices.Contains(r.peers, addr) || !r.sentBefore(addr, n) {
+				continue
+			}
+			r.forget(addr)
+			if len(r.peers) > 0 {
+				continue // still requested from other peers during the endgame.
+			}
+			p.Pending = append(p.Pending, &messagesv1.Request{
+				Index:  r.request.Index,
+				Begin:  r.request.Begin,
+				Length: r.request.Length,
+			})
+			p.InFlight[j] = nil
+			requeued++
+		}
+		p.InFlight = slices.DeleteFunc(p.InFlight, func(r *timedDownloadRequest) bool { return r == nil })
+		p.l.Unlock()
+	}
+	return requeued
+}
+
+// spawnReceiver runs recvPieces in the download wait group and returns
+// a channel that is closed once it returned. Every connection to a
+// seeder has its own pieces channel, which the peer closes once the
+// connection was killed, so that its receiver never outlives it.
+func (t *TorrentSession) spawnReceiver(logger *slog.Logger, addr, peerID string, pieces <-chan *messagesv1.Piece, chokes <-chan uint64) <-chan struct{} {
+	done := make(chan struct{})
+	t.download.wg.Add(1)
+	go func() {
+		defer t.download.wg.Done()
+		defer close(done)
+		t.recvPieces(logger, addr, peerID, pieces, chokes)
+	}()
+	return done
+}
+
+// recvPieces handles the blocks and chokes of a single peer or web
+// seed until its pieces channel is closed, see spawnReceiver.
+func (t *TorrentSession) recvPieces(logger *slog.Logger, addr, peerID string, pieces <-chan *messagesv1.Piece, chokes <-chan uint64) {
 	for {
 		select {
+		case n := <-chokes:
+			t.requeueChoked(logger, addr, n)
 		case recv, ok := <-pieces:
 			if !ok {
 				logger.Debug("shutting piece downloader, channel closed")
 				return
 			}
+			idx := recv.PieceIndex()
 
-			pieceIdx := -1
-			var piece *pendingPiece
-			for i := range t.download.requests {
-				if r := t.download.requests[i].Load(); r != nil && r.Index == recv.Index {
-					piece = r
-					pieceIdx = i
-					break
-				}
+			if t.have.Check(idx) {
+				logger.Debug("received block of verified piece, dropping", slog.String("piece_idx", fmt.Sprint(recv.Index)))
+				t.download.waste.duplicate.Add(int64(len(recv.Block)))
+				t.statsFor(addr).redundant.Add(int64(len(recv.Block)))
+				continue
 			}
+
+			piece := t.download.active.get(idx)
 			if piece == nil {
 				logger.Debug("received piece for untracked piece index", slog.String("piece_idx", fmt.Sprint(recv.Index)))
+				t.download.waste.duplicate.Add(int64(len(recv.Block)))
 				continue
 			}
 
 			piece.l.Lock()
+			if t.download.active.get(idx) != piece {
+				piece.l.Unlock()
+				t.download.waste.duplicate.Add(int64(len(recv.Block)))
+				continue // no longer downloaded.
+			}
 
-			req := slices.IndexFunc(piece.InFlight, func(r *timedDownloadRequest) bool {
-				return r.request == messagesv1.Request{
-					Index:  recv.Index,
-					Begin:  recv.Begin,
-					Length: uint32(len(recv.Block)),
+			block := messagesv1.Request{Index: recv.Index, Begin: recv.Begin, Length: uint32(len(recv.Block))}
+			req := slices.IndexFunc(piece.InFlight, func(r *timedDownloadRequest) bool { return r.request == block })
+			if req < 0 && piece.timedOutAt(block, addr) {
+				// the late answer to a request that timed out, which
+				// is used unless it was sent to another peer already.
+				if i := slices.IndexFunc(piece.Pending, func(r *messagesv1.Request) bool { return *r == block }); i >= 0 {
+					piece.Pending = slices.Delete(piece.Pending, i, i+1)
+					piece.InFlight = append(piece.InFlight, &timedDownloadRequest{request: block, send: t.now(), peers: []string{addr}})
+					req = len(piece.InFlight) - 1
 				}
-			})
+			}
 
 			if req < 0 {
 				logger.Debug("received piece for untracked piece",
@@ -278,56 +553,64 @@ func (t *Tracker) recvPieces(logger *slog.Logger, pieces <-chan *messagesv1.Piec
 					slog.String("piece_offset", fmt.Sprint(recv.Begin)),
 					slog.String("piece_length", fmt.Sprint(len(recv.Block))),
 				)
+				t.download.waste.duplicate.Add(int64(len(recv.Block)))
 				piece.l.Unlock()
 				continue
 			}
 
-			// check for duplicates
-			var skip bool
-			for _, other := range piece.Received {
-				duplicate := other.Begin == recv.Begin
-				duplicate = duplicate && other.Index == recv.Index
-				duplicate = duplicate && len(other.Block) == len(recv.Block)
-				if duplicate {
-					skip = true
-					break
-				}
-			}
-			if skip {
+			if !piece.receive(&receivedBlock{Piece: recv, from: addr, fromID: peerID}) {
+				logger.Debug("received block overlapping the received ones, dropping",
+					slog.String("piece_idx", fmt.Sprint(recv.Index)),
+					slog.String("piece_offset", fmt.Sprint(recv.Begin)),
+				)
+				t.download.waste.duplicate.Add(int64(len(recv.Block)))
+				// another copy of the block was used, e.g. that of the
+				// peer the request was sent to after it timed out here.
+				t.statsFor(addr).redundant.Add(int64(len(recv.Block)))
 				piece.l.Unlock()
 				continue
 			}
 
-			piece.Downloaded += int64(len(recv.Block))
-			if piece.Downloaded > piece.Size {
-				piece.l.Unlock()
-				panic(fmt.Sprintf("recieved more data than expected for piece %v", recv.Index))
+			// only the blocks used count as delivered, so that a slow
+			// peer answering requests served by others stays snubbed.
+			stats := t.statsFor(addr)
+			stats.lastBlock.Store(t.now().UnixNano())
+			if stats.snubbed.Swap(false) {
+				logger.Debug("peer delivered a block, no longer snubbed")
 			}
-			total := t.Downloaded.Add(int64(len(recv.Block)))
+			t.downloaded.Add(int64(len(recv.Block)))
+			t.download.rate.add(int64(len(recv.Block)), t.now())
+			stats.downloaded.Add(int64(len(recv.Block)))
+			t.metrics.received.Add(int64(len(recv.Block)))
 
-			piece.Received = append(piece.Received, recv)
 			piece.InFlight[req].received = true // mark as received to it won't be rescheduled again.
+			t.cancelDuplicates(logger, piece.InFlight[req], addr)
 
-			status := float64(piece.Downloaded) / float64(piece.Size)
-			status *= 100
-			logger.Debug("received piece",
-				slog.String("piece", fmt.Sprint(recv.Index)),
-				slog.String("downloaded_bytes", fmt.Sprint(piece.Downloaded)),
-				slog.String("status", fmt.Sprintf("%.2f%%", status)),
-			)
+			t.logReceivedBlock(logger, piece, recv)
 
 			if piece.Downloaded == piece.Size {
-				slices.SortFunc(piece.Received, func(a, b *messagesv1.Piece) int { return cmp.Compare(a.Begin, b.Begin) })
+				t.buffers.move(StageReceiving, StageVerifying, piece.Size)
+				completed := t.now()
+				slices.SortFunc(piece.Received, func(a, b *receivedBlock) int { return cmp.Compare(a.Begin, b.Begin) })
 				var data []byte
 				for _, d := range piece.Received {
 					data = append(data, d.Block...)
 				}
 				digest := sha1.Sum(data)
 
-				if !bytes.Equal(digest[:], t.Torrent.PieceHash(recv.Index)) {
+				if !bytes.Equal(digest[:], t.meta.PieceHash(idx)) {
 					logger.Error("invalid piece sha1 hash, retrying", slog.String("piece", fmt.Sprint(recv.Index)))
-					// TODO: mark peer as malicious and close connection.
-					t.Downloaded.Add(-piece.Size)
+					t.emit(PieceHashFailed{
+						Piece:        idx,
+						Attempt:      piece.Attempt,
+						Size:         piece.Size,
+						Contributors: piece.Contributions(),
+					})
+					t.suspectContributors(piece)
+					t.downloaded.Add(-piece.Size)
+					t.download.waste.hashFailed.Add(piece.Size)
+					t.metrics.hashFailures.Add(1)
+					t.buffers.move(StageVerifying, StageReceiving, piece.Size)
 					if err := piece.Retry(); err != nil {
 						piece.l.Unlock()
 						panic("malformed state, expected no pending requests when rescheduling piece for retry download")
@@ -336,9 +619,16 @@ func (t *Tracker) recvPieces(logger *slog.Logger, pieces <-chan *messagesv1.Piec
 					continue
 				}
 
-				if err := t.Flush(recv.Index, data); err != nil {
-					logger.Error("failed to flush piece", slog.Any("err", err), slog.String("piece", fmt.Sprint(recv.Index)))
-					t.Downloaded.Add(-piece.Size)
+				verified := t.now()
+				t.attributeCorruption(logger, piece, data)
+				t.download.pipeline.recordVerified(completed, verified)
+				t.buffers.move(StageVerifying, StageFlushing, piece.Size)
+
+				if err := t.Flush(idx, data); err != nil {
+					t.flushFailed(logger, piece, err)
+					t.downloaded.Add(-piece.Size)
+					t.download.waste.flushFailed.Add(piece.Size)
+					t.buffers.move(StageFlushing, StageReceiving, piece.Size)
 					if err := piece.Retry(); err != nil {
 						piece.l.Unlock()
 						panic("malformed state, expected no pending requests when rescheduling piece for retry download")
@@ -347,44 +637,18 @@ func (t *Tracker) recvPieces(logger *slog.Logger, pieces <-chan *messagesv1.Piec
 					continue
 				}
 
-				t.BitField.Set(recv.Index)
-
-				logger.Debug("sending have message for verified piece", slog.String("piece", fmt.Sprint(recv.Index)))
-
-				// send have message to all peers.
-				t.peers.seeders.Range(func(_, value any) bool {
-					if p := value.(*peer.Peer); p.ConnectionStatus() == peer.ConnectionEstablished {
-						if err := p.SendHave(&messagesv1.Have{Index: recv.Index}); err != nil {
-							logger.Error("failed to send have piece, after verifying", slog.Any("err", err),
-								slog.String("end_peer", p.Id),
-								slog.String("piece", fmt.Sprint(recv.Index)),
-							)
-						}
-					}
-					return true
-				})
-				t.peers.leechers.Range(func(_, value any) bool {
-					if p := value.(*peer.Peer); p.ConnectionStatus() == peer.ConnectionEstablished {
-						if err := p.SendHave(&messagesv1.Have{Index: recv.Index}); err != nil {
-							logger.Error("failed to send have piece, after verifying", slog.Any("err", err),
-								slog.String("end_peer", p.Id),
-								slog.String("piece", fmt.Sprint(recv.Index)),
-							)
-						}
-					}
-					return true
-				})
-
-				logger.Info("piece verified successfully",
-					slog.String("status", fmt.Sprintf("%.2f%%", (float64(total)/float64(t.Torrent.BytesToDownload()))*100)),
-					slog.String("kbps", fmt.Sprintf("%.2f", (float64(t.download.rate.Load())/1000.0)*100)),
-					slog.String("piece", fmt.Sprint(recv.Index)),
-				)
+				t.download.pipeline.recordFlushed(piece.Size, verified, t.now())
 
-				// make place for a new piece to be scheduled.
-				if !t.download.requests[pieceIdx].CompareAndSwap(piece, nil) {
-					logger.Warn("two go-routines verified same piece", slog.String("piece", fmt.Sprint(recv.Index)))
+				if t.download.readBack {
+					// read back off the receiver, see readBack.
+					piece.readingBack = true
+					t.download.wg.Add(1)
+					go t.readBack(logger, piece)
+					piece.l.Unlock()
+					continue
 				}
+
+				t.pieceVerified(logger, piece)
 			}
 
 			piece.l.Unlock()
@@ -392,62 +656,206 @@ func (t *Tracker) recvPieces(logger *slog.Logger, pieces <-chan *messagesv1.Piec
 	}
 }
 
-func (t *Tracker) keepAliveSeeders(addr string) {
+// pieceVerified marks the flushed piece as verified, announces it to
+// the peers and frees its slot. The caller must hold the lock of piece.
+func (t *TorrentSession) pieceVerified(logger *slog.Logger, piece *pendingPiece) {
+	idx := piece.Index
+	t.setSource(idx, t.pieceSource(piece))
+	t.have.Set(idx)
+	t.pieceFlushed()
+
+	if piece.Attempt > webSeedFallbackAttempts && piece.fromWebSeed() {
+		t.download.recovered.Add(1)
+		logger.Info("recovered piece from web seed after failed verification",
+			slog.String("piece", fmt.Sprint(idx)),
+			slog.Int("attempt", piece.Attempt),
+		)
+	}
+
+	logger.Debug("sending have message for verified piece", slog.String("piece", fmt.Sprint(idx)))
+
+	// send have message to all peers.
+	t.peers.seeders.Range(func(_, value any) bool {
+		if p := value.(*peer.Peer); p.ConnectionStatus() == peer.ConnectionEstablished {
+			if err := p.SendHave(&messagesv1.Have{Index: uint32(idx)}); err != nil {
+				logger.Error("failed to send have piece, after verifying", slog.Any("err", err),
+					slog.String("end_peer", p.Id),
+					slog.String("piece", fmt.Sprint(idx)),
+				)
+			}
+		}
+		return true
+	})
+	t.peers.leechers.Range(func(_, value any) bool {
+		if p := value.(*peer.Peer); p.ConnectionStatus() == peer.ConnectionEstablished {
+			if err := p.SendHave(&messagesv1.Have{Index: uint32(idx)}); err != nil {
+				logger.Error("failed to send have piece, after verifying", slog.Any("err", err),
+					slog.String("end_peer", p.Id),
+					slog.String("piece", fmt.Sprint(idx)),
+				)
+			}
+		}
+		return true
+	})
+
+	logger.Info("piece verified successfully",
+		slog.String("status", fmt.Sprintf("%.2f%%", (float64(t.downloaded.Load())/float64(t.meta.BytesToDownload()))*100)),
+		slog.String("rate", formatRate(t.TransferStats().DownloadRate)),
+		slog.String("piece", fmt.Sprint(idx)),
+	)
+
+	// make place for a new piece to be scheduled, piece
+	// still holds the slot as checked after locking it.
+	t.download.active.verified(piece)
+	t.buffers.release(StageFlushing, piece.Size)
+}
+
+func (t *TorrentSession) keepAliveSeeders(addr string) {
 	logger := t.logger.With(slog.String("peer_ip", addr))
 
 	var p *peer.Peer
+	// received is closed once the receiver of the blocks of p returned.
+	var received <-chan struct{}
+	// connected is set while a connection of the limit is held.
+	var connected bool
+	// since and downloaded are the time and the bytes received
+	// from the peer when the current connection was established.
+	var since time.Time
+	var downloaded int64
 	defer func() {
+		t.peers.dials.done(addr)
+		if p.ConnectionStatus() == peer.ConnectionEstablished {
+			t.recordPeer(p, since, downloaded)
+		}
 		if err := p.SendNotInterested(); err != nil {
 			logger.Error("failed to send not-interested msg", slog.Any("err", err))
 		}
 		if err := p.Close(); err != nil {
 			logger.Error("failed to close peer", slog.Any("err", err))
 		}
+		if received != nil {
+			<-received
+		}
+		if connected {
+			t.releaseConn()
+		}
 
+		t.peers.connecting.Delete(addr)
 		t.download.wg.Done()
 	}()
 
-	refresh := time.NewTicker(1 * time.Nanosecond) // first tick happens immediately.
+	var failures connectFailures
+	refresh := t.newTicker(1 * time.Nanosecond) // first tick happens immediately.
 	for {
 		select {
 		case <-t.stop:
 			logger.Debug("shutting down peer refresher, stopped tracker")
 			return
-		case <-t.download.cancel:
+		case <-t.canceled():
 			logger.Debug("shutting down peer refresher, canceled download")
 			return
 		case <-t.download.completed:
 			logger.Debug("shutting down peer refresher, as torrent was downloaded")
 			return
-		case <-refresh.C:
-			refresh.Reset(2 * time.Minute)
+		case <-refresh.C():
+			refresh.Reset(t.download.reconnect.interval)
+			if _, ok := t.peers.banned.Load(addr); ok {
+				logger.Debug("shutting down peer refresher, peer was banned")
+				t.peers.seeders.Delete(addr)
+				return
+			}
 			switch p.ConnectionStatus() {
 			case peer.ConnectionKilled:
 				if err := p.Close(); err != nil {
 					logger.Error("failed to close peer", slog.Any("err", err))
 				}
+				if received != nil {
+					// the blocks of the previous connection are handled
+					// before those of the next one, never concurrently.
+					<-received
+					received = nil
+				}
+				if p != nil {
+					t.recordPeer(p, since, downloaded)
+					p = nil
+				}
 				t.peers.seeders.Delete(addr)
+				t.peers.suggestions.Delete(addr)
+				if t.isBlocked(addr) {
+					logger.Debug("shutting down peer refresher, peer is blocked")
+					return
+				}
+				if t.peers.dials.reap(addr, t.now()) {
+					logger.Debug("shutting down peer refresher, peer is no longer listed by the tracker")
+					return
+				}
+				if connected {
+					t.releaseConn()
+				}
+				if connected = t.acquireDial(addr); !connected {
+					logger.Debug("connection limit reached, delaying connection to peer")
+					refresh.Reset(connLimitRetry)
+					continue
+				}
 
 				var err error
 				p, err = peer.NewSeederConnection(
 					logger,
 					addr,
-					t.Torrent.NumPieces(),
-					string(t.Torrent.Metadata.Hash[:]),
+					t.meta.NumPieces(),
+					string(t.meta.Metadata.Hash[:]),
 					t.clientID,
+					append(t.peerOptions(), t.suggestHandler(addr))...,
 				)
 				if err != nil {
-					logger.Error("failed to initiating handshake", slog.Any("err", err))
+					t.releaseConn()
+					connected = false
+					if errors.Is(err, peer.ErrSelfConnection) {
+						logger.Info("shutting down peer refresher, peer is this client")
+						t.peers.self.Store(addr, struct{}{})
+						t.peers.manual.Delete(addr)
+						return
+					}
+					if storage.TooManyOpenFiles(err) {
+						// not the fault of the peer, retry once descriptors are released.
+						logger.Debug("no file descriptors left to connect to peer", slog.Any("err", err))
+						refresh.Reset(connLimitRetry)
+						continue
+					}
+					delay, retry := t.download.reconnect.record(&failures, err)
+					if !retry {
+						logger.Warn("giving up on peer after repeated handshake failures, until listed again",
+							slog.Int("failures", failures.handshake),
+							slog.Any("err", err),
+						)
+						t.peers.manual.Delete(addr)
+						return
+					}
+					if failures.dial+failures.handshake == 1 {
+						logger.Error("failed to initiating handshake", slog.Any("err", err))
+					} else {
+						logger.Debug("failed to initiating handshake, backing off",
+							slog.String("retry_in", delay.String()),
+							slog.Any("err", err),
+						)
+					}
+					refresh.Reset(delay)
 					continue
 				}
+				if t.rejectBannedID(addr, p.Id) {
+					logger.Debug("shutting down peer refresher, peer id is banned")
+					t.peers.manual.Delete(addr)
+					return
+				}
+				failures = connectFailures{}
+				since, downloaded = t.now(), t.statsFor(addr).downloaded.Load()
 
 				t.peers.seeders.Store(addr, p)
 
 				// Listen for incoming pieces.
-				t.download.wg.Add(1)
-				go t.recvPieces(logger.With(slog.String("pid", p.Id)), p.Pieces())
+				received = t.spawnReceiver(logger.With(slog.String("pid", p.Id)), addr, p.Id, p.Pieces(), p.Chokes())
 
-				if err := p.SendBitfield(t.BitField.Clone()); err != nil {
+				if err := p.SendBitfield(t.have.Clone()); err != nil {
 					logger.Error("failed to send bitfield msg")
 				}
 
@@ -466,3 +874,34 @@ func (t *Tracker) keepAliveSeeders(addr string) {
 		}
 	}
 }
+
+// peerOptions returns the options the connections to peers are created with.
+func (t *TorrentSession) peerOptions() []peer.Option {
+	opts := []peer.Option{t.piecesHandler()}
+	if t.dial != nil {
+		opts = append(opts, peer.WithDialer(t.dial))
+	}
+	if t.dhtNode != nil {
+		opts = append(opts, peer.WithDHTNodeHandler(t.dhtNode))
+	}
+	return opts
+}
+
+// acquireConn reserves a connection to a seeder within both the limit
+// shared by all torrents and the limit of the torrent, if any.
+func (t *TorrentSession) acquireConn() bool {
+	if n := t.seederLimit(); n > 0 && t.download.connected.Load() >= n {
+		return false
+	}
+	if !t.conns.TryAcquire() {
+		return false
+	}
+	t.download.connected.Add(1)
+	return true
+}
+
+// releaseConn frees a connection reserved by acquireConn.
+func (t *TorrentSession) releaseConn() {
+	t.download.connected.Add(-1)
+	t.conns.Release()
+}
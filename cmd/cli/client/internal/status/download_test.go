@@ -0,0 +1,311 @@
+package status
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestTracker returns a tracker for a single file torrent
+// consisting of the passed pieces without spawning any of the
+// background workflows.
+func newTestTracker(t *testing.T, pieceLength int64, pieces ...[]byte) *TorrentSession {
+	t.Helper()
+
+	var hashes []byte
+	var length int64
+	for _, p := range pieces {
+		h := sha1.Sum(p)
+		hashes = append(hashes, h[:]...)
+		length += int64(len(p))
+	}
+
+	mi := &torrent.MetaInfoFile{
+		Info: torrent.Info{
+			InfoSingleFile: &torrent.InfoSingleFile{Name: "test.bin", Length: length},
+			PieceLength:    pieceLength,
+			Pieces:         hex.EncodeToString(hashes),
+		},
+		Announce: "http://localhost/announce",
+	}
+
+	tr := &TorrentSession{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		stop:   make(chan struct{}),
+		meta:   mi,
+		have:   bitfield.NewBitfield(mi.NumPieces()),
+	}
+	tr.setDownloadDir(t.TempDir())
+	tr.download.cancel.Store(newCancelation())
+	tr.download.completed = make(chan struct{})
+	tr.download.failure.Store(newFailure())
+	tr.download.reannounce = make(chan struct{}, 1)
+	tr.download.reclaimed = make(chan struct{}, 1)
+	tr.durability.durable = bitfield.NewBitfield(mi.NumPieces())
+	tr.download.reconnect = defaultReconnectPolicy
+	tr.download.picker = RatePicker{}
+	tr.download.active.setMax(defaultActivePieces(pieceLength))
+	tr.announce.numWant = DefaultNumWant
+	tr.upload.cancel = make(chan struct{})
+	tr.upload.seeded = make(chan struct{})
+	tr.storage = storage.NewPieceFiles(tr.DownloadDir())
+	tr.buffers = NewBufferBudget(0)
+	tr.conns = NewConnLimit(0)
+	tr.Subscribe(tr.banContributors)
+	return tr
+}
+
+func TestTracker_PieceHashFailedEvent(t *testing.T) {
+	good := bytes.Repeat([]byte{0xAB}, 2*messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(good)), good)
+
+	events := make(chan Event, 1)
+	tr.Subscribe(func(e Event) { events <- e })
+
+	schedule := func() {
+		tr.download.active.add(&pendingPiece{
+			Index:   0,
+			Attempt: 1,
+			Size:    int64(len(good)),
+			InFlight: []*timedDownloadRequest{
+				{request: messagesv1.Request{Index: 0, Begin: 0, Length: messagesv1.RequestSize}},
+				{request: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}},
+			},
+		})
+	}
+	schedule()
+
+	a, b := make(chan *messagesv1.Piece), make(chan *messagesv1.Piece)
+	tr.spawnReceiver(tr.logger, "10.0.0.1:6881", "peer-a", a, nil)
+	tr.spawnReceiver(tr.logger, "10.0.0.2:6881", "peer-b", b, nil)
+
+	corrupted := bytes.Repeat([]byte{0xCD}, messagesv1.RequestSize)
+
+	for attempt := 1; attempt <= maxHashFailures; attempt++ {
+		if attempt > 1 {
+			// the piece was rescheduled move the pending requests in-flight again.
+			p := tr.download.active.get(0)
+			p.l.Lock()
+			for _, r := range p.Pending {
+				p.InFlight = append(p.InFlight, &timedDownloadRequest{request: *r})
+			}
+			p.Pending = nil
+			p.l.Unlock()
+		}
+
+		b <- &messagesv1.Piece{Index: 0, Begin: messagesv1.RequestSize, Block: corrupted}
+		a <- &messagesv1.Piece{Index: 0, Begin: 0, Block: good[:messagesv1.RequestSize]}
+
+		e := (<-events).(PieceHashFailed)
+		assert.Equal(t, int64(0), e.Piece)
+		assert.Equal(t, attempt, e.Attempt)
+		assert.Equal(t, int64(len(good)), e.Size)
+		assert.Equal(t, []Contribution{
+			{PeerID: "peer-a", Addr: "10.0.0.1:6881", Ranges: []BlockRange{{Begin: 0, Length: messagesv1.RequestSize}}},
+			{PeerID: "peer-b", Addr: "10.0.0.2:6881", Ranges: []BlockRange{{Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}}},
+		}, e.Contributors)
+	}
+
+	close(a)
+	close(b)
+	tr.download.wg.Wait()
+
+	assert.Equal(t, int64(0), tr.downloaded.Load())
+	assert.False(t, tr.have.Check(0))
+
+	_, banned := tr.peers.banned.Load("10.0.0.2:6881")
+	assert.True(t, banned)
+}
+
+func TestTracker_RequeueOnChoke(t *testing.T) {
+	data := make([]byte, 4*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+
+	choker := newStubSeeder(t, int64(len(data)), data, 3, true)
+	seeder := newStubSeeder(t, int64(len(data)), data, 0, false)
+
+	for _, addr := range []string{choker.addr, seeder.addr} {
+		tr.download.wg.Add(1)
+		go tr.keepAliveSeeders(addr)
+	}
+	assert.Eventually(t, func() bool {
+		connected := 0
+		tr.peers.seeders.Range(func(_, value any) bool {
+			if value.(*peer.Peer).Bitfield.Check(0) {
+				connected++
+			}
+			return true
+		})
+		return connected == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	// only the choker is unchoked, it receives the requests and chokes.
+	assert.Eventually(t, func() bool { return len(choker.received()) >= 3 }, 5*time.Second, 10*time.Millisecond)
+	close(seeder.unchoke)
+
+	// the discarded requests are re-requested well before they would time out.
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(3 * time.Second):
+		t.Fatal("blocks discarded by choking peer were not re-requested")
+	}
+	tr.download.wg.Wait()
+
+	assert.True(t, tr.have.Check(0))
+	assert.Len(t, seeder.received(), 4)
+	for _, r := range choker.received() {
+		assert.Contains(t, seeder.received(), r)
+	}
+
+	// the discarded requests did not count against the choking peer.
+	for _, s := range tr.PeerStats() {
+		assert.Zero(t, s.Timeouts, s.Addr)
+		assert.False(t, s.Snubbed, s.Addr)
+	}
+}
+
+func TestTracker_SkipsPiecesVerifiedAfterStart(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	pieces := [][]byte{data[:messagesv1.RequestSize], data[messagesv1.RequestSize:]}
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	// the scheduler snapshots the missing pieces while no peers are connected.
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+	time.Sleep(100 * time.Millisecond)
+
+	// the first piece is verified in the meantime, e.g. by a recheck.
+	assert.NoError(t, tr.Flush(0, pieces[0]))
+	tr.have.Set(0)
+	tr.downloaded.Add(messagesv1.RequestSize)
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.CancelDownload()
+	assertPieces(t, tr, pieces)
+
+	for _, r := range seeder.received() {
+		assert.NotEqual(t, uint32(0), r.Index, "verified piece was requested")
+	}
+	assert.Equal(t, int64(len(data)), tr.downloaded.Load())
+}
+
+func TestTracker_DropsBlocksOfVerifiedPieces(t *testing.T) {
+	piece := bytes.Repeat([]byte{0x1}, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(piece)), piece)
+	tr.have.Set(0)
+	tr.downloaded.Store(int64(len(piece)))
+
+	pieces := make(chan *messagesv1.Piece, 1)
+	pieces <- &messagesv1.Piece{Index: 0, Begin: 0, Block: piece}
+	close(pieces)
+	tr.recvPieces(tr.logger, "10.0.0.1:6881", "peer-a", pieces, nil)
+
+	assert.Equal(t, int64(len(piece)), tr.downloaded.Load())
+	assert.Zero(t, tr.Metrics().Received)
+}
+
+func TestTracker_RequeuesRequestsSentBeforeChoke(t *testing.T) {
+	const addr = "10.0.0.1:6881"
+	data := make([]byte, 2*messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.download.active.reset(1, nil)
+
+	// the first request was sent before the peer choked this client, the
+	// second one after it unchoked this client again.
+	stale := &timedDownloadRequest{
+		request: messagesv1.Request{Index: 0, Begin: 0, Length: messagesv1.RequestSize},
+		peers:   []string{addr},
+		chokes:  []uint64{0},
+	}
+	fresh := &timedDownloadRequest{
+		request: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize},
+		peers:   []string{addr},
+		chokes:  []uint64{1},
+	}
+	p := &pendingPiece{Index: 0, Size: int64(len(data)), InFlight: []*timedDownloadRequest{stale, fresh}}
+	assert.True(t, tr.download.active.add(p))
+
+	pieces, chokes := make(chan *messagesv1.Piece), make(chan uint64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tr.recvPieces(tr.logger, addr, "peer-a", pieces, chokes)
+	}()
+	// the choke is consumed late, once the peer unchoked this client again.
+	chokes <- 1
+	close(pieces)
+	<-done
+
+	p.l.Lock()
+	defer p.l.Unlock()
+	assert.Equal(t, []*messagesv1.Request{&stale.request}, p.Pending)
+	assert.Equal(t, []*timedDownloadRequest{fresh}, p.InFlight)
+}
+
+func TestTracker_AllowedFastWhileChoked(t *testing.T) {
+	const numPieces = 4
+	data := make([]byte, numPieces*messagesv1.RequestSize)
+	var pieces [][]byte
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	for i := range numPieces {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	// never unchokes this client, piece 4 does not exist.
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, false, func(s *stubSeeder) {
+		s.allowedFast = []uint32{1, 3, 4}
+	})
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		p, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && p.(*peer.Peer).AllowedFast(3)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+	t.Cleanup(tr.CancelDownload)
+
+	assert.Eventually(t, func() bool {
+		return tr.have.Check(1) && tr.have.Check(3)
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{0, 2}, tr.have.MissingPieces())
+
+	for _, r := range seeder.received() {
+		assert.Contains(t, []uint32{1, 3}, r.Index, "piece that is not allowed fast was requested while choked")
+	}
+}
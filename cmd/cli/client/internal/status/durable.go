@@ -0,0 +1,94 @@
+package status
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
+)
+
+const (
+	// defaultSyncPieces and defaultSyncInterval are the default
+	// policy of when flushed pieces are synced, see WithSyncPolicy.
+	defaultSyncPieces   = 32
+	defaultSyncInterval = 10 * time.Second
+)
+
+// durability tracks which of the flushed pieces were synced to the storage.
+// Only those are persisted in the resume data, so that a restart after a
+// crash never trusts pieces whose data may have been lost.
+type durability struct {
+	// l serializes syncing the storage and persisting the resume
+	// data, and guards durable, the pieces that were synced.
+	l       sync.Mutex
+	durable *bitfield.BitField
+
+	// pieces and interval are the sync policy, see WithSyncPolicy.
+	pieces   int
+	interval time.Duration
+
+	// unsynced is the number of pieces flushed since the last sync,
+	// synced the unix nano time of the last sync.
+	unsynced atomic.Int64
+	synced   atomic.Int64
+	// syncing is set while a sync runs in the background.
+	syncing atomic.Bool
+
+	// writes counts the resume data written, coalesced the flushed
+	// pieces that were persisted by the write of a later piece.
+	writes    atomic.Int64
+	coalesced atomic.Int64
+}
+
+// syncPieces syncs the storage and marks the pieces flushed before as
+// durable. The durability lock must be held.
+func (t *TorrentSession) syncPieces() error {
+	snapshot := t.have.Clone()
+	unsynced := t.durability.unsynced.Load()
+	if err := storage.Sync(t.storage); err != nil {
+		return err
+	}
+	t.durability.durable.Overwrite(snapshot)
+	t.durability.unsynced.Add(-unsynced)
+	t.durability.synced.Store(t.now().UnixNano())
+	return nil
+}
+
+// pieceFlushed counts a flushed piece and syncs the
+// pieces in the background once enough were flushed.
+func (t *TorrentSession) pieceFlushed() {
+	n := t.durability.unsynced.Add(1)
+	if t.durability.pieces > 0 && n >= int64(t.durability.pieces) {
+		t.scheduleSync()
+	}
+}
+
+// checkSync syncs the flushed pieces in the background if the last
+// sync was too long ago, must be called periodically by the scheduler.
+func (t *TorrentSession) checkSync(now time.Time) {
+	if t.durability.interval <= 0 || t.durability.unsynced.Load() == 0 {
+		return
+	}
+	if now.Sub(time.Unix(0, t.durability.synced.Load())) >= t.durability.interval {
+		t.scheduleSync()
+	}
+}
+
+// scheduleSync persists the resume data in the background,
+// which syncs the flushed pieces first, unless it already runs.
+func (t *TorrentSession) scheduleSync() {
+	if !t.durability.syncing.CompareAndSwap(false, true) {
+		return
+	}
+	t.download.wg.Add(1)
+	go func() {
+		defer t.download.wg.Done()
+		defer t.durability.syncing.Store(false)
+		if err := t.saveResume(); err != nil {
+			t.logger.Error("failed to persist resume data", slog.Any("err", err))
+		}
+	}()
+}
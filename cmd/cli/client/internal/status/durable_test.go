@@ -0,0 +1,163 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncingStorage counts the syncs of the pieces, which fail while failing is set.
+type syncingStorage struct {
+	*storage.Memory
+	syncs   atomic.Int64
+	failing atomic.Bool
+}
+
+func (s *syncingStorage) Sync() error {
+	if s.failing.Load() {
+		return errors.New("sync failed")
+	}
+	s.syncs.Add(1)
+	return nil
+}
+
+func TestTracker_ResumeOnlySyncedPieces(t *testing.T) {
+	pieces := [][]byte{{0x1}, {0x2}, {0x3}, {0x4}}
+	tr := newTestTracker(t, 1, pieces...)
+	disk := &syncingStorage{Memory: storage.NewMemory()}
+	WithStorage(disk)(tr)
+	WithSyncPolicy(2, 0)(tr)
+
+	flush := func(i int64) {
+		assert.NoError(t, tr.Flush(i, pieces[i]))
+		tr.have.Set(i)
+		tr.pieceFlushed()
+	}
+	persisted := func() []int64 {
+		b, err := os.ReadFile(filepath.Join(tr.DownloadDir(), resumeFile))
+		if err != nil {
+			return nil
+		}
+		var r resume
+		assert.NoError(t, json.Unmarshal(b, &r))
+		have := bitfield.NewBitfield(tr.meta.NumPieces())
+		have.Overwrite(r.Bitfield)
+		return have.ExistingPieces()
+	}
+
+	flush(0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, disk.syncs.Load(), "synced before enough pieces were flushed")
+
+	// the second piece triggers the sync in the background.
+	flush(1)
+	assert.Eventually(t, func() bool {
+		return len(persisted()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{0, 1}, persisted())
+
+	// pieces that could not be synced are not persisted.
+	disk.failing.Store(true)
+	flush(2)
+	assert.NoError(t, tr.saveResume())
+	assert.Equal(t, []int64{0, 1}, persisted())
+
+	disk.failing.Store(false)
+	flush(3)
+	assert.NoError(t, tr.saveResume())
+	assert.Equal(t, []int64{0, 1, 2, 3}, persisted())
+
+	tr.CancelDownload()
+}
+
+func TestTracker_CheckSync(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1})
+	disk := &syncingStorage{Memory: storage.NewMemory()}
+	WithStorage(disk)(tr)
+	WithSyncPolicy(0, time.Minute)(tr)
+	now := time.Now()
+	tr.durability.synced.Store(now.UnixNano())
+
+	tr.checkSync(now.Add(2 * time.Minute))
+	assert.Never(t, func() bool { return disk.syncs.Load() > 0 }, 50*time.Millisecond, 10*time.Millisecond,
+		"nothing to sync")
+
+	tr.pieceFlushed()
+	tr.checkSync(now.Add(30 * time.Second))
+	assert.Never(t, func() bool { return disk.syncs.Load() > 0 }, 50*time.Millisecond, 10*time.Millisecond,
+		"synced before the interval passed")
+
+	tr.checkSync(now.Add(time.Minute))
+	assert.Eventually(t, func() bool { return disk.syncs.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	tr.CancelDownload()
+	assert.Zero(t, tr.durability.unsynced.Load())
+}
+
+func TestTracker_ResumeWriteCadence(t *testing.T) {
+	pieces := make([][]byte, 40)
+	for i := range pieces {
+		pieces[i] = []byte{byte(i)}
+	}
+	tr := newTestTracker(t, 1, pieces...)
+	disk := &syncingStorage{Memory: storage.NewMemory()}
+	WithStorage(disk)(tr)
+	WithSyncPolicy(10, 5*time.Second)(tr)
+	clk := newFakeClock()
+	tr.clock = clk
+	tr.durability.synced.Store(clk.Now().UnixNano())
+	t.Cleanup(tr.CancelDownload)
+
+	persisted := func() int {
+		r, err := tr.loadResume()
+		if err != nil || r == nil {
+			return 0
+		}
+		have := bitfield.NewBitfield(tr.meta.NumPieces())
+		have.Overwrite(r.Bitfield)
+		return len(have.ExistingPieces())
+	}
+	wait := func() {
+		assert.Eventually(t, func() bool { return !tr.durability.syncing.Load() }, 5*time.Second, time.Millisecond)
+	}
+
+	// a burst of verifications is written every 10 pieces.
+	for i := range int64(25) {
+		assert.NoError(t, tr.Flush(i, pieces[i]))
+		tr.have.Set(i)
+		tr.pieceFlushed()
+		wait()
+	}
+	m := tr.Metrics()
+	assert.Equal(t, int64(2), m.ResumeWrites)
+	assert.Equal(t, int64(18), m.ResumeCoalesced)
+
+	// a crash now loses the pieces flushed since, fewer than 10.
+	assert.Equal(t, 20, persisted())
+
+	clk.Advance(4 * time.Second)
+	tr.checkSync(tr.now())
+	wait()
+	assert.Equal(t, int64(2), tr.Metrics().ResumeWrites, "written before the interval passed")
+
+	clk.Advance(time.Second)
+	tr.checkSync(tr.now())
+	assert.Eventually(t, func() bool { return persisted() == 25 }, 5*time.Second, time.Millisecond)
+	wait()
+	m = tr.Metrics()
+	assert.Equal(t, int64(3), m.ResumeWrites)
+	assert.Equal(t, int64(22), m.ResumeCoalesced)
+
+	// nothing is written while no piece was flushed.
+	clk.Advance(time.Minute)
+	tr.checkSync(tr.now())
+	wait()
+	assert.Equal(t, int64(3), tr.Metrics().ResumeWrites)
+}
@@ -0,0 +1,53 @@
+package status
+
+// endgameSnapshot captures the state of the scheduler that
+// is relevant for deciding whether to enter the endgame.
+type endgameSnapshot struct {
+	// Unassigned is the number of pieces not yet assigned to a request slot.
+	Unassigned int
+	// Remaining is the number of blocks not yet received,
+	// including blocks that were not requested yet.
+	Remaining int
+	// Outstanding holds, for each peer with in-flight requests,
+	// the number of requested bytes not yet received.
+	Outstanding map[string]int64
+	// Rates holds the measured download rate, in bytes per second, of each peer.
+	Rates map[string]int64
+	// FastestIdle is the rate, in bytes per second, of the fastest
+	// peer that has no outstanding requests and could serve the blocks.
+	FastestIdle int64
+}
+
+// shouldEnterEndgame decides whether the in-flight blocks should be
+// requested redundantly from other peers. With a positive threshold the
+// endgame is entered once no more than threshold blocks remain. Otherwise
+// the endgame is entered when waiting for the slowest peer to deliver its
+// outstanding blocks would take longer than fetching all the outstanding
+// blocks again from the fastest idle peer. Peers without a measured rate,
+// e.g. as they just connected, are not waited for in the estimate, their
+// requests time out if they never deliver.
+func shouldEnterEndgame(s endgameSnapshot, threshold int) bool {
+	if s.Unassigned > 0 {
+		return false // there is still work that does not need duplication.
+	}
+	if threshold > 0 {
+		return s.Remaining <= threshold
+	}
+	if len(s.Outstanding) == 0 || s.FastestIdle <= 0 {
+		return false
+	}
+
+	var total int64
+	var wait float64
+	for peer, bytes := range s.Outstanding {
+		total += bytes
+		rate := s.Rates[peer]
+		if rate <= 0 {
+			continue
+		}
+		wait = max(wait, float64(bytes)/float64(rate))
+	}
+
+	redundant := float64(total) / float64(s.FastestIdle)
+	return wait > redundant
+}
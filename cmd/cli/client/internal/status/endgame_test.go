@@ -0,0 +1,180 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldEnterEndgame(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshot  endgameSnapshot
+		threshold int
+		want      bool
+	}{
+		{
+			name:     "unassigned-pieces-left",
+			snapshot: endgameSnapshot{Unassigned: 1, Outstanding: map[string]int64{"a": 100}, FastestIdle: 1000},
+			want:     false,
+		},
+		{
+			name:     "no-idle-peer",
+			snapshot: endgameSnapshot{Outstanding: map[string]int64{"a": 100}, Rates: map[string]int64{"a": 1}},
+			want:     false,
+		},
+		{
+			name:     "nothing-outstanding",
+			snapshot: endgameSnapshot{FastestIdle: 1000},
+			want:     false,
+		},
+		{
+			name: "slow-peer-holding-blocks",
+			snapshot: endgameSnapshot{
+				Outstanding: map[string]int64{"slow": 4 * 16384, "fast": 16384},
+				Rates:       map[string]int64{"slow": 16384, "fast": 1 << 20},
+				FastestIdle: 1 << 20,
+			},
+			want: true,
+		},
+		{
+			name: "peers-finish-faster-than-duplication",
+			snapshot: endgameSnapshot{
+				Outstanding: map[string]int64{"a": 16384, "b": 16384},
+				Rates:       map[string]int64{"a": 1 << 20, "b": 1 << 20},
+				FastestIdle: 16384,
+			},
+			want: false,
+		},
+		{
+			name: "unmeasured-peer",
+			snapshot: endgameSnapshot{
+				Outstanding: map[string]int64{"a": 16384},
+				Rates:       map[string]int64{"a": 0},
+				FastestIdle: 1 << 20,
+			},
+			want: false,
+		},
+		{
+			name: "unmeasured-peer-beside-slow-peer",
+			snapshot: endgameSnapshot{
+				Outstanding: map[string]int64{"new": 16384, "slow": 4 * 16384},
+				Rates:       map[string]int64{"slow": 16384},
+				FastestIdle: 1 << 20,
+			},
+			want: true,
+		},
+		{
+			name:      "fixed-threshold-not-reached",
+			snapshot:  endgameSnapshot{Remaining: 5, Outstanding: map[string]int64{"a": 16384}, FastestIdle: 1 << 20},
+			threshold: 4,
+			want:      false,
+		},
+		{
+			name:      "fixed-threshold-reached",
+			snapshot:  endgameSnapshot{Remaining: 4},
+			threshold: 4,
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, shouldEnterEndgame(tt.snapshot, tt.threshold))
+		})
+	}
+}
+
+// simulateTail simulates downloading the blocks outstanding at the given
+// peers, each peer delivers its queue sequentially at a fixed rate of blocks
+// per second, and returns the number of seconds until all blocks arrived.
+func simulateTail(outstanding map[string]int, rates map[string]float64, threshold int) float64 {
+	const dt = 0.01
+	const blockSize = 16384
+
+	type block struct {
+		id         int
+		duplicated bool
+	}
+
+	queues := make(map[string][]*block)
+	progress := make(map[string]float64)
+	done := make(map[int]bool)
+	var total int
+	for p, n := range outstanding {
+		for range n {
+			queues[p] = append(queues[p], &block{id: total})
+			total++
+		}
+	}
+
+	for elapsed := 0.0; elapsed < 3600; elapsed += dt {
+		snapshot := endgameSnapshot{
+			Remaining:   total - len(done),
+			Outstanding: make(map[string]int64),
+			Rates:       make(map[string]int64),
+		}
+		for p, r := range rates {
+			snapshot.Rates[p] = int64(r * blockSize)
+			if q := queues[p]; len(q) > 0 {
+				snapshot.Outstanding[p] = int64(len(q) * blockSize)
+			}
+		}
+		fastest := ""
+		for p := range rates {
+			if _, busy := snapshot.Outstanding[p]; !busy && snapshot.Rates[p] > snapshot.FastestIdle {
+				snapshot.FastestIdle, fastest = snapshot.Rates[p], p
+			}
+		}
+
+		if fastest != "" && shouldEnterEndgame(snapshot, threshold) {
+			for p, q := range queues {
+				if p == fastest {
+					continue
+				}
+				for _, b := range q {
+					if !b.duplicated {
+						b.duplicated = true
+						queues[fastest] = append(queues[fastest], b)
+					}
+				}
+			}
+		}
+
+		for p := range queues {
+			progress[p] += rates[p] * dt
+			for progress[p] >= 1 && len(queues[p]) > 0 {
+				progress[p]--
+				done[queues[p][0].id] = true
+				queues[p] = queues[p][1:]
+			}
+			if len(queues[p]) == 0 {
+				progress[p] = 0
+			}
+			// drop blocks delivered by another peer.
+			q := queues[p][:0]
+			for _, b := range queues[p] {
+				if !done[b.id] {
+					q = append(q, b)
+				}
+			}
+			queues[p] = q
+		}
+
+		if len(done) == total {
+			return elapsed
+		}
+	}
+	return 3600
+}
+
+func TestEndgame_AdaptiveReducesTailLatency(t *testing.T) {
+	outstanding := map[string]int{"slow": 4, "medium": 2, "fast": 0}
+	rates := map[string]float64{"slow": 0.5, "medium": 4, "fast": 40}
+
+	adaptive := simulateTail(outstanding, rates, 0)
+	fixed := simulateTail(outstanding, rates, 1)
+
+	t.Logf("adaptive tail %.2fs, fixed tail %.2fs", adaptive, fixed)
+	assert.Less(t, adaptive, fixed)
+	assert.Less(t, adaptive, 1.0)
+}
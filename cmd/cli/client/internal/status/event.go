@@ -0,0 +1,123 @@
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is implemented by every notification the TorrentSession
+// emits during the lifetime of a torrent.
+type Event interface{ isEvent() }
+
+// BlockRange is a byte range within a single piece.
+type BlockRange struct {
+	Begin  uint32
+	Length uint32
+}
+
+// Contribution describes the blocks a single peer
+// supplied for a piece.
+type Contribution struct {
+	PeerID string
+	Addr   string
+	Ranges []BlockRange
+}
+
+// PieceHashFailed is emitted when an assembled piece does not
+// match the SHA-1 hash listed in the metainfo file.
+type PieceHashFailed struct {
+	// Piece is the index of the piece that failed verification.
+	Piece int64
+	// Attempt is the download attempt of the piece that failed,
+	// starting at 1.
+	Attempt int
+	// Size is the size of the piece in bytes.
+	Size int64
+	// Contributors are the peers that supplied the blocks
+	// of the piece, in the order of the blocks within the piece.
+	Contributors []Contribution
+}
+
+func (PieceHashFailed) isEvent() {}
+
+// DiskVerificationFailed is emitted when a verified piece that was
+// flushed to storage does not match its SHA-1 hash once read back.
+// Unlike PieceHashFailed it indicates a problem with the disk rather
+// than with the peers, which are not penalized.
+type DiskVerificationFailed struct {
+	// Piece is the index of the piece that was read back.
+	Piece int64
+	// Size is the size of the piece in bytes.
+	Size int64
+	// Err is set if the piece could not be read back at all.
+	Err error
+	// Failures is the number of pieces of the torrent
+	// that failed to read back so far.
+	Failures int64
+}
+
+func (DiskVerificationFailed) isEvent() {}
+
+// Moved is emitted once the downloaded torrent was
+// moved to the destination directory.
+type Moved struct {
+	// From and To are the download directories before and after the move.
+	From, To string
+}
+
+func (Moved) isEvent() {}
+
+// MoveFailed is emitted if the downloaded torrent could not be moved to
+// the destination directory. The torrent keeps its original location.
+type MoveFailed struct {
+	// To is the download directory the torrent was to be moved to.
+	To  string
+	Err error
+}
+
+func (MoveFailed) isEvent() {}
+
+// TorrentCompleted is emitted once the last piece was verified and
+// flushed, after the download directory was moved if WithMoveOnComplete
+// is set. It is not emitted for torrents that were complete when added.
+type TorrentCompleted struct {
+	// Dir is the download directory holding the pieces.
+	Dir string
+	// Paths are the paths within Dir the files of the torrent are assembled at.
+	Paths []string
+	// Elapsed is the time from adding the torrent until it completed.
+	Elapsed time.Duration
+}
+
+func (TorrentCompleted) isEvent() {}
+
+type subscribers struct {
+	l        sync.RWMutex
+	handlers []func(Event)
+}
+
+// Subscribe registers fn to be called for every event emitted
+// by the session. The handlers are called synchronously from
+// the download goroutines and must not block.
+func (t *TorrentSession) Subscribe(fn func(Event)) {
+	t.subscribers.l.Lock()
+	defer t.subscribers.l.Unlock()
+	t.subscribers.handlers = append(t.subscribers.handlers, fn)
+}
+
+func (t *TorrentSession) emit(e Event) {
+	t.subscribers.l.RLock()
+	defer t.subscribers.l.RUnlock()
+	for _, fn := range t.subscribers.handlers {
+		fn(e)
+	}
+}
+
+// TorrentFailed is emitted once the download failed and was stopped,
+// e.g. as verified pieces could not be written to a full disk. It can
+// be started again with Retry.
+type TorrentFailed struct {
+	Err error
+}
+
+func (TorrentFailed) isEvent() {}
@@ -0,0 +1,87 @@
+package status
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// isBlocked reports whether the peer at the host:port addr must not be
+// contacted, see WithPeerFilter. Peers given by host name are not filtered.
+func (t *TorrentSession) isBlocked(addr string) bool {
+	if t.blocked == nil {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return false
+	}
+	return t.blocked(ap.Addr())
+}
+
+// peerAddr returns the host:port address of a peer at ip and port, as
+// listed by a tracker. IP addresses are normalized, so that the same peer
+// listed twice, e.g. once IPv4-mapped, has the same address. False is
+// returned for addresses that cannot be dialed.
+func peerAddr(ip string, port int64) (string, bool) {
+	if ip == "" || port <= 0 || port > math.MaxUint16 {
+		return "", false
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		// host names are resolved once dialed.
+		return net.JoinHostPort(ip, strconv.FormatInt(port, 10)), true
+	}
+	a = a.Unmap()
+	if a.IsUnspecified() || a.IsMulticast() || a == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return "", false
+	}
+	return netip.AddrPortFrom(a, uint16(port)).String(), true
+}
+
+// isSelf reports whether the peer at the host:port addr is this client,
+// either listening at one of the addresses passed to WithListenAddrs or
+// found to be this client during a handshake.
+func (t *TorrentSession) isSelf(addr string) bool {
+	if _, ok := t.peers.self.Load(addr); ok {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(t.listenAddrs, ap)
+}
+
+// DisconnectPeers closes the connections to the seeders and leechers whose
+// address blocked reports true for. Seeders are not contacted again while
+// the filter passed to WithPeerFilter blocks them. It returns the number
+// of closed connections.
+func (t *TorrentSession) DisconnectPeers(blocked func(netip.Addr) bool) int {
+	closed := 0
+	for _, m := range []*sync.Map{&t.peers.seeders, &t.peers.leechers} {
+		m.Range(func(key, value any) bool {
+			ap, err := netip.ParseAddrPort(key.(string))
+			if err != nil || !blocked(ap.Addr()) {
+				return true
+			}
+			p := value.(*peer.Peer)
+			if p.ConnectionStatus() != peer.ConnectionEstablished {
+				return true
+			}
+			t.logger.Info("disconnecting blocked peer", slog.String("end_peer", key.(string)))
+			if err := p.Close(); err != nil {
+				t.logger.Debug("failed to close blocked peer", slog.Any("err", err))
+			}
+			closed++
+			return true
+		})
+	}
+	return closed
+}
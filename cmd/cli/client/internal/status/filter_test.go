@@ -0,0 +1,146 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_PeerFilter(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.download.reconnect.interval = 50 * time.Millisecond
+
+	var block atomic.Bool
+	var rejected atomic.Int64
+	tr.blocked = func(netip.Addr) bool {
+		if block.Load() {
+			rejected.Add(1)
+			return true
+		}
+		return false
+	}
+
+	// the stub never unchokes, so the torrent is not downloaded.
+	s := newStubSeeder(t, int64(len(data)), data, 0, false)
+
+	block.Store(true)
+	tr.addManualPeers([]string{s.addr})
+	tr.download.wg.Add(1)
+	tr.keepAliveSeeders(s.addr) // returns right away, without dialing.
+	assert.Equal(t, int64(2), rejected.Load())
+
+	block.Store(false)
+	done := make(chan struct{})
+	tr.download.wg.Add(1)
+	go func() {
+		defer close(done)
+		tr.keepAliveSeeders(s.addr)
+	}()
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(s.addr)
+		return ok && v.(*peer.Peer).ConnectionStatus() == peer.ConnectionEstablished
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// peers that are not blocked stay connected.
+	assert.Zero(t, tr.DisconnectPeers(func(netip.Addr) bool { return false }))
+
+	block.Store(true)
+	assert.Equal(t, 1, tr.DisconnectPeers(func(addr netip.Addr) bool { return addr.IsLoopback() }))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked peer was contacted again")
+	}
+	_, ok := tr.peers.seeders.Load(s.addr)
+	assert.False(t, ok)
+}
+
+// listedPeer is a peer of a tracker response.
+type listedPeer = struct {
+	PeerID string
+	IP     string
+	Port   int64
+}
+
+func TestTracker_AddPeersSkipsInvalidAndSelf(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.listenAddrs = []netip.AddrPort{netip.MustParseAddrPort("192.168.1.2:6881")}
+
+	var l sync.Mutex
+	var dialed []string
+	tr.dial = func(_ context.Context, _, addr string) (net.Conn, error) {
+		l.Lock()
+		defer l.Unlock()
+		dialed = append(dialed, addr)
+		return nil, errors.New("unreachable")
+	}
+
+	resp := &tracker.Response{Peers: []listedPeer{
+		{IP: "10.0.0.1", Port: 6881},
+		{IP: "::ffff:10.0.0.1", Port: 6881}, // duplicate.
+		{IP: "10.0.0.1", Port: 6881},        // duplicate.
+		{IP: "10.0.0.2", Port: 0},           // no port.
+		{IP: "10.0.0.3", Port: 1 << 16},     // out of range.
+		{IP: "0.0.0.0", Port: 6881},         // unspecified.
+		{IP: "::", Port: 6881},              // unspecified.
+		{IP: "224.0.0.1", Port: 6881},       // multicast.
+		{IP: "255.255.255.255", Port: 6881}, // broadcast.
+		{IP: "", Port: 6881},                // no address.
+		{IP: "192.168.1.2", Port: 6881},     // listen address.
+		{PeerID: tr.clientID, IP: "10.0.0.4", Port: 6881},
+		{IP: "10.0.0.5", Port: 6881},
+		{IP: "2001:db8::1", Port: 6881},
+	}}
+	assert.NoError(t, tr.UpdateSeeders(resp))
+	t.Cleanup(tr.CancelDownload)
+
+	want := []string{"10.0.0.1:6881", "10.0.0.5:6881", "[2001:db8::1]:6881"}
+	assert.Eventually(t, func() bool {
+		l.Lock()
+		defer l.Unlock()
+		return len(dialed) >= len(want)
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	l.Lock()
+	defer l.Unlock()
+	assert.ElementsMatch(t, want, dialed)
+}
+
+func TestTracker_SelfConnection(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	// the stub answers with the peer id of this client.
+	tr.clientID = "-ST0001-000000000000"
+	s := newStubSeeder(t, int64(len(data)), data, 0, false)
+
+	ap := netip.MustParseAddrPort(s.addr)
+	resp := &tracker.Response{Peers: []listedPeer{{IP: ap.Addr().String(), Port: int64(ap.Port())}}}
+
+	assert.NoError(t, tr.UpdateSeeders(resp))
+	t.Cleanup(tr.CancelDownload)
+	assert.Eventually(t, func() bool {
+		_, ok := tr.peers.connecting.Load(s.addr)
+		return tr.isSelf(s.addr) && !ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// it is not contacted again.
+	assert.NoError(t, tr.UpdateSeeders(resp))
+	_, ok := tr.peers.connecting.Load(s.addr)
+	assert.False(t, ok)
+	_, ok = tr.peers.seeders.Load(s.addr)
+	assert.False(t, ok)
+}
@@ -0,0 +1,45 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"syscall"
+)
+
+// maxFlushFailures is the number of times in a row a verified piece may
+// fail to be written before the download is stopped, for errors that
+// could be transient.
+const maxFlushFailures = 5
+
+// ErrFlushFailed is the reason for a failed download
+// if verified pieces could not be written to storage.
+var ErrFlushFailed = errors.New("failed to write verified pieces")
+
+// fatalFlushError reports whether err is not resolved by writing again,
+// such as a full disk or missing permissions.
+func fatalFlushError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, fs.ErrPermission)
+}
+
+// flushFailed counts the failed write of the piece, which is downloaded
+// again, and fails the download if err is fatal or the piece failed to
+// be written too often. The piece must be locked.
+func (t *TorrentSession) flushFailed(logger *slog.Logger, piece *pendingPiece, err error) {
+	piece.flushFailures++
+	logger.Error("failed to flush piece",
+		slog.Any("err", err),
+		slog.String("piece", fmt.Sprint(piece.Index)),
+		slog.Int("failures", piece.flushFailures),
+	)
+
+	switch {
+	case fatalFlushError(err):
+		t.fail(fmt.Errorf("%w: piece %d: %w", ErrFlushFailed, piece.Index, err))
+	case piece.flushFailures >= maxFlushFailures:
+		t.fail(fmt.Errorf("%w: piece %d failed %d times: %w", ErrFlushFailed, piece.Index, piece.flushFailures, err))
+	}
+}
@@ -0,0 +1,102 @@
+package status
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_FlushFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantWrites int
+	}{
+		{name: "disk full", err: syscall.ENOSPC, wantWrites: 1},
+		{name: "permission denied", err: syscall.EACCES, wantWrites: 1},
+		{name: "transient", err: syscall.EIO, wantWrites: maxFlushFailures},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, messagesv1.RequestSize)
+			for i := range data {
+				data[i] = byte(i * 5)
+			}
+			tr := newTestTracker(t, messagesv1.RequestSize, data)
+			tr.clientID = "-TT0100-000000000000"
+			disk := storagetest.NewFlakyStorage(storage.NewMemory(), 1, storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 1, Err: tt.err}))
+			WithStorage(disk)(tr)
+
+			var (
+				l      sync.Mutex
+				failed []TorrentFailed
+			)
+			tr.Subscribe(func(e Event) {
+				if e, ok := e.(TorrentFailed); ok {
+					l.Lock()
+					defer l.Unlock()
+					failed = append(failed, e)
+				}
+			})
+
+			// the stub seeders accept a single connection.
+			connect := func() {
+				seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+				tr.download.wg.Add(1)
+				go tr.keepAliveSeeders(seeder.addr)
+				assert.Eventually(t, func() bool {
+					v, ok := tr.peers.seeders.Load(seeder.addr)
+					return ok && v.(*peer.Peer).Bitfield.Check(0)
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+			connect()
+			tr.download.wg.Add(1)
+			go tr.downloadScheduler()
+
+			select {
+			case <-tr.Failed():
+			case <-tr.WaitUntilDownloaded():
+				t.Fatal("download should have failed")
+			case <-time.After(10 * time.Second):
+				t.Fatal("download neither completed nor failed")
+			}
+			tr.CancelDownload()
+
+			assert.ErrorIs(t, tr.Err(), ErrFlushFailed)
+			assert.ErrorIs(t, tr.Err(), tt.err)
+			_, writes := disk.Injected()
+			assert.Equal(t, tt.wantWrites, writes)
+			l.Lock()
+			if assert.Len(t, failed, 1) {
+				assert.Equal(t, tr.Err(), failed[0].Err)
+			}
+			l.Unlock()
+
+			// the download continues once the disk was fixed.
+			WithStorage(storage.NewMemory())(tr)
+			assert.NoError(t, tr.Retry())
+			assert.NoError(t, tr.Err())
+			connect()
+			// wake the scheduler idling as it started without peers.
+			select {
+			case tr.download.reclaimed <- struct{}{}:
+			default:
+			}
+			select {
+			case <-tr.WaitUntilDownloaded():
+			case <-tr.Failed():
+				t.Fatalf("retried download failed: %v", tr.Err())
+			case <-time.After(10 * time.Second):
+				t.Fatal("retried download did not complete")
+			}
+			assert.ErrorIs(t, tr.Retry(), ErrNotFailed)
+		})
+	}
+}
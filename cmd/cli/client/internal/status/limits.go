@@ -0,0 +1,123 @@
+package status
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// Limits are the limits of a torrent that can be changed while it
+// downloads, zero if unset.
+type Limits struct {
+	// MaxPeers is the number of seeders the torrent is connected to at
+	// most, within its share of the connections of all torrents.
+	MaxPeers int
+	// MaxActivePieces is the number of pieces downloaded concurrently,
+	// which is derived from the share of the torrent if unset.
+	MaxActivePieces int
+	// MaxPipeline is the number of unanswered requests sent to a seeder
+	// at most, which is derived from the rate of the seeder if unset.
+	MaxPipeline int
+}
+
+// Limits returns the limits of the torrent.
+func (t *TorrentSession) Limits() Limits {
+	return Limits{
+		MaxPeers:        int(t.download.maxPeers.Load()),
+		MaxActivePieces: int(t.download.maxActive.Load()),
+		MaxPipeline:     int(t.download.maxPipeline.Load()),
+	}
+}
+
+// SetMaxPeers changes the number of seeders the torrent is connected to at
+// most, a non-positive value leaves it unbounded. If fewer than connected,
+// the seeders contributing the least are disconnected, see shedSeeders.
+func (t *TorrentSession) SetMaxPeers(n int) {
+	t.download.maxPeers.Store(int64(max(n, 0)))
+	if n > 0 {
+		t.shedSeeders(n)
+	}
+}
+
+// SetMaxActivePieces changes the number of pieces downloaded concurrently,
+// a non-positive value derives it from the share of the torrent again. The
+// pieces already downloading are not interrupted, fewer pieces are started
+// once they complete.
+func (t *TorrentSession) SetMaxActivePieces(n int) {
+	t.download.maxActive.Store(int64(max(n, 0)))
+	if n > 0 {
+		t.download.active.setMax(n)
+		return
+	}
+	t.download.active.setMax(defaultActivePieces(t.meta.PieceLength))
+	t.coordinator.reshare()
+}
+
+// SetMaxPipeline changes the number of unanswered requests sent to a seeder
+// at most, a non-positive value derives it from the rate of the seeder
+// again. The requests already sent are not cancelled.
+func (t *TorrentSession) SetMaxPipeline(n int) {
+	t.download.maxPipeline.Store(int64(max(n, 0)))
+}
+
+// seederLimit returns the number of seeders the torrent may be connected
+// to, the lower of its share and MaxPeers, or zero if unbounded.
+func (t *TorrentSession) seederLimit() int64 {
+	share, limit := t.download.connShare.Load(), t.download.maxPeers.Load()
+	switch {
+	case share <= 0:
+		return limit
+	case limit <= 0:
+		return share
+	default:
+		return min(share, limit)
+	}
+}
+
+// shedSeeders disconnects the seeders beyond the first n. Those that
+// are snubbed go first, then those choking this client, then the ones
+// that delivered the lowest rate, so that the download keeps the
+// seeders it depends on. The requests in flight at the disconnected
+// seeders are re-queued right away, to be sent to the remaining ones.
+func (t *TorrentSession) shedSeeders(n int) {
+	var seeders []*peer.Peer
+	t.peers.seeders.Range(func(_, value any) bool {
+		if p := value.(*peer.Peer); p.ConnectionStatus() == peer.ConnectionEstablished {
+			seeders = append(seeders, p)
+		}
+		return true
+	})
+	if len(seeders) <= n {
+		return
+	}
+
+	rank := func(p *peer.Peer) (snubbed, choked bool, rate int64) {
+		return t.isSnubbed(p.Addr), p.Status.Remote.Load() != uint32(peer.UnChoked), t.peerRate(p.Addr)
+	}
+	slices.SortStableFunc(seeders, func(a, b *peer.Peer) int {
+		as, ac, ar := rank(a)
+		bs, bc, br := rank(b)
+		return cmp.Or(compareBool(as, bs), compareBool(ac, bc), cmp.Compare(br, ar))
+	})
+	for _, p := range seeders[n:] {
+		t.logger.Info("disconnecting seeder over the peer limit", slog.String("end_peer", p.Addr), slog.Int("limit", n))
+		if err := p.Close(); err != nil {
+			t.logger.Debug("failed to close seeder over the peer limit", slog.Any("err", err))
+		}
+		t.requeueInFlight(p.Addr)
+	}
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
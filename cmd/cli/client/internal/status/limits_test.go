@@ -0,0 +1,137 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_SetMaxPeers(t *testing.T) {
+	data := make([]byte, messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.conns = NewConnLimit(0)
+
+	// the torrent is not downloaded, as the unchoking stub never answers.
+	silent := func(s *stubSeeder) { s.ignore = func(messagesv1.Request) bool { return true } }
+	unchoking := newStubSeeder(t, int64(len(data)), data, 0, true, silent)
+	stubs := []*stubSeeder{
+		newStubSeeder(t, int64(len(data)), data, 0, false),
+		unchoking,
+		newStubSeeder(t, int64(len(data)), data, 0, false),
+	}
+	tr.download.wg.Add(len(stubs))
+	for _, s := range stubs {
+		go tr.keepAliveSeeders(s.addr)
+	}
+	t.Cleanup(tr.CancelDownload)
+
+	connected := func() []string {
+		var addrs []string
+		tr.peers.seeders.Range(func(key, value any) bool {
+			if value.(*peer.Peer).ConnectionStatus() == peer.ConnectionEstablished {
+				addrs = append(addrs, key.(string))
+			}
+			return true
+		})
+		return addrs
+	}
+	assert.Eventually(t, func() bool { return len(connected()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(unchoking.addr)
+		return ok && v.(*peer.Peer).Status.Remote.Load() == uint32(peer.UnChoked)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the seeders choking this client are shed first.
+	tr.SetMaxPeers(1)
+	assert.Equal(t, Limits{MaxPeers: 1}, tr.Limits())
+	assert.Eventually(t, func() bool { return len(connected()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{unchoking.addr}, connected(), "shed seeders were connected again")
+}
+
+func TestTracker_LimitsReducedWhileDownloading(t *testing.T) {
+	const numPieces = 16
+	data := make([]byte, numPieces*messagesv1.RequestSize)
+	var pieces [][]byte
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	for i := range numPieces {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	tr.conns = NewConnLimit(0)
+	WithMaxActivePieces(4)(tr)
+
+	// the stubs answer a request every 20ms, so that the download lasts.
+	slow := func(s *stubSeeder) {
+		s.ignore = func(messagesv1.Request) bool { time.Sleep(20 * time.Millisecond); return false }
+	}
+	for range 3 {
+		s := newStubSeeder(t, messagesv1.RequestSize, data, 0, true, slow)
+		tr.download.wg.Add(1)
+		go tr.keepAliveSeeders(s.addr)
+	}
+	seeders := func() int {
+		var n int
+		tr.peers.seeders.Range(func(_, value any) bool {
+			if value.(*peer.Peer).ConnectionStatus() == peer.ConnectionEstablished {
+				n++
+			}
+			return true
+		})
+		return n
+	}
+	assert.Eventually(t, func() bool { return seeders() == 3 }, 5*time.Second, 10*time.Millisecond)
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+	assert.Eventually(t, func() bool { return len(tr.have.ExistingPieces()) >= 2 }, 5*time.Second, time.Millisecond)
+
+	tr.SetMaxPeers(1)
+	tr.SetMaxActivePieces(2)
+	tr.SetMaxPipeline(1)
+	assert.Equal(t, Limits{MaxPeers: 1, MaxActivePieces: 2, MaxPipeline: 1}, tr.Limits())
+
+	// the pieces downloading are completed before fewer are started, and
+	// the requests sent are answered before fewer are sent.
+	pipelined := func() bool {
+		for _, n := range tr.outstandingRequests() {
+			if n > 1 {
+				return false
+			}
+		}
+		return true
+	}
+	converged := false
+	timeout := time.After(10 * time.Second)
+loop:
+	for {
+		select {
+		case <-tr.WaitUntilDownloaded():
+			break loop
+		case <-timeout:
+			t.Fatal("torrent was not downloaded after the limits were reduced")
+		default:
+		}
+		if !converged {
+			converged = tr.download.active.len() <= 2 && seeders() <= 1 && pipelined()
+		} else {
+			assert.LessOrEqual(t, tr.download.active.len(), 2)
+			assert.LessOrEqual(t, seeders(), 1)
+			for addr, n := range tr.outstandingRequests() {
+				assert.LessOrEqual(t, n, 1, "requests outstanding with %s", addr)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tr.download.wg.Wait()
+
+	assert.True(t, converged, "limits were not reached before the download completed")
+	assert.Empty(t, tr.have.MissingPieces())
+}
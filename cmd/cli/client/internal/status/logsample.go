@@ -0,0 +1,63 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+)
+
+// DefaultBlockLogSampling is the number of blocks one of which is
+// logged by the debug lines written for every block, see
+// WithBlockLogSampling.
+const DefaultBlockLogSampling = 64
+
+// blockLog samples the debug lines written for every block requested,
+// received or uploaded, which at high rates would flood the log.
+type blockLog struct {
+	// every is the sampling rate, DefaultBlockLogSampling if not positive.
+	every int
+	// requested, received and uploaded count the blocks of each line.
+	requested, received, uploaded atomic.Uint64
+}
+
+// sampleBlock reports whether the debug line of a block counted by n is
+// written to logger. The first and last blocks of a piece, edge, are
+// always logged, one in every blockLog.every of the others. It is called
+// before the attributes of the line are evaluated, so that the lines not
+// written cost no formatting.
+func (t *TorrentSession) sampleBlock(logger *slog.Logger, n *atomic.Uint64, edge bool) bool {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return false
+	}
+	if edge {
+		return true
+	}
+	every := uint64(DefaultBlockLogSampling)
+	if t.blockLog.every > 0 {
+		every = uint64(t.blockLog.every)
+	}
+	return n.Add(1)%every == 0
+}
+
+// edgeBlock reports whether req is the first or the last block of a piece of size bytes.
+func edgeBlock(req messagesv1.Request, size int64) bool {
+	return req.Begin == 0 || int64(req.Begin)+int64(req.Length) >= size
+}
+
+// logReceivedBlock logs the block recv used for piece, if sampled.
+func (t *TorrentSession) logReceivedBlock(logger *slog.Logger, piece *pendingPiece, recv *messagesv1.Piece) {
+	edge := piece.Downloaded == int64(len(recv.Block)) || piece.Downloaded == piece.Size
+	if !t.sampleBlock(logger, &t.blockLog.received, edge) {
+		return
+	}
+	status := float64(piece.Downloaded) / float64(piece.Size)
+	status *= 100
+	logger.Debug("received piece",
+		slog.String("piece", fmt.Sprint(recv.Index)),
+		slog.String("downloaded_bytes", fmt.Sprint(piece.Downloaded)),
+		slog.String("status", fmt.Sprintf("%.2f%%", status)),
+	)
+}
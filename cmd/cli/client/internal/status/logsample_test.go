@@ -0,0 +1,82 @@
+package status
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveBlocks passes the blocks of a piece of n blocks to logReceivedBlock.
+func receiveBlocks(t *TorrentSession, logger *slog.Logger, n int) {
+	p := &pendingPiece{Size: int64(n) * messagesv1.RequestSize}
+	block := make([]byte, messagesv1.RequestSize)
+	for i := range n {
+		p.Downloaded += messagesv1.RequestSize
+		t.logReceivedBlock(logger, p, &messagesv1.Piece{Begin: uint32(i) * messagesv1.RequestSize, Block: block})
+	}
+}
+
+func TestTorrentSession_BlockLogSampling(t *testing.T) {
+	tests := []struct {
+		name  string
+		every int
+		want  int
+	}{
+		// the first and last block, and blocks 4, 8, ..., 96 of the others.
+		{name: "sampled", every: 4, want: 2 + 24},
+		{name: "default", want: 2 + 1},
+		{name: "every block", every: 1, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t, 1, []byte{0x1})
+			WithBlockLogSampling(tt.every)(tr)
+
+			var out bytes.Buffer
+			receiveBlocks(tr, slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})), 100)
+			assert.Equal(t, tt.want, strings.Count(out.String(), "received piece"))
+		})
+	}
+
+	// nothing is counted unless debug lines are written.
+	tr := newTestTracker(t, 1, []byte{0x1})
+	receiveBlocks(tr, slog.New(slog.NewTextHandler(io.Discard, nil)), 100)
+	assert.Zero(t, tr.blockLog.received.Load())
+}
+
+func TestEdgeBlock(t *testing.T) {
+	const size = 3 * messagesv1.RequestSize
+	assert.True(t, edgeBlock(messagesv1.Request{Begin: 0, Length: messagesv1.RequestSize}, size))
+	assert.False(t, edgeBlock(messagesv1.Request{Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}, size))
+	assert.True(t, edgeBlock(messagesv1.Request{Begin: 2 * messagesv1.RequestSize, Length: messagesv1.RequestSize}, size))
+}
+
+// BenchmarkBlockLogSampling measures the cost of the debug line of a
+// received block with debug logging enabled, by the sampling rate.
+func BenchmarkBlockLogSampling(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	rates := []struct {
+		name  string
+		every int
+	}{
+		{name: "every", every: 1},
+		{name: "default", every: DefaultBlockLogSampling},
+	}
+	for _, r := range rates {
+		b.Run(r.name, func(b *testing.B) {
+			tr := &TorrentSession{}
+			WithBlockLogSampling(r.every)(tr)
+			p := &pendingPiece{Size: 1 << 30, Downloaded: 2 * messagesv1.RequestSize}
+			recv := &messagesv1.Piece{Begin: messagesv1.RequestSize, Block: make([]byte, messagesv1.RequestSize)}
+			b.ReportAllocs()
+			for range b.N {
+				tr.logReceivedBlock(logger, p, recv)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package status
+
+import "fmt"
+
+const (
+	// baseTorrentMemory is the memory a torrent holds regardless of its
+	// size: the goroutines of the download and upload, their channels,
+	// and the maps of the peers, trackers and statistics.
+	baseTorrentMemory = 512 << 10
+	// pieceMemory is the memory a torrent holds per piece: its hash in the
+	// metainfo, its bits in the bitfields, its availability counter and
+	// its scheduling state.
+	pieceMemory = 64
+	// connMemory is the memory a torrent holds per connection to a peer:
+	// the stacks of its goroutines, its queues and read buffers.
+	connMemory = 96 << 10
+)
+
+// TorrentCost are the properties of a torrent its memory is estimated by.
+type TorrentCost struct {
+	// Pieces is the number of pieces, and PieceLength their length.
+	Pieces      int64
+	PieceLength int64
+	// Slots is the number of pieces buffered while they are downloaded.
+	Slots int
+	// Conns is the number of connections to peers.
+	Conns int
+}
+
+// Memory returns the estimated bytes held by a downloading torrent.
+func (c TorrentCost) Memory() int64 {
+	return baseTorrentMemory +
+		c.Pieces*pieceMemory +
+		int64(c.Slots)*c.PieceLength +
+		int64(c.Conns)*connMemory
+}
+
+// Cost returns the properties the memory of the torrent is estimated by.
+// Unless configured, the slots of a torrent are its least share of the
+// piece data downloaded concurrently by the torrents of a Coordinator,
+// which accounts for that data once, and its connections are those asked
+// for by an announce, within the connections shared by all torrents.
+func (t *TorrentSession) Cost() TorrentCost {
+	c := TorrentCost{
+		Pieces:      t.meta.NumPieces(),
+		PieceLength: t.meta.PieceLength,
+		Slots:       int(t.download.maxActive.Load()),
+		Conns:       int(max(t.announce.numWant, DefaultNumWant)),
+	}
+	if c.Slots <= 0 {
+		c.Slots = minActivePieces
+		if t.coordinator == nil {
+			c.Slots = defaultActivePieces(t.meta.PieceLength)
+		}
+	}
+	if t.conns != nil {
+		if _, limit := t.conns.Stats(); limit > 0 {
+			c.Conns = min(c.Conns, limit)
+		}
+	}
+	return c
+}
+
+// QueueReason returns why the download of a queued torrent waits,
+// empty if it is not queued.
+func (t *TorrentSession) QueueReason() string {
+	if !t.queued.Load() {
+		return ""
+	}
+	if r := t.queueReason.Load(); r != nil {
+		return *r
+	}
+	return ""
+}
+
+// queue marks the torrent as queued for reason.
+func (t *TorrentSession) queue(reason string) {
+	t.queueReason.Store(&reason)
+	t.queued.Store(true)
+}
+
+// formatMemory formats n bytes in MiB, for the queue reasons.
+func formatMemory(n int64) string { return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20)) }
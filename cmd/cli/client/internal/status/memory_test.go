@@ -0,0 +1,82 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTorrentCost_Memory(t *testing.T) {
+	empty := TorrentCost{}
+	assert.Equal(t, int64(baseTorrentMemory), empty.Memory())
+
+	c := TorrentCost{Pieces: 1000, PieceLength: 1 << 20, Slots: 4, Conns: 10}
+	assert.Equal(t, int64(baseTorrentMemory+1000*pieceMemory+4<<20+10*connMemory), c.Memory())
+
+	// larger pieces and more connections cost more.
+	larger := c
+	larger.PieceLength = 4 << 20
+	assert.Greater(t, larger.Memory(), c.Memory())
+	larger = c
+	larger.Conns = 20
+	assert.Greater(t, larger.Memory(), c.Memory())
+}
+
+func TestTorrentSession_Cost(t *testing.T) {
+	const pieceLength = 1 << 20
+	tr := newTestTracker(t, pieceLength, []byte{0x1}, []byte{0x2})
+	assert.Equal(t, TorrentCost{Pieces: 2, PieceLength: pieceLength, Slots: defaultActivePieces(pieceLength), Conns: DefaultNumWant}, tr.Cost())
+
+	// the piece data of a coordinated torrent is shared with the others.
+	WithCoordinator(NewCoordinator(nil, 0, 0), PriorityNormal)(tr)
+	assert.Equal(t, minActivePieces, tr.Cost().Slots)
+	WithMaxActivePieces(8)(tr)
+	assert.Equal(t, 8, tr.Cost().Slots)
+
+	WithNumWant(50)(tr)
+	assert.Equal(t, 50, tr.Cost().Conns)
+	WithConnLimit(NewConnLimit(30))(tr)
+	assert.Equal(t, 30, tr.Cost().Conns)
+}
+
+func TestCoordinator_MemoryBudget(t *testing.T) {
+	const pieceLength = 1 << 20
+	newTracker := func(c *Coordinator, p Priority) *TorrentSession {
+		tr := newTestTracker(t, pieceLength, []byte{0x1})
+		WithCoordinator(c, p)(tr)
+		t.Cleanup(tr.CancelDownload)
+		return tr
+	}
+	// the budget fits the outstanding piece data and two torrents.
+	cost := newTracker(NewCoordinator(nil, 0, 0), PriorityNormal).Cost().Memory()
+	c := NewCoordinator(nil, 0, targetOutstandingBytes+2*cost+cost/2)
+
+	first, second, third, fourth := newTracker(c, PriorityNormal), newTracker(c, PriorityNormal), newTracker(c, PriorityNormal), newTracker(c, PriorityHigh)
+	assert.True(t, c.admit(first))
+	assert.True(t, c.admit(second))
+	assert.False(t, c.admit(third))
+	assert.False(t, c.admit(fourth))
+	assert.True(t, third.Queued())
+	assert.Contains(t, third.QueueReason(), "memory budget")
+	assert.Empty(t, first.QueueReason())
+	reserved, budget := c.MemoryStats()
+	assert.Equal(t, 2*cost, reserved)
+	assert.Equal(t, targetOutstandingBytes+2*cost+cost/2, budget)
+
+	// the freed memory starts the queued torrent of higher priority.
+	c.leave(first)
+	assert.False(t, fourth.Queued())
+	assert.True(t, third.Queued())
+	c.leave(second)
+	assert.False(t, third.Queued())
+	reserved, _ = c.MemoryStats()
+	assert.Equal(t, 2*cost, reserved)
+
+	// a torrent exceeding the budget on its own still downloads alone.
+	small := NewCoordinator(nil, 0, 1)
+	alone, queued := newTracker(small, PriorityNormal), newTracker(small, PriorityNormal)
+	assert.True(t, small.admit(alone))
+	assert.False(t, small.admit(queued))
+	small.leave(alone)
+	assert.False(t, queued.Queued())
+}
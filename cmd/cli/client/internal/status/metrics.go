@@ -0,0 +1,131 @@
+package status
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// flushBuckets are the upper bounds, in seconds, of the
+// buckets of the histogram of the piece flush latencies.
+var flushBuckets = [...]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram is a snapshot of a histogram of durations.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets in seconds.
+	Bounds []float64
+	// Counts are the number of observations per bucket, with
+	// one more bucket than bounds for those above the last.
+	Counts []int64
+	// Sum is the sum of the observations in seconds.
+	Sum float64
+}
+
+// flushHistogram counts the flush latencies into the
+// flushBuckets without locking, its zero value is usable.
+type flushHistogram struct {
+	counts [len(flushBuckets) + 1]atomic.Int64
+	// sum is the sum of the observations in nanoseconds.
+	sum atomic.Int64
+}
+
+func (h *flushHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(flushBuckets) && d.Seconds() > flushBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *flushHistogram) snapshot() Histogram {
+	s := Histogram{Bounds: flushBuckets[:], Counts: make([]int64, len(h.counts))}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	s.Sum = time.Duration(h.sum.Load()).Seconds()
+	return s
+}
+
+// metrics are the counters of a torrent that are not kept for its status
+// already. They are updated on the hot paths, so only atomics are used.
+type metrics struct {
+	// received are the bytes of the blocks received from peers.
+	received          atomic.Int64
+	hashFailures      atomic.Int64
+	announceSuccesses atomic.Int64
+	announceFailures  atomic.Int64
+	flush             flushHistogram
+}
+
+// Metrics is a snapshot of the counters of a torrent for monitoring.
+type Metrics struct {
+	// Received and Uploaded are the bytes of the blocks received from and
+	// uploaded to peers, DownloadRate and UploadRate their smoothed rates
+	// in bytes per second. Unlike Received, Downloaded counts the bytes of
+	// the verified pieces only, including those of previous runs.
+	Received, Uploaded       int64
+	Downloaded               int64
+	DownloadRate, UploadRate int64
+	// SeedersChoking and SeedersUnchoking are the connected seeders
+	// that choke this client or not.
+	SeedersChoking, SeedersUnchoking int64
+	// LeechersChoked and LeechersUnchoked are the connected leechers
+	// that this client chokes or not.
+	LeechersChoked, LeechersUnchoked int64
+	// PiecesVerified is the number of pieces that passed verification,
+	// HashFailures the number of pieces that failed it.
+	PiecesVerified, HashFailures int64
+	// AnnounceSuccesses and AnnounceFailures are the number of
+	// announces the tracker answered, or failed to.
+	AnnounceSuccesses, AnnounceFailures int64
+	// FlushLatency are the times taken to write the verified pieces.
+	FlushLatency Histogram
+	// ResumeWrites is the number of times the resume data was written,
+	// ResumeCoalesced the number of flushed pieces that did not cause a
+	// write of their own, as they were persisted together with later ones.
+	ResumeWrites, ResumeCoalesced int64
+}
+
+// Metrics returns the counters of the torrent for monitoring.
+func (t *TorrentSession) Metrics() Metrics {
+	transfer := t.TransferStats()
+	m := Metrics{
+		Received:          t.metrics.received.Load(),
+		Downloaded:        t.downloaded.Load(),
+		Uploaded:          t.uploaded.Load(),
+		DownloadRate:      transfer.DownloadRate,
+		UploadRate:        transfer.UploadRate,
+		PiecesVerified:    t.download.pipeline.verified.Load(),
+		HashFailures:      t.metrics.hashFailures.Load(),
+		AnnounceSuccesses: t.metrics.announceSuccesses.Load(),
+		AnnounceFailures:  t.metrics.announceFailures.Load(),
+		FlushLatency:      t.metrics.flush.snapshot(),
+		ResumeWrites:      t.durability.writes.Load(),
+		ResumeCoalesced:   t.durability.coalesced.Load(),
+	}
+	t.peers.seeders.Range(func(_, value any) bool {
+		p := value.(*peer.Peer)
+		switch {
+		case p.ConnectionStatus() != peer.ConnectionEstablished:
+		case p.Status.Remote.Load() == uint32(peer.Choked):
+			m.SeedersChoking++
+		default:
+			m.SeedersUnchoking++
+		}
+		return true
+	})
+	t.peers.leechers.Range(func(_, value any) bool {
+		p := value.(*peer.Peer)
+		switch {
+		case p.ConnectionStatus() != peer.ConnectionEstablished:
+		case p.Status.This.Load() == uint32(peer.Choked):
+			m.LeechersChoked++
+		default:
+			m.LeechersUnchoked++
+		}
+		return true
+	})
+	return m
+}
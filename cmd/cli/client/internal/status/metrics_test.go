@@ -0,0 +1,44 @@
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushHistogram(t *testing.T) {
+	var h flushHistogram
+	for _, d := range []time.Duration{0, time.Millisecond, 2 * time.Millisecond, 3 * time.Second, time.Minute} {
+		h.observe(d)
+	}
+
+	s := h.snapshot()
+	assert.Len(t, s.Counts, len(s.Bounds)+1)
+	want := make([]int64, len(s.Bounds)+1)
+	want[0] = 2             // up to 1ms, inclusive.
+	want[1] = 1             // up to 5ms.
+	want[10] = 1            // up to 5s.
+	want[len(s.Bounds)] = 1 // above the last bound.
+	assert.Equal(t, want, s.Counts)
+	assert.InDelta(t, 63.003, s.Sum, 1e-9)
+}
+
+func TestTracker_Metrics(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1})
+	tr.RecordAnnounce(&tracker.Response{}, nil, time.Now())
+	tr.RecordAnnounce(nil, errors.New("unreachable"), time.Now())
+	tr.RecordAnnounce(nil, &tracker.FailureError{Reason: "refused"}, time.Now())
+	assert.NoError(t, tr.Flush(0, []byte{0x1}))
+
+	m := tr.Metrics()
+	assert.Equal(t, int64(1), m.AnnounceSuccesses)
+	assert.Equal(t, int64(2), m.AnnounceFailures)
+	var flushed int64
+	for _, n := range m.FlushLatency.Counts {
+		flushed += n
+	}
+	assert.Equal(t, int64(1), flushed)
+}
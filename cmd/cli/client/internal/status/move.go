@@ -0,0 +1,37 @@
+package status
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+)
+
+// moveDownload moves the download directory into the directory
+// passed to WithMoveOnComplete and emits the outcome.
+func (t *TorrentSession) moveDownload() {
+	from := t.DownloadDir()
+	to := filepath.Join(t.moveTo, filepath.Base(from))
+	if from == to {
+		return // resumed from the destination.
+	}
+
+	// the resume data is not persisted while moving the directory.
+	t.durability.l.Lock()
+	defer t.durability.l.Unlock()
+
+	var err error
+	if t.files == nil {
+		err = errors.New("moving is only supported for the default storage")
+	} else {
+		err = t.files.Move(to)
+	}
+	if err != nil {
+		t.logger.Error("failed to move downloaded torrent", slog.String("to", to), slog.Any("err", err))
+		t.emit(MoveFailed{To: to, Err: err})
+		return
+	}
+
+	t.setDownloadDir(to)
+	t.logger.Info("moved downloaded torrent", slog.String("from", from), slog.String("to", to))
+	t.emit(Moved{From: from, To: to})
+}
@@ -0,0 +1,158 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_MoveOnComplete(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing creates the destination of the move beforehand.
+		existing bool
+	}{
+		{name: "moved"},
+		{name: "destination exists", existing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, 2*messagesv1.RequestSize)
+			for i := range data {
+				data[i] = byte(i * 3)
+			}
+			tr := newTestTracker(t, messagesv1.RequestSize, data[:messagesv1.RequestSize], data[messagesv1.RequestSize:])
+			tr.clientID = "-TT0100-000000000000"
+			tr.files = storage.NewPieceFiles(tr.DownloadDir())
+			tr.storage = tr.files
+
+			library := t.TempDir()
+			WithMoveOnComplete(library)(tr)
+			from, to := tr.DownloadDir(), filepath.Join(library, filepath.Base(tr.DownloadDir()))
+			if tt.existing {
+				assert.NoError(t, os.Mkdir(to, os.ModePerm))
+			}
+
+			events := make(chan Event, 2)
+			tr.Subscribe(func(e Event) { events <- e })
+
+			seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+			tr.download.wg.Add(1)
+			go tr.keepAliveSeeders(seeder.addr)
+			assert.Eventually(t, func() bool {
+				v, ok := tr.peers.seeders.Load(seeder.addr)
+				return ok && v.(*peer.Peer).Bitfield.Check(0)
+			}, 5*time.Second, 10*time.Millisecond)
+
+			tr.download.wg.Add(1)
+			go tr.downloadScheduler()
+
+			select {
+			case <-tr.WaitUntilDownloaded():
+			case <-time.After(5 * time.Second):
+				t.Fatal("torrent was not downloaded")
+			}
+			tr.download.wg.Wait()
+
+			if tt.existing {
+				failed, ok := (<-events).(MoveFailed)
+				assert.True(t, ok)
+				assert.ErrorIs(t, failed.Err, storage.ErrDestinationExists)
+				assert.Equal(t, from, tr.DownloadDir())
+			} else {
+				assert.Equal(t, Moved{From: from, To: to}, <-events)
+				assert.Equal(t, to, tr.DownloadDir())
+				_, err := os.Stat(from)
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			}
+
+			// seeding continues from wherever the data is.
+			b, err := tr.ReadRequest(&messagesv1.Request{Index: 1, Length: messagesv1.RequestSize})
+			assert.NoError(t, err)
+			assert.Equal(t, data[messagesv1.RequestSize:], b)
+		})
+	}
+}
+
+func TestTracker_CompleteAfterMove(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 5)
+	}
+	tr := newTestTracker(t, messagesv1.RequestSize, data[:messagesv1.RequestSize], data[messagesv1.RequestSize:])
+	tr.clientID = "-TT0100-000000000000"
+	tr.files = storage.NewPieceFiles(tr.DownloadDir())
+	tr.storage = tr.files
+	tr.added = time.Now()
+
+	library := t.TempDir()
+	to := filepath.Join(library, filepath.Base(tr.DownloadDir()))
+	WithMoveOnComplete(library)(tr)
+
+	// each step records whether the files were in place at that time.
+	var steps []string
+	paths := []string{filepath.Join(to, "test.bin")}
+	moved := func() bool {
+		for _, p := range paths {
+			if _, err := os.Stat(p); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+	tr.Subscribe(func(e Event) {
+		switch e := e.(type) {
+		case Moved:
+			steps = append(steps, "moved")
+		case TorrentCompleted:
+			assert.Equal(t, to, e.Dir)
+			assert.Equal(t, paths, e.Paths)
+			assert.Positive(t, e.Elapsed)
+			assert.True(t, moved())
+			steps = append(steps, "completed")
+		}
+	})
+	WithOnComplete(func(e TorrentCompleted) {
+		assert.True(t, moved())
+		select {
+		case <-tr.WaitUntilDownloaded():
+		default:
+			t.Error("hook ran before the torrent was seeding")
+		}
+		steps = append(steps, "hook")
+	})(tr)
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.download.wg.Wait()
+	assert.Equal(t, []string{"moved", "completed", "hook"}, steps)
+	b, err := os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// torrents that were complete when added do not complete again.
+	restored := newTestTracker(t, messagesv1.RequestSize, data)
+	restored.restoredComplete = true
+	restored.Subscribe(func(e Event) { t.Errorf("unexpected event %T", e) })
+	restored.complete()
+}
@@ -0,0 +1,47 @@
+package status
+
+const (
+	// DefaultNumWant is the number of peers the first announce asks for.
+	DefaultNumWant = 15
+	// MaxNumWant is the most peers an announce asks for.
+	MaxNumWant = 200
+	// lowUsablePeers is the number of usable peers below which
+	// announces ask for more peers, up to MaxNumWant without any.
+	lowUsablePeers = 8
+	// plentyLeechers is the number of connected leechers from
+	// which a seeding torrent asks for no more peers.
+	plentyLeechers = 20
+)
+
+// NumWant returns the number of peers the next announce asks for. The
+// first announce asks for the number passed to WithNumWant. Later ones ask
+// for none while all connections are used or while seeding to plenty of
+// leechers, and for more the fewer usable peers the torrent has.
+func (t *TorrentSession) NumWant() int64 {
+	t.announce.l.Lock()
+	base, announced := t.announce.numWant, t.announce.succeeded
+	t.announce.l.Unlock()
+
+	if !announced {
+		return base
+	}
+	if used, limit := t.conns.Stats(); limit > 0 && used >= limit {
+		return 0
+	}
+	if t.downloaded.Load() == t.meta.BytesToDownload() {
+		if _, leechers := t.PeerCounts(); leechers >= plentyLeechers {
+			return 0
+		}
+		return base
+	}
+	usable := min(t.usablePeers(), lowUsablePeers)
+	return max(base, int64(MaxNumWant*(lowUsablePeers-usable)/lowUsablePeers))
+}
+
+// RecordNumWant records the number of peers the
+// announce about to be sent asks for, see NumWant.
+func (t *TorrentSession) RecordNumWant(n int64) {
+	t.announce.l.Lock()
+	defer t.announce.l.Unlock()
+	t.announce.status.NumWant = n
+}
@@ -0,0 +1,59 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_NumWant(t *testing.T) {
+	tr := newTestTracker(t, messagesv1.RequestSize, make([]byte, messagesv1.RequestSize), make([]byte, messagesv1.RequestSize))
+	WithNumWant(30)(tr)
+
+	// the first announce asks for the configured number of peers.
+	assert.Equal(t, int64(30), tr.NumWant())
+	tr.RecordAnnounce(nil, errors.New("unreachable"), time.Now())
+	assert.Equal(t, int64(30), tr.NumWant())
+
+	tr.RecordNumWant(30)
+	tr.RecordAnnounce(&tracker.Response{Peers: []listedPeer{{IP: "10.0.0.1", Port: 6881}}}, nil, time.Now())
+	assert.Equal(t, int64(30), tr.TrackerStatus().NumWant)
+	assert.Equal(t, 1, tr.TrackerStatus().PeersReturned)
+
+	// without usable peers, as many peers as possible are asked for.
+	assert.Equal(t, int64(MaxNumWant), tr.NumWant())
+
+	usable := func(n int) {
+		for i := range n {
+			p := &peer.Peer{Bitfield: bitfield.NewBitfield(2)}
+			p.Bitfield.Set(1)
+			p.Status.Remote.Store(uint32(peer.UnChoked))
+			tr.peers.seeders.Store(fmt.Sprintf("10.0.0.%d:6881", i), p)
+		}
+	}
+	usable(lowUsablePeers / 2)
+	assert.Equal(t, int64(MaxNumWant/2), tr.NumWant())
+	usable(lowUsablePeers)
+	assert.Equal(t, int64(30), tr.NumWant())
+
+	// no peers are asked for while all connections are used.
+	tr.conns = NewConnLimit(1)
+	assert.True(t, tr.conns.TryAcquire())
+	assert.Zero(t, tr.NumWant())
+	tr.conns.Release()
+
+	// nor when seeding to plenty of leechers.
+	tr.downloaded.Store(tr.meta.BytesToDownload())
+	assert.Equal(t, int64(30), tr.NumWant())
+	for i := range plentyLeechers {
+		tr.peers.leechers.Store(fmt.Sprintf("10.0.1.%d:6881", i), &peer.Peer{})
+	}
+	assert.Zero(t, tr.NumWant())
+}
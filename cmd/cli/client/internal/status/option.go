@@ -0,0 +1,289 @@
+package status
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+)
+
+// Option configures a TorrentSession.
+type Option func(t *TorrentSession)
+
+// WithEndgameThreshold overrides the adaptive endgame policy with a fixed
+// threshold. The endgame is entered once no more than blocks blocks remain
+// to be downloaded. A non-positive value keeps the adaptive policy.
+func WithEndgameThreshold(blocks int) Option {
+	return func(t *TorrentSession) {
+		t.download.endgameBlocks = blocks
+	}
+}
+
+// WithSeedRatio stops seeding once the bytes uploaded in all runs
+// reach ratio times the size of the torrent. A non-positive ratio
+// does not limit seeding.
+func WithSeedRatio(ratio float64) Option {
+	return func(t *TorrentSession) {
+		t.upload.seedRatio = ratio
+	}
+}
+
+// WithSeedTime stops seeding once the given duration has
+// passed since the download completed. A non-positive
+// duration does not limit seeding.
+func WithSeedTime(d time.Duration) Option {
+	return func(t *TorrentSession) {
+		t.upload.seedTime = d
+	}
+}
+
+// WithPeerList uses the newline-delimited host:port entries of the
+// file at path as peers, in addition to the peers returned by the
+// tracker. The file is re-read when it changes. If writeBack is set
+// the peers discovered by other sources are appended to the file.
+func WithPeerList(path string, writeBack bool) Option {
+	return func(t *TorrentSession) {
+		t.peerList = &peerList{path: path, writeBack: writeBack}
+	}
+}
+
+// WithStorage persists the pieces in s instead of
+// a file per piece within the download directory.
+func WithStorage(s storage.Storage) Option {
+	return func(t *TorrentSession) {
+		t.storage = s
+	}
+}
+
+// WithDiskScheduler schedules the reads and writes of the
+// pieces on the passed scheduler, shared among torrents.
+func WithDiskScheduler(s *storage.Scheduler) Option {
+	return func(t *TorrentSession) {
+		t.disk = s
+	}
+}
+
+// WithPieceCache serves the blocks requested by leechers from the
+// pieces cached by c, shared among torrents.
+func WithPieceCache(c *storage.PieceCache) Option {
+	return func(t *TorrentSession) {
+		t.cache = c
+	}
+}
+
+// WithPeerPicker chooses the peers the requests are sent to with
+// picker instead of the default RatePicker.
+func WithPeerPicker(picker PeerPicker) Option {
+	return func(t *TorrentSession) {
+		t.download.picker = picker
+	}
+}
+
+// WithClock tells the time with c instead of the SystemClock,
+// e.g. to let tests control how time passes.
+func WithClock(c Clock) Option {
+	return func(t *TorrentSession) {
+		t.clock = c
+	}
+}
+
+// WithRand draws the random choices of the session, e.g. those of the
+// default RatePicker, from src instead of the math/rand/v2 functions.
+func WithRand(src rand.Source) Option {
+	return func(t *TorrentSession) {
+		t.rand = rand.New(src)
+	}
+}
+
+// WithMaxActivePieces sets the number of pieces downloaded concurrently.
+// A non-positive value keeps the default, which targets 64MiB of
+// outstanding piece data.
+func WithMaxActivePieces(n int) Option {
+	return func(t *TorrentSession) {
+		if n > 0 {
+			t.download.maxActive.Store(int64(n))
+			t.download.active.setMax(n)
+		}
+	}
+}
+
+// WithMaxPeers sets the number of seeders the torrent is connected to at
+// most, within its share of the connections of all torrents. A
+// non-positive value leaves it unbounded.
+func WithMaxPeers(n int) Option {
+	return func(t *TorrentSession) {
+		t.download.maxPeers.Store(int64(max(n, 0)))
+	}
+}
+
+// WithMaxPipeline sets the number of unanswered requests sent to a seeder
+// at most, which is otherwise derived from the rate of the seeder. A
+// non-positive value keeps the default.
+func WithMaxPipeline(n int) Option {
+	return func(t *TorrentSession) {
+		t.download.maxPipeline.Store(int64(max(n, 0)))
+	}
+}
+
+// WithCompactPieceStates holds the scheduling states of the pieces in a
+// table of a byte per piece, mapped from a temporary file where supported,
+// instead of a map. Torrents with many pieces always use the table.
+func WithCompactPieceStates() Option {
+	return func(t *TorrentSession) {
+		t.compactStates = true
+	}
+}
+
+// WithReadBackVerification reads each piece back after it was flushed and
+// verifies its hash again before it is marked as downloaded. Pieces that
+// do not match are downloaded again, and the download fails once too
+// many of them did, as that indicates a failing disk.
+func WithReadBackVerification() Option {
+	return func(t *TorrentSession) {
+		t.download.readBack = true
+	}
+}
+
+// WithSyncPolicy syncs the flushed pieces to the storage once the given
+// number of pieces were flushed or interval passed since the last sync,
+// whichever happens first, and persists the resume data afterwards. Only
+// synced pieces are recorded as downloaded in the resume data. A
+// non-positive value disables the respective trigger, the pieces are then
+// still synced when pausing, completing or closing the torrent. A crash
+// loses at most the pieces flushed since the last sync, fewer than the
+// given number or those of the interval, which are downloaded again.
+func WithSyncPolicy(pieces int, interval time.Duration) Option {
+	return func(t *TorrentSession) {
+		t.durability.pieces = pieces
+		t.durability.interval = interval
+	}
+}
+
+// WithBufferBudget bounds the memory held by the pieces of the torrent
+// that are downloaded, verified or flushed by b, which may be shared
+// among torrents.
+func WithBufferBudget(b *BufferBudget) Option {
+	return func(t *TorrentSession) {
+		t.buffers = b
+	}
+}
+
+// WithConnLimit bounds the connections to the seeders of the
+// torrent by c, which may be shared among torrents.
+func WithConnLimit(c *ConnLimit) Option {
+	return func(t *TorrentSession) {
+		t.conns = c
+	}
+}
+
+// WithCoordinator shares the active pieces and connections of the torrent
+// with the other torrents of c, weighted by priority. The download of the
+// torrent is queued until c has a slot for it.
+func WithCoordinator(c *Coordinator, priority Priority) Option {
+	return func(t *TorrentSession) {
+		t.coordinator = c
+		t.priority = priority
+	}
+}
+
+// WithPeerFilter prevents connecting to the peers whose address
+// blocked reports true for, e.g. as they are on a blocklist.
+func WithPeerFilter(blocked func(addr netip.Addr) bool) Option {
+	return func(t *TorrentSession) {
+		t.blocked = blocked
+	}
+}
+
+// WithBlockLogSampling logs one in every n of the debug lines written
+// for each block requested, received or uploaded, and always those of
+// the first and last block of a piece. A value of 1 logs every block, a
+// non-positive one keeps DefaultBlockLogSampling.
+func WithBlockLogSampling(n int) Option {
+	return func(t *TorrentSession) {
+		t.blockLog.every = n
+	}
+}
+
+// WithNumWant sets the number of peers the first announce asks for,
+// DefaultNumWant if not positive. Later announces adapt it, see NumWant.
+func WithNumWant(n int64) Option {
+	return func(t *TorrentSession) {
+		if n > 0 {
+			t.announce.numWant = n
+		}
+	}
+}
+
+// WithListenAddrs sets the addresses this client accepts connections on.
+// Peers listed at these addresses are this client and never contacted.
+func WithListenAddrs(addrs ...netip.AddrPort) Option {
+	return func(t *TorrentSession) {
+		t.listenAddrs = addrs
+	}
+}
+
+// WithAnnouncePort sets the port announced to the tracker and the DHT, if
+// positive, for clients whose listen port is translated by a NAT.
+func WithAnnouncePort(port int) Option {
+	return func(t *TorrentSession) {
+		t.announcePort = port
+	}
+}
+
+// WithDialer sets the function the connections to seeders and web
+// seeds are established with, e.g. to resolve host names through a cache.
+func WithDialer(dial peer.DialFunc) Option {
+	return func(t *TorrentSession) {
+		t.dial = dial
+	}
+}
+
+// WithDHTNodeHandler calls handle with the address of the DHT node
+// of each peer that advertises one with a PORT message.
+func WithDHTNodeHandler(handle func(node netip.AddrPort)) Option {
+	return func(t *TorrentSession) {
+		t.dhtNode = handle
+	}
+}
+
+// WithMoveOnComplete moves the download directory of the torrent into
+// dst once all pieces were verified and flushed. Seeding continues from
+// the new location. Only the default storage can be moved.
+func WithMoveOnComplete(dst string) Option {
+	return func(t *TorrentSession) {
+		t.moveTo = dst
+	}
+}
+
+// WithOnComplete calls fn synchronously once the torrent completed,
+// after TorrentCompleted was emitted and thus after any move.
+func WithOnComplete(fn func(TorrentCompleted)) Option {
+	return func(t *TorrentSession) {
+		t.onComplete = fn
+	}
+}
+
+// WithUploaded restores the bytes uploaded by the torrent
+// in a previous run, which count towards the seed ratio.
+func WithUploaded(n int64) Option {
+	return func(t *TorrentSession) {
+		t.uploaded.Store(n)
+	}
+}
+
+// WithAdded restores the time the torrent was first added.
+func WithAdded(added time.Time) Option {
+	return func(t *TorrentSession) {
+		t.added = added
+	}
+}
+
+// WithStartPaused adds the torrent without downloading it or contacting
+// any peers until Resume is called. The resume data is still loaded.
+func WithStartPaused() Option {
+	return func(t *TorrentSession) {
+		t.paused.Store(true)
+	}
+}
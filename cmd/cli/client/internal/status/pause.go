@@ -0,0 +1,151 @@
+package status
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+var (
+	// ErrNotPaused is returned when resuming a torrent that is not paused.
+	ErrNotPaused = errors.New("torrent is not paused")
+	// ErrPaused is returned when pausing a torrent that is already paused.
+	ErrPaused = errors.New("torrent is already paused")
+	// ErrNotFailed is returned when retrying a torrent that did not fail.
+	ErrNotFailed = errors.New("torrent did not fail")
+)
+
+// errPausedLeecher is returned when a leecher connects to a paused torrent.
+var errPausedLeecher = errors.New("torrent is paused, not accepting leechers")
+
+// Paused reports whether the torrent is paused.
+func (t *TorrentSession) Paused() bool { return t.paused.Load() }
+
+// Queued reports whether the download of the torrent waits
+// for other torrents of the coordinator to finish.
+func (t *TorrentSession) Queued() bool { return t.queued.Load() }
+
+// Pause stops downloading the torrent and disconnects its peers until
+// Resume is called. The paused state is persisted. UpdateSeeders must
+// not be called while the torrent is being paused.
+func (t *TorrentSession) Pause() error {
+	if !t.paused.CompareAndSwap(false, true) {
+		return ErrPaused
+	}
+	t.logger.Info("pausing torrent")
+
+	t.stopDownload()
+	t.closeLeechers()
+
+	return t.saveResume()
+}
+
+// closeLeechers disconnects the leechers of the paused torrent.
+func (t *TorrentSession) closeLeechers() {
+	t.peers.leechers.Range(func(_, value any) bool {
+		if err := value.(*peer.Peer).Close(); err != nil {
+			t.logger.Debug("failed to close leecher", slog.Any("err", err))
+		}
+		return true
+	})
+}
+
+// stopDownload stops the download goroutines, or removes the torrent
+// from the queue of the coordinator, so that the download can be
+// started again by startDownload.
+func (t *TorrentSession) stopDownload() {
+	t.coordinator.leave(t)
+	t.download.cancel.Load().cancel()
+	t.download.wg.Wait()
+
+	// the seeders were closed by their refreshers, they are contacted
+	// again once the tracker lists them after the download restarted.
+	t.peers.seeders.Clear()
+
+	// no download goroutines are running, the next download
+	// started is canceled on its own channel.
+	t.download.cancel.Store(newCancelation())
+}
+
+// Resume starts downloading a paused torrent. The paused state is
+// persisted, so that the torrent is no longer paused after a restart.
+func (t *TorrentSession) Resume() error {
+	if !t.paused.CompareAndSwap(true, false) {
+		return ErrNotPaused
+	}
+	t.logger.Info("resuming paused torrent")
+	t.startDownload()
+	return t.saveResume()
+}
+
+// Retry starts the download of a failed torrent again, e.g. once space
+// was freed on the full disk it failed on. A paused torrent is only
+// started again once resumed.
+func (t *TorrentSession) Retry() error {
+	f := t.download.failure.Load()
+	select {
+	case <-f.failed:
+	default:
+		return ErrNotFailed
+	}
+	t.logger.Info("retrying failed torrent", slog.Any("err", f.err))
+
+	t.stopDownload()
+	t.download.diskErrors.Store(0)
+	t.download.failure.Store(newFailure())
+	if !t.Paused() {
+		t.startDownload()
+	}
+	return nil
+}
+
+// startDownload spawns the goroutines that connect to peers and
+// web seeds and download the missing pieces, unless the download
+// already completed or failed, or is queued by the coordinator.
+func (t *TorrentSession) startDownload() {
+	select {
+	case <-t.download.completed:
+		return
+	case <-t.Failed():
+		return
+	default:
+	}
+
+	if !t.coordinator.admit(t) {
+		t.logger.Info("torrent queued, waiting for other torrents to finish downloading")
+		return
+	}
+	t.spawnDownload()
+}
+
+// dequeue starts the download of a queued torrent, and contacts the
+// peers announced while it was queued. It is called by the coordinator
+// once the torrent got a slot.
+func (t *TorrentSession) dequeue() {
+	if !t.queued.CompareAndSwap(true, false) {
+		return
+	}
+	t.logger.Info("torrent dequeued, starting download")
+	t.spawnDownload()
+	if resp := t.download.queuedPeers.Swap(nil); resp != nil {
+		if err := t.UpdateSeeders(resp); err != nil {
+			t.logger.Error("failed to update peers, attempting to continue", slog.Any("err", err))
+		}
+	}
+}
+
+func (t *TorrentSession) spawnDownload() {
+	t.download.wg.Add(1)
+	go t.downloadScheduler()
+
+	if t.peerList != nil {
+		t.download.wg.Add(1)
+		go t.watchPeerList()
+	}
+
+	for _, w := range t.webSeeds {
+		t.download.wg.Add(1)
+		go t.runWebSeed(w)
+	}
+}
@@ -0,0 +1,162 @@
+package status
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_StartPaused(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 5)
+	}
+	hash := sha1.Sum(data)
+
+	var requests, dials atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// hold back the data until the peer was dialed, as the
+		// peer list is no longer watched once the download completed.
+		for deadline := time.Now().Add(2 * time.Second); dials.Load() == 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		http.ServeContent(rw, r, "test.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	peers := filepath.Join(t.TempDir(), "peers.txt")
+	assert.NoError(t, os.WriteFile(peers, []byte(l.Addr().String()+"\n"), 0o644))
+
+	mi := &torrent.MetaInfoFile{
+		Info: torrent.Info{
+			InfoSingleFile: &torrent.InfoSingleFile{Name: "test.bin", Length: int64(len(data))},
+			PieceLength:    int64(len(data)),
+			Pieces:         hex.EncodeToString(hash[:]),
+		},
+		Announce: "http://localhost/announce",
+		UrlList:  []string{srv.URL + "/test.bin"},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	tr, err := NewTorrentSession("-TT0100-000000000000", logger, mi, dir, WithStartPaused(), WithPeerList(peers, false))
+	assert.NoError(t, err)
+	assert.True(t, tr.Paused())
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, tr.Close())
+
+	// the paused state is honored after a restart.
+	tr, err = NewTorrentSession("-TT0100-000000000000", logger, mi, dir, WithPeerList(peers, false))
+	assert.NoError(t, err)
+	assert.True(t, tr.Paused())
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, requests.Load(), "paused torrent contacted a web seed")
+	assert.Zero(t, dials.Load(), "paused torrent dialed a peer")
+
+	assert.NoError(t, tr.Resume())
+	assert.True(t, errors.Is(tr.Resume(), ErrNotPaused))
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed torrent was not downloaded")
+	}
+	assert.Positive(t, requests.Load())
+	assert.Positive(t, dials.Load())
+	assert.NoError(t, tr.Close())
+
+	tr, err = NewTorrentSession("-TT0100-000000000000", logger, mi, dir)
+	assert.NoError(t, err)
+	assert.False(t, tr.Paused())
+	assert.NoError(t, tr.Close())
+}
+
+func TestTracker_Pause(t *testing.T) {
+	const pieceLength = messagesv1.RequestSize
+	data := make([]byte, 8*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var pieces string
+	for i := 0; i < len(data); i += pieceLength {
+		hash := sha1.Sum(data[i : i+pieceLength])
+		pieces += hex.EncodeToString(hash[:])
+	}
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		http.ServeContent(rw, r, "test.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	mi := &torrent.MetaInfoFile{
+		Info: torrent.Info{
+			InfoSingleFile: &torrent.InfoSingleFile{Name: "test.bin", Length: int64(len(data))},
+			PieceLength:    pieceLength,
+			Pieces:         pieces,
+		},
+		Announce: "http://localhost/announce",
+		UrlList:  []string{srv.URL + "/test.bin"},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	tr, err := NewTorrentSession("-TT0100-000000000000", logger, mi, dir)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return requests.Load() > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, tr.Pause())
+	assert.ErrorIs(t, tr.Pause(), ErrPaused)
+	assert.True(t, tr.Paused())
+
+	paused := requests.Load()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, paused, requests.Load(), "paused torrent contacted a web seed")
+	assert.NotEmpty(t, tr.have.MissingPieces())
+
+	assert.NoError(t, tr.Resume())
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed torrent was not downloaded")
+	}
+	assert.NoError(t, tr.Pause())
+	assert.NoError(t, tr.Close())
+
+	// the paused state is honored after a restart.
+	tr, err = NewTorrentSession("-TT0100-000000000000", logger, mi, dir)
+	assert.NoError(t, err)
+	assert.True(t, tr.Paused())
+	assert.NoError(t, tr.Close())
+}
@@ -0,0 +1,183 @@
+package status
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/internal/atomicfile"
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// How often the peer list file is checked for changes.
+const peerListTick = 5 * time.Second
+
+// peerList is a newline-delimited file of host:port
+// entries that are used as a static peer source.
+type peerList struct {
+	path string
+	// writeBack persists peers discovered by other
+	// sources to the file.
+	writeBack bool
+	// tick overrides peerListTick, used in tests.
+	tick time.Duration
+}
+
+// readPeerList parses the peer list at path. Empty lines
+// and lines starting with # are ignored.
+func readPeerList(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer list: %w", err)
+	}
+
+	var out []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		entry := strings.TrimSpace(s.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer list entry at line %d: %w", line, err)
+		}
+		if addr := net.JoinHostPort(host, port); !slices.Contains(out, addr) {
+			out = append(out, addr)
+		}
+	}
+	return out, s.Err()
+}
+
+// writePeerList atomically replaces the peer list at path with addrs.
+func writePeerList(path string, addrs []string) error {
+	err := atomicfile.Write(path, 0o600, func(w io.Writer) error {
+		for _, a := range addrs {
+			if _, err := fmt.Fprintln(w, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace peer list: %w", err)
+	}
+	return nil
+}
+
+// addManualPeers starts connecting to the passed addresses. Peers that
+// were already added are skipped, reconnection attempts to peers that
+// are not reachable are made periodically by keepAliveSeeders.
+func (t *TorrentSession) addManualPeers(addrs []string) {
+	if t.downloaded.Load() == t.meta.BytesToDownload() {
+		return
+	}
+
+	var added []string
+	for _, addr := range addrs {
+		if _, ok := t.peers.banned.Load(addr); ok || t.isBlocked(addr) {
+			continue
+		}
+		if _, ok := t.peers.manual.LoadOrStore(addr, struct{}{}); ok {
+			continue
+		}
+		if _, ok := t.peers.seeders.Load(addr); ok {
+			continue
+		}
+		if _, ok := t.peers.connecting.LoadOrStore(addr, struct{}{}); ok {
+			continue
+		}
+		t.logger.Debug("adding peer from peer list", slog.String("addr", addr))
+		t.peers.dials.list(addr, SourcePeerList)
+		added = append(added, addr)
+	}
+
+	for _, addr := range added {
+		t.download.wg.Add(1)
+		go t.keepAliveSeeders(addr)
+	}
+}
+
+// watchPeerList injects the entries of the peer list file as peers,
+// re-reading it when it changes. If enabled, the connected peers are
+// written back to the file.
+func (t *TorrentSession) watchPeerList() {
+	defer t.download.wg.Done()
+
+	logger := t.logger.With(slog.String("peer_list", t.peerList.path))
+
+	tick := t.peerList.tick
+	if tick <= 0 {
+		tick = peerListTick
+	}
+
+	var modTime time.Time
+	var size int64
+	var known []string
+
+	refresh := t.newTicker(1 * time.Nanosecond) // first tick happens immediately.
+	defer refresh.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.canceled():
+			return
+		case <-t.download.completed:
+			return
+		case <-refresh.C():
+			refresh.Reset(tick)
+
+			st, err := os.Stat(t.peerList.path)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				known = nil
+			case err != nil:
+				logger.Error("failed to stat peer list", slog.Any("err", err))
+				continue
+			case !st.ModTime().Equal(modTime) || st.Size() != size:
+				addrs, err := readPeerList(t.peerList.path)
+				if err != nil {
+					logger.Error("failed to reload peer list", slog.Any("err", err))
+					continue
+				}
+				modTime, size, known = st.ModTime(), st.Size(), addrs
+				logger.Debug("loaded peer list", slog.Int("peers", len(addrs)))
+				t.addManualPeers(addrs)
+			}
+
+			if !t.peerList.writeBack {
+				continue
+			}
+
+			discovered := slices.Clone(known)
+			t.peers.seeders.Range(func(key, value any) bool {
+				addr := key.(string)
+				if value.(*peer.Peer).ConnectionStatus() == peer.ConnectionEstablished && !slices.Contains(discovered, addr) {
+					discovered = append(discovered, addr)
+				}
+				return true
+			})
+			if len(discovered) == len(known) {
+				continue
+			}
+			if err := writePeerList(t.peerList.path, discovered); err != nil {
+				logger.Error("failed to write back peer list", slog.Any("err", err))
+				continue
+			}
+			// the entries were already added, the next tick only picks up the new modification time.
+			for _, addr := range discovered[len(known):] {
+				t.peers.manual.Store(addr, struct{}{})
+			}
+			known = discovered
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package status
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPeerList(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", content: "", want: nil},
+		{
+			name:    "comments-and-blank-lines",
+			content: "# swarm\n\n10.0.0.1:6881\n  10.0.0.2:6882  \n",
+			want:    []string{"10.0.0.1:6881", "10.0.0.2:6882"},
+		},
+		{
+			name:    "duplicates",
+			content: "10.0.0.1:6881\n10.0.0.1:6881\n",
+			want:    []string{"10.0.0.1:6881"},
+		},
+		{
+			name:    "ipv6",
+			content: "[::1]:6881\n",
+			want:    []string{"[::1]:6881"},
+		},
+		{name: "missing-port", content: "10.0.0.1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "peers.txt")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o644))
+
+			got, err := readPeerList(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWritePeerList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	addrs := []string{"10.0.0.1:6881", "[::1]:6882"}
+
+	assert.NoError(t, writePeerList(path, addrs))
+	got, err := readPeerList(path)
+	assert.NoError(t, err)
+	assert.Equal(t, addrs, got)
+}
+
+func TestTracker_PeerListReloaded(t *testing.T) {
+	tr := newTestTracker(t, 4, []byte{0x1, 0x2, 0x3, 0x4})
+
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("127.0.0.1:1\n"), 0o644))
+
+	tr.peerList = &peerList{path: path, tick: 10 * time.Millisecond}
+	tr.download.wg.Add(1)
+	go tr.watchPeerList()
+
+	added := func(addr string) func() bool {
+		return func() bool { _, ok := tr.peers.manual.Load(addr); return ok }
+	}
+	assert.Eventually(t, added("127.0.0.1:1"), time.Second, 5*time.Millisecond)
+
+	// the file gains an entry mid-download.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteString("127.0.0.1:2\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	assert.Eventually(t, added("127.0.0.1:2"), time.Second, 5*time.Millisecond)
+
+	tr.download.cancel.Load().cancel()
+	tr.download.wg.Wait()
+}
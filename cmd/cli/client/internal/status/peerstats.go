@@ -0,0 +1,196 @@
+package status
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// snubTimeout is the time after which a peer with outstanding
+// requests that did not deliver any block is considered snubbed.
+const snubTimeout = 30 * time.Second
+
+// PeerStat is a snapshot of the download statistics of a single seeder.
+type PeerStat struct {
+	Addr string `json:"addr"`
+	// Downloaded is the total number of bytes received from the peer.
+	Downloaded int64 `json:"downloaded"`
+	// Rate is the number of bytes received from the peer during the last second.
+	Rate int64 `json:"rate"`
+	// Snubbed is set if the peer accepted requests but did not deliver
+	// any block for a while. Snubbed peers receive only a single request
+	// at a time until they deliver again.
+	Snubbed bool `json:"snubbed"`
+	// Timeouts is the number of requests the peer did not answer within
+	// requestTimeout. Requests discarded by the peer choking this client
+	// are re-queued right away and not counted.
+	Timeouts int64 `json:"timeouts"`
+	// Redundant is the number of bytes received from the peer that were
+	// dropped, as another copy of the block was used already, e.g. after
+	// its request timed out and was answered by another peer. They are
+	// not counted in Downloaded and do not keep the peer from being snubbed.
+	Redundant int64 `json:"redundant"`
+	// Reputation scores the data received from the peer. It rises with
+	// each block of a verified piece and falls with timed out requests
+	// and, heavily, with corrupt blocks, decaying toward zero over time.
+	// Peers with a negative reputation are sent fewer requests.
+	Reputation float64 `json:"reputation"`
+}
+
+// peerStats are the download statistics of a single seeder.
+type peerStats struct {
+	// downloaded is the total number of bytes received from the peer.
+	downloaded atomic.Int64
+	// rate is the number of bytes received from the peer during the last rateTick.
+	rate atomic.Int64
+	// last is the value of downloaded at the last rateTick.
+	last int64
+	// lastBlock is the unix nano time at which the peer delivered its
+	// last block, or at which it was sent a request while it had none
+	// outstanding.
+	lastBlock atomic.Int64
+	// snubbed is set once the peer did not deliver any block
+	// for snubTimeout while having outstanding requests.
+	snubbed atomic.Bool
+	// timeouts is the number of requests that were not answered within requestTimeout.
+	timeouts atomic.Int64
+	// redundant is the number of bytes of blocks received from the peer
+	// that were dropped, as another copy of the block was used already.
+	redundant atomic.Int64
+	// reputation scores the data received from the peer.
+	reputation reputation
+}
+
+// statsFor returns the statistics for the peer at addr, creating them if needed.
+func (t *TorrentSession) statsFor(addr string) *peerStats {
+	s, _ := t.peers.stats.LoadOrStore(addr, new(peerStats))
+	return s.(*peerStats)
+}
+
+// peerRate returns the download rate, in bytes per rateTick, of the peer at addr.
+func (t *TorrentSession) peerRate(addr string) int64 {
+	s, ok := t.peers.stats.Load(addr)
+	if !ok {
+		return 0
+	}
+	return s.(*peerStats).rate.Load()
+}
+
+// updatePeerRates recomputes the rate of each peer, must be called every rateTick.
+func (t *TorrentSession) updatePeerRates() {
+	t.peers.stats.Range(func(_, value any) bool {
+		s := value.(*peerStats)
+		current := s.downloaded.Load()
+		s.rate.Store(max(0, current-s.last))
+		s.last = current
+		return true
+	})
+}
+
+// isSnubbed reports whether the peer at addr is snubbed.
+func (t *TorrentSession) isSnubbed(addr string) bool {
+	s, ok := t.peers.stats.Load(addr)
+	return ok && s.(*peerStats).snubbed.Load()
+}
+
+// outstandingRequests returns the number of requests that were sent
+// to each peer and for which no block was received yet.
+func (t *TorrentSession) outstandingRequests() map[string]int {
+	out := make(map[string]int)
+	for _, p := range t.download.active.snapshot() {
+		p.l.Lock()
+		for _, r := range p.InFlight {
+			if r.received {
+				continue
+			}
+			for _, addr := range r.peers {
+				out[addr]++
+			}
+		}
+		p.l.Unlock()
+	}
+	return out
+}
+
+// updateSnubbed marks the peers that have outstanding requests but did
+// not deliver a block within snubTimeout as snubbed.
+func (t *TorrentSession) updateSnubbed(outstanding map[string]int, now time.Time) {
+	t.peers.stats.Range(func(key, value any) bool {
+		s := value.(*peerStats)
+		if outstanding[key.(string)] == 0 || s.snubbed.Load() {
+			return true
+		}
+		if now.Sub(time.Unix(0, s.lastBlock.Load())) >= snubTimeout {
+			s.snubbed.Store(true)
+			t.logger.Debug("peer snubbed, no blocks delivered for outstanding requests", slog.String("end_peer", key.(string)))
+		}
+		return true
+	})
+}
+
+// PeerStats returns the download statistics of the seeders, ordered by address.
+func (t *TorrentSession) PeerStats() []PeerStat {
+	var out []PeerStat
+	now := t.now()
+	t.peers.stats.Range(func(key, value any) bool {
+		s := value.(*peerStats)
+		out = append(out, PeerStat{
+			Addr:       key.(string),
+			Downloaded: s.downloaded.Load(),
+			Rate:       s.rate.Load(),
+			Snubbed:    s.snubbed.Load(),
+			Timeouts:   s.timeouts.Load(),
+			Redundant:  s.redundant.Load(),
+			Reputation: s.reputation.value(now),
+		})
+		return true
+	})
+	slices.SortFunc(out, func(a, b PeerStat) int { return cmp.Compare(a.Addr, b.Addr) })
+	return out
+}
+
+// LeecherStat is a snapshot of the upload statistics of a single leecher.
+type LeecherStat struct {
+	Addr string `json:"addr"`
+	// Uploaded is the total number of bytes sent to the peer.
+	Uploaded int64 `json:"uploaded"`
+	// Queued is the number of requests of the peer waiting to be served.
+	Queued int `json:"queued"`
+}
+
+// LeecherStats returns the upload statistics of the connected leechers, ordered by address.
+func (t *TorrentSession) LeecherStats() []LeecherStat {
+	var out []LeecherStat
+	t.peers.uploads.Range(func(key, value any) bool {
+		queued, _ := t.queuedUploads(key.(string))
+		out = append(out, LeecherStat{
+			Addr:     key.(string),
+			Uploaded: value.(*atomic.Int64).Load(),
+			Queued:   queued,
+		})
+		return true
+	})
+	slices.SortFunc(out, func(a, b LeecherStat) int { return cmp.Compare(a.Addr, b.Addr) })
+	return out
+}
+
+// PeerCounts returns the number of seeders and leechers
+// the torrent has an established connection with.
+func (t *TorrentSession) PeerCounts() (seeders, leechers int) {
+	count := func(m *sync.Map) int {
+		n := 0
+		m.Range(func(_, value any) bool {
+			if value.(*peer.Peer).ConnectionStatus() == peer.ConnectionEstablished {
+				n++
+			}
+			return true
+		})
+		return n
+	}
+	return count(&t.peers.seeders), count(&t.peers.leechers)
+}
@@ -0,0 +1,107 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_Snubbing(t *testing.T) {
+	tr := newTestTracker(t, 4, []byte{0x1, 0x2, 0x3, 0x4})
+
+	now := time.Now()
+	stalled, fast := &peer.Peer{Addr: "10.0.0.1:6881"}, &peer.Peer{Addr: "10.0.0.2:6881"}
+	tr.statsFor(stalled.Addr).lastBlock.Store(now.Add(-snubTimeout).UnixNano())
+	tr.statsFor(fast.Addr).lastBlock.Store(now.Add(-snubTimeout).UnixNano())
+
+	// only peers with outstanding requests are snubbed.
+	tr.updateSnubbed(map[string]int{stalled.Addr: 5}, now)
+	assert.Equal(t, []PeerStat{
+		{Addr: stalled.Addr, Snubbed: true},
+		{Addr: fast.Addr},
+	}, tr.PeerStats())
+
+	for range 100 {
+		assert.Equal(t, fast, pickPeer(tr, []*peer.Peer{stalled, fast}, map[string]int{stalled.Addr: 5}, 0))
+	}
+
+	// snubbed peers are probed with a single request at a time.
+	assert.Nil(t, pickPeer(tr, []*peer.Peer{stalled}, map[string]int{stalled.Addr: 1}, 0))
+	assert.Equal(t, stalled, pickPeer(tr, []*peer.Peer{stalled}, map[string]int{}, 0))
+
+	// the next block used clears the snub.
+	tr.download.active.add(&pendingPiece{
+		Index:    0,
+		Attempt:  1,
+		Size:     4,
+		InFlight: []*timedDownloadRequest{{request: messagesv1.Request{Index: 0, Begin: 0, Length: 1}, peers: []string{stalled.Addr}}},
+	})
+	pieces := make(chan *messagesv1.Piece, 1)
+	pieces <- &messagesv1.Piece{Index: 0, Begin: 0, Block: []byte{0x1}}
+	close(pieces)
+	tr.recvPieces(tr.logger, stalled.Addr, "peer-a", pieces, nil)
+
+	assert.False(t, tr.isSnubbed(stalled.Addr))
+}
+
+func TestTracker_RedundantDelivery(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	tr := newTestTracker(t, int64(len(data)), data)
+
+	const slow, fast = "10.0.0.1:6881", "10.0.0.2:6881"
+	first := messagesv1.Request{Index: 0, Begin: 0, Length: messagesv1.RequestSize}
+	second := messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}
+	p := &pendingPiece{
+		Index:   0,
+		Attempt: 1,
+		Size:    int64(len(data)),
+		InFlight: []*timedDownloadRequest{
+			{request: first, send: tr.now(), peers: []string{slow}},
+			{request: second, send: tr.now(), peers: []string{slow}},
+		},
+	}
+	assert.True(t, tr.download.active.add(p))
+	tr.statsFor(slow)
+	tr.statsFor(fast).snubbed.Store(true)
+
+	// both requests time out at the slow peer, only the first one
+	// is sent to the fast peer again before the slow peer delivers.
+	p.l.Lock()
+	tr.execute(p, stepPiece{}, stepAction{Kind: actionTimeout, Request: first}, nil, nil)
+	tr.execute(p, stepPiece{}, stepAction{Kind: actionTimeout, Request: second}, nil, nil)
+	p.InFlight = []*timedDownloadRequest{{request: first, send: tr.now(), peers: []string{fast}}}
+	p.Pending = []*messagesv1.Request{&second}
+	p.l.Unlock()
+
+	deliver := func(addr string, r messagesv1.Request) {
+		pieces := make(chan *messagesv1.Piece, 1)
+		pieces <- &messagesv1.Piece{Index: r.Index, Begin: r.Begin, Block: data[r.Begin : r.Begin+r.Length]}
+		close(pieces)
+		<-tr.spawnReceiver(tr.logger, addr, addr, pieces, nil)
+	}
+	// the slow copy arrives first and is used, the fast one is redundant.
+	deliver(slow, first)
+	deliver(fast, first)
+	// the late answer to the request not sent again is used as well.
+	deliver(slow, second)
+	// the fast peer answers the cancelled request after verification.
+	deliver(fast, first)
+
+	assert.True(t, tr.have.Check(0))
+	assert.Equal(t, int64(len(data)), tr.downloaded.Load())
+	assert.Equal(t, WasteStats{Duplicate: 2 * messagesv1.RequestSize}, tr.WasteStats())
+	stats := tr.PeerStats()
+	// the blocks of the verified piece make up for the timeouts.
+	assert.InDelta(t, 0, stats[0].Reputation, 1e-3)
+	stats[0].Reputation = 0
+	assert.Equal(t, []PeerStat{
+		{Addr: slow, Downloaded: 2 * messagesv1.RequestSize, Timeouts: 2},
+		{Addr: fast, Snubbed: true, Redundant: 2 * messagesv1.RequestSize},
+	}, stats)
+}
@@ -0,0 +1,173 @@
+package status
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+)
+
+// Pipelining of the requests sent to a single peer, see RatePicker.
+const (
+	// pipelineWindow is the time worth of the rate of a peer that is
+	// kept outstanding with it, bounded by minPipeline and maxPipeline
+	// requests.
+	pipelineWindow = 2 * time.Second
+	minPipeline    = 4
+	maxPipeline    = 256
+	// probeShare is the share of the rate of the fastest candidate
+	// that the slower ones are weighted by at least, so that peers
+	// without a measured rate yet are still probed.
+	probeShare = 0.05
+)
+
+// PeerCandidate is a peer that can serve the request being scheduled.
+type PeerCandidate struct {
+	Addr string
+	// Rate is the number of bytes received from the peer during the last second.
+	Rate int64
+	// Outstanding is the number of requests sent to the peer that were not answered yet.
+	Outstanding int
+	// Suggested is set if the peer suggested requesting the piece, see BEP6.
+	Suggested bool
+	// Reputation is the score of the data the peer delivered. Pickers
+	// weight candidates with a negative one down, see reputationWeight.
+	Reputation float64
+}
+
+// PeerPicker chooses the peer the next request is sent to.
+type PeerPicker interface {
+	// Pick returns the index of the candidate the request is sent to.
+	// It is called with at least one candidate.
+	Pick(candidates []PeerCandidate) int
+}
+
+// RandomPicker picks any of the candidates with the same probability,
+// the ones with a reputation that is not negative that suggested the
+// piece if any did, and weights candidates down by a negative reputation.
+type RandomPicker struct {
+	// Rand is the source of the choices, the math/rand/v2 functions if nil.
+	Rand *rand.Rand
+}
+
+func (p RandomPicker) Pick(candidates []PeerCandidate) int {
+	var suggested []int
+	for i, c := range candidates {
+		if c.Suggested && c.Reputation >= 0 {
+			suggested = append(suggested, i)
+		}
+	}
+	if len(suggested) == 0 {
+		for i := range candidates {
+			suggested = append(suggested, i)
+		}
+	}
+	weights := make([]float64, len(suggested))
+	for i, j := range suggested {
+		weights[i] = reputationWeight(candidates[j].Reputation)
+	}
+	return suggested[weighted(p.Rand, weights)]
+}
+
+// RatePicker picks the candidates with probability proportional to the
+// rate they delivered recently, with a floor so that new peers are
+// probed, weighted down by a negative reputation. The candidates whose
+// pipeline holds fewer requests than they can serve within pipelineWindow
+// are preferred, unless only candidates with a negative reputation have
+// such spare pipelines, and those that suggested the piece over the ones
+// weighted the same. It is the default.
+type RatePicker struct {
+	// Rand is the source of the choices, the math/rand/v2 functions if nil.
+	Rand *rand.Rand
+}
+
+func (p RatePicker) Pick(candidates []PeerCandidate) int {
+	var best int64
+	for _, c := range candidates {
+		best = max(best, c.Rate)
+	}
+	floor := max(float64(messagesv1.RequestSize), probeShare*float64(best))
+
+	weights := make([]float64, len(candidates))
+	eligible := make([]bool, len(candidates))
+	spare := false
+	for i, c := range candidates {
+		weights[i] = max(float64(c.Rate), floor) * reputationWeight(c.Reputation)
+		eligible[i] = c.Outstanding < pipelineDepth(c.Rate)
+		// the pipeline of a peer that is weighted down is spare as it
+		// is starved, which must not make it the only one picked.
+		spare = spare || (eligible[i] && c.Reputation >= 0)
+	}
+	// without spare pipelines, the candidates are weighted by rate only.
+	suggested := make(map[float64]bool)
+	for i, c := range candidates {
+		eligible[i] = eligible[i] || !spare
+		if eligible[i] && c.Suggested {
+			suggested[weights[i]] = true
+		}
+	}
+
+	var total float64
+	for i, c := range candidates {
+		// suggestions break ties.
+		eligible[i] = eligible[i] && (c.Suggested || !suggested[weights[i]])
+		if eligible[i] {
+			total += weights[i]
+		}
+	}
+	x := float64N(p.Rand, total)
+	last := 0
+	for i := range candidates {
+		if !eligible[i] {
+			continue
+		}
+		if x < weights[i] {
+			return i
+		}
+		x -= weights[i]
+		last = i
+	}
+	return last // rounding errors.
+}
+
+// pipelineDepth returns the number of outstanding requests
+// a peer delivering rate bytes per second is kept busy with.
+func pipelineDepth(rate int64) int {
+	n := int64(float64(rate) * pipelineWindow.Seconds() / messagesv1.RequestSize)
+	return int(min(max(n, minPipeline), maxPipeline))
+}
+
+// intN returns a number in [0, n) drawn from r, or from
+// the math/rand/v2 functions if r is nil.
+func intN(r *rand.Rand, n int) int {
+	if r == nil {
+		return rand.IntN(n)
+	}
+	return r.IntN(n)
+}
+
+// weighted returns the index of a weight, drawn from r with probability
+// proportional to it, see intN.
+func weighted(r *rand.Rand, weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	x := float64N(r, total)
+	for i, w := range weights {
+		if x < w {
+			return i
+		}
+		x -= w
+	}
+	return len(weights) - 1 // rounding errors.
+}
+
+// float64N returns a number in [0, n) drawn from r, or from
+// the math/rand/v2 functions if r is nil.
+func float64N(r *rand.Rand, n float64) float64 {
+	if r == nil {
+		return rand.Float64() * n
+	}
+	return r.Float64() * n
+}
@@ -0,0 +1,187 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// pickerFunc adapts a function to the PeerPicker interface.
+type pickerFunc func(candidates []PeerCandidate) int
+
+func (f pickerFunc) Pick(candidates []PeerCandidate) int { return f(candidates) }
+
+// pickPeer returns the peer among peers the next request
+// for the piece is sent to, or nil if none is chosen.
+func pickPeer(tr *TorrentSession, peers []*peer.Peer, outstanding map[string]int, piece int64) *peer.Peer {
+	candidates := make([]stepPeer, len(peers))
+	for i, p := range peers {
+		candidates[i] = tr.stepPeerOf(p)
+	}
+	if i := pickCandidate(candidates, outstanding, piece, tr.download.picker); i >= 0 {
+		return peers[i]
+	}
+	return nil
+}
+
+func TestPipelineDepth(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int64
+		want int
+	}{
+		{name: "no rate", rate: 0, want: minPipeline},
+		{name: "slow", rate: messagesv1.RequestSize, want: minPipeline},
+		{name: "window of the rate", rate: 16 * messagesv1.RequestSize, want: 32},
+		{name: "bounded", rate: 1 << 30, want: maxPipeline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pipelineDepth(tt.rate))
+		})
+	}
+}
+
+func TestRatePicker(t *testing.T) {
+	const n = 10000
+	count := func(candidates []PeerCandidate) []int {
+		picks := make([]int, len(candidates))
+		for range n {
+			picks[RatePicker{}.Pick(candidates)]++
+		}
+		return picks
+	}
+
+	// the candidates are picked proportionally to their rate.
+	picks := count([]PeerCandidate{
+		{Addr: "fast", Rate: 3 << 20},
+		{Addr: "slow", Rate: 1 << 20},
+	})
+	assert.InDelta(t, 0.75, float64(picks[0])/n, 0.05)
+
+	// new peers are still probed.
+	picks = count([]PeerCandidate{
+		{Addr: "fast", Rate: 1 << 30},
+		{Addr: "new"},
+	})
+	assert.InDelta(t, probeShare/(1+probeShare), float64(picks[1])/n, 0.02)
+
+	// candidates with spare pipelines are preferred.
+	picks = count([]PeerCandidate{
+		{Addr: "busy", Rate: 1 << 20, Outstanding: pipelineDepth(1 << 20)},
+		{Addr: "spare", Rate: messagesv1.RequestSize},
+	})
+	assert.Equal(t, []int{0, n}, picks)
+
+	// unless only candidates weighted down by their reputation have spare pipelines.
+	picks = count([]PeerCandidate{
+		{Addr: "busy", Rate: 1 << 20, Outstanding: pipelineDepth(1 << 20)},
+		{Addr: "corrupt", Rate: 1 << 20, Reputation: -3 * reputationScale},
+	})
+	assert.InDelta(t, reputationWeight(-3*reputationScale)/(1+reputationWeight(-3*reputationScale)), float64(picks[1])/n, 0.02)
+
+	// without spare pipelines, the rate decides alone.
+	picks = count([]PeerCandidate{
+		{Addr: "busy", Rate: 1 << 20, Outstanding: maxPipeline},
+		{Addr: "busy too", Rate: 1 << 20, Outstanding: maxPipeline},
+	})
+	assert.InDelta(t, 0.5, float64(picks[0])/n, 0.05)
+
+	// suggestions break ties.
+	picks = count([]PeerCandidate{
+		{Addr: "new"},
+		{Addr: "suggested", Suggested: true},
+	})
+	assert.Equal(t, []int{0, n}, picks)
+
+	// but do not outweigh the rate.
+	picks = count([]PeerCandidate{
+		{Addr: "fast", Rate: 3 << 20},
+		{Addr: "suggested", Rate: 1 << 20, Suggested: true},
+	})
+	assert.InDelta(t, 0.75, float64(picks[0])/n, 0.05)
+}
+
+func TestRandomPicker(t *testing.T) {
+	candidates := []PeerCandidate{{Addr: "a"}, {Addr: "b", Suggested: true}, {Addr: "c"}}
+	for range 100 {
+		assert.Equal(t, 1, RandomPicker{}.Pick(candidates))
+	}
+}
+
+func TestTracker_PickPeer(t *testing.T) {
+	tr := newTestTracker(t, 4, []byte{0x1, 0x2, 0x3, 0x4})
+	a, b := &peer.Peer{Addr: "10.0.0.1:6881"}, &peer.Peer{Addr: "10.0.0.2:6881"}
+	tr.statsFor(a.Addr).rate.Store(100)
+
+	var got []PeerCandidate
+	tr.download.picker = pickerFunc(func(candidates []PeerCandidate) int {
+		got = candidates
+		return len(candidates) - 1
+	})
+	assert.Equal(t, b, pickPeer(tr, []*peer.Peer{a, b}, map[string]int{b.Addr: 2}, 0))
+	assert.Equal(t, []PeerCandidate{
+		{Addr: a.Addr, Rate: 100},
+		{Addr: b.Addr, Outstanding: 2},
+	}, got)
+
+	// invalid picks send no request.
+	tr.download.picker = pickerFunc(func([]PeerCandidate) int { return -1 })
+	assert.Nil(t, pickPeer(tr, []*peer.Peer{a, b}, nil, 0))
+}
+
+// simulatedPeer serves up to speed blocks per tick of the requests
+// queued with it, in the order they were sent.
+type simulatedPeer struct {
+	speed       int
+	outstanding int
+	rate        int64
+}
+
+// simulateSwarm schedules requests to peers of mixed speeds with picker,
+// keeping up to budget blocks outstanding in total as the active pieces
+// do, and returns the blocks delivered per tick.
+func simulateSwarm(picker PeerPicker, ticks int) float64 {
+	const budget = 256
+	peers := []*simulatedPeer{{speed: 64}, {speed: 16}, {speed: 4}, {speed: 1}, {speed: 1}, {speed: 1}}
+	candidates := make([]PeerCandidate, len(peers))
+
+	var inFlight, delivered int
+	for range ticks {
+		for ; inFlight < budget; inFlight++ {
+			for i, p := range peers {
+				candidates[i] = PeerCandidate{Rate: p.rate, Outstanding: p.outstanding}
+			}
+			peers[picker.Pick(candidates)].outstanding++
+		}
+		for _, p := range peers {
+			n := min(p.speed, p.outstanding)
+			p.outstanding -= n
+			p.rate = int64(n) * messagesv1.RequestSize
+			inFlight -= n
+			delivered += n
+		}
+	}
+	return float64(delivered) / float64(ticks)
+}
+
+func BenchmarkPeerPicker(b *testing.B) {
+	pickers := []struct {
+		name   string
+		picker PeerPicker
+	}{
+		{name: "random", picker: RandomPicker{}},
+		{name: "rate", picker: RatePicker{}},
+	}
+	for _, p := range pickers {
+		b.Run(p.name, func(b *testing.B) {
+			var throughput float64
+			for range b.N {
+				throughput = simulateSwarm(p.picker, 1000)
+			}
+			b.ReportMetric(throughput, "blocks/tick")
+		})
+	}
+}
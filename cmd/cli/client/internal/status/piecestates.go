@@ -0,0 +1,89 @@
+package status
+
+import "runtime"
+
+// compactPieceThreshold is the number of pieces from which the scheduling
+// states of a torrent are held in a table, see WithCompactPieceStates.
+const compactPieceThreshold = 1 << 18
+
+// pieceStates holds the scheduling state of each piece of a torrent.
+// It is accessed with the lock of the pieceSlots held.
+type pieceStates interface {
+	get(index int64) pieceState
+	set(index int64, state pieceState)
+	// each calls fn with every piece that is not unscheduled.
+	each(fn func(index int64, state pieceState))
+}
+
+// sparseStates holds the pieces that are not unscheduled in a map,
+// which is small while few pieces are verified.
+type sparseStates map[int64]pieceState
+
+func (s sparseStates) get(index int64) pieceState { return s[index] }
+
+func (s sparseStates) set(index int64, state pieceState) {
+	if state == pieceUnscheduled {
+		delete(s, index)
+		return
+	}
+	s[index] = state
+}
+
+func (s sparseStates) each(fn func(index int64, state pieceState)) {
+	for i, state := range s {
+		fn(i, state)
+	}
+}
+
+// tableStates holds the state of every piece in a record of a single
+// byte. Where supported, the table is mapped from a temporary file, so
+// that the states of huge torrents take no heap, which is scarce on
+// 32-bit platforms.
+type tableStates struct {
+	records []byte
+	// release unmaps the records, nil if they are on the heap.
+	release func()
+}
+
+// newTableStates returns the table of the states of n pieces, all
+// unscheduled. The table is allocated on the heap if it cannot be mapped.
+func newTableStates(n int64) (*tableStates, error) {
+	records, release, err := mapTable(n)
+	if err != nil {
+		return &tableStates{records: make([]byte, n)}, err
+	}
+	s := &tableStates{records: records, release: release}
+	if release != nil {
+		// the records are accessed only through s, the finalizer
+		// unmaps them if the table was never closed.
+		runtime.SetFinalizer(s, (*tableStates).close)
+	}
+	return s, nil
+}
+
+// close unmaps the records, the table is not accessed afterwards.
+func (s *tableStates) close() {
+	if s.release == nil {
+		return
+	}
+	runtime.SetFinalizer(s, nil)
+	s.release()
+	s.release, s.records = nil, nil
+}
+
+func (s *tableStates) get(index int64) pieceState {
+	if index < 0 || index >= int64(len(s.records)) {
+		return pieceUnscheduled
+	}
+	return pieceState(s.records[index])
+}
+
+func (s *tableStates) set(index int64, state pieceState) { s.records[index] = byte(state) }
+
+func (s *tableStates) each(fn func(index int64, state pieceState)) {
+	for i, state := range s.records {
+		if pieceState(state) != pieceUnscheduled {
+			fn(int64(i), pieceState(state))
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package status
+
+// mapTable allocates the table on the heap, as files are not mapped.
+func mapTable(size int64) ([]byte, func(), error) {
+	return make([]byte, size), nil, nil
+}
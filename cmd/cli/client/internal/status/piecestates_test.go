@@ -0,0 +1,91 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPieceStates(t *testing.T) {
+	table, err := newTableStates(8)
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		states pieceStates
+	}{
+		{name: "sparse", states: make(sparseStates)},
+		{name: "table", states: table},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.states.set(1, pieceActive)
+			tt.states.set(3, pieceVerified)
+			tt.states.set(5, pieceAbandoned)
+			tt.states.set(3, pieceUnscheduled)
+
+			got := make(map[int64]pieceState)
+			tt.states.each(func(i int64, state pieceState) { got[i] = state })
+			assert.Equal(t, map[int64]pieceState{1: pieceActive, 5: pieceAbandoned}, got)
+			assert.Equal(t, pieceAbandoned, tt.states.get(5))
+			assert.Equal(t, pieceUnscheduled, tt.states.get(3))
+			assert.Equal(t, pieceUnscheduled, tt.states.get(8))
+		})
+	}
+
+	// the table is released once, the finalizer is only a backstop.
+	table.close()
+	assert.Nil(t, table.release)
+	table.close()
+}
+
+func TestTracker_CompactPieceStates(t *testing.T) {
+	const numPieces = 8
+	data := make([]byte, numPieces*messagesv1.RequestSize)
+	var pieces [][]byte
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	for i := range numPieces {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	states, err := newTableStates(numPieces)
+	assert.NoError(t, err)
+	tr.download.active.states = states
+	WithMaxActivePieces(2)(tr)
+	assert.NoError(t, tr.AbandonPiece(3))
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	t.Cleanup(tr.CancelDownload)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	assert.Eventually(t, func() bool {
+		return len(tr.have.MissingPieces()) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{3}, tr.AbandonedPieces())
+	assert.Equal(t, []int64{3}, tr.have.MissingPieces())
+
+	assert.NoError(t, tr.ReclaimPiece(3))
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	assertPieces(t, tr, pieces)
+	for i := range int64(numPieces) {
+		assert.Equal(t, pieceVerified, tr.download.active.state(i), "piece %d", i)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package status
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapTable maps a table of size zeroed bytes from a temporary file, which
+// is removed right away. The returned function unmaps the table.
+func mapTable(size int64) ([]byte, func(), error) {
+	if size == 0 {
+		return nil, nil, nil
+	}
+	f, err := os.CreateTemp("", "tinytorrent-pieces-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create piece table: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return nil, nil, fmt.Errorf("failed to size piece table: %w", err)
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to map piece table: %w", err)
+	}
+	return b, func() { syscall.Munmap(b) }, nil
+}
@@ -0,0 +1,82 @@
+package status
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+)
+
+// DownloadStats is a snapshot of the throughput of the stages a
+// downloaded piece passes through, which tells apart whether the
+// network, the hashing or the disk is the bottleneck.
+type DownloadStats struct {
+	// Rate is the smoothed download rate in bytes per second.
+	Rate int64
+	// PiecesVerified is the number of pieces verified during the last second.
+	PiecesVerified int64
+	// BytesFlushed is the number of bytes flushed to storage during the last second.
+	BytesFlushed int64
+	// VerifyLatency is the smoothed time from receiving the last
+	// block of a piece until its hash was verified.
+	VerifyLatency time.Duration
+	// FlushLatency is the smoothed time from verifying a piece until
+	// it was flushed, including the time queued for the disk.
+	FlushLatency time.Duration
+}
+
+// pipeline measures the verification and flushing of completed pieces.
+type pipeline struct {
+	verified atomic.Int64
+	flushed  atomic.Int64
+
+	l                         sync.Mutex
+	lastVerified, lastFlushed int64
+	verifiedRate, flushedRate int64
+	verifyLatency             time.Duration
+	flushLatency              time.Duration
+}
+
+// recordVerified records a piece whose last block was received
+// at completed and whose hash was verified at verified.
+func (p *pipeline) recordVerified(completed, verified time.Time) {
+	p.verified.Add(1)
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.verifyLatency = storage.Smooth(p.verifyLatency, verified.Sub(completed))
+}
+
+// recordFlushed records size bytes of a piece verified at
+// verified that finished flushing at flushed.
+func (p *pipeline) recordFlushed(size int64, verified, flushed time.Time) {
+	p.flushed.Add(size)
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.flushLatency = storage.Smooth(p.flushLatency, flushed.Sub(verified))
+}
+
+// updatePipelineRates recomputes the per second rates, must be called every rateTick.
+func (t *TorrentSession) updatePipelineRates() {
+	p := &t.download.pipeline
+	verified, flushed := p.verified.Load(), p.flushed.Load()
+
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.verifiedRate, p.lastVerified = verified-p.lastVerified, verified
+	p.flushedRate, p.lastFlushed = flushed-p.lastFlushed, flushed
+}
+
+// DownloadStats returns the throughput of the download of the torrent.
+func (t *TorrentSession) DownloadStats() DownloadStats {
+	p := &t.download.pipeline
+	p.l.Lock()
+	defer p.l.Unlock()
+	return DownloadStats{
+		Rate:           t.TransferStats().DownloadRate,
+		PiecesVerified: p.verifiedRate,
+		BytesFlushed:   p.flushedRate,
+		VerifyLatency:  p.verifyLatency,
+		FlushLatency:   p.flushLatency,
+	}
+}
@@ -0,0 +1,40 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_DownloadStats(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1})
+	p := &tr.download.pipeline
+
+	now := time.Unix(1000, 0)
+	for range 4 {
+		p.recordVerified(now, now.Add(10*time.Millisecond))
+		p.recordFlushed(256, now.Add(10*time.Millisecond), now.Add(50*time.Millisecond))
+	}
+	tr.download.rate.rate, tr.download.rate.last = 4096, time.Now()
+	tr.updatePipelineRates()
+
+	assert.Equal(t, DownloadStats{
+		Rate:           4096,
+		PiecesVerified: 4,
+		BytesFlushed:   1024,
+		VerifyLatency:  10 * time.Millisecond,
+		FlushLatency:   40 * time.Millisecond,
+	}, tr.DownloadStats())
+
+	// a slow flush moves the latency, an idle second resets the rates.
+	p.recordFlushed(256, now, now.Add(840*time.Millisecond))
+	tr.updatePipelineRates()
+	tr.updatePipelineRates()
+
+	s := tr.DownloadStats()
+	assert.Zero(t, s.PiecesVerified)
+	assert.Zero(t, s.BytesFlushed)
+	assert.Equal(t, 10*time.Millisecond, s.VerifyLatency)
+	assert.Equal(t, 140*time.Millisecond, s.FlushLatency)
+}
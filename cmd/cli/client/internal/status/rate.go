@@ -0,0 +1,104 @@
+package status
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateWindow is the time constant of the smoothed transfer rates. A
+// change in throughput is reflected to about 63% after rateWindow.
+const rateWindow = 5 * time.Second
+
+// UnknownETA is reported as the ETA while nothing is being downloaded.
+const UnknownETA time.Duration = -1
+
+// TransferStats is a snapshot of the smoothed transfer rates of a torrent.
+type TransferStats struct {
+	// DownloadRate and UploadRate are in bytes per second.
+	DownloadRate, UploadRate int64
+	// Remaining is the number of bytes left to download.
+	Remaining int64
+	// ETA is the estimated time until the download completes,
+	// UnknownETA if the download rate is zero.
+	ETA time.Duration
+}
+
+// rateMeter is an exponentially weighted moving average of a transfer
+// rate, updated on every transferred block. The rate decays while
+// nothing is transferred.
+type rateMeter struct {
+	l    sync.Mutex
+	rate float64
+	last time.Time
+}
+
+// decay returns the weight of a rate measured d ago.
+func decay(d time.Duration) float64 { return math.Exp(-d.Seconds() / rateWindow.Seconds()) }
+
+// add records n bytes transferred at now.
+func (m *rateMeter) add(n int64, now time.Time) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	dt := now.Sub(m.last)
+	if m.last.IsZero() || dt <= 0 {
+		// blocks arriving at once add up, the weight of a block
+		// approaches 1/rateWindow as the interval approaches zero.
+		m.rate += float64(n) / rateWindow.Seconds()
+	} else {
+		w := decay(dt)
+		m.rate = w*m.rate + (1-w)*float64(n)/dt.Seconds()
+	}
+	m.last = now
+}
+
+// at returns the rate, in bytes per second, at now.
+func (m *rateMeter) at(now time.Time) float64 {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.last.IsZero() {
+		return 0
+	}
+	return m.rate * decay(max(now.Sub(m.last), 0))
+}
+
+// eta returns the time needed to download remaining bytes at rate bytes
+// per second, rounded to seconds. Rates below a byte per second are
+// treated as a stalled download, for which the ETA is unknown.
+func eta(remaining int64, rate float64) time.Duration {
+	if remaining <= 0 {
+		return 0
+	}
+	if rate < 1 {
+		return UnknownETA
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second)
+}
+
+// formatRate formats a rate in bytes per second with a decimal unit.
+func formatRate(bytesPerSecond int64) string {
+	switch r := float64(bytesPerSecond); {
+	case r >= 1e9:
+		return fmt.Sprintf("%.2f GB/s", r/1e9)
+	case r >= 1e6:
+		return fmt.Sprintf("%.2f MB/s", r/1e6)
+	case r >= 1e3:
+		return fmt.Sprintf("%.2f kB/s", r/1e3)
+	default:
+		return fmt.Sprintf("%d B/s", bytesPerSecond)
+	}
+}
+
+// TransferStats returns the smoothed transfer rates of the torrent.
+func (t *TorrentSession) TransferStats() TransferStats {
+	now := t.now()
+	down := t.download.rate.at(now)
+	remaining := max(t.meta.BytesToDownload()-t.downloaded.Load(), 0)
+	return TransferStats{
+		DownloadRate: int64(math.Round(down)),
+		UploadRate:   int64(math.Round(t.upload.rate.at(now))),
+		Remaining:    remaining,
+		ETA:          eta(remaining, down),
+	}
+}
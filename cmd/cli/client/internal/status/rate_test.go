@@ -0,0 +1,85 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateMeter(t *testing.T) {
+	var m rateMeter
+	now := time.Unix(1000, 0)
+	assert.Zero(t, m.at(now))
+
+	// 1000 bytes every 100ms converge to 10kB/s.
+	for range 300 {
+		now = now.Add(100 * time.Millisecond)
+		m.add(1000, now)
+	}
+	assert.InDelta(t, 10_000, m.at(now), 100)
+
+	// a burst is smoothed out rather than reported as is.
+	m.add(100_000, now.Add(time.Millisecond))
+	assert.Less(t, m.at(now.Add(time.Millisecond)), 40_000.0)
+
+	// the rate decays while nothing is transferred.
+	idle := now.Add(time.Millisecond + 15*rateWindow)
+	assert.Less(t, m.at(idle), 1.0)
+}
+
+func TestETA(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining int64
+		rate      float64
+		want      time.Duration
+	}{
+		{name: "complete", remaining: 0, rate: 0, want: 0},
+		{name: "stalled", remaining: 1024, rate: 0, want: UnknownETA},
+		{name: "almost stalled", remaining: 1024, rate: 0.5, want: UnknownETA},
+		{name: "downloading", remaining: 10_000, rate: 4_000, want: 3 * time.Second},
+		{name: "long", remaining: 1 << 30, rate: 1 << 20, want: 1024 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, eta(tt.remaining, tt.rate))
+		})
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate int64
+		want string
+	}{
+		{rate: 0, want: "0 B/s"},
+		{rate: 999, want: "999 B/s"},
+		// previously logged as 409.60 kbps.
+		{rate: 4096, want: "4.10 kB/s"},
+		{rate: 12_345_678, want: "12.35 MB/s"},
+		{rate: 2_500_000_000, want: "2.50 GB/s"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatRate(tt.rate))
+	}
+}
+
+func TestTracker_TransferStats(t *testing.T) {
+	data := make([]byte, 4096)
+	tr := newTestTracker(t, int64(len(data)), data)
+
+	s := tr.TransferStats()
+	assert.Equal(t, TransferStats{Remaining: 4096, ETA: UnknownETA}, s)
+
+	now := time.Now()
+	tr.downloaded.Add(1024)
+	tr.download.rate.rate, tr.download.rate.last = 1024, now
+	tr.upload.rate.rate, tr.upload.rate.last = 512, now
+
+	s = tr.TransferStats()
+	assert.Equal(t, int64(1024), s.DownloadRate)
+	assert.Equal(t, int64(512), s.UploadRate)
+	assert.Equal(t, int64(3072), s.Remaining)
+	assert.Equal(t, 3*time.Second, s.ETA)
+}
@@ -0,0 +1,93 @@
+package status
+
+// ShareStats are the bytes a torrent uploaded to and downloaded from
+// peers, in the current run of the client and in all of its runs.
+type ShareStats struct {
+	// SessionUploaded and SessionDownloaded are the bytes uploaded and
+	// received since the torrent was added to this run of the client.
+	SessionUploaded   int64 `json:"sessionUploaded"`
+	SessionDownloaded int64 `json:"sessionDownloaded"`
+	// Uploaded and Downloaded are the bytes of all runs, which are
+	// persisted in the resume data.
+	Uploaded   int64 `json:"uploaded"`
+	Downloaded int64 `json:"downloaded"`
+	// Size is the size of the torrent, which the ratios are computed
+	// against while nothing was downloaded, e.g. by the initial seeder.
+	Size int64 `json:"size"`
+}
+
+// SessionRatio returns the ratio of the bytes uploaded to those
+// downloaded in this run, see Ratio.
+func (s ShareStats) SessionRatio() float64 {
+	return shareRatio(s.SessionUploaded, s.SessionDownloaded, s.Size)
+}
+
+// Ratio returns the ratio of the bytes uploaded to those downloaded in
+// all runs. A torrent that downloaded nothing is rated against its size.
+func (s ShareStats) Ratio() float64 { return shareRatio(s.Uploaded, s.Downloaded, s.Size) }
+
+// Add returns the sum of s and o, e.g. to rate all torrents of a client.
+func (s ShareStats) Add(o ShareStats) ShareStats {
+	return ShareStats{
+		SessionUploaded:   s.SessionUploaded + o.SessionUploaded,
+		SessionDownloaded: s.SessionDownloaded + o.SessionDownloaded,
+		Uploaded:          s.Uploaded + o.Uploaded,
+		Downloaded:        s.Downloaded + o.Downloaded,
+		Size:              s.Size + o.Size,
+	}
+}
+
+func shareRatio(uploaded, downloaded, size int64) float64 {
+	if downloaded > 0 {
+		return float64(uploaded) / float64(downloaded)
+	}
+	if size > 0 {
+		return float64(uploaded) / float64(size)
+	}
+	return 0
+}
+
+// share are the counters of the previous runs of a torrent, see ShareStats.
+type share struct {
+	// uploaded and downloaded are the bytes of the previous runs.
+	uploaded, downloaded int64
+	// restored are the uploaded bytes passed to WithUploaded,
+	// which were not uploaded in this run.
+	restored int64
+}
+
+// restoreShare carries over the counters of the previous runs from the
+// resume data r, if not nil. The bytes restored by WithUploaded are those
+// reported to the tracker, which are more recent if the resume data is
+// older, so that the counters never go backwards.
+func (t *TorrentSession) restoreShare(r *resume) {
+	t.share.restored = t.uploaded.Load()
+	t.share.uploaded = t.share.restored
+	if r != nil {
+		t.share.uploaded = max(t.share.uploaded, r.Uploaded)
+		t.share.downloaded = r.Downloaded
+	}
+}
+
+// ShareStats returns the bytes the torrent uploaded and downloaded.
+func (t *TorrentSession) ShareStats() ShareStats {
+	s := ShareStats{
+		SessionUploaded:   t.uploaded.Load() - t.share.restored,
+		SessionDownloaded: t.metrics.received.Load(),
+		Size:              t.meta.BytesToDownload(),
+	}
+	s.Uploaded = t.share.uploaded + s.SessionUploaded
+	s.Downloaded = t.share.downloaded + s.SessionDownloaded
+	return s
+}
+
+// Finished reports whether the torrent reached its seeding goals
+// and no longer seeds, see WithSeedRatio and WithSeedTime.
+func (t *TorrentSession) Finished() bool {
+	select {
+	case <-t.upload.seeded:
+		return true
+	default:
+		return false
+	}
+}
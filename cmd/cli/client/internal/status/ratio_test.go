@@ -0,0 +1,64 @@
+package status
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareStats_Ratio(t *testing.T) {
+	s := ShareStats{SessionUploaded: 30, SessionDownloaded: 20, Uploaded: 60, Downloaded: 20, Size: 40}
+	assert.Equal(t, 1.5, s.SessionRatio())
+	assert.Equal(t, 3.0, s.Ratio())
+
+	// the initial seeder downloaded nothing and is rated against the size.
+	seeder := ShareStats{SessionUploaded: 10, Uploaded: 20, Size: 40}
+	assert.Equal(t, 0.25, seeder.SessionRatio())
+	assert.Equal(t, 0.5, seeder.Ratio())
+	assert.Zero(t, ShareStats{}.Ratio())
+
+	assert.Equal(t, ShareStats{SessionUploaded: 40, SessionDownloaded: 20, Uploaded: 80, Downloaded: 20, Size: 80}, s.Add(seeder))
+}
+
+func TestTorrentSession_ShareStatsPersisted(t *testing.T) {
+	piece := []byte{0x1, 0x2, 0x3, 0x4}
+	tr := newTestTracker(t, int64(len(piece)), piece)
+	base := t.TempDir()
+	tr.setDownloadDir(filepath.Join(base, hex.EncodeToString(tr.meta.Metadata.Hash[:])))
+	tr.restoreShare(nil)
+
+	tr.metrics.received.Store(4)
+	tr.uploaded.Store(10)
+	assert.Equal(t, ShareStats{SessionUploaded: 10, SessionDownloaded: 4, Uploaded: 10, Downloaded: 4, Size: 4}, tr.ShareStats())
+	assert.Nil(t, tr.saveResume())
+
+	restored, err := NewTorrentSession("client", tr.logger, tr.meta, base)
+	assert.Nil(t, err)
+	t.Cleanup(func() { restored.Close() })
+
+	restored.uploaded.Add(2)
+	assert.Equal(t, ShareStats{SessionUploaded: 2, Uploaded: 12, Downloaded: 4, Size: 4}, restored.ShareStats())
+	assert.Equal(t, 3.0, restored.ShareStats().Ratio())
+}
+
+func TestTorrentSession_ShareStatsNeverGoBackwards(t *testing.T) {
+	piece := []byte{0x1, 0x2, 0x3, 0x4}
+	tr := newTestTracker(t, int64(len(piece)), piece)
+
+	// the counters reported to the tracker are more recent than the resume data.
+	WithUploaded(25)(tr)
+	tr.restoreShare(&resume{Uploaded: 10, Downloaded: 4})
+	assert.Equal(t, ShareStats{Uploaded: 25, Downloaded: 4, Size: 4}, tr.ShareStats())
+
+	tr.uploaded.Add(5)
+	assert.Equal(t, ShareStats{SessionUploaded: 5, Uploaded: 30, Downloaded: 4, Size: 4}, tr.ShareStats())
+
+	// older counters reported to the tracker keep those of the resume data.
+	older := newTestTracker(t, int64(len(piece)), piece)
+	WithUploaded(5)(older)
+	older.restoreShare(&resume{Uploaded: 10})
+	assert.Equal(t, int64(10), older.ShareStats().Uploaded)
+	assert.Zero(t, older.ShareStats().SessionUploaded)
+}
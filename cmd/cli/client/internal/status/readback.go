@@ -0,0 +1,84 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Despire/tinytorrent/storage"
+)
+
+// maxDiskErrors is the number of pieces that may fail to read back as
+// written before the download is stopped, as the disk is likely failing.
+const maxDiskErrors = 3
+
+// ErrDiskCorruption is the reason for a failed download
+// if too many pieces did not read back as written.
+var ErrDiskCorruption = errors.New("flushed pieces did not read back as written")
+
+// readBack reads the flushed piece p back from storage and verifies its
+// hash again, as a job queued on the disk scheduler so that the receiver
+// of the blocks is not held up by the disk. The piece keeps its slot
+// meanwhile and is marked as verified, or downloaded again, once the
+// job completed.
+func (t *TorrentSession) readBack(logger *slog.Logger, p *pendingPiece) {
+	defer t.download.wg.Done()
+
+	ok, err := storage.VerifyBack(t.storage, p.Index, uint32(p.Size), t.meta.PieceHash(p.Index))
+
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.readingBack = false
+
+	if !ok && t.stopping() {
+		// the piece is downloaded again once resumed, without counting
+		// a disk error as the read back may have been cut short.
+		if t.download.active.remove(p) {
+			t.buffers.release(StageFlushing, p.Size)
+			t.downloaded.Add(-p.Size)
+			t.download.waste.shutdown.Add(p.Size)
+		}
+		return
+	}
+
+	if !ok {
+		t.readBackFailed(logger, p.Index, p.Size, err)
+		t.downloaded.Add(-p.Size)
+		t.download.waste.flushFailed.Add(p.Size)
+		t.buffers.move(StageFlushing, StageReceiving, p.Size)
+		if err := p.Retry(); err != nil {
+			panic("malformed state, expected no pending requests when rescheduling piece for retry download")
+		}
+		return
+	}
+
+	t.pieceVerified(logger, p)
+}
+
+// stopping reports whether the download is being stopped.
+func (t *TorrentSession) stopping() bool {
+	select {
+	case <-t.stop:
+		return true
+	case <-t.canceled():
+		return true
+	default:
+		return false
+	}
+}
+
+// readBackFailed counts and emits the disk error of the piece that did
+// not read back as written, and fails the download once there are too many.
+func (t *TorrentSession) readBackFailed(logger *slog.Logger, idx int64, size int64, err error) {
+	failures := t.download.diskErrors.Add(1)
+	logger.Error("flushed piece did not read back as written, retrying",
+		slog.String("piece", fmt.Sprint(idx)),
+		slog.Int64("disk_errors", failures),
+		slog.Any("err", err),
+	)
+	t.emit(DiskVerificationFailed{Piece: idx, Size: size, Err: err, Failures: failures})
+
+	if failures >= maxDiskErrors {
+		t.fail(fmt.Errorf("%w: %d pieces", ErrDiskCorruption, failures))
+	}
+}
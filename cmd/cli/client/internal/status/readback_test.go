@@ -0,0 +1,171 @@
+package status
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_ReadBackVerification(t *testing.T) {
+	tests := []struct {
+		name        string
+		corruptRate float64
+		wantFailed  bool
+	}{
+		{name: "intact disk", corruptRate: 0},
+		{name: "corrupting disk", corruptRate: 1, wantFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, 4*messagesv1.RequestSize)
+			for i := range data {
+				data[i] = byte(i * 5)
+			}
+			var pieces [][]byte
+			for i := range 4 {
+				pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+			}
+
+			tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+			tr.clientID = "-TT0100-000000000000"
+			disk := storagetest.NewFlakyStorage(storage.NewMemory(), 1, storagetest.WithWriteCorruption(tt.corruptRate))
+			WithStorage(disk)(tr)
+			WithReadBackVerification()(tr)
+
+			var (
+				l      sync.Mutex
+				events []Event
+			)
+			tr.Subscribe(func(e Event) {
+				l.Lock()
+				defer l.Unlock()
+				events = append(events, e)
+			})
+
+			seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+			tr.download.wg.Add(1)
+			go tr.keepAliveSeeders(seeder.addr)
+			assert.Eventually(t, func() bool {
+				v, ok := tr.peers.seeders.Load(seeder.addr)
+				return ok && v.(*peer.Peer).Bitfield.Check(0)
+			}, 5*time.Second, 10*time.Millisecond)
+
+			tr.download.wg.Add(1)
+			go tr.downloadScheduler()
+
+			select {
+			case <-tr.WaitUntilDownloaded():
+				assert.False(t, tt.wantFailed, "download should have failed")
+			case <-tr.Failed():
+				assert.True(t, tt.wantFailed, "download should not have failed")
+			case <-time.After(5 * time.Second):
+				t.Fatal("download neither completed nor failed")
+			}
+			tr.CancelDownload()
+
+			l.Lock()
+			defer l.Unlock()
+			if !tt.wantFailed {
+				assert.NoError(t, tr.Err())
+				for _, e := range events {
+					assert.IsType(t, TorrentCompleted{}, e, "intact disks emit no errors")
+				}
+				assert.Empty(t, tr.have.MissingPieces())
+				return
+			}
+
+			assert.ErrorIs(t, tr.Err(), ErrDiskCorruption)
+			// blocks that were already received are still verified after failing.
+			assert.GreaterOrEqual(t, tr.DiskErrors(), int64(maxDiskErrors))
+			assert.Len(t, tr.have.MissingPieces(), 4, "corrupted pieces remain missing")
+			var failed int
+			for _, e := range events {
+				if _, ok := e.(TorrentFailed); ok {
+					failed++
+					continue
+				}
+				assert.IsType(t, DiskVerificationFailed{}, e, "disk errors are not reported as network corruption")
+			}
+			assert.Equal(t, 1, failed)
+			assert.Len(t, events, int(tr.DiskErrors())+failed)
+			_, banned := tr.peers.banned.Load(seeder.addr)
+			assert.False(t, banned)
+		})
+	}
+}
+
+// blockingReads blocks the first read until release is closed.
+type blockingReads struct {
+	storage.Storage
+	once    sync.Once
+	blocked chan int64
+	release chan struct{}
+}
+
+func (s *blockingReads) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	first := false
+	s.once.Do(func() { first = true })
+	if first {
+		s.blocked <- piece
+		<-s.release
+	}
+	return s.Storage.ReadBlock(piece, begin, length)
+}
+
+func TestTracker_ReadBackDoesNotBlockReceiver(t *testing.T) {
+	data := make([]byte, 4*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	var pieces [][]byte
+	for i := range 4 {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	disk := &blockingReads{Storage: storage.NewMemory(), blocked: make(chan int64, 1), release: make(chan struct{})}
+	WithStorage(disk)(tr)
+	WithReadBackVerification()(tr)
+	defer tr.CancelDownload()
+	release := sync.OnceFunc(func() { close(disk.release) })
+	defer release()
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	var blocked int64
+	select {
+	case blocked = <-disk.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no piece was read back")
+	}
+
+	// the single peer keeps delivering the other pieces meanwhile.
+	assert.Eventually(t, func() bool { return len(tr.have.MissingPieces()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"receiver blocked while reading back piece %d", blocked)
+	assert.False(t, tr.have.Check(blocked), "piece is verified only once read back")
+
+	release()
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("download did not complete")
+	}
+	assert.NoError(t, tr.Err())
+	assert.Zero(t, tr.DiskErrors())
+}
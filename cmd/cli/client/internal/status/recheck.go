@@ -0,0 +1,303 @@
+package status
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
+)
+
+var (
+	// ErrRechecking is returned by Recheck if the torrent is already being rechecked.
+	ErrRechecking = errors.New("torrent is already being rechecked")
+	// ErrNotRechecking is returned by CancelRecheck if the torrent is not being rechecked.
+	ErrNotRechecking = errors.New("torrent is not being rechecked")
+	// ErrRecheckCompleted is returned by Recheck if the download of the torrent
+	// completed, as the pieces that fail would never be downloaded again.
+	ErrRecheckCompleted = errors.New("torrent completed, add it again to recheck its pieces")
+)
+
+// errClosed is returned by Recheck once the session is closed.
+var errClosed = errors.New("torrent is closed")
+
+// defaultRecheckCheckpoint is the number of pieces after
+// which a recheck persists the pieces verified so far.
+const defaultRecheckCheckpoint = 256
+
+// RecheckStats is the outcome of Recheck.
+type RecheckStats struct {
+	// Valid is the number of downloaded pieces that passed the verification.
+	Valid int64 `json:"valid"`
+	// Invalid is the number of downloaded pieces that failed the
+	// verification, and are no longer considered downloaded.
+	Invalid int64 `json:"invalid"`
+	// Found is the number of missing pieces whose data was in the
+	// storage already, and which are now considered downloaded.
+	Found int64 `json:"found"`
+}
+
+// RecheckProgress is the progress of a running recheck.
+type RecheckProgress struct {
+	// Checked is the number of the pieces verified, including
+	// those verified by an interrupted recheck continued from.
+	Checked int64
+	Pieces  int64
+	// ETA is the estimated time until all pieces are verified,
+	// zero until the first piece was verified.
+	ETA time.Duration
+}
+
+// recheck is the state of the recheck of a torrent.
+type recheck struct {
+	// l guards cancel, which cancels the running recheck, and
+	// closed, which is set once the session is closed. wg waits
+	// for the running recheck.
+	l      sync.Mutex
+	cancel context.CancelFunc
+	closed bool
+	wg     sync.WaitGroup
+	// checked is the number of pieces verified by the running
+	// recheck, which started at the piece from at since.
+	checked atomic.Int64
+	from    atomic.Int64
+	since   atomic.Int64
+	// pending is the number of pieces verified by an interrupted
+	// recheck, which the next one continues from, zero if none.
+	pending atomic.Int64
+	// checkpoint is the number of pieces after which the verified
+	// ones are persisted, defaultRecheckCheckpoint if zero.
+	checkpoint int64
+}
+
+// Recheck verifies every piece of the torrent in its storage, and replaces
+// the downloaded pieces by the ones that passed. A running download is
+// stopped during the verification and then downloads the pieces that
+// failed. Torrents that completed already are refused with
+// ErrRecheckCompleted, as their download is not started again.
+//
+// The verified pieces are persisted every few pieces. If ctx is canceled,
+// or the session closed, the pieces verified so far replace the downloaded
+// ones and the torrent is paused. The next recheck, also after a restart,
+// continues with the pieces that were not verified, and returns the stats
+// of those only.
+func (t *TorrentSession) Recheck(ctx context.Context) (RecheckStats, error) {
+	if !t.rechecking.CompareAndSwap(false, true) {
+		return RecheckStats{}, ErrRechecking
+	}
+	defer t.rechecking.Store(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.check.l.Lock()
+	if t.check.closed {
+		t.check.l.Unlock()
+		return RecheckStats{}, errClosed
+	}
+	t.check.cancel = cancel
+	t.check.wg.Add(1)
+	t.check.l.Unlock()
+	defer t.check.wg.Done()
+
+	return t.recheck(ctx)
+}
+
+// CancelRecheck cancels the running recheck, see Recheck.
+func (t *TorrentSession) CancelRecheck() error {
+	t.check.l.Lock()
+	defer t.check.l.Unlock()
+	if !t.rechecking.Load() || t.check.cancel == nil {
+		return ErrNotRechecking
+	}
+	t.check.cancel()
+	return nil
+}
+
+// Rechecking reports whether the torrent is being rechecked, with its progress.
+func (t *TorrentSession) Rechecking() (RecheckProgress, bool) {
+	if !t.rechecking.Load() {
+		return RecheckProgress{}, false
+	}
+	p := RecheckProgress{Checked: t.check.checked.Load(), Pieces: t.meta.NumPieces()}
+	if done := p.Checked - t.check.from.Load(); done > 0 {
+		elapsed := t.since(time.Unix(0, t.check.since.Load()))
+		p.ETA = time.Duration(float64(elapsed) / float64(done) * float64(p.Pieces-p.Checked))
+	}
+	return p, true
+}
+
+// closeRecheck cancels the running recheck, waits for it to persist
+// the verified pieces and prevents further ones.
+func (t *TorrentSession) closeRecheck() {
+	t.check.l.Lock()
+	t.check.closed = true
+	if t.check.cancel != nil {
+		t.check.cancel()
+	}
+	t.check.l.Unlock()
+	t.check.wg.Wait()
+}
+
+func (t *TorrentSession) recheck(ctx context.Context) (RecheckStats, error) {
+	if t.downloadCompleted() {
+		return RecheckStats{}, ErrRecheckCompleted
+	}
+
+	running := !t.paused.Load()
+	select {
+	case <-t.Failed():
+		running = false
+	default:
+	}
+	if running {
+		t.stopDownload()
+		// the download may have completed before it was stopped.
+		if t.downloadCompleted() {
+			return RecheckStats{}, ErrRecheckCompleted
+		}
+	}
+
+	var (
+		stats      RecheckStats
+		pieces     = t.meta.NumPieces()
+		from       = t.check.pending.Load()
+		checkpoint = cmp.Or(t.check.checkpoint, defaultRecheckCheckpoint)
+		have       = bitfield.NewBitfield(pieces)
+	)
+	if from > 0 {
+		t.logger.Info("continuing interrupted recheck of torrent", slog.Int64("checked", from))
+	} else {
+		t.logger.Info("rechecking torrent")
+	}
+	// the pieces verified by the interrupted recheck were applied already.
+	for i := range from {
+		if t.have.Check(i) {
+			have.Set(i)
+		}
+	}
+	t.check.checked.Store(from)
+	t.check.from.Store(from)
+	t.check.since.Store(t.now().UnixNano())
+
+	next := from
+	for ; next < pieces && ctx.Err() == nil; next++ {
+		size := t.meta.PieceSize(next)
+		valid := false
+		// the pieces are verified as stored, not as cached.
+		if b, err := storage.ReadThrough(t.storage, next, 0, uint32(size)); err == nil {
+			digest := sha1.Sum(b)
+			valid = bytes.Equal(digest[:], t.meta.PieceHash(next))
+		}
+
+		switch had := t.have.Check(next); {
+		case valid && had:
+			stats.Valid++
+		case valid:
+			stats.Found++
+			t.setSource(next, PieceSourceLocal)
+		case had:
+			stats.Invalid++
+			t.setSource(next, PieceSourceNone)
+		}
+		if valid {
+			have.Set(next)
+		}
+		t.check.checked.Store(next + 1)
+
+		if checked := next + 1; (checked-from)%checkpoint == 0 && checked < pieces {
+			t.applyRecheck(have, checked)
+			if err := t.saveResume(); err != nil {
+				t.logger.Error("failed to persist the pieces rechecked so far", slog.Any("err", err))
+			}
+		}
+	}
+	t.applyRecheck(have, next)
+
+	interrupted := next < pieces
+	if interrupted {
+		t.logger.Info("recheck interrupted, pausing torrent",
+			slog.Int64("checked", next),
+			slog.Int64("pieces", pieces),
+		)
+		if !t.paused.Swap(true) {
+			t.closeLeechers()
+		}
+	} else {
+		t.logger.Info("rechecked torrent",
+			slog.Int64("valid", stats.Valid),
+			slog.Int64("invalid", stats.Invalid),
+			slog.Int64("found", stats.Found),
+		)
+	}
+
+	var err error
+	if errSave := t.saveResume(); errSave != nil {
+		err = fmt.Errorf("failed to persist rechecked pieces: %w", errSave)
+	}
+	if interrupted {
+		return stats, errors.Join(fmt.Errorf("recheck interrupted after %d of %d pieces: %w", next, pieces, ctx.Err()), err)
+	}
+	if running {
+		t.startDownload()
+		// the seeders were disconnected while rechecking.
+		select {
+		case t.download.reannounce <- struct{}{}:
+		default:
+		}
+	}
+	return stats, err
+}
+
+// applyRecheck replaces the downloaded pieces before next by the ones
+// set in have, which the recheck verified, and keeps the others.
+func (t *TorrentSession) applyRecheck(have *bitfield.BitField, next int64) {
+	pieces := t.meta.NumPieces()
+	merged := bitfield.NewBitfield(pieces)
+	merged.Overwrite(have.Clone())
+	for i := next; i < pieces; i++ {
+		if t.have.Check(i) {
+			merged.Set(i)
+		}
+	}
+
+	t.durability.l.Lock()
+	defer t.durability.l.Unlock()
+	// pieces that failed are no longer durable either, should the sync fail.
+	durable := bitfield.NewBitfield(pieces)
+	for _, i := range t.durability.durable.ExistingPieces() {
+		if merged.Check(i) {
+			durable.Set(i)
+		}
+	}
+	var downloaded int64
+	for _, i := range merged.ExistingPieces() {
+		downloaded += t.meta.PieceSize(i)
+	}
+	t.durability.durable.Overwrite(durable.Clone())
+	t.have.Overwrite(merged.Clone())
+	t.downloaded.Store(downloaded)
+	if next < pieces {
+		t.check.pending.Store(next)
+	} else {
+		t.check.pending.Store(0)
+	}
+}
+
+// downloadCompleted reports whether all pieces were downloaded.
+func (t *TorrentSession) downloadCompleted() bool {
+	select {
+	case <-t.download.completed:
+		return true
+	default:
+		return false
+	}
+}
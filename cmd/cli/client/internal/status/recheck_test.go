@@ -0,0 +1,214 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRecheckFixture returns a tracker of four pieces, of which the first
+// is stored and downloaded, the second downloaded but stored corrupted,
+// the third stored but missing and the last neither stored nor downloaded.
+func newRecheckFixture(t *testing.T) (*TorrentSession, []byte, [][]byte) {
+	t.Helper()
+	const pieceLength = 1024
+	data := make([]byte, 4*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var pieces [][]byte
+	for i := 0; i < len(data); i += pieceLength {
+		pieces = append(pieces, data[i:i+pieceLength])
+	}
+
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	assert.NoError(t, tr.Flush(0, pieces[0]))
+	assert.NoError(t, tr.Flush(1, bytes.Repeat([]byte{0xff}, pieceLength)))
+	assert.NoError(t, tr.Flush(2, pieces[2]))
+	tr.have.Set(0)
+	tr.have.Set(1)
+	tr.downloaded.Store(2 * pieceLength)
+	assert.NoError(t, tr.saveResume())
+	return tr, data, pieces
+}
+
+func TestTracker_Recheck(t *testing.T) {
+	tr, _, _ := newRecheckFixture(t)
+	tr.paused.Store(true)
+
+	stats, err := tr.Recheck(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RecheckStats{Valid: 1, Invalid: 1, Found: 1}, stats)
+	assert.Equal(t, []int64{0, 2}, tr.have.ExistingPieces())
+	assert.Equal(t, int64(2*1024), tr.downloaded.Load())
+	assert.True(t, tr.Paused())
+
+	b, err := os.ReadFile(filepath.Join(tr.DownloadDir(), resumeFile))
+	assert.NoError(t, err)
+	var r resume
+	assert.NoError(t, json.Unmarshal(b, &r))
+	assert.Equal(t, tr.have.Clone(), r.Bitfield)
+
+	// a recheck already running is not started again.
+	tr.rechecking.Store(true)
+	_, err = tr.Recheck(context.Background())
+	assert.ErrorIs(t, err, ErrRechecking)
+}
+
+func TestTracker_RecheckBypassesPieceCache(t *testing.T) {
+	tr, _, _ := newRecheckFixture(t)
+	tr.paused.Store(true)
+	cache := storage.NewPieceCache(storage.DefaultCacheSize)
+	tr.storage = cache.Wrap(tr.storage, tr.meta.PieceSize)
+
+	// the cached piece is corrupted on disk afterwards.
+	_, err := tr.ReadRequest(&messagesv1.Request{Index: 0, Length: 16})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(tr.DownloadDir(), "0.bin"), make([]byte, 1024), 0o644))
+
+	stats, err := tr.Recheck(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RecheckStats{Invalid: 2, Found: 1}, stats)
+	assert.Equal(t, storage.CacheStats{Misses: 1, Size: 1024, Budget: storage.DefaultCacheSize}, cache.Stats())
+}
+
+func TestTracker_RecheckRunning(t *testing.T) {
+	tr, data, pieces := newRecheckFixture(t)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	// the download is stopped before verifying and started again,
+	// which fetches the corrupted and the missing pieces.
+	stats, err := tr.Recheck(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RecheckStats{Valid: 1, Invalid: 1, Found: 1}, stats)
+
+	seeder := newStubSeeder(t, 1024, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+
+	// the restarted scheduler found no peers and waits before looking again.
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("rechecked torrent was not downloaded")
+	}
+	tr.CancelDownload()
+	assertPieces(t, tr, pieces)
+
+	select {
+	case <-tr.Reannounce():
+	default:
+		t.Error("recheck of a running download did not request an announce")
+	}
+}
+
+func TestTracker_RecheckCompleted(t *testing.T) {
+	tr, _, _ := newRecheckFixture(t)
+	close(tr.download.completed)
+
+	// the corrupted piece would never be downloaded again.
+	stats, err := tr.Recheck(context.Background())
+	assert.ErrorIs(t, err, ErrRecheckCompleted)
+	assert.Zero(t, stats)
+	assert.Equal(t, []int64{0, 1}, tr.have.ExistingPieces())
+	assert.False(t, tr.Paused())
+}
+
+// hookStorage calls onRead before each read of the storage it wraps.
+type hookStorage struct {
+	storage.Storage
+	onRead func(piece int64)
+}
+
+func (s *hookStorage) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	s.onRead(piece)
+	return s.Storage.ReadBlock(piece, begin, length)
+}
+
+func TestTracker_RecheckCanceled(t *testing.T) {
+	tr, _, _ := newRecheckFixture(t)
+	tr.check.checkpoint = 1
+
+	// the recheck is canceled while verifying the second piece.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr.storage = &hookStorage{Storage: tr.storage, onRead: func(piece int64) {
+		if piece != 1 {
+			return
+		}
+		progress, ok := tr.Rechecking()
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), progress.Checked)
+		assert.Equal(t, int64(4), progress.Pieces)
+		assert.Positive(t, progress.ETA)
+		cancel()
+	}}
+
+	stats, err := tr.Recheck(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, RecheckStats{Valid: 1, Invalid: 1}, stats)
+	assert.Equal(t, []int64{0}, tr.have.ExistingPieces(), "the corrupted piece was dropped")
+	assert.True(t, tr.Paused())
+	_, ok := tr.Rechecking()
+	assert.False(t, ok)
+
+	b, err := os.ReadFile(filepath.Join(tr.DownloadDir(), resumeFile))
+	assert.NoError(t, err)
+	var r resume
+	assert.NoError(t, json.Unmarshal(b, &r))
+	assert.Equal(t, int64(2), r.Recheck)
+	assert.True(t, r.Paused)
+
+	// once restarted, the torrent is paused with the pieces verified so far
+	// and the next recheck verifies only the remaining pieces.
+	base := t.TempDir()
+	dir := filepath.Join(base, hex.EncodeToString(tr.meta.Metadata.Hash[:]))
+	assert.NoError(t, os.Rename(tr.DownloadDir(), dir))
+	restored, err := NewTorrentSession("-TT0100-000000000000", tr.logger, tr.meta, base)
+	assert.NoError(t, err)
+	t.Cleanup(func() { restored.Close() })
+	assert.True(t, restored.Paused())
+	assert.Equal(t, []int64{0}, restored.have.ExistingPieces())
+	assert.Equal(t, int64(1024), restored.downloaded.Load())
+
+	var reads []int64
+	restored.storage = &hookStorage{Storage: restored.storage, onRead: func(piece int64) { reads = append(reads, piece) }}
+	stats, err = restored.Recheck(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RecheckStats{Found: 1}, stats)
+	assert.Equal(t, []int64{2, 3}, reads)
+	assert.Equal(t, []int64{0, 2}, restored.have.ExistingPieces())
+	assert.True(t, restored.Paused())
+	assert.Zero(t, restored.check.pending.Load())
+}
+
+func TestTracker_CancelRecheck(t *testing.T) {
+	tr, _, _ := newRecheckFixture(t)
+	assert.ErrorIs(t, tr.CancelRecheck(), ErrNotRechecking)
+
+	tr.storage = &hookStorage{Storage: tr.storage, onRead: func(piece int64) {
+		if piece == 0 {
+			assert.NoError(t, tr.CancelRecheck())
+		}
+	}}
+	_, err := tr.Recheck(context.Background())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(1), tr.check.pending.Load())
+
+	// closing the tracker interrupts rechecks as well.
+	tr.closeRecheck()
+	_, err = tr.Recheck(context.Background())
+	assert.ErrorIs(t, err, errClosed)
+}
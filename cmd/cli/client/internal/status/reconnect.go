@@ -0,0 +1,65 @@
+package status
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// reconnectPolicy decides how long to wait before connecting
+// again to a seeder after failed connection attempts.
+type reconnectPolicy struct {
+	// interval is the time between keep alive messages and the
+	// delay after the first failed connection attempt.
+	interval time.Duration
+	// max caps the delay between connection attempts.
+	max time.Duration
+	// maxHandshakeFailures is the number of consecutive failed
+	// handshakes after which the peer is no longer contacted
+	// until it is listed again by the tracker.
+	maxHandshakeFailures int
+}
+
+var defaultReconnectPolicy = reconnectPolicy{
+	interval:             2 * time.Minute,
+	max:                  30 * time.Minute,
+	maxHandshakeFailures: 5,
+}
+
+// connectFailures counts the consecutive failed connection attempts to a peer.
+type connectFailures struct {
+	dial, handshake int
+}
+
+// record counts the failed attempt and returns the delay before the next
+// one. If the peer should no longer be contacted false is returned.
+//
+// A peer that cannot be dialed may just be offline, and is retried with a
+// delay doubling after every other failure. A peer that accepts connections
+// but fails the handshake is most likely not a BitTorrent client, the delay
+// doubles after every failure and the peer is given up eventually.
+func (p reconnectPolicy) record(f *connectFailures, err error) (time.Duration, bool) {
+	var exp int
+	switch {
+	case errors.Is(err, peer.ErrHandshake):
+		f.dial = 0
+		f.handshake++
+		if f.handshake >= p.maxHandshakeFailures {
+			return 0, false
+		}
+		exp = f.handshake - 1
+	default:
+		f.handshake = 0
+		f.dial++
+		exp = (f.dial - 1) / 2
+	}
+
+	delay := p.interval
+	for range exp {
+		if delay *= 2; delay >= p.max {
+			return p.max, true
+		}
+	}
+	return min(delay, p.max), true
+}
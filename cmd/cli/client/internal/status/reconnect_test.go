@@ -0,0 +1,218 @@
+package status
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectPolicy_Record(t *testing.T) {
+	policy := reconnectPolicy{interval: time.Minute, max: 10 * time.Minute, maxHandshakeFailures: 4}
+	dial := fmt.Errorf("%w: connection refused", peer.ErrDial)
+	handshake := fmt.Errorf("%w: EOF", peer.ErrHandshake)
+
+	type attempt struct {
+		err   error
+		delay time.Duration
+		retry bool
+	}
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{
+			name: "dial-failures-back-off-slowly-and-never-give-up",
+			attempts: []attempt{
+				{dial, time.Minute, true},
+				{dial, time.Minute, true},
+				{dial, 2 * time.Minute, true},
+				{dial, 2 * time.Minute, true},
+				{dial, 4 * time.Minute, true},
+				{dial, 4 * time.Minute, true},
+				{dial, 8 * time.Minute, true},
+				{dial, 8 * time.Minute, true},
+				{dial, 10 * time.Minute, true},
+				{dial, 10 * time.Minute, true},
+			},
+		},
+		{
+			name: "handshake-failures-give-up",
+			attempts: []attempt{
+				{handshake, time.Minute, true},
+				{handshake, 2 * time.Minute, true},
+				{handshake, 4 * time.Minute, true},
+				{handshake, 0, false},
+			},
+		},
+		{
+			name: "dial-failure-resets-handshake-failures",
+			attempts: []attempt{
+				{handshake, time.Minute, true},
+				{handshake, 2 * time.Minute, true},
+				{handshake, 4 * time.Minute, true},
+				{dial, time.Minute, true},
+				{handshake, time.Minute, true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f connectFailures
+			for i, a := range tt.attempts {
+				delay, retry := policy.record(&f, a.err)
+				assert.Equal(t, a.delay, delay, "attempt %d", i)
+				assert.Equal(t, a.retry, retry, "attempt %d", i)
+			}
+		})
+	}
+}
+
+func TestTracker_GivesUpAfterHandshakeFailures(t *testing.T) {
+	tr := newTestTracker(t, 4, []byte{0x1, 0x2, 0x3, 0x4})
+	tr.clientID = "-TT0100-000000000000"
+	tr.download.reconnect = reconnectPolicy{interval: 50 * time.Millisecond, max: time.Second, maxHandshakeFailures: 3}
+
+	// accepts the connection and immediately closes it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	var l sync.Mutex
+	var accepted []time.Time
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			l.Lock()
+			accepted = append(accepted, time.Now())
+			l.Unlock()
+			conn.Close()
+		}
+	}()
+
+	tr.peers.connecting.Store(ln.Addr().String(), struct{}{})
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(ln.Addr().String())
+
+	done := make(chan struct{})
+	go func() { tr.download.wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer failing handshakes was not given up")
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	assert.Len(t, accepted, 3)
+	assert.Greater(t, accepted[2].Sub(accepted[1]), accepted[1].Sub(accepted[0]))
+
+	_, connecting := tr.peers.connecting.Load(ln.Addr().String())
+	assert.False(t, connecting, "a future announce must be able to list the peer again")
+}
+
+func TestTracker_ReconnectStorm(t *testing.T) {
+	const storms = 100
+	data := make([]byte, storms*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	// a single piece whose blocks were all requested from the peer,
+	// each connection delivers one of them and is then killed.
+	tr := newTestTracker(t, int64(len(data)), data)
+	tr.clientID = "-TT0100-000000000000"
+	tr.download.reconnect = reconnectPolicy{interval: time.Millisecond, max: time.Second, maxHandshakeFailures: 3}
+	piece := &pendingPiece{Index: 0, Attempt: 1, Size: int64(len(data))}
+	for i := range storms {
+		piece.InFlight = append(piece.InFlight, &timedDownloadRequest{
+			request: messagesv1.Request{Index: 0, Begin: uint32(i * messagesv1.RequestSize), Length: messagesv1.RequestSize},
+		})
+	}
+	tr.download.active.add(piece)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	baseline := runtime.NumGoroutine()
+	var accepted, peak atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			i := accepted.Add(1) - 1
+			peak.Store(max(peak.Load(), int64(runtime.NumGoroutine())))
+			serveBlock(conn, data, i)
+		}
+	}()
+
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(ln.Addr().String())
+
+	// the completion is detected by the scheduler, which is not running.
+	assert.Eventually(t, func() bool { return tr.have.Check(0) }, 20*time.Second, time.Millisecond)
+	tr.CancelDownload()
+	ln.Close()
+
+	assert.GreaterOrEqual(t, accepted.Load(), int64(storms))
+	// every block was handled once, by the receiver of its connection.
+	assert.Equal(t, int64(len(data)), tr.Metrics().Received)
+	assert.Equal(t, int64(len(data)), tr.statsFor(ln.Addr().String()).downloaded.Load())
+	// a receiver leaked per connection would add up over the storm.
+	assert.Less(t, peak.Load()-int64(baseline), int64(10))
+	// polled here, as assert.Eventually runs goroutines of its own.
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > baseline && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
+
+// serveBlock answers the handshake of conn and sends the i-th block of
+// data unrequested, if any, and closes conn once the peer is interested.
+func serveBlock(conn net.Conn, data []byte, i int64) {
+	defer conn.Close()
+
+	var buf [messagesv1.HandshakeLength]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return
+	}
+	hs := new(messagesv1.Handshake)
+	if err := hs.Deserialize(buf[:]); err != nil {
+		return
+	}
+	hs.PeerID = "-ST0001-000000000000"
+	have := bitfield.NewBitfield(1)
+	have.Set(0)
+	msgs := [][]byte{hs.Serialize(), (&messagesv1.Bitfield{Bitfield: have.Clone()}).Serialize()}
+	if begin := i * messagesv1.RequestSize; begin < int64(len(data)) {
+		msgs = append(msgs, (&messagesv1.Piece{Begin: uint32(begin), Block: data[begin : begin+messagesv1.RequestSize]}).Serialize())
+	}
+	for _, msg := range msgs {
+		if _, err := conn.Write(msg); err != nil {
+			return
+		}
+	}
+	// closing before the messages of the peer were read could reset
+	// the connection and drop the block.
+	for {
+		msg, err := messagesv1.Identify(conn)
+		if err != nil || msg.Type == messagesv1.InterestType {
+			return
+		}
+	}
+}
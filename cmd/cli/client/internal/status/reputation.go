@@ -0,0 +1,170 @@
+package status
+
+import (
+	"crypto/sha1"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reputation of the seeders, scoring the data they delivered. The
+// picker weights candidates with a negative reputation down, see
+// reputationWeight, so that peers delivering corrupt data are starved
+// before they contributed to maxHashFailures pieces and are banned.
+const (
+	// reputationGood is added for each block of a verified piece.
+	reputationGood = 1
+	// reputationTimeout is subtracted for each request that timed out.
+	reputationTimeout = 1
+	// reputationSuspect is subtracted from each peer that contributed
+	// to a piece failing verification, and added back once the piece
+	// verifies if the blocks of the peer were not corrupt.
+	reputationSuspect = 4
+	// reputationCorrupt is subtracted for each corrupt block, which
+	// is known once the piece it was delivered for verifies.
+	reputationCorrupt = 32
+	// maxReputation bounds the credit of a peer, so that a peer
+	// delivering corrupt data after a while is starved soon.
+	maxReputation = 32
+	// reputationScale is the score that weights a peer down by 1/e.
+	reputationScale = 8
+	// reputationHalfLife is the time after which a score is halved,
+	// so that a peer is no longer punished for an old corrupt piece.
+	reputationHalfLife = 10 * time.Minute
+)
+
+// reputation is the score of the data delivered by a peer, decaying
+// toward zero over time.
+type reputation struct {
+	l     sync.Mutex
+	score float64
+	at    time.Time
+}
+
+// add adds delta to the score at now.
+func (r *reputation) add(now time.Time, delta float64) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.score = min(r.decayed(now)+delta, maxReputation)
+	r.at = now
+}
+
+// value returns the score at now.
+func (r *reputation) value(now time.Time) float64 {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.decayed(now)
+}
+
+func (r *reputation) decayed(now time.Time) float64 {
+	if r.score == 0 || !now.After(r.at) {
+		return r.score
+	}
+	return r.score * math.Exp2(-float64(now.Sub(r.at))/float64(reputationHalfLife))
+}
+
+// reputationWeight returns the factor the weight of a candidate of the
+// given reputation is multiplied with, which is 1 unless it is negative.
+func reputationWeight(reputation float64) float64 {
+	if reputation >= 0 {
+		return 1
+	}
+	return math.Exp(reputation / reputationScale)
+}
+
+// peerReputation returns the reputation of the peer at addr.
+func (t *TorrentSession) peerReputation(addr string) float64 {
+	s, ok := t.peers.stats.Load(addr)
+	if !ok {
+		return 0
+	}
+	return s.(*peerStats).reputation.value(t.now())
+}
+
+// suspectBlock is a block of an attempt of a piece that failed
+// verification, kept to tell whether the peer that delivered it
+// corrupted it once the piece verifies.
+type suspectBlock struct {
+	attempt int
+	begin   uint32
+	length  uint32
+	digest  [sha1.Size]byte
+	from    string
+	fromID  string
+}
+
+// suspectContributors lowers the reputation of the peers that delivered
+// the blocks of the piece failing verification and keeps the digests of
+// the blocks. The lock of the piece must be held.
+func (t *TorrentSession) suspectContributors(piece *pendingPiece) {
+	now := t.now()
+	var suspected []string
+	for _, b := range piece.Received {
+		piece.suspects = append(piece.suspects, suspectBlock{
+			attempt: piece.Attempt,
+			begin:   b.Begin,
+			length:  uint32(len(b.Block)),
+			digest:  sha1.Sum(b.Block),
+			from:    b.from,
+			fromID:  b.fromID,
+		})
+		if !slices.Contains(suspected, b.from) {
+			suspected = append(suspected, b.from)
+			t.statsFor(b.from).reputation.add(now, -reputationSuspect)
+		}
+	}
+}
+
+// attributeCorruption credits the peers that delivered the blocks of the
+// verified piece, and tells the peers that delivered corrupt blocks in
+// its failed attempts from those that did not by the data of the piece.
+// The former are penalized, the latter get their reputation back and
+// their strike withdrawn, see banContributors. The lock of the piece
+// must be held.
+func (t *TorrentSession) attributeCorruption(logger *slog.Logger, piece *pendingPiece, data []byte) {
+	now := t.now()
+	for _, b := range piece.Received {
+		t.statsFor(b.from).reputation.add(now, reputationGood)
+	}
+
+	// the contributions of the failed attempts, by address and by stable
+	// peer id, as strikes are counted for both, are corrupt if any block is.
+	type contribution struct {
+		attempt int
+		key     string
+		id      bool
+	}
+	corrupt := make(map[contribution]bool)
+	for _, b := range piece.suspects {
+		end := int64(b.begin) + int64(b.length)
+		bad := end > int64(len(data)) || sha1.Sum(data[b.begin:end]) != b.digest
+		if bad {
+			logger.Warn("peer delivered corrupt block", slog.String("end_peer", b.from), slog.Int("attempt", b.attempt))
+			t.statsFor(b.from).reputation.add(now, -reputationCorrupt)
+		}
+		c := contribution{attempt: b.attempt, key: b.from}
+		corrupt[c] = corrupt[c] || bad
+		if stablePeerID(b.fromID) {
+			c := contribution{attempt: b.attempt, key: b.fromID, id: true}
+			corrupt[c] = corrupt[c] || bad
+		}
+	}
+	for c, bad := range corrupt {
+		if bad {
+			continue
+		}
+		strikes := &t.peers.strikes
+		if c.id {
+			strikes = &t.peers.idStrikes
+		} else {
+			t.statsFor(c.key).reputation.add(now, reputationSuspect)
+		}
+		if s, ok := strikes.Load(c.key); ok {
+			s.(*atomic.Int64).Add(-1)
+		}
+	}
+	piece.suspects = nil
+}
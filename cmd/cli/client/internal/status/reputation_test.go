@@ -0,0 +1,199 @@
+package status
+
+import (
+	"bytes"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReputation(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var r reputation
+	r.add(now, -reputationCorrupt)
+	assert.Equal(t, float64(-reputationCorrupt), r.value(now))
+	assert.InDelta(t, -reputationCorrupt/2, r.value(now.Add(reputationHalfLife)), 1e-9)
+	assert.InDelta(t, -reputationCorrupt/4, r.value(now.Add(2*reputationHalfLife)), 1e-9)
+
+	// the credit of a peer is bounded.
+	for range 2 * maxReputation {
+		r.add(now, reputationGood)
+	}
+	assert.Equal(t, float64(maxReputation), r.value(now))
+
+	assert.Equal(t, 1.0, reputationWeight(maxReputation))
+	assert.Equal(t, 1.0, reputationWeight(0))
+	assert.InDelta(t, 1/math.E, reputationWeight(-reputationScale), 1e-9)
+}
+
+func TestPickers_Reputation(t *testing.T) {
+	const n = 10000
+	candidates := []PeerCandidate{
+		{Addr: "honest", Rate: 1 << 20},
+		{Addr: "corrupt", Rate: 1 << 20, Suggested: true, Reputation: -reputationCorrupt},
+	}
+	for _, picker := range []PeerPicker{RatePicker{}, RandomPicker{}} {
+		var picks [2]int
+		for range n {
+			picks[picker.Pick(candidates)]++
+		}
+		share := reputationWeight(-reputationCorrupt) / (1 + reputationWeight(-reputationCorrupt))
+		assert.InDelta(t, share, float64(picks[1])/n, 0.01, "%T", picker)
+	}
+}
+
+func TestTracker_AttributeCorruption(t *testing.T) {
+	good := bytes.Repeat([]byte{0xAB}, 2*messagesv1.RequestSize)
+	tr := newTestTracker(t, int64(len(good)), good)
+
+	failed := make(chan PieceHashFailed, 1)
+	tr.Subscribe(func(e Event) {
+		if e, ok := e.(PieceHashFailed); ok {
+			failed <- e
+		}
+	})
+
+	tr.download.active.add(&pendingPiece{
+		Index:   0,
+		Attempt: 1,
+		Size:    int64(len(good)),
+		InFlight: []*timedDownloadRequest{
+			{request: messagesv1.Request{Index: 0, Begin: 0, Length: messagesv1.RequestSize}},
+			{request: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}},
+		},
+	})
+
+	const (
+		honest, corrupt     = "10.0.0.1:6881", "10.0.0.2:6881"
+		honestID, corruptID = "-TT0100-honestpeer00", "-TT0100-corruptpeer0"
+	)
+	a, b := make(chan *messagesv1.Piece), make(chan *messagesv1.Piece)
+	tr.spawnReceiver(tr.logger, honest, honestID, a, nil)
+	tr.spawnReceiver(tr.logger, corrupt, corruptID, b, nil)
+
+	b <- &messagesv1.Piece{Index: 0, Begin: messagesv1.RequestSize, Block: bytes.Repeat([]byte{0xCD}, messagesv1.RequestSize)}
+	a <- &messagesv1.Piece{Index: 0, Begin: 0, Block: good[:messagesv1.RequestSize]}
+	<-failed
+
+	// both contributors are suspected until the piece verifies.
+	assert.InDelta(t, -reputationSuspect, tr.peerReputation(honest), 1e-3)
+	assert.InDelta(t, -reputationSuspect, tr.peerReputation(corrupt), 1e-3)
+
+	p := tr.download.active.get(0)
+	p.l.Lock()
+	for _, r := range p.Pending {
+		p.InFlight = append(p.InFlight, &timedDownloadRequest{request: *r})
+	}
+	p.Pending = nil
+	p.l.Unlock()
+
+	// the corrupt peer delivers the honest peer's block this time.
+	b <- &messagesv1.Piece{Index: 0, Begin: 0, Block: good[:messagesv1.RequestSize]}
+	a <- &messagesv1.Piece{Index: 0, Begin: messagesv1.RequestSize, Block: good[messagesv1.RequestSize:]}
+	close(a)
+	close(b)
+	tr.download.wg.Wait()
+	assert.True(t, tr.have.Check(0))
+
+	assert.InDelta(t, reputationGood, tr.peerReputation(honest), 1e-3)
+	assert.InDelta(t, -reputationSuspect-reputationCorrupt+reputationGood, tr.peerReputation(corrupt), 1e-3)
+
+	// the strikes of the honest peer are withdrawn.
+	strikes := func(m interface{ Load(any) (any, bool) }, key string) int64 {
+		s, ok := m.Load(key)
+		assert.True(t, ok)
+		return s.(*atomic.Int64).Load()
+	}
+	assert.Zero(t, strikes(&tr.peers.strikes, honest))
+	assert.Zero(t, strikes(&tr.peers.idStrikes, honestID))
+	assert.Equal(t, int64(1), strikes(&tr.peers.strikes, corrupt))
+	assert.Equal(t, int64(1), strikes(&tr.peers.idStrikes, corruptID))
+}
+
+func TestTracker_ReputationStarvesCorruptPeer(t *testing.T) {
+	const (
+		pieceLength = 4 * messagesv1.RequestSize
+		numPieces   = 64
+	)
+	data := make([]byte, numPieces*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	var pieces [][]byte
+	for i := range numPieces {
+		pieces = append(pieces, data[i*pieceLength:(i+1)*pieceLength])
+	}
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	// few pieces in flight, so that most are requested after the first attribution.
+	tr.download.active.setMax(8)
+
+	// one of the seeders corrupts every tenth block it serves.
+	var served atomic.Int64
+	corrupt := newStubSeeder(t, pieceLength, data, 0, true, func(s *stubSeeder) {
+		s.corrupt = func(messagesv1.Request) bool { return served.Add(1)%10 == 0 }
+	})
+	seeders := []*stubSeeder{corrupt, newStubSeeder(t, pieceLength, data, 0, true), newStubSeeder(t, pieceLength, data, 0, true)}
+	for _, s := range seeders {
+		tr.download.wg.Add(1)
+		go tr.keepAliveSeeders(s.addr)
+	}
+	assert.Eventually(t, func() bool {
+		connected := 0
+		tr.peers.seeders.Range(func(_, value any) bool {
+			if value.(*peer.Peer).Bitfield.Check(0) {
+				connected++
+			}
+			return true
+		})
+		return connected == len(seeders)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	// the requests sent once the first corrupt block was attributed.
+	requests := func() (total, toCorrupt int) {
+		for _, s := range seeders {
+			total += len(s.received())
+		}
+		return total, len(corrupt.received())
+	}
+	attributed := make(chan [2]int, 1)
+	go func() {
+		for tr.peerReputation(corrupt.addr) > -reputationCorrupt/2 {
+			select {
+			case <-tr.WaitUntilDownloaded():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		total, toCorrupt := requests()
+		attributed <- [2]int{total, toCorrupt}
+	}()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(20 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.CancelDownload()
+
+	var before [2]int
+	select {
+	case before = <-attributed:
+	default:
+		t.Fatal("no corrupt block was attributed to the corrupt seeder")
+	}
+	total, toCorrupt := requests()
+	total, toCorrupt = total-before[0], toCorrupt-before[1]
+	assert.Greater(t, total, numPieces, "most of the blocks were requested afterwards")
+	assert.Less(t, float64(toCorrupt)/float64(total), 0.05, "the corrupt seeder is starved")
+	assert.Empty(t, tr.have.MissingPieces())
+}
@@ -0,0 +1,261 @@
+package status
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Despire/tinytorrent/cmd/cli/internal/atomicfile"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+const (
+	// resumeFile is the name of the file inside the download
+	// directory that holds the state needed to resume a torrent.
+	resumeFile = "resume.json"
+	// legacyBitfieldFile is the name of the file in which only the
+	// bitfield was persisted by previous versions.
+	legacyBitfieldFile = "bitfield.bin"
+)
+
+// resume is the state persisted across restarts of the client.
+type resume struct {
+	Bitfield           []byte `json:"bitfield"`
+	CompletedAnnounced bool   `json:"completedAnnounced"`
+	Paused             bool   `json:"paused,omitempty"`
+	// Recheck is the number of pieces verified by an interrupted
+	// recheck, which the next one continues from, see Recheck.
+	Recheck int64 `json:"recheck,omitempty"`
+	// Pieces records the files of the downloaded pieces, only
+	// for torrents persisted in the default storage.
+	Pieces []pieceRecord `json:"pieces,omitempty"`
+	// Sources holds the PieceSource of each piece, pieces
+	// without a recorded source are considered pre-existing.
+	Sources []byte `json:"sources,omitempty"`
+	// Uploaded and Downloaded are the bytes transferred
+	// in all runs, see ShareStats.
+	Uploaded   int64 `json:"uploaded,omitempty"`
+	Downloaded int64 `json:"downloaded,omitempty"`
+}
+
+// pieceRecord is the metadata of the file of a downloaded piece,
+// used to detect pieces that changed on disk, see RestoreResume.
+type pieceRecord struct {
+	Index   int64 `json:"index"`
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"`
+}
+
+// matches reports whether the file described by fi is the one
+// recorded. Modification times are compared with a resolution of
+// a second, as copies across file systems may lose the rest.
+func (r pieceRecord) matches(fi os.FileInfo) bool {
+	return r.Size == fi.Size() && r.ModTime == fi.ModTime().Unix()
+}
+
+func newPieceRecord(idx int64, fi os.FileInfo) pieceRecord {
+	return pieceRecord{Index: idx, Size: fi.Size(), ModTime: fi.ModTime().Unix()}
+}
+
+// loadResume reads the persisted resume data from the download directory.
+// If no resume data exists a nil value is returned.
+func (t *TorrentSession) loadResume() (*resume, error) {
+	b, err := os.ReadFile(filepath.Join(t.DownloadDir(), resumeFile))
+	if err == nil {
+		r := new(resume)
+		if err := json.Unmarshal(b, r); err != nil {
+			return nil, fmt.Errorf("failed to decode resume file: %w", err)
+		}
+		if len(r.Bitfield) != t.have.Len() {
+			return nil, fmt.Errorf("resume file bitfield has length %v, expected %v", len(r.Bitfield), t.have.Len())
+		}
+		return r, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read resume file: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(t.DownloadDir(), legacyBitfieldFile))
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	r := &resume{Bitfield: make([]byte, t.have.Len())}
+	if err := binary.Read(f, binary.LittleEndian, &r.Bitfield); err != nil {
+		return nil, fmt.Errorf("failed to read existing bitfield file: %w", err)
+	}
+	return r, nil
+}
+
+// saveResume persists the current resume data to the download directory.
+func (t *TorrentSession) saveResume() error {
+	t.durability.l.Lock()
+	defer t.durability.l.Unlock()
+
+	if _, err := os.Stat(t.DownloadDir()); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(t.DownloadDir(), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	pending := t.durability.unsynced.Load()
+	b, err := t.resumeData()
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.WriteFile(filepath.Join(t.DownloadDir(), resumeFile), b, 0o644); err != nil {
+		return fmt.Errorf("failed to write resume file: %w", err)
+	}
+	t.durability.writes.Add(1)
+	if pending > 1 {
+		t.durability.coalesced.Add(pending - 1)
+	}
+	return nil
+}
+
+// ResumeData returns the current resume data of the torrent, as
+// persisted in its download directory, see RestoreResume.
+func (t *TorrentSession) ResumeData() ([]byte, error) {
+	t.durability.l.Lock()
+	defer t.durability.l.Unlock()
+	return t.resumeData()
+}
+
+// resumeData syncs the flushed pieces and returns the resume data, which
+// only includes the pieces that were synced. The durability lock must be held.
+func (t *TorrentSession) resumeData() ([]byte, error) {
+	if err := t.syncPieces(); err != nil {
+		t.logger.Error("failed to sync pieces, persisting only the ones synced before", slog.Any("err", err))
+	}
+
+	share := t.ShareStats()
+	r := &resume{
+		Bitfield:           t.durability.durable.Clone(),
+		CompletedAnnounced: t.completedAnnounced.Load(),
+		Paused:             t.paused.Load(),
+		Recheck:            t.check.pending.Load(),
+		Uploaded:           share.Uploaded,
+		Downloaded:         share.Downloaded,
+	}
+	for i, src := range t.PieceSources() {
+		if src != PieceSourceNone && t.durability.durable.Check(int64(i)) {
+			if r.Sources == nil {
+				r.Sources = make([]byte, t.meta.NumPieces())
+			}
+			r.Sources[i] = byte(src)
+		}
+	}
+	if t.files != nil {
+		for _, i := range t.durability.durable.ExistingPieces() {
+			fi, err := t.files.Stat(i)
+			if err != nil {
+				// recorded as missing, which forces a recheck once restored.
+				continue
+			}
+			r.Pieces = append(r.Pieces, newPieceRecord(i, fi))
+		}
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resume data: %w", err)
+	}
+	return b, nil
+}
+
+// RestoreStats is the outcome of restoring resume data, see RestoreResume.
+type RestoreStats struct {
+	// Pieces is the number of downloaded pieces in the resume data.
+	Pieces int64 `json:"pieces"`
+	// Rechecked is the number of pieces whose file did not match
+	// the resume data, and which were verified again.
+	Rechecked int64 `json:"rechecked"`
+	// Invalid is the number of rechecked pieces that failed the
+	// verification and are downloaded again.
+	Invalid int64 `json:"invalid"`
+}
+
+// RestoreResume writes the resume data of t, returned by ResumeData of
+// another client, into the download directory dir which holds the pieces
+// of the torrent, so that a TorrentSession created for dir resumes from it. The
+// pieces whose files do not match the metadata recorded in the resume
+// data are verified again, and dropped if they fail.
+func RestoreResume(data []byte, t *torrent.MetaInfoFile, dir string) (RestoreStats, error) {
+	r := new(resume)
+	if err := json.Unmarshal(data, r); err != nil {
+		return RestoreStats{}, fmt.Errorf("failed to decode resume data: %w", err)
+	}
+
+	have := bitfield.NewBitfield(t.NumPieces())
+	if len(r.Bitfield) != have.Len() {
+		return RestoreStats{}, fmt.Errorf("resume data bitfield has length %v, expected %v", len(r.Bitfield), have.Len())
+	}
+	have.Overwrite(r.Bitfield)
+
+	records := make(map[int64]pieceRecord, len(r.Pieces))
+	for _, p := range r.Pieces {
+		records[p.Index] = p
+	}
+
+	var stats RestoreStats
+	files := storage.NewPieceFiles(dir)
+	restored := bitfield.NewBitfield(t.NumPieces())
+	r.Pieces = nil
+	for _, i := range have.ExistingPieces() {
+		stats.Pieces++
+
+		fi, err := files.Stat(i)
+		if rec, ok := records[i]; err == nil && ok && rec.matches(fi) {
+			restored.Set(i)
+			r.Pieces = append(r.Pieces, rec)
+			continue
+		}
+
+		stats.Rechecked++
+		if err != nil || !verifyPieceFile(t, files, i, fi.Size()) {
+			stats.Invalid++
+			if len(r.Sources) == int(t.NumPieces()) {
+				r.Sources[i] = byte(PieceSourceNone)
+			}
+			continue
+		}
+		restored.Set(i)
+		r.Pieces = append(r.Pieces, newPieceRecord(i, fi))
+	}
+	r.Bitfield = restored.Clone()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return RestoreStats{}, fmt.Errorf("failed to encode resume data: %w", err)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return RestoreStats{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, resumeFile), b, 0o644); err != nil {
+		return RestoreStats{}, fmt.Errorf("failed to write resume file: %w", err)
+	}
+	return stats, nil
+}
+
+// verifyPieceFile reports whether the file of the piece holds its data.
+func verifyPieceFile(t *torrent.MetaInfoFile, files *storage.PieceFiles, piece, size int64) bool {
+	if size != t.PieceSize(piece) {
+		return false
+	}
+	b, err := files.ReadBlock(piece, 0, uint32(size))
+	if err != nil {
+		return false
+	}
+	hash := sha1.Sum(b)
+	return bytes.Equal(hash[:], t.PieceHash(piece))
+}
@@ -0,0 +1,190 @@
+package status
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Priority weighs the share of a torrent in the resources of a Coordinator.
+type Priority int
+
+const (
+	PriorityLow    Priority = 1
+	PriorityNormal Priority = 2
+	PriorityHigh   Priority = 4
+)
+
+// Coordinator shares the resources of a client among the torrents that
+// are downloading, in proportion to their priorities: the piece data
+// downloaded concurrently, from which the active pieces of each torrent
+// are derived, and the connections of a ConnLimit. At most maxActive
+// torrents download at a time, and only as many as the estimated memory
+// of which fits into the memory budget, see TorrentCost. The others are
+// queued until one of them stops downloading.
+type Coordinator struct {
+	l     sync.Mutex
+	conns *ConnLimit
+	// outstanding is the piece data downloaded concurrently
+	// by all torrents, see targetOutstandingBytes.
+	outstanding int64
+	// maxActive is the number of torrents downloading at a
+	// time, a non-positive value does not bound them.
+	maxActive int
+	// memory is the bytes the torrents downloading may hold, in addition
+	// to the outstanding piece data, a non-positive value does not bound
+	// them. reserved is the estimated memory of the active torrents.
+	memory   int64
+	reserved int64
+	// active holds the estimated memory of each torrent downloading.
+	active map[*TorrentSession]int64
+	// queue holds the torrents waiting for a slot, in the order
+	// they were queued. Torrents of higher priority go first.
+	queue []*TorrentSession
+}
+
+// NewCoordinator returns a coordinator sharing the connections of conns,
+// if not nil, that lets at most maxActive torrents download at a time,
+// whose estimated memory together fits into memory bytes, if positive.
+// A single torrent always downloads, even if it exceeds the memory.
+func NewCoordinator(conns *ConnLimit, maxActive int, memory int64) *Coordinator {
+	return &Coordinator{
+		conns:       conns,
+		outstanding: targetOutstandingBytes,
+		maxActive:   max(maxActive, 0),
+		memory:      max(memory, 0),
+		active:      make(map[*TorrentSession]int64),
+	}
+}
+
+// MemoryStats returns the estimated memory of the torrents downloading
+// and the budget, which is 0 if the memory is unbounded.
+func (c *Coordinator) MemoryStats() (reserved, budget int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.reserved, c.memory
+}
+
+// blocked returns why t cannot start downloading, or an
+// empty string if it can. The lock must be held.
+func (c *Coordinator) blocked(t *TorrentSession, cost int64) string {
+	if c.maxActive > 0 && len(c.active) >= c.maxActive {
+		return fmt.Sprintf("waiting for one of %d active torrents to finish downloading", len(c.active))
+	}
+	if c.memory > 0 && len(c.active) > 0 && c.outstanding+c.reserved+cost > c.memory {
+		return fmt.Sprintf("estimated memory of %s exceeds the %s left of the memory budget of %s",
+			formatMemory(cost), formatMemory(max(c.memory-c.outstanding-c.reserved, 0)), formatMemory(c.memory),
+		)
+	}
+	return ""
+}
+
+// activate lets t download, with the estimated memory cost. The lock must be held.
+func (c *Coordinator) activate(t *TorrentSession, cost int64) {
+	c.active[t] = cost
+	c.reserved += cost
+}
+
+// admit reports whether t may start downloading. Otherwise
+// t is queued and started by leave once a slot frees.
+func (c *Coordinator) admit(t *TorrentSession) bool {
+	if c == nil {
+		return true
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if _, ok := c.active[t]; ok {
+		return true
+	}
+	cost := t.Cost().Memory()
+	if reason := c.blocked(t, cost); reason != "" {
+		if !slices.Contains(c.queue, t) {
+			c.queue = append(c.queue, t)
+		}
+		t.queue(reason)
+		return false
+	}
+	c.activate(t, cost)
+	c.rebalance()
+	return true
+}
+
+// leave removes t once it stopped downloading, or from the queue, and
+// starts the queued torrents that fit into the freed slots. It must be
+// called before the download goroutines of t are waited for.
+func (c *Coordinator) leave(t *TorrentSession) {
+	if c == nil {
+		return
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.queue = slices.DeleteFunc(c.queue, func(o *TorrentSession) bool { return o == t })
+	t.queued.Store(false)
+	cost, ok := c.active[t]
+	if !ok {
+		return
+	}
+	delete(c.active, t)
+	c.reserved -= cost
+	t.download.connShare.Store(0)
+
+	// the queued torrents are started by priority. One that does not fit
+	// is not skipped, so that large torrents are not starved by small ones.
+	for len(c.queue) > 0 {
+		next := 0
+		for i, o := range c.queue {
+			if o.priority > c.queue[next].priority {
+				next = i
+			}
+		}
+		tr := c.queue[next]
+		cost := tr.Cost().Memory()
+		if reason := c.blocked(tr, cost); reason != "" {
+			tr.queue(reason)
+			break
+		}
+		c.queue = slices.Delete(c.queue, next, next+1)
+		c.activate(tr, cost)
+		tr.dequeue()
+	}
+	c.rebalance()
+}
+
+// reshare derives the active pieces of the torrents downloading from their
+// share again, e.g. once a torrent no longer sets its own, see rebalance.
+func (c *Coordinator) reshare() {
+	if c == nil {
+		return
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.rebalance()
+}
+
+// rebalance updates the active pieces and connections of the torrents
+// downloading to their share. The lock must be held.
+func (c *Coordinator) rebalance() {
+	var total Priority
+	for t := range c.active {
+		total += t.priority
+	}
+	var conns int
+	if c.conns != nil {
+		_, conns = c.conns.Stats()
+	}
+
+	for t := range c.active {
+		share := float64(t.priority) / float64(total)
+		if t.download.maxActive.Load() <= 0 {
+			t.download.active.setMax(activePieces(int64(share*float64(c.outstanding)), t.meta.PieceLength))
+		}
+		if conns > 0 {
+			t.download.connShare.Store(int64(max(share*float64(conns), 1)))
+		}
+	}
+}
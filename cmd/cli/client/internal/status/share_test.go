@@ -0,0 +1,61 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinator(t *testing.T) {
+	const pieceLength = 1024 * 1024
+	c := NewCoordinator(NewConnLimit(30), 2, 0)
+
+	newTracker := func(p Priority) *TorrentSession {
+		tr := newTestTracker(t, pieceLength, []byte{0x1})
+		WithCoordinator(c, p)(tr)
+		t.Cleanup(tr.CancelDownload)
+		return tr
+	}
+	high, low, queued, urgent := newTracker(PriorityHigh), newTracker(PriorityLow), newTracker(PriorityNormal), newTracker(PriorityHigh)
+
+	assert.True(t, c.admit(high))
+	assert.True(t, c.admit(low))
+	assert.False(t, c.admit(queued))
+	assert.False(t, c.admit(urgent))
+	assert.True(t, queued.Queued())
+	assert.True(t, urgent.Queued())
+
+	// the shares are weighted by priority, 4:1.
+	assert.Equal(t, 52, high.download.active.limit())
+	assert.Equal(t, 13, low.download.active.limit())
+	assert.Equal(t, int64(24), high.download.connShare.Load())
+	assert.Equal(t, int64(6), low.download.connShare.Load())
+
+	// the queued torrent of higher priority is started first.
+	c.leave(high)
+	assert.False(t, urgent.Queued())
+	assert.True(t, queued.Queued())
+	assert.Equal(t, int64(24), urgent.download.connShare.Load())
+
+	// paused torrents leave the queue.
+	assert.NoError(t, queued.Pause())
+	assert.False(t, queued.Queued())
+	c.leave(low)
+	assert.Equal(t, int64(30), urgent.download.connShare.Load())
+	assert.Equal(t, defaultActivePieces(pieceLength), urgent.download.active.limit())
+}
+
+func TestTracker_ConnShare(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1})
+	tr.conns = NewConnLimit(3)
+	tr.download.connShare.Store(2)
+
+	assert.True(t, tr.acquireConn())
+	assert.True(t, tr.acquireConn())
+	assert.False(t, tr.acquireConn(), "share of the torrent exceeded")
+	tr.releaseConn()
+	assert.True(t, tr.acquireConn())
+
+	used, _ := tr.conns.Stats()
+	assert.Equal(t, 2, used)
+}
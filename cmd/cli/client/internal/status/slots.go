@@ -0,0 +1,371 @@
+package status
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// targetOutstandingBytes is the amount of piece data downloaded
+	// concurrently by default, from which the number of active pieces
+	// is derived.
+	targetOutstandingBytes = 64 * 1024 * 1024
+	// minActivePieces and maxActivePieces bound the default number
+	// of concurrently downloaded pieces.
+	minActivePieces = 4
+	maxActivePieces = 256
+)
+
+// defaultActivePieces returns the number of pieces concurrently
+// downloaded for torrents with the given piece length.
+func defaultActivePieces(pieceLength int64) int {
+	return activePieces(targetOutstandingBytes, pieceLength)
+}
+
+// activePieces returns the number of pieces of the given length
+// needed to download the outstanding bytes concurrently.
+func activePieces(outstanding, pieceLength int64) int {
+	if pieceLength <= 0 {
+		return minActivePieces
+	}
+	n := (outstanding + pieceLength - 1) / pieceLength
+	return int(min(max(n, minActivePieces), maxActivePieces))
+}
+
+// pieceState is the scheduling state of a piece.
+type pieceState uint8
+
+const (
+	// pieceUnscheduled pieces are not downloaded and can be added to a slot.
+	pieceUnscheduled pieceState = iota
+	// pieceActive pieces are downloaded by the pendingPiece holding their slot.
+	pieceActive
+	// pieceVerified pieces were downloaded and verified, they are never added again.
+	pieceVerified
+	// pieceAbandoned pieces are not downloaded until they are reclaimed.
+	pieceAbandoned
+)
+
+// pieceSlots holds the pieces that are concurrently downloaded,
+// keyed by the piece index. The number of slots can be changed
+// at any time, pieces in excess are not evicted but no new ones
+// are added until enough of them finish.
+//
+// The scheduling state of every piece changes only under the lock, so
+// that a piece is downloaded by a single slot at a time, and no longer
+// once it was verified, whichever path attempts to add it.
+type pieceSlots struct {
+	l      sync.Mutex
+	max    int
+	pieces map[int64]*pendingPiece
+	// states holds the state of the pieces, sparseStates unless set.
+	states pieceStates
+	// n is the number of pieces of the torrent, counts the number of
+	// pieces in each state other than pieceUnscheduled.
+	n      int64
+	counts [pieceAbandoned + 1]int64
+}
+
+// reset marks the passed pieces out of n as verified and all others,
+// except the abandoned ones, as unscheduled. It is called while no
+// piece is downloaded, before the download starts, as the verified
+// pieces may have changed.
+func (s *pieceSlots) reset(n int64, verified []int64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.n = n
+	var unscheduled []int64
+	s.table().each(func(i int64, state pieceState) {
+		if state != pieceAbandoned {
+			unscheduled = append(unscheduled, i)
+		}
+	})
+	for _, i := range unscheduled {
+		s.set(i, pieceUnscheduled)
+	}
+	for _, i := range verified {
+		s.set(i, pieceVerified)
+	}
+	for i := range s.pieces {
+		s.set(i, pieceActive)
+	}
+}
+
+// table returns the states of the pieces, allocated on first use.
+func (s *pieceSlots) table() pieceStates {
+	if s.states == nil {
+		s.states = make(sparseStates)
+	}
+	return s.states
+}
+
+// close releases the states of the pieces, once the download stopped
+// for good, see tableStates.
+func (s *pieceSlots) close() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if table, ok := s.states.(*tableStates); ok {
+		table.close()
+	}
+}
+
+// set changes the state of the piece at index and counts it.
+// The caller must hold the lock.
+func (s *pieceSlots) set(index int64, state pieceState) {
+	states := s.table()
+	s.counts[states.get(index)]--
+	s.counts[state]++
+	states.set(index, state)
+}
+
+// remaining returns the number of pieces that are neither
+// verified nor downloaded, including the abandoned ones.
+func (s *pieceSlots) remaining() int64 {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.n - s.counts[pieceVerified] - s.counts[pieceActive]
+}
+
+// unscheduled yields the unscheduled pieces, starting with the one
+// at index from and wrapping around, so that the scheduler does not
+// favour the first pieces. The state of each piece is checked as it
+// is yielded.
+func (s *pieceSlots) unscheduled(from int64) iter.Seq[int64] {
+	return func(yield func(int64) bool) {
+		s.l.Lock()
+		n := s.n
+		s.l.Unlock()
+		for k := range n {
+			i := (from + k) % n
+			if s.state(i) != pieceUnscheduled {
+				continue
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+// settle marks the unscheduled piece at index as verified, as it was
+// verified without being downloaded by a slot, e.g. by a recheck.
+func (s *pieceSlots) settle(index int64) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.table().get(index) != pieceUnscheduled {
+		return false
+	}
+	s.set(index, pieceVerified)
+	return true
+}
+
+// state returns the scheduling state of the piece with the given index.
+func (s *pieceSlots) state(index int64) pieceState {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.table().get(index)
+}
+
+func (s *pieceSlots) setMax(n int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.max = max(n, 1)
+}
+
+// limit returns the maximum number of concurrently downloaded pieces.
+func (s *pieceSlots) limit() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.max
+}
+
+func (s *pieceSlots) len() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return len(s.pieces)
+}
+
+// full reports whether no more pieces can be added.
+func (s *pieceSlots) full() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return len(s.pieces) >= s.max
+}
+
+// add stores the piece if there is a free slot and the
+// piece is unscheduled, and marks it as active.
+func (s *pieceSlots) add(p *pendingPiece) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if len(s.pieces) >= s.max {
+		return false
+	}
+	if s.table().get(p.Index) != pieceUnscheduled {
+		return false
+	}
+	if s.pieces == nil {
+		s.pieces = make(map[int64]*pendingPiece)
+	}
+	s.pieces[p.Index] = p
+	s.set(p.Index, pieceActive)
+	return true
+}
+
+// get returns the piece with the given index, if it is downloaded.
+func (s *pieceSlots) get(index int64) *pendingPiece {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.pieces[index]
+}
+
+// remove frees the slot of the piece, if it is still held
+// by p, and marks the piece as unscheduled again.
+func (s *pieceSlots) remove(p *pendingPiece) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.pieces[p.Index] != p {
+		return false
+	}
+	delete(s.pieces, p.Index)
+	s.set(p.Index, pieceUnscheduled)
+	return true
+}
+
+// verified frees the slot held by p and marks the piece as verified.
+// The caller must hold the lock of p and have checked that p still
+// holds the slot, as slots are only freed with the lock of the piece.
+func (s *pieceSlots) verified(p *pendingPiece) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.pieces[p.Index] != p {
+		panic(fmt.Sprintf("malformed state, verified piece %d does not hold its slot", p.Index))
+	}
+	delete(s.pieces, p.Index)
+	s.set(p.Index, pieceVerified)
+}
+
+// snapshot returns the downloaded pieces ordered by their index.
+func (s *pieceSlots) snapshot() []*pendingPiece {
+	s.l.Lock()
+	out := make([]*pendingPiece, 0, len(s.pieces))
+	for _, p := range s.pieces {
+		out = append(out, p)
+	}
+	s.l.Unlock()
+
+	slices.SortFunc(out, func(a, b *pendingPiece) int { return cmp.Compare(a.Index, b.Index) })
+	return out
+}
+
+// abandon marks the piece at index as abandoned. If p is set, it must
+// hold the slot of the piece, which is freed, else the piece must not
+// be downloaded. The caller must hold the lock of p.
+func (s *pieceSlots) abandon(index int64, p *pendingPiece) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	states := s.table()
+	switch state := states.get(index); {
+	case p != nil && s.pieces[index] != p:
+		return false
+	case p == nil && state != pieceUnscheduled && state != pieceAbandoned:
+		return false
+	}
+	if p != nil {
+		delete(s.pieces, index)
+	}
+	s.set(index, pieceAbandoned)
+	return true
+}
+
+// reclaim marks the abandoned piece at index as unscheduled again.
+func (s *pieceSlots) reclaim(index int64) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.table().get(index) != pieceAbandoned {
+		return false
+	}
+	s.set(index, pieceUnscheduled)
+	return true
+}
+
+// abandoned returns the abandoned pieces in ascending order.
+func (s *pieceSlots) abandoned() []int64 {
+	s.l.Lock()
+	var out []int64
+	s.table().each(func(i int64, state pieceState) {
+		if state == pieceAbandoned {
+			out = append(out, i)
+		}
+	})
+	s.l.Unlock()
+
+	slices.Sort(out)
+	return out
+}
+
+// SlotInfo is a snapshot of a piece being downloaded in a slot, for
+// debugging downloads that stall.
+type SlotInfo struct {
+	Piece   int64 `json:"piece"`
+	Attempt int   `json:"attempt"`
+	// Size is the length of the piece, Downloaded the bytes of the
+	// blocks received for the current attempt.
+	Size       int64 `json:"size"`
+	Downloaded int64 `json:"downloaded"`
+	// Pending is the number of blocks not requested, InFlight the
+	// number requested and not received yet, and Received the
+	// number received.
+	Pending  int `json:"pending"`
+	InFlight int `json:"inFlight"`
+	Received int `json:"received"`
+	// OldestInFlight is the time since the oldest block in flight was
+	// requested, zero if there is none. Encoded in nanoseconds.
+	OldestInFlight time.Duration `json:"oldestInFlight"`
+	// Peers are the addresses of the peers the blocks in flight were
+	// requested from, or that delivered the received ones, sorted.
+	Peers []string `json:"peers"`
+}
+
+// SlotSnapshot returns the pieces being downloaded, ordered by index.
+// It only reads the state of the slots.
+func (t *TorrentSession) SlotSnapshot() []SlotInfo {
+	now := t.now()
+	var out []SlotInfo
+	for _, p := range t.download.active.snapshot() {
+		p.l.Lock()
+		s := SlotInfo{
+			Piece:      p.Index,
+			Attempt:    p.Attempt,
+			Size:       p.Size,
+			Downloaded: p.Downloaded,
+			Received:   len(p.Received),
+			Peers:      []string{},
+		}
+		for _, r := range p.Pending {
+			if r != nil {
+				s.Pending++
+			}
+		}
+		for _, r := range p.InFlight {
+			if r == nil || r.received {
+				continue
+			}
+			s.InFlight++
+			s.OldestInFlight = max(s.OldestInFlight, now.Sub(r.send))
+			s.Peers = append(s.Peers, r.peers...)
+		}
+		for _, b := range p.Received {
+			s.Peers = append(s.Peers, b.from)
+		}
+		p.l.Unlock()
+
+		slices.Sort(s.Peers)
+		s.Peers = slices.Compact(s.Peers)
+		out = append(out, s)
+	}
+	return out
+}
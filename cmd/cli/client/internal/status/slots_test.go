@@ -0,0 +1,267 @@
+package status
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultActivePieces(t *testing.T) {
+	tests := []struct {
+		pieceLength int64
+		want        int
+	}{
+		{pieceLength: 0, want: minActivePieces},
+		{pieceLength: 16 * 1024, want: maxActivePieces},
+		{pieceLength: 256 * 1024, want: 256},
+		{pieceLength: 1024 * 1024, want: 64},
+		{pieceLength: 3 * 1024 * 1024, want: 22},
+		{pieceLength: 32 * 1024 * 1024, want: minActivePieces},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, defaultActivePieces(tt.pieceLength), "piece length %d", tt.pieceLength)
+	}
+}
+
+func TestPieceSlots(t *testing.T) {
+	var s pieceSlots
+	s.setMax(2)
+
+	a, b, c := &pendingPiece{Index: 1}, &pendingPiece{Index: 2}, &pendingPiece{Index: 3}
+	assert.True(t, s.add(b))
+	assert.False(t, s.add(&pendingPiece{Index: 2}), "piece is already downloaded")
+	assert.True(t, s.add(a))
+	assert.True(t, s.full())
+	assert.False(t, s.add(c))
+	assert.Equal(t, []*pendingPiece{a, b}, s.snapshot())
+
+	// shrinking keeps the pieces already downloaded.
+	s.setMax(1)
+	assert.Equal(t, 2, s.len())
+	assert.False(t, s.remove(&pendingPiece{Index: 1}), "slot is held by another piece")
+	assert.True(t, s.remove(a))
+	assert.True(t, s.full())
+	assert.True(t, s.remove(b))
+
+	s.setMax(3)
+	assert.True(t, s.add(c))
+	assert.Same(t, c, s.get(3))
+	assert.Nil(t, s.get(1))
+
+	// verified pieces are never added again.
+	assert.Equal(t, pieceActive, s.state(3))
+	s.verified(c)
+	assert.Equal(t, pieceVerified, s.state(3))
+	assert.False(t, s.add(&pendingPiece{Index: 3}))
+	assert.Panics(t, func() { s.verified(&pendingPiece{Index: 1}) }, "slot is not held")
+
+	// removed pieces are unscheduled again.
+	assert.Equal(t, pieceUnscheduled, s.state(1))
+	assert.True(t, s.add(a))
+
+	s.reset(5, []int64{1, 2})
+	assert.Equal(t, pieceActive, s.state(1), "piece is still downloaded")
+	assert.Equal(t, pieceVerified, s.state(2))
+	assert.Equal(t, pieceUnscheduled, s.state(3))
+	assert.Equal(t, int64(3), s.remaining())
+	assert.Equal(t, []int64{3, 4, 0}, slices.Collect(s.unscheduled(3)))
+
+	// pieces verified without a slot are no longer scheduled.
+	assert.True(t, s.settle(0))
+	assert.False(t, s.settle(1), "piece is downloaded")
+	assert.Equal(t, int64(2), s.remaining())
+	assert.Equal(t, []int64{3, 4}, slices.Collect(s.unscheduled(0)))
+}
+
+func TestPieceSlots_Abandon(t *testing.T) {
+	var s pieceSlots
+	s.setMax(2)
+
+	a := &pendingPiece{Index: 1}
+	assert.True(t, s.add(a))
+	assert.False(t, s.abandon(1, nil), "piece is downloaded")
+	assert.False(t, s.abandon(1, &pendingPiece{Index: 1}), "slot is held by another piece")
+	assert.True(t, s.abandon(1, a))
+	assert.Nil(t, s.get(1))
+	assert.True(t, s.abandon(2, nil))
+	assert.Equal(t, []int64{1, 2}, s.abandoned())
+
+	assert.False(t, s.add(&pendingPiece{Index: 1}))
+	s.reset(3, []int64{2})
+	assert.Equal(t, []int64{1}, s.abandoned(), "verified pieces are no longer abandoned")
+	// abandoned pieces remain to be downloaded, but are not scheduled.
+	assert.Equal(t, int64(2), s.remaining())
+	assert.Equal(t, []int64{0}, slices.Collect(s.unscheduled(0)))
+
+	assert.True(t, s.reclaim(1))
+	assert.False(t, s.reclaim(1))
+	assert.True(t, s.add(&pendingPiece{Index: 1}))
+}
+
+func TestPieceSlots_SingleSlotPerPiece(t *testing.T) {
+	const (
+		pieces  = 8
+		workers = 16
+		rounds  = 2000
+	)
+	var s pieceSlots
+	s.setMax(pieces)
+
+	var (
+		holders  [pieces]atomic.Int32
+		verified [pieces]atomic.Bool
+		wg       sync.WaitGroup
+	)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rounds {
+				p := &pendingPiece{Index: int64((w + r) % pieces)}
+				if !s.add(p) {
+					continue
+				}
+				assert.Equal(t, int32(1), holders[p.Index].Add(1), "piece %d held by two slots", p.Index)
+				assert.False(t, verified[p.Index].Load(), "verified piece %d added again", p.Index)
+
+				p.l.Lock()
+				holders[p.Index].Add(-1)
+				// about every tenth download is verified, the others are reset.
+				if r%10 == 0 {
+					verified[p.Index].Store(true)
+					s.verified(p)
+				} else {
+					assert.True(t, s.remove(p))
+				}
+				p.l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Zero(t, s.len())
+	for i := range int64(pieces) {
+		want := pieceUnscheduled
+		if verified[i].Load() {
+			want = pieceVerified
+		}
+		assert.Equal(t, want, s.state(i), "piece %d", i)
+	}
+}
+
+func TestTracker_SlotSnapshot(t *testing.T) {
+	const pieceLength = 4 * messagesv1.RequestSize
+	tr := newTestTracker(t, pieceLength, make([]byte, pieceLength), make([]byte, pieceLength), make([]byte, pieceLength))
+	clk := newFakeClock()
+	WithClock(clk)(tr)
+	assert.Empty(t, tr.SlotSnapshot())
+
+	request := func(index, block uint32) messagesv1.Request {
+		return messagesv1.Request{Index: index, Begin: block * messagesv1.RequestSize, Length: messagesv1.RequestSize}
+	}
+	sent := func(r messagesv1.Request, ago time.Duration, peers ...string) *timedDownloadRequest {
+		return &timedDownloadRequest{request: r, send: clk.Now().Add(-ago), peers: peers}
+	}
+	first, second := request(2, 0), request(2, 3)
+	// the second piece stalls with a block requested from two peers in the
+	// endgame, one answered, one sent already and one timed out.
+	tr.download.active.add(&pendingPiece{
+		Index:      2,
+		Attempt:    3,
+		Size:       pieceLength,
+		Downloaded: messagesv1.RequestSize,
+		Received: []*receivedBlock{{
+			Piece: &messagesv1.Piece{Index: 2, Begin: 0, Block: make([]byte, messagesv1.RequestSize)},
+			from:  "10.0.0.3:6881",
+		}},
+		Pending: []*messagesv1.Request{nil, &second},
+		InFlight: []*timedDownloadRequest{
+			{request: first, received: true, peers: []string{"10.0.0.3:6881"}},
+			sent(request(2, 1), 45*time.Second, "10.0.0.2:6881", "10.0.0.1:6881"),
+			sent(request(2, 2), 5*time.Second, "10.0.0.2:6881"),
+			nil,
+		},
+	})
+	// the first piece was just started.
+	started := &pendingPiece{Index: 0, Attempt: 1, Size: pieceLength}
+	for block := range uint32(4) {
+		r := request(0, block)
+		started.Pending = append(started.Pending, &r)
+	}
+	tr.download.active.add(started)
+
+	assert.Equal(t, []SlotInfo{
+		{Piece: 0, Attempt: 1, Size: pieceLength, Pending: 4, Peers: []string{}},
+		{
+			Piece:          2,
+			Attempt:        3,
+			Size:           pieceLength,
+			Downloaded:     messagesv1.RequestSize,
+			Pending:        1,
+			InFlight:       2,
+			Received:       1,
+			OldestInFlight: 45 * time.Second,
+			Peers:          []string{"10.0.0.1:6881", "10.0.0.2:6881", "10.0.0.3:6881"},
+		},
+	}, tr.SlotSnapshot())
+
+	// the snapshot does not change the slots.
+	clk.Advance(time.Second)
+	snapshot := tr.SlotSnapshot()
+	assert.Equal(t, 46*time.Second, snapshot[1].OldestInFlight)
+	assert.Len(t, tr.download.active.get(2).InFlight, 4)
+	assert.Equal(t, 2, tr.download.active.len())
+}
+
+func TestTracker_MaxActivePieces(t *testing.T) {
+	const numPieces = 8
+	data := make([]byte, numPieces*messagesv1.RequestSize)
+	var pieces [][]byte
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for i := range numPieces {
+		pieces = append(pieces, data[i*messagesv1.RequestSize:(i+1)*messagesv1.RequestSize])
+	}
+
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	WithMaxActivePieces(2)(tr)
+
+	seeder := newStubSeeder(t, messagesv1.RequestSize, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	peak := 0
+	timeout := time.After(5 * time.Second)
+loop:
+	for {
+		select {
+		case <-tr.WaitUntilDownloaded():
+			break loop
+		case <-timeout:
+			t.Fatal("torrent was not downloaded")
+		default:
+			peak = max(peak, tr.download.active.len())
+			time.Sleep(time.Millisecond)
+		}
+	}
+	tr.download.wg.Wait()
+
+	assert.LessOrEqual(t, peak, 2)
+	assert.Empty(t, tr.have.MissingPieces())
+	assert.Equal(t, 0, tr.download.active.len())
+}
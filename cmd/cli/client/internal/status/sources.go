@@ -0,0 +1,176 @@
+package status
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PieceSource is where the data of a verified piece was obtained from.
+// It is persisted with the resume data as a single byte per piece.
+type PieceSource uint8
+
+const (
+	// PieceSourceNone is the source of the pieces not verified yet.
+	PieceSourceNone PieceSource = iota
+	// PieceSourcePeers are the pieces downloaded from peers, even if
+	// some of their blocks were delivered by web seeds.
+	PieceSourcePeers
+	// PieceSourceLocal are the pieces that were found in storage, by a
+	// recheck or when resuming data that did not record their source.
+	PieceSourceLocal
+	// pieceSourceWebSeed is the source of the pieces downloaded from the
+	// first web seed, the following values are those of the others.
+	pieceSourceWebSeed
+)
+
+// webSeedSource returns the source of the pieces downloaded from the i-th
+// web seed of the torrent. Web seeds past the 253rd share its source.
+func webSeedSource(i int) PieceSource {
+	return PieceSource(min(int(pieceSourceWebSeed)+i, 0xff))
+}
+
+// WebSeed returns the index of the web seed in the url-list of the torrent,
+// counting only the supported ones, if the piece was downloaded from it.
+func (s PieceSource) WebSeed() (int, bool) {
+	if s < pieceSourceWebSeed {
+		return 0, false
+	}
+	return int(s - pieceSourceWebSeed), true
+}
+
+func (s PieceSource) String() string {
+	switch s {
+	case PieceSourceNone:
+		return "none"
+	case PieceSourcePeers:
+		return "peers"
+	case PieceSourceLocal:
+		return "pre-existing"
+	}
+	i, _ := s.WebSeed()
+	return fmt.Sprintf("web seed %d", i)
+}
+
+// SourceStats are the bytes of the verified pieces by their source.
+type SourceStats struct {
+	Peers    int64
+	WebSeeds int64
+	Local    int64
+	// ByWebSeed splits WebSeeds by the URL of the web seed.
+	ByWebSeed map[string]int64
+}
+
+// Total returns the bytes of the verified pieces of all sources.
+func (s SourceStats) Total() int64 { return s.Peers + s.WebSeeds + s.Local }
+
+// String returns the share of each source, such as
+// "82% from peers, 11% web seeds, 7% pre-existing".
+func (s SourceStats) String() string {
+	total := s.Total()
+	if total == 0 {
+		return "no verified pieces"
+	}
+	var parts []string
+	for _, src := range []struct {
+		bytes int64
+		name  string
+	}{
+		{s.Peers, "from peers"},
+		{s.WebSeeds, "web seeds"},
+		{s.Local, "pre-existing"},
+	} {
+		if src.bytes > 0 {
+			parts = append(parts, fmt.Sprintf("%d%% %s", src.bytes*100/total, src.name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pieceSources holds the source of each piece of a torrent.
+type pieceSources struct {
+	l  sync.Mutex
+	of []PieceSource
+}
+
+// setSource records the source of the piece i, which was verified,
+// or PieceSourceNone if it was dropped.
+func (t *TorrentSession) setSource(i int64, s PieceSource) {
+	t.sources.l.Lock()
+	defer t.sources.l.Unlock()
+	if t.sources.of == nil {
+		t.sources.of = make([]PieceSource, t.meta.NumPieces())
+	}
+	t.sources.of[i] = s
+}
+
+// PieceSources returns the source of each piece of the torrent,
+// PieceSourceNone for the pieces that were not verified.
+func (t *TorrentSession) PieceSources() []PieceSource {
+	t.sources.l.Lock()
+	defer t.sources.l.Unlock()
+	if t.sources.of == nil {
+		return make([]PieceSource, t.meta.NumPieces())
+	}
+	return slices.Clone(t.sources.of)
+}
+
+// SourceStats returns the bytes of the verified pieces by their source.
+func (t *TorrentSession) SourceStats() SourceStats {
+	var s SourceStats
+	for i, src := range t.PieceSources() {
+		size := t.meta.PieceSize(int64(i))
+		switch w, ok := src.WebSeed(); {
+		case src == PieceSourcePeers:
+			s.Peers += size
+		case src == PieceSourceLocal:
+			s.Local += size
+		case ok:
+			s.WebSeeds += size
+			if s.ByWebSeed == nil {
+				s.ByWebSeed = make(map[string]int64)
+			}
+			var url string
+			if w < len(t.webSeeds) {
+				url = t.webSeeds[w].url
+			}
+			s.ByWebSeed[url] += size
+		}
+	}
+	return s
+}
+
+// pieceSource returns the source of the verified piece p, a web seed
+// if all its blocks were delivered by the same one. The piece lock
+// must be held.
+func (t *TorrentSession) pieceSource(p *pendingPiece) PieceSource {
+	if len(p.Received) == 0 {
+		return PieceSourcePeers
+	}
+	from := p.Received[0]
+	for _, b := range p.Received {
+		if b.fromID != webSeedID || b.from != from.from {
+			return PieceSourcePeers
+		}
+	}
+	i := slices.IndexFunc(t.webSeeds, func(w *webSeed) bool { return w.url == from.from })
+	if i < 0 {
+		return PieceSourcePeers
+	}
+	return webSeedSource(i)
+}
+
+// restoreSources sets the source of the pieces in have from the resume
+// data, or to PieceSourceLocal for those whose source was not recorded.
+func (t *TorrentSession) restoreSources(r *resume) {
+	t.sources.l.Lock()
+	defer t.sources.l.Unlock()
+	t.sources.of = make([]PieceSource, t.meta.NumPieces())
+	for _, i := range t.have.ExistingPieces() {
+		t.sources.of[i] = PieceSourceLocal
+		if len(r.Sources) == len(t.sources.of) && PieceSource(r.Sources[i]) != PieceSourceNone {
+			t.sources.of[i] = PieceSource(r.Sources[i])
+		}
+	}
+}
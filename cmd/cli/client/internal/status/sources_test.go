@@ -0,0 +1,109 @@
+package status
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_PieceSources(t *testing.T) {
+	const pieceLength = messagesv1.RequestSize
+	data := make([]byte, 4*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	var pieces [][]byte
+	for i := 0; i < len(data); i += pieceLength {
+		pieces = append(pieces, data[i:i+pieceLength])
+	}
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	// the first piece is already stored, the peer only has the two
+	// following ones and the last is only served by the web seed.
+	assert.NoError(t, tr.Flush(0, pieces[0]))
+	tr.paused.Store(true)
+	stats, err := tr.Recheck(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RecheckStats{Found: 1}, stats)
+	tr.paused.Store(false)
+
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "test.bin"), data, 0o644))
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	t.Cleanup(srv.Close)
+	w := newWebSeed(srv.URL+"/test.bin", srv.Client())
+	tr.webSeeds = append(tr.webSeeds, w)
+
+	seeder := newStubSeeder(t, pieceLength, data, 0, true, func(s *stubSeeder) { s.pieces = []int64{1, 2} })
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	// the web seed is only used for the pieces no connected peer has.
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(1) && v.(*peer.Peer).Status.Remote.Load() == uint32(peer.UnChoked)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(2)
+	go tr.runWebSeed(w)
+	go tr.downloadScheduler()
+	t.Cleanup(tr.CancelDownload)
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+
+	want := []PieceSource{PieceSourceLocal, PieceSourcePeers, PieceSourcePeers, webSeedSource(0)}
+	assert.Equal(t, want, tr.PieceSources())
+	assert.Equal(t, SourceStats{
+		Peers:     2 * pieceLength,
+		WebSeeds:  pieceLength,
+		Local:     pieceLength,
+		ByWebSeed: map[string]int64{w.url: pieceLength},
+	}, tr.SourceStats())
+	assert.Equal(t, "50% from peers, 25% web seeds, 25% pre-existing", tr.SourceStats().String())
+
+	// the sources are persisted with the resume data.
+	assert.NoError(t, tr.saveResume())
+	restored := newTestTracker(t, pieceLength, pieces...)
+	restored.setDownloadDir(tr.DownloadDir())
+	r, err := restored.loadResume()
+	if !assert.NoError(t, err) || !assert.NotNil(t, r) {
+		return
+	}
+	restored.have.Overwrite(r.Bitfield)
+	restored.restoreSources(r)
+	assert.Equal(t, want, restored.PieceSources())
+
+	// pieces resumed without a recorded source are pre-existing.
+	r.Sources = nil
+	restored.restoreSources(r)
+	assert.Equal(t, []PieceSource{PieceSourceLocal, PieceSourceLocal, PieceSourceLocal, PieceSourceLocal}, restored.PieceSources())
+}
+
+func TestSourceStats_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats SourceStats
+		want  string
+	}{
+		{name: "empty", want: "no verified pieces"},
+		{name: "peers only", stats: SourceStats{Peers: 10}, want: "100% from peers"},
+		{name: "mixed", stats: SourceStats{Peers: 82, WebSeeds: 11, Local: 7}, want: "82% from peers, 11% web seeds, 7% pre-existing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.stats.String())
+		})
+	}
+}
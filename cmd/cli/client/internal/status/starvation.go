@@ -0,0 +1,66 @@
+package status
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+const (
+	// minUsablePeers is the number of usable peers
+	// below which the torrent is starving for peers.
+	minUsablePeers = 2
+	// starvationTimeout is how long the torrent has to starve
+	// before an early announce is requested, and again between
+	// the following requests while it keeps starving.
+	starvationTimeout = 1 * time.Minute
+)
+
+// Reannounce delivers a value once the torrent had fewer than
+// minUsablePeers usable peers for starvationTimeout, asking
+// the announce loop to find new peers before the interval elapses.
+func (t *TorrentSession) Reannounce() <-chan struct{} { return t.download.reannounce }
+
+// usablePeers returns the number of connected seeders
+// that unchoked this client and have pieces it needs.
+func (t *TorrentSession) usablePeers() int {
+	missing := t.have.MissingPieces()
+	var n int
+	t.peers.seeders.Range(func(_, value any) bool {
+		p := value.(*peer.Peer)
+		usable := p.ConnectionStatus() == peer.ConnectionEstablished
+		usable = usable && p.Status.Remote.Load() == uint32(peer.UnChoked)
+		usable = usable && slices.ContainsFunc(missing, p.Bitfield.Check)
+		if usable {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// checkStarvation requests an early announce if the torrent is
+// starving for peers, must be called periodically by the scheduler.
+func (t *TorrentSession) checkStarvation(now time.Time) {
+	usable := t.usablePeers()
+	if usable >= minUsablePeers {
+		t.download.starvedSince = time.Time{}
+		return
+	}
+	if t.download.starvedSince.IsZero() {
+		t.download.starvedSince = now
+		return
+	}
+	if now.Sub(t.download.starvedSince) < starvationTimeout {
+		return
+	}
+	t.download.starvedSince = now
+
+	select {
+	case t.download.reannounce <- struct{}{}:
+		t.logger.Info("running out of usable peers, requesting early announce", slog.Int("usable_peers", usable))
+	default: // the previous request was not handled yet.
+	}
+}
@@ -0,0 +1,46 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_CheckStarvation(t *testing.T) {
+	tr := newTestTracker(t, messagesv1.RequestSize, make([]byte, messagesv1.RequestSize))
+	start := time.Now()
+
+	tests := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{after: 0},
+		{after: 30 * time.Second},
+		{after: starvationTimeout, want: true},
+		// the torrent has to starve for another period.
+		{after: starvationTimeout + time.Second},
+		{after: 2*starvationTimeout - time.Second},
+		{after: 2 * starvationTimeout, want: true},
+	}
+	for _, tt := range tests {
+		tr.checkStarvation(start.Add(tt.after))
+		select {
+		case <-tr.Reannounce():
+			assert.True(t, tt.want, "unexpected early announce after %v", tt.after)
+		default:
+			assert.False(t, tt.want, "no early announce after %v", tt.after)
+		}
+	}
+
+	// requests are not queued up while the announce loop is busy.
+	tr.checkStarvation(start.Add(3 * starvationTimeout))
+	tr.checkStarvation(start.Add(4 * starvationTimeout))
+	<-tr.Reannounce()
+	select {
+	case <-tr.Reannounce():
+		t.Fatal("early announces were queued up")
+	default:
+	}
+}
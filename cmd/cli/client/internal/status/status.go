@@ -1,20 +1,26 @@
 package status
 
 import (
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
 	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/storage"
 	"github.com/Despire/tinytorrent/torrent"
 )
 
@@ -22,6 +28,40 @@ type timedDownloadRequest struct {
 	request  messagesv1.Request
 	send     time.Time
 	received bool
+	// peers are the addresses of the peers the request was sent to.
+	// During the endgame a request is sent to more than one peer.
+	peers []string
+	// chokes are the choke counts of the peers, in the order of peers,
+	// when the request was sent to them, see requeueChoked. They are
+	// missing for web seeds, which never choke.
+	chokes []uint64
+}
+
+// sentBefore reports whether the request was sent to the peer at addr
+// before the peer choked this client for the n-th time.
+func (r *timedDownloadRequest) sentBefore(addr string, n uint64) bool {
+	i := slices.Index(r.peers, addr)
+	return i >= len(r.chokes) || r.chokes[i] < n
+}
+
+// forget removes the peer at addr from the peers the request was sent to.
+func (r *timedDownloadRequest) forget(addr string) {
+	i := slices.Index(r.peers, addr)
+	if i < 0 {
+		return
+	}
+	r.peers = slices.Delete(r.peers, i, i+1)
+	if i < len(r.chokes) {
+		r.chokes = slices.Delete(r.chokes, i, i+1)
+	}
+}
+
+type receivedBlock struct {
+	*messagesv1.Piece
+	// from is the address of the peer that delivered the block.
+	from string
+	// fromID is the id of the peer that delivered the block.
+	fromID string
 }
 
 type timedUploadRequest struct {
@@ -35,12 +75,26 @@ type pendingPiece struct {
 	// for the fields. Useful to have
 	// a consistent snapshot.
 	l          sync.Mutex
-	Index      uint32
+	Index      int64
+	Attempt    int
 	Downloaded int64
 	Size       int64
-	Received   []*messagesv1.Piece
+	Received   []*receivedBlock
 	Pending    []*messagesv1.Request
 	InFlight   []*timedDownloadRequest
+	// timedOut are the requests that timed out, with the peers they were
+	// sent to, so that a late block of such a peer is recognized as the
+	// answer to its request rather than as an unrequested block.
+	timedOut []*timedDownloadRequest
+	// flushFailures counts the failed writes of the verified piece,
+	// which are not reset when it is downloaded again.
+	flushFailures int
+	// suspects are the blocks of the attempts that failed verification,
+	// which are not reset when it is downloaded again, see attributeCorruption.
+	suspects []suspectBlock
+	// readingBack is set while the flushed piece is read back, during
+	// which its buffers are accounted as flushing, see readBack.
+	readingBack bool
 }
 
 func (p *pendingPiece) Retry() error {
@@ -56,24 +110,115 @@ func (p *pendingPiece) Retry() error {
 		})
 	}
 	p.InFlight = nil
+	p.timedOut = nil
 	p.Received = nil
 	p.Downloaded = 0
+	p.Attempt++
 	return nil
 }
 
+// receive adds the block b to the received ones and counts it toward
+// Downloaded, unless it overlaps any of them or does not fit into the
+// piece, which reports false. The piece lock must be held.
+func (p *pendingPiece) receive(b *receivedBlock) bool {
+	begin, end := int64(b.Begin), int64(b.Begin)+int64(len(b.Block))
+	if len(b.Block) == 0 || end > p.Size {
+		return false
+	}
+	for _, o := range p.Received {
+		if begin < int64(o.Begin)+int64(len(o.Block)) && int64(o.Begin) < end {
+			return false
+		}
+	}
+	p.Received = append(p.Received, b)
+	p.Downloaded += end - begin
+	return true
+}
+
+// timedOutAt reports whether the request req was sent to the peer at
+// addr before it timed out. The piece lock must be held.
+func (p *pendingPiece) timedOutAt(req messagesv1.Request, addr string) bool {
+	return slices.ContainsFunc(p.timedOut, func(r *timedDownloadRequest) bool {
+		return r.request == req && slices.Contains(r.peers, addr)
+	})
+}
+
+// fromWebSeed reports whether any of the received blocks was delivered by a web seed.
+func (p *pendingPiece) fromWebSeed() bool {
+	return slices.ContainsFunc(p.Received, func(b *receivedBlock) bool { return b.fromID == webSeedID })
+}
+
+// Contributions groups the received blocks by the peer
+// that delivered them. The piece data is not copied.
+func (p *pendingPiece) Contributions() []Contribution {
+	var out []Contribution
+	for _, b := range p.Received {
+		i := slices.IndexFunc(out, func(c Contribution) bool { return c.Addr == b.from })
+		if i < 0 {
+			out = append(out, Contribution{PeerID: b.fromID, Addr: b.from})
+			i = len(out) - 1
+		}
+		out[i].Ranges = append(out[i].Ranges, BlockRange{
+			Begin:  b.Begin,
+			Length: uint32(len(b.Block)),
+		})
+	}
+	return out
+}
+
+// maxHashFailures is the number of pieces failing verification
+// a peer can contribute to before it is banned.
+const maxHashFailures = 3
+
 type peers struct {
 	seeders  sync.Map
 	leechers sync.Map
+
+	// strikes counts the number of pieces that failed
+	// verification a peer contributed to, keyed by address.
+	strikes sync.Map
+	// banned contains addresses of peers that will no
+	// longer be contacted.
+	banned sync.Map
+	// idStrikes counts the pieces that failed verification a
+	// peer id contributed to, at any address, keyed by peer id.
+	idStrikes sync.Map
+	// bannedIDs contains the peer ids whose connections are
+	// dropped after the handshake, see stablePeerID.
+	bannedIDs sync.Map
+	// self contains addresses of peers that turned out
+	// to be this client during the handshake.
+	self sync.Map
+
+	// suggestions holds the *suggestions of the seeders, keyed by address.
+	suggestions sync.Map
+
+	// stats holds the *peerStats of the seeders, keyed by address.
+	stats sync.Map
+	// uploads holds the *atomic.Int64 number of bytes sent
+	// to each connected leecher, keyed by address.
+	uploads sync.Map
+
+	// manual contains addresses of peers that were
+	// added from the peer list file.
+	manual sync.Map
+
+	// connecting contains addresses of the seeders for which
+	// a keepAliveSeeders goroutine is running.
+	connecting sync.Map
+
+	// dials orders the seeders waiting for a connection.
+	dials dialQueue
 }
 
-// How often the rate of bytes downloaded is updated.
+// How often the rates of the peers and the pipeline are updated.
 const rateTick = 1 * time.Second
 
 type Download struct {
-	// Requests are the number of pieces concurrently
-	// downloaded. No more than len(requests) pieces
-	// are downloaded at a time.
-	requests [4]atomic.Pointer[pendingPiece]
+	// Active are the pieces concurrently downloaded.
+	// No more than active.limit() pieces are
+	// downloaded at a time.
+	active pieceSlots
 	// The wait group is used when spawning download related goroutines.
 	wg sync.WaitGroup
 	// Download related signaling. When the torrent
@@ -82,11 +227,78 @@ type Download struct {
 	// allows the application code to only cancel
 	// the downloads and keep other workflows
 	// running, such as seeding.
-	cancel, completed chan struct{}
-	// Rate is the number of bytes downloaded for the last 1 seconds.
-	rate atomic.Int64
+	completed chan struct{}
+	// cancel is the cancelation of the download, replaced once the
+	// download is stopped, so that it can be started again.
+	cancel atomic.Pointer[cancelation]
+	// rate is the smoothed download rate.
+	rate rateMeter
+	// pipeline measures the verification and flushing of pieces.
+	pipeline pipeline
+	// endgameBlocks is the fixed endgame threshold, if positive.
+	// Otherwise the endgame is entered adaptively.
+	endgameBlocks int
+	// reconnect decides when to contact seeders again
+	// after failed connection attempts.
+	reconnect reconnectPolicy
+	// readBack is set if flushed pieces are read back and verified again.
+	readBack bool
+	// diskErrors counts the pieces that failed to read back as written.
+	diskErrors atomic.Int64
+	// waste counts the downloaded bytes that were discarded.
+	waste waste
+	// recovered counts the pieces fetched from web seeds
+	// after repeatedly failing verification from peers.
+	recovered atomic.Int64
+	// starvedSince is the time since which too few peers are usable,
+	// zero if enough are. Only accessed by the download scheduler.
+	starvedSince time.Time
+	// reannounce asks the announce loop for an early announce.
+	reannounce chan struct{}
+	// reclaimed wakes the scheduler waiting for
+	// pieces to download once one was reclaimed.
+	reclaimed chan struct{}
+	// picker chooses the peers the requests are sent to.
+	picker PeerPicker
+	// maxActive is the number of active pieces set by
+	// WithMaxActivePieces, zero if derived from the share
+	// of the torrent.
+	maxActive atomic.Int64
+	// maxPeers and maxPipeline are the number of seeders and of requests
+	// outstanding with each set by WithMaxPeers and WithMaxPipeline,
+	// zero if unbounded, see Limits.
+	maxPeers, maxPipeline atomic.Int64
+	// connShare is the number of seeders the torrent may be connected
+	// to, zero if unbounded, connected the number it is connected to.
+	connShare atomic.Int64
+	connected atomic.Int64
+	// queuedPeers are the peers announced while the torrent was
+	// queued, which are contacted once it is dequeued.
+	queuedPeers atomic.Pointer[tracker.Response]
+	// failure is the failure of the download, replaced once it is retried.
+	failure atomic.Pointer[failure]
+}
+
+// failure is closed once the download failed, with err set to the reason.
+type failure struct {
+	failed chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func newFailure() *failure { return &failure{failed: make(chan struct{})} }
+
+// cancelation is closed once the download is canceled, either by
+// cancelling the download or once the torrent failed.
+type cancelation struct {
+	canceled chan struct{}
+	once     sync.Once
 }
 
+func newCancelation() *cancelation { return &cancelation{canceled: make(chan struct{})} }
+
+func (c *cancelation) cancel() { c.once.Do(func() { close(c.canceled) }) }
+
 type Upload struct {
 	// Requests are the number of maximum requests
 	// that will be handled by the client for any
@@ -96,19 +308,34 @@ type Upload struct {
 	wg sync.WaitGroup
 	// Upload related signaling. When the torrent
 	// finishes uploading the cancel channel is closed.
-	cancel chan struct{}
-	// Rate is the number of bytes uploaded for the last 1 second.
-	rate atomic.Int64
+	// The seeded channel is closed once the seeding
+	// goals are reached.
+	cancel, seeded chan struct{}
+	// rate is the smoothed upload rate.
+	rate rateMeter
+	// seedRatio is the ratio of uploaded bytes to the size of the
+	// torrent after which seeding stops, if positive.
+	seedRatio float64
+	// seedTime is the duration after completing the download
+	// after which seeding stops, if positive.
+	seedTime time.Duration
 }
 
-// Tracker wraps all necessary information for tracking
-// the status of a torrent file
-type Tracker struct {
+// TorrentSession downloads and seeds a single torrent, and tracks its
+// status. Its methods are safe for concurrent use, unless documented
+// otherwise.
+type TorrentSession struct {
 	clientID string
 	logger   *slog.Logger
 
+	// clock tells the time, the SystemClock if nil.
+	clock Clock
+	// rand is the source of the random choices, those of
+	// the math/rand/v2 package functions if nil.
+	rand *rand.Rand
+
 	// Peers are the seeders and leechers that are known
-	// to this torrent tracker.
+	// to this torrent session.
 	peers peers
 
 	// download wraps all download related information.
@@ -117,55 +344,259 @@ type Tracker struct {
 	// upload wraps all upload related information.
 	upload Upload
 
+	// subscribers are notified about emitted events.
+	subscribers subscribers
+
+	// storage persists the verified pieces.
+	storage storage.Storage
+	// files is the default storage, nil if another was passed.
+	files *storage.PieceFiles
+	// moveTo is the directory the downloaded torrent is moved to, if set.
+	moveTo string
+	// disk schedules the operations on storage, if set.
+	disk *storage.Scheduler
+	// cache caches the pieces read from storage to serve uploads, if set.
+	cache  *storage.PieceCache
+	cached *storage.CachedStorage
+
+	// compactStates holds the scheduling states of the pieces
+	// in a table regardless of their number, see WithCompactPieceStates.
+	compactStates bool
+
+	// buffers bounds the memory held by the pieces in flight.
+	buffers *BufferBudget
+
+	// conns bounds the connections to the seeders.
+	conns *ConnLimit
+
+	// blocked reports the addresses of peers that must not
+	// be contacted, if set.
+	blocked func(addr netip.Addr) bool
+
+	// listenAddrs are the addresses this client accepts connections on.
+	listenAddrs []netip.AddrPort
+	// announcePort is the port announced instead of the listen port, if positive.
+	announcePort int
+
+	// dial establishes the connections to seeders and web seeds, if set.
+	dial peer.DialFunc
+
+	// dhtNode is called with the DHT nodes advertised by peers, if set.
+	dhtNode func(node netip.AddrPort)
+
+	// peerList is the optional static peer source.
+	peerList *peerList
+
+	// webSeeds are the HTTP servers from the url-list of the torrent.
+	webSeeds []*webSeed
+
+	// announce is the outcome of the announces to the tracker.
+	announce announceStatus
+
+	// metrics are the counters exposed for monitoring, see Metrics.
+	metrics metrics
+	// blockLog samples the debug lines of the blocks, see WithBlockLogSampling.
+	blockLog blockLog
+
+	// paused is set while the torrent is not downloading
+	// nor contacting peers, until Resume is called.
+	paused atomic.Bool
+
+	// coordinator shares the resources of the client among the
+	// torrents, weighted by priority. queued is set while the
+	// torrent waits for the coordinator to start its download,
+	// for the reason in queueReason.
+	coordinator *Coordinator
+	priority    Priority
+	queued      atomic.Bool
+	queueReason atomic.Pointer[string]
+
+	// rechecking is set while Recheck verifies the pieces,
+	// check is the state of the running or interrupted recheck.
+	rechecking atomic.Bool
+	check      recheck
+
+	// completedAnnounced is set once the completed event
+	// was sent to the tracker.
+	completedAnnounced atomic.Bool
+
+	// added is when the session was created, restoredComplete is set
+	// if the torrent was complete already, see TorrentCompleted.
+	added            time.Time
+	restoredComplete bool
+	// onComplete is called once the torrent completed, if set.
+	onComplete func(TorrentCompleted)
+
+	// durability tracks the flushed pieces that were synced.
+	durability durability
+
 	// Stop channel indicates the application was shutdown
 	// By closing this channel all workflows will finish
-	// and the tracker will no longer do any work.
+	// and the session will no longer do any work.
 	stop chan struct{}
 
-	Torrent     *torrent.MetaInfoFile
-	BitField    *bitfield.BitField
-	Uploaded    atomic.Int64
-	Downloaded  atomic.Int64
-	DownloadDir string
+	// meta is the torrent, have the verified pieces of it.
+	meta *torrent.MetaInfoFile
+	have *bitfield.BitField
+	// sources are the PieceSource of each piece, see PieceSources.
+	sources pieceSources
+	// availability counts the connected peers having each piece, see Availability.
+	availability availability
+	// uploaded and downloaded are the bytes uploaded to the peers
+	// and those of the verified pieces.
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	// share are the counters of the previous runs, see ShareStats.
+	share share
+	// dir is the download directory, changed once moved.
+	dir atomic.Pointer[string]
 }
 
-func NewTracker(clientID string, logger *slog.Logger, t *torrent.MetaInfoFile, downloadDir string) (*Tracker, error) {
-	tr := Tracker{
-		clientID:    clientID,
-		logger:      logger.With(slog.String("url", t.Announce), slog.String("infoHash", string(t.Metadata.Hash[:]))),
-		stop:        make(chan struct{}),
-		Torrent:     t,
-		BitField:    bitfield.NewBitfield(t.NumPieces()),
-		Uploaded:    atomic.Int64{},
-		Downloaded:  atomic.Int64{},
-		DownloadDir: path.Join(downloadDir, hex.EncodeToString(t.Info.Metadata.Hash[:])),
+// Tracker is the former name of TorrentSession.
+//
+// Deprecated: use TorrentSession, the name is kept until the CLI migrated.
+type Tracker = TorrentSession
+
+// NewTracker is the former name of NewTorrentSession.
+//
+// Deprecated: use NewTorrentSession.
+func NewTracker(clientID string, logger *slog.Logger, t *torrent.MetaInfoFile, downloadDir string, opts ...Option) (*TorrentSession, error) {
+	return NewTorrentSession(clientID, logger, t, downloadDir, opts...)
+}
+
+// NewTorrentSession returns the session of the torrent, whose pieces are
+// stored in a directory named by the hex encoded info hash within downloadDir.
+func NewTorrentSession(clientID string, logger *slog.Logger, t *torrent.MetaInfoFile, downloadDir string, opts ...Option) (*TorrentSession, error) {
+	announce, skipped := selectTracker(t)
+	tr := TorrentSession{
+		clientID: clientID,
+		logger:   logger.With(slog.String("url", tracker.RedactURL(announce)), slog.String("infoHash", string(t.Metadata.Hash[:]))),
+		stop:     make(chan struct{}),
+		meta:     t,
+		have:     bitfield.NewBitfield(t.NumPieces()),
 	}
+	tr.setDownloadDir(path.Join(downloadDir, hex.EncodeToString(t.Info.Metadata.Hash[:])))
 
-	tr.download.cancel = make(chan struct{})
+	tr.download.cancel.Store(newCancelation())
 	tr.download.completed = make(chan struct{})
+	tr.download.failure.Store(newFailure())
+	tr.download.reannounce = make(chan struct{}, 1)
+	tr.download.reclaimed = make(chan struct{}, 1)
+	tr.download.reconnect = defaultReconnectPolicy
+	tr.announce.numWant = DefaultNumWant
+	tr.announce.url = announce
+	for _, u := range skipped {
+		tr.logger.Info("skipping tracker of unsupported scheme", slog.String("tracker", tracker.RedactURL(u)))
+		tr.announce.status.Skipped = append(tr.announce.status.Skipped, tracker.RedactURL(u))
+	}
+	tr.download.active.setMax(defaultActivePieces(t.PieceLength))
+	tr.upload.cancel = make(chan struct{})
+	tr.upload.seeded = make(chan struct{})
+
+	tr.durability.durable = bitfield.NewBitfield(t.NumPieces())
+	tr.durability.pieces = defaultSyncPieces
+	tr.durability.interval = defaultSyncInterval
 
-	// read bitfield if exists.
-	f, err := os.Open(filepath.Join(tr.DownloadDir, "bitfield.bin"))
-	if err == nil {
-		defer f.Close()
-		r := make([]byte, tr.BitField.Len())
-		if err := binary.Read(f, binary.LittleEndian, &r); err != nil {
-			return nil, fmt.Errorf("failed to read existing bitfield file: %w", err)
+	tr.Subscribe(tr.banContributors)
+
+	for _, o := range opts {
+		o(&tr)
+	}
+	if tr.added.IsZero() {
+		tr.added = tr.now()
+	}
+	tr.durability.synced.Store(tr.now().UnixNano())
+	if tr.download.picker == nil {
+		tr.download.picker = RatePicker{Rand: tr.rand}
+	}
+
+	if tr.moveTo != "" {
+		// a torrent that was already moved is resumed from its destination.
+		moved := filepath.Join(tr.moveTo, filepath.Base(tr.DownloadDir()))
+		if _, err := os.Stat(filepath.Join(moved, resumeFile)); err == nil {
+			tr.setDownloadDir(moved)
+		}
+	}
+	if tr.storage == nil {
+		tr.files = storage.NewPieceFiles(tr.DownloadDir())
+		tr.storage = tr.files
+	}
+	if tr.disk != nil {
+		tr.storage = tr.disk.Wrap(tr.storage)
+	}
+	if tr.cache != nil {
+		tr.cached = tr.cache.Wrap(tr.storage, tr.meta.PieceSize)
+		tr.storage = tr.cached
+	}
+	if tr.compactStates || t.NumPieces() >= compactPieceThreshold {
+		states, err := newTableStates(t.NumPieces())
+		if err != nil {
+			tr.logger.Warn("failed to map piece states, holding them on the heap", slog.Any("err", err))
 		}
+		tr.download.active.states = states
+	}
+	if tr.buffers == nil {
+		tr.buffers = NewBufferBudget(0)
+	}
+	if tr.conns == nil {
+		tr.conns = NewConnLimit(0)
+	}
+	if tr.priority <= 0 {
+		tr.priority = PriorityNormal
+	}
 
-		tr.BitField.Overwrite(r)
+	r, err := tr.loadResume()
+	if err != nil {
+		return nil, err
+	}
+	tr.restoreShare(r)
+	if r != nil {
+		tr.have.Overwrite(r.Bitfield)
+		tr.durability.durable.Overwrite(r.Bitfield)
+		tr.completedAnnounced.Store(r.CompletedAnnounced)
+		if r.Paused {
+			tr.paused.Store(true)
+		}
+		tr.check.pending.Store(r.Recheck)
+		tr.restoreSources(r)
 
 		// calculated downloaded size.
-		for _, i := range tr.BitField.ExistingPieces() {
-			pieceStart := int64(i) * tr.Torrent.PieceLength
-			pieceEnd := pieceStart + tr.Torrent.PieceLength
-			pieceEnd = min(pieceEnd, tr.Torrent.BytesToDownload())
-			tr.Downloaded.Add(pieceEnd - pieceStart)
+		for _, i := range tr.have.ExistingPieces() {
+			tr.downloaded.Add(tr.meta.PieceSize(i))
 		}
 	}
 
-	tr.download.wg.Add(1)
-	go tr.downloadScheduler()
+	// torrents that were already complete when restored must
+	// never announce the completed event.
+	if len(tr.have.MissingPieces()) == 0 {
+		tr.completedAnnounced.Store(true)
+		tr.restoredComplete = true
+	}
+
+	client := &http.Client{Timeout: webSeedTimeout}
+	if tr.dial != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = tr.dial
+		client.Transport = transport
+	}
+	for _, u := range t.UrlList {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			tr.logger.Debug("skipping unsupported web seed", slog.String("web_seed", u))
+			continue
+		}
+		tr.webSeeds = append(tr.webSeeds, newWebSeed(u, client))
+	}
+
+	if tr.paused.Load() {
+		// persist the paused state right away, so that it is
+		// honored even if the client does not shut down cleanly.
+		if err := tr.saveResume(); err != nil {
+			return nil, err
+		}
+		tr.logger.Info("torrent added paused")
+	} else {
+		tr.startDownload()
+	}
 
 	tr.upload.wg.Add(1)
 	go tr.processUploadRequests()
@@ -173,61 +604,132 @@ func NewTracker(clientID string, logger *slog.Logger, t *torrent.MetaInfoFile, d
 	tr.upload.wg.Add(1)
 	go tr.optimisticUnchoke()
 
+	tr.upload.wg.Add(1)
+	go tr.watchSeedGoals()
+
 	return &tr, nil
 }
 
-func (t *Tracker) Close() error {
+// Close stops the session and persists its resume data. It must be called
+// once, and no other method is called afterwards.
+func (t *TorrentSession) Close() error {
+	t.closeRecheck()
 	var errAll error
-	b, err := os.Create(filepath.Join(t.DownloadDir, "bitfield.bin"))
-	if err != nil {
-		errAll = errors.Join(errAll, fmt.Errorf("failed creating bitfiled file: %w", err))
-	} else {
-		if err := binary.Write(b, binary.LittleEndian, t.BitField.Clone()); err != nil {
-			errAll = errors.Join(errAll, fmt.Errorf("failed to write bitfield to disk: %w", err))
-		}
-		if err := b.Close(); err != nil {
-			errAll = errors.Join(errAll, fmt.Errorf("failed to close bitfield file: %w", err))
-		}
+	if err := t.saveResume(); err != nil {
+		errAll = errors.Join(errAll, err)
 	}
+	t.coordinator.leave(t)
 	close(t.stop)
 	t.download.wg.Wait()
 	t.upload.wg.Wait()
-	return nil
+	t.download.active.close()
+	if t.cached != nil {
+		t.cached.Purge()
+	}
+	return errAll
 }
 
-func (t *Tracker) Flush(idx uint32, pieceBytes []byte) error {
-	if _, err := os.Stat(t.DownloadDir); errors.Is(err, os.ErrNotExist) {
-		if err := os.Mkdir(t.DownloadDir, os.ModePerm); err != nil {
-			return err
-		}
+// Failed returns a channel that is closed once the download
+// failed and was stopped. The reason is returned by Err.
+// Once the download is retried, a new channel is returned.
+func (t *TorrentSession) Failed() <-chan struct{} { return t.download.failure.Load().failed }
+
+// Err returns the reason the download failed, if it did.
+func (t *TorrentSession) Err() error {
+	f := t.download.failure.Load()
+	select {
+	case <-f.failed:
+		return f.err
+	default:
+		return nil
 	}
+}
 
-	f, err := os.Create(filepath.Join(t.DownloadDir, fmt.Sprintf("%v.bin", idx)))
-	if err != nil {
-		return err
+// DiskErrors returns the number of pieces that did
+// not read back as written, see WithReadBackVerification.
+func (t *TorrentSession) DiskErrors() int64 { return t.download.diskErrors.Load() }
+
+// CorruptBytes returns the number of downloaded bytes
+// that were discarded as their piece failed verification.
+func (t *TorrentSession) CorruptBytes() int64 { return t.download.waste.hashFailed.Load() }
+
+// WebSeedRecoveries returns the number of pieces that were fetched from
+// web seeds after repeatedly failing verification from peers.
+func (t *TorrentSession) WebSeedRecoveries() int64 { return t.download.recovered.Load() }
+
+// fail stops the download with err. It does not wait for
+// the download goroutines, as it is called from within them.
+func (t *TorrentSession) fail(err error) {
+	f := t.download.failure.Load()
+	f.once.Do(func() {
+		t.logger.Error("download failed, stopping", slog.Any("err", err))
+		f.err = err
+		close(f.failed)
+		t.download.cancel.Load().cancel()
+		t.emit(TorrentFailed{Err: err})
+	})
+}
+
+// ShouldAnnounceCompleted reports whether the completed event
+// still needs to be announced to the tracker. It reports true
+// only for torrents that were downloaded within this client and
+// whose completion has not been announced yet.
+func (t *TorrentSession) ShouldAnnounceCompleted() bool {
+	select {
+	case <-t.download.completed:
+		return !t.completedAnnounced.Load()
+	default:
+		return false
 	}
-	defer f.Close()
+}
 
-	return binary.Write(f, binary.LittleEndian, pieceBytes)
+// MarkCompletedAnnounced records that the completed event was sent
+// to the tracker, so that it is never sent again for this torrent.
+func (t *TorrentSession) MarkCompletedAnnounced() error {
+	t.completedAnnounced.Store(true)
+	return t.saveResume()
 }
 
-func (t *Tracker) ReadRequest(req *messagesv1.Request) ([]byte, error) {
-	if _, err := os.Stat(t.DownloadDir); errors.Is(err, os.ErrNotExist) {
-		return nil, fmt.Errorf("cannot construct request: %w", err)
-	}
+// Torrent returns the torrent of the session, which must not be modified.
+func (t *TorrentSession) Torrent() *torrent.MetaInfoFile { return t.meta }
 
-	b, err := os.ReadFile(filepath.Join(t.DownloadDir, fmt.Sprintf("%v.bin", req.Index)))
-	if err != nil {
-		return nil, err
-	}
+// HasPiece reports whether the piece at index was downloaded and verified.
+func (t *TorrentSession) HasPiece(index int64) bool { return t.have.Check(index) }
 
-	if int(req.Begin) >= len(b) {
-		return nil, fmt.Errorf("invalid request, offset within piece larger than piece size")
-	}
+// VerifiedPieces returns the downloaded and verified pieces in ascending order.
+func (t *TorrentSession) VerifiedPieces() []int64 { return t.have.ExistingPieces() }
 
-	if l := len(b) - int(req.Begin); int(req.Length) > l {
-		return nil, fmt.Errorf("invalid request, offset + length tries to request larger block than possible")
+// Downloaded returns the number of bytes of the verified pieces.
+func (t *TorrentSession) Downloaded() int64 { return t.downloaded.Load() }
+
+// Uploaded returns the number of bytes uploaded to peers, including
+// those restored by WithUploaded.
+func (t *TorrentSession) Uploaded() int64 { return t.uploaded.Load() }
+
+// DownloadDir returns the directory the pieces are stored in, which
+// changes once the torrent was moved, see WithMoveOnComplete.
+func (t *TorrentSession) DownloadDir() string {
+	if dir := t.dir.Load(); dir != nil {
+		return *dir
 	}
+	return ""
+}
+
+func (t *TorrentSession) setDownloadDir(dir string) { t.dir.Store(&dir) }
+
+// Added returns the time the torrent was added, see WithAdded.
+func (t *TorrentSession) Added() time.Time { return t.added }
+
+// AnnouncePort returns the port passed to WithAnnouncePort, zero if none was.
+func (t *TorrentSession) AnnouncePort() int { return t.announcePort }
+
+func (t *TorrentSession) Flush(idx int64, pieceBytes []byte) error {
+	start := t.now()
+	err := t.storage.WritePiece(idx, pieceBytes)
+	t.metrics.flush.observe(t.since(start))
+	return err
+}
 
-	return b[int(req.Begin):(int(req.Begin + req.Length))], nil
+func (t *TorrentSession) ReadRequest(req *messagesv1.Request) ([]byte, error) {
+	return t.storage.ReadBlock(req.PieceIndex(), req.Begin, req.Length)
 }
@@ -1,10 +1,14 @@
 package status
 
 import (
+	"encoding/hex"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/storage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,7 +21,8 @@ func TestTracker_FlushRead(t *testing.T) {
 		os.RemoveAll(downloadDir)
 	})
 
-	tr := &Tracker{DownloadDir: downloadDir}
+	tr := &TorrentSession{storage: storage.NewPieceFiles(downloadDir)}
+	tr.setDownloadDir(downloadDir)
 
 	err = tr.Flush(0, []byte{0x0, 0x1})
 	assert.Nil(t, err)
@@ -90,3 +95,77 @@ func TestTracker_FlushRead(t *testing.T) {
 	})
 	assert.NotNil(t, err)
 }
+
+func TestTracker_CompletedAnnouncedOnce(t *testing.T) {
+	piece := []byte{0x1, 0x2, 0x3}
+	tr := newTestTracker(t, int64(len(piece)), piece)
+
+	// not yet downloaded.
+	assert.False(t, tr.ShouldAnnounceCompleted())
+
+	tr.have.Set(0)
+	close(tr.download.completed)
+	assert.True(t, tr.ShouldAnnounceCompleted())
+
+	assert.Nil(t, tr.MarkCompletedAnnounced())
+	assert.False(t, tr.ShouldAnnounceCompleted())
+
+	restored := newTestTracker(t, int64(len(piece)), piece)
+	restored.setDownloadDir(tr.DownloadDir())
+	r, err := restored.loadResume()
+	assert.Nil(t, err)
+	assert.True(t, r.CompletedAnnounced)
+	assert.Equal(t, tr.have.Clone(), r.Bitfield)
+}
+
+func TestNewTorrentSession_RestoredCompleteNeverAnnounces(t *testing.T) {
+	piece := []byte{0x1, 0x2, 0x3}
+	tr := newTestTracker(t, int64(len(piece)), piece)
+	base := t.TempDir()
+	tr.setDownloadDir(filepath.Join(base, hex.EncodeToString(tr.meta.Metadata.Hash[:])))
+	tr.have.Set(0)
+	assert.Nil(t, tr.saveResume())
+
+	restored, err := NewTorrentSession("client", tr.logger, tr.meta, base)
+	assert.Nil(t, err)
+	t.Cleanup(func() { restored.Close() })
+
+	<-restored.WaitUntilDownloaded()
+	assert.False(t, restored.ShouldAnnounceCompleted())
+}
+
+func TestTracker_SeedGoals(t *testing.T) {
+	piece := []byte{0x1, 0x2, 0x3, 0x4}
+	tr := newTestTracker(t, int64(len(piece)), piece)
+
+	// seeds forever by default.
+	assert.False(t, tr.seedGoalsReached(time.Now().Add(-time.Hour)))
+
+	WithSeedRatio(1.5)(tr)
+	tr.uploaded.Store(5)
+	assert.False(t, tr.seedGoalsReached(time.Now()))
+	tr.uploaded.Store(6)
+	assert.True(t, tr.seedGoalsReached(time.Now()))
+
+	tr.uploaded.Store(0)
+	WithSeedTime(time.Minute)(tr)
+	assert.False(t, tr.seedGoalsReached(time.Now()))
+	assert.True(t, tr.seedGoalsReached(time.Now().Add(-2*time.Minute)))
+}
+
+func TestTorrentSession_Accessors(t *testing.T) {
+	tr := newTestTracker(t, 2, []byte{0x1, 0x2}, []byte{0x3, 0x4}, []byte{0x5})
+	tr.have.Set(0)
+	tr.have.Set(2)
+	tr.downloaded.Store(3)
+	tr.uploaded.Store(7)
+
+	assert.Same(t, tr.meta, tr.Torrent())
+	assert.True(t, tr.HasPiece(0))
+	assert.False(t, tr.HasPiece(1))
+	assert.Equal(t, []int64{0, 2}, tr.VerifiedPieces())
+	assert.Equal(t, int64(3), tr.Downloaded())
+	assert.Equal(t, int64(7), tr.Uploaded())
+	assert.NotEmpty(t, tr.DownloadDir())
+	assert.Empty(t, new(TorrentSession).DownloadDir())
+}
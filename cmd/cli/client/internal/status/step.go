@@ -0,0 +1,358 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// stepPeer is a connected seeder, as seen by a scheduling step.
+type stepPeer struct {
+	Addr     string
+	Unchoked bool
+	Snubbed  bool
+	Rate     int64
+	// Reputation is the score of the data the peer delivered, see reputation.
+	Reputation float64
+	// Has, AllowedFast and Suggested report whether the peer has the
+	// piece, allowed requesting it while choked, and suggested it.
+	Has, AllowedFast, Suggested func(piece int64) bool
+	// Suggestions are the pieces the peer suggested recently.
+	Suggestions []int64
+}
+
+// stepRequest is a request of an active piece that was sent to peers.
+type stepRequest struct {
+	Request  messagesv1.Request
+	Sent     time.Time
+	Received bool
+	Peers    []string
+}
+
+// stepPiece is a piece that is downloaded in a slot.
+type stepPiece struct {
+	Index    int64
+	Pending  []messagesv1.Request
+	InFlight []stepRequest
+	// Fallback is set if the piece is only requested from web seeds.
+	Fallback bool
+}
+
+// stepInput is the state a scheduling step decides upon.
+type stepInput struct {
+	Now    time.Time
+	Peers  []stepPeer
+	Pieces []stepPiece
+	// Outstanding is the number of unanswered requests of each peer,
+	// MaxPipeline the number no peer is sent more than, if positive.
+	Outstanding map[string]int
+	MaxPipeline int
+	// Missing yields the pieces that are neither verified nor held by
+	// a slot, in the order they are considered to be started. It is
+	// only iterated if FreeSlot is set.
+	Missing iter.Seq[int64]
+	// FreeSlot is set if another piece can be started.
+	FreeSlot bool
+	// WebSeed is set if a web seed can serve requests.
+	WebSeed bool
+	// Startable reports whether a missing piece can be started, and
+	// Availability the number of peers that have a piece. If set,
+	// the pieces suggested by the peers are preferred, see nextPiece.
+	Startable    func(piece int64) bool
+	Availability func(piece int64) int
+}
+
+// actionKind is the kind of a stepAction.
+type actionKind uint8
+
+const (
+	// actionTimeout cancels a request that timed out, which is pending again.
+	actionTimeout actionKind = iota + 1
+	// actionRequest sends a pending request.
+	actionRequest
+	// actionStart starts to download a piece in the free slot.
+	actionStart
+)
+
+// stepAction is a decision of a scheduling step.
+type stepAction struct {
+	Kind    actionKind
+	Piece   int64
+	Request messagesv1.Request
+	// Peer is the address the request is sent to. If empty, no peer
+	// can serve the request and it is left to the web seeds; Candidates
+	// is then the number of peers that have the piece but are snubbed.
+	Peer       string
+	Candidates int
+	// Ready is set if a peer serves the started piece right away.
+	Ready bool
+}
+
+// step decides which requests of the active pieces are sent to which
+// peers, after those that timed out were made pending again, and which
+// piece is started next. It has no side effects, the actions are
+// executed by the caller in the order they are returned.
+func step(in stepInput, picker PeerPicker) []stepAction {
+	var out []stepAction
+	outstanding := maps.Clone(in.Outstanding)
+	if outstanding == nil {
+		outstanding = make(map[string]int)
+	}
+
+	for _, p := range in.Pieces {
+		pending := slices.Clone(p.Pending)
+		for _, r := range p.InFlight {
+			if !r.Received && in.Now.Sub(r.Sent) > requestTimeout {
+				out = append(out, stepAction{Kind: actionTimeout, Piece: p.Index, Request: r.Request})
+				pending = append(pending, r.Request)
+			}
+		}
+		for _, req := range pending {
+			a := stepAction{Kind: actionRequest, Piece: p.Index, Request: req}
+			if !p.Fallback {
+				var serving []stepPeer
+				for _, s := range in.Peers {
+					if in.MaxPipeline > 0 && outstanding[s.Addr] >= in.MaxPipeline {
+						continue
+					}
+					// pieces the peer allowed fast are requested while choked.
+					if s.Has(p.Index) && (s.Unchoked || s.AllowedFast(p.Index)) {
+						serving = append(serving, s)
+					}
+				}
+				if i := pickCandidate(serving, outstanding, p.Index, picker); i >= 0 {
+					a.Peer = serving[i].Addr
+					outstanding[a.Peer]++
+				} else {
+					a.Candidates = len(serving)
+				}
+			}
+			out = append(out, a)
+		}
+	}
+
+	if in.FreeSlot {
+		if index, ready := nextPiece(in); index >= 0 {
+			out = append(out, stepAction{Kind: actionStart, Piece: index, Ready: ready})
+		}
+	}
+	return out
+}
+
+// nextPiece returns the next missing piece that can be downloaded,
+// preferring one that a peer serves right away, as it unchoked this
+// client or allowed the piece fast, or -1 if there is none. A piece
+// suggested by a peer is preferred over it if it is no more common.
+func nextPiece(in stepInput) (index int64, ready bool) {
+	index = -1
+	for i := range in.Missing {
+		for _, p := range in.Peers {
+			if !p.Has(i) {
+				continue
+			}
+			if p.Unchoked || p.AllowedFast(i) {
+				if s := suggestedPiece(in, i); s >= 0 {
+					return s, true
+				}
+				return i, true
+			}
+			if index < 0 {
+				index = i
+			}
+		}
+	}
+	if index < 0 && in.WebSeed {
+		// web seeds have all the pieces.
+		for i := range in.Missing {
+			return i, false
+		}
+	}
+	return index, false
+}
+
+// pickCandidate returns the index of the peer among peers the next request
+// for the piece is sent to, or -1 if none is chosen. Peers that are not
+// snubbed are strongly preferred. A snubbed peer is only chosen if it has
+// no outstanding requests, to probe whether it delivers again. Among those,
+// picker decides.
+func pickCandidate(peers []stepPeer, outstanding map[string]int, piece int64, picker PeerPicker) int {
+	var preferred, probes []int
+	for i, p := range peers {
+		switch {
+		case !p.Snubbed:
+			preferred = append(preferred, i)
+		case outstanding[p.Addr] == 0:
+			probes = append(probes, i)
+		}
+	}
+	if len(preferred) == 0 {
+		preferred = probes
+	}
+	if len(preferred) == 0 {
+		return -1
+	}
+
+	candidates := make([]PeerCandidate, len(preferred))
+	for i, j := range preferred {
+		candidates[i] = PeerCandidate{
+			Addr:        peers[j].Addr,
+			Rate:        peers[j].Rate,
+			Outstanding: outstanding[peers[j].Addr],
+			Suggested:   peers[j].Suggested(piece),
+			Reputation:  peers[j].Reputation,
+		}
+	}
+	i := picker.Pick(candidates)
+	if i < 0 || i >= len(preferred) {
+		return -1
+	}
+	return preferred[i]
+}
+
+// stepPeerOf returns the seeder p as seen by a scheduling step.
+func (t *TorrentSession) stepPeerOf(p *peer.Peer) stepPeer {
+	suggestions := t.suggestionsOf(p.Addr)
+	return stepPeer{
+		Addr:        p.Addr,
+		Unchoked:    p.Status.Remote.Load() == uint32(peer.UnChoked),
+		Snubbed:     t.isSnubbed(p.Addr),
+		Rate:        t.peerRate(p.Addr),
+		Reputation:  t.peerReputation(p.Addr),
+		Has:         p.Bitfield.Check,
+		AllowedFast: p.AllowedFast,
+		Suggested: func(i int64) bool {
+			return slices.Contains(suggestions, i) && p.Bitfield.Check(i)
+		},
+		Suggestions: suggestions,
+	}
+}
+
+// stepPieceOf returns the active piece p as seen by a scheduling
+// step. The caller must hold the lock of p.
+func (t *TorrentSession) stepPieceOf(p *pendingPiece) stepPiece {
+	s := stepPiece{Index: p.Index, Fallback: t.fallbackToWebSeed(p)}
+	for _, r := range p.Pending {
+		s.Pending = append(s.Pending, *r)
+	}
+	for _, r := range p.InFlight {
+		s.InFlight = append(s.InFlight, stepRequest{Request: r.request, Sent: r.send, Received: r.received, Peers: r.peers})
+	}
+	return s
+}
+
+// execute carries out the action a decided for the active piece p, whose
+// lock the caller holds, by a step given piece. Requests sent are counted
+// in outstanding. Requests that are no longer in the state the step saw
+// are skipped. The sent and the timed out requests are set to nil.
+func (t *TorrentSession) execute(p *pendingPiece, piece stepPiece, a stepAction, seeders map[string]*peer.Peer, outstanding map[string]int) {
+	switch a.Kind {
+	case actionTimeout:
+		i := slices.IndexFunc(p.InFlight, func(r *timedDownloadRequest) bool {
+			return r != nil && !r.received && r.request == a.Request
+		})
+		if i < 0 {
+			return
+		}
+		for _, addr := range p.InFlight[i].peers {
+			if s, ok := t.peers.stats.Load(addr); ok {
+				s.(*peerStats).timeouts.Add(1)
+				s.(*peerStats).reputation.add(t.now(), -reputationTimeout)
+			}
+		}
+		for _, s := range seeders {
+			if s.Status.Remote.Load() != uint32(peer.UnChoked) {
+				continue
+			}
+			if err := s.SendCancel(&messagesv1.Cancel{Index: a.Request.Index, Begin: a.Request.Begin, Length: a.Request.Length}); err != nil {
+				t.logger.Error("failed to cancel request",
+					slog.Any("err", err),
+					slog.String("end_peer", s.Id),
+					slog.String("req", fmt.Sprintf("%#v", a.Request)),
+				)
+			}
+		}
+		req := a.Request
+		p.Pending = append(p.Pending, &req)
+		p.timedOut = append(p.timedOut, p.InFlight[i])
+		p.InFlight[i] = nil
+
+	case actionRequest:
+		i := slices.IndexFunc(p.Pending, func(r *messagesv1.Request) bool { return r != nil && *r == a.Request })
+		if i < 0 {
+			return
+		}
+		req := p.Pending[i]
+
+		chosen := seeders[a.Peer]
+		if chosen == nil {
+			// web seeds are only used if no peer can serve the request.
+			if w := t.pickWebSeed(*req); w != nil {
+				t.logger.Debug("sending request for piece to web seed",
+					slog.String("web_seed", w.url),
+					slog.String("req", fmt.Sprintf("%#v", req)),
+				)
+				p.Pending[i] = nil
+				p.InFlight = append(p.InFlight, &timedDownloadRequest{request: *req, send: t.now(), peers: []string{w.url}})
+				return
+			}
+			switch {
+			case piece.Fallback:
+				t.logger.Debug("web seeds are busy, waiting to re-request failed piece", slog.Int64("piece", a.Piece))
+			case a.Candidates == 0:
+				t.logger.Debug("no peers online that contain needed piece",
+					slog.Int64("piece", a.Piece),
+					slog.String("req", fmt.Sprintf("%#v", req)),
+				)
+			default:
+				t.logger.Debug("all peers that contain needed piece are snubbed and probed", slog.Int64("piece", a.Piece))
+			}
+			return
+		}
+
+		if t.sampleBlock(t.logger, &t.blockLog.requested, edgeBlock(*req, p.Size)) {
+			t.logger.Debug("sending request for piece",
+				slog.String("end_peer", chosen.Id),
+				slog.String("req", fmt.Sprintf("%#v", req)),
+			)
+		}
+		// read before queueing, a choke received meanwhile discards the request.
+		chokes := chosen.ChokeCount()
+		if err := chosen.QueueRequest(req); err != nil {
+			if errors.Is(err, peer.ErrChoked) {
+				t.logger.Debug("peer choked before request was sent", slog.String("end_peer", chosen.Id))
+				return
+			}
+			if errors.Is(err, peer.ErrQueueFull) {
+				// the peer does not keep up, it is at capacity until its queue drains.
+				t.logger.Debug("request queue of peer is full", slog.String("end_peer", chosen.Id))
+				return
+			}
+			t.logger.Error("failed to issue request",
+				slog.Any("err", err),
+				slog.String("end_peer", chosen.Id),
+				slog.String("req", fmt.Sprintf("%#v", req)),
+			)
+			return
+		}
+		if outstanding[chosen.Addr] == 0 {
+			// start measuring the time since the last block from now on.
+			t.statsFor(chosen.Addr).lastBlock.Store(t.now().UnixNano())
+		}
+		outstanding[chosen.Addr]++
+
+		p.Pending[i] = nil
+		p.InFlight = append(p.InFlight, &timedDownloadRequest{
+			request: *req,
+			send:    t.now(),
+			peers:   []string{chosen.Addr},
+			chokes:  []uint64{chokes},
+		})
+	}
+}
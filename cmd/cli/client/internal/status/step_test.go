@@ -0,0 +1,200 @@
+package status
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+// pieces returns a function reporting whether a piece is one of pieces.
+func pieces(pieces ...int64) func(int64) bool {
+	return func(i int64) bool { return slices.Contains(pieces, i) }
+}
+
+// leastOutstanding picks the first candidate with the fewest outstanding requests.
+var leastOutstanding = pickerFunc(func(candidates []PeerCandidate) int {
+	best := 0
+	for i, c := range candidates {
+		if c.Outstanding < candidates[best].Outstanding {
+			best = i
+		}
+	}
+	return best
+})
+
+// block returns the request of the nth block of the piece.
+func block(piece int64, n uint32) messagesv1.Request {
+	return messagesv1.Request{Index: uint32(piece), Begin: n * messagesv1.RequestSize, Length: messagesv1.RequestSize}
+}
+
+func TestStep(t *testing.T) {
+	now := time.Now()
+	seed := func(addr string) stepPeer {
+		return stepPeer{Addr: addr, Unchoked: true, Has: pieces(0, 1, 2, 3), AllowedFast: pieces(), Suggested: pieces()}
+	}
+	choked := stepPeer{Addr: "choked", Has: pieces(0, 1, 2, 3), AllowedFast: pieces(1), Suggested: pieces()}
+	snubbed := seed("snubbed")
+	snubbed.Snubbed = true
+
+	tests := []struct {
+		name string
+		in   stepInput
+		want []stepAction
+	}{
+		{
+			name: "requests are spread over the peers",
+			in: stepInput{
+				Now:         now,
+				Peers:       []stepPeer{seed("a"), seed("b")},
+				Pieces:      []stepPiece{{Index: 0, Pending: []messagesv1.Request{block(0, 0), block(0, 1), block(0, 2)}}},
+				Outstanding: map[string]int{"a": 1},
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 0, Request: block(0, 0), Peer: "b"},
+				{Kind: actionRequest, Piece: 0, Request: block(0, 1), Peer: "a"},
+				{Kind: actionRequest, Piece: 0, Request: block(0, 2), Peer: "b"},
+			},
+		},
+		{
+			name: "requests beyond MaxPipeline are left pending",
+			in: stepInput{
+				Now:         now,
+				Peers:       []stepPeer{seed("a")},
+				Pieces:      []stepPiece{{Index: 0, Pending: []messagesv1.Request{block(0, 0), block(0, 1)}}},
+				Outstanding: map[string]int{"a": 1},
+				MaxPipeline: 2,
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 0, Request: block(0, 0), Peer: "a"},
+				{Kind: actionRequest, Piece: 0, Request: block(0, 1)},
+			},
+		},
+		{
+			name: "choked peers serve allowed fast pieces only",
+			in: stepInput{
+				Now:   now,
+				Peers: []stepPeer{choked},
+				Pieces: []stepPiece{
+					{Index: 0, Pending: []messagesv1.Request{block(0, 0)}},
+					{Index: 1, Pending: []messagesv1.Request{block(1, 0)}},
+				},
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 0, Request: block(0, 0)},
+				{Kind: actionRequest, Piece: 1, Request: block(1, 0), Peer: "choked"},
+			},
+		},
+		{
+			name: "snubbed peers are probed with a single request",
+			in: stepInput{
+				Now:    now,
+				Peers:  []stepPeer{snubbed},
+				Pieces: []stepPiece{{Index: 2, Pending: []messagesv1.Request{block(2, 0), block(2, 1)}}},
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 2, Request: block(2, 0), Peer: "snubbed"},
+				{Kind: actionRequest, Piece: 2, Request: block(2, 1), Candidates: 1},
+			},
+		},
+		{
+			name: "snubbed peers are avoided",
+			in: stepInput{
+				Now:         now,
+				Peers:       []stepPeer{snubbed, seed("a")},
+				Pieces:      []stepPiece{{Index: 2, Pending: []messagesv1.Request{block(2, 0)}}},
+				Outstanding: map[string]int{"a": 10},
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 2, Request: block(2, 0), Peer: "a"},
+			},
+		},
+		{
+			name: "timed out requests are sent again",
+			in: stepInput{
+				Now:   now,
+				Peers: []stepPeer{seed("a"), seed("b")},
+				Pieces: []stepPiece{{Index: 3, InFlight: []stepRequest{
+					{Request: block(3, 0), Sent: now.Add(-requestTimeout - time.Second), Peers: []string{"a"}},
+					{Request: block(3, 1), Sent: now.Add(-requestTimeout - time.Second), Peers: []string{"a"}, Received: true},
+					{Request: block(3, 2), Sent: now, Peers: []string{"a"}},
+				}}},
+				Outstanding: map[string]int{"a": 2},
+			},
+			want: []stepAction{
+				{Kind: actionTimeout, Piece: 3, Request: block(3, 0)},
+				{Kind: actionRequest, Piece: 3, Request: block(3, 0), Peer: "b"},
+			},
+		},
+		{
+			name: "failing pieces are left to web seeds",
+			in: stepInput{
+				Now:    now,
+				Peers:  []stepPeer{seed("a")},
+				Pieces: []stepPiece{{Index: 0, Pending: []messagesv1.Request{block(0, 0)}, Fallback: true}},
+			},
+			want: []stepAction{
+				{Kind: actionRequest, Piece: 0, Request: block(0, 0)},
+			},
+		},
+		{
+			name: "pieces served right away are started first",
+			in: stepInput{
+				Now: now,
+				Peers: []stepPeer{
+					{Addr: "a", Has: pieces(6), AllowedFast: pieces(), Suggested: pieces()},
+					{Addr: "b", Unchoked: true, Has: pieces(7), AllowedFast: pieces(), Suggested: pieces()},
+				},
+				Missing:  slices.Values([]int64{5, 6, 7}),
+				FreeSlot: true,
+			},
+			want: []stepAction{{Kind: actionStart, Piece: 7, Ready: true}},
+		},
+		{
+			name: "pieces of choked peers are started",
+			in: stepInput{
+				Now:      now,
+				Peers:    []stepPeer{{Addr: "a", Has: pieces(6, 7), AllowedFast: pieces(), Suggested: pieces()}},
+				Missing:  slices.Values([]int64{5, 6, 7}),
+				FreeSlot: true,
+			},
+			want: []stepAction{{Kind: actionStart, Piece: 6}},
+		},
+		{
+			name: "web seeds have all pieces",
+			in: stepInput{
+				Now:      now,
+				Missing:  slices.Values([]int64{5, 6, 7}),
+				FreeSlot: true,
+				WebSeed:  true,
+			},
+			want: []stepAction{{Kind: actionStart, Piece: 5}},
+		},
+		{
+			name: "no piece is started without a source",
+			in: stepInput{
+				Now:      now,
+				Missing:  slices.Values([]int64{5, 6, 7}),
+				FreeSlot: true,
+			},
+		},
+		{
+			name: "no piece is started without a free slot",
+			in: stepInput{
+				Now:     now,
+				Peers:   []stepPeer{seed("a")},
+				Missing: slices.Values([]int64{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outstanding := maps.Clone(tt.in.Outstanding)
+			assert.Equal(t, tt.want, step(tt.in, leastOutstanding))
+			assert.Equal(t, outstanding, tt.in.Outstanding, "input was modified")
+		})
+	}
+}
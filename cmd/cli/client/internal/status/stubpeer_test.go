@@ -0,0 +1,243 @@
+package status
+
+import (
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubSeeder is a remote peer that has all pieces of data and serves
+// the requests it receives, unless it chokes this client.
+type stubSeeder struct {
+	addr string
+
+	// chokeAfter chokes this client after receiving that many
+	// requests, which are never answered, if positive.
+	chokeAfter int
+	// unchoke is closed once the stub should unchoke this client.
+	unchoke chan struct{}
+
+	// corrupt reports whether the block requested by req
+	// is served corrupted, if set.
+	corrupt func(req messagesv1.Request) bool
+	// allowedFast are the pieces the stub allows this client
+	// to request while choked, the only ones it serves then.
+	allowedFast []uint32
+	// pieces are the pieces the stub announces in its bitfield, all
+	// of them if nil. Requests for the others are still served.
+	pieces []int64
+	// ignore reports whether the request req is never
+	// answered, if set. Called from a single goroutine.
+	ignore func(req messagesv1.Request) bool
+
+	l        sync.Mutex
+	requests []messagesv1.Request
+	cancels  []messagesv1.Cancel
+}
+
+func newStubSeeder(t *testing.T, pieceLength int64, data []byte, chokeAfter int, unchoked bool, opts ...func(*stubSeeder)) *stubSeeder {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &stubSeeder{addr: ln.Addr().String(), chokeAfter: chokeAfter, unchoke: make(chan struct{})}
+	if unchoked {
+		close(s.unchoke)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		s.serve(conn, pieceLength, data)
+	}()
+	return s
+}
+
+func (s *stubSeeder) received() []messagesv1.Request {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]messagesv1.Request(nil), s.requests...)
+}
+
+func (s *stubSeeder) canceled() []messagesv1.Cancel {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]messagesv1.Cancel(nil), s.cancels...)
+}
+
+func (s *stubSeeder) serve(conn net.Conn, pieceLength int64, data []byte) {
+	var hs [messagesv1.HandshakeLength]byte
+	if _, err := io.ReadFull(conn, hs[:]); err != nil {
+		return
+	}
+	h := new(messagesv1.Handshake)
+	if err := h.Deserialize(hs[:]); err != nil {
+		return
+	}
+	h.PeerID = "-ST0001-000000000000"
+
+	numPieces := (int64(len(data)) + pieceLength - 1) / pieceLength
+	b := bitfield.NewBitfield(numPieces)
+	for i := range numPieces {
+		if s.pieces == nil || slices.Contains(s.pieces, i) {
+			b.Set(i)
+		}
+	}
+
+	var mu sync.Mutex
+	write := func(msg []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.Write(msg)
+	}
+
+	write(h.Serialize())
+	write((&messagesv1.Bitfield{Bitfield: b.Clone()}).Serialize())
+	for _, i := range s.allowedFast {
+		write((&messagesv1.AllowedFast{Index: i}).Serialize())
+	}
+	go func() {
+		<-s.unchoke
+		write(messagesv1.Unchoke{}.Serialize())
+	}()
+
+	choked := false
+	for {
+		msg, err := messagesv1.Identify(conn)
+		if err != nil {
+			return
+		}
+		if msg.Type == messagesv1.CancelType {
+			c := new(messagesv1.Cancel)
+			if err := c.Deserialize(msg.Payload); err != nil {
+				return
+			}
+			s.l.Lock()
+			s.cancels = append(s.cancels, *c)
+			s.l.Unlock()
+			continue
+		}
+		if msg.Type != messagesv1.RequestType {
+			continue
+		}
+		req := new(messagesv1.Request)
+		if err := req.Deserialize(msg.Payload); err != nil {
+			return
+		}
+
+		s.l.Lock()
+		s.requests = append(s.requests, *req)
+		count := len(s.requests)
+		s.l.Unlock()
+
+		if s.chokeAfter > 0 {
+			if !choked && count >= s.chokeAfter {
+				choked = true
+				write(messagesv1.Choke{}.Serialize())
+			}
+			continue // never answers.
+		}
+
+		select {
+		case <-s.unchoke:
+		default:
+			if !slices.Contains(s.allowedFast, req.Index) {
+				continue
+			}
+		}
+		if s.ignore != nil && s.ignore(*req) {
+			continue
+		}
+
+		start := int64(req.Index)*pieceLength + int64(req.Begin)
+		block := data[start : start+int64(req.Length)]
+		if s.corrupt != nil && s.corrupt(*req) {
+			block = append([]byte(nil), block...)
+			block[0] ^= 0xff
+		}
+		write((&messagesv1.Piece{
+			Index: req.Index,
+			Begin: req.Begin,
+			Block: block,
+		}).Serialize())
+	}
+}
+
+// connectStubLeecher connects a remote leecher to tr that is interested
+// and unchoked, and returns its connection and the peer tr sees.
+func connectStubLeecher(t *testing.T, tr *TorrentSession) (net.Conn, *peer.Peer) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	accepted, err := ln.Accept()
+	assert.NoError(t, err)
+	assert.NoError(t, tr.AddLeecher("-ST0001-000000000000", accepted))
+
+	// the handshake and bitfield of the tracker.
+	var hs [messagesv1.HandshakeLength]byte
+	_, err = io.ReadFull(conn, hs[:])
+	assert.NoError(t, err)
+	msg, err := messagesv1.Identify(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, messagesv1.BitfieldType, msg.Type)
+
+	_, err = conn.Write(messagesv1.Interest{}.Serialize())
+	assert.NoError(t, err)
+	var leecher *peer.Peer
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.leechers.Load(accepted.RemoteAddr().String())
+		if ok {
+			leecher = v.(*peer.Peer)
+		}
+		return ok && leecher.Interest.Remote.Load() == uint32(peer.Interested)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, leecher.SendUnchoke())
+	msg, err = messagesv1.Identify(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, messagesv1.UnChokeType, msg.Type)
+	return conn, leecher
+}
+
+// readPieces reads n piece messages from the connection of a stub leecher.
+func readPieces(t *testing.T, conn net.Conn, n int) []messagesv1.Piece {
+	t.Helper()
+
+	var served []messagesv1.Piece
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(served) < n {
+		msg, err := messagesv1.Identify(conn)
+		if !assert.NoError(t, err) {
+			break
+		}
+		if msg.Type != messagesv1.PieceType {
+			continue
+		}
+		p := new(messagesv1.Piece)
+		assert.NoError(t, p.Deserialize(msg.Payload))
+		served = append(served, *p)
+	}
+	return served
+}
@@ -0,0 +1,110 @@
+package status
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/peer"
+)
+
+// suggestionTTL is how long a piece suggested by a seeder is preferred,
+// as it is likely no longer cached by the seeder after a while.
+const suggestionTTL = 30 * time.Second
+
+// maxSuggestions is the number of cached pieces suggested to a leecher
+// once it is unchoked.
+const maxSuggestions = 4
+
+// suggestions are the pieces a seeder suggested, see BEP6, with the
+// time each was suggested at.
+type suggestions struct {
+	l  sync.Mutex
+	at map[int64]time.Time
+}
+
+// suggestHandler returns the option that records the pieces
+// suggested by the seeder at addr, see peer.WithSuggestHandler.
+func (t *TorrentSession) suggestHandler(addr string) peer.Option {
+	return peer.WithSuggestHandler(func(piece int64) { t.recordSuggestion(addr, piece) })
+}
+
+// recordSuggestion records that the seeder at addr suggested the piece now.
+func (t *TorrentSession) recordSuggestion(addr string, piece int64) {
+	v, _ := t.peers.suggestions.LoadOrStore(addr, &suggestions{at: make(map[int64]time.Time)})
+	s := v.(*suggestions)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.at[piece] = t.now()
+}
+
+// suggestionsOf returns the pieces the seeder at addr suggested
+// within the suggestionTTL, sorted. Expired ones are dropped.
+func (t *TorrentSession) suggestionsOf(addr string) []int64 {
+	v, ok := t.peers.suggestions.Load(addr)
+	if !ok {
+		return nil
+	}
+	s := v.(*suggestions)
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	var out []int64
+	now := t.now()
+	for piece, at := range s.at {
+		if now.Sub(at) >= suggestionTTL {
+			delete(s.at, piece)
+			continue
+		}
+		out = append(out, piece)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// suggestedPiece returns the piece suggested by an unchoked peer with spare
+// capacity that is at most as rare as the piece at index, which nextPiece
+// would start otherwise, or -1 if there is none. The rarest one is returned.
+func suggestedPiece(in stepInput, index int64) int64 {
+	if in.Startable == nil || in.Availability == nil {
+		return -1
+	}
+	best, rarest := int64(-1), in.Availability(index)
+	for _, p := range in.Peers {
+		if !p.Unchoked || in.Outstanding[p.Addr] >= pipelineDepth(p.Rate) {
+			continue
+		}
+		for _, i := range p.Suggestions {
+			if !p.Has(i) || !in.Startable(i) {
+				continue
+			}
+			if a := in.Availability(i); a < rarest || (a == rarest && best < 0) {
+				best, rarest = i, a
+			}
+		}
+	}
+	return best
+}
+
+// unchokeLeecher unchokes the leecher p and suggests it the pieces
+// most recently read into the piece cache which it is missing, if it
+// supports the Fast Extension, as they are served without a disk read.
+func (t *TorrentSession) unchokeLeecher(p *peer.Peer) error {
+	if err := p.SendUnchoke(); err != nil {
+		return err
+	}
+	if t.cached == nil || !p.FastExtension() {
+		return nil
+	}
+	for _, i := range t.cached.Recent(maxSuggestions) {
+		if p.Bitfield.Check(i) {
+			continue
+		}
+		if err := p.SendSuggestPiece(i); err != nil {
+			t.logger.Debug("failed to suggest piece", slog.String("end_peer", p.Addr), slog.Any("err", err))
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package status
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTorrentSession_SuggestionsExpire(t *testing.T) {
+	tr := newTestTracker(t, 1, []byte{0x1}, []byte{0x2}, []byte{0x3})
+	clock := newFakeClock()
+	WithClock(clock)(tr)
+
+	tr.recordSuggestion("a", 2)
+	clock.Advance(suggestionTTL / 2)
+	tr.recordSuggestion("a", 0)
+	tr.recordSuggestion("b", 1)
+	assert.Equal(t, []int64{0, 2}, tr.suggestionsOf("a"))
+
+	clock.Advance(suggestionTTL / 2)
+	assert.Equal(t, []int64{0}, tr.suggestionsOf("a"))
+
+	// suggesting a piece again renews it.
+	tr.recordSuggestion("b", 1)
+	clock.Advance(suggestionTTL / 2)
+	assert.Equal(t, []int64{1}, tr.suggestionsOf("b"))
+	assert.Empty(t, tr.suggestionsOf("a"))
+	assert.Empty(t, tr.suggestionsOf("c"))
+}
+
+func TestNextPiece_Suggestions(t *testing.T) {
+	availability := map[int64]int{5: 2, 6: 2, 7: 1, 8: 3}
+	suggesting := func(suggestions ...int64) stepPeer {
+		return stepPeer{
+			Addr:        "a",
+			Unchoked:    true,
+			Has:         pieces(5, 6, 7, 8),
+			AllowedFast: pieces(),
+			Suggested:   pieces(suggestions...),
+			Suggestions: suggestions,
+		}
+	}
+	input := func(peers ...stepPeer) stepInput {
+		return stepInput{
+			Peers:        peers,
+			Missing:      slices.Values([]int64{5, 6, 7, 8}),
+			FreeSlot:     true,
+			Startable:    pieces(5, 6, 7, 8),
+			Availability: func(i int64) int { return availability[i] },
+		}
+	}
+
+	tests := []struct {
+		name string
+		in   stepInput
+		want int64
+	}{
+		{name: "equally rare suggestion wins the tie", in: input(suggesting(6)), want: 6},
+		{name: "rarer suggestion is preferred", in: input(suggesting(6, 7)), want: 7},
+		{name: "more common suggestion is ignored", in: input(suggesting(8)), want: 5},
+		{name: "started suggestion is ignored", in: func() stepInput {
+			in := input(suggesting(6))
+			in.Startable = pieces(5, 7, 8)
+			return in
+		}(), want: 5},
+		{name: "suggestion of choked peer is ignored", in: func() stepInput {
+			choked := suggesting(6)
+			choked.Addr, choked.Unchoked = "b", false
+			unchoked := suggesting()
+			return input(unchoked, choked)
+		}(), want: 5},
+		{name: "suggestion of busy peer is ignored", in: func() stepInput {
+			in := input(suggesting(6))
+			in.Outstanding = map[string]int{"a": pipelineDepth(0)}
+			return in
+		}(), want: 5},
+		{name: "suggestions are ignored without availability", in: func() stepInput {
+			in := input(suggesting(6))
+			in.Availability = nil
+			return in
+		}(), want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, []stepAction{{Kind: actionStart, Piece: tt.want, Ready: true}}, step(tt.in, leastOutstanding))
+		})
+	}
+}
+
+func TestTracker_SuggestsCachedPiecesOnUnchoke(t *testing.T) {
+	tr, _ := newUploadFixture(t, 4)
+	tr.cache = storage.NewPieceCache(storage.DefaultCacheSize)
+	tr.cached = tr.cache.Wrap(tr.storage, tr.meta.PieceSize)
+	tr.storage = tr.cached
+	for _, i := range []int64{3, 1, 2} {
+		_, err := tr.cached.ReadBlock(i, 0, messagesv1.RequestSize)
+		assert.NoError(t, err)
+	}
+
+	client, remote := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	added := make(chan error, 1)
+	go func() { added <- tr.AddLeecher("-ST0001-000000000000", remote, peer.WithFastExtension()) }()
+
+	var hs [messagesv1.HandshakeLength]byte
+	_, err := io.ReadFull(client, hs[:])
+	assert.NoError(t, err)
+	var h messagesv1.Handshake
+	assert.NoError(t, h.Deserialize(hs[:]))
+	assert.True(t, h.FastExtension())
+	msg, err := messagesv1.Identify(client)
+	assert.NoError(t, err)
+	assert.Equal(t, messagesv1.BitfieldType, msg.Type)
+	assert.NoError(t, <-added)
+
+	// the leecher already has the piece most recently cached.
+	_, err = client.Write((&messagesv1.Bitfield{Bitfield: []byte{0b0010_0000}}).Serialize())
+	assert.NoError(t, err)
+	_, err = client.Write(messagesv1.Interest{}.Serialize())
+	assert.NoError(t, err)
+	var leecher *peer.Peer
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.leechers.Load(remote.RemoteAddr().String())
+		if ok {
+			leecher = v.(*peer.Peer)
+		}
+		return ok && leecher.Interest.Remote.Load() == uint32(peer.Interested)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	unchoked := make(chan error, 1)
+	go func() { unchoked <- tr.unchokeLeecher(leecher) }()
+
+	msg, err = messagesv1.Identify(client)
+	assert.NoError(t, err)
+	assert.Equal(t, messagesv1.UnChokeType, msg.Type)
+	var suggested []uint32
+	for range 2 {
+		msg, err = messagesv1.Identify(client)
+		assert.NoError(t, err)
+		assert.Equal(t, messagesv1.SuggestPieceType, msg.Type)
+		s := new(messagesv1.SuggestPiece)
+		assert.NoError(t, s.Deserialize(msg.Payload))
+		suggested = append(suggested, s.Index)
+	}
+	assert.Equal(t, []uint32{1, 3}, suggested)
+	assert.NoError(t, <-unchoked)
+}
@@ -0,0 +1,155 @@
+package status
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// tailLength is the size of the last piece of the tail fixture,
+// which is a single block much shorter than messagesv1.RequestSize.
+const tailLength = 13
+
+// newTailFixture returns the data of a torrent whose last piece
+// is a block of tailLength bytes, and the pieces it is split into.
+func newTailFixture() (pieceLength int64, data []byte, pieces [][]byte) {
+	pieceLength = 2 * messagesv1.RequestSize
+	data = make([]byte, 3*pieceLength+tailLength)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	for i := int64(0); i < int64(len(data)); i += pieceLength {
+		pieces = append(pieces, data[i:min(i+pieceLength, int64(len(data)))])
+	}
+	return pieceLength, data, pieces
+}
+
+func assertPieces(t *testing.T, tr *TorrentSession, pieces [][]byte) {
+	t.Helper()
+	assert.Empty(t, tr.have.MissingPieces())
+	for i, p := range pieces {
+		got, err := tr.ReadRequest(&messagesv1.Request{Index: uint32(i), Length: uint32(len(p))})
+		assert.NoError(t, err)
+		assert.Equal(t, p, got, "piece %v", i)
+	}
+}
+
+func TestTracker_SubBlockTailFromPeer(t *testing.T) {
+	pieceLength, data, pieces := newTailFixture()
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	seeder := newStubSeeder(t, pieceLength, data, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent with a sub block tail was not downloaded")
+	}
+	tr.CancelDownload()
+
+	assertPieces(t, tr, pieces)
+	assert.Equal(t, int64(len(data)), tr.downloaded.Load())
+	assert.Zero(t, tr.WasteStats().Total())
+
+	last := uint32(len(pieces) - 1)
+	for _, r := range seeder.received() {
+		if r.Index == last {
+			assert.Equal(t, messagesv1.Request{Index: last, Begin: 0, Length: tailLength}, r)
+		}
+	}
+}
+
+func TestTracker_SubBlockTailFromWebSeed(t *testing.T) {
+	pieceLength, data, pieces := newTailFixture()
+	tr := newTestTracker(t, pieceLength, pieces...)
+
+	var (
+		l      sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		l.Unlock()
+		http.ServeContent(rw, r, "test.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	w := newWebSeed(srv.URL+"/test.bin", srv.Client())
+	tr.webSeeds = append(tr.webSeeds, w)
+	tr.download.wg.Add(2)
+	go tr.runWebSeed(w)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent with a sub block tail was not downloaded from web seed")
+	}
+	tr.download.wg.Wait()
+
+	assertPieces(t, tr, pieces)
+
+	l.Lock()
+	defer l.Unlock()
+	// every byte is fetched exactly once, nothing past the end of the data.
+	assert.Len(t, ranges, 3*int(pieceLength)/messagesv1.RequestSize+1)
+	assert.Contains(t, ranges, fmt.Sprintf("bytes=%d-%d", 3*pieceLength, len(data)-1))
+}
+
+func TestTracker_SubBlockTailUpload(t *testing.T) {
+	pieceLength, _, pieces := newTailFixture()
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	for i, p := range pieces {
+		assert.NoError(t, tr.Flush(int64(i), p))
+		tr.have.Set(int64(i))
+	}
+
+	conn, _ := connectStubLeecher(t, tr)
+	tr.upload.wg.Add(1)
+	go tr.processUploadRequests()
+	t.Cleanup(tr.CancelUpload)
+
+	last := uint32(len(pieces) - 1)
+	requests := []messagesv1.Request{
+		// reach past the end of the data and are never served.
+		{Index: last, Begin: 0, Length: messagesv1.RequestSize},
+		{Index: last, Begin: tailLength, Length: 1},
+		{Index: last, Begin: 1, Length: tailLength},
+		// served in full.
+		{Index: last, Begin: 0, Length: tailLength},
+		{Index: last, Begin: tailLength - 3, Length: 3},
+	}
+	for _, r := range requests {
+		_, err := conn.Write(r.Serialize())
+		assert.NoError(t, err)
+	}
+
+	served := readPieces(t, conn, 2)
+	assert.ElementsMatch(t, []messagesv1.Piece{
+		{Index: last, Begin: 0, Block: pieces[last]},
+		{Index: last, Begin: tailLength - 3, Block: pieces[last][tailLength-3:]},
+	}, served)
+	assert.Eventually(t, func() bool {
+		return tr.uploaded.Load() == tailLength+3
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -1,24 +1,101 @@
 package status
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
 	"github.com/Despire/tinytorrent/p2p/peer"
 )
 
-func (t *Tracker) CancelUpload() { close(t.upload.cancel); t.upload.wg.Wait() }
+// Bounds of the requests of a single leecher waiting to be served,
+// so that a peer cannot occupy all of the upload slots.
+const (
+	maxQueuedRequests = 64
+	maxQueuedBytes    = 1 << 20
+)
+
+// CancelUpload stops serving the leechers, it must be called once.
+func (t *TorrentSession) CancelUpload() { close(t.upload.cancel); t.upload.wg.Wait() }
+
+func (t *TorrentSession) WaitUntilSeeded() <-chan struct{} { return t.upload.seeded }
+
+// seedGoalsReached reports whether seeding, which started at
+// the passed time, reached any of the configured goals.
+func (t *TorrentSession) seedGoalsReached(since time.Time) bool {
+	if r := t.upload.seedRatio; r > 0 {
+		if float64(t.ShareStats().Uploaded) >= r*float64(t.meta.BytesToDownload()) {
+			return true
+		}
+	}
+	if d := t.upload.seedTime; d > 0 && t.since(since) >= d {
+		return true
+	}
+	return false
+}
+
+// watchSeedGoals closes the seeded channel once the torrent was
+// downloaded and any of the configured seeding goals is reached.
+func (t *TorrentSession) watchSeedGoals() {
+	defer t.upload.wg.Done()
+
+	select {
+	case <-t.stop:
+		return
+	case <-t.upload.cancel:
+		return
+	case <-t.download.completed:
+	}
+
+	if t.upload.seedRatio <= 0 && t.upload.seedTime <= 0 {
+		return // seed until the torrent is removed.
+	}
+
+	t.logger.Info("torrent downloaded, seeding until goals are reached",
+		slog.String("ratio", fmt.Sprint(t.upload.seedRatio)),
+		slog.String("time", t.upload.seedTime.String()),
+	)
 
-func (t *Tracker) AddLeecher(id string, conn net.Conn) error {
+	since := t.now()
+	check := t.newTicker(rateTick)
+	defer check.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.upload.cancel:
+			return
+		case <-check.C():
+			if t.seedGoalsReached(since) {
+				t.logger.Info("seeding goals reached")
+				close(t.upload.seeded)
+				return
+			}
+		}
+	}
+}
+
+// AddLeecher serves the pieces to the leecher that connected with conn
+// and handshaked with the peer id. The options configure the connection,
+// e.g. to negotiate the Fast Extension the leecher announced.
+func (t *TorrentSession) AddLeecher(id string, conn net.Conn, opts ...peer.Option) error {
+	if t.paused.Load() {
+		return errPausedLeecher
+	}
+	if t.rejectBannedID(conn.RemoteAddr().String(), id) {
+		return errBannedLeecher
+	}
 	np, err := peer.NewLeecherConnection(
 		t.logger,
 		id, conn.RemoteAddr().String(),
-		t.Torrent.NumPieces(),
+		t.meta.NumPieces(),
 		conn,
-		string(t.Torrent.Metadata.Hash[:]), t.clientID,
+		string(t.meta.Metadata.Hash[:]), t.clientID,
+		append(t.peerOptions(), opts...)...,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to establish leecher connection")
@@ -26,8 +103,9 @@ func (t *Tracker) AddLeecher(id string, conn net.Conn) error {
 
 	t.peers.leechers.Delete(conn.RemoteAddr().String())
 	t.peers.leechers.Store(conn.RemoteAddr().String(), np)
+	t.peers.uploads.Store(conn.RemoteAddr().String(), new(atomic.Int64))
 
-	if err := np.SendBitfield(t.BitField.Clone()); err != nil {
+	if err := np.SendBitfield(t.have.Clone()); err != nil {
 		t.peers.leechers.Delete(conn.RemoteAddr().String())
 		return fmt.Errorf("failed to send bitfield: %w", err)
 	}
@@ -41,10 +119,8 @@ func (t *Tracker) AddLeecher(id string, conn net.Conn) error {
 	return nil
 }
 
-func (t *Tracker) processUploadRequests() {
+func (t *TorrentSession) processUploadRequests() {
 	defer t.upload.wg.Done()
-	currentRate := int64(0)
-	rateTicker := time.NewTicker(rateTick)
 	for {
 		select {
 		case <-t.stop:
@@ -53,52 +129,103 @@ func (t *Tracker) processUploadRequests() {
 		case <-t.upload.cancel:
 			t.logger.Info("shutting down piece uploader, canceled upload")
 			return
-		case <-rateTicker.C:
-			newRate := t.Uploaded.Load()
-			diff := max(0, newRate-currentRate)
-			t.upload.rate.Store(diff)
-			currentRate = newRate
 		default:
 			for i := range t.upload.requests {
-				req := t.upload.requests[i].Load()
-				if req == nil {
-					continue
+				if req := t.upload.requests[i].Load(); req != nil {
+					t.serveUploadRequest(i, req)
 				}
-				t.peers.leechers.Range(func(key, value any) bool {
-					if key.(string) == req.addr {
-						p := value.(*peer.Peer)
-
-						b, err := t.ReadRequest(&req.request)
-						if err != nil {
-							t.upload.requests[i].CompareAndSwap(req, nil)
-							return false
-						}
-						err = p.SendPiece(&messagesv1.Piece{
-							Index: req.request.Index,
-							Begin: req.request.Begin,
-							Block: b,
-						})
-						if err != nil {
-							t.upload.requests[i].CompareAndSwap(req, nil)
-							return false
-						}
-						t.upload.requests[i].CompareAndSwap(req, nil)
-
-						newUpload := t.Uploaded.Add(int64(len(b)))
-						t.logger.Debug("uploaded piece",
-							slog.String("piece", fmt.Sprint(req.request.Index)),
-							slog.String("uploaded_bytes", fmt.Sprint(newUpload)),
-						)
-						return false
-					}
-					return true
-				})
 			}
 		}
 	}
 }
 
-func (t *Tracker) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Request, cancels <-chan *messagesv1.Cancel) {
+// serveUploadRequest sends the block requested by req, stored in the
+// passed slot, to its leecher. The slot is freed before the block is
+// read, a request canceled by then is no longer served.
+func (t *TorrentSession) serveUploadRequest(slot int, req *timedUploadRequest) {
+	v, ok := t.peers.leechers.Load(req.addr)
+	if !ok {
+		t.dropUploadRequests(req.addr)
+		return
+	}
+	p := v.(*peer.Peer)
+	if p.ConnectionStatus() != peer.ConnectionEstablished || p.Status.This.Load() == uint32(peer.Choked) {
+		// choking a peer discards its requests.
+		t.dropUploadRequests(req.addr)
+		return
+	}
+	if !t.upload.requests[slot].CompareAndSwap(req, nil) {
+		return
+	}
+
+	b, err := t.ReadRequest(&req.request)
+	if err != nil {
+		t.logger.Debug("failed to read requested block", slog.String("peer_ip", req.addr), slog.Any("err", err))
+		return
+	}
+	err = p.SendPiece(&messagesv1.Piece{
+		Index: req.request.Index,
+		Begin: req.request.Begin,
+		Block: b,
+	})
+	if errors.Is(err, peer.ErrWriteTimeout) {
+		t.logger.Debug("leecher stopped accepting pieces, dropping its requests", slog.String("peer_ip", req.addr))
+		t.dropUploadRequests(req.addr)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	n := int64(len(b))
+	newUpload := t.uploaded.Add(n)
+	if s, ok := t.peers.uploads.Load(req.addr); ok {
+		s.(*atomic.Int64).Add(n)
+	}
+	t.upload.rate.add(n, t.now())
+	if t.sampleBlock(t.logger, &t.blockLog.uploaded, edgeBlock(req.request, t.meta.PieceSize(int64(req.request.Index)))) {
+		t.logger.Debug("uploaded piece",
+			slog.String("piece", fmt.Sprint(req.request.Index)),
+			slog.String("uploaded_bytes", fmt.Sprint(newUpload)),
+		)
+	}
+}
+
+// dropUploadRequests frees the slots of the requests
+// of the leecher at addr, which can no longer be served.
+func (t *TorrentSession) dropUploadRequests(addr string) {
+	for i := range t.upload.requests {
+		if req := t.upload.requests[i].Load(); req != nil && req.addr == addr {
+			t.upload.requests[i].CompareAndSwap(req, nil)
+		}
+	}
+}
+
+// queuedUploads returns the number of requests of the leecher
+// at addr waiting to be served, and the bytes they request.
+func (t *TorrentSession) queuedUploads(addr string) (requests int, bytes int64) {
+	for i := range t.upload.requests {
+		if req := t.upload.requests[i].Load(); req != nil && req.addr == addr {
+			requests++
+			bytes += int64(req.request.Length)
+		}
+	}
+	return requests, bytes
+}
+
+// validRequest reports whether r requests a non-empty block within
+// a piece of the torrent, no larger than messagesv1.RequestSize.
+func (t *TorrentSession) validRequest(r *messagesv1.Request) bool {
+	if r.Length == 0 || r.Length > messagesv1.RequestSize {
+		return false
+	}
+	if int64(r.Index) >= t.meta.NumPieces() {
+		return false
+	}
+	return int64(r.Begin)+int64(r.Length) <= t.meta.PieceSize(int64(r.Index))
+}
+
+func (t *TorrentSession) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Request, cancels <-chan *messagesv1.Cancel) {
 	logger := t.logger.With(slog.String("peer_ip", p.Addr), slog.String("pid", p.Id))
 	for {
 		select {
@@ -113,7 +240,8 @@ func (t *Tracker) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Reque
 				if req == nil {
 					continue
 				}
-				matched := req.request.Index == c.Index &&
+				matched := req.addr == p.Addr &&
+					req.request.Index == c.Index &&
 					req.request.Begin == c.Begin &&
 					req.request.Length == c.Length
 				if matched {
@@ -127,9 +255,25 @@ func (t *Tracker) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Reque
 				return
 			}
 
-			if !t.BitField.Check(r.Index) {
+			if p.Status.This.Load() == uint32(peer.Choked) {
+				continue // requests of choked peers are discarded.
+			}
+			if !t.validRequest(r) {
+				logger.Debug("ignoring invalid request",
+					slog.Any("index", r.Index),
+					slog.Any("begin", r.Begin),
+					slog.Any("length", r.Length),
+				)
+				continue
+			}
+			if !t.have.Check(r.PieceIndex()) {
 				continue // we don't have the piece.
 			}
+			// only this goroutine queues requests of the peer, the count can only shrink meanwhile.
+			if n, size := t.queuedUploads(p.Addr); n >= maxQueuedRequests || size+int64(r.Length) > maxQueuedBytes {
+				logger.Debug("ignoring request, too many queued already", slog.Int("queued", n))
+				continue
+			}
 
 			timedUpload := &timedUploadRequest{
 				request: messagesv1.Request{
@@ -137,7 +281,7 @@ func (t *Tracker) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Reque
 					Begin:  r.Begin,
 					Length: r.Length,
 				},
-				recieved: time.Now(),
+				recieved: t.now(),
 				addr:     p.Addr,
 			}
 
@@ -161,7 +305,7 @@ func (t *Tracker) handleRequests(p *peer.Peer, requests <-chan *messagesv1.Reque
 	}
 }
 
-func (t *Tracker) keepAliveLeechers(p *peer.Peer) {
+func (t *TorrentSession) keepAliveLeechers(p *peer.Peer) {
 	logger := t.logger.With(slog.String("peer_ip", p.Addr), slog.String("pid", p.Id))
 
 	defer func() {
@@ -169,10 +313,11 @@ func (t *Tracker) keepAliveLeechers(p *peer.Peer) {
 			logger.Error("failed to close peer", slog.Any("err", err))
 		}
 		t.peers.leechers.Delete(p.Addr)
+		t.peers.uploads.Delete(p.Addr)
 		t.upload.wg.Done()
 	}()
 
-	refresh := time.NewTicker(2 * time.Minute)
+	refresh := t.newTicker(2 * time.Minute)
 	for {
 		select {
 		case <-t.stop:
@@ -181,7 +326,7 @@ func (t *Tracker) keepAliveLeechers(p *peer.Peer) {
 		case <-t.upload.cancel:
 			logger.Debug("shutting down peer refresher, canceled upload")
 			return
-		case <-refresh.C:
+		case <-refresh.C():
 			refresh.Reset(2 * time.Minute)
 			switch s := p.ConnectionStatus(); s {
 			case peer.ConnectionKilled:
@@ -198,10 +343,10 @@ func (t *Tracker) keepAliveLeechers(p *peer.Peer) {
 	}
 }
 
-func (t *Tracker) optimisticUnchoke() {
+func (t *TorrentSession) optimisticUnchoke() {
 	defer t.upload.wg.Done()
 
-	unchoke := time.NewTicker(30 * time.Second)
+	unchoke := t.newTicker(30 * time.Second)
 	for {
 		select {
 		case <-t.stop:
@@ -210,12 +355,12 @@ func (t *Tracker) optimisticUnchoke() {
 		case <-t.upload.cancel:
 			t.logger.Debug("shutting down peer refresher, canceled upload")
 			return
-		case <-unchoke.C:
+		case <-unchoke.C():
 			// select random peer to unchoke
 			t.peers.leechers.Range(func(_, value any) bool {
 				p := value.(*peer.Peer)
 				if p.Status.This.Load() == uint32(peer.Choked) && p.Interest.Remote.Load() == uint32(peer.Interested) {
-					if err := p.SendUnchoke(); err != nil {
+					if err := t.unchokeLeecher(p); err != nil {
 						t.logger.Error("failed to unchoke peer", slog.String("end_peer", p.Addr))
 						return false
 					}
@@ -0,0 +1,175 @@
+package status
+
+import (
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/stretchr/testify/assert"
+)
+
+// newUploadFixture returns a tracker that has all pieces of
+// blocks blocks each, a single block per piece.
+func newUploadFixture(t *testing.T, blocks int) (*TorrentSession, [][]byte) {
+	t.Helper()
+
+	pieces := make([][]byte, blocks)
+	for i := range pieces {
+		pieces[i] = make([]byte, messagesv1.RequestSize)
+		for j := range pieces[i] {
+			pieces[i][j] = byte(i + j*3)
+		}
+	}
+	tr := newTestTracker(t, messagesv1.RequestSize, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	for i, p := range pieces {
+		assert.NoError(t, tr.Flush(int64(i), p))
+		tr.have.Set(int64(i))
+	}
+	return tr, pieces
+}
+
+// queuedPieces returns the pieces of the queued requests of the leecher at addr.
+func queuedPieces(tr *TorrentSession, addr string) []uint32 {
+	var out []uint32
+	for i := range tr.upload.requests {
+		if req := tr.upload.requests[i].Load(); req != nil && req.addr == addr {
+			out = append(out, req.request.Index)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// assertNothingSent asserts that the stub leecher receives no further piece.
+func assertNothingSent(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	for {
+		msg, err := messagesv1.Identify(conn)
+		if err != nil {
+			assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+			return
+		}
+		assert.NotEqual(t, messagesv1.PieceType, msg.Type)
+	}
+}
+
+func TestTracker_ValidRequest(t *testing.T) {
+	pieceLength, _, pieces := newTailFixture()
+	tr := newTestTracker(t, pieceLength, pieces...)
+	last := uint32(len(pieces) - 1)
+
+	tests := []struct {
+		name string
+		req  messagesv1.Request
+		want bool
+	}{
+		{name: "block", req: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}, want: true},
+		{name: "tail", req: messagesv1.Request{Index: last, Begin: 0, Length: tailLength}, want: true},
+		{name: "empty", req: messagesv1.Request{Index: 0, Begin: 0, Length: 0}},
+		{name: "too long", req: messagesv1.Request{Index: 0, Begin: 0, Length: 2 * messagesv1.RequestSize}},
+		{name: "past piece", req: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize + 1, Length: messagesv1.RequestSize}},
+		{name: "past tail", req: messagesv1.Request{Index: last, Begin: 1, Length: tailLength}},
+		{name: "no such piece", req: messagesv1.Request{Index: last + 1, Begin: 0, Length: 1}},
+		{name: "overflow", req: messagesv1.Request{Index: 0, Begin: ^uint32(0), Length: messagesv1.RequestSize}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tr.validRequest(&tt.req))
+		})
+	}
+}
+
+func TestTracker_UploadSkipsCanceledRequests(t *testing.T) {
+	const blocks = 16
+	tr, pieces := newUploadFixture(t, blocks)
+	conn, leecher := connectStubLeecher(t, tr)
+
+	// the uploader starts once the cancels were handled.
+	var want []uint32
+	for i := range uint32(blocks) {
+		_, err := conn.Write((&messagesv1.Request{Index: i, Length: messagesv1.RequestSize}).Serialize())
+		assert.NoError(t, err)
+		if i%2 == 0 {
+			want = append(want, i)
+		}
+	}
+	for i := uint32(1); i < blocks; i += 2 {
+		_, err := conn.Write((&messagesv1.Cancel{Index: i, Length: messagesv1.RequestSize}).Serialize())
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return slices.Equal(want, queuedPieces(tr, leecher.Addr))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.upload.wg.Add(1)
+	go tr.processUploadRequests()
+	t.Cleanup(tr.CancelUpload)
+
+	served := readPieces(t, conn, len(want))
+	var got []uint32
+	for _, p := range served {
+		assert.Equal(t, pieces[p.Index], p.Block)
+		got = append(got, p.Index)
+	}
+	slices.Sort(got)
+	assert.Equal(t, want, got)
+	assertNothingSent(t, conn)
+
+	uploaded := int64(len(want) * messagesv1.RequestSize)
+	assert.Equal(t, uploaded, tr.uploaded.Load())
+	assert.Equal(t, []LeecherStat{{Addr: leecher.Addr, Uploaded: uploaded}}, tr.LeecherStats())
+}
+
+func TestTracker_UploadQueueBounded(t *testing.T) {
+	const blocks = maxQueuedRequests + 6
+	tr, _ := newUploadFixture(t, blocks)
+	conn, leecher := connectStubLeecher(t, tr)
+
+	for i := range uint32(blocks) {
+		_, err := conn.Write((&messagesv1.Request{Index: i, Length: messagesv1.RequestSize}).Serialize())
+		assert.NoError(t, err)
+	}
+	// handled after all requests, frees a slot no later request takes.
+	_, err := conn.Write((&messagesv1.Cancel{Index: 0, Length: messagesv1.RequestSize}).Serialize())
+	assert.NoError(t, err)
+
+	var want []uint32
+	for i := uint32(1); i < maxQueuedRequests; i++ {
+		want = append(want, i)
+	}
+	assert.Eventually(t, func() bool {
+		return slices.Equal(want, queuedPieces(tr, leecher.Addr))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []LeecherStat{{Addr: leecher.Addr, Queued: len(want)}}, tr.LeecherStats())
+}
+
+func TestTracker_UploadDropsRequestsOfChokedLeechers(t *testing.T) {
+	tr, _ := newUploadFixture(t, 4)
+	conn, leecher := connectStubLeecher(t, tr)
+
+	for i := range uint32(4) {
+		_, err := conn.Write((&messagesv1.Request{Index: i, Length: messagesv1.RequestSize}).Serialize())
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return len(queuedPieces(tr, leecher.Addr)) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, leecher.SendChoke())
+	tr.upload.wg.Add(1)
+	go tr.processUploadRequests()
+	t.Cleanup(tr.CancelUpload)
+
+	assert.Eventually(t, func() bool {
+		return len(queuedPieces(tr, leecher.Addr)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assertNothingSent(t, conn)
+	assert.Zero(t, tr.uploaded.Load())
+}
@@ -0,0 +1,93 @@
+package status
+
+import (
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+)
+
+// WasteStats are the downloaded bytes that were discarded and had to be
+// downloaded again, by the reason they were discarded. They explain why
+// more bytes are received from peers than the size of the torrent.
+type WasteStats struct {
+	// HashFailed are the bytes of the pieces that failed verification.
+	HashFailed int64
+	// FlushFailed are the bytes of the pieces that could not be
+	// flushed or did not read back as written.
+	FlushFailed int64
+	// PeerBanned are the bytes of incomplete pieces
+	// received from peers that were banned.
+	PeerBanned int64
+	// Shutdown are the bytes of incomplete pieces
+	// dropped as the download was stopped.
+	Shutdown int64
+	// Abandoned are the bytes of incomplete pieces that were abandoned.
+	Abandoned int64
+	// Duplicate are the bytes of blocks that were received again, such
+	// as those requested from several peers in the endgame, or that
+	// were not requested or no longer needed. They were dropped.
+	Duplicate int64
+}
+
+// Total returns the discarded bytes of all reasons.
+func (s WasteStats) Total() int64 {
+	return s.HashFailed + s.FlushFailed + s.PeerBanned + s.Shutdown + s.Abandoned + s.Duplicate
+}
+
+type waste struct {
+	hashFailed  atomic.Int64
+	flushFailed atomic.Int64
+	peerBanned  atomic.Int64
+	shutdown    atomic.Int64
+	abandoned   atomic.Int64
+	duplicate   atomic.Int64
+}
+
+// WasteStats returns the downloaded bytes that were discarded.
+func (t *TorrentSession) WasteStats() WasteStats {
+	w := &t.download.waste
+	return WasteStats{
+		HashFailed:  w.hashFailed.Load(),
+		FlushFailed: w.flushFailed.Load(),
+		PeerBanned:  w.peerBanned.Load(),
+		Shutdown:    w.shutdown.Load(),
+		Abandoned:   w.abandoned.Load(),
+		Duplicate:   w.duplicate.Load(),
+	}
+}
+
+// discardBlocksFrom discards the blocks of the incomplete pieces that were
+// delivered by the peer at addr and requests them again, as the peer was
+// banned for delivering corrupt data.
+func (t *TorrentSession) discardBlocksFrom(addr string) {
+	var discarded int64
+	for _, p := range t.download.active.snapshot() {
+		p.l.Lock()
+		if t.download.active.get(p.Index) != p || p.Downloaded == p.Size {
+			p.l.Unlock()
+			continue // no longer downloaded or already verified.
+		}
+		p.Received = slices.DeleteFunc(p.Received, func(b *receivedBlock) bool {
+			if b.from != addr {
+				return false
+			}
+			req := messagesv1.Request{Index: b.Index, Begin: b.Begin, Length: uint32(len(b.Block))}
+			p.InFlight = slices.DeleteFunc(p.InFlight, func(r *timedDownloadRequest) bool { return r.request == req })
+			p.Pending = append(p.Pending, &req)
+			p.Downloaded -= int64(len(b.Block))
+			t.downloaded.Add(-int64(len(b.Block)))
+			discarded += int64(len(b.Block))
+			return true
+		})
+		p.l.Unlock()
+	}
+	if discarded > 0 {
+		t.download.waste.peerBanned.Add(discarded)
+		t.logger.Debug("discarded blocks of banned peer",
+			slog.String("end_peer", addr),
+			slog.Int64("bytes", discarded),
+		)
+	}
+}
@@ -0,0 +1,198 @@
+package status
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_WasteStats(t *testing.T) {
+	const pieceLength = messagesv1.RequestSize
+	data := make([]byte, 8*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 11)
+	}
+	var pieces [][]byte
+	for i := 0; i < len(data); i += pieceLength {
+		pieces = append(pieces, data[i:i+pieceLength])
+	}
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+	disk := storagetest.NewFlakyStorage(storage.NewMemory(), 7, storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 0.3}))
+	WithStorage(disk)(tr)
+
+	// the second piece is corrupted twice, below the ban threshold.
+	var corrupted atomic.Int64
+	seeder := newStubSeeder(t, pieceLength, data, 0, true, func(s *stubSeeder) {
+		s.corrupt = func(req messagesv1.Request) bool {
+			return req.Index == 1 && corrupted.Add(1) <= 2
+		}
+	})
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(1)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	tr.CancelDownload()
+
+	_, failedWrites := disk.Injected()
+	assert.Positive(t, failedWrites, "the seed injects write failures")
+
+	waste := tr.WasteStats()
+	assert.Equal(t, WasteStats{
+		HashFailed:  2 * pieceLength,
+		FlushFailed: int64(failedWrites) * pieceLength,
+	}, waste)
+	assert.Equal(t, waste.HashFailed, tr.CorruptBytes())
+
+	var received int64
+	for _, s := range tr.PeerStats() {
+		received += s.Downloaded
+	}
+	assert.Equal(t, int64(len(data))+waste.Total(), received, "over-download is explained by the waste")
+	assert.Equal(t, int64(len(data)), tr.downloaded.Load())
+}
+
+func TestTracker_WasteOnBanAndShutdown(t *testing.T) {
+	const pieceLength = 3 * messagesv1.RequestSize
+	tr := newTestTracker(t, pieceLength, make([]byte, pieceLength), make([]byte, pieceLength))
+
+	block := func(index, begin uint32, from string) *receivedBlock {
+		return &receivedBlock{
+			Piece: &messagesv1.Piece{Index: index, Begin: begin, Block: make([]byte, messagesv1.RequestSize)},
+			from:  from,
+		}
+	}
+	inFlight := func(index, begin uint32, from string) *timedDownloadRequest {
+		return &timedDownloadRequest{
+			request:  messagesv1.Request{Index: index, Begin: begin, Length: messagesv1.RequestSize},
+			received: true,
+			peers:    []string{from},
+		}
+	}
+	for i := range uint32(2) {
+		p := &pendingPiece{
+			Index:      int64(i),
+			Attempt:    1,
+			Size:       pieceLength,
+			Downloaded: messagesv1.RequestSize,
+			Received:   []*receivedBlock{block(i, 0, "bad:1")},
+			InFlight:   []*timedDownloadRequest{inFlight(i, 0, "bad:1")},
+		}
+		if i == 1 {
+			p.Downloaded += messagesv1.RequestSize
+			p.Received = append(p.Received, block(i, messagesv1.RequestSize, "good:1"))
+			p.InFlight = append(p.InFlight, inFlight(i, messagesv1.RequestSize, "good:1"))
+		}
+		assert.True(t, tr.download.active.add(p))
+	}
+	tr.downloaded.Store(3 * messagesv1.RequestSize)
+
+	tr.discardBlocksFrom("bad:1")
+	assert.Equal(t, WasteStats{PeerBanned: 2 * messagesv1.RequestSize}, tr.WasteStats())
+	for _, p := range tr.download.active.snapshot() {
+		for _, b := range p.Received {
+			assert.Equal(t, "good:1", b.from)
+		}
+		assert.Len(t, p.Pending, 1, "discarded block is requested again")
+		assert.Equal(t, uint32(0), p.Pending[0].Begin)
+	}
+
+	tr.releaseActive()
+	assert.Equal(t, WasteStats{PeerBanned: 2 * messagesv1.RequestSize, Shutdown: messagesv1.RequestSize}, tr.WasteStats())
+	assert.Zero(t, tr.downloaded.Load())
+	assert.Zero(t, tr.download.active.len())
+}
+
+func TestTracker_OverlappingBlocks(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	tr := newTestTracker(t, int64(len(data)), data)
+
+	// the requests of the piece overlap, as no request of this client
+	// does, so that the blocks they match overlap as well.
+	const half = messagesv1.RequestSize / 2
+	tr.download.active.add(&pendingPiece{
+		Index:   0,
+		Attempt: 1,
+		Size:    int64(len(data)),
+		InFlight: []*timedDownloadRequest{
+			{request: messagesv1.Request{Index: 0, Begin: 0, Length: messagesv1.RequestSize}},
+			{request: messagesv1.Request{Index: 0, Begin: half, Length: messagesv1.RequestSize}},
+			{request: messagesv1.Request{Index: 0, Begin: messagesv1.RequestSize, Length: messagesv1.RequestSize}},
+		},
+	})
+
+	pieces := make(chan *messagesv1.Piece)
+	done := tr.spawnReceiver(tr.logger, "10.0.0.1:6881", "peer", pieces, nil)
+	pieces <- &messagesv1.Piece{Index: 0, Begin: 0, Block: data[:messagesv1.RequestSize]}
+	pieces <- &messagesv1.Piece{Index: 0, Begin: half, Block: data[half : half+messagesv1.RequestSize]}
+	// a block that was not requested.
+	pieces <- &messagesv1.Piece{Index: 0, Begin: 1, Block: data[1:10]}
+	pieces <- &messagesv1.Piece{Index: 0, Begin: messagesv1.RequestSize, Block: data[messagesv1.RequestSize:]}
+	// a block of the verified piece, received again.
+	pieces <- &messagesv1.Piece{Index: 0, Begin: 0, Block: data[:messagesv1.RequestSize]}
+	close(pieces)
+	<-done
+
+	assert.True(t, tr.have.Check(0))
+	assert.Equal(t, int64(len(data)), tr.downloaded.Load())
+	assert.Equal(t, WasteStats{Duplicate: 2*messagesv1.RequestSize + 9}, tr.WasteStats())
+}
+
+func FuzzPendingPiece_Receive(f *testing.F) {
+	f.Add(uint32(0), uint16(16384), uint32(8192), uint16(16384), uint32(16384), uint16(16384))
+	f.Add(uint32(0), uint16(100), uint32(0), uint16(100), uint32(99), uint16(2))
+	f.Add(uint32(40000), uint16(9000), uint32(0), uint16(0), uint32(1), uint16(65535))
+	f.Fuzz(func(t *testing.T, b1 uint32, l1 uint16, b2 uint32, l2 uint16, b3 uint32, l3 uint16) {
+		const size = 3*messagesv1.RequestSize - 100
+		p := &pendingPiece{Size: size}
+		covered := make([]bool, size)
+		for _, b := range []struct {
+			begin  uint32
+			length uint16
+		}{{b1, l1}, {b2, l2}, {b3, l3}} {
+			// twice, as a duplicate is never accepted.
+			for range 2 {
+				block := &receivedBlock{Piece: &messagesv1.Piece{Begin: b.begin, Block: make([]byte, b.length)}}
+				if !p.receive(block) {
+					continue
+				}
+				for i := int64(b.begin); i < int64(b.begin)+int64(b.length); i++ {
+					if covered[i] {
+						t.Fatalf("byte %d was accepted twice", i)
+					}
+					covered[i] = true
+				}
+			}
+		}
+
+		var want int64
+		for _, c := range covered {
+			if c {
+				want++
+			}
+		}
+		if p.Downloaded != want || p.Downloaded > p.Size {
+			t.Fatalf("downloaded %d bytes of %d, %d covered", p.Downloaded, p.Size, want)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+)
+
+const (
+	// webSeedID is used as the peer id of blocks delivered by web seeds.
+	webSeedID = "webseed"
+	// maxWebSeedRequests is the number of blocks fetched
+	// from a single web seed concurrently.
+	maxWebSeedRequests = 4
+	// webSeedTimeout bounds a single HTTP request to a web seed.
+	webSeedTimeout = 30 * time.Second
+	// webSeedBackoff is the initial wait after a failed request, if
+	// the web seed did not say when to retry. It doubles on each
+	// consecutive failure up to maxWebSeedBackoff.
+	webSeedBackoff    = 30 * time.Second
+	maxWebSeedBackoff = 10 * time.Minute
+	// webSeedFallbackAttempts is the number of times a piece may fail
+	// verification before it is only requested from web seeds, which
+	// serve the original data.
+	webSeedFallbackAttempts = 2
+)
+
+// errWebSeedUnavailable is returned if the web seed responded with 503.
+var errWebSeedUnavailable = errors.New("web seed unavailable")
+
+// webSeed is an HTTP server hosting the torrent data, as described in
+// BEP 19. It is treated as a peer that is always unchoked and has all
+// pieces, which is only used if no real peer can serve a block.
+type webSeed struct {
+	url    string
+	client *http.Client
+
+	// requests are handed over to the idle workers of the web seed.
+	requests chan messagesv1.Request
+
+	// retryAt is the unix nano time before which
+	// no requests are sent to the web seed.
+	retryAt atomic.Int64
+	// failures counts the consecutive failed requests.
+	failures atomic.Int64
+}
+
+func newWebSeed(u string, client *http.Client) *webSeed {
+	return &webSeed{
+		url:      u,
+		client:   client,
+		requests: make(chan messagesv1.Request),
+	}
+}
+
+// available reports whether the web seed is not backing off.
+func (w *webSeed) available(now time.Time) bool { return now.UnixNano() >= w.retryAt.Load() }
+
+// request hands req over to an idle worker. It reports
+// false if the web seed is backing off or all workers are busy.
+func (w *webSeed) request(req messagesv1.Request, now time.Time) bool {
+	if !w.available(now) {
+		return false
+	}
+	select {
+	case w.requests <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff delays further requests after a failed one, by retryAfter
+// if the web seed said so, otherwise exponentially.
+func (w *webSeed) backoff(retryAfter time.Duration, now time.Time) time.Duration {
+	n := w.failures.Add(1)
+	delay := retryAfter
+	if delay <= 0 {
+		delay = min(webSeedBackoff<<min(n-1, 16), maxWebSeedBackoff)
+	}
+	w.retryAt.Store(now.Add(delay).UnixNano())
+	return delay
+}
+
+// fileURL returns the URL of the file at path, relative to the torrent
+// name, following the rules of BEP 19. For single file torrents path is
+// empty and the URL is used as is unless it ends with a slash.
+func (w *webSeed) fileURL(name, path string, multiFile bool) string {
+	u := w.url
+	if !multiFile && !strings.HasSuffix(u, "/") {
+		return u
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	segments := []string{url.PathEscape(name)}
+	if multiFile {
+		for _, s := range strings.Split(filepath.ToSlash(path), "/") {
+			segments = append(segments, url.PathEscape(s))
+		}
+	}
+	return u + strings.Join(segments, "/")
+}
+
+// fileRange is a range of bytes within a single file of the torrent.
+type fileRange struct {
+	url    string
+	offset int64
+	length int64
+	// padding is set for ranges of padding files, which
+	// are zeros that web seeds are not asked for.
+	padding bool
+}
+
+// fileRanges maps the block described by req to the files it is
+// located in. Empty files never hold any bytes of the block.
+func (t *TorrentSession) fileRanges(w *webSeed, req messagesv1.Request) []fileRange {
+	start := req.PieceIndex()*t.meta.PieceLength + int64(req.Begin)
+	end := start + int64(req.Length)
+
+	if t.meta.InfoSingleFile != nil {
+		return []fileRange{{
+			url:    w.fileURL(t.meta.InfoSingleFile.Name, "", false),
+			offset: start,
+			length: end - start,
+		}}
+	}
+
+	var out []fileRange
+	var offset int64
+	for _, f := range t.meta.InfoMultiFile.Files {
+		fileStart, fileEnd := offset, offset+f.Length
+		offset = fileEnd
+		if f.Length == 0 || fileEnd <= start || fileStart >= end {
+			continue
+		}
+		from, to := max(start, fileStart), min(end, fileEnd)
+		out = append(out, fileRange{
+			url:     w.fileURL(t.meta.InfoMultiFile.Name, f.Path, true),
+			offset:  from - fileStart,
+			length:  to - from,
+			padding: f.IsPadding(),
+		})
+	}
+	return out
+}
+
+// fetch downloads the block described by req from the web seed.
+func (t *TorrentSession) fetch(w *webSeed, req messagesv1.Request) ([]byte, error) {
+	block := make([]byte, 0, req.Length)
+	for _, r := range t.fileRanges(w, req) {
+		if r.padding {
+			block = append(block, make([]byte, r.length)...)
+			continue
+		}
+		httpReq, err := http.NewRequest(http.MethodGet, r.url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for %s: %w", r.url, err)
+		}
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+r.length-1))
+
+		resp, err := w.client.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to request %s: %w", r.url, err)
+		}
+
+		b, err := readRange(resp, r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, b...)
+	}
+	return block, nil
+}
+
+// readRange reads the range r from the response.
+func readRange(resp *http.Response, r fileRange) ([]byte, error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range and sends the whole file.
+		if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", r.url, err)
+		}
+	case http.StatusServiceUnavailable:
+		return nil, &unavailableError{retryAfter: retryAfter(resp)}
+	default:
+		return nil, fmt.Errorf("unexpected status %q for %s", resp.Status, r.url)
+	}
+
+	b := make([]byte, r.length)
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.url, err)
+	}
+	return b, nil
+}
+
+type unavailableError struct{ retryAfter time.Duration }
+
+func (e *unavailableError) Error() string { return errWebSeedUnavailable.Error() }
+func (e *unavailableError) Unwrap() error { return errWebSeedUnavailable }
+
+// retryAfter returns the time after which the request can be retried, read
+// from the Retry-After header or, as web seeds commonly do, from the body
+// containing the number of seconds. It returns 0 if neither is present.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 32))
+		v = string(b)
+	}
+	if s, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if d, err := http.ParseTime(v); err == nil {
+		return time.Until(d)
+	}
+	return 0
+}
+
+// pickWebSeed hands req over to a web seed that is able to serve it.
+func (t *TorrentSession) pickWebSeed(req messagesv1.Request) *webSeed {
+	for _, w := range t.webSeeds {
+		if _, ok := t.peers.banned.Load(w.url); ok {
+			continue
+		}
+		if w.request(req, t.now()) {
+			return w
+		}
+	}
+	return nil
+}
+
+// webSeedAvailable reports whether any web seed can currently serve requests.
+func (t *TorrentSession) webSeedAvailable() bool {
+	now := t.now()
+	for _, w := range t.webSeeds {
+		if _, ok := t.peers.banned.Load(w.url); !ok && w.available(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackToWebSeed reports whether p failed verification too often to be
+// requested from peers again. If all web seeds are banned, or there are none,
+// the piece keeps being requested from peers, whose repeated failures get
+// them banned. The piece lock must be held.
+func (t *TorrentSession) fallbackToWebSeed(p *pendingPiece) bool {
+	if p.Attempt <= webSeedFallbackAttempts {
+		return false
+	}
+	for _, w := range t.webSeeds {
+		if _, ok := t.peers.banned.Load(w.url); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runWebSeed fetches the blocks handed over to the web seed until
+// the download finishes. The fetched blocks go through recvPieces,
+// same as the blocks delivered by peers.
+func (t *TorrentSession) runWebSeed(w *webSeed) {
+	defer t.download.wg.Done()
+
+	logger := t.logger.With(slog.String("web_seed", w.url))
+
+	// pieces delivers the fetched blocks to recvPieces, each
+	// download started has its own.
+	pieces := make(chan *messagesv1.Piece)
+	t.spawnReceiver(logger, w.url, webSeedID, pieces, nil)
+
+	var wg sync.WaitGroup
+	for range maxWebSeedRequests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.webSeedWorker(logger, w, pieces)
+		}()
+	}
+	wg.Wait()
+	close(pieces)
+}
+
+func (t *TorrentSession) webSeedWorker(logger *slog.Logger, w *webSeed, pieces chan<- *messagesv1.Piece) {
+	for {
+		var req messagesv1.Request
+		select {
+		case <-t.stop:
+			return
+		case <-t.canceled():
+			return
+		case <-t.download.completed:
+			return
+		case req = <-w.requests:
+		}
+
+		block, err := t.fetch(w, req)
+		if err != nil {
+			var unavailable *unavailableError
+			var delay time.Duration
+			if errors.As(err, &unavailable) {
+				delay = w.backoff(unavailable.retryAfter, t.now())
+			} else {
+				delay = w.backoff(0, t.now())
+			}
+			logger.Debug("failed to fetch block from web seed, backing off",
+				slog.String("retry_in", delay.String()),
+				slog.Any("err", err),
+			)
+			// the other requests sent in the meantime will not be served either.
+			requeued := t.requeueInFlight(w.url)
+			logger.Debug("re-queued in-flight web seed requests", slog.Int("requests", requeued))
+			continue
+		}
+		w.failures.Store(0)
+
+		select {
+		case <-t.stop:
+			return
+		case <-t.canceled():
+			return
+		case pieces <- &messagesv1.Piece{Index: req.Index, Begin: req.Begin, Block: block}:
+		}
+	}
+}
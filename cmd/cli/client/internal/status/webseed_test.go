@@ -0,0 +1,243 @@
+package status
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSeed_FileURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		path      string
+		multiFile bool
+		want      string
+	}{
+		{name: "single file", url: "http://seed/data/file.iso", want: "http://seed/data/file.iso"},
+		{name: "single file directory", url: "http://seed/data/", want: "http://seed/data/file.iso"},
+		{name: "multi file", url: "http://seed/data/", path: "sub/a b.txt", multiFile: true, want: "http://seed/data/file.iso/sub/a%20b.txt"},
+		{name: "multi file without slash", url: "http://seed/data", path: "a.txt", multiFile: true, want: "http://seed/data/file.iso/a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWebSeed(tt.url, http.DefaultClient)
+			assert.Equal(t, tt.want, w.fileURL("file.iso", tt.path, tt.multiFile))
+		})
+	}
+}
+
+func TestTracker_WebSeedDownload(t *testing.T) {
+	const pieceLength = 2 * messagesv1.RequestSize
+	data := make([]byte, 3*pieceLength+100)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	var pieces [][]byte
+	for i := 0; i < len(data); i += pieceLength {
+		pieces = append(pieces, data[i:min(i+pieceLength, len(data))])
+	}
+
+	tests := []struct {
+		name      string
+		multiFile bool
+	}{
+		{name: "single file"},
+		{name: "multi file", multiFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t, pieceLength, pieces...)
+
+			root := t.TempDir()
+			srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+			t.Cleanup(srv.Close)
+
+			seedURL := srv.URL + "/test.bin"
+			if tt.multiFile {
+				// the first file ends in the middle of a block.
+				split := messagesv1.RequestSize + 10
+				tr.meta.InfoSingleFile = nil
+				tr.meta.InfoMultiFile = &torrent.InfoMultiFile{
+					Name: "test",
+					Files: []torrent.FileInfo{
+						{Path: "a.bin", Length: int64(split)},
+						{Path: filepath.Join("sub", "b c.bin"), Length: int64(len(data) - split)},
+					},
+				}
+				assert.NoError(t, os.MkdirAll(filepath.Join(root, "test", "sub"), 0o755))
+				assert.NoError(t, os.WriteFile(filepath.Join(root, "test", "a.bin"), data[:split], 0o644))
+				assert.NoError(t, os.WriteFile(filepath.Join(root, "test", "sub", "b c.bin"), data[split:], 0o644))
+				seedURL = srv.URL + "/"
+			} else {
+				assert.NoError(t, os.WriteFile(filepath.Join(root, "test.bin"), data, 0o644))
+			}
+
+			w := newWebSeed(seedURL, srv.Client())
+			tr.webSeeds = append(tr.webSeeds, w)
+			tr.download.wg.Add(2)
+			go tr.runWebSeed(w)
+			go tr.downloadScheduler()
+
+			select {
+			case <-tr.WaitUntilDownloaded():
+			case <-time.After(5 * time.Second):
+				t.Fatal("torrent was not downloaded from web seed")
+			}
+			tr.download.wg.Wait()
+			assert.Equal(t, int64(len(pieces)), tr.download.pipeline.verified.Load())
+			assert.Equal(t, int64(len(data)), tr.download.pipeline.flushed.Load())
+
+			for i, p := range pieces {
+				got, err := tr.ReadRequest(&messagesv1.Request{Index: uint32(i), Length: uint32(len(p))})
+				assert.NoError(t, err)
+				assert.Equal(t, p, got)
+			}
+		})
+	}
+}
+
+func TestTracker_WebSeedBackoff(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	tr := newTestTracker(t, int64(len(data)), data)
+
+	var (
+		l           sync.Mutex
+		unavailable time.Time
+		early       int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		switch {
+		case unavailable.IsZero():
+			unavailable = time.Now()
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case time.Since(unavailable) < 900*time.Millisecond:
+			early++
+		}
+		http.ServeContent(rw, r, "test.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	w := newWebSeed(srv.URL+"/test.bin", srv.Client())
+	tr.webSeeds = append(tr.webSeeds, w)
+	tr.download.wg.Add(2)
+	go tr.runWebSeed(w)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent was not downloaded from web seed")
+	}
+	tr.download.wg.Wait()
+
+	l.Lock()
+	defer l.Unlock()
+	assert.False(t, unavailable.IsZero())
+	// a request may have been in flight while the first one failed.
+	assert.LessOrEqual(t, early, 1)
+	assert.Empty(t, tr.have.MissingPieces())
+}
+
+func TestTracker_WebSeedFallback(t *testing.T) {
+	const pieceLength = messagesv1.RequestSize
+	data := make([]byte, 4*pieceLength)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var pieces [][]byte
+	for i := 0; i < len(data); i += pieceLength {
+		pieces = append(pieces, data[i:i+pieceLength])
+	}
+	tr := newTestTracker(t, pieceLength, pieces...)
+	tr.clientID = "-TT0100-000000000000"
+
+	var (
+		l      sync.Mutex
+		ranges []string
+		events []PieceHashFailed
+	)
+	tr.Subscribe(func(e Event) {
+		if e, ok := e.(PieceHashFailed); ok {
+			l.Lock()
+			defer l.Unlock()
+			events = append(events, e)
+		}
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		l.Unlock()
+		http.ServeContent(rw, r, "test.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	w := newWebSeed(srv.URL+"/test.bin", srv.Client())
+	tr.webSeeds = append(tr.webSeeds, w)
+
+	// the only peer of the swarm serves a corrupt copy of the second piece.
+	corrupt := bytes.Clone(data)
+	corrupt[pieceLength+10] ^= 0xff
+	seeder := newStubSeeder(t, pieceLength, corrupt, 0, true)
+	tr.download.wg.Add(1)
+	go tr.keepAliveSeeders(seeder.addr)
+	assert.Eventually(t, func() bool {
+		v, ok := tr.peers.seeders.Load(seeder.addr)
+		return ok && v.(*peer.Peer).Bitfield.Check(0)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tr.download.wg.Add(2)
+	go tr.runWebSeed(w)
+	go tr.downloadScheduler()
+
+	select {
+	case <-tr.WaitUntilDownloaded():
+	case <-time.After(10 * time.Second):
+		t.Fatal("corrupt piece was not recovered from web seed")
+	}
+	tr.CancelDownload()
+
+	for i, p := range pieces {
+		got, err := tr.ReadRequest(&messagesv1.Request{Index: uint32(i), Length: uint32(len(p))})
+		assert.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	assert.Len(t, events, webSeedFallbackAttempts)
+	for _, e := range events {
+		assert.Equal(t, int64(1), e.Piece)
+	}
+	assert.Equal(t, int64(webSeedFallbackAttempts*pieceLength), tr.CorruptBytes())
+	assert.Equal(t, int64(1), tr.WebSeedRecoveries())
+	assert.Contains(t, ranges, "bytes=16384-32767")
+
+	var fromSeeder int
+	for _, r := range seeder.received() {
+		if r.Index == 1 {
+			fromSeeder++
+		}
+	}
+	assert.Equal(t, webSeedFallbackAttempts, fromSeeder, "failed piece is not requested from peers again")
+	_, banned := tr.peers.banned.Load(seeder.addr)
+	assert.False(t, banned, "peer serving other pieces intact stays below the ban threshold")
+}
@@ -0,0 +1,23 @@
+package tracker
+
+import "net/url"
+
+// RedactURL returns the URL raw without its userinfo, so that credentials
+// embedded in announce URLs do not end up in logs and errors.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	if u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// HasCredentials reports whether the URL raw embeds userinfo.
+func HasCredentials(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.User != nil
+}
@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 func Optional[T any](val T) *T { return &val }
@@ -28,6 +30,10 @@ type RequestParams struct {
 	// The number of bytes this client still has to download
 	//(The number of bytes needed to download to be 100% complete).
 	Left int64
+	// The number of bytes discarded as they failed the hash check
+	// (since the client sent the 'started' event to the tracker).
+	// It is an extension only sent to HTTP trackers (Optional).
+	Corrupt *int64
 	// Setting this to 1 indicates that the client accepts a compact response. Possible values [0|1] (Optional).
 	// NOTE: some trackers only support compact responses (for saving bandwidth) and either refuse requests
 	// without "compact=1" or simply send a compact response unless the request contains "compact=0"
@@ -60,8 +66,9 @@ func (p RequestParams) Validate() error {
 	if p.PeerID == "" {
 		return errors.New("peer_id is required")
 	}
-	if p.Port == 0 {
-		return errors.New("port specified but provided value 0")
+	// port 0 announces a client that does not accept connections.
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid port %v", p.Port)
 	}
 	if p.Compact != nil {
 		if *p.Compact != 1 && *p.Compact != 0 {
@@ -88,6 +95,11 @@ func (p RequestParams) Validate() error {
 			return fmt.Errorf("invalid ip %v", *p.IP)
 		}
 	}
+	if p.Corrupt != nil {
+		if *p.Corrupt < 0 {
+			return fmt.Errorf("corrupt %v cannot be negative", *p.Corrupt)
+		}
+	}
 	if p.NumWant != nil {
 		if *p.NumWant < 0 {
 			return fmt.Errorf("num_want %v cannot be negative", *p.NumWant)
@@ -106,6 +118,9 @@ func (p RequestParams) Encode() string {
 		"left":       {strconv.FormatInt(p.Left, 10)},
 	}
 
+	if p.Corrupt != nil {
+		values.Set("corrupt", strconv.FormatInt(*p.Corrupt, 10))
+	}
 	if p.Compact != nil {
 		values.Set("compact", strconv.Itoa(int(*p.Compact)))
 	}
@@ -130,22 +145,127 @@ func (p RequestParams) Encode() string {
 	return values.Encode()
 }
 
+// FailureError is returned by CreateRequest if the tracker
+// responded with a failure reason.
+type FailureError struct{ Reason string }
+
+func (e *FailureError) Error() string { return fmt.Sprintf("request to tracker failed: %s", e.Reason) }
+
+// ErrUnsupportedScheme is returned for trackers whose URL scheme is
+// neither http, https nor udp, such as the wss trackers of WebTorrent.
+// Unlike other errors it is permanent, the tracker is never contacted.
+var ErrUnsupportedScheme = errors.New("unsupported tracker url scheme")
+
+// Supported reports whether requests can be sent to the tracker at announce.
+func Supported(announce string) bool {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "udp":
+		return true
+	default:
+		return false
+	}
+}
+
+// unsupported returns the error for the tracker at announce, whose
+// scheme is not supported, without the credentials it may embed.
+func unsupported(announce string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedScheme, RedactURL(announce))
+}
+
+// DefaultTimeout bounds the requests of a Client without its own HTTP
+// client, so that an unresponsive tracker cannot stall the announces.
+const DefaultTimeout = 30 * time.Second
+
+var defaultHTTP = &http.Client{Timeout: DefaultTimeout}
+
+// Client sends the requests to trackers.
+type Client struct {
+	// HTTP is the client the requests are sent with. If nil, a client
+	// timing out after DefaultTimeout is used.
+	HTTP *http.Client
+	// UserAgent is sent with every request if set, as some trackers
+	// only accept the clients they know.
+	UserAgent string
+
+	// Dial connects to UDP trackers. If nil, a net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Timeout bounds the requests to UDP trackers, DefaultTimeout if
+	// zero. The HTTP requests are bounded by the timeout of HTTP.
+	Timeout time.Duration
+	// UDPRetransmit is how long a request to a UDP tracker waits for
+	// the response before it is sent again, DefaultUDPRetransmit if zero.
+	UDPRetransmit time.Duration
+}
+
+// CreateRequest announces to the tracker with the default client.
 func CreateRequest(ctx context.Context, announce string, params *RequestParams) (*Response, error) {
+	return new(Client).CreateRequest(ctx, announce, params)
+}
+
+// CreateRequest announces to the tracker at announce, over UDP for
+// udp:// URLs and over HTTP otherwise. Trackers of other schemes fail
+// with ErrUnsupportedScheme.
+func (c *Client) CreateRequest(ctx context.Context, announce string, params *RequestParams) (*Response, error) {
 	if err := params.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid params: %w", err)
 	}
+	if !Supported(announce) {
+		return nil, unsupported(announce)
+	}
+	if IsUDP(announce) {
+		return c.announceUDP(ctx, announce, params)
+	}
 
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		fmt.Sprintf("%s?%s", announce, params.Encode()),
-		nil,
-	)
+	body, err := c.get(ctx, fmt.Sprintf("%s?%s", announce, params.Encode()), announce)
+	if err != nil {
+		return nil, err
+	}
+
+	var info Response
+	if err := DecodeResponse(bytes.NewReader(body), &info); err != nil {
+		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
+	}
+
+	if info.FailureReason != nil {
+		return nil, &FailureError{Reason: *info.FailureReason}
+	}
+
+	return &info, nil
+}
+
+// get sends a GET request to the tracker and returns the response body.
+func (c *Client) get(ctx context.Context, rawURL, tracker string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// the error of url.Parse contains the url, which may embed credentials.
+		return nil, errors.New("failed to create request: invalid tracker url")
+	}
+	// credentials are sent in the Authorization header rather than the URL.
+	user := u.User
+	u.User = nil
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	client := c.HTTP
+	if client == nil {
+		client = defaultHTTP
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -156,18 +276,26 @@ func CreateRequest(ctx context.Context, announce string, params *RequestParams)
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request send to tracker %s returned status code: %v, body: %s", announce, resp.StatusCode, body)
+	// some trackers gzip the response without setting the Content-Encoding header.
+	if bytes.HasPrefix(body, gzipMagic) {
+		if body, err = gunzip(body); err != nil {
+			return nil, fmt.Errorf("failed to decompress response body: %w", err)
+		}
 	}
 
-	var info Response
-	if err := DecodeResponse(bytes.NewReader(body), &info); err != nil {
-		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request send to tracker %s returned status code: %v, body: %s", RedactURL(tracker), resp.StatusCode, body)
 	}
+	return body, nil
+}
 
-	if info.FailureReason != nil {
-		return nil, fmt.Errorf("request to tracker failed: %s", *info.FailureReason)
-	}
+var gzipMagic = []byte{0x1f, 0x8b}
 
-	return &info, nil
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
 }
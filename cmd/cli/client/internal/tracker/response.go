@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/Despire/tinytorrent/bencoding"
 )
@@ -30,12 +32,22 @@ type Response struct {
 	Complete *int64
 	// Number of peers participating in the file (leechers).
 	Incomplete *int64
+	// Number of times the file was downloaded completely (snatches).
+	Downloaded *int64
 	// Peers for the file.
 	Peers []struct {
 		PeerID string
 		IP     string
 		Port   int64
 	}
+
+	// TrailingBytes is the number of bytes following the bencoded
+	// response that were ignored. Some trackers append whitespace
+	// or even HTML to their responses.
+	TrailingBytes int
+	// Warnings describes the optional fields that were skipped,
+	// as they had a type that could not be coerced.
+	Warnings []string
 }
 
 func DecodeResponse(src io.Reader, out *Response) error {
@@ -43,7 +55,7 @@ func DecodeResponse(src io.Reader, out *Response) error {
 		panic("no response to fill, pased <nil>")
 	}
 
-	resp, err := bencoding.Decode(src)
+	resp, trailing, err := bencoding.DecodeLenient(src)
 	if err != nil {
 		return fmt.Errorf("failed to decode body: %w", err)
 	}
@@ -52,6 +64,7 @@ func DecodeResponse(src io.Reader, out *Response) error {
 	}
 
 	dict := resp.(*bencoding.Dictionary).Dict
+	out.TrailingBytes = trailing
 
 	if fr := dict["failure reason"]; fr != nil {
 		l, ok := fr.(*bencoding.ByteString)
@@ -62,52 +75,15 @@ func DecodeResponse(src io.Reader, out *Response) error {
 		return nil // no other fields will be set.
 	}
 
-	if wm := dict["warning message"]; wm != nil {
-		l, ok := wm.(*bencoding.ByteString)
-		if !ok {
-			return fmt.Errorf("expected warning_message to be of type Bytestring but was %T: ", wm)
-		}
-		out.WarningMessage = (*string)(l)
-	}
-
-	if i := dict["interval"]; i != nil {
-		l, ok := i.(*bencoding.Integer)
-		if !ok {
-			return fmt.Errorf("expected interval to be of type Integer but was %T: ", i)
-		}
-		out.Interval = (*int64)(l)
-	}
-
-	if mi := dict["min interval"]; mi != nil {
-		l, ok := mi.(*bencoding.Integer)
-		if !ok {
-			return fmt.Errorf("expected min_interval to be of type Integer but was %T: ", mi)
-		}
-		out.MinInterval = (*int64)(l)
-	}
-
-	if ti := dict["tracker id"]; ti != nil {
-		l, ok := ti.(*bencoding.ByteString)
-		if !ok {
-			return fmt.Errorf("expected tracker_id to be of type Bytestring but was %T: ", ti)
-		}
-		out.TrackerID = (*string)(l)
-	}
-
-	if c := dict["complete"]; c != nil {
-		l, ok := c.(*bencoding.Integer)
-		if !ok {
-			return fmt.Errorf("expected complete to be of type Integer but was %T: ", c)
-		}
-		out.Complete = (*int64)(l)
-	}
-	if inc := dict["incomplete"]; inc != nil {
-		l, ok := inc.(*bencoding.Integer)
-		if !ok {
-			return fmt.Errorf("expected incomplete to be of type Integer but was %T: ", inc)
-		}
-		out.Incomplete = (*int64)(l)
-	}
+	// optional fields of an unexpected type are coerced if possible, or
+	// skipped, as some trackers echo them in non-standard types.
+	out.WarningMessage = optionalString(dict, "warning message", &out.Warnings)
+	out.Interval = optionalInteger(dict, "interval", &out.Warnings)
+	out.MinInterval = optionalInteger(dict, "min interval", &out.Warnings)
+	out.TrackerID = optionalString(dict, "tracker id", &out.Warnings)
+	out.Complete = optionalInteger(dict, "complete", &out.Warnings)
+	out.Incomplete = optionalInteger(dict, "incomplete", &out.Warnings)
+	out.Downloaded = optionalInteger(dict, "downloaded", &out.Warnings)
 
 	if peers := dict["peers"]; peers != nil {
 		switch peers.Type() {
@@ -184,3 +160,100 @@ func DecodeResponse(src io.Reader, out *Response) error {
 
 	return nil
 }
+
+// optionalInteger returns the integer at key of dict, parsing it if it was
+// sent as a string. A value of another type is skipped with a warning.
+func optionalInteger(dict map[string]bencoding.Value, key string, warnings *[]string) *int64 {
+	switch v := dict[key].(type) {
+	case nil:
+		return nil
+	case *bencoding.Integer:
+		return (*int64)(v)
+	case *bencoding.ByteString:
+		if i, err := strconv.ParseInt(strings.TrimSpace(string(*v)), 10, 64); err == nil {
+			return &i
+		}
+	}
+	*warnings = append(*warnings, fmt.Sprintf("skipped %s: expected %v but got %v", key, bencoding.IntegerType, dict[key].Type()))
+	return nil
+}
+
+// optionalString returns the string at key of dict, formatting it if it was
+// sent as an integer. A value of another type is skipped with a warning.
+func optionalString(dict map[string]bencoding.Value, key string, warnings *[]string) *string {
+	switch v := dict[key].(type) {
+	case nil:
+		return nil
+	case *bencoding.ByteString:
+		return (*string)(v)
+	case *bencoding.Integer:
+		s := strconv.FormatInt(int64(*v), 10)
+		return &s
+	}
+	*warnings = append(*warnings, fmt.Sprintf("skipped %s: expected %v but got %v", key, bencoding.ByteStringType, dict[key].Type()))
+	return nil
+}
+
+// EncodeResponse writes the bencoded r to w, the counterpart of
+// DecodeResponse. With compact the peers are encoded as a single
+// string of 6 bytes per peer, which only holds IPv4 peers, the
+// others are left out. Otherwise the peer id of each peer is
+// included unless it is empty.
+func EncodeResponse(w io.Writer, r *Response, compact bool) error {
+	dict := make(map[string]bencoding.Value)
+	if r.FailureReason != nil {
+		dict["failure reason"] = byteString(*r.FailureReason)
+		return bencoding.Encode(w, &bencoding.Dictionary{Dict: dict})
+	}
+
+	if r.WarningMessage != nil {
+		dict["warning message"] = byteString(*r.WarningMessage)
+	}
+	if r.Interval != nil {
+		dict["interval"] = integer(*r.Interval)
+	}
+	if r.MinInterval != nil {
+		dict["min interval"] = integer(*r.MinInterval)
+	}
+	if r.TrackerID != nil {
+		dict["tracker id"] = byteString(*r.TrackerID)
+	}
+	if r.Complete != nil {
+		dict["complete"] = integer(*r.Complete)
+	}
+	if r.Incomplete != nil {
+		dict["incomplete"] = integer(*r.Incomplete)
+	}
+	if r.Downloaded != nil {
+		dict["downloaded"] = integer(*r.Downloaded)
+	}
+
+	if compact {
+		var peers []byte
+		for _, p := range r.Peers {
+			ip := net.ParseIP(p.IP).To4()
+			if ip == nil {
+				continue
+			}
+			peers = binary.BigEndian.AppendUint16(append(peers, ip...), uint16(p.Port))
+		}
+		dict["peers"] = byteString(string(peers))
+	} else {
+		peers := bencoding.List{}
+		for _, p := range r.Peers {
+			peer := map[string]bencoding.Value{
+				"ip":   byteString(p.IP),
+				"port": integer(p.Port),
+			}
+			if p.PeerID != "" {
+				peer["peer id"] = byteString(p.PeerID)
+			}
+			peers = append(peers, &bencoding.Dictionary{Dict: peer})
+		}
+		dict["peers"] = &peers
+	}
+	return bencoding.Encode(w, &bencoding.Dictionary{Dict: dict})
+}
+
+func byteString(s string) *bencoding.ByteString { return (*bencoding.ByteString)(&s) }
+func integer(i int64) *bencoding.Integer        { return (*bencoding.Integer)(&i) }
@@ -0,0 +1,152 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Despire/tinytorrent/bencoding"
+)
+
+var (
+	// ErrScrapeUnsupported is returned for trackers whose announce
+	// URL does not follow the convention for deriving the scrape URL.
+	ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+	// ErrNotTracked is returned if the scraped tracker does not know the torrent.
+	ErrNotTracked = errors.New("torrent is not tracked")
+)
+
+// ScrapeResponse are the statistics of a single torrent reported by a tracker.
+type ScrapeResponse struct {
+	// Number of peers with entire file (seeders).
+	Complete int64
+	// Number of peers participating in the file (leechers).
+	Incomplete int64
+	// Number of times the tracker registered a completed download.
+	Downloaded int64
+}
+
+// ScrapeURL derives the scrape URL from the announce URL, which is only
+// possible if the last path segment of the announce URL starts with
+// "announce". See https://wiki.theory.org/BitTorrentSpecification#Tracker_.27scrape.27_Convention.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		// the error of url.Parse contains the url, which may embed credentials.
+		return "", errors.New("invalid announce url")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	last := u.Path[i+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrScrapeUnsupported
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	return u.String(), nil
+}
+
+// Scrape requests the statistics of the torrent with infoHash from the
+// tracker at announce, without announcing the client to the swarm.
+func Scrape(ctx context.Context, announce, infoHash string) (*ScrapeResponse, error) {
+	return new(Client).Scrape(ctx, announce, infoHash)
+}
+
+// Scrape requests the statistics of the torrent with infoHash from the
+// tracker at announce. UDP trackers are scraped with the scrape action,
+// HTTP trackers at the URL derived by ScrapeURL.
+func (c *Client) Scrape(ctx context.Context, announce, infoHash string) (*ScrapeResponse, error) {
+	if !Supported(announce) {
+		return nil, unsupported(announce)
+	}
+	if IsUDP(announce) {
+		files, err := c.scrapeUDP(ctx, announce, infoHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape udp tracker: %w", err)
+		}
+		return &files[0], nil
+	}
+
+	scrape, err := ScrapeURL(announce)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "?"
+	if strings.Contains(scrape, "?") {
+		sep = "&"
+	}
+	body, err := c.get(ctx, scrape+sep+url.Values{"info_hash": {infoHash}}.Encode(), announce)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := DecodeScrapeResponse(bytes.NewReader(body), infoHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode scrape response: %w", err)
+	}
+	return resp, nil
+}
+
+// DecodeScrapeResponse decodes the statistics of the torrent
+// with infoHash from the bencoded scrape response in src.
+func DecodeScrapeResponse(src io.Reader, infoHash string) (*ScrapeResponse, error) {
+	v, _, err := bencoding.DecodeLenient(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode body: %w", err)
+	}
+	dict, ok := v.(*bencoding.Dictionary)
+	if !ok {
+		return nil, fmt.Errorf("expected response to be of type dictionary but got %v", v.Type())
+	}
+
+	if fr, ok := dict.Dict["failure reason"].(*bencoding.ByteString); ok {
+		return nil, &FailureError{Reason: string(*fr)}
+	}
+
+	files, ok := dict.Dict["files"].(*bencoding.Dictionary)
+	if !ok {
+		return nil, fmt.Errorf("expected files to be of type Dictionary but was %T", dict.Dict["files"])
+	}
+	file, ok := files.Dict[infoHash].(*bencoding.Dictionary)
+	if !ok {
+		return nil, ErrNotTracked
+	}
+
+	out := new(ScrapeResponse)
+	for key, field := range map[string]*int64{
+		"complete":   &out.Complete,
+		"incomplete": &out.Incomplete,
+		"downloaded": &out.Downloaded,
+	} {
+		v := file.Dict[key]
+		if v == nil {
+			continue
+		}
+		i, ok := v.(*bencoding.Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected %s to be of type Integer but was %T", key, v)
+		}
+		*field = int64(*i)
+	}
+	return out, nil
+}
+
+// EncodeScrapeResponse writes the bencoded statistics of the torrents
+// in files, keyed by their info hash, to w. It is the counterpart of
+// DecodeScrapeResponse.
+func EncodeScrapeResponse(w io.Writer, files map[string]ScrapeResponse) error {
+	dict := make(map[string]bencoding.Value, len(files))
+	for infoHash, f := range files {
+		dict[infoHash] = &bencoding.Dictionary{Dict: map[string]bencoding.Value{
+			"complete":   integer(f.Complete),
+			"incomplete": integer(f.Incomplete),
+			"downloaded": integer(f.Downloaded),
+		}}
+	}
+	return bencoding.Encode(w, &bencoding.Dictionary{Dict: map[string]bencoding.Value{
+		"files": &bencoding.Dictionary{Dict: dict},
+	}})
+}
@@ -0,0 +1,66 @@
+package tracker_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string
+		wantErr  error
+	}{
+		{announce: "http://example.com/announce", want: "http://example.com/scrape"},
+		{announce: "http://example.com/x/announce", want: "http://example.com/x/scrape"},
+		{announce: "http://example.com/announce.php", want: "http://example.com/scrape.php"},
+		{announce: "http://example.com/a/announce/b", wantErr: tracker.ErrScrapeUnsupported},
+		{announce: "http://example.com/announce?x=2/4", want: "http://example.com/scrape?x=2/4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.announce, func(t *testing.T) {
+			got, err := tracker.ScrapeURL(tt.announce)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestScrape(t *testing.T) {
+	const infoHash = "01234567890123456789"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			http.NotFound(rw, r)
+			return
+		}
+		hash := r.URL.Query().Get("info_hash")
+		if hash != infoHash {
+			fmt.Fprint(rw, "d5:filesdee")
+			return
+		}
+		fmt.Fprintf(rw, "d5:filesd20:%sd8:completei5e10:downloadedi50e10:incompletei10eeee", hash)
+	}))
+	defer srv.Close()
+
+	resp, err := tracker.Scrape(context.Background(), srv.URL+"/announce", infoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &tracker.ScrapeResponse{Complete: 5, Incomplete: 10, Downloaded: 50}, resp)
+	}
+
+	_, err = tracker.Scrape(context.Background(), srv.URL+"/announce", "98765432109876543210")
+	assert.ErrorIs(t, err, tracker.ErrNotTracked)
+
+	_, err = tracker.Scrape(context.Background(), srv.URL+"/tracker", infoHash)
+	assert.ErrorIs(t, err, tracker.ErrScrapeUnsupported)
+}
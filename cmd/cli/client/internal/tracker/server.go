@@ -0,0 +1,357 @@
+package tracker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultInterval and defaultMinInterval are the announce
+	// intervals of a Server, see WithInterval.
+	defaultInterval    = 5 * time.Minute
+	defaultMinInterval = 1 * time.Minute
+	// defaultNumWant and maxNumWant bound the peers returned by an announce.
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// Server is a minimal HTTP tracker for private swarms. It serves the
+// announce and scrape requests on any path whose last segment starts
+// with "announce" or "scrape", so that ScrapeURL derives the scrape URL
+// of its announce URL. The swarms are kept in memory only.
+type Server struct {
+	interval, minInterval time.Duration
+	// expiry is how long a peer stays in its swarm without announcing.
+	expiry time.Duration
+	// allowed holds the info hashes that are tracked, if not nil.
+	allowed map[string]struct{}
+	logger  *slog.Logger
+	now     func() time.Time
+
+	l      sync.Mutex
+	swarms map[string]*swarm
+}
+
+// swarm holds the peers of a torrent keyed by their peer id.
+type swarm struct {
+	peers map[string]*swarmPeer
+	// downloaded counts the completed events.
+	downloaded int64
+}
+
+type swarmPeer struct {
+	ip   string
+	port int64
+	left int64
+	seen time.Time
+	// completed is set once the peer announced the completed event.
+	completed bool
+}
+
+// ServerOption configures a Server.
+type ServerOption func(s *Server)
+
+// WithInterval sets the interval at which the peers announce and the
+// minimum interval between their announces, 5 and 1 minute by default.
+func WithInterval(interval, minInterval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.interval = interval
+		s.minInterval = minInterval
+	}
+}
+
+// WithPeerExpiry drops the peers that did not announce for d,
+// twice the announce interval by default.
+func WithPeerExpiry(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.expiry = d
+	}
+}
+
+// WithAllowlist tracks only the torrents with the given info hashes,
+// announces and scrapes of other torrents are refused with a failure.
+func WithAllowlist(infoHashes ...string) ServerOption {
+	return func(s *Server) {
+		if s.allowed == nil {
+			s.allowed = make(map[string]struct{})
+		}
+		for _, h := range infoHashes {
+			s.allowed[h] = struct{}{}
+		}
+	}
+}
+
+// WithServerLogger logs the requests that were refused.
+func WithServerLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// NewServer returns a tracker without any swarms.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		interval:    defaultInterval,
+		minInterval: defaultMinInterval,
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		now:         time.Now,
+		swarms:      make(map[string]*swarm),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.expiry <= 0 {
+		s.expiry = 2 * s.interval
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	last := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch {
+	case r.Method != http.MethodGet:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case strings.HasPrefix(last, "announce"):
+		s.announce(w, r)
+	case strings.HasPrefix(last, "scrape"):
+		s.scrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) announce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params, err := parseRequestParams(q)
+	if err != nil {
+		s.fail(w, err.Error())
+		return
+	}
+	if !s.isAllowed(params.InfoHash) {
+		s.fail(w, "torrent is not tracked")
+		return
+	}
+	// the ip parameter is ignored, so that the tracker cannot
+	// be used to direct the peers of a swarm at another host.
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		s.fail(w, "invalid remote address")
+		return
+	}
+	numWant := int64(defaultNumWant)
+	if params.NumWant != nil {
+		numWant = min(*params.NumWant, maxNumWant)
+	}
+	// peers are compact unless asked otherwise, as most clients expect.
+	compact := q.Get("compact") != "0"
+	noPeerID := q.Get("no_peer_id") == "1"
+
+	now := s.now()
+	s.l.Lock()
+	sw := s.swarms[params.InfoHash]
+	if sw == nil {
+		sw = &swarm{peers: make(map[string]*swarmPeer)}
+		s.swarms[params.InfoHash] = sw
+	}
+	s.expire(sw, now)
+
+	event := Event("")
+	if params.Event != nil {
+		event = *params.Event
+	}
+	switch event {
+	case EventStopped:
+		delete(sw.peers, params.PeerID)
+	default:
+		completed := false
+		if p, ok := sw.peers[params.PeerID]; ok {
+			completed = p.completed
+		}
+		if event == EventCompleted && !completed {
+			sw.downloaded++
+			completed = true
+		}
+		sw.peers[params.PeerID] = &swarmPeer{ip: ip, port: params.Port, left: params.Left, seen: now, completed: completed}
+	}
+
+	resp := &Response{
+		Interval:    Optional(int64(s.interval / time.Second)),
+		MinInterval: Optional(int64(s.minInterval / time.Second)),
+	}
+	complete, incomplete := sw.counts()
+	resp.Complete, resp.Incomplete = &complete, &incomplete
+	resp.Downloaded = Optional(sw.downloaded)
+	for id, p := range sw.peers {
+		if int64(len(resp.Peers)) >= numWant {
+			break
+		}
+		// seeders need no other seeders, and port 0 does not accept connections.
+		if id == params.PeerID || p.port == 0 || (params.Left == 0 && p.left == 0) {
+			continue
+		}
+		if noPeerID {
+			id = ""
+		}
+		resp.Peers = append(resp.Peers, struct {
+			PeerID string
+			IP     string
+			Port   int64
+		}{PeerID: id, IP: p.ip, Port: p.port})
+	}
+	if len(sw.peers) == 0 {
+		// with its statistics, so that stale swarms do not pile up.
+		delete(s.swarms, params.InfoHash)
+	}
+	s.l.Unlock()
+
+	s.write(w, func(b *bytes.Buffer) error { return EncodeResponse(b, resp, compact) })
+}
+
+func (s *Server) scrape(w http.ResponseWriter, r *http.Request) {
+	hashes := r.URL.Query()["info_hash"]
+	for _, h := range hashes {
+		if !s.isAllowed(h) {
+			s.fail(w, "torrent is not tracked")
+			return
+		}
+	}
+
+	now := s.now()
+	files := make(map[string]ScrapeResponse)
+	s.l.Lock()
+	if len(hashes) == 0 {
+		// a scrape without info hashes reports every swarm.
+		for h := range s.swarms {
+			hashes = append(hashes, h)
+		}
+	}
+	for _, h := range hashes {
+		sw := s.swarms[h]
+		if sw == nil {
+			continue
+		}
+		s.expire(sw, now)
+		complete, incomplete := sw.counts()
+		files[h] = ScrapeResponse{Complete: complete, Incomplete: incomplete, Downloaded: sw.downloaded}
+	}
+	s.l.Unlock()
+
+	s.write(w, func(b *bytes.Buffer) error { return EncodeScrapeResponse(b, files) })
+}
+
+// expire drops the peers of the swarm that did not announce within the
+// expiry. The lock must be held.
+func (s *Server) expire(sw *swarm, now time.Time) {
+	for id, p := range sw.peers {
+		if now.Sub(p.seen) > s.expiry {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+// counts returns the number of seeders and leechers of the swarm.
+func (sw *swarm) counts() (complete, incomplete int64) {
+	for _, p := range sw.peers {
+		if p.left == 0 {
+			complete++
+		} else {
+			incomplete++
+		}
+	}
+	return complete, incomplete
+}
+
+func (s *Server) isAllowed(infoHash string) bool {
+	if s.allowed == nil {
+		return true
+	}
+	_, ok := s.allowed[infoHash]
+	return ok
+}
+
+// fail responds with a failure reason, which trackers send with status OK.
+func (s *Server) fail(w http.ResponseWriter, reason string) {
+	s.logger.Debug("refused tracker request", slog.String("reason", reason))
+	s.write(w, func(b *bytes.Buffer) error { return EncodeResponse(b, &Response{FailureReason: &reason}, false) })
+}
+
+func (s *Server) write(w http.ResponseWriter, encode func(b *bytes.Buffer) error) {
+	var b bytes.Buffer
+	if err := encode(&b); err != nil {
+		s.logger.Error("failed to encode tracker response", slog.Any("err", err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b.Bytes())
+}
+
+// parseRequestParams parses the parameters of an announce, the
+// counterpart of RequestParams.Encode.
+func parseRequestParams(q url.Values) (RequestParams, error) {
+	p := RequestParams{
+		InfoHash: q.Get("info_hash"),
+		PeerID:   q.Get("peer_id"),
+	}
+	if len(p.InfoHash) != 20 {
+		return RequestParams{}, fmt.Errorf("invalid info_hash of %d bytes", len(p.InfoHash))
+	}
+	if len(p.PeerID) != 20 {
+		return RequestParams{}, fmt.Errorf("invalid peer_id of %d bytes", len(p.PeerID))
+	}
+
+	for key, field := range map[string]*int64{
+		"port":       &p.Port,
+		"uploaded":   &p.Uploaded,
+		"downloaded": &p.Downloaded,
+		"left":       &p.Left,
+	} {
+		n, err := strconv.ParseInt(q.Get(key), 10, 64)
+		if err != nil {
+			return RequestParams{}, fmt.Errorf("invalid %s", key)
+		}
+		*field = n
+	}
+	for key, field := range map[string]**int64{
+		"compact":    &p.Compact,
+		"no_peer_id": &p.NoPeerId,
+		"numwant":    &p.NumWant,
+		"corrupt":    &p.Corrupt,
+	} {
+		if !q.Has(key) {
+			continue
+		}
+		n, err := strconv.ParseInt(q.Get(key), 10, 64)
+		if err != nil {
+			return RequestParams{}, fmt.Errorf("invalid %s", key)
+		}
+		*field = &n
+	}
+	if q.Has("event") && q.Get("event") != "" {
+		p.Event = Optional(Event(q.Get("event")))
+	}
+	if q.Has("key") {
+		p.Key = Optional(q.Get("key"))
+	}
+	if q.Has("trackerid") {
+		p.TrackerID = Optional(q.Get("trackerid"))
+	}
+
+	// clients commonly send both, which Validate refuses.
+	if p.Compact != nil && p.NoPeerId != nil {
+		p.NoPeerId = nil
+	}
+	if err := p.Validate(); err != nil {
+		return RequestParams{}, err
+	}
+	return p, nil
+}
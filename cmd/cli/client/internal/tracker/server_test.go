@@ -0,0 +1,213 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type peer = struct {
+	PeerID string
+	IP     string
+	Port   int64
+}
+
+func TestEncodeResponse(t *testing.T) {
+	resp := &Response{
+		WarningMessage: Optional("warning"),
+		Interval:       Optional[int64](60),
+		MinInterval:    Optional[int64](30),
+		TrackerID:      Optional("id"),
+		Complete:       Optional[int64](1),
+		Incomplete:     Optional[int64](2),
+		Downloaded:     Optional[int64](3),
+		Peers: []peer{
+			{PeerID: "-TT0100-000000000001", IP: "10.0.0.1", Port: 6881},
+			{PeerID: "-TT0100-000000000002", IP: "::1", Port: 6882},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		resp    *Response
+		compact bool
+		want    *Response
+	}{
+		{name: "non compact", resp: resp, want: resp},
+		{
+			name:    "compact without peer ids and ipv6 peers",
+			resp:    resp,
+			compact: true,
+			want: func() *Response {
+				r := *resp
+				r.Peers = []peer{{IP: "10.0.0.1", Port: 6881}}
+				return &r
+			}(),
+		},
+		{name: "failure", resp: &Response{FailureReason: Optional("refused"), Interval: Optional[int64](60)}, want: &Response{FailureReason: Optional("refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			assert.NoError(t, EncodeResponse(&b, tt.resp, tt.compact))
+			got := new(Response)
+			assert.NoError(t, DecodeResponse(&b, got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeScrapeResponse(t *testing.T) {
+	hash := strings.Repeat("a", 20)
+	var b bytes.Buffer
+	assert.NoError(t, EncodeScrapeResponse(&b, map[string]ScrapeResponse{hash: {Complete: 1, Incomplete: 2, Downloaded: 3}}))
+	got, err := DecodeScrapeResponse(bytes.NewReader(b.Bytes()), hash)
+	assert.NoError(t, err)
+	assert.Equal(t, &ScrapeResponse{Complete: 1, Incomplete: 2, Downloaded: 3}, got)
+}
+
+// newTestServer returns the announce url of s, whose clock is set by now.
+func newTestServer(t *testing.T, s *Server) (announce string, now *time.Time) {
+	t.Helper()
+	clock := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return clock }
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce", &clock
+}
+
+func TestServer_AnnounceLifecycle(t *testing.T) {
+	hash := strings.Repeat("h", 20)
+	announce, now := newTestServer(t, NewServer(WithInterval(time.Minute, 10*time.Second)))
+	ctx := context.Background()
+
+	send := func(id string, port, left int64, event *Event) *Response {
+		t.Helper()
+		resp, err := CreateRequest(ctx, announce, &RequestParams{
+			InfoHash: hash,
+			PeerID:   strings.Repeat(id, 20),
+			Port:     port,
+			Left:     left,
+			Compact:  Optional[int64](1),
+			Event:    event,
+		})
+		assert.NoError(t, err)
+		return resp
+	}
+	scrape := func() *ScrapeResponse {
+		t.Helper()
+		resp, err := Scrape(ctx, announce, hash)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	leecher := send("l", 6881, 100, Optional(EventStarted))
+	assert.Equal(t, int64(60), *leecher.Interval)
+	assert.Equal(t, int64(10), *leecher.MinInterval)
+	assert.Empty(t, leecher.Peers)
+	assert.Equal(t, int64(1), *leecher.Incomplete)
+
+	seeder := send("s", 6882, 0, Optional(EventStarted))
+	assert.Equal(t, []peer{{IP: "127.0.0.1", Port: 6881}}, seeder.Peers)
+	assert.Equal(t, int64(1), *seeder.Complete)
+	assert.Equal(t, int64(1), *seeder.Incomplete)
+
+	// peers that do not accept connections are not handed out.
+	send("x", 0, 100, Optional(EventStarted))
+	leecher = send("l", 6881, 100, nil)
+	assert.Equal(t, []peer{{IP: "127.0.0.1", Port: 6882}}, leecher.Peers)
+
+	// a regular announce may report the download before the completed event.
+	send("l", 6881, 0, nil)
+	leecher = send("l", 6881, 0, Optional(EventCompleted))
+	assert.Empty(t, leecher.Peers, "seeders are not handed out to seeders")
+	assert.Equal(t, int64(1), *leecher.Downloaded)
+	send("l", 6881, 0, Optional(EventCompleted))
+	assert.Equal(t, &ScrapeResponse{Complete: 2, Incomplete: 1, Downloaded: 1}, scrape())
+
+	send("s", 6882, 0, Optional(EventStopped))
+	assert.Equal(t, &ScrapeResponse{Complete: 1, Incomplete: 1, Downloaded: 1}, scrape())
+
+	// the peers expire after twice the interval without announcing.
+	*now = now.Add(90 * time.Second)
+	send("l", 6881, 0, nil)
+	*now = now.Add(90 * time.Second)
+	assert.Equal(t, &ScrapeResponse{Complete: 1, Downloaded: 1}, scrape())
+	*now = now.Add(90 * time.Second)
+	assert.Equal(t, &ScrapeResponse{Downloaded: 1}, scrape())
+
+	// swarms without peers are dropped once announced to.
+	send("x", 6883, 100, Optional(EventStopped))
+	_, err := Scrape(ctx, announce, hash)
+	assert.ErrorIs(t, err, ErrNotTracked)
+}
+
+func TestServer_NonCompact(t *testing.T) {
+	hash := strings.Repeat("h", 20)
+	announce, _ := newTestServer(t, NewServer())
+
+	get := func(id string, extra url.Values) *Response {
+		t.Helper()
+		q := url.Values{
+			"info_hash":  {hash},
+			"peer_id":    {strings.Repeat(id, 20)},
+			"port":       {"6881"},
+			"uploaded":   {"0"},
+			"downloaded": {"0"},
+			"left":       {"1"},
+		}
+		for k, v := range extra {
+			q[k] = v
+		}
+		resp, err := http.Get(announce + "?" + q.Encode())
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		out := new(Response)
+		assert.NoError(t, DecodeResponse(resp.Body, out))
+		assert.Nil(t, out.FailureReason)
+		return out
+	}
+
+	get("a", nil)
+	assert.Equal(t, []peer{{PeerID: strings.Repeat("a", 20), IP: "127.0.0.1", Port: 6881}}, get("b", url.Values{"compact": {"0"}}).Peers)
+	assert.Equal(t, []peer{{IP: "127.0.0.1", Port: 6881}}, get("b", url.Values{"compact": {"0"}, "no_peer_id": {"1"}}).Peers)
+	// clients commonly send both, compact wins.
+	assert.Equal(t, []peer{{IP: "127.0.0.1", Port: 6881}}, get("b", url.Values{"compact": {"1"}, "no_peer_id": {"1"}}).Peers)
+	assert.Len(t, get("b", url.Values{"numwant": {"0"}}).Peers, 0)
+}
+
+func TestServer_Refused(t *testing.T) {
+	allowed := strings.Repeat("a", 20)
+	announce, _ := newTestServer(t, NewServer(WithAllowlist(allowed)))
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		params RequestParams
+	}{
+		{name: "not allowed", params: RequestParams{InfoHash: strings.Repeat("b", 20), PeerID: strings.Repeat("p", 20), Port: 6881}},
+		{name: "short info hash", params: RequestParams{InfoHash: "a", PeerID: strings.Repeat("p", 20), Port: 6881}},
+		{name: "short peer id", params: RequestParams{InfoHash: allowed, PeerID: "p", Port: 6881}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CreateRequest(ctx, announce, &tt.params)
+			var failure *FailureError
+			assert.ErrorAs(t, err, &failure)
+		})
+	}
+
+	_, err := Scrape(ctx, announce, strings.Repeat("b", 20))
+	var failure *FailureError
+	assert.ErrorAs(t, err, &failure)
+
+	_, err = CreateRequest(ctx, announce, &RequestParams{InfoHash: allowed, PeerID: strings.Repeat("p", 20), Port: 6881})
+	assert.NoError(t, err)
+}
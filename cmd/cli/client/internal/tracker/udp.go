@@ -0,0 +1,332 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultUDPRetransmit is how long a request to a UDP tracker waits for
+// the response before it is sent again, doubled with every retransmission.
+// See https://www.bittorrent.org/beps/bep_0015.html.
+const DefaultUDPRetransmit = 15 * time.Second
+
+// udpProtocolID is the magic constant of the connect request.
+const udpProtocolID = 0x41727101980
+
+// udpMaxRetransmits bounds how often a request is sent again, unless the
+// request times out before.
+const udpMaxRetransmits = 8
+
+// udpMaxPacket is the largest UDP payload.
+const udpMaxPacket = 65507
+
+const (
+	udpActionConnect  uint32 = 0
+	udpActionAnnounce uint32 = 1
+	udpActionScrape   uint32 = 2
+	udpActionError    uint32 = 3
+)
+
+// ErrMalformedUDPResponse is returned if a UDP tracker responded
+// with a packet too short for the action requested.
+var ErrMalformedUDPResponse = errors.New("malformed udp tracker response")
+
+// IsUDP reports whether announce is the URL of a UDP tracker.
+func IsUDP(announce string) bool {
+	u, err := url.Parse(announce)
+	return err == nil && u.Scheme == "udp"
+}
+
+// udpSession is a connection to a UDP tracker, after the connect
+// request was answered.
+type udpSession struct {
+	c    *Client
+	conn net.Conn
+	id   uint64
+}
+
+// dialUDP connects to the UDP tracker at announce. The host is resolved
+// by the dialer and the first of its addresses is used, which may be an
+// IPv6 one. Dialing UDP does not fail for unreachable addresses, so the
+// others are not tried.
+func (c *Client) dialUDP(ctx context.Context, announce string) (*udpSession, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		// the error of url.Parse contains the url, which may embed credentials.
+		return nil, errors.New("invalid tracker url")
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("udp tracker %s has no port", RedactURL(announce))
+	}
+
+	dial := c.Dial
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	conn, err := dial(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial udp tracker: %w", err)
+	}
+
+	s := &udpSession{c: c, conn: conn}
+	req := binary.BigEndian.AppendUint64(nil, udpProtocolID)
+	req = binary.BigEndian.AppendUint32(req, udpActionConnect)
+	req = binary.BigEndian.AppendUint32(req, 0) // transaction id
+	resp, err := s.roundTrip(ctx, req, udpActionConnect)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to udp tracker: %w", err)
+	}
+	if len(resp) < 8 {
+		conn.Close()
+		return nil, fmt.Errorf("%w: connect response of %d bytes", ErrMalformedUDPResponse, len(resp))
+	}
+	s.id = binary.BigEndian.Uint64(resp)
+	return s, nil
+}
+
+func (s *udpSession) Close() error { return s.conn.Close() }
+
+// ipv6 reports whether the tracker is reached over IPv6, in which case
+// the announce responses list the peers with their IPv6 addresses.
+func (s *udpSession) ipv6() bool {
+	addr, ok := s.conn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	return ok && !ip.Unmap().Is4()
+}
+
+// request prepends the connection id, the action and the
+// transaction id, to be filled in by roundTrip, to body.
+func (s *udpSession) request(action uint32, body []byte) []byte {
+	req := binary.BigEndian.AppendUint64(make([]byte, 0, 16+len(body)), s.id)
+	req = binary.BigEndian.AppendUint32(req, action)
+	req = binary.BigEndian.AppendUint32(req, 0) // transaction id
+	return append(req, body...)
+}
+
+// roundTrip sends req with a new transaction id and returns the body of
+// the response to it, following the action and transaction id. Packets
+// with another transaction id or too short to carry one are discarded.
+// If no response arrives the request is sent again with a doubled wait.
+func (s *udpSession) roundTrip(ctx context.Context, req []byte, action uint32) ([]byte, error) {
+	tid := rand.Uint32()
+	binary.BigEndian.PutUint32(req[12:16], tid)
+
+	// reads are interrupted once ctx is done.
+	stop := context.AfterFunc(ctx, func() { s.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	wait := s.c.UDPRetransmit
+	if wait <= 0 {
+		wait = DefaultUDPRetransmit
+	}
+
+	buf := make([]byte, udpMaxPacket)
+	for attempt := 0; attempt <= udpMaxRetransmits; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, err := s.conn.Write(req); err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		deadline := time.Now().Add(wait << attempt)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for {
+			n, err := s.conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				// the timer of ctx may not have fired yet.
+				if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+					return nil, context.DeadlineExceeded
+				}
+				break // retransmit.
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read response: %w", err)
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != tid {
+				continue
+			}
+
+			body := append([]byte(nil), buf[8:n]...)
+			switch got := binary.BigEndian.Uint32(buf[:4]); got {
+			case action:
+				return body, nil
+			case udpActionError:
+				return nil, &FailureError{Reason: string(body)}
+			default:
+				return nil, fmt.Errorf("%w: expected action %d but got %d", ErrMalformedUDPResponse, action, got)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response after %d retransmits", udpMaxRetransmits)
+}
+
+// udpEvents are the event ids of the announce request.
+var udpEvents = map[Event]uint32{
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+// announceUDP announces to the UDP tracker at announce.
+func (c *Client) announceUDP(ctx context.Context, announce string, params *RequestParams) (*Response, error) {
+	ctx, cancel := c.udpContext(ctx)
+	defer cancel()
+
+	s, err := c.dialUDP(ctx, announce)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	body := make([]byte, 0, 82)
+	body = append(body, params.InfoHash...)
+	body = append(body, params.PeerID...)
+	body = binary.BigEndian.AppendUint64(body, uint64(params.Downloaded))
+	body = binary.BigEndian.AppendUint64(body, uint64(params.Left))
+	body = binary.BigEndian.AppendUint64(body, uint64(params.Uploaded))
+	var event uint32
+	if params.Event != nil {
+		event = udpEvents[*params.Event]
+	}
+	body = binary.BigEndian.AppendUint32(body, event)
+	var ip [4]byte
+	if params.IP != nil {
+		if v4 := net.ParseIP(*params.IP).To4(); v4 != nil {
+			copy(ip[:], v4)
+		}
+	}
+	body = append(body, ip[:]...)
+	var key uint32
+	if params.Key != nil {
+		key = udpKey(*params.Key)
+	}
+	body = binary.BigEndian.AppendUint32(body, key)
+	numWant := int32(-1)
+	if params.NumWant != nil {
+		numWant = int32(min(*params.NumWant, 1<<31-1))
+	}
+	body = binary.BigEndian.AppendUint32(body, uint32(numWant))
+	body = binary.BigEndian.AppendUint16(body, uint16(params.Port))
+
+	resp, err := s.roundTrip(ctx, s.request(udpActionAnnounce, body), udpActionAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUDPAnnounce(resp, s.ipv6())
+}
+
+// decodeUDPAnnounce decodes the body of an announce response, whose peers
+// are listed with their IPv6 addresses if the tracker was reached over IPv6.
+func decodeUDPAnnounce(b []byte, ipv6 bool) (*Response, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("%w: announce response of %d bytes", ErrMalformedUDPResponse, len(b))
+	}
+	interval := int64(binary.BigEndian.Uint32(b[0:4]))
+	leechers := int64(binary.BigEndian.Uint32(b[4:8]))
+	seeders := int64(binary.BigEndian.Uint32(b[8:12]))
+	out := &Response{Interval: &interval, Incomplete: &leechers, Complete: &seeders}
+
+	size := 6
+	if ipv6 {
+		size = 18
+	}
+	peers := b[12:]
+	if len(peers)%size != 0 {
+		return nil, fmt.Errorf("%w: expected length of peers to be a multiple of %d but got %d", ErrMalformedUDPResponse, size, len(peers))
+	}
+	for i := 0; i < len(peers); i += size {
+		peer := peers[i : i+size]
+		var peerData struct {
+			PeerID string
+			IP     string
+			Port   int64
+		}
+		peerData.IP = net.IP(peer[:size-2]).String()
+		peerData.Port = int64(binary.BigEndian.Uint16(peer[size-2:]))
+		out.Peers = append(out.Peers, peerData)
+	}
+	return out, nil
+}
+
+// scrapeUDP requests the statistics of the torrents with infoHashes from
+// the UDP tracker at announce, in the order of infoHashes.
+func (c *Client) scrapeUDP(ctx context.Context, announce string, infoHashes ...string) ([]ScrapeResponse, error) {
+	ctx, cancel := c.udpContext(ctx)
+	defer cancel()
+
+	s, err := c.dialUDP(ctx, announce)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var body []byte
+	for _, h := range infoHashes {
+		body = append(body, h...)
+	}
+	resp, err := s.roundTrip(ctx, s.request(udpActionScrape, body), udpActionScrape)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 12*len(infoHashes) {
+		return nil, fmt.Errorf("%w: scrape response of %d bytes for %d torrents", ErrMalformedUDPResponse, len(resp), len(infoHashes))
+	}
+
+	out := make([]ScrapeResponse, len(infoHashes))
+	for i := range out {
+		f := resp[12*i:]
+		out[i] = ScrapeResponse{
+			Complete:   int64(binary.BigEndian.Uint32(f[0:4])),
+			Downloaded: int64(binary.BigEndian.Uint32(f[4:8])),
+			Incomplete: int64(binary.BigEndian.Uint32(f[8:12])),
+		}
+	}
+	return out, nil
+}
+
+// udpContext bounds the requests to UDP trackers to Timeout, as the
+// HTTP requests are bounded by the timeout of their client.
+func (c *Client) udpContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// udpKey converts the key of an announce to the 32 bits a UDP
+// announce carries. Keys of 8 hex digits are decoded, others hashed.
+func udpKey(key string) uint32 {
+	if b, err := hex.DecodeString(key); err == nil && len(b) == 4 {
+		return binary.BigEndian.Uint32(b)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,244 @@
+package tracker_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	udpInfoHash = "01234567890123456789"
+	udpPeerID   = "-TT0100-000000000001"
+)
+
+// udpTracker serves the requests sent to the UDP socket on addr with
+// handle, which returns the packets answering the request. The connect
+// requests are answered with the connection id 42.
+func udpTracker(t *testing.T, addr string, handle func(action uint32, req []byte) [][]byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := append([]byte(nil), buf[:n]...)
+			action := binary.BigEndian.Uint32(req[8:12])
+			var resp [][]byte
+			if action == 0 {
+				resp = [][]byte{udpResponse(0, req, binary.BigEndian.AppendUint64(nil, 42))}
+			} else {
+				resp = handle(action, req)
+			}
+			for _, p := range resp {
+				conn.WriteTo(p, from)
+			}
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String() + "/announce"
+}
+
+// udpResponse answers req with action and body.
+func udpResponse(action uint32, req, body []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, action)
+	out = append(out, req[12:16]...)
+	return append(out, body...)
+}
+
+func udpAnnounceResponse(req []byte, peers ...netip.AddrPort) []byte {
+	body := binary.BigEndian.AppendUint32(nil, 1800) // interval
+	body = binary.BigEndian.AppendUint32(body, 3)    // leechers
+	body = binary.BigEndian.AppendUint32(body, 7)    // seeders
+	for _, p := range peers {
+		body = append(body, p.Addr().AsSlice()...)
+		body = binary.BigEndian.AppendUint16(body, p.Port())
+	}
+	return udpResponse(1, req, body)
+}
+
+func udpAnnounceParams() *tracker.RequestParams {
+	return &tracker.RequestParams{
+		InfoHash: udpInfoHash,
+		PeerID:   udpPeerID,
+		Port:     6881,
+		Left:     100,
+		Event:    tracker.Optional(tracker.EventStarted),
+		NumWant:  tracker.Optional[int64](30),
+		Key:      tracker.Optional("0a0b0c0d"),
+	}
+}
+
+func TestCreateRequest_UDP(t *testing.T) {
+	requests := make(chan []byte, 1)
+	announce := udpTracker(t, "127.0.0.1:0", func(action uint32, req []byte) [][]byte {
+		select {
+		case requests <- req:
+		default:
+		}
+		return [][]byte{udpAnnounceResponse(req, netip.MustParseAddrPort("10.0.0.1:6881"), netip.MustParseAddrPort("10.0.0.2:51413"))}
+	})
+
+	resp, err := tracker.CreateRequest(context.Background(), announce, udpAnnounceParams())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1800), *resp.Interval)
+	assert.Equal(t, int64(7), *resp.Complete)
+	assert.Equal(t, int64(3), *resp.Incomplete)
+	if assert.Len(t, resp.Peers, 2) {
+		assert.Equal(t, "10.0.0.1", resp.Peers[0].IP)
+		assert.Equal(t, int64(6881), resp.Peers[0].Port)
+		assert.Equal(t, "10.0.0.2", resp.Peers[1].IP)
+		assert.Equal(t, int64(51413), resp.Peers[1].Port)
+	}
+
+	got := <-requests
+	if assert.Len(t, got, 98) {
+		assert.Equal(t, uint64(42), binary.BigEndian.Uint64(got[0:8]))
+		assert.Equal(t, udpInfoHash, string(got[16:36]))
+		assert.Equal(t, udpPeerID, string(got[36:56]))
+		assert.Equal(t, uint64(100), binary.BigEndian.Uint64(got[64:72]))
+		assert.Equal(t, uint32(2), binary.BigEndian.Uint32(got[80:84]), "event")
+		assert.Equal(t, uint32(0x0a0b0c0d), binary.BigEndian.Uint32(got[88:92]), "key")
+		assert.Equal(t, uint32(30), binary.BigEndian.Uint32(got[92:96]), "num want")
+		assert.Equal(t, uint16(6881), binary.BigEndian.Uint16(got[96:98]), "port")
+	}
+}
+
+func TestCreateRequest_UDPv6(t *testing.T) {
+	announce := udpTracker(t, "[::1]:0", func(action uint32, req []byte) [][]byte {
+		if action == 2 {
+			return [][]byte{udpResponse(2, req, make([]byte, 12))}
+		}
+		return [][]byte{udpAnnounceResponse(req, netip.MustParseAddrPort("[2001:db8::1]:6881"))}
+	})
+
+	resp, err := tracker.CreateRequest(context.Background(), announce, udpAnnounceParams())
+	if assert.NoError(t, err) && assert.Len(t, resp.Peers, 1) {
+		assert.Equal(t, "2001:db8::1", resp.Peers[0].IP)
+		assert.Equal(t, int64(6881), resp.Peers[0].Port)
+	}
+
+	_, err = tracker.Scrape(context.Background(), announce, udpInfoHash)
+	assert.NoError(t, err)
+}
+
+func TestScrape_UDP(t *testing.T) {
+	announce := udpTracker(t, "127.0.0.1:0", func(action uint32, req []byte) [][]byte {
+		if action != 2 || string(req[16:]) != udpInfoHash {
+			return nil
+		}
+		body := binary.BigEndian.AppendUint32(nil, 5)  // seeders
+		body = binary.BigEndian.AppendUint32(body, 50) // completed
+		body = binary.BigEndian.AppendUint32(body, 10) // leechers
+		return [][]byte{udpResponse(2, req, body)}
+	})
+
+	resp, err := tracker.Scrape(context.Background(), announce, udpInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &tracker.ScrapeResponse{Complete: 5, Incomplete: 10, Downloaded: 50}, resp)
+	}
+}
+
+func TestCreateRequest_UDPResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handle  func(attempt int, req []byte) [][]byte
+		wantErr error
+	}{
+		{
+			name: "transaction id mismatch is discarded",
+			handle: func(_ int, req []byte) [][]byte {
+				other := udpAnnounceResponse(req)
+				other[4] ^= 0xff
+				return [][]byte{other, udpAnnounceResponse(req)}
+			},
+		},
+		{
+			name: "lost response is retransmitted",
+			handle: func(attempt int, req []byte) [][]byte {
+				if attempt == 0 {
+					return nil
+				}
+				return [][]byte{udpAnnounceResponse(req)}
+			},
+		},
+		{
+			name: "packet too short for a transaction id is discarded",
+			handle: func(_ int, req []byte) [][]byte {
+				return [][]byte{{0, 0, 0}, udpAnnounceResponse(req)}
+			},
+		},
+		{
+			name: "short announce response",
+			handle: func(_ int, req []byte) [][]byte {
+				return [][]byte{udpResponse(1, req, []byte{0, 0, 7, 8})}
+			},
+			wantErr: tracker.ErrMalformedUDPResponse,
+		},
+		{
+			name: "truncated peer",
+			handle: func(_ int, req []byte) [][]byte {
+				resp := udpAnnounceResponse(req, netip.MustParseAddrPort("10.0.0.1:6881"))
+				return [][]byte{resp[:len(resp)-1]}
+			},
+			wantErr: tracker.ErrMalformedUDPResponse,
+		},
+		{
+			name: "error",
+			handle: func(_ int, req []byte) [][]byte {
+				return [][]byte{udpResponse(3, req, []byte("unregistered torrent"))}
+			},
+			wantErr: &tracker.FailureError{Reason: "unregistered torrent"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			announce := udpTracker(t, "127.0.0.1:0", func(action uint32, req []byte) [][]byte {
+				return tt.handle(int(attempts.Add(1)-1), req)
+			})
+
+			c := &tracker.Client{UDPRetransmit: 50 * time.Millisecond, Timeout: 5 * time.Second}
+			resp, err := c.CreateRequest(context.Background(), announce, udpAnnounceParams())
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if assert.NoError(t, err) {
+					assert.Equal(t, int64(7), *resp.Complete)
+				}
+			case *tracker.FailureError:
+				var fe *tracker.FailureError
+				if assert.True(t, errors.As(err, &fe)) {
+					assert.Equal(t, want.Reason, fe.Reason)
+				}
+			default:
+				assert.ErrorIs(t, err, want)
+			}
+		})
+	}
+}
+
+func TestCreateRequest_UDPTimeout(t *testing.T) {
+	announce := udpTracker(t, "127.0.0.1:0", func(uint32, []byte) [][]byte { return nil })
+
+	c := &tracker.Client{UDPRetransmit: 10 * time.Millisecond, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	_, err := c.CreateRequest(context.Background(), announce, udpAnnounceParams())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/blocklist"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+)
+
+// LoadBlocklist replaces the blocklist of the client, see WithBlocklist.
+// Established connections to peers on the new list are kept, unless
+// disconnect is set. On error the previous blocklist stays in effect.
+func (p *Client) LoadBlocklist(r io.Reader, disconnect bool) error {
+	list, err := blocklist.Parse(r)
+	if err != nil {
+		return err
+	}
+	p.blocklist.Store(list)
+	p.logger.Info("loaded blocklist", slog.Int("ranges", list.Len()))
+
+	if !disconnect {
+		return nil
+	}
+	var closed int
+	p.torrentsDownloading.Range(func(_, value any) bool {
+		closed += value.(*status.TorrentSession).DisconnectPeers(list.Contains)
+		return true
+	})
+	if closed > 0 {
+		p.logger.Info("disconnected peers on the blocklist", slog.Int("peers", closed))
+	}
+	return nil
+}
+
+// BlockedConnections returns the number of connections from peers that
+// were refused as they are on the blocklist. Peers on the blocklist are
+// never contacted, so that no connections to them are refused.
+func (p *Client) BlockedConnections() int64 { return p.blocked.Load() }
+
+// isBlocked reports whether addr is on the blocklist. The torrents check
+// the peers they learn about with it, repeatedly, which is not counted.
+func (p *Client) isBlocked(addr netip.Addr) bool { return p.blocklist.Load().Contains(addr) }
+
+// isBlockedConn reports whether the remote address of conn is on the
+// blocklist, counting the refused connection.
+func (p *Client) isBlockedConn(conn net.Conn) bool {
+	ap, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		p.logger.Debug("failed to parse address of peer", slog.String("addr", conn.RemoteAddr().String()))
+		return false
+	}
+	if !p.isBlocked(ap.Addr()) {
+		return false
+	}
+	p.blocked.Add(1)
+	return true
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Blocklist(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithBlocklist(strings.NewReader("not a range")))
+	assert.Error(t, err)
+
+	c, err := New(
+		WithLogger(logger),
+		WithDownloadDir(t.TempDir()),
+		WithAction(Both),
+		WithPort(0),
+		WithBlocklist(strings.NewReader("Loopback:127.000.000.000-127.255.255.255\n")),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	// closed reports whether the client closed the connection
+	// right away, instead of waiting for the handshake.
+	closed := func() bool {
+		t.Helper()
+		port := c.seedServer.Addr().(*net.TCPAddr).Port
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+		assert.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		_, err = conn.Read(make([]byte, 1))
+		return !errors.Is(err, os.ErrDeadlineExceeded)
+	}
+
+	assert.True(t, closed())
+	assert.Equal(t, int64(1), c.BlockedConnections())
+
+	// checking the peers of the torrents again refuses no connection.
+	for range 3 {
+		assert.True(t, c.isBlocked(netip.MustParseAddr("127.0.0.2")))
+	}
+	assert.Equal(t, int64(1), c.BlockedConnections())
+
+	assert.Error(t, c.LoadBlocklist(strings.NewReader("10.0.0.0/33"), false))
+	assert.True(t, closed(), "previous blocklist stays in effect")
+
+	assert.NoError(t, c.LoadBlocklist(strings.NewReader("10.0.0.0/8"), true))
+	assert.False(t, closed())
+	assert.Equal(t, int64(2), c.BlockedConnections())
+}
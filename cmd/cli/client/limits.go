@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+)
+
+// ErrInvalidLimit is returned for negative limits of a torrent.
+var ErrInvalidLimit = errors.New("limit cannot be negative")
+
+// TorrentLimits are the limits of a torrent that can be changed while it
+// downloads, zero if unset. They persist across restarts of the client
+// if WithSessionRestore is set.
+type TorrentLimits struct {
+	// MaxPeers is the number of seeders the torrent is connected to at
+	// most, within its share of the connections of all torrents.
+	MaxPeers int `json:"maxPeers"`
+	// MaxActivePieces is the number of pieces downloaded concurrently,
+	// which is derived from the share of the torrent if unset.
+	MaxActivePieces int `json:"maxActivePieces"`
+	// MaxPipeline is the number of unanswered requests sent to a seeder
+	// at most, which is derived from the rate of the seeder if unset.
+	MaxPipeline int `json:"maxPipeline"`
+}
+
+func torrentLimits(l status.Limits) TorrentLimits {
+	return TorrentLimits{MaxPeers: l.MaxPeers, MaxActivePieces: l.MaxActivePieces, MaxPipeline: l.MaxPipeline}
+}
+
+// Limits returns the limits of the torrent with the given id.
+func (p *Client) Limits(id string) (TorrentLimits, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return TorrentLimits{}, err
+	}
+	return torrentLimits(tr.Limits()), nil
+}
+
+// SetMaxPeers changes the number of seeders the torrent with the given id
+// is connected to at most, zero leaves it unbounded. If it is connected to
+// more, the seeders contributing the least are disconnected: the snubbed
+// ones first, then those choking this client, then the slowest ones.
+func (p *Client) SetMaxPeers(id string, n int) error {
+	return p.setLimit(id, "max peers", n, (*status.TorrentSession).SetMaxPeers)
+}
+
+// SetMaxActivePieces changes the number of pieces of the torrent with the
+// given id that are downloaded concurrently, zero derives it from the share
+// of the torrent. The pieces downloading are completed, fewer pieces are
+// started until the new limit is reached.
+func (p *Client) SetMaxActivePieces(id string, n int) error {
+	return p.setLimit(id, "max active pieces", n, (*status.TorrentSession).SetMaxActivePieces)
+}
+
+// SetMaxPipeline changes the number of unanswered requests sent to each
+// seeder of the torrent with the given id at most, zero derives it from
+// the rate of the seeder.
+func (p *Client) SetMaxPipeline(id string, n int) error {
+	return p.setLimit(id, "max pipeline", n, (*status.TorrentSession).SetMaxPipeline)
+}
+
+// setLimit applies the limit called name to the torrent with the given id
+// with set, and persists it with the torrent.
+func (p *Client) setLimit(id, name string, n int, set func(tr *status.TorrentSession, n int)) error {
+	if n < 0 {
+		return fmt.Errorf("invalid %s %d: %w", name, n, ErrInvalidLimit)
+	}
+	err := p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		set(tr, n)
+		p.logger.Info("changed limit of torrent", slog.String("infoHash", id), slog.String("limit", name), slog.Int("value", n))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.saveRestore()
+	return nil
+}
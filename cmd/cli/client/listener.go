@@ -4,12 +4,62 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer"
 )
 
+const (
+	// acceptRetryMin and acceptRetryMax bound the delay
+	// before accepting connections again after a failure.
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = 1 * time.Second
+)
+
+// limitedConn releases its connection of the limit once closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// listenAddrs returns the addresses leechers connect to this client at,
+// the listen port on each address of the local interfaces.
+func (p *Client) listenAddrs() []netip.AddrPort {
+	if p.seedServer == nil {
+		return nil
+	}
+	addr, ok := p.seedServer.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	ifaces, err := net.InterfaceAddrs()
+	if err != nil {
+		p.logger.Debug("failed to list the addresses of the local interfaces", slog.Any("err", err))
+		return nil
+	}
+	var out []netip.AddrPort
+	for _, a := range ifaces {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip, ok := netip.AddrFromSlice(ipNet.IP); ok {
+			out = append(out, netip.AddrPortFrom(ip.Unmap(), uint16(addr.Port)))
+		}
+	}
+	return out
+}
+
 func (p *Client) handlePeer(conn net.Conn) {
 	addr := conn.RemoteAddr().String()
 	closeConn := true
@@ -57,10 +107,19 @@ func (p *Client) handlePeer(conn net.Conn) {
 		)
 		return
 	}
+	if h.PeerID == p.id {
+		p.logger.Debug("rejecting connection from this client", slog.String("leecher", addr))
+		return
+	}
+
+	var opts []peer.Option
+	if h.FastExtension() {
+		opts = append(opts, peer.WithFastExtension())
+	}
 
 	p.torrentsDownloading.Range(func(key, value any) bool {
 		if key.(string) == h.InfoHash {
-			if err := value.(*status.Tracker).AddLeecher(h.PeerID, conn); err != nil {
+			if err := value.(*status.TorrentSession).AddLeecher(h.PeerID, conn, opts...); err != nil {
 				p.logger.Error("failed to add new leecher",
 					slog.String("leecher", addr),
 					slog.String("err", err.Error()),
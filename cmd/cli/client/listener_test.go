@@ -49,7 +49,7 @@ func TestAddNewPeer(t *testing.T) {
 		Port   int64
 	}{PeerID: "", IP: peerAddr, Port: port})
 
-	tracker, err := status.NewTracker(string(id[:]), logger, tr, "./testDownload")
+	tracker, err := status.NewTorrentSession(string(id[:]), logger, tr, "./testDownload")
 	assert.Nil(t, err)
 
 	err = tracker.UpdateSeeders(&resp)
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// MagnetLink returns the magnet link to share t with. It lists the trackers
+// of t, except those embedding credentials, which must not be shared.
+func MagnetLink(t *torrent.MetaInfoFile) string {
+	mg := torrent.Magnet{InfoHash: t.Metadata.Hash, Name: t.Name()}
+	for _, u := range t.Trackers() {
+		if !tracker.HasCredentials(u) {
+			mg.Trackers = append(mg.Trackers, u)
+		}
+	}
+	return mg.String()
+}
+
+// RedactURL returns the URL raw without its userinfo, so that credentials
+// embedded in the tracker URLs of a torrent are not shown.
+func RedactURL(raw string) string { return tracker.RedactURL(raw) }
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+)
+
+type (
+	// Metrics is a snapshot of the counters of a torrent for monitoring.
+	Metrics = status.Metrics
+	// Histogram is a snapshot of a histogram of durations.
+	Histogram = status.Histogram
+)
+
+// retiredMetrics are the counters of the removed torrents, which
+// the client wide counters must keep including to stay monotonic.
+type retiredMetrics struct {
+	received atomic.Int64
+	uploaded atomic.Int64
+}
+
+// Metrics returns the counters of the torrent with the given id.
+func (p *Client) Metrics(id string) (Metrics, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return Metrics{}, err
+	}
+	return tr.Metrics(), nil
+}
+
+// retireMetrics keeps the counters of the removed torrent in the client wide ones.
+func (p *Client) retireMetrics(tr *status.TorrentSession) {
+	m := tr.Metrics()
+	p.retired.received.Add(m.Received)
+	p.retired.uploaded.Add(m.Uploaded)
+}
+
+// WriteMetrics writes the metrics of the client and its torrents in the
+// Prometheus text exposition format. The torrents are labeled by their
+// hex encoded info hash.
+func (p *Client) WriteMetrics(w io.Writer) error {
+	type torrentMetrics struct {
+		hash  string
+		state TorrentState
+		m     Metrics
+	}
+	var torrents []torrentMetrics
+	p.torrentsDownloading.Range(func(key, value any) bool {
+		tr := value.(*status.TorrentSession)
+		torrents = append(torrents, torrentMetrics{
+			hash:  hex.EncodeToString([]byte(key.(string))),
+			state: torrentState(tr),
+			m:     tr.Metrics(),
+		})
+		return true
+	})
+	slices.SortFunc(torrents, func(a, b torrentMetrics) int { return cmp.Compare(a.hash, b.hash) })
+
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+
+	received, uploaded := p.retired.received.Load(), p.retired.uploaded.Load()
+	for _, t := range torrents {
+		received += t.m.Received
+		uploaded += t.m.Uploaded
+	}
+	mw.family("tinytorrent_received_bytes_total", "counter", "Bytes of blocks received from peers by all torrents, including removed ones.")
+	mw.sample("tinytorrent_received_bytes_total", "", float64(received))
+	mw.family("tinytorrent_uploaded_bytes_total", "counter", "Bytes uploaded to peers by all torrents, including removed ones.")
+	mw.sample("tinytorrent_uploaded_bytes_total", "", float64(uploaded))
+
+	cache := p.CacheStats()
+	mw.family("tinytorrent_piece_cache_hits_total", "counter", "Blocks uploaded from cached pieces.")
+	mw.sample("tinytorrent_piece_cache_hits_total", "", float64(cache.Hits))
+	mw.family("tinytorrent_piece_cache_misses_total", "counter", "Blocks uploaded whose piece was read from disk.")
+	mw.sample("tinytorrent_piece_cache_misses_total", "", float64(cache.Misses))
+	mw.family("tinytorrent_piece_cache_bytes", "gauge", "Bytes of the cached pieces.")
+	mw.sample("tinytorrent_piece_cache_bytes", "", float64(cache.Size))
+	mw.family("tinytorrent_piece_cache_limit_bytes", "gauge", "Budget of the piece cache, 0 if disabled.")
+	mw.sample("tinytorrent_piece_cache_limit_bytes", "", float64(cache.Budget))
+
+	used, limit := p.ConnStats()
+	mw.family("tinytorrent_connections", "gauge", "Peer connections of all torrents.")
+	mw.sample("tinytorrent_connections", "", float64(used))
+	mw.family("tinytorrent_connections_limit", "gauge", "Limit of the peer connections of all torrents, 0 if unbounded.")
+	mw.sample("tinytorrent_connections_limit", "", float64(limit))
+
+	mw.family("tinytorrent_torrents", "gauge", "Tracked torrents by state.")
+	for _, state := range []TorrentState{StateDownloading, StateSeeding, StatePaused, StateQueued, StateChecking, StateError, StateFinished} {
+		var n int
+		for _, t := range torrents {
+			if t.state == state {
+				n++
+			}
+		}
+		mw.sample("tinytorrent_torrents", fmt.Sprintf(`state=%q`, state), float64(n))
+	}
+
+	perTorrent := []struct {
+		name, typ, help string
+		value           func(m *Metrics) float64
+	}{
+		{"tinytorrent_torrent_received_bytes_total", "counter", "Bytes of blocks received from peers.", func(m *Metrics) float64 { return float64(m.Received) }},
+		{"tinytorrent_torrent_uploaded_bytes_total", "counter", "Bytes uploaded to peers.", func(m *Metrics) float64 { return float64(m.Uploaded) }},
+		{"tinytorrent_torrent_verified_bytes", "gauge", "Bytes of the verified pieces.", func(m *Metrics) float64 { return float64(m.Downloaded) }},
+		{"tinytorrent_torrent_download_rate_bytes_per_second", "gauge", "Smoothed download rate in bytes per second.", func(m *Metrics) float64 { return float64(m.DownloadRate) }},
+		{"tinytorrent_torrent_upload_rate_bytes_per_second", "gauge", "Smoothed upload rate in bytes per second.", func(m *Metrics) float64 { return float64(m.UploadRate) }},
+		{"tinytorrent_torrent_pieces_verified_total", "counter", "Pieces that passed verification.", func(m *Metrics) float64 { return float64(m.PiecesVerified) }},
+		{"tinytorrent_torrent_hash_failures_total", "counter", "Pieces that failed verification.", func(m *Metrics) float64 { return float64(m.HashFailures) }},
+		{"tinytorrent_torrent_resume_writes_total", "counter", "Writes of the resume data.", func(m *Metrics) float64 { return float64(m.ResumeWrites) }},
+		{"tinytorrent_torrent_resume_coalesced_total", "counter", "Flushed pieces persisted by the resume data write of a later piece.", func(m *Metrics) float64 { return float64(m.ResumeCoalesced) }},
+	}
+	for _, f := range perTorrent {
+		mw.family(f.name, f.typ, f.help)
+		for _, t := range torrents {
+			mw.sample(f.name, torrentLabel(t.hash), f.value(&t.m))
+		}
+	}
+
+	mw.family("tinytorrent_torrent_seeders", "gauge", "Connected seeders by whether they choke this client.")
+	for _, t := range torrents {
+		mw.sample("tinytorrent_torrent_seeders", torrentLabel(t.hash)+`,choking="true"`, float64(t.m.SeedersChoking))
+		mw.sample("tinytorrent_torrent_seeders", torrentLabel(t.hash)+`,choking="false"`, float64(t.m.SeedersUnchoking))
+	}
+	mw.family("tinytorrent_torrent_leechers", "gauge", "Connected leechers by whether this client chokes them.")
+	for _, t := range torrents {
+		mw.sample("tinytorrent_torrent_leechers", torrentLabel(t.hash)+`,choked="true"`, float64(t.m.LeechersChoked))
+		mw.sample("tinytorrent_torrent_leechers", torrentLabel(t.hash)+`,choked="false"`, float64(t.m.LeechersUnchoked))
+	}
+	mw.family("tinytorrent_torrent_announces_total", "counter", "Announces to the tracker by whether they succeeded.")
+	for _, t := range torrents {
+		mw.sample("tinytorrent_torrent_announces_total", torrentLabel(t.hash)+`,result="success"`, float64(t.m.AnnounceSuccesses))
+		mw.sample("tinytorrent_torrent_announces_total", torrentLabel(t.hash)+`,result="failure"`, float64(t.m.AnnounceFailures))
+	}
+
+	mw.family("tinytorrent_torrent_flush_duration_seconds", "histogram", "Time taken to write the verified pieces.")
+	for _, t := range torrents {
+		mw.histogram("tinytorrent_torrent_flush_duration_seconds", torrentLabel(t.hash), t.m.FlushLatency)
+	}
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+func torrentLabel(hash string) string { return fmt.Sprintf("torrent=%q", hash) }
+
+// metricsWriter writes the Prometheus text exposition format,
+// keeping the first error so that it is checked only once.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...any) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+func (m *metricsWriter) family(name, typ, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m *metricsWriter) sample(name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	m.printf("%s %s\n", name, formatValue(v))
+}
+
+// formatValue formats whole numbers without an exponent, as most counters are.
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *metricsWriter) histogram(name, labels string, h Histogram) {
+	var count int64
+	for i, bound := range h.Bounds {
+		count += h.Counts[i]
+		m.sample(name+"_bucket", labels+fmt.Sprintf(`,le="%s"`, formatValue(bound)), float64(count))
+	}
+	count += h.Counts[len(h.Bounds)]
+	m.sample(name+"_bucket", labels+`,le="+Inf"`, float64(count))
+	m.sample(name+"_sum", labels, h.Sum)
+	m.sample(name+"_count", labels, float64(count))
+}
+
+func (a *controlAPI) metrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := a.client.WriteMetrics(w); err != nil {
+		a.logger.Debug("failed to write metrics", slog.Any("err", err))
+	}
+}
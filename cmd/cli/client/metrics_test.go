@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Metrics(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	newClient := func(opts ...Option) *Client {
+		opts = append(opts,
+			WithLogger(logger),
+			WithDownloadDir(t.TempDir()),
+			WithStorage(func(*torrent.MetaInfoFile) storage.Storage { return storage.NewMemory() }),
+		)
+		c, err := New(opts...)
+		assert.NoError(t, err)
+		t.Cleanup(func() { c.Close(context.Background()) })
+		return c
+	}
+	scrape := func(c *Client) (int, string) {
+		t.Helper()
+		srv := httptest.NewServer(newControlAPI(c, ""))
+		defer srv.Close()
+		resp, err := srv.Client().Get(srv.URL + "/metrics")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := scrape(newClient())
+	assert.Equal(t, http.StatusNotFound, code, "metrics are served only if enabled")
+
+	c := newClient(WithMetrics())
+	id, err := c.WorkOnWithOptions(newTestTorrent(tracker.URL+"/announce"), withUploaded(1234))
+	assert.NoError(t, err)
+	hash := hex.EncodeToString([]byte(id))
+
+	assert.Eventually(t, func() bool {
+		m, err := c.Metrics(id)
+		return err == nil && m.AnnounceSuccesses == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	code, body := scrape(c)
+	assert.Equal(t, http.StatusOK, code)
+	for _, want := range []string{
+		"# TYPE tinytorrent_uploaded_bytes_total counter",
+		"tinytorrent_uploaded_bytes_total 1234",
+		`tinytorrent_torrents{state="downloading"} 1`,
+		`tinytorrent_torrents{state="paused"} 0`,
+		"tinytorrent_piece_cache_hits_total 0",
+		fmt.Sprintf("tinytorrent_piece_cache_limit_bytes %d", storage.DefaultCacheSize),
+		fmt.Sprintf(`tinytorrent_torrent_uploaded_bytes_total{torrent=%q} 1234`, hash),
+		fmt.Sprintf(`tinytorrent_torrent_announces_total{torrent=%q,result="success"} 1`, hash),
+		fmt.Sprintf(`tinytorrent_torrent_seeders{torrent=%q,choking="true"} 0`, hash),
+		"# TYPE tinytorrent_torrent_flush_duration_seconds histogram",
+		fmt.Sprintf(`tinytorrent_torrent_flush_duration_seconds_bucket{torrent=%q,le="+Inf"} 0`, hash),
+	} {
+		assert.Contains(t, body, want+"\n")
+	}
+
+	// the client wide counters keep the bytes of removed torrents.
+	assert.NoError(t, c.Remove(id, false))
+	_, body = scrape(c)
+	assert.Contains(t, body, "tinytorrent_uploaded_bytes_total 1234\n")
+	assert.NotContains(t, body, hash)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			assert.Len(t, strings.Fields(line), 2, "malformed sample %q", line)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+)
+
+var (
+	// ErrRechecking is returned by Recheck if the torrent is already being rechecked.
+	ErrRechecking = status.ErrRechecking
+	// ErrNotRechecking is returned by CancelRecheck if the torrent is not being rechecked.
+	ErrNotRechecking = status.ErrNotRechecking
+	// ErrRecheckCompleted is returned by Recheck if the download of the torrent completed.
+	ErrRecheckCompleted = status.ErrRecheckCompleted
+	// ErrInvalidPiece is returned for piece indexes that do not belong to the torrent.
+	ErrInvalidPiece = status.ErrInvalidPiece
+	// ErrPieceVerified is returned by AbandonPiece for pieces that were downloaded already.
+	ErrPieceVerified = status.ErrPieceVerified
+	// ErrPieceNotAbandoned is returned by ReclaimPiece for pieces that were not abandoned.
+	ErrPieceNotAbandoned = status.ErrPieceNotAbandoned
+	// ErrNotAnnounced is returned by Reannounce if the torrent is
+	// tracked, but not announced to its tracker, e.g. as it stopped
+	// seeding or the client is shutting down.
+	ErrNotAnnounced = errors.New("torrent is not announced to its tracker")
+)
+
+// RecheckStats is the outcome of Recheck.
+type RecheckStats = status.RecheckStats
+
+// opLock serializes the operations on a single torrent,
+// refs counts the operations holding or waiting for it.
+type opLock struct {
+	sync.Mutex
+	refs int
+}
+
+// torrentKey returns the key of the torrent with the given id in the maps
+// of the client. The id is either the info hash or its hex encoding.
+func torrentKey(id string) string {
+	if len(id) == 2*sha1.Size {
+		if h, err := hex.DecodeString(id); err == nil {
+			return string(h)
+		}
+	}
+	return id
+}
+
+// lockTorrent waits until no other operation runs on the torrent
+// with the given key and returns the function releasing it.
+func (p *Client) lockTorrent(key string) (unlock func()) {
+	p.opsL.Lock()
+	if p.ops == nil {
+		p.ops = make(map[string]*opLock)
+	}
+	l, ok := p.ops[key]
+	if !ok {
+		l = new(opLock)
+		p.ops[key] = l
+	}
+	l.refs++
+	p.opsL.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		p.opsL.Lock()
+		defer p.opsL.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(p.ops, key)
+		}
+	}
+}
+
+// withTorrent runs op on the torrent with the given id, once the operations
+// on the torrent that started before finished. It returns ErrTorrentNotFound
+// if the torrent is not tracked, also if it was removed in the meantime.
+func (p *Client) withTorrent(id string, op func(id string, tr *status.TorrentSession) error) error {
+	id = torrentKey(id)
+	unlock := p.lockTorrent(id)
+	defer unlock()
+
+	tr, err := p.tracker(id)
+	if err != nil {
+		return err
+	}
+	return op(id, tr)
+}
+
+// Recheck verifies the pieces of the torrent with the given id in its
+// storage. Downloaded pieces that fail the verification are downloaded
+// again, and pieces found in the storage are no longer downloaded. It
+// returns ErrRecheckCompleted if the download completed already. The
+// torrent is not paused or removed while it is rechecked.
+//
+// If ctx is canceled, or CancelRecheck called, the pieces verified so far
+// are kept and the torrent is paused. The next recheck continues with the
+// pieces that were not verified, also after a restart.
+func (p *Client) Recheck(ctx context.Context, id string) (RecheckStats, error) {
+	var stats RecheckStats
+	err := p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		var err error
+		if stats, err = tr.Recheck(ctx); err != nil {
+			return fmt.Errorf("failed to recheck torrent with id %x: %w", id, err)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// CancelRecheck cancels the recheck of the torrent with the given id,
+// see Recheck. It returns ErrNotRechecking if none is running.
+func (p *Client) CancelRecheck(id string) error {
+	// the running recheck holds the lock of the torrent.
+	tr, err := p.tracker(id)
+	if err != nil {
+		return err
+	}
+	if err := tr.CancelRecheck(); err != nil {
+		return fmt.Errorf("failed to cancel recheck of torrent with id %x: %w", torrentKey(id), err)
+	}
+	return nil
+}
+
+// AbandonPiece stops downloading the piece at index of the torrent with
+// the given id until ReclaimPiece is called for it, e.g. as another client
+// provides the piece. Its outstanding requests are canceled and the blocks
+// received for it discarded. Abandoned pieces are downloaded again after a
+// restart.
+func (p *Client) AbandonPiece(id string, index int64) error {
+	// a running recheck holds the lock of the torrent for long.
+	tr, err := p.tracker(id)
+	if err != nil {
+		return err
+	}
+	if err := tr.AbandonPiece(index); err != nil {
+		return fmt.Errorf("failed to abandon piece of torrent with id %x: %w", torrentKey(id), err)
+	}
+	return nil
+}
+
+// ReclaimPiece downloads the abandoned piece at index of the
+// torrent with the given id again, see AbandonPiece.
+func (p *Client) ReclaimPiece(id string, index int64) error {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return err
+	}
+	if err := tr.ReclaimPiece(index); err != nil {
+		return fmt.Errorf("failed to reclaim piece of torrent with id %x: %w", torrentKey(id), err)
+	}
+	return nil
+}
+
+// Reannounce announces the torrent with the given id to its tracker
+// without waiting for the announce interval, once the minimum interval
+// of the tracker elapsed. It returns ErrPaused if the torrent is paused.
+func (p *Client) Reannounce(id string) error {
+	return p.withTorrent(id, func(id string, tr *status.TorrentSession) error {
+		if tr.Paused() {
+			return fmt.Errorf("failed to reannounce torrent with id %x: %w", id, ErrPaused)
+		}
+		d, ok := p.downloads.Load(id)
+		if !ok {
+			return fmt.Errorf("failed to reannounce torrent with id %x: %w", id, ErrNotAnnounced)
+		}
+
+		select {
+		case <-d.(*download).stopped:
+			return fmt.Errorf("failed to reannounce torrent with id %x: %w", id, ErrNotAnnounced)
+		default:
+		}
+		select {
+		case d.(*download).announce <- struct{}{}:
+		default: // the previous request was not handled yet.
+		}
+		p.logger.Info("requested announce of torrent", slog.String("infoHash", id))
+		return nil
+	})
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTorrentKey(t *testing.T) {
+	hash := string([]byte{0: 0xab, 19: 0x01})
+	tests := []struct {
+		name string
+		id   string
+		want string
+	}{
+		{name: "binary", id: hash, want: hash},
+		{name: "hex", id: hex.EncodeToString([]byte(hash)), want: hash},
+		{name: "upper hex", id: "AB00000000000000000000000000000000000001", want: hash},
+		{name: "not hex", id: "zz00000000000000000000000000000000000001", want: "zz00000000000000000000000000000000000001"},
+		{name: "unknown", id: "abc", want: "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, torrentKey(tt.id))
+		})
+	}
+}
+
+// newOpsClient returns a client tracking a torrent, whose tracker counts
+// the announces, and the hex encoded info hash of the torrent.
+func newOpsClient(t *testing.T, announces *atomic.Int64) (*Client, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(
+		WithLogger(logger),
+		WithDownloadDir(t.TempDir()),
+		WithStorage(func(*torrent.MetaInfoFile) storage.Storage { return storage.NewMemory() }),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	id, err := c.WorkOn(newTestTorrent(srv.URL + "/announce"))
+	assert.NoError(t, err)
+	return c, hex.EncodeToString([]byte(id))
+}
+
+func TestClient_TorrentOps(t *testing.T) {
+	type op func(c *Client, id string) error
+	var (
+		pause      op = (*Client).Pause
+		resume     op = (*Client).Resume
+		reannounce op = (*Client).Reannounce
+		recheck    op = func(c *Client, id string) error { _, err := c.Recheck(context.Background(), id); return err }
+		remove     op = func(c *Client, id string) error { return c.Remove(id, false) }
+	)
+	ops := map[string]op{"pause": pause, "resume": resume, "reannounce": reannounce, "recheck": recheck, "remove": remove}
+
+	// the error of each operation, after the torrent was brought into a state.
+	tests := []struct {
+		state string
+		setup op
+		want  map[string]error
+	}{
+		{
+			state: "downloading",
+			setup: func(*Client, string) error { return nil },
+			want:  map[string]error{"resume": ErrNotPaused},
+		},
+		{
+			state: "paused",
+			setup: pause,
+			want:  map[string]error{"pause": ErrPaused, "reannounce": ErrPaused},
+		},
+		{
+			state: "removed",
+			setup: remove,
+			want: map[string]error{
+				"pause":      ErrTorrentNotFound,
+				"resume":     ErrTorrentNotFound,
+				"reannounce": ErrTorrentNotFound,
+				"recheck":    ErrTorrentNotFound,
+				"remove":     ErrTorrentNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		for name, op := range ops {
+			t.Run(tt.state+"/"+name, func(t *testing.T) {
+				var announces atomic.Int64
+				c, id := newOpsClient(t, &announces)
+				assert.NoError(t, tt.setup(c, id))
+
+				err := op(c, id)
+				if want := tt.want[name]; want != nil {
+					assert.ErrorIs(t, err, want)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	}
+}
+
+func TestClient_TorrentOpsSerialized(t *testing.T) {
+	var announces atomic.Int64
+	c, id := newOpsClient(t, &announces)
+
+	// an operation running on the torrent, e.g. a removal.
+	unlock := c.lockTorrent(torrentKey(id))
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Recheck(context.Background(), id)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("recheck ran concurrently with another operation on the torrent")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// other torrents are not blocked.
+	assert.ErrorIs(t, c.Pause("unknown"), ErrTorrentNotFound)
+
+	unlock()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("recheck did not run once the operation finished")
+	}
+
+	c.opsL.Lock()
+	assert.Empty(t, c.ops, "locks of finished operations are kept")
+	c.opsL.Unlock()
+}
+
+func TestClient_Reannounce(t *testing.T) {
+	var announces atomic.Int64
+	c, id := newOpsClient(t, &announces)
+	assert.Eventually(t, func() bool { return announces.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, c.Reannounce(id))
+	assert.Eventually(t, func() bool { return announces.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+}
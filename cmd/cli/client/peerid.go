@@ -0,0 +1,66 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PeerIDPrefix is the Azureus-style prefix identifying
+// this client and its version within the peer id.
+const PeerIDPrefix = "-TT0100-"
+
+// PeerIDLength is the required length of a peer id.
+const PeerIDLength = 20
+
+// keyFile is the name of the file inside the download directory
+// in which the tracker key is persisted across restarts.
+const keyFile = "tracker.key"
+
+// GeneratePeerID returns a new Azureus-style peer id consisting
+// of the PeerIDPrefix followed by random bytes.
+func GeneratePeerID() (string, error) {
+	var suffix [PeerIDLength - len(PeerIDPrefix)]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", fmt.Errorf("failed to generate peer id: %w", err)
+	}
+	return PeerIDPrefix + string(suffix[:]), nil
+}
+
+func validatePeerID(id string) error {
+	if len(id) != PeerIDLength {
+		return fmt.Errorf("peer id must be exactly %d bytes long, got %d", PeerIDLength, len(id))
+	}
+	return nil
+}
+
+// loadOrCreateKey reads the tracker key persisted in dir. If there
+// is none a new random key is generated and persisted.
+func loadOrCreateKey(dir string) (string, error) {
+	path := filepath.Join(dir, keyFile)
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if key := strings.TrimSpace(string(b)); key != "" {
+			return key, nil
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read tracker key: %w", err)
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", fmt.Errorf("failed to generate tracker key: %w", err)
+	}
+
+	encoded := hex.EncodeToString(key[:])
+	if err := os.WriteFile(path, []byte(encoded), 0o644); err != nil {
+		return "", fmt.Errorf("failed to persist tracker key: %w", err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePeerID(t *testing.T) {
+	a, err := GeneratePeerID()
+	assert.Nil(t, err)
+	assert.Len(t, a, PeerIDLength)
+	assert.True(t, strings.HasPrefix(a, PeerIDPrefix))
+	assert.Nil(t, validatePeerID(a))
+
+	b, err := GeneratePeerID()
+	assert.Nil(t, err)
+	assert.NotEqual(t, a, b)
+
+	assert.NotNil(t, validatePeerID("too-short"))
+	assert.NotNil(t, validatePeerID(strings.Repeat("a", PeerIDLength+1)))
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := loadOrCreateKey(dir)
+	assert.Nil(t, err)
+	assert.Len(t, key, 8)
+
+	again, err := loadOrCreateKey(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+}
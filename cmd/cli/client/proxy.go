@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/socks5"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+)
+
+// initDial sets up how the connections to trackers, web seeds and
+// seeders are established, either directly or through the proxy.
+func (p *Client) initDial() error {
+	p.dial = p.dns.Dial
+	if p.proxyURL != "" {
+		proxy, err := socks5.FromURL(p.proxyURL)
+		if err != nil {
+			return err
+		}
+		// the proxy itself is reached directly.
+		proxy.Forward = p.dns.Dial
+		p.proxy = proxy
+		p.dial = p.dialProxy
+	}
+
+	p.trackers = &tracker.Client{
+		HTTP:      p.trackerHTTP,
+		UserAgent: p.userAgent,
+		Dial:      p.dial,
+		Timeout:   p.trackerTimeout,
+	}
+	if p.trackers.HTTP == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = p.dial
+		transport.TLSClientConfig = p.trackerTLS
+		p.trackers.HTTP = &http.Client{Transport: transport, Timeout: p.trackerTimeout}
+	}
+	return nil
+}
+
+// dialProxy connects to addr through the proxy. Unless the proxy fails
+// closed, host names are resolved locally and addr is dialed directly
+// if the proxy cannot be reached. Otherwise host names are resolved by
+// the proxy, so that no lookups leave the client.
+func (p *Client) dialProxy(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.proxyFailClosed {
+		return p.proxy.DialContext(ctx, network, addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, _, err := p.dns.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, a := range addrs {
+		conn, err := p.proxy.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if errors.Is(err, socks5.ErrProxyUnreachable) {
+			p.logger.Warn("proxy unreachable, connecting directly", slog.String("addr", addr), slog.Any("err", err))
+			return p.dns.Dial(ctx, network, addr)
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no addresses for host %q", host)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// listenPort is the port incoming connections are accepted on.
+func (p *Client) listenPort() int64 {
+	// the port the listener was bound to, as the configured one may be 0.
+	if p.seedServer != nil {
+		if addr, ok := p.seedServer.Addr().(*net.TCPAddr); ok {
+			return int64(addr.Port)
+		}
+	}
+	return int64(p.port)
+}
+
+// announcePort is the port announced to trackers and the DHT for the
+// torrent t, or for a torrent not added if nil. Incoming connections
+// cannot traverse the proxy, so none is claimed while proxying.
+func (p *Client) announcePort(t *status.TorrentSession) int64 {
+	if p.proxy != nil {
+		return 0
+	}
+	if t != nil && t.AnnouncePort() > 0 {
+		return int64(t.AnnouncePort())
+	}
+	if p.publicPort > 0 {
+		return int64(p.publicPort)
+	}
+	return p.listenPort()
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_WithProxy(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// no proxy listens on the address.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	proxyURL := "socks5://user:pass@" + ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name         string
+		failClosed   bool
+		wantAnnounce bool
+	}{
+		{name: "falls back to direct connections", wantAnnounce: true},
+		{name: "fails closed", failClosed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports := make(chan string, 10)
+			tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				ports <- r.URL.Query().Get("port")
+				fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+			}))
+			t.Cleanup(tracker.Close)
+
+			opts := []Option{
+				WithLogger(logger),
+				WithDownloadDir(t.TempDir()),
+				WithAction(Both),
+				WithProxy(proxyURL),
+			}
+			if tt.failClosed {
+				opts = append(opts, WithProxyFailClosed())
+			}
+			c, err := New(opts...)
+			assert.NoError(t, err)
+			t.Cleanup(func() { c.Close(context.Background()) })
+			assert.Nil(t, c.seedServer, "no leechers are accepted through the proxy")
+
+			mi, err := torrent.From(bytes.NewReader(bencodeTorrent(tracker.URL, []byte("proxied"))))
+			assert.NoError(t, err)
+			_, err = c.WorkOn(mi)
+			assert.NoError(t, err)
+
+			select {
+			case port := <-ports:
+				assert.True(t, tt.wantAnnounce, "announced without the proxy")
+				assert.Equal(t, "0", port)
+			case <-time.After(time.Second):
+				assert.False(t, tt.wantAnnounce, "no announce")
+			}
+		})
+	}
+
+	_, err = New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithProxy("http://proxy.example:8080"))
+	assert.ErrorContains(t, err, "invalid proxy")
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/dnscache"
+)
+
+// Resolver resolves the host names of trackers, web seeds and peers,
+// see WithResolver. A *net.DNSError with IsNotFound set must be returned
+// for hosts that do not exist, so that they are cached as such.
+type Resolver = dnscache.Resolver
+
+// ResolverStats are the metrics of the cache in front of the Resolver.
+type ResolverStats = dnscache.Stats
+
+// ResolverStats returns the metrics of the host name cache.
+func (p *Client) ResolverStats() ResolverStats { return p.dns.Stats() }
+
+// initResolver puts the cache in front of the resolver.
+func (p *Client) initResolver() {
+	r := p.resolver
+	if r == nil {
+		r = dnscache.System{}
+	}
+	p.dns = dnscache.New(r)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticResolver map[string]netip.Addr
+
+func (r staticResolver) Resolve(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addr, ok := r[host]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []netip.Addr{addr}, time.Minute, nil
+}
+
+func TestClient_WithResolver(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var announces atomic.Int64
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	c, err := New(
+		WithLogger(logger),
+		WithDownloadDir(t.TempDir()),
+		WithResolver(staticResolver{"tracker.test": netip.MustParseAddr("127.0.0.1")}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	announce := strings.Replace(tracker.URL, "127.0.0.1", "tracker.test", 1)
+	mi, err := torrent.From(bytes.NewReader(bencodeTorrent(announce, []byte("resolved"))))
+	assert.NoError(t, err)
+	_, err = c.WorkOn(mi)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return announces.Load() > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, c.ResolverStats().Misses, int64(1))
+	assert.Equal(t, 1, c.ResolverStats().Entries)
+}
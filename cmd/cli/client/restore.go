@@ -0,0 +1,206 @@
+package client
+
+import (
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/cmd/cli/internal/atomicfile"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+const (
+	// restoreFile is the name of the file inside the download directory
+	// in which the tracked torrents are persisted, see WithSessionRestore.
+	restoreFile = "torrents.json"
+	// restoreVersion is the version of the restore file, files of newer
+	// versions are backed up and not restored.
+	restoreVersion = 1
+)
+
+type restoreState struct {
+	Version  int              `json:"version"`
+	Torrents []restoreTorrent `json:"torrents"`
+}
+
+// restoreTorrent is a tracked torrent with the options it was added with.
+type restoreTorrent struct {
+	InfoHash string `json:"infoHash"`
+	// Torrent is the torrent file the torrent was decoded from.
+	Torrent           []byte   `json:"torrent"`
+	Dir               string   `json:"dir"`
+	MoveTo            string   `json:"moveTo,omitempty"`
+	PeerList          string   `json:"peerList,omitempty"`
+	PeerListWriteBack bool     `json:"peerListWriteBack,omitempty"`
+	MaxActivePieces   int      `json:"maxActivePieces,omitempty"`
+	MaxPeers          int      `json:"maxPeers,omitempty"`
+	MaxPipeline       int      `json:"maxPipeline,omitempty"`
+	Priority          Priority `json:"priority,omitempty"`
+	Paused            bool     `json:"paused,omitempty"`
+	SeedRatio         *float64 `json:"seedRatio,omitempty"`
+	// Uploaded and Added are the counters carried over to the next run.
+	Uploaded int64     `json:"uploaded"`
+	Added    time.Time `json:"added"`
+}
+
+// options returns the options the torrent is added with again.
+func (r *restoreTorrent) options() TorrentOption {
+	return func(o *torrentOptions) {
+		if r.Dir != "" {
+			o.dir = r.Dir
+		}
+		o.moveTo = r.MoveTo
+		o.peerList = r.PeerList
+		o.peerListWriteBack = r.PeerListWriteBack
+		o.maxActivePieces = r.MaxActivePieces
+		o.maxPeers = r.MaxPeers
+		o.maxPipeline = r.MaxPipeline
+		o.priority = r.Priority
+		o.paused = r.Paused
+		o.seedRatio = r.SeedRatio
+		o.uploaded = r.Uploaded
+		o.added = r.Added
+	}
+}
+
+// trackRestore records the options of an added torrent, which are
+// persisted with it once saveRestore is called.
+func (p *Client) trackRestore(id string, t *torrent.MetaInfoFile, o *torrentOptions) {
+	if !p.sessionRestore {
+		return
+	}
+	if len(t.Raw) == 0 {
+		p.logger.Warn("torrent was not decoded from a torrent file, it is not restored after a restart", slog.String("infoHash", id))
+		return
+	}
+	p.restoreL.Lock()
+	defer p.restoreL.Unlock()
+	p.restore[id] = &restoreTorrent{
+		InfoHash:          hex.EncodeToString([]byte(id)),
+		Torrent:           t.Raw,
+		Dir:               o.dir,
+		MoveTo:            o.moveTo,
+		PeerList:          o.peerList,
+		PeerListWriteBack: o.peerListWriteBack,
+		MaxActivePieces:   o.maxActivePieces,
+		MaxPeers:          o.maxPeers,
+		MaxPipeline:       o.maxPipeline,
+		Priority:          o.priority,
+		SeedRatio:         o.seedRatio,
+	}
+}
+
+// untrackRestore no longer persists the removed torrent.
+func (p *Client) untrackRestore(id string) {
+	if !p.sessionRestore {
+		return
+	}
+	p.restoreL.Lock()
+	defer p.restoreL.Unlock()
+	delete(p.restore, id)
+}
+
+// saveRestore persists the tracked torrents, with their current paused
+// state, limits and counters, if WithSessionRestore is set. Errors are
+// logged, as they must not fail the operation that changed the torrents.
+func (p *Client) saveRestore() {
+	if !p.sessionRestore {
+		return
+	}
+	p.restoreL.Lock()
+	defer p.restoreL.Unlock()
+
+	s := restoreState{Version: restoreVersion, Torrents: []restoreTorrent{}}
+	for id, r := range p.restore {
+		if v, ok := p.torrentsDownloading.Load(id); ok {
+			tr := v.(*status.TorrentSession)
+			r.Paused = tr.Paused()
+			r.Uploaded = tr.Uploaded()
+			r.Added = tr.Added()
+			limits := tr.Limits()
+			r.MaxPeers, r.MaxActivePieces, r.MaxPipeline = limits.MaxPeers, limits.MaxActivePieces, limits.MaxPipeline
+		}
+		s.Torrents = append(s.Torrents, *r)
+	}
+	slices.SortFunc(s.Torrents, func(a, b restoreTorrent) int { return cmp.Compare(a.InfoHash, b.InfoHash) })
+
+	if err := writeRestoreFile(filepath.Join(p.downloadDir, restoreFile), &s); err != nil {
+		p.logger.Error("failed to persist tracked torrents", slog.Any("err", err))
+	}
+}
+
+func writeRestoreFile(path string, s *restoreState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, b, 0o600)
+}
+
+// restoreSession adds the torrents persisted by the previous run again.
+// A restore file that cannot be read, or holds torrents that cannot be
+// added, is backed up next to it and the torrents that can be added are.
+func (p *Client) restoreSession() {
+	path := filepath.Join(p.downloadDir, restoreFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		p.logger.Error("failed to read tracked torrents, starting without them", slog.Any("err", err))
+		return
+	}
+
+	var s restoreState
+	if err := json.Unmarshal(b, &s); err != nil {
+		p.backupRestore(path, b, fmt.Errorf("failed to decode: %w", err))
+		return
+	}
+	if s.Version > restoreVersion {
+		p.backupRestore(path, b, fmt.Errorf("unsupported version %d", s.Version))
+		return
+	}
+
+	var errAll error
+	for _, r := range s.Torrents {
+		mi, err := torrent.LoadBytes(r.Torrent)
+		if err != nil {
+			errAll = errors.Join(errAll, fmt.Errorf("torrent with id %s: invalid torrent file: %w", r.InfoHash, err))
+			continue
+		}
+		if hex.EncodeToString(mi.Metadata.Hash[:]) != r.InfoHash {
+			errAll = errors.Join(errAll, fmt.Errorf("torrent with id %s: torrent file has info hash %x", r.InfoHash, mi.Metadata.Hash))
+			continue
+		}
+		if _, err := p.WorkOnWithOptions(mi, r.options()); err != nil {
+			errAll = errors.Join(errAll, fmt.Errorf("torrent with id %s: %w", r.InfoHash, err))
+			continue
+		}
+		p.logger.Info("restored torrent", slog.String("infoHash", r.InfoHash), slog.Bool("paused", r.Paused))
+	}
+	if errAll != nil {
+		p.backupRestore(path, b, errAll)
+	}
+}
+
+// backupRestore keeps a copy of the restore file that could not be
+// restored completely, as it is overwritten by the next change.
+func (p *Client) backupRestore(path string, b []byte, reason error) {
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102T150405"))
+	p.logger.Error("failed to restore tracked torrents, backing up the restore file",
+		slog.String("backup", backup),
+		slog.Any("err", reason),
+	)
+	if err := os.WriteFile(backup, b, 0o644); err != nil {
+		p.logger.Error("failed to back up restore file", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRestoreTracker(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func readRestoreFile(t *testing.T, dir string) restoreState {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, restoreFile))
+	assert.NoError(t, err)
+	var s restoreState
+	assert.NoError(t, json.Unmarshal(b, &s))
+	return s
+}
+
+// withUploaded starts the torrent with n bytes uploaded, as restored ones are.
+func withUploaded(n int64) TorrentOption {
+	return func(o *torrentOptions) { o.uploaded = n }
+}
+
+func TestClient_SessionRestore(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	announce := newRestoreTracker(t)
+	dir, otherDir := t.TempDir(), t.TempDir()
+
+	first, err := New(WithLogger(logger), WithDownloadDir(dir), WithSessionRestore())
+	assert.NoError(t, err)
+
+	add := func(c *Client, data string, opts ...TorrentOption) string {
+		mi, err := torrent.From(bytes.NewReader(bencodeTorrent(announce, []byte(data))))
+		assert.NoError(t, err)
+		id, err := c.WorkOnWithOptions(mi, opts...)
+		assert.NoError(t, err)
+		return id
+	}
+	downloading := add(first, "downloading", TorrentWithPriority(PriorityHigh), withUploaded(1234))
+	paused := add(first, "paused", TorrentWithDir(otherDir))
+	removed := add(first, "removed")
+	assert.NoError(t, first.Pause(paused))
+	assert.NoError(t, first.Remove(removed, false))
+	// torrents not decoded from a torrent file cannot be restored.
+	_, err = first.WorkOn(newTestTorrent(announce))
+	assert.NoError(t, err)
+
+	tr, err := first.tracker(downloading)
+	assert.NoError(t, err)
+	added := tr.Added()
+	// limits changed while the torrent runs are restored too.
+	assert.NoError(t, first.SetMaxPeers(downloading, 3))
+	assert.NoError(t, first.SetMaxPipeline(downloading, 16))
+	assert.NoError(t, first.Close(context.Background()))
+
+	s := readRestoreFile(t, dir)
+	if assert.Len(t, s.Torrents, 2) {
+		for _, r := range s.Torrents {
+			switch r.InfoHash {
+			case hex.EncodeToString([]byte(downloading)):
+				assert.Equal(t, PriorityHigh, r.Priority)
+				assert.Equal(t, dir, r.Dir)
+				assert.Equal(t, int64(1234), r.Uploaded)
+				assert.Equal(t, 3, r.MaxPeers)
+				assert.Equal(t, 16, r.MaxPipeline)
+			case hex.EncodeToString([]byte(paused)):
+				assert.True(t, r.Paused)
+				assert.Equal(t, otherDir, r.Dir)
+			default:
+				t.Errorf("unexpected torrent %s", r.InfoHash)
+			}
+		}
+	}
+
+	second, err := New(WithLogger(logger), WithDownloadDir(dir), WithSessionRestore())
+	assert.NoError(t, err)
+	t.Cleanup(func() { second.Close(context.Background()) })
+
+	assert.Len(t, second.Statuses(), 2)
+	st, err := second.Status(downloading)
+	assert.NoError(t, err)
+	assert.Equal(t, StateDownloading, st.State)
+	assert.Equal(t, int64(1234), st.Uploaded)
+	assert.Equal(t, TorrentLimits{MaxPeers: 3, MaxPipeline: 16}, st.Limits)
+	tr, err = second.tracker(downloading)
+	assert.NoError(t, err)
+	assert.True(t, added.Equal(tr.Added()))
+
+	st, err = second.Status(paused)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePaused, st.State)
+	tr, err = second.tracker(paused)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(otherDir, hex.EncodeToString([]byte(paused))), tr.DownloadDir())
+
+	// the file is kept up to date with the changes of the restored client.
+	assert.NoError(t, second.Resume(paused))
+	assert.NoError(t, second.Remove(downloading, false))
+	s = readRestoreFile(t, dir)
+	if assert.Len(t, s.Torrents, 1) {
+		assert.Equal(t, hex.EncodeToString([]byte(paused)), s.Torrents[0].InfoHash)
+		assert.False(t, s.Torrents[0].Paused)
+	}
+}
+
+func TestClient_SessionRestoreInvalid(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	announce := newRestoreTracker(t)
+
+	valid := bencodeTorrent(announce, []byte("valid"))
+	mi, err := torrent.From(bytes.NewReader(valid))
+	assert.NoError(t, err)
+	validHash := hex.EncodeToString(mi.Metadata.Hash[:])
+
+	encode := func(s restoreState) []byte {
+		b, err := json.Marshal(s)
+		assert.NoError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		file     []byte
+		restored int
+	}{
+		{name: "not json", file: []byte("{not json"), restored: 0},
+		{name: "newer version", file: encode(restoreState{Version: restoreVersion + 1}), restored: 0},
+		{
+			name: "invalid torrent",
+			file: encode(restoreState{Version: restoreVersion, Torrents: []restoreTorrent{
+				{InfoHash: "00", Torrent: []byte("not a torrent")},
+				{InfoHash: "01", Torrent: valid},
+				{InfoHash: validHash, Torrent: valid},
+			}}),
+			restored: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, restoreFile), tt.file, 0o644))
+
+			c, err := New(WithLogger(logger), WithDownloadDir(dir), WithSessionRestore())
+			assert.NoError(t, err, "invalid restore file prevented the client from starting")
+			t.Cleanup(func() { c.Close(context.Background()) })
+			assert.Len(t, c.Statuses(), tt.restored)
+
+			backups, err := filepath.Glob(filepath.Join(dir, restoreFile+".*.bak"))
+			assert.NoError(t, err)
+			if assert.Len(t, backups, 1) {
+				b, err := os.ReadFile(backups[0])
+				assert.NoError(t, err)
+				assert.Equal(t, tt.file, b)
+			}
+			assert.Len(t, readRestoreFile(t, dir).Torrents, tt.restored)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package client
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// SessionVersion is the version of the session file within the archives
+// written by Export. Archives of newer versions are rejected by ImportSession.
+const SessionVersion = 1
+
+const (
+	// sessionFile is the first entry of an archive, listing its torrents.
+	sessionFile = "session.json"
+	// torrentsDir and resumeDir hold the .torrent file and the resume
+	// data of each torrent, named by its hex encoded info hash.
+	torrentsDir = "torrents"
+	resumeDir   = "resume"
+	// maxArchiveEntrySize bounds the size of a single entry of an archive.
+	maxArchiveEntrySize = 64 << 20
+)
+
+// ErrInvalidArchive is returned by ImportSession for
+// archives that were not written by Export.
+var ErrInvalidArchive = errors.New("invalid session archive")
+
+// RestoreStats is the outcome of restoring the resume data of a torrent.
+type RestoreStats = status.RestoreStats
+
+type session struct {
+	Version  int              `json:"version"`
+	Torrents []sessionTorrent `json:"torrents"`
+}
+
+type sessionTorrent struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+}
+
+// ImportedTorrent is the outcome of importing a single torrent, see ImportSession.
+type ImportedTorrent struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	// Skipped is set if the torrent was already tracked,
+	// e.g. by an earlier import that was interrupted.
+	Skipped bool `json:"skipped,omitempty"`
+	RestoreStats
+}
+
+// Export writes the torrents of the client as a tar.gz archive to w, so
+// that they can be imported by another client with ImportSession. The
+// archive holds the .torrent file and the resume data of each torrent,
+// the downloaded data has to be copied separately. Only torrents decoded
+// from a torrent file can be exported.
+func (p *Client) Export(w io.Writer) error {
+	var trackers []*status.TorrentSession
+	p.torrentsDownloading.Range(func(_, value any) bool {
+		trackers = append(trackers, value.(*status.TorrentSession))
+		return true
+	})
+	slices.SortFunc(trackers, func(a, b *status.TorrentSession) int {
+		return cmp.Compare(string(a.Torrent().Metadata.Hash[:]), string(b.Torrent().Metadata.Hash[:]))
+	})
+
+	s := session{Version: SessionVersion, Torrents: []sessionTorrent{}}
+	for _, tr := range trackers {
+		if len(tr.Torrent().Raw) == 0 {
+			return fmt.Errorf("torrent with id %x was not decoded from a torrent file", tr.Torrent().Metadata.Hash)
+		}
+		s.Torrents = append(s.Torrents, sessionTorrent{
+			InfoHash: hex.EncodeToString(tr.Torrent().Metadata.Hash[:]),
+			Name:     tr.Torrent().Name(),
+		})
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	b, err := json.Marshal(&s)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := writeArchiveEntry(tw, sessionFile, b); err != nil {
+		return err
+	}
+
+	for i, tr := range trackers {
+		h := s.Torrents[i].InfoHash
+		if err := writeArchiveEntry(tw, path.Join(torrentsDir, h+".torrent"), tr.Torrent().Raw); err != nil {
+			return err
+		}
+		b, err := tr.ResumeData()
+		if err != nil {
+			return fmt.Errorf("torrent with id %s: %w", h, err)
+		}
+		if err := writeArchiveEntry(tw, path.Join(resumeDir, h+".json"), b); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// ImportSession adds the torrents of an archive written by Export. The
+// downloaded data of each torrent is expected within dataRoot, in a
+// directory named by its hex encoded info hash as in the download
+// directory of the exporting client. Pieces whose files do not match
+// the resume data are verified again before the torrents are started.
+//
+// Torrents that are already tracked are skipped, so that an interrupted
+// import can be completed by importing the same archive again. The
+// outcome of the torrents imported before an error is returned with it.
+func (p *Client) ImportSession(r io.Reader, dataRoot string) ([]ImportedTorrent, error) {
+	if p.newStorage != nil {
+		return nil, errors.New("importing a session is only supported for the default storage")
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	name, b, err := readArchiveEntry(tr)
+	if err != nil {
+		return nil, err
+	}
+	if name != sessionFile {
+		return nil, fmt.Errorf("%w: expected %s as first entry, got %s", ErrInvalidArchive, sessionFile, name)
+	}
+	var s session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode session: %w", ErrInvalidArchive, err)
+	}
+	if s.Version > SessionVersion {
+		return nil, fmt.Errorf("%w: unsupported session version %d", ErrInvalidArchive, s.Version)
+	}
+
+	type entries struct{ torrent, resume []byte }
+	pending := make(map[string]*entries, len(s.Torrents))
+	for _, t := range s.Torrents {
+		pending[t.InfoHash] = new(entries)
+	}
+
+	var out []ImportedTorrent
+	for {
+		name, b, err := readArchiveEntry(tr)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return out, err
+		}
+
+		dir, file := path.Split(name)
+		h := strings.TrimSuffix(file, path.Ext(file))
+		e, ok := pending[h]
+		switch {
+		case !ok:
+			return out, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, name)
+		case dir == torrentsDir+"/":
+			e.torrent = b
+		case dir == resumeDir+"/":
+			e.resume = b
+		default:
+			return out, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, name)
+		}
+		if e.torrent == nil || e.resume == nil {
+			continue
+		}
+
+		// each torrent is imported as soon as its entries were read.
+		delete(pending, h)
+		imported, err := p.importTorrent(h, e.torrent, e.resume, dataRoot)
+		if err != nil {
+			return out, fmt.Errorf("failed to import torrent with id %s: %w", h, err)
+		}
+		out = append(out, imported)
+	}
+
+	if len(pending) != 0 {
+		return out, fmt.Errorf("%w: %d torrents are incomplete", ErrInvalidArchive, len(pending))
+	}
+	return out, nil
+}
+
+func (p *Client) importTorrent(h string, torrentFile, resume []byte, dataRoot string) (ImportedTorrent, error) {
+	mi, err := torrent.LoadBytes(torrentFile)
+	if err != nil {
+		return ImportedTorrent{}, fmt.Errorf("%w: invalid torrent file: %w", ErrInvalidArchive, err)
+	}
+	if hex.EncodeToString(mi.Metadata.Hash[:]) != h {
+		return ImportedTorrent{}, fmt.Errorf("%w: torrent file has info hash %x", ErrInvalidArchive, mi.Metadata.Hash)
+	}
+
+	out := ImportedTorrent{InfoHash: h, Name: mi.Name()}
+	if _, err := p.tracker(string(mi.Metadata.Hash[:])); err == nil {
+		out.Skipped = true
+		return out, nil
+	}
+
+	start := time.Now()
+	stats, err := status.RestoreResume(resume, mi, filepath.Join(dataRoot, h))
+	if err != nil {
+		return ImportedTorrent{}, err
+	}
+	out.RestoreStats = stats
+	p.logger.Info("restored resume data of imported torrent",
+		slog.String("infoHash", h),
+		slog.Int64("pieces", stats.Pieces),
+		slog.Int64("rechecked", stats.Rechecked),
+		slog.Int64("invalid", stats.Invalid),
+		slog.Duration("took", time.Since(start)),
+	)
+
+	if _, err := p.WorkOnWithOptions(mi, TorrentWithDir(dataRoot)); err != nil {
+		if errors.Is(err, ErrAlreadyTracked) {
+			return ImportedTorrent{InfoHash: h, Name: out.Name, Skipped: true}, nil
+		}
+		return ImportedTorrent{}, err
+	}
+	return out, nil
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, b []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(b)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// readArchiveEntry returns the name and contents of the next regular
+// file of the archive. It returns io.EOF at the end of the archive.
+func readArchiveEntry(tr *tar.Reader) (string, []byte, error) {
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil, io.EOF
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxArchiveEntrySize {
+			return "", nil, fmt.Errorf("%w: entry %s exceeds %d bytes", ErrInvalidArchive, hdr.Name, maxArchiveEntrySize)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: failed to read entry %s: %w", ErrInvalidArchive, hdr.Name, err)
+		}
+		return hdr.Name, b, nil
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_ExportImportSession(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	// each torrent is a single downloaded piece, copied to the new
+	// data root differently to exercise the rechecks of the import.
+	type copyMode int
+	const (
+		unchanged copyMode = iota
+		touched
+		corrupted
+		missing
+	)
+	tests := []struct {
+		data   string
+		mode   copyMode
+		paused bool
+		want   ImportedTorrent
+		state  TorrentState
+	}{
+		{data: "unchanged piece", mode: unchanged, paused: true, want: ImportedTorrent{RestoreStats: RestoreStats{Pieces: 1}}, state: StatePaused},
+		{data: "touched piece", mode: touched, want: ImportedTorrent{RestoreStats: RestoreStats{Pieces: 1, Rechecked: 1}}, state: StateSeeding},
+		{data: "corrupted piece", mode: corrupted, want: ImportedTorrent{RestoreStats: RestoreStats{Pieces: 1, Rechecked: 1, Invalid: 1}}, state: StateDownloading},
+		{data: "missing piece", mode: missing, want: ImportedTorrent{RestoreStats: RestoreStats{Pieces: 1, Rechecked: 1, Invalid: 1}}, state: StateDownloading},
+	}
+
+	srcDir, dataRoot := t.TempDir(), t.TempDir()
+	resume, err := json.Marshal(map[string][]byte{"bitfield": {0x80}})
+	assert.NoError(t, err)
+
+	src, err := New(WithLogger(logger), WithDownloadDir(srcDir))
+	assert.NoError(t, err)
+	t.Cleanup(func() { src.Close(context.Background()) })
+
+	for i, tt := range tests {
+		mi, err := torrent.From(bytes.NewReader(bencodeTorrent(tracker.URL, []byte(tt.data))))
+		assert.NoError(t, err)
+		h := hex.EncodeToString(mi.Metadata.Hash[:])
+		tests[i].want.InfoHash, tests[i].want.Name = h, "test.bin"
+
+		dir := filepath.Join(srcDir, h)
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "0.bin"), []byte(tt.data), 0o644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "resume.json"), resume, 0o644))
+
+		var opts []TorrentOption
+		if tt.paused {
+			opts = append(opts, WithStartPaused())
+		}
+		_, err = src.WorkOnWithOptions(mi, opts...)
+		assert.NoError(t, err)
+	}
+
+	var archive bytes.Buffer
+	assert.NoError(t, src.Export(&archive))
+
+	for _, tt := range tests {
+		from := filepath.Join(srcDir, tt.want.InfoHash, "0.bin")
+		to := filepath.Join(dataRoot, tt.want.InfoHash, "0.bin")
+		fi, err := os.Stat(from)
+		assert.NoError(t, err)
+
+		switch tt.mode {
+		case missing:
+			continue
+		case corrupted:
+			assert.NoError(t, os.MkdirAll(filepath.Dir(to), os.ModePerm))
+			assert.NoError(t, os.WriteFile(to, bytes.Repeat([]byte{'x'}, len(tt.data)), 0o644))
+			assert.NoError(t, os.Chtimes(to, fi.ModTime(), fi.ModTime().Add(time.Hour)))
+		default:
+			assert.NoError(t, os.MkdirAll(filepath.Dir(to), os.ModePerm))
+			assert.NoError(t, os.WriteFile(to, []byte(tt.data), 0o644))
+			mtime := fi.ModTime()
+			if tt.mode == touched {
+				mtime = mtime.Add(time.Hour)
+			}
+			assert.NoError(t, os.Chtimes(to, mtime, mtime))
+		}
+	}
+
+	dst, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { dst.Close(context.Background()) })
+
+	imported, err := dst.ImportSession(bytes.NewReader(archive.Bytes()), dataRoot)
+	assert.NoError(t, err)
+	assert.Len(t, imported, len(tests))
+	for _, tt := range tests {
+		assert.Contains(t, imported, tt.want)
+
+		id, err := hex.DecodeString(tt.want.InfoHash)
+		assert.NoError(t, err)
+		s, err := dst.Status(string(id))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.state, s.State, tt.data)
+	}
+
+	// importing again completes an interrupted import without changes.
+	imported, err = dst.ImportSession(bytes.NewReader(archive.Bytes()), dataRoot)
+	assert.NoError(t, err)
+	assert.Len(t, imported, len(tests))
+	for _, it := range imported {
+		assert.True(t, it.Skipped)
+	}
+}
+
+func TestClient_ImportSession_InvalidArchive(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	_, err = c.ImportSession(strings.NewReader("not an archive"), t.TempDir())
+	assert.ErrorIs(t, err, ErrInvalidArchive)
+
+	// an archive without torrents is valid.
+	var archive bytes.Buffer
+	assert.NoError(t, c.Export(&archive))
+	imported, err := c.ImportSession(&archive, t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, imported)
+}
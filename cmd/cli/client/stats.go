@@ -0,0 +1,186 @@
+package client
+
+import (
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+)
+
+type (
+	// PeerStat is a snapshot of the download statistics of a single peer.
+	PeerStat = status.PeerStat
+	// LeecherStat is a snapshot of the upload statistics of a single leecher.
+	LeecherStat = status.LeecherStat
+	// DownloadStats is the throughput of the download stages of a torrent.
+	DownloadStats = status.DownloadStats
+	// BufferStats are the bytes of piece data held in memory per stage.
+	BufferStats = status.BufferStats
+	// TrackerStatus is the outcome of the announces of a torrent to its tracker.
+	TrackerStatus = status.TrackerStatus
+	// TransferStats are the smoothed transfer rates and the ETA of a torrent.
+	TransferStats = status.TransferStats
+	// WasteStats are the downloaded bytes of a torrent that were discarded.
+	WasteStats = status.WasteStats
+	// PieceSource is where the data of a verified piece was obtained from.
+	PieceSource = status.PieceSource
+	// SourceStats are the bytes of the verified pieces of a torrent by their source.
+	SourceStats = status.SourceStats
+	// ShareStats are the bytes a torrent uploaded and downloaded, and their ratios.
+	ShareStats = status.ShareStats
+	// BannedPeers are the addresses and peer ids a torrent banned for corrupt data.
+	BannedPeers = status.BannedPeers
+	// SlotInfo is a snapshot of a piece a torrent downloads in one of its slots.
+	SlotInfo = status.SlotInfo
+)
+
+const (
+	PieceSourceNone  = status.PieceSourceNone
+	PieceSourcePeers = status.PieceSourcePeers
+	PieceSourceLocal = status.PieceSourceLocal
+)
+
+// UnknownETA is reported as the ETA while nothing is being downloaded.
+const UnknownETA = status.UnknownETA
+
+// DistributedCopies returns the number of complete copies of a torrent
+// whose pieces are available as often as reported by Client.Availability.
+func DistributedCopies(availability []int) float64 { return status.DistributedCopies(availability) }
+
+// PeerStats returns the download statistics of the peers
+// of the torrent with the given id.
+func (p *Client) PeerStats(id string) ([]PeerStat, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return nil, err
+	}
+	return tr.PeerStats(), nil
+}
+
+// LeecherStats returns the upload statistics of the
+// connected leechers of the torrent with the given id.
+func (p *Client) LeecherStats(id string) ([]LeecherStat, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return nil, err
+	}
+	return tr.LeecherStats(), nil
+}
+
+// TrackerStatus returns the outcome of the last announce
+// of the torrent with the given id to its tracker.
+func (p *Client) TrackerStatus(id string) (TrackerStatus, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return TrackerStatus{}, err
+	}
+	return tr.TrackerStatus(), nil
+}
+
+// DownloadStats returns the throughput of the download,
+// verification and flushing of the torrent with the given id.
+func (p *Client) DownloadStats(id string) (DownloadStats, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return DownloadStats{}, err
+	}
+	return tr.DownloadStats(), nil
+}
+
+// TransferStats returns the smoothed download and upload rates
+// of the torrent with the given id, and the estimated time until
+// its download completes.
+func (p *Client) TransferStats(id string) (TransferStats, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return TransferStats{}, err
+	}
+	return tr.TransferStats(), nil
+}
+
+// WasteStats returns the downloaded bytes of the torrent with
+// the given id that were discarded, by the reason they were.
+func (p *Client) WasteStats(id string) (WasteStats, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return WasteStats{}, err
+	}
+	return tr.WasteStats(), nil
+}
+
+// PieceSources returns the source of each piece of the torrent with
+// the given id, PieceSourceNone for the pieces not verified yet.
+func (p *Client) PieceSources(id string) ([]PieceSource, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return nil, err
+	}
+	return tr.PieceSources(), nil
+}
+
+// SourceStats returns the bytes of the verified pieces of the torrent
+// with the given id by whether they were downloaded from peers or web
+// seeds, or were already present.
+func (p *Client) SourceStats(id string) (SourceStats, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return SourceStats{}, err
+	}
+	return tr.SourceStats(), nil
+}
+
+// Availability returns for each piece of the torrent with the
+// given id the number of connected peers that have it.
+func (p *Client) Availability(id string) ([]int, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Availability(), nil
+}
+
+// BannedPeers returns the addresses and peer ids the torrent
+// with the given id banned for delivering corrupt data.
+func (p *Client) BannedPeers(id string) (BannedPeers, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return BannedPeers{}, err
+	}
+	return tr.BannedPeers(), nil
+}
+
+// Slots returns the pieces the torrent with the given id downloads,
+// with the state of their requests, for debugging stalled downloads.
+func (p *Client) Slots(id string) ([]SlotInfo, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return nil, err
+	}
+	return tr.SlotSnapshot(), nil
+}
+
+// ShareStats returns the bytes the torrent with the given id uploaded
+// and downloaded, in this run of the client and in all of its runs.
+func (p *Client) ShareStats(id string) (ShareStats, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return ShareStats{}, err
+	}
+	return tr.ShareStats(), nil
+}
+
+// TotalShareStats returns the sum of the ShareStats of all torrents,
+// whose ratios are those of the client as a whole.
+func (p *Client) TotalShareStats() ShareStats {
+	var out ShareStats
+	p.torrentsDownloading.Range(func(_, value any) bool {
+		out = out.Add(value.(*status.TorrentSession).ShareStats())
+		return true
+	})
+	return out
+}
+
+// ConnStats returns the number of peer connections of all
+// torrents and the limit they are bounded by.
+func (p *Client) ConnStats() (connections, limit int) { return p.conns.Stats() }
+
+// BufferStats returns the memory held by the pieces of all torrents
+// that are downloaded, verified or flushed.
+func (p *Client) BufferStats() BufferStats { return p.buffers.Stats() }
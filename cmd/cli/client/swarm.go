@@ -0,0 +1,178 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// SwarmHealth is the size of the swarm of a torrent as
+// reported by its trackers, see EvaluateTorrents.
+type SwarmHealth struct {
+	Torrent  *torrent.MetaInfoFile
+	InfoHash string
+	// Seeders and Leechers are the highest counts
+	// reported by any of the reachable trackers.
+	Seeders  int64
+	Leechers int64
+	// Trackers is the result of each tracker of the torrent.
+	Trackers []TrackerHealth
+}
+
+// Reachable reports whether any tracker of the torrent responded.
+func (s SwarmHealth) Reachable() bool {
+	return slices.ContainsFunc(s.Trackers, func(t TrackerHealth) bool { return t.Reachable })
+}
+
+// TrackerHealth is the response of a single tracker of a torrent.
+type TrackerHealth struct {
+	URL       string
+	Reachable bool
+	Seeders   int64
+	Leechers  int64
+	// Skipped is set if the scheme of the tracker url is not supported,
+	// such trackers are never contacted and did not fail.
+	Skipped bool
+	// Err is the reason the tracker was not reachable.
+	Err error
+}
+
+// EvaluateTorrents queries the trackers of each torrent concurrently for the
+// size of its swarm, without downloading it. Trackers are scraped, falling
+// back to an announce asking for no peers if they do not support scraping,
+// which lists this client in the swarm until the tracker expires it. The
+// results are in the order of torrents, trackers that did not respond are
+// marked as unreachable and those that are not supported as skipped. An
+// error is only returned if ctx was canceled.
+func (p *Client) EvaluateTorrents(ctx context.Context, torrents []*torrent.MetaInfoFile) ([]SwarmHealth, error) {
+	out := make([]SwarmHealth, len(torrents))
+
+	var wg sync.WaitGroup
+	for i, t := range torrents {
+		out[i] = SwarmHealth{
+			Torrent:  t,
+			InfoHash: hex.EncodeToString(t.Metadata.Hash[:]),
+		}
+		for _, announce := range t.Trackers() {
+			out[i].Trackers = append(out[i].Trackers, TrackerHealth{URL: announce})
+		}
+		for j := range out[i].Trackers {
+			wg.Add(1)
+			go func(th *TrackerHealth) {
+				defer wg.Done()
+				p.evaluateTracker(ctx, t, th)
+			}(&out[i].Trackers[j])
+		}
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to evaluate torrents: %w", err)
+	}
+
+	for i := range out {
+		for _, th := range out[i].Trackers {
+			if th.Reachable {
+				out[i].Seeders = max(out[i].Seeders, th.Seeders)
+				out[i].Leechers = max(out[i].Leechers, th.Leechers)
+			}
+		}
+	}
+	return out, nil
+}
+
+// BestSwarm returns the index of the healthiest swarm, preferring reachable
+// torrents with the most seeders and then the most leechers. It returns -1
+// if swarms is empty.
+func BestSwarm(swarms []SwarmHealth) int {
+	best := -1
+	for i, s := range swarms {
+		if best < 0 || compareSwarms(s, swarms[best]) > 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+func compareSwarms(a, b SwarmHealth) int {
+	reachable := func(s SwarmHealth) int {
+		if s.Reachable() {
+			return 1
+		}
+		return 0
+	}
+	return cmp.Or(
+		cmp.Compare(reachable(a), reachable(b)),
+		cmp.Compare(a.Seeders, b.Seeders),
+		cmp.Compare(a.Leechers, b.Leechers),
+	)
+}
+
+func (p *Client) evaluateTracker(ctx context.Context, t *torrent.MetaInfoFile, th *TrackerHealth) {
+	infoHash := string(t.Metadata.Hash[:])
+
+	scrape, err := p.trackers.Scrape(ctx, th.URL, infoHash)
+	if err == nil {
+		th.Reachable, th.Seeders, th.Leechers = true, scrape.Complete, scrape.Incomplete
+		return
+	}
+	if errors.Is(err, tracker.ErrUnsupportedScheme) {
+		th.Skipped = true
+		return
+	}
+	if errors.Is(err, tracker.ErrNotTracked) {
+		th.Reachable = true
+		return
+	}
+	if ctx.Err() != nil {
+		th.Err = err
+		return
+	}
+
+	// not every tracker supports scraping, the announce also reports the swarm size.
+	resp, err := p.trackers.CreateRequest(ctx, th.URL, &tracker.RequestParams{
+		InfoHash: infoHash,
+		PeerID:   p.id,
+		Port:     p.announcePort(nil),
+		Left:     t.BytesToDownload(),
+		Compact:  tracker.Optional[int64](1),
+		NumWant:  tracker.Optional[int64](0),
+		Key:      tracker.Optional(p.key),
+	})
+	if err != nil {
+		th.Err = err
+		return
+	}
+	th.Reachable = true
+	if resp.Complete != nil {
+		th.Seeders = *resp.Complete
+	}
+	if resp.Incomplete != nil {
+		th.Leechers = *resp.Incomplete
+	}
+}
+
+// checkTrackerCredentials warns about the trackers of t embedding
+// credentials in their URL or rejects them if WithRejectTrackerCredentials is set.
+func (p *Client) checkTrackerCredentials(t *torrent.MetaInfoFile) error {
+	for _, u := range t.Trackers() {
+		if !tracker.HasCredentials(u) {
+			continue
+		}
+		if p.rejectCredentials {
+			return fmt.Errorf("tracker %s: %w", tracker.RedactURL(u), ErrTrackerCredentials)
+		}
+		p.logger.Warn("announce url embeds credentials, sending them in the Authorization header instead",
+			slog.String("url", tracker.RedactURL(u)),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_EvaluateTorrents(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// scrapeTracker supports scraping, announceTracker only reports the swarm on announces.
+	scrapeTracker := func(seeders, leechers int) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			hash := r.URL.Query().Get("info_hash")
+			fmt.Fprintf(rw, "d5:filesd20:%sd8:completei%de10:incompletei%deeee", hash, seeders, leechers)
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/announce"
+	}
+	announceTracker := func(seeders, leechers int) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/announce" || r.URL.Query().Get("numwant") != "0" {
+				http.NotFound(rw, r)
+				return
+			}
+			fmt.Fprintf(rw, "d8:completei%de10:incompletei%de8:intervali60e5:peers0:e", seeders, leechers)
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/announce"
+	}
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	small := newTestTorrent(scrapeTracker(1, 2))
+	small.Metadata.Hash[0] = 1
+
+	large := newTestTorrent(unreachable.URL + "/announce")
+	large.AnnounceList = []string{announceTracker(3, 0), scrapeTracker(7, 1), "wss://tracker.example.com:80"}
+	large.Metadata.Hash[0] = 2
+
+	dead := newTestTorrent(unreachable.URL + "/announce")
+	dead.Metadata.Hash[0] = 3
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	swarms, err := c.EvaluateTorrents(ctx, []*torrent.MetaInfoFile{small, large, dead})
+	assert.NoError(t, err)
+	if !assert.Len(t, swarms, 3) {
+		return
+	}
+
+	assert.True(t, swarms[0].Reachable())
+	assert.Equal(t, int64(1), swarms[0].Seeders)
+	assert.Equal(t, int64(2), swarms[0].Leechers)
+
+	assert.True(t, swarms[1].Reachable())
+	assert.Equal(t, int64(7), swarms[1].Seeders)
+	assert.Equal(t, int64(1), swarms[1].Leechers)
+	if assert.Len(t, swarms[1].Trackers, 4) {
+		assert.False(t, swarms[1].Trackers[0].Reachable)
+		assert.Error(t, swarms[1].Trackers[0].Err)
+		assert.True(t, swarms[1].Trackers[1].Reachable)
+		assert.Equal(t, int64(3), swarms[1].Trackers[1].Seeders)
+		assert.True(t, swarms[1].Trackers[2].Reachable)
+		assert.False(t, swarms[1].Trackers[3].Reachable)
+		assert.True(t, swarms[1].Trackers[3].Skipped)
+		assert.NoError(t, swarms[1].Trackers[3].Err, "unsupported trackers did not fail")
+	}
+
+	assert.False(t, swarms[2].Reachable())
+	assert.Equal(t, 1, BestSwarm(swarms))
+
+	cancel()
+	_, err = c.EvaluateTorrents(ctx, []*torrent.MetaInfoFile{small})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBestSwarm(t *testing.T) {
+	reachable := []TrackerHealth{{Reachable: true}}
+	tests := []struct {
+		name   string
+		swarms []SwarmHealth
+		want   int
+	}{
+		{name: "empty", want: -1},
+		{
+			name: "most seeders",
+			swarms: []SwarmHealth{
+				{Seeders: 1, Leechers: 10, Trackers: reachable},
+				{Seeders: 2, Trackers: reachable},
+			},
+			want: 1,
+		},
+		{
+			name: "most leechers on tie",
+			swarms: []SwarmHealth{
+				{Seeders: 2, Leechers: 3, Trackers: reachable},
+				{Seeders: 2, Leechers: 1, Trackers: reachable},
+			},
+			want: 0,
+		},
+		{
+			name: "reachable first",
+			swarms: []SwarmHealth{
+				{},
+				{Trackers: reachable},
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, BestSwarm(tt.swarms))
+		})
+	}
+}
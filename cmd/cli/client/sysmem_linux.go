@@ -0,0 +1,18 @@
+//go:build linux
+
+package client
+
+import (
+	"math"
+	"syscall"
+)
+
+// systemMemory returns the bytes of physical memory of the system.
+func systemMemory() (int64, bool) {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0, false
+	}
+	total := uint64(info.Totalram) * uint64(info.Unit)
+	return int64(min(total, math.MaxInt64)), total > 0
+}
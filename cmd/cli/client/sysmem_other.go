@@ -0,0 +1,6 @@
+//go:build !linux
+
+package client
+
+// systemMemory reports false, as the memory of the system is not looked up.
+func systemMemory() (int64, bool) { return 0, false }
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"cmp"
+	"encoding/hex"
+	"math"
+	"slices"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/status"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// StatusVersion is the version of the TorrentStatus schema. It is
+// incremented whenever a field is removed or changes its meaning,
+// adding fields keeps the version.
+const StatusVersion = 1
+
+// TorrentState is the state a torrent is in.
+type TorrentState string
+
+const (
+	StateDownloading TorrentState = "downloading"
+	StateSeeding     TorrentState = "seeding"
+	StatePaused      TorrentState = "paused"
+	StateQueued      TorrentState = "queued"
+	StateChecking    TorrentState = "checking"
+	StateError       TorrentState = "error"
+	// StateFinished is a torrent that reached its seeding goals.
+	StateFinished TorrentState = "finished"
+)
+
+// TorrentStatus is a snapshot of the progress of a single torrent,
+// meant to be consumed by other programs as JSON.
+type TorrentStatus struct {
+	Version  int    `json:"version"`
+	InfoHash string `json:"infoHash"`
+	// InfoHashBase32 is the info hash encoded in base32, as in older magnet links.
+	InfoHashBase32 string `json:"infoHashBase32"`
+	// Magnet is the magnet link to share the torrent with, see MagnetLink.
+	Magnet string `json:"magnet"`
+	Name   string `json:"name"`
+	// Size is the number of bytes of the torrent.
+	Size int64 `json:"size"`
+	// Wanted is the number of bytes of the files of the
+	// torrent, which excludes the padding files within Size.
+	Wanted     int64 `json:"wanted"`
+	Downloaded int64 `json:"downloaded"`
+	Uploaded   int64 `json:"uploaded"`
+	// DownloadRate and UploadRate are in bytes per second.
+	DownloadRate int64        `json:"downloadRate"`
+	UploadRate   int64        `json:"uploadRate"`
+	Seeders      int          `json:"seeders"`
+	Leechers     int          `json:"leechers"`
+	State        TorrentState `json:"state"`
+	// Snatches is the number of completed downloads of the torrent
+	// reported by its tracker, zero if it never did.
+	Snatches int64 `json:"snatches,omitempty"`
+	// Error is the reason the torrent failed, set in StateError.
+	Error string `json:"error,omitempty"`
+	// QueueReason is why the download waits, set in StateQueued,
+	// e.g. as the memory budget of the client is exhausted.
+	QueueReason string `json:"queueReason,omitempty"`
+	// Files is the progress of each file of a multi-file torrent.
+	Files []FileStatus `json:"files,omitempty"`
+	// Check is the progress of the recheck, set in StateChecking.
+	Check *CheckStatus `json:"check,omitempty"`
+	// Abandoned are the pieces that are not downloaded until reclaimed.
+	Abandoned []int64 `json:"abandoned,omitempty"`
+	// ListenPort is the port connections are accepted on, zero if
+	// none are. AnnouncePort is the port announced to the tracker and
+	// the DHT, which differs if overridden, see WithAnnouncePort.
+	ListenPort   int `json:"listenPort,omitempty"`
+	AnnouncePort int `json:"announcePort,omitempty"`
+	// Ratio and SessionRatio are the ratios of the bytes uploaded to
+	// those downloaded in all runs and in this run, see ShareStats.
+	Ratio        float64 `json:"ratio"`
+	SessionRatio float64 `json:"sessionRatio"`
+	// DistributedCopies is the number of complete copies of the
+	// torrent among the connected peers, see Client.Availability.
+	DistributedCopies float64 `json:"distributedCopies"`
+	// Limits are the limits of the torrent, see Client.SetMaxPeers.
+	Limits TorrentLimits `json:"limits"`
+}
+
+// CheckStatus is the progress of the recheck of a torrent.
+type CheckStatus struct {
+	// Checked is the number of the pieces verified, including
+	// those verified by an interrupted recheck continued from.
+	Checked int64 `json:"checked"`
+	Pieces  int64 `json:"pieces"`
+	// Percent is the share of the pieces verified, between 0 and 100.
+	Percent float64 `json:"percent"`
+	// ETA is the estimated number of seconds until all pieces
+	// are verified, zero until the first piece was verified.
+	ETA int64 `json:"eta"`
+}
+
+// FileStatus is the progress of a single file of a multi-file torrent.
+type FileStatus struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Downloaded is the number of bytes of the file within verified pieces.
+	Downloaded int64 `json:"downloaded"`
+}
+
+// Progress returns the downloaded fraction of the torrent, between 0 and 1.
+func (s TorrentStatus) Progress() float64 {
+	if s.Size == 0 {
+		return 1
+	}
+	return min(float64(s.Downloaded)/float64(s.Size), 1)
+}
+
+// Status returns the status of the torrent with the given id.
+func (p *Client) Status(id string) (TorrentStatus, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return TorrentStatus{}, err
+	}
+	return p.torrentStatus(id, tr), nil
+}
+
+// TorrentInfo returns the metadata of the torrent with the given id,
+// as found in its metainfo file.
+func (p *Client) TorrentInfo(id string) (torrent.Description, error) {
+	tr, err := p.tracker(id)
+	if err != nil {
+		return torrent.Description{}, err
+	}
+	return tr.Torrent().Describe(), nil
+}
+
+// Statuses returns the status of all torrents, ordered by name.
+func (p *Client) Statuses() []TorrentStatus {
+	var out []TorrentStatus
+	p.torrentsDownloading.Range(func(key, value any) bool {
+		out = append(out, p.torrentStatus(key.(string), value.(*status.TorrentSession)))
+		return true
+	})
+	slices.SortFunc(out, func(a, b TorrentStatus) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.InfoHash, b.InfoHash))
+	})
+	return out
+}
+
+func (p *Client) torrentStatus(id string, tr *status.TorrentSession) TorrentStatus {
+	transfer := tr.TransferStats()
+	share := tr.ShareStats()
+	seeders, leechers := tr.PeerCounts()
+
+	s := TorrentStatus{
+		Version:           StatusVersion,
+		InfoHash:          hex.EncodeToString([]byte(id)),
+		Size:              tr.Torrent().BytesToDownload(),
+		Wanted:            tr.Torrent().WantedBytes(),
+		Downloaded:        tr.Downloaded(),
+		Uploaded:          tr.Uploaded(),
+		DownloadRate:      transfer.DownloadRate,
+		UploadRate:        transfer.UploadRate,
+		Seeders:           seeders,
+		Leechers:          leechers,
+		State:             torrentState(tr),
+		Name:              tr.Torrent().Name(),
+		Magnet:            MagnetLink(tr.Torrent()),
+		InfoHashBase32:    tr.Torrent().Base32Hash(),
+		Abandoned:         tr.AbandonedPieces(),
+		Snatches:          tr.TrackerStatus().Downloaded,
+		AnnouncePort:      int(p.announcePort(tr)),
+		Ratio:             share.Ratio(),
+		SessionRatio:      share.SessionRatio(),
+		DistributedCopies: tr.DistributedCopies(),
+		Limits:            torrentLimits(tr.Limits()),
+	}
+	if p.seedServer != nil {
+		s.ListenPort = int(p.listenPort())
+	}
+	if s.State == StateError {
+		s.Error = tr.Err().Error()
+	}
+	if s.State == StateQueued {
+		s.QueueReason = tr.QueueReason()
+	}
+	if progress, ok := tr.Rechecking(); ok && s.State == StateChecking {
+		s.Check = &CheckStatus{
+			Checked: progress.Checked,
+			Pieces:  progress.Pieces,
+			Percent: 100 * float64(progress.Checked) / float64(max(progress.Pieces, 1)),
+			ETA:     int64(math.Ceil(progress.ETA.Seconds())),
+		}
+	}
+
+	if tr.Torrent().InfoMultiFile != nil {
+		s.Files = fileProgress(tr.Torrent(), tr.HasPiece)
+	}
+	return s
+}
+
+func torrentState(tr *status.TorrentSession) TorrentState {
+	_, checking := tr.Rechecking()
+	switch {
+	case tr.Err() != nil:
+		return StateError
+	case checking:
+		return StateChecking
+	case tr.Paused():
+		return StatePaused
+	case tr.Queued():
+		return StateQueued
+	case tr.Finished():
+		return StateFinished
+	}
+	select {
+	case <-tr.WaitUntilDownloaded():
+		return StateSeeding
+	default:
+		return StateDownloading
+	}
+}
+
+// fileProgress returns the bytes of each file of the multi-file torrent
+// mi that lie within the pieces for which have reports true. Padding
+// files are left out, and empty files never lie within a piece.
+func fileProgress(mi *torrent.MetaInfoFile, have func(piece int64) bool) []FileStatus {
+	var out []FileStatus
+	var offset int64
+	for _, f := range mi.InfoMultiFile.Files {
+		if f.IsPadding() {
+			offset += f.Length
+			continue
+		}
+		fs := FileStatus{Path: f.Path, Size: f.Length}
+		for piece := offset / mi.PieceLength; f.Length > 0 && piece*mi.PieceLength < offset+f.Length; piece++ {
+			if !have(piece) {
+				continue
+			}
+			start := max(piece*mi.PieceLength, offset)
+			end := min((piece+1)*mi.PieceLength, offset+f.Length)
+			fs.Downloaded += end - start
+		}
+		out = append(out, fs)
+		offset += f.Length
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProgress(t *testing.T) {
+	// pieces of 10 bytes, the second file spans pieces 1 to 3.
+	mi := &torrent.MetaInfoFile{Info: torrent.Info{
+		PieceLength: 10,
+		InfoMultiFile: &torrent.InfoMultiFile{
+			Name: "dir",
+			Files: []torrent.FileInfo{
+				{Path: "a", Length: 15},
+				{Path: "b", Length: 20},
+				{Path: "c", Length: 5},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name string
+		have []int64
+		want []int64
+	}{
+		{name: "nothing", want: []int64{0, 0, 0}},
+		{name: "shared piece", have: []int64{1}, want: []int64{5, 5, 0}},
+		{name: "last piece", have: []int64{3}, want: []int64{0, 5, 5}},
+		{name: "everything", have: []int64{0, 1, 2, 3}, want: []int64{15, 20, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have := func(piece int64) bool {
+				for _, h := range tt.have {
+					if h == piece {
+						return true
+					}
+				}
+				return false
+			}
+			var got []int64
+			for _, f := range fileProgress(mi, have) {
+				got = append(got, f.Downloaded)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFileProgress_EmptyAndPaddingFiles(t *testing.T) {
+	// a padding file aligns b to the second piece, c is empty.
+	mi := &torrent.MetaInfoFile{Info: torrent.Info{
+		PieceLength: 10,
+		InfoMultiFile: &torrent.InfoMultiFile{
+			Name: "dir",
+			Files: []torrent.FileInfo{
+				{Path: "a", Length: 7},
+				{Path: ".pad/3", Length: 3, Attr: "p"},
+				{Path: "b", Length: 5},
+				{Path: "c"},
+			},
+		},
+	}}
+	assert.Equal(t, int64(12), mi.WantedBytes())
+
+	var pieces []int64
+	have := func(piece int64) bool {
+		pieces = append(pieces, piece)
+		return true
+	}
+	assert.Equal(t, []FileStatus{
+		{Path: "a", Size: 7, Downloaded: 7},
+		{Path: "b", Size: 5, Downloaded: 5},
+		{Path: "c"},
+	}, fileProgress(mi, have))
+	assert.Equal(t, []int64{0, 1}, pieces)
+}
+
+func TestClient_Status(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	mi := newTestTorrent("http://localhost/announce")
+	id, err := c.WorkOnWithOptions(mi, WithStartPaused())
+	assert.NoError(t, err)
+
+	s, err := c.Status(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []TorrentStatus{s}, c.Statuses())
+
+	b, err := json.Marshal(s)
+	assert.NoError(t, err)
+	var got TorrentStatus
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, TorrentStatus{
+		Version:        StatusVersion,
+		InfoHash:       hex.EncodeToString([]byte(id)),
+		InfoHashBase32: mi.Base32Hash(),
+		Magnet:         "magnet:?xt=urn:btih:" + mi.HexHash() + "&dn=test.bin&tr=http%3A%2F%2Flocalhost%2Fannounce",
+		Name:           "test.bin",
+		Size:           16 * 1024,
+		Wanted:         16 * 1024,
+		State:          StatePaused,
+		// the default port, as leechers do not accept connections.
+		AnnouncePort: 6882,
+	}, got)
+	assert.Zero(t, got.Progress())
+
+	tr, err := c.tracker(id)
+	assert.NoError(t, err)
+	tr.RecordAnnounce(&tracker.Response{Downloaded: tracker.Optional[int64](512)}, nil, time.Now())
+	s, err = c.Status(id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(512), s.Snatches)
+
+	_, err = c.Status("unknown")
+	assert.Error(t, err)
+}
+
+func TestClient_TorrentInfo(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	mi := newTestTorrent("http://localhost/announce")
+	id, err := c.WorkOnWithOptions(mi, WithStartPaused())
+	assert.NoError(t, err)
+
+	d, err := c.TorrentInfo(id)
+	assert.NoError(t, err)
+	assert.Equal(t, mi.Describe(), d)
+	assert.Equal(t, [][]string{{"http://localhost/announce"}}, d.Tiers)
+	assert.Equal(t, []torrent.FileInfo{{Length: 16 * 1024, Path: "test.bin"}}, d.Files)
+
+	_, err = c.TorrentInfo("unknown")
+	assert.ErrorIs(t, err, ErrTorrentNotFound)
+}
@@ -0,0 +1,411 @@
+package tortest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/cmd/cli/client/tortest"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+// payload returns 4.5 pieces of random data, of two blocks each.
+func payload() (pieceLength int64, data []byte) {
+	pieceLength = 2 * messagesv1.RequestSize
+	data = make([]byte, 9*messagesv1.RequestSize)
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return pieceLength, data
+}
+
+// once returns a function reporting true for the first request matching fn only.
+func once(fn func(req messagesv1.Request) bool) func(req messagesv1.Request) bool {
+	var done atomic.Bool
+	return func(req messagesv1.Request) bool {
+		return fn(req) && done.CompareAndSwap(false, true)
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	tests := []struct {
+		name string
+		// opts configure the two seeders, the download must
+		// succeed as long as either serves every block.
+		first, second []tortest.SeederOption
+		compact       bool
+	}{
+		{name: "healthy seeders", compact: true},
+		{name: "non-compact peers"},
+		{
+			// a piece failing verification once is downloaded again.
+			name:    "corrupt block",
+			first:   []tortest.SeederOption{tortest.WithCorruptBlocks(once(func(req messagesv1.Request) bool { return req.Index == 1 }))},
+			compact: true,
+		},
+		{
+			name:    "choking seeder",
+			first:   []tortest.SeederOption{tortest.WithChokeAfter(2)},
+			compact: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pieceLength, data := payload()
+			var trackerOpts []tortest.TrackerOption
+			if !tt.compact {
+				trackerOpts = append(trackerOpts, tortest.WithNonCompactPeers())
+			}
+			tracker := tortest.NewTracker(trackerOpts...)
+			t.Cleanup(tracker.Close)
+			mi, err := tortest.NewTorrent(tracker.URL, pieceLength, data)
+			if !assert.NoError(t, err) {
+				return
+			}
+			first, err := tortest.NewSeeder(mi, data, tt.first...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			t.Cleanup(first.Close)
+			second, err := tortest.NewSeeder(mi, data, tt.second...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			t.Cleanup(second.Close)
+			tracker.SetPeers(first.Addr, second.Addr)
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()))
+			if !assert.NoError(t, err) {
+				return
+			}
+			t.Cleanup(func() { c.Close(context.Background()) })
+
+			dir := t.TempDir()
+			id, err := c.WorkOnWithOptions(mi, client.TorrentWithDir(dir))
+			if !assert.NoError(t, err) {
+				return
+			}
+			select {
+			case err := <-c.WaitFor(id):
+				assert.NoError(t, err)
+			case <-time.After(20 * time.Second):
+				t.Fatal("torrent was not downloaded")
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, mi.HexHash(), mi.Name()))
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, got), "downloaded data differs")
+
+			announces := tracker.Announces()
+			if assert.NotEmpty(t, announces) {
+				assert.Equal(t, "started", announces[0].Event)
+				assert.Equal(t, string(mi.Metadata.Hash[:]), announces[0].InfoHash)
+				assert.Equal(t, int64(len(data)), announces[0].Left)
+			}
+			assert.NotEmpty(t, second.Requests())
+		})
+	}
+}
+
+func TestClient_BannedPeerIDReconnects(t *testing.T) {
+	pieceLength, data := payload()
+	tracker := tortest.NewTracker(tortest.WithInterval(time.Second))
+	t.Cleanup(tracker.Close)
+	mi, err := tortest.NewTorrent(tracker.URL, pieceLength, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	seeder := func(id string, opts ...tortest.SeederOption) *tortest.Seeder {
+		s, err := tortest.NewSeeder(mi, data, append(opts, tortest.WithPeerID(id))...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(s.Close)
+		return s
+	}
+	// the poisoner corrupts every block, and reconnects from other addresses.
+	const poisonerID = "-XX0100-a1b2c3d4e5f6"
+	corrupt := tortest.WithCorruptBlocks(func(messagesv1.Request) bool { return true })
+	poisoner := seeder(poisonerID, corrupt)
+	tracker.SetPeers(poisoner.Addr)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	id, err := c.WorkOn(mi)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bannedID := hex.EncodeToString([]byte(poisonerID))
+	assert.Eventually(t, func() bool {
+		bans, err := c.BannedPeers(id)
+		return err == nil && slices.Contains(bans.PeerIDs, bannedID)
+	}, 10*time.Second, 10*time.Millisecond)
+
+	moved, again := seeder(poisonerID, corrupt), seeder(poisonerID, corrupt)
+	honest := seeder("-TS0001-9f8e7d6c5b4a")
+	tracker.SetPeers(moved.Addr, again.Addr, honest.Addr)
+
+	select {
+	case err := <-c.WaitFor(id):
+		assert.NoError(t, err)
+	case <-time.After(20 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+
+	// the poisoner is dropped right after the handshake at the new addresses.
+	assert.Empty(t, moved.Requests())
+	assert.Empty(t, again.Requests())
+	bans, err := c.BannedPeers(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{bannedID}, bans.PeerIDs)
+	assert.ElementsMatch(t, []string{poisoner.Addr, moved.Addr, again.Addr}, bans.Addrs)
+}
+
+func TestClient_MemoryBudgetQueuesTorrents(t *testing.T) {
+	pieceLength, data := payload()
+	other := slices.Clone(data)
+	other[0]++
+
+	// the first torrent finds its seeder only once the second was queued.
+	firstTracker := tortest.NewTracker(tortest.WithInterval(time.Second))
+	t.Cleanup(firstTracker.Close)
+	secondTracker := tortest.NewTracker()
+	t.Cleanup(secondTracker.Close)
+	first, err := tortest.NewTorrent(firstTracker.URL, pieceLength, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	second, err := tortest.NewTorrent(secondTracker.URL, pieceLength, other)
+	if !assert.NoError(t, err) {
+		return
+	}
+	firstSeeder, err := tortest.NewSeeder(first, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(firstSeeder.Close)
+	secondSeeder, err := tortest.NewSeeder(second, other)
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(secondSeeder.Close)
+	secondTracker.SetPeers(secondSeeder.Addr)
+
+	// room for the piece data downloaded concurrently and one small torrent.
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()), client.WithMemoryBudget(67<<20))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	firstID, err := c.WorkOn(first)
+	if !assert.NoError(t, err) {
+		return
+	}
+	secondID, err := c.WorkOn(second)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s, err := c.Status(secondID)
+	assert.NoError(t, err)
+	assert.Equal(t, client.StateQueued, s.State)
+	assert.Contains(t, s.QueueReason, "memory budget")
+	s, err = c.Status(firstID)
+	assert.NoError(t, err)
+	assert.Equal(t, client.StateDownloading, s.State)
+
+	firstTracker.SetPeers(firstSeeder.Addr)
+	for _, id := range []string{firstID, secondID} {
+		select {
+		case err := <-c.WaitFor(id):
+			assert.NoError(t, err)
+		case <-time.After(20 * time.Second):
+			t.Fatal("torrent was not downloaded")
+		}
+	}
+	assert.NotEmpty(t, secondSeeder.Requests())
+}
+
+func TestClient_AnnounceCountersOfPartialStart(t *testing.T) {
+	pieceLength, data := payload()
+	tracker := tortest.NewTracker(tortest.WithInterval(time.Second))
+	t.Cleanup(tracker.Close)
+	mi, err := tortest.NewTorrent(tracker.URL, pieceLength, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	// the first run downloads four blocks, a piece at a time, before the seeder stalls.
+	stalling, err := tortest.NewSeeder(mi, data, tortest.WithStallAfter(4))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(stalling.Close)
+	tracker.SetPeers(stalling.Addr)
+	first, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	id, err := first.WorkOnWithOptions(mi, client.TorrentWithDir(dir), client.TorrentWithMaxActivePieces(1))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var restored int64
+	assert.Eventually(t, func() bool {
+		s, err := first.Status(id)
+		restored = s.Downloaded
+		return err == nil && restored == 2*pieceLength
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.NoError(t, first.Close(context.Background()))
+	resumed := len(tracker.Announces())
+
+	// the second run resumes them, and discards the first block it receives.
+	seeder, err := tortest.NewSeeder(mi, data, tortest.WithCorruptBlocks(once(func(messagesv1.Request) bool { return true })))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(seeder.Close)
+	tracker.SetPeers(seeder.Addr)
+	second, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()), client.WithAction(client.Both))
+	if !assert.NoError(t, err) {
+		return
+	}
+	id, err = second.WorkOnWithOptions(mi, client.TorrentWithDir(dir))
+	if !assert.NoError(t, err) {
+		return
+	}
+	select {
+	case err := <-second.WaitFor(id):
+		assert.NoError(t, err)
+	case <-time.After(20 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+	// a regular announce follows the completed one while seeding.
+	assert.Eventually(t, func() bool {
+		announces := tracker.Announces()
+		return announces[len(announces)-1].Event == ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, second.Close(context.Background()))
+
+	announces := tracker.Announces()[resumed:]
+	if !assert.GreaterOrEqual(t, len(announces), 4) {
+		return
+	}
+	started, completed, stopped := announces[0], slices.IndexFunc(announces, func(a tortest.Announce) bool { return a.Event == "completed" }), announces[len(announces)-1]
+	assert.Equal(t, "started", started.Event)
+	assert.Zero(t, started.Uploaded)
+	assert.Zero(t, started.Downloaded)
+	assert.Zero(t, started.Corrupt)
+	// the blocks of the pieces not verified before the first run stopped are left.
+	assert.GreaterOrEqual(t, started.Left, int64(len(data))-restored)
+	assert.Less(t, started.Left, int64(len(data)))
+	if assert.Greater(t, completed, 0) {
+		assert.Zero(t, announces[completed].Left)
+		// the piece of the discarded block was downloaded twice.
+		assert.Positive(t, announces[completed].Corrupt)
+		assert.GreaterOrEqual(t, announces[completed].Downloaded, started.Left+announces[completed].Corrupt)
+	}
+	assert.Equal(t, "stopped", stopped.Event)
+	for i := 1; i < len(announces); i++ {
+		prev, a := announces[i-1], announces[i]
+		assert.GreaterOrEqual(t, a.Uploaded, prev.Uploaded)
+		assert.GreaterOrEqual(t, a.Downloaded, prev.Downloaded)
+		assert.GreaterOrEqual(t, a.Corrupt, prev.Corrupt)
+		assert.LessOrEqual(t, a.Left, prev.Left)
+		// the bytes left and those downloaded in the session add up.
+		assert.LessOrEqual(t, started.Left-a.Left, a.Downloaded-a.Corrupt)
+	}
+}
+
+func TestClient_DownloadOddPieceLength(t *testing.T) {
+	// the pieces are not a multiple of the block size, the last block of
+	// each is truncated, as is the last piece.
+	const pieceLength = 100_000
+	data := make([]byte, 3*pieceLength+12_345)
+	r := rand.New(rand.NewPCG(3, 4))
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+
+	tracker := tortest.NewTracker()
+	t.Cleanup(tracker.Close)
+	mi, err := tortest.NewTorrent(tracker.URL, pieceLength, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var warned bool
+	for _, err := range mi.Validate() {
+		warned = warned || errors.Is(err, torrent.ErrPieceLength)
+	}
+	assert.True(t, warned, "the piece length is reported")
+
+	// the truncated last block of a piece fails the verification once.
+	truncated := func(req messagesv1.Request) bool {
+		return req.Index == 1 && int64(req.Begin)+int64(req.Length) == pieceLength
+	}
+	first, err := tortest.NewSeeder(mi, data, tortest.WithCorruptBlocks(once(truncated)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(first.Close)
+	second, err := tortest.NewSeeder(mi, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(second.Close)
+	tracker.SetPeers(first.Addr, second.Addr)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(t.TempDir()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	dir := t.TempDir()
+	id, err := c.WorkOnWithOptions(mi, client.TorrentWithDir(dir))
+	if !assert.NoError(t, err) {
+		return
+	}
+	select {
+	case err := <-c.WaitFor(id):
+		assert.NoError(t, err)
+	case <-time.After(20 * time.Second):
+		t.Fatal("torrent was not downloaded")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, mi.HexHash(), mi.Name()))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "downloaded data differs")
+
+	var requested []messagesv1.Request
+	requested = append(append(requested, first.Requests()...), second.Requests()...)
+	for _, req := range requested {
+		assert.LessOrEqual(t, int64(req.Begin)+int64(req.Length), mi.PieceSize(int64(req.Index)), "request %#v exceeds its piece", req)
+	}
+}
@@ -0,0 +1,228 @@
+package tortest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// NewTorrent returns a single file torrent of data, split into pieces
+// of pieceLength, announced to announce.
+func NewTorrent(announce string, pieceLength int64, data []byte) (*torrent.MetaInfoFile, error) {
+	var pieces []byte
+	for i := int64(0); i < int64(len(data)); i += pieceLength {
+		h := sha1.Sum(data[i:min(i+pieceLength, int64(len(data)))])
+		pieces = append(pieces, h[:]...)
+	}
+	b := fmt.Appendf(nil, "d8:announce%d:%s4:infod6:lengthi%de4:name8:data.bin12:piece lengthi%de6:pieces%d:%see",
+		len(announce), announce, len(data), pieceLength, len(pieces), pieces)
+	return torrent.From(bytes.NewReader(b))
+}
+
+// Seeder is a peer that has all pieces of a torrent and serves them over
+// the v1 wire protocol to any number of connections. It unchokes every
+// peer right away and does not support any extension.
+type Seeder struct {
+	// Addr is the host:port the seeder listens on.
+	Addr string
+
+	mi     *torrent.MetaInfoFile
+	data   []byte
+	peerID string
+
+	chokeAfter int
+	stallAfter int
+	corrupt    func(req messagesv1.Request) bool
+
+	ln    net.Listener
+	conns sync.Map
+	wg    sync.WaitGroup
+
+	l        sync.Mutex
+	requests []messagesv1.Request
+	cancels  []messagesv1.Cancel
+}
+
+// SeederOption configures a Seeder, mostly to inject faults.
+type SeederOption func(*Seeder)
+
+// WithChokeAfter chokes each connection once the seeder received n
+// requests over it, which are then never answered.
+func WithChokeAfter(n int) SeederOption {
+	return func(s *Seeder) {
+		s.chokeAfter = n
+	}
+}
+
+// WithStallAfter stops answering the requests of each connection once the
+// seeder received n of them over it, without choking or disconnecting.
+func WithStallAfter(n int) SeederOption {
+	return func(s *Seeder) {
+		s.stallAfter = n
+	}
+}
+
+// WithCorruptBlocks serves the blocks for which corrupt reports true
+// with their first byte flipped. Empty blocks are served unchanged.
+func WithCorruptBlocks(corrupt func(req messagesv1.Request) bool) SeederOption {
+	return func(s *Seeder) {
+		s.corrupt = corrupt
+	}
+}
+
+// WithPeerID sets the peer id the seeder handshakes with, which
+// defaults to a placeholder id shared by all seeders.
+func WithPeerID(id string) SeederOption {
+	return func(s *Seeder) {
+		s.peerID = id
+	}
+}
+
+// NewSeeder starts a Seeder of the torrent mi, whose content is data,
+// on a local port. It must be closed.
+func NewSeeder(mi *torrent.MetaInfoFile, data []byte, opts ...SeederOption) (*Seeder, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s := &Seeder{Addr: ln.Addr().String(), mi: mi, data: data, peerID: "-TS0001-000000000000", ln: ln}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Store(conn, struct{}{})
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.conns.Delete(conn)
+				defer conn.Close()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the seeder and closes its connections.
+func (s *Seeder) Close() {
+	s.ln.Close()
+	s.conns.Range(func(key, _ any) bool {
+		key.(net.Conn).Close()
+		return true
+	})
+	s.wg.Wait()
+}
+
+// Requests returns the requests received from all peers, oldest first.
+func (s *Seeder) Requests() []messagesv1.Request {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]messagesv1.Request(nil), s.requests...)
+}
+
+// Cancels returns the cancels received from all peers, oldest first.
+func (s *Seeder) Cancels() []messagesv1.Cancel {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]messagesv1.Cancel(nil), s.cancels...)
+}
+
+func (s *Seeder) serve(conn net.Conn) {
+	var buf [messagesv1.HandshakeLength]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return
+	}
+	remote := new(messagesv1.Handshake)
+	if err := remote.Deserialize(buf[:]); err != nil || remote.InfoHash != string(s.mi.Metadata.Hash[:]) {
+		return
+	}
+
+	have := bitfield.NewBitfield(s.mi.NumPieces())
+	for i := range s.mi.NumPieces() {
+		have.Set(i)
+	}
+	hs := &messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: remote.InfoHash, PeerID: s.peerID}
+	for _, msg := range [][]byte{
+		hs.Serialize(),
+		(&messagesv1.Bitfield{Bitfield: have.Clone()}).Serialize(),
+		messagesv1.Unchoke{}.Serialize(),
+	} {
+		if _, err := conn.Write(msg); err != nil {
+			return
+		}
+	}
+
+	// received counts the requests of this connection only.
+	choked, received := false, 0
+	for {
+		msg, err := messagesv1.Identify(conn)
+		if err != nil {
+			return
+		}
+		switch msg.Type {
+		case messagesv1.CancelType:
+			c := new(messagesv1.Cancel)
+			if err := c.Deserialize(msg.Payload); err != nil {
+				return
+			}
+			s.l.Lock()
+			s.cancels = append(s.cancels, *c)
+			s.l.Unlock()
+			continue
+		case messagesv1.RequestType:
+		default:
+			continue
+		}
+
+		req := new(messagesv1.Request)
+		if err := req.Deserialize(msg.Payload); err != nil {
+			return
+		}
+		s.l.Lock()
+		s.requests = append(s.requests, *req)
+		s.l.Unlock()
+		received++
+
+		switch {
+		case choked:
+			continue
+		case s.chokeAfter > 0 && received >= s.chokeAfter:
+			choked = true
+			if _, err := conn.Write(messagesv1.Choke{}.Serialize()); err != nil {
+				return
+			}
+			continue
+		case s.stallAfter > 0 && received > s.stallAfter:
+			continue
+		}
+
+		start := int64(req.Index)*s.mi.PieceLength + int64(req.Begin)
+		end := start + int64(req.Length)
+		if end > int64(len(s.data)) {
+			return // a request the torrent does not hold.
+		}
+		block := s.data[start:end]
+		if s.corrupt != nil && s.corrupt(*req) && len(block) > 0 {
+			block = bytes.Clone(block)
+			block[0] ^= 0xff
+		}
+		if _, err := conn.Write((&messagesv1.Piece{Index: req.Index, Begin: req.Begin, Block: block}).Serialize()); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package tortest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []TrackerOption
+		compact string
+	}{
+		{name: "compact", compact: "1"},
+		{name: "non-compact requested", compact: "0"},
+		{name: "non-compact served", opts: []TrackerOption{WithNonCompactPeers()}, compact: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker(append(tt.opts, WithPeers("127.0.0.1:6881"), WithInterval(30*time.Second))...)
+			defer tr.Close()
+			tr.SetPeers("127.0.0.1:6881", "10.0.0.1:51413")
+
+			q := url.Values{
+				"info_hash": {"01234567890123456789"},
+				"peer_id":   {"-TT0100-000000000000"},
+				"port":      {"6882"},
+				"left":      {"42"},
+				"event":     {"started"},
+				"compact":   {tt.compact},
+			}
+			resp, err := http.Get(tr.URL + "?" + q.Encode())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			var got tracker.Response
+			assert.NoError(t, tracker.DecodeResponse(resp.Body, &got))
+			assert.Equal(t, int64(30), *got.Interval)
+			if assert.Len(t, got.Peers, 2) {
+				assert.Equal(t, "10.0.0.1", got.Peers[1].IP)
+				assert.Equal(t, int64(51413), got.Peers[1].Port)
+			}
+
+			assert.Equal(t, []Announce{{
+				InfoHash: "01234567890123456789",
+				PeerID:   "-TT0100-000000000000",
+				Port:     6882,
+				Event:    "started",
+				Left:     42,
+				Corrupt:  -1,
+				Compact:  tt.compact == "1",
+			}}, tr.Announces())
+		})
+	}
+}
+
+// connect performs the handshake with s and returns the connection, after
+// the bitfield and the unchoke of the seeder were read.
+func connect(t *testing.T, s *Seeder) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	hs := &messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: string(s.mi.Metadata.Hash[:]), PeerID: "-TT0100-000000000000"}
+	_, err = conn.Write(hs.Serialize())
+	assert.NoError(t, err)
+	var buf [messagesv1.HandshakeLength]byte
+	_, err = io.ReadFull(conn, buf[:])
+	assert.NoError(t, err)
+	for _, want := range []messagesv1.MessageType{messagesv1.BitfieldType, messagesv1.UnChokeType} {
+		msg, err := messagesv1.Identify(conn)
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, msg.Type)
+		}
+	}
+	return conn
+}
+
+// next returns the next message the seeder sent, or nil if none was sent shortly.
+func next(t *testing.T, conn net.Conn) *messagesv1.Message {
+	t.Helper()
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	msg, err := messagesv1.Identify(conn)
+	if err != nil {
+		assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+		return nil
+	}
+	return msg
+}
+
+// newSeeder starts a Seeder that is closed once the test finishes.
+func newSeeder(t *testing.T, mi *torrent.MetaInfoFile, data []byte, opts ...SeederOption) *Seeder {
+	t.Helper()
+
+	s, err := NewSeeder(mi, data, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSeeder(t *testing.T) {
+	data := make([]byte, 2*messagesv1.RequestSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	mi, err := NewTorrent("http://localhost/announce", messagesv1.RequestSize, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	request := func(conn net.Conn, index uint32) {
+		_, err := conn.Write((&messagesv1.Request{Index: index, Length: messagesv1.RequestSize}).Serialize())
+		assert.NoError(t, err)
+	}
+	piece := func(msg *messagesv1.Message) *messagesv1.Piece {
+		if !assert.NotNil(t, msg) || !assert.Equal(t, messagesv1.PieceType, msg.Type) {
+			return nil
+		}
+		p := new(messagesv1.Piece)
+		assert.NoError(t, p.Deserialize(msg.Payload))
+		return p
+	}
+
+	t.Run("serves blocks", func(t *testing.T) {
+		s := newSeeder(t, mi, data)
+		conn := connect(t, s)
+		request(conn, 1)
+		if p := piece(next(t, conn)); p != nil {
+			assert.Equal(t, data[messagesv1.RequestSize:], p.Block)
+		}
+		assert.Equal(t, []messagesv1.Request{{Index: 1, Length: messagesv1.RequestSize}}, s.Requests())
+	})
+
+	t.Run("corrupt blocks", func(t *testing.T) {
+		s := newSeeder(t, mi, data, WithCorruptBlocks(func(req messagesv1.Request) bool { return req.Index == 0 }))
+		conn := connect(t, s)
+		request(conn, 0)
+		request(conn, 1)
+		if p := piece(next(t, conn)); p != nil {
+			assert.NotEqual(t, data[:messagesv1.RequestSize], p.Block)
+		}
+		if p := piece(next(t, conn)); p != nil {
+			assert.Equal(t, data[messagesv1.RequestSize:], p.Block)
+		}
+	})
+
+	t.Run("choke after", func(t *testing.T) {
+		s := newSeeder(t, mi, data, WithChokeAfter(2))
+		conn := connect(t, s)
+		request(conn, 0)
+		piece(next(t, conn))
+		request(conn, 1)
+		if msg := next(t, conn); assert.NotNil(t, msg) {
+			assert.Equal(t, messagesv1.ChokeType, msg.Type)
+		}
+		assert.Nil(t, next(t, conn))
+	})
+
+	t.Run("stall after", func(t *testing.T) {
+		s := newSeeder(t, mi, data, WithStallAfter(1))
+		conn := connect(t, s)
+		request(conn, 0)
+		piece(next(t, conn))
+		request(conn, 1)
+		assert.Nil(t, next(t, conn))
+		assert.Len(t, s.Requests(), 2)
+
+		// the requests are counted per connection.
+		other := connect(t, s)
+		request(other, 1)
+		if p := piece(next(t, other)); p != nil {
+			assert.Equal(t, data[messagesv1.RequestSize:], p.Block)
+		}
+	})
+
+	t.Run("corrupt empty block", func(t *testing.T) {
+		s := newSeeder(t, mi, data, WithCorruptBlocks(func(messagesv1.Request) bool { return true }))
+		conn := connect(t, s)
+		_, err := conn.Write((&messagesv1.Request{Index: 0}).Serialize())
+		assert.NoError(t, err)
+		if msg := next(t, conn); assert.NotNil(t, msg) {
+			assert.Equal(t, messagesv1.PieceType, msg.Type)
+		}
+	})
+
+	t.Run("unknown info hash", func(t *testing.T) {
+		s := newSeeder(t, mi, data)
+		conn, err := net.Dial("tcp", s.Addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		hs := &messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: "01234567890123456789", PeerID: "-TT0100-000000000000"}
+		_, err = conn.Write(hs.Serialize())
+		assert.NoError(t, err)
+		_, err = conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
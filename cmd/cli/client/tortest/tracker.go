@@ -0,0 +1,147 @@
+// Package tortest provides an in-process tracker and seeder peers for
+// testing the client end-to-end, and integrations built on top of it,
+// without any network access.
+package tortest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+)
+
+// Announce is an announce received by a Tracker.
+type Announce struct {
+	InfoHash string
+	PeerID   string
+	Port     int64
+	// Event is empty for the regular announces.
+	Event                string
+	Uploaded, Downloaded int64
+	Left                 int64
+	// Corrupt is -1 if the announce did not report it.
+	Corrupt int64
+	Compact bool
+}
+
+// Tracker is an HTTP tracker that answers every announce with the
+// configured peers and records the announces it received.
+type Tracker struct {
+	// URL is the announce URL of the tracker.
+	URL string
+
+	srv *httptest.Server
+
+	l          sync.Mutex
+	peers      []string
+	nonCompact bool
+	interval   time.Duration
+	announces  []Announce
+}
+
+// TrackerOption configures a Tracker.
+type TrackerOption func(*Tracker)
+
+// WithPeers sets the host:port of the peers returned by the tracker.
+func WithPeers(addrs ...string) TrackerOption {
+	return func(tr *Tracker) {
+		tr.peers = addrs
+	}
+}
+
+// WithNonCompactPeers returns the peers as a list of dictionaries,
+// even if the client asked for the compact form.
+func WithNonCompactPeers() TrackerOption {
+	return func(tr *Tracker) {
+		tr.nonCompact = true
+	}
+}
+
+// WithInterval sets the announce interval returned, a minute by default.
+func WithInterval(d time.Duration) TrackerOption {
+	return func(tr *Tracker) {
+		tr.interval = d
+	}
+}
+
+// NewTracker starts a Tracker on a local port, which must be closed.
+func NewTracker(opts ...TrackerOption) *Tracker {
+	tr := &Tracker{interval: time.Minute}
+	for _, opt := range opts {
+		opt(tr)
+	}
+	tr.srv = httptest.NewServer(http.HandlerFunc(tr.serveAnnounce))
+	tr.URL = tr.srv.URL + "/announce"
+	return tr
+}
+
+// Close stops the tracker.
+func (tr *Tracker) Close() { tr.srv.Close() }
+
+// SetPeers replaces the peers returned by the following announces.
+func (tr *Tracker) SetPeers(addrs ...string) {
+	tr.l.Lock()
+	defer tr.l.Unlock()
+	tr.peers = addrs
+}
+
+// Announces returns the announces received so far, oldest first.
+func (tr *Tracker) Announces() []Announce {
+	tr.l.Lock()
+	defer tr.l.Unlock()
+	return append([]Announce(nil), tr.announces...)
+}
+
+func (tr *Tracker) serveAnnounce(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	integer := func(key string) int64 {
+		i, _ := strconv.ParseInt(q.Get(key), 10, 64)
+		return i
+	}
+	a := Announce{
+		InfoHash:   q.Get("info_hash"),
+		PeerID:     q.Get("peer_id"),
+		Port:       integer("port"),
+		Event:      q.Get("event"),
+		Uploaded:   integer("uploaded"),
+		Downloaded: integer("downloaded"),
+		Left:       integer("left"),
+		Corrupt:    -1,
+		Compact:    q.Get("compact") == "1",
+	}
+
+	if q.Has("corrupt") {
+		a.Corrupt = integer("corrupt")
+	}
+
+	tr.l.Lock()
+	tr.announces = append(tr.announces, a)
+	peers := tr.peers
+	compact := a.Compact && !tr.nonCompact
+	interval := int64(tr.interval / time.Second)
+	tr.l.Unlock()
+
+	resp := &tracker.Response{Interval: &interval}
+	for _, addr := range peers {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		p, err := strconv.ParseInt(port, 10, 64)
+		if err != nil {
+			continue
+		}
+		resp.Peers = append(resp.Peers, struct {
+			PeerID string
+			IP     string
+			Port   int64
+		}{IP: host, Port: p})
+	}
+	if err := tracker.EncodeResponse(rw, resp, compact); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+)
+
+type (
+	// TrackerServer is a minimal HTTP tracker, which serves the announce
+	// and scrape requests of private swarms, e.g. between own machines.
+	TrackerServer       = tracker.Server
+	TrackerServerOption = tracker.ServerOption
+)
+
+// NewTrackerServer returns a tracker without any swarms.
+func NewTrackerServer(opts ...TrackerServerOption) *TrackerServer {
+	return tracker.NewServer(opts...)
+}
+
+// TrackerWithInterval sets the interval at which the peers announce and
+// the minimum interval between their announces, 5 and 1 minute by default.
+func TrackerWithInterval(interval, minInterval time.Duration) TrackerServerOption {
+	return tracker.WithInterval(interval, minInterval)
+}
+
+// TrackerWithPeerExpiry drops the peers that did not announce for d.
+func TrackerWithPeerExpiry(d time.Duration) TrackerServerOption {
+	return tracker.WithPeerExpiry(d)
+}
+
+// TrackerWithAllowlist tracks only the torrents with the given binary info hashes.
+func TrackerWithAllowlist(infoHashes ...string) TrackerServerOption {
+	return tracker.WithAllowlist(infoHashes...)
+}
+
+// TrackerWithLogger logs the requests the tracker refused.
+func TrackerWithLogger(logger *slog.Logger) TrackerServerOption {
+	return tracker.WithServerLogger(logger)
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client/internal/tracker"
+	"github.com/Despire/tinytorrent/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_TrackerServer(t *testing.T) {
+	// the seeder unchokes the leecher only with its optimistic unchoke.
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	data := []byte("transferred through the built-in tracker")
+
+	srv := httptest.NewServer(NewTrackerServer(TrackerWithInterval(time.Second, time.Second), TrackerWithPeerExpiry(time.Minute)))
+	t.Cleanup(srv.Close)
+	announce := srv.URL + "/announce"
+
+	newTorrent := func() *torrent.MetaInfoFile {
+		mi, err := torrent.From(bytes.NewReader(bencodeTorrent(announce, data)))
+		assert.NoError(t, err)
+		return mi
+	}
+	mi := newTorrent()
+	h := hex.EncodeToString(mi.Metadata.Hash[:])
+
+	// the seeder starts with the only piece already downloaded.
+	seedDir := t.TempDir()
+	resume, err := json.Marshal(map[string][]byte{"bitfield": {0x80}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(seedDir, h), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(seedDir, h, "0.bin"), data, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(seedDir, h, "resume.json"), resume, 0o644))
+
+	seeder, err := New(WithLogger(logger), WithDownloadDir(seedDir), WithAction(Both), WithPort(0))
+	assert.NoError(t, err)
+	t.Cleanup(func() { seeder.Close(context.Background()) })
+	id, err := seeder.WorkOn(mi)
+	assert.NoError(t, err)
+
+	leecher, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { leecher.Close(context.Background()) })
+	_, err = leecher.WorkOn(newTorrent())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		st, err := leecher.Status(id)
+		return err == nil && st.State == StateSeeding
+	}, time.Minute, 50*time.Millisecond, "torrent was not downloaded from the seeder found through the tracker")
+
+	// the leecher announces the completed download before it stops.
+	assert.Eventually(t, func() bool {
+		s, err := tracker.Scrape(context.Background(), announce, id)
+		return err == nil && s.Complete == 1 && s.Incomplete == 0 && s.Downloaded == 1
+	}, 10*time.Second, 50*time.Millisecond)
+}
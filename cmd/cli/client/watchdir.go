@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// defaultWatchInterval is how often the watch directory is scanned,
+// unless overridden by WithWatchDir.
+const defaultWatchInterval = 5 * time.Second
+
+const (
+	// addedSuffix is appended to the torrent files of the watch
+	// directory that were added, or that were already tracked.
+	addedSuffix = ".added"
+	// invalidSuffix is appended to the torrent files of the watch
+	// directory that could not be added.
+	invalidSuffix = ".invalid"
+)
+
+// watchDir adds the .torrent files dropped into the watch directory
+// until the client is closed. The directory is polled, as the module
+// does not depend on file system notifications.
+func (p *Client) watchDir() {
+	defer p.wg.Done()
+
+	logger := p.logger.With(slog.String("watch_dir", p.watchPath))
+	// failed holds the modification time of files that could not be
+	// renamed, so that they are not added again until they change.
+	failed := make(map[string]time.Time)
+
+	ticker := time.NewTicker(p.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		p.scanWatchDir(logger, failed)
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Client) scanWatchDir(logger *slog.Logger, failed map[string]time.Time) {
+	entries, err := os.ReadDir(p.watchPath)
+	if err != nil {
+		logger.Error("failed to scan watch directory", slog.Any("err", err))
+		return
+	}
+
+	for _, e := range entries {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		if !e.Type().IsRegular() || !strings.EqualFold(filepath.Ext(e.Name()), ".torrent") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // removed since the scan.
+		}
+		path := filepath.Join(p.watchPath, e.Name())
+		if mtime, ok := failed[path]; ok && mtime.Equal(fi.ModTime()) {
+			continue
+		}
+		delete(failed, path)
+
+		suffix, ok := p.addWatched(logger, path, fi)
+		if !ok {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			logger.Error("failed to rename torrent file, ignoring it until it changes",
+				slog.String("file", e.Name()),
+				slog.Any("err", err),
+			)
+			failed[path] = fi.ModTime()
+		}
+	}
+}
+
+// addWatched adds the torrent file at path and returns the suffix the file
+// is renamed with. It reports false if the file should be retried later.
+func (p *Client) addWatched(logger *slog.Logger, path string, fi os.FileInfo) (string, bool) {
+	logger = logger.With(slog.String("file", filepath.Base(path)))
+
+	f, err := os.Open(path)
+	if err != nil {
+		logger.Error("failed to open torrent file", slog.Any("err", err))
+		return invalidSuffix, true
+	}
+	mi, err := torrent.From(f)
+	f.Close()
+	if err != nil {
+		// the file may still be written to, it is only given up on once
+		// it did not change for a whole interval.
+		if time.Since(fi.ModTime()) < p.watchInterval {
+			return "", false
+		}
+		logger.Error("invalid torrent file in watch directory", slog.Any("err", err))
+		return invalidSuffix, true
+	}
+
+	id, err := p.WorkOn(mi)
+	switch {
+	case errors.Is(err, ErrAlreadyTracked):
+		logger.Info("torrent file in watch directory is already tracked")
+		return addedSuffix, true
+	case errors.Is(err, ErrClosed):
+		// the file is valid, it is added again once the client restarts.
+		logger.Debug("client shut down while adding torrent from watch directory")
+		return "", false
+	case err != nil:
+		logger.Error("failed to add torrent from watch directory", slog.Any("err", err))
+		return invalidSuffix, true
+	}
+	logger.Info("added torrent from watch directory", slog.String("infoHash", hex.EncodeToString([]byte(id))))
+	return addedSuffix, true
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_WatchDir(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "d8:intervali60e5:peers0:e")
+	}))
+	t.Cleanup(tracker.Close)
+
+	watch := t.TempDir()
+	const interval = 10 * time.Millisecond
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(watch, name))
+		return err == nil
+	}
+
+	_, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithWatchDir(filepath.Join(watch, "missing"), interval))
+	assert.Error(t, err)
+
+	c, err := New(WithLogger(logger), WithDownloadDir(t.TempDir()), WithWatchDir(watch, interval))
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+
+	data := bencodeTorrent(tracker.URL, []byte("watched"))
+	assert.NoError(t, os.WriteFile(filepath.Join(watch, "a.torrent"), data, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(watch, "notes.txt"), []byte("ignored"), 0o644))
+	assert.Eventually(t, func() bool { return exists("a.torrent.added") }, 5*time.Second, interval)
+	assert.Len(t, c.Statuses(), 1)
+	assert.True(t, exists("notes.txt"))
+
+	// the same torrent again is not looped on.
+	assert.NoError(t, os.WriteFile(filepath.Join(watch, "b.torrent"), data, 0o644))
+	assert.Eventually(t, func() bool { return exists("b.torrent.added") }, 5*time.Second, interval)
+	assert.Len(t, c.Statuses(), 1)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(watch, "c.torrent"), []byte("not a torrent"), 0o644))
+	assert.Eventually(t, func() bool { return exists("c.torrent.invalid") }, 5*time.Second, interval)
+
+	assert.NoError(t, c.Close(context.Background()))
+	assert.NoError(t, os.WriteFile(filepath.Join(watch, "d.torrent"), bencodeTorrent(tracker.URL, []byte("late")), 0o644))
+	time.Sleep(10 * interval)
+	assert.True(t, exists("d.torrent"), "watcher stopped with the client")
+
+	// a torrent file added while the client shuts down is not invalid.
+	path := filepath.Join(watch, "d.torrent")
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	suffix, ok := c.addWatched(logger, path, fi)
+	assert.False(t, ok)
+	assert.Empty(t, suffix)
+}
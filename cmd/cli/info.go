@@ -0,0 +1,154 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// info prints the metadata of a torrent file, including the
+// magnet link to share it with.
+//
+// Usage: tinytorrent info <file.torrent>
+func info(out io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tinytorrent info <file.torrent>")
+	}
+	t, err := loadTorrent(args[0])
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "name:\t%s\n", t.Name())
+	fmt.Fprintf(tw, "size:\t%s (%d bytes)\n", formatBytes(t.BytesToDownload()), t.BytesToDownload())
+	fmt.Fprintf(tw, "pieces:\t%d of %s\n", t.NumPieces(), formatBytes(t.PieceLength))
+	fmt.Fprintf(tw, "info hash:\t%s\n", t.HexHash())
+	fmt.Fprintf(tw, "info hash (base32):\t%s\n", t.Base32Hash())
+	for _, tr := range t.Trackers() {
+		fmt.Fprintf(tw, "tracker:\t%s\n", client.RedactURL(tr))
+	}
+	for _, n := range t.Nodes {
+		fmt.Fprintf(tw, "dht node:\t%s\n", n)
+	}
+	if len(t.Nodes) > 0 && os.Getenv("TINY_DHT") == "" {
+		fmt.Fprintf(tw, "note:\tthe torrent expects the dht to find peers, enable it with TINY_DHT\n")
+	}
+	fmt.Fprintf(tw, "magnet:\t%s\n", client.MagnetLink(t))
+	return tw.Flush()
+}
+
+// show prints the metadata of a torrent file in full: its trackers by
+// tier, web seeds, the optional fields set by its author and the tree
+// of its files. Like info, it contacts neither trackers nor peers.
+//
+// Usage: tinytorrent show <file.torrent>
+func show(out io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tinytorrent show <file.torrent>")
+	}
+	t, err := loadTorrent(args[0])
+	if err != nil {
+		return err
+	}
+	d := t.Describe()
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "name:\t%s\n", d.Name)
+	fmt.Fprintf(tw, "info hash:\t%s\n", d.InfoHash)
+	fmt.Fprintf(tw, "info hash (base32):\t%s\n", d.InfoHashBase32)
+	fmt.Fprintf(tw, "piece length:\t%s (%d bytes)\n", formatBytes(d.PieceLength), d.PieceLength)
+	fmt.Fprintf(tw, "pieces:\t%d\n", d.Pieces)
+	fmt.Fprintf(tw, "size:\t%s (%d bytes)\n", formatBytes(d.Size), d.Size)
+	fmt.Fprintf(tw, "private:\t%t\n", d.Private)
+	if d.CreationDate != nil {
+		fmt.Fprintf(tw, "created:\t%s\n", formatCreationDate(*d.CreationDate, time.Now()))
+	}
+	if d.CreatedBy != "" {
+		fmt.Fprintf(tw, "created by:\t%s\n", d.CreatedBy)
+	}
+	if d.Comment != "" {
+		fmt.Fprintf(tw, "comment:\t%s\n", d.Comment)
+	}
+	if d.Encoding != "" {
+		fmt.Fprintf(tw, "encoding:\t%s\n", d.Encoding)
+	}
+	for i, tier := range d.Tiers {
+		for _, tr := range tier {
+			fmt.Fprintf(tw, "tier %d:\t%s\n", i+1, client.RedactURL(tr))
+		}
+	}
+	for _, w := range d.WebSeeds {
+		fmt.Fprintf(tw, "web seed:\t%s\n", client.RedactURL(w))
+	}
+	for _, n := range d.Nodes {
+		fmt.Fprintf(tw, "dht node:\t%s\n", n)
+	}
+	fmt.Fprintf(tw, "files:\t%d\n", len(slices.DeleteFunc(slices.Clone(d.Files), torrent.FileInfo.IsPadding)))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return writeFileTree(out, t.InfoMultiFile != nil, d.Name, d.Files)
+}
+
+// writeFileTree writes the files, sorted by their path, indented by
+// the directories they are in. The files of a multi-file torrent are
+// in the directory of its name. Padding files are not listed, as they
+// are not stored.
+func writeFileTree(out io.Writer, multiFile bool, name string, files []torrent.FileInfo) error {
+	type entry struct {
+		dirs []string
+		file torrent.FileInfo
+	}
+	var entries []entry
+	for _, f := range files {
+		if f.IsPadding() {
+			continue
+		}
+		dirs := strings.Split(filepath.ToSlash(f.Path), "/")
+		dirs = dirs[:len(dirs)-1]
+		if multiFile {
+			dirs = append([]string{name}, dirs...)
+		}
+		entries = append(entries, entry{dirs: dirs, file: f})
+	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return cmp.Or(slices.Compare(a.dirs, b.dirs), cmp.Compare(a.file.Path, b.file.Path))
+	})
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	var open []string
+	for _, e := range entries {
+		// the directories shared with the previous file are listed already.
+		shared := 0
+		for shared < min(len(open), len(e.dirs)) && open[shared] == e.dirs[shared] {
+			shared++
+		}
+		for i := shared; i < len(e.dirs); i++ {
+			fmt.Fprintf(tw, "  %s%s/\t\n", strings.Repeat("  ", i), e.dirs[i])
+		}
+		open = e.dirs
+		fmt.Fprintf(tw, "  %s%s\t%s\n", strings.Repeat("  ", len(e.dirs)), filepath.Base(e.file.Path), formatBytes(e.file.Length))
+	}
+	return tw.Flush()
+}
+
+// formatCreationDate formats the creation date of a torrent. Dates
+// before 1980 or more than a year after now are likely bogus, e.g. set
+// in milliseconds, and are shown as the raw seconds found in the file.
+func formatCreationDate(d, now time.Time) string {
+	if d.Before(time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)) || d.After(now.AddDate(1, 0, 0)) {
+		return fmt.Sprintf("%d (raw)", d.Unix())
+	}
+	return d.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,55 @@
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	assert.NoError(t, WriteFile(path, []byte("first"), 0o600))
+	assert.NoError(t, WriteFile(path, []byte("second"), 0o644))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), fi.Mode().Perm())
+
+	// the temporary files are removed.
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWrite_Failed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "peers.txt")
+	assert.NoError(t, WriteFile(path, []byte("10.0.0.1:6881\n"), 0o600))
+
+	errWrite := errors.New("write failed")
+	err := Write(path, 0o600, func(w io.Writer) error {
+		fmt.Fprintln(w, "10.0.0.2:6881")
+		return errWrite
+	})
+	assert.ErrorIs(t, err, errWrite)
+
+	// the previous content is kept.
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:6881\n", string(b))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, WriteFile(filepath.Join(dir, "missing", "peers.txt"), nil, 0o600))
+}
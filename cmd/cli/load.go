@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// fetchTimeout bounds the download of a torrent file passed by its URL.
+const fetchTimeout = 30 * time.Second
+
+// loadTorrent reads the torrent file at path, which is downloaded if
+// path is its http(s) URL.
+func loadTorrent(path string) (*torrent.MetaInfoFile, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return fetchTorrent(&http.Client{Timeout: fetchTimeout}, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open torrent file %q: %w", path, err)
+	}
+	defer file.Close()
+
+	t, err := torrent.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent file %q: %w", path, err)
+	}
+	return t, nil
+}
+
+// fetchTorrent downloads the torrent file at url with hc. It does not
+// go through the proxy of TINY_PROXY, so it is refused if connecting
+// without the proxy is, see TINY_PROXY_FAIL_CLOSED.
+func fetchTorrent(hc *http.Client, url string) (*torrent.MetaInfoFile, error) {
+	if os.Getenv("TINY_PROXY") != "" && os.Getenv("TINY_PROXY_FAIL_CLOSED") != "" {
+		return nil, errors.New("torrent urls are not fetched through the proxy, download the torrent file first")
+	}
+
+	resp, err := hc.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download torrent file %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download torrent file %q: %s", url, resp.Status)
+	}
+
+	t, err := torrent.Load(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent file %q: %w", url, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTorrent_URL(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("../../torrent/test_data")))
+	t.Cleanup(srv.Close)
+
+	mi, err := loadTorrent(srv.URL + "/debian.torrent")
+	assert.NoError(t, err)
+	assert.Equal(t, "e37b64d85cf4aa93e0ec4aee2b44735b7cb63967", mi.HexHash())
+
+	_, err = loadTorrent(srv.URL + "/missing.torrent")
+	assert.ErrorContains(t, err, "404")
+
+	// the url would be fetched without the proxy.
+	t.Setenv("TINY_PROXY", "socks5://127.0.0.1:1080")
+	t.Setenv("TINY_PROXY_FAIL_CLOSED", "1")
+	_, err = loadTorrent(srv.URL + "/debian.torrent")
+	assert.Error(t, err)
+}
@@ -2,16 +2,28 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Despire/tinytorrent/cmd/cli/client"
 	"github.com/Despire/tinytorrent/torrent"
 )
 
+// statusInterval is how often the status of the torrent is printed.
+const statusInterval = 30 * time.Second
+
+// shutdownTimeout bounds the time the client has to announce the
+// stopped event and persist the torrents once it is shut down.
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	opts := &slog.HandlerOptions{
 		AddSource: true,
@@ -31,18 +43,80 @@ func main() {
 
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, opts))
+	args, asJSON := boolFlag(os.Args[1:], "--json")
+	args, slots := boolFlag(args, "--slots")
 
-	if err := run(context.Background(), logger, os.Args[1:]); err != nil {
+	// the JSON documents on stdout must not be interleaved with logs.
+	logOut := os.Stdout
+	if asJSON {
+		logOut = os.Stderr
+	}
+	logger := slog.New(slog.NewTextHandler(logOut, opts))
+
+	if err := run(context.Background(), logger, args, asJSON, slots); err != nil {
 		logger.Error("stopping tinytorrent client due to encountered error while executing", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context, logger *slog.Logger, args []string) error {
+// boolFlag removes flag from args and reports whether it was set. The
+// --json flag prints the status of the torrents as one JSON document
+// per torrent instead of a table, --slots adds the pieces downloaded
+// in the slots of the torrent to its status, see writeStatus.
+func boolFlag(args []string, flag string) ([]string, bool) {
+	i := slices.Index(args, flag)
+	if i < 0 {
+		return args, false
+	}
+	return slices.Delete(slices.Clone(args), i, i+1), true
+}
+
+func run(ctx context.Context, logger *slog.Logger, args []string, asJSON, slots bool) error {
 	if len(args) < 1 {
 		return errors.New("no torrent file specified")
 	}
+	switch args[0] {
+	case "check":
+		return check(ctx, os.Stdout, args[1:])
+	case "info":
+		return info(os.Stdout, args[1:])
+	case "show":
+		return show(os.Stdout, args[1:])
+	case "serve-tracker":
+		return serveTracker(ctx, logger, args[1:])
+	case "export", "import":
+		// sessions are exported from and imported into a running client.
+		c, err := controlClientFromEnv()
+		if err != nil {
+			return err
+		}
+		if args[0] == "export" {
+			return exportSession(ctx, c, args[1:])
+		}
+		return importSession(ctx, os.Stdout, c, args[1:])
+	}
+	// add --paused only registers the torrent, so
+	// that a later run does not start it on its own.
+	var paused bool
+	if args[0] == "add" {
+		args = args[1:]
+		if len(args) > 0 && args[0] == "--paused" {
+			paused = true
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			return errors.New("usage: tinytorrent add [--paused] <file.torrent> [leech|both]")
+		}
+	}
+	// pick downloads the torrent with the healthiest swarm among the candidates.
+	var candidates []string
+	if args[0] == "pick" {
+		candidates = args[1:]
+		if len(candidates) < 1 {
+			return errors.New("usage: tinytorrent pick <file.torrent>...")
+		}
+		args = nil
+	}
 	action := "leech"
 	if len(args) == 2 {
 		switch args[1] {
@@ -54,18 +128,112 @@ func run(ctx context.Context, logger *slog.Logger, args []string) error {
 		}
 	}
 
-	file, err := os.OpenFile(args[0], os.O_RDONLY, 0)
-	if err != nil {
-		return fmt.Errorf("failed to open torrent file %q: %w", args[0], err)
+	var t *torrent.MetaInfoFile
+	if candidates == nil {
+		var err error
+		if t, err = loadTorrent(args[0]); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	t, err := torrent.From(file)
-	if err != nil {
-		return fmt.Errorf("failed to read torrent file %q: %w", args[0], err)
+	opts := []client.Option{client.WithLogger(logger), client.WithAction(client.Action(action))}
+	// the control API allows scripts to manage the torrents of the running client.
+	if addr := os.Getenv("TINY_CONTROL_ADDR"); addr != "" {
+		opts = append(opts, client.WithControlAPI(addr), client.WithControlAPIToken(os.Getenv("TINY_CONTROL_TOKEN")))
+		// the metrics are scraped from the control API, e.g. by Prometheus.
+		if os.Getenv("TINY_METRICS") != "" {
+			opts = append(opts, client.WithMetrics())
+		}
+	}
+	// torrent files dropped into the watch directory are added while the client runs.
+	if dir := os.Getenv("TINY_WATCH_DIR"); dir != "" {
+		opts = append(opts, client.WithWatchDir(dir, 0))
+	}
+
+	// all outbound connections go through the SOCKS5 proxy, without
+	// falling back to direct connections if TINY_PROXY_FAIL_CLOSED is set.
+	if proxy := os.Getenv("TINY_PROXY"); proxy != "" {
+		opts = append(opts, client.WithProxy(proxy))
+		if os.Getenv("TINY_PROXY_FAIL_CLOSED") != "" {
+			opts = append(opts, client.WithProxyFailClosed())
+		}
+	}
+
+	// the torrents of the previous run are added again, next to the one passed.
+	if os.Getenv("TINY_SESSION_RESTORE") != "" {
+		opts = append(opts, client.WithSessionRestore())
+	}
+
+	// torrents with credentials embedded in their announce URLs are refused.
+	if os.Getenv("TINY_REJECT_TRACKER_CREDENTIALS") != "" {
+		opts = append(opts, client.WithRejectTrackerCredentials())
+	}
+
+	// peers of torrents that are not private are looked up in the DHT too.
+	if os.Getenv("TINY_DHT") != "" {
+		var bootstrap []string
+		if nodes := os.Getenv("TINY_DHT_BOOTSTRAP"); nodes != "" {
+			bootstrap = strings.Split(nodes, ",")
+		}
+		opts = append(opts, client.WithDHT(bootstrap...))
+	}
+
+	// torrents added beyond the limit, e.g. through the watch directory, are queued.
+	if v := os.Getenv("TINY_MAX_ACTIVE_TORRENTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_MAX_ACTIVE_TORRENTS %q: %w", v, err)
+		}
+		opts = append(opts, client.WithMaxActiveTorrents(n))
+	}
+
+	// torrents whose estimated memory exceeds the budget are queued.
+	if v := os.Getenv("TINY_MEMORY_BUDGET"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_MEMORY_BUDGET %q: %w", v, err)
+		}
+		opts = append(opts, client.WithMemoryBudget(n))
+	}
+
+	// the port announced instead of the listen port, for NATs translating it.
+	if v := os.Getenv("TINY_ANNOUNCE_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_ANNOUNCE_PORT %q: %w", v, err)
+		}
+		opts = append(opts, client.WithAnnouncePort(port))
+	}
+
+	// one in every n of the debug lines of the blocks is logged.
+	if v := os.Getenv("TINY_LOG_SAMPLE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_LOG_SAMPLE %q: %w", v, err)
+		}
+		opts = append(opts, client.WithBlockLogSampling(n))
 	}
 
-	c, err := client.New(client.WithLogger(logger), client.WithAction(client.Action(action)))
+	// the number of peers the first announce of each torrent asks for.
+	if v := os.Getenv("TINY_NUMWANT"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_NUMWANT %q: %w", v, err)
+		}
+		opts = append(opts, client.WithNumWant(n))
+	}
+
+	// peers on the blocklist are neither contacted nor accepted.
+	if path := os.Getenv("TINY_BLOCKLIST"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open blocklist: %w", err)
+		}
+		defer f.Close()
+		opts = append(opts, client.WithBlocklist(f))
+	}
+
+	c, err := client.New(opts...)
 	if err != nil {
 		return fmt.Errorf("failed to initialize the client: %w", err)
 	}
@@ -73,24 +241,117 @@ func run(ctx context.Context, logger *slog.Logger, args []string) error {
 	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
 	defer stop()
 
+	if candidates != nil {
+		if t, err = pick(ctx, os.Stdout, c, candidates); err != nil {
+			return errors.Join(fmt.Errorf("failed to pick torrent: %w", err), closeClient(c))
+		}
+	}
+
+	if paused {
+		id, err := c.WorkOnWithOptions(t, client.WithStartPaused())
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed to add torrent: %w", err), closeClient(c))
+		}
+		logger.Info("torrent added paused", "id", fmt.Sprintf("%x", id))
+		return closeClient(c)
+	}
+
 	id, err := c.WorkOn(t)
+	if errors.Is(err, client.ErrAlreadyTracked) {
+		// restored from the previous run.
+		id, err = string(t.Metadata.Hash[:]), nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to start work on: %w", err)
 	}
+	// running the torrent explicitly resumes it, if it was added paused.
+	if p, _ := c.Paused(id); p {
+		logger.Info("resuming torrent that was added paused")
+		if err := c.Resume(id); err != nil {
+			return fmt.Errorf("failed to resume torrent: %w", err)
+		}
+	}
+
+	status := time.NewTicker(statusInterval)
+	defer status.Stop()
 
 	done := c.WaitFor(id)
+	var seeded <-chan error
 	for {
 		select {
+		case <-status.C:
+			var torrentSlots map[string][]client.SlotInfo
+			if slots {
+				s, err := c.Slots(id)
+				if err != nil {
+					logger.Error("failed to get slots", "error", err)
+				} else {
+					torrentSlots = map[string][]client.SlotInfo{hex.EncodeToString([]byte(id)): s}
+				}
+			}
+			if err := writeStatus(os.Stdout, c.Statuses(), torrentSlots, asJSON); err != nil {
+				logger.Error("failed to write status", "error", err)
+			}
+			if asJSON {
+				continue
+			}
+			if st, err := c.TrackerStatus(id); err == nil {
+				fmt.Fprintf(os.Stdout, "tracker: %s\n", st)
+			}
+			if st, err := c.Status(id); err == nil && st.ListenPort != st.AnnouncePort {
+				fmt.Fprintf(os.Stdout, "ports: listening on %d, announcing %d\n", st.ListenPort, st.AnnouncePort)
+			}
+			if st, err := c.DownloadStats(id); err == nil {
+				fmt.Fprintf(os.Stdout, "download: %d B/s, verified %d pieces/s (%s), flushed %d B/s (%s)\n",
+					st.Rate, st.PiecesVerified, st.VerifyLatency, st.BytesFlushed, st.FlushLatency)
+			}
+			if st, err := c.TransferStats(id); err == nil {
+				eta := "unknown"
+				if st.ETA != client.UnknownETA {
+					eta = st.ETA.String()
+				}
+				fmt.Fprintf(os.Stdout, "transfer: down %d B/s, up %d B/s, %d B left, eta %s\n",
+					st.DownloadRate, st.UploadRate, st.Remaining, eta)
+			}
+			if st, err := c.WasteStats(id); err == nil && st.Total() > 0 {
+				fmt.Fprintf(os.Stdout, "wasted: %d B (hash failed %d B, flush failed %d B, banned peers %d B, shutdown %d B, duplicate %d B)\n",
+					st.Total(), st.HashFailed, st.FlushFailed, st.PeerBanned, st.Shutdown, st.Duplicate)
+			}
+			if st, err := c.SourceStats(id); err == nil && st.Total() > 0 {
+				fmt.Fprintf(os.Stdout, "sources: %s\n", st)
+			}
+			if st, err := c.ShareStats(id); err == nil {
+				fmt.Fprintf(os.Stdout, "share: ratio %.2f (up %d B, down %d B), this run %.2f (up %d B, down %d B)\n",
+					st.Ratio(), st.Uploaded, st.Downloaded, st.SessionRatio(), st.SessionUploaded, st.SessionDownloaded)
+			}
 		case <-ctx.Done():
 			logger.Warn("interrupt signal received")
-			return c.Close()
-		case err, _ := <-done:
+			return closeClient(c)
+		case err := <-done:
+			done = nil
 			if err != nil {
-				if err := c.Close(); err != nil {
+				if err := closeClient(c); err != nil {
 					logger.Error("failed to close client", "error", err)
 				}
 				return fmt.Errorf("failed to wait for work on torrent %s to finish: %w", id, err)
 			}
+			if action == client.Leech {
+				logger.Info("torrent downloaded")
+				return closeClient(c)
+			}
+			logger.Info("torrent downloaded, seeding until interrupted")
+			seeded = c.WaitForSeeding(id)
+		case err := <-seeded:
+			if err != nil {
+				logger.Error("failed to wait for seeding goals", "error", err)
+			}
+			return closeClient(c)
 		}
 	}
 }
+
+func closeClient(c *client.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return c.Close(ctx)
+}
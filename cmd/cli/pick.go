@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+// evaluateTimeout bounds the time the trackers of the
+// candidates have to report the size of their swarms.
+const evaluateTimeout = 30 * time.Second
+
+// pick evaluates the swarms of the torrent files at paths, writes their
+// health to out and returns the torrent with the healthiest swarm.
+func pick(ctx context.Context, out io.Writer, c *client.Client, paths []string) (*torrent.MetaInfoFile, error) {
+	var candidates []*torrent.MetaInfoFile
+	for _, path := range paths {
+		t, err := loadTorrent(path)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, t)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evaluateTimeout)
+	defer cancel()
+
+	swarms, err := c.EvaluateTorrents(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSwarms(out, paths, swarms); err != nil {
+		return nil, err
+	}
+
+	best := client.BestSwarm(swarms)
+	if !swarms[best].Reachable() {
+		return nil, errors.New("none of the trackers of the torrents are reachable")
+	}
+	fmt.Fprintf(out, "picked %s\n", paths[best])
+	return swarms[best].Torrent, nil
+}
+
+// writeSwarms writes the health of the swarm of each
+// torrent file at paths, followed by its trackers.
+func writeSwarms(w io.Writer, paths []string, swarms []client.SwarmHealth) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TORRENT\tSEEDERS\tLEECHERS\tTRACKERS")
+	for i, s := range swarms {
+		// skipped trackers are neither reachable nor failed.
+		var reachable, supported int
+		for _, t := range s.Trackers {
+			if t.Reachable {
+				reachable++
+			}
+			if !t.Skipped {
+				supported++
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d/%d reachable\n", paths[i], s.Seeders, s.Leechers, reachable, supported)
+		for _, t := range s.Trackers {
+			if t.Skipped {
+				fmt.Fprintf(tw, "  %s\t\t\tskipped: unsupported scheme\n", t.URL)
+				continue
+			}
+			if t.Err != nil {
+				fmt.Fprintf(tw, "  %s\t\t\terror: %v\n", t.URL, t.Err)
+				continue
+			}
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t\n", t.URL, t.Seeders, t.Leechers)
+		}
+	}
+	return tw.Flush()
+}
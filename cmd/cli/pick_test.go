@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSwarms(t *testing.T) {
+	swarms := []client.SwarmHealth{
+		{Seeders: 4, Leechers: 2, Trackers: []client.TrackerHealth{
+			{URL: "http://a/announce", Reachable: true, Seeders: 4, Leechers: 2},
+			{URL: "http://b/announce", Err: errors.New("connection refused")},
+			{URL: "wss://c/announce", Skipped: true},
+		}},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeSwarms(&out, []string{"a.torrent"}, swarms))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, []string{"a.torrent", "4", "2", "1/2", "reachable"}, strings.Fields(lines[1]))
+		assert.Equal(t, []string{"http://a/announce", "4", "2"}, strings.Fields(lines[2]))
+		assert.Contains(t, lines[3], "error: connection refused")
+		assert.Contains(t, lines[4], "skipped: unsupported scheme")
+	}
+}
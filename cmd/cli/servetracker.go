@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+)
+
+// serveTracker runs the built-in HTTP tracker until interrupted. The
+// torrents tracked can be limited by TINY_TRACKER_ALLOWLIST, a comma
+// separated list of hex encoded info hashes.
+//
+// Usage: tinytorrent serve-tracker <addr>
+func serveTracker(ctx context.Context, logger *slog.Logger, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tinytorrent serve-tracker <addr>")
+	}
+
+	opts := []client.TrackerServerOption{client.TrackerWithLogger(logger)}
+	if v := os.Getenv("TINY_TRACKER_ALLOWLIST"); v != "" {
+		hashes, err := parseAllowlist(v)
+		if err != nil {
+			return fmt.Errorf("invalid TINY_TRACKER_ALLOWLIST: %w", err)
+		}
+		opts = append(opts, client.TrackerWithAllowlist(hashes...))
+	}
+
+	ln, err := net.Listen("tcp", args[0])
+	if err != nil {
+		return fmt.Errorf("failed to listen for the tracker: %w", err)
+	}
+	srv := &http.Server{Handler: client.NewTrackerServer(opts...)}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	logger.Info("serving tracker", slog.String("announce", fmt.Sprintf("http://%s/announce", ln.Addr())))
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to serve the tracker: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// parseAllowlist decodes the comma separated hex encoded info hashes.
+func parseAllowlist(v string) ([]string, error) {
+	var hashes []string
+	for _, h := range strings.Split(v, ",") {
+		h = strings.TrimSpace(h)
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("info hash %q is not 40 hex characters", h)
+		}
+		hashes = append(hashes, string(b))
+	}
+	return hashes, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	hash := strings.Repeat("ab", 20)
+	tests := []struct {
+		name    string
+		v       string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single", v: hash, want: []string{strings.Repeat("\xab", 20)}},
+		{name: "spaces", v: hash + ", " + strings.ToUpper(hash), want: []string{strings.Repeat("\xab", 20), strings.Repeat("\xab", 20)}},
+		{name: "not hex", v: strings.Repeat("zz", 20), wantErr: true},
+		{name: "short", v: "abcd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAllowlist(tt.v)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/cmd/cli/internal/atomicfile"
+)
+
+// controlClient calls the control API of a running client.
+type controlClient struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// controlClientFromEnv returns a client for the control API configured
+// by the TINY_CONTROL_ADDR and TINY_CONTROL_TOKEN environment variables.
+func controlClientFromEnv() (*controlClient, error) {
+	addr := os.Getenv("TINY_CONTROL_ADDR")
+	if addr == "" {
+		return nil, errors.New("TINY_CONTROL_ADDR must be set to the control API of the running client")
+	}
+	return &controlClient{addr: addr, token: os.Getenv("TINY_CONTROL_TOKEN"), http: http.DefaultClient}, nil
+}
+
+// do sends the request and returns the response if it succeeded,
+// otherwise the error reported by the control API.
+func (c *controlClient) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call control API: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return nil, fmt.Errorf("control API responded with %s", resp.Status)
+	}
+	return nil, fmt.Errorf("control API responded with %s: %s", resp.Status, e.Error)
+}
+
+// exportSession writes the torrents of the running client to a tar.gz
+// archive, which is only created once it was written completely.
+//
+// Usage: tinytorrent export <archive>
+func exportSession(ctx context.Context, c *controlClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tinytorrent export <archive>")
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/session", nil)
+	if err != nil {
+		return fmt.Errorf("failed to export session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	err = atomicfile.Write(args[0], 0o600, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// importSession adds the torrents of an archive written by export to the
+// running client, with their data expected within the data root. An
+// interrupted import is completed by running it again.
+//
+// Usage: tinytorrent import <archive> <data-root>
+func importSession(ctx context.Context, out io.Writer, c *controlClient, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tinytorrent import <archive> <data-root>")
+	}
+
+	archive, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open archive %q: %w", args[0], err)
+	}
+	defer archive.Close()
+
+	// the running client resolves the path relative to its own directory.
+	dataRoot, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid data root %q: %w", args[1], err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/session?dataRoot="+url.QueryEscape(dataRoot), archive)
+	if err != nil {
+		return fmt.Errorf("failed to import session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var imported []client.ImportedTorrent
+	if err := json.NewDecoder(resp.Body).Decode(&imported); err != nil {
+		return fmt.Errorf("failed to decode imported torrents: %w", err)
+	}
+	return writeImported(out, imported)
+}
+
+// writeImported writes the outcome of an import as a table.
+func writeImported(w io.Writer, imported []client.ImportedTorrent) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tINFO HASH\tPIECES\tRECHECKED\tINVALID")
+	for _, it := range imported {
+		if it.Skipped {
+			fmt.Fprintf(tw, "%s\t%s\tskipped, already tracked\n", it.Name, it.InfoHash)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", it.Name, it.InfoHash, it.Pieces, it.Rechecked, it.Invalid)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_ExportImport(t *testing.T) {
+	const token = "secret"
+	archive := []byte("archive")
+	dataRoot := t.TempDir()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /session":
+			rw.Write(archive)
+		case "POST /session":
+			b, _ := io.ReadAll(r.Body)
+			if !bytes.Equal(b, archive) || r.URL.Query().Get("dataRoot") != dataRoot {
+				rw.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(rw).Encode(map[string]string{"error": "unexpected import"})
+				return
+			}
+			json.NewEncoder(rw).Encode([]client.ImportedTorrent{
+				{InfoHash: "aa", Name: "a.iso", RestoreStats: client.RestoreStats{Pieces: 4, Rechecked: 1}},
+				{InfoHash: "bb", Name: "b.iso", Skipped: true},
+			})
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c := &controlClient{addr: strings.TrimPrefix(srv.URL, "http://"), token: token, http: srv.Client()}
+	path := filepath.Join(t.TempDir(), "session.tar.gz")
+
+	assert.NoError(t, exportSession(context.Background(), c, []string{path}))
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+
+	var out bytes.Buffer
+	assert.NoError(t, importSession(context.Background(), &out, c, []string{path, dataRoot}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, []string{"a.iso", "aa", "4", "1", "0"}, strings.Fields(lines[1]))
+		assert.Contains(t, lines[2], "skipped")
+	}
+
+	err = importSession(context.Background(), &out, c, []string{path, t.TempDir()})
+	assert.ErrorContains(t, err, "unexpected import")
+
+	c.token = "wrong"
+	assert.Error(t, exportSession(context.Background(), c, []string{path + ".other"}))
+	_, err = os.Stat(path + ".other")
+	assert.ErrorIs(t, err, os.ErrNotExist, "failed export must not leave an archive")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+)
+
+// progressWidth is the number of characters of the progress bar.
+const progressWidth = 20
+
+// statusDocument is the JSON document written per torrent, see writeStatus.
+type statusDocument struct {
+	client.TorrentStatus
+	// Slots are the pieces the torrent downloads in its slots, if
+	// requested with --slots and there are any.
+	Slots []client.SlotInfo `json:"slots,omitempty"`
+}
+
+// writeStatus writes the status of the torrents either as one JSON
+// document per line, or as a table for humans. The slots of the torrents,
+// keyed by their hex encoded info hash, are included in the documents or
+// written as a table below the status, see Client.Slots.
+func writeStatus(w io.Writer, statuses []client.TorrentStatus, slots map[string][]client.SlotInfo, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, s := range statuses {
+			if err := enc.Encode(statusDocument{TorrentStatus: s, Slots: slots[s.InfoHash]}); err != nil {
+				return fmt.Errorf("failed to encode status of %s: %w", s.InfoHash, err)
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tPROGRESS\tDOWN\tUP\tPEERS\tRATIO\tSTATE")
+	for _, s := range statuses {
+		state := string(s.State)
+		if s.Error != "" {
+			state += ": " + s.Error
+		}
+		if s.QueueReason != "" {
+			state += ": " + s.QueueReason
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s/s\t%s/s\t%d/%d\t%.2f\t%s\n",
+			s.Name, formatBytes(s.Size), progressBar(s.Progress()),
+			formatBytes(s.DownloadRate), formatBytes(s.UploadRate),
+			s.Seeders, s.Leechers, s.Ratio, state,
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if torrentSlots, ok := slots[s.InfoHash]; ok {
+			if err := writeSlots(w, torrentSlots); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeSlots writes the pieces a torrent downloads in its slots as a
+// table for humans.
+func writeSlots(w io.Writer, slots []client.SlotInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PIECE\tATTEMPT\tDOWNLOADED\tPENDING\tIN FLIGHT\tRECEIVED\tOLDEST\tPEERS")
+	for _, s := range slots {
+		oldest := "-"
+		if s.InFlight > 0 {
+			oldest = s.OldestInFlight.Round(time.Millisecond).String()
+		}
+		peers := strings.Join(s.Peers, ",")
+		if peers == "" {
+			peers = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s/%s\t%d\t%d\t%d\t%s\t%s\n",
+			s.Piece, s.Attempt, formatBytes(s.Downloaded), formatBytes(s.Size),
+			s.Pending, s.InFlight, s.Received, oldest, peers,
+		)
+	}
+	return tw.Flush()
+}
+
+// progressBar renders the fraction p as a bar followed by the percentage.
+func progressBar(p float64) string {
+	p = min(max(p, 0), 1)
+	done := int(p * progressWidth)
+	return fmt.Sprintf("[%s%s] %5.1f%%", strings.Repeat("#", done), strings.Repeat(".", progressWidth-done), p*100)
+}
+
+// formatBytes formats n bytes with a decimal unit, as the transfer
+// rates are logged with.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
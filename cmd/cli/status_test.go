@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		p    float64
+		want string
+	}{
+		{p: 0, want: "[....................]   0.0%"},
+		{p: 0.5, want: "[##########..........]  50.0%"},
+		{p: 1, want: "[####################] 100.0%"},
+		{p: 1.5, want: "[####################] 100.0%"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, progressBar(tt.p))
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5kB", formatBytes(1536))
+	assert.Equal(t, "2.1GB", formatBytes(2<<30))
+}
+
+func TestWriteStatus(t *testing.T) {
+	statuses := []client.TorrentStatus{
+		{Version: client.StatusVersion, InfoHash: "aa", Name: "a.iso", Size: 2048, Downloaded: 1024, State: client.StateDownloading, Seeders: 3, Ratio: 1.5},
+		{Version: client.StatusVersion, InfoHash: "bb", Name: "b", Size: 10, Downloaded: 10, State: client.StateError, Error: "disk corruption"},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeStatus(&out, statuses, nil, true))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2, "one document per torrent")
+	for i, l := range lines {
+		var got client.TorrentStatus
+		assert.NoError(t, json.Unmarshal([]byte(l), &got))
+		assert.Equal(t, statuses[i], got)
+		assert.NotContains(t, l, `"slots"`)
+	}
+
+	out.Reset()
+	assert.NoError(t, writeStatus(&out, statuses, nil, false))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "NAME"))
+	assert.Contains(t, lines[1], "[##########..........]  50.0%")
+	assert.Contains(t, lines[1], "3/0")
+	assert.Contains(t, lines[1], "1.50")
+	assert.Contains(t, lines[2], "error: disk corruption")
+}
+
+func TestWriteStatus_Slots(t *testing.T) {
+	statuses := []client.TorrentStatus{
+		{Version: client.StatusVersion, InfoHash: "aa", Name: "a.iso", Size: 65536, State: client.StateDownloading},
+		{Version: client.StatusVersion, InfoHash: "bb", Name: "b", Size: 10, Downloaded: 10, State: client.StateSeeding},
+	}
+	slots := map[string][]client.SlotInfo{"aa": {
+		{Piece: 0, Attempt: 1, Size: 65536, Pending: 4, Peers: []string{}},
+		{
+			Piece: 7, Attempt: 3, Size: 65536, Downloaded: 16384,
+			Pending: 1, InFlight: 2, Received: 1, OldestInFlight: 45*time.Second + 123456789,
+			Peers: []string{"10.0.0.1:6881", "10.0.0.2:6881"},
+		},
+	}}
+
+	// the slots are nested in the document of their torrent.
+	var out bytes.Buffer
+	assert.NoError(t, writeStatus(&out, statuses, slots, true))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2, "one document per torrent")
+	for i, l := range lines {
+		var got statusDocument
+		assert.NoError(t, json.Unmarshal([]byte(l), &got))
+		assert.Equal(t, statuses[i], got.TorrentStatus)
+		assert.Equal(t, slots[statuses[i].InfoHash], got.Slots)
+	}
+
+	out.Reset()
+	assert.NoError(t, writeStatus(&out, statuses, slots, false))
+	lines = strings.Split(out.String(), "\n")
+	assert.Len(t, lines, 7)
+	assert.Equal(t, []string{
+		"PIECE  ATTEMPT  DOWNLOADED     PENDING  IN FLIGHT  RECEIVED  OLDEST   PEERS",
+		"0      1        0B/65.5kB      4        0          0         -        -",
+		"7      3        16.4kB/65.5kB  1        2          1         45.123s  10.0.0.1:6881,10.0.0.2:6881",
+		"",
+	}, lines[3:])
+}
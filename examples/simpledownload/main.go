@@ -0,0 +1,95 @@
+// Command simpledownload is a minimal program embedding the client: it
+// downloads a single torrent, printing its progress, and exits once it
+// completed.
+//
+//	go run ./examples/simpledownload <file.torrent> [download dir]
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Despire/tinytorrent/cmd/cli/client"
+	"github.com/Despire/tinytorrent/torrent"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "simpledownload:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: simpledownload <file.torrent> [download dir]")
+	}
+	dir := "."
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open torrent: %w", err)
+	}
+	mi, err := torrent.From(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to parse torrent: %w", err)
+	}
+
+	// the client logs are only of interest when something goes wrong.
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	c, err := client.New(client.WithLogger(logger), client.WithDownloadDir(dir))
+	if err != nil {
+		return fmt.Errorf("failed to initialize the client: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		c.Close(ctx)
+	}()
+
+	id, err := c.WorkOn(mi)
+	if err != nil {
+		return fmt.Errorf("failed to add torrent: %w", err)
+	}
+	if err := c.Subscribe(id, func(e client.Event) {
+		// handlers are called synchronously and must not block.
+		switch e := e.(type) {
+		case client.PieceHashFailed:
+			fmt.Printf("piece %d failed verification, downloading it again\n", e.Piece)
+		case client.TorrentCompleted:
+			fmt.Printf("completed in %s: %v\n", e.Elapsed.Round(time.Second), e.Paths)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	done := c.WaitFor(id)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s, err := c.Status(id)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d/%d bytes, %d B/s from %d seeders\n", s.Name, s.Downloaded, s.Size, s.DownloadRate, s.Seeders)
+		}
+	}
+}
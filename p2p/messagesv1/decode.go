@@ -25,6 +25,15 @@ const (
 	PortType
 )
 
+// Messages of the Fast Extension, see BEP6.
+const (
+	SuggestPieceType MessageType = iota + 0x0D
+	HaveAllType
+	HaveNoneType
+	RejectRequestType
+	AllowedFastType
+)
+
 type Message struct {
 	Type    MessageType
 	Payload []byte
@@ -67,9 +76,10 @@ func Identify(reader io.Reader) (*Message, error) {
 	}
 
 	switch typ := MessageType(messageID[0]); typ {
-	case ChokeType, UnChokeType, InterestType, NotInterestType:
+	case ChokeType, UnChokeType, InterestType, NotInterestType, HaveAllType, HaveNoneType:
 		return &Message{Type: typ}, nil
-	case HaveType, BitfieldType, RequestType, PieceType, CancelType, PortType:
+	case HaveType, BitfieldType, RequestType, PieceType, CancelType, PortType,
+		SuggestPieceType, RejectRequestType, AllowedFastType:
 		return &Message{Type: typ, Payload: payload}, nil
 	default:
 		return nil, fmt.Errorf("unknown message id: %v", messageID[0])
@@ -0,0 +1,114 @@
+package messagesv1
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The Fast Extension, see BEP6, is negotiated by both
+// peers setting this bit of the reserved handshake bytes.
+const (
+	fastExtensionByte = 7
+	fastExtensionBit  = 0x04
+)
+
+// SetFastExtension advertises support for the Fast Extension.
+func (h *Handshake) SetFastExtension() { h.Reserved[fastExtensionByte] |= fastExtensionBit }
+
+// FastExtension reports whether the peer advertised support for the Fast Extension.
+func (h *Handshake) FastExtension() bool {
+	return h.Reserved[fastExtensionByte]&fastExtensionBit != 0
+}
+
+// SuggestPiece hints that the peer can serve the piece at Index quickly, e.g. from its cache.
+type SuggestPiece struct {
+	Index uint32
+}
+
+func (s *SuggestPiece) Serialize() []byte { return serializeIndex(SuggestPieceType, s.Index) }
+
+func (s *SuggestPiece) Deserialize(b []byte) error { return deserializeIndex(b, &s.Index) }
+
+// AllowedFast allows requesting blocks of the piece at Index while the peer chokes this client.
+type AllowedFast struct {
+	Index uint32
+}
+
+func (a *AllowedFast) Serialize() []byte { return serializeIndex(AllowedFastType, a.Index) }
+
+func (a *AllowedFast) Deserialize(b []byte) error { return deserializeIndex(b, &a.Index) }
+
+// HaveAll replaces the bitfield of a peer that has all pieces.
+type HaveAll struct{}
+
+func (HaveAll) Serialize() []byte {
+	var msg [5]byte
+
+	binary.BigEndian.PutUint32(msg[:4], 1)
+	msg[4] = byte(HaveAllType)
+
+	return msg[:]
+}
+
+// HaveNone replaces the bitfield of a peer that has no pieces.
+type HaveNone struct{}
+
+func (HaveNone) Serialize() []byte {
+	var msg [5]byte
+
+	binary.BigEndian.PutUint32(msg[:4], 1)
+	msg[4] = byte(HaveNoneType)
+
+	return msg[:]
+}
+
+// RejectRequest tells that the peer will not answer the request.
+type RejectRequest struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+func (r *RejectRequest) Serialize() []byte {
+	// Length (4) | id (1) | index (4) | begin (4) | length (4)
+	var msg [4 + 1 + 4 + 4 + 4]byte
+
+	binary.BigEndian.PutUint32(msg[:4], 1+4+4+4)
+	msg[4] = byte(RejectRequestType)
+	binary.BigEndian.PutUint32(msg[5:9], r.Index)
+	binary.BigEndian.PutUint32(msg[9:13], r.Begin)
+	binary.BigEndian.PutUint32(msg[13:17], r.Length)
+
+	return msg[:]
+}
+
+func (r *RejectRequest) Deserialize(data []byte) error {
+	if len(data) != 12 {
+		return errors.New("wrong length")
+	}
+
+	r.Index = binary.BigEndian.Uint32(data[:4])
+	r.Begin = binary.BigEndian.Uint32(data[4:8])
+	r.Length = binary.BigEndian.Uint32(data[8:12])
+
+	return nil
+}
+
+func serializeIndex(typ MessageType, index uint32) []byte {
+	var msg [4 + 1 + 4]byte // 4 for the length, 1 for id, 4 for index
+
+	binary.BigEndian.PutUint32(msg[:4], 1+4)
+	msg[4] = byte(typ)
+	binary.BigEndian.PutUint32(msg[5:], index)
+
+	return msg[:]
+}
+
+func deserializeIndex(b []byte, index *uint32) error {
+	if len(b) != 4 {
+		return errors.New("invalid payload length")
+	}
+
+	*index = binary.BigEndian.Uint32(b)
+	return nil
+}
@@ -0,0 +1,53 @@
+package messagesv1
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake_FastExtension(t *testing.T) {
+	var h Handshake
+	assert.False(t, h.FastExtension())
+	h.SetFastExtension()
+	assert.True(t, h.FastExtension())
+	assert.Equal(t, [8]byte{7: 0x04}, h.Reserved)
+}
+
+func TestFastMessages(t *testing.T) {
+	tests := []struct {
+		msg     []byte
+		typ     MessageType
+		payload []byte
+	}{
+		{msg: (&SuggestPiece{Index: 7}).Serialize(), typ: SuggestPieceType, payload: []byte{0, 0, 0, 7}},
+		{msg: (&AllowedFast{Index: 258}).Serialize(), typ: AllowedFastType, payload: []byte{0, 0, 1, 2}},
+		{msg: HaveAll{}.Serialize(), typ: HaveAllType},
+		{msg: HaveNone{}.Serialize(), typ: HaveNoneType},
+		{
+			msg:     (&RejectRequest{Index: 1, Begin: 2, Length: 3}).Serialize(),
+			typ:     RejectRequestType,
+			payload: []byte{0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			m, err := Identify(bytes.NewReader(tt.msg))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.typ, m.Type)
+			assert.Equal(t, tt.payload, m.Payload)
+		})
+	}
+
+	var a AllowedFast
+	assert.NoError(t, a.Deserialize([]byte{0, 0, 1, 2}))
+	assert.Equal(t, int64(258), a.PieceIndex())
+	assert.Error(t, a.Deserialize([]byte{1}))
+
+	var r RejectRequest
+	assert.NoError(t, r.Deserialize([]byte{0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}))
+	assert.Equal(t, RejectRequest{Index: 1, Begin: 2, Length: 3}, r)
+
+	assert.Equal(t, "MessageType(12)", MessageType(12).String())
+}
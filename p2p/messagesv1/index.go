@@ -0,0 +1,49 @@
+package messagesv1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Piece indexes are int64 within the client, same as the piece
+// offsets computed from them, and uint32 only on the wire. The
+// conversions below are the only places the two meet.
+
+// ErrIndexOutOfRange is returned for piece indexes that
+// cannot be represented in a message.
+var ErrIndexOutOfRange = errors.New("piece index out of range")
+
+// WireIndex converts the piece index idx to its wire representation.
+func WireIndex(idx int64) (uint32, error) {
+	if idx < 0 || idx > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: %d", ErrIndexOutOfRange, idx)
+	}
+	return uint32(idx), nil
+}
+
+// NewRequest returns a request for length bytes at begin of the piece at index.
+func NewRequest(index int64, begin, length uint32) (*Request, error) {
+	i, err := WireIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Index: i, Begin: begin, Length: length}, nil
+}
+
+// NewHave returns a have message for the piece at index.
+func NewHave(index int64) (*Have, error) {
+	i, err := WireIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Have{Index: i}, nil
+}
+
+func (r *Request) PieceIndex() int64       { return int64(r.Index) }
+func (c *Cancel) PieceIndex() int64        { return int64(c.Index) }
+func (p *Piece) PieceIndex() int64         { return int64(p.Index) }
+func (h *Have) PieceIndex() int64          { return int64(h.Index) }
+func (s *SuggestPiece) PieceIndex() int64  { return int64(s.Index) }
+func (a *AllowedFast) PieceIndex() int64   { return int64(a.Index) }
+func (r *RejectRequest) PieceIndex() int64 { return int64(r.Index) }
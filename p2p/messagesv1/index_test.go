@@ -0,0 +1,49 @@
+package messagesv1
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireIndex(t *testing.T) {
+	tests := []struct {
+		idx     int64
+		want    uint32
+		wantErr bool
+	}{
+		{idx: 0, want: 0},
+		{idx: 1234, want: 1234},
+		{idx: math.MaxUint32, want: math.MaxUint32},
+		{idx: math.MaxUint32 + 1, wantErr: true},
+		{idx: -1, wantErr: true},
+		{idx: math.MaxInt64, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := WireIndex(tt.idx)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrIndexOutOfRange, "index %d", tt.idx)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	r, err := NewRequest(math.MaxUint32, 16, RequestSize)
+	assert.NoError(t, err)
+	assert.Equal(t, &Request{Index: math.MaxUint32, Begin: 16, Length: RequestSize}, r)
+	assert.Equal(t, int64(math.MaxUint32), r.PieceIndex())
+
+	// the index would silently wrap around to 0 if truncated.
+	_, err = NewRequest(1<<32, 0, RequestSize)
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+
+	h, err := NewHave(7)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), h.PieceIndex())
+	_, err = NewHave(-1)
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+}
@@ -19,16 +19,32 @@ func _() {
 	_ = x[PieceType-7]
 	_ = x[CancelType-8]
 	_ = x[PortType-9]
+	_ = x[SuggestPieceType-13]
+	_ = x[HaveAllType-14]
+	_ = x[HaveNoneType-15]
+	_ = x[RejectRequestType-16]
+	_ = x[AllowedFastType-17]
 }
 
-const _MessageType_name = "KeepAliveTypeChokeTypeUnChokeTypeInterestTypeNotInterestTypeHaveTypeBitfieldTypeRequestTypePieceTypeCancelTypePortType"
+const (
+	_MessageType_name_0 = "KeepAliveTypeChokeTypeUnChokeTypeInterestTypeNotInterestTypeHaveTypeBitfieldTypeRequestTypePieceTypeCancelTypePortType"
+	_MessageType_name_1 = "SuggestPieceTypeHaveAllTypeHaveNoneTypeRejectRequestTypeAllowedFastType"
+)
 
-var _MessageType_index = [...]uint8{0, 13, 22, 33, 45, 60, 68, 80, 91, 100, 110, 118}
+var (
+	_MessageType_index_0 = [...]uint8{0, 13, 22, 33, 45, 60, 68, 80, 91, 100, 110, 118}
+	_MessageType_index_1 = [...]uint8{0, 16, 27, 39, 56, 71}
+)
 
 func (i MessageType) String() string {
-	i -= -1
-	if i < 0 || i >= MessageType(len(_MessageType_index)-1) {
-		return "MessageType(" + strconv.FormatInt(int64(i+-1), 10) + ")"
+	switch {
+	case -1 <= i && i <= 9:
+		i -= -1
+		return _MessageType_name_0[_MessageType_index_0[i]:_MessageType_index_0[i+1]]
+	case 13 <= i && i <= 17:
+		i -= 13
+		return _MessageType_name_1[_MessageType_index_1[i]:_MessageType_index_1[i+1]]
+	default:
+		return "MessageType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _MessageType_name[_MessageType_index[i]:_MessageType_index[i+1]]
 }
@@ -12,14 +12,17 @@ type Piece struct {
 }
 
 func (p *Piece) Serialize() []byte {
+	return p.AppendSerialize(make([]byte, 0, 4+1+4+4+len(p.Block)))
+}
+
+// AppendSerialize appends the serialized message to b, so that its buffer can be reused.
+func (p *Piece) AppendSerialize(b []byte) []byte {
 	// Length (4) | id (1) | index (4) | begin (4) | block variable.
-	msg := make([]byte, 4+1+4+4+len(p.Block))
-	binary.BigEndian.PutUint32(msg[:4], uint32(1+4+4+len(p.Block)))
-	msg[4] = byte(PieceType)
-	binary.BigEndian.PutUint32(msg[5:9], p.Index)
-	binary.BigEndian.PutUint32(msg[9:13], p.Begin)
-	copy(msg[13:], p.Block)
-	return msg
+	b = binary.BigEndian.AppendUint32(b, uint32(1+4+4+len(p.Block)))
+	b = append(b, byte(PieceType))
+	b = binary.BigEndian.AppendUint32(b, p.Index)
+	b = binary.BigEndian.AppendUint32(b, p.Begin)
+	return append(b, p.Block...)
 }
 
 func (p *Piece) Deserialize(msg []byte) error {
@@ -21,38 +21,38 @@ func NewBitfield(numPieces int64) *BitField {
 		numPieces: numPieces,
 	}
 }
-func (b *BitField) MissingPieces() []uint32 {
-	var pieces []uint32
+func (b *BitField) MissingPieces() []int64 {
+	var pieces []int64
 
 	b.l.Lock()
 	defer b.l.Unlock()
 
 	for i := int64(0); i < b.numPieces; i++ {
-		o := b.byteOffset(uint32(i))
+		o := b.byteOffset(i)
 		_ = b.b[o] // bounds check
-		piece := b.bitOffset(uint32(i))
+		piece := b.bitOffset(i)
 		shift := ((1 << 3) - 1) - piece
 		if (b.b[o] & (1 << shift)) == 0 {
-			pieces = append(pieces, uint32(i))
+			pieces = append(pieces, i)
 		}
 	}
 
 	return pieces
 }
 
-func (b *BitField) ExistingPieces() []uint32 {
-	var pieces []uint32
+func (b *BitField) ExistingPieces() []int64 {
+	var pieces []int64
 
 	b.l.Lock()
 	defer b.l.Unlock()
 
 	for i := int64(0); i < b.numPieces; i++ {
-		o := b.byteOffset(uint32(i))
+		o := b.byteOffset(i)
 		_ = b.b[o] // bounds check
-		piece := b.bitOffset(uint32(i))
+		piece := b.bitOffset(i)
 		shift := ((1 << 3) - 1) - piece
 		if (b.b[o] & (1 << shift)) != 0 {
-			pieces = append(pieces, uint32(i))
+			pieces = append(pieces, i)
 		}
 	}
 
@@ -79,21 +79,24 @@ func (b *BitField) Overwrite(other []byte) {
 	b.b = other
 }
 
+// NumPieces returns the number of pieces of the torrent.
+func (b *BitField) NumPieces() int64 { return b.numPieces }
+
 func (b *BitField) Len() int {
 	b.l.Lock()
 	defer b.l.Unlock()
 	return len(b.b)
 }
 
-func (b *BitField) SetWithCheck(idx uint32) error {
+func (b *BitField) SetWithCheck(idx int64) error {
 	b.l.Lock()
 	defer b.l.Unlock()
 
-	if int64(idx) >= b.numPieces {
+	if idx < 0 || idx >= b.numPieces {
 		return errors.New("trying to set bits that do not belong to the torrent")
 	}
 
-	if field := b.byteOffset(idx); int(field) > len(b.b)-1 {
+	if field := b.byteOffset(idx); field > int64(len(b.b))-1 {
 		return fmt.Errorf("%v is out of range for bitfield", field)
 	}
 
@@ -105,7 +108,7 @@ func (b *BitField) SetWithCheck(idx uint32) error {
 	return nil
 }
 
-func (b *BitField) Set(idx uint32) {
+func (b *BitField) Set(idx int64) {
 	b.l.Lock()
 	defer b.l.Unlock()
 
@@ -116,10 +119,16 @@ func (b *BitField) Set(idx uint32) {
 	b.b[o] |= 1 << shift
 }
 
-func (b *BitField) Check(idx uint32) bool {
+// Check reports whether the piece at idx is set, indexes
+// that do not belong to the torrent are never set.
+func (b *BitField) Check(idx int64) bool {
 	b.l.Lock()
 	defer b.l.Unlock()
 
+	if idx < 0 || idx >= b.numPieces {
+		return false
+	}
+
 	o := b.byteOffset(idx)
 	_ = b.b[o] // bounds check
 	piece := b.bitOffset(idx)
@@ -127,5 +136,5 @@ func (b *BitField) Check(idx uint32) bool {
 	return (b.b[o] & (1 << shift)) != 0
 }
 
-func (b *BitField) byteOffset(idx uint32) uint32 { return idx / (1 << 3) }
-func (b *BitField) bitOffset(idx uint32) uint32  { return idx % (1 << 3) }
+func (b *BitField) byteOffset(idx int64) int64 { return idx / (1 << 3) }
+func (b *BitField) bitOffset(idx int64) int64  { return idx % (1 << 3) }
@@ -9,7 +9,7 @@ import (
 
 func TestBitField_Set(t *testing.T) {
 	type args struct {
-		idx uint32
+		idx int64
 	}
 	tests := []struct {
 		name     string
@@ -61,7 +61,7 @@ func TestBitField_Set(t *testing.T) {
 
 func TestBitField_SetWithCheck(t *testing.T) {
 	type args struct {
-		idx uint32
+		idx int64
 	}
 	tests := []struct {
 		name     string
@@ -75,6 +75,18 @@ func TestBitField_SetWithCheck(t *testing.T) {
 			args:     args{9},
 			wantErr:  func(t assert.TestingT, err error, i ...interface{}) bool { return assert.NotNil(t, err) },
 		},
+		{
+			name:     "err-set-negative",
+			bitfield: NewBitfield(9),
+			args:     args{-1},
+			wantErr:  func(t assert.TestingT, err error, i ...interface{}) bool { return assert.NotNil(t, err) },
+		},
+		{
+			name:     "err-set-beyond-wire-range",
+			bitfield: NewBitfield(9),
+			args:     args{1 << 32},
+			wantErr:  func(t assert.TestingT, err error, i ...interface{}) bool { return assert.NotNil(t, err) },
+		},
 		{
 			name:     "ok-set-overflow",
 			bitfield: NewBitfield(9),
@@ -90,26 +102,39 @@ func TestBitField_SetWithCheck(t *testing.T) {
 	}
 }
 
+func TestBitField_Check(t *testing.T) {
+	b := NewBitfield(9)
+	b.Set(8)
+
+	assert.True(t, b.Check(8))
+	assert.False(t, b.Check(0))
+	// indexes outside of the torrent are never set, even if
+	// they fall into the padding bits of the last byte.
+	assert.False(t, b.Check(9))
+	assert.False(t, b.Check(-1))
+	assert.False(t, b.Check(8+1<<32))
+}
+
 func TestBitField_MissingPieces(t *testing.T) {
 	tests := []struct {
 		name string
 		b    *BitField
-		want []uint32
+		want []int64
 	}{
 		{
 			name: "ok-overflow",
 			b:    NewBitfield(1),
-			want: []uint32{0},
+			want: []int64{0},
 		},
 		{
 			name: "ok-not-overflow",
 			b:    NewBitfield(8),
-			want: []uint32{0, 1, 2, 3, 4, 5, 6, 7},
+			want: []int64{0, 1, 2, 3, 4, 5, 6, 7},
 		},
 		{
 			name: "ok-overflow-2",
 			b:    NewBitfield(9),
-			want: []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8},
+			want: []int64{0, 1, 2, 3, 4, 5, 6, 7, 8},
 		},
 	}
 
@@ -123,7 +148,7 @@ func TestBitField_MissingPieces(t *testing.T) {
 func Test_ExistingPieces(t *testing.T) {
 	t.Parallel()
 
-	pieces := []uint32{
+	pieces := []int64{
 		9,
 		837,
 		586,
@@ -6,10 +6,12 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
 	"time"
 
 	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer/bitfield"
 )
 
 // KeepAliveTimeout represents the maximum timeout for recieving a
@@ -43,6 +45,18 @@ func (p *Peer) listener() {
 		p.logger.Debug("received message type", slog.String("type", msg.Type.String()))
 		if err := p.process(msg); err != nil {
 			p.logger.Error("failed to process message", slog.String("type", msg.Type.String()), slog.Any("err", err))
+			if errors.Is(err, ErrProtocolViolation) {
+				if err := p.conn.Close(); err != nil {
+					p.logger.Debug("failed to close connection", slog.Any("err", err))
+				}
+				break
+			}
+		}
+	}
+
+	if p.onPieces != nil {
+		if pieces := p.Bitfield.ExistingPieces(); len(pieces) > 0 {
+			p.onPieces(pieces, -1)
 		}
 	}
 
@@ -52,6 +66,7 @@ func (p *Peer) listener() {
 		close(p.leecher.cancels)
 	case seeder:
 		close(p.seeder.pieces)
+		close(p.seeder.done)
 	}
 	p.wg.Done()
 	p.connectionStatus.Store(uint32(ConnectionKilled))
@@ -59,15 +74,40 @@ func (p *Peer) listener() {
 }
 
 func (p *Peer) process(msg *messagesv1.Message) error {
+	switch msg.Type {
+	case messagesv1.KeepAliveType:
+	case messagesv1.BitfieldType, messagesv1.HaveAllType, messagesv1.HaveNoneType:
+		// the pieces of the peer are announced only before any other message.
+		if p.messages {
+			return fmt.Errorf("%w: %s after other messages", ErrProtocolViolation, msg.Type)
+		}
+		p.messages = true
+	default:
+		p.messages = true
+	}
+
 	switch msg.Type {
 	case messagesv1.KeepAliveType:
 		// do nothing.
 		return nil
 	case messagesv1.ChokeType: // receive choked from remote peer.
+		p.choke.Lock()
+		n := p.chokeCount.Add(1)
 		p.Status.Remote.Store(uint32(Choked))
+		p.choke.Unlock()
+		if p.typ == seeder {
+			select {
+			case <-p.seeder.chokes: // previous choke was not consumed yet.
+			default:
+			}
+			// only the listener sends, the drained slot is free.
+			p.seeder.chokes <- n
+		}
 		return nil
 	case messagesv1.UnChokeType: // receive unchoke from remote peer.
+		p.choke.Lock()
 		p.Status.Remote.Store(uint32(UnChoked))
+		p.choke.Unlock()
 		return nil
 	case messagesv1.InterestType: // recieve interest from remote peer.
 		p.Interest.Remote.Store(uint32(Interested))
@@ -80,9 +120,13 @@ func (p *Peer) process(msg *messagesv1.Message) error {
 		if err := h.Deserialize(msg.Payload); err != nil {
 			return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
 		}
-		if err := p.Bitfield.SetWithCheck(h.Index); err != nil {
+		had := p.Bitfield.Check(h.PieceIndex())
+		if err := p.Bitfield.SetWithCheck(h.PieceIndex()); err != nil {
 			return fmt.Errorf("could not acknowledge piece %v: %w", h.Index, err)
 		}
+		if !had && p.onPieces != nil {
+			p.onPieces([]int64{h.PieceIndex()}, 1)
+		}
 		p.logger.Debug("updated bitfield based on have message")
 		return nil
 	case messagesv1.BitfieldType: // peer send what pieces he possesses.
@@ -94,7 +138,7 @@ func (p *Peer) process(msg *messagesv1.Message) error {
 		if len(b.Bitfield) != p.Bitfield.Len() {
 			return errors.New("received incorrect bit-flied length")
 		}
-		p.Bitfield.Overwrite(b.Bitfield)
+		p.overwriteBitfield(b.Bitfield)
 		p.logger.Debug("updated bitfield based on bitfield message")
 		return nil
 	case messagesv1.PieceType: // peer send a piece
@@ -108,8 +152,78 @@ func (p *Peer) process(msg *messagesv1.Message) error {
 			return nil
 		}
 		return fmt.Errorf("received piece message on leecher connection")
-	case messagesv1.PortType: // peer requested DHT extension.
-		return fmt.Errorf("dht is not supported")
+	case messagesv1.HaveAllType, messagesv1.HaveNoneType: // peer has all or none of the pieces.
+		if !p.fast {
+			return fmt.Errorf("received %s without the fast extension", msg.Type)
+		}
+		b := bitfield.NewBitfield(p.Bitfield.NumPieces())
+		if msg.Type == messagesv1.HaveAllType {
+			for i := range p.Bitfield.NumPieces() {
+				b.Set(i)
+			}
+		}
+		p.overwriteBitfield(b.Clone())
+		p.logger.Debug("updated bitfield based on message", slog.String("type", msg.Type.String()))
+		return nil
+	case messagesv1.SuggestPieceType, messagesv1.AllowedFastType: // peer hinted pieces to request.
+		if !p.fast {
+			return fmt.Errorf("received %s without the fast extension", msg.Type)
+		}
+		var (
+			index uint32
+			set   = &p.hints.suggested
+		)
+		if msg.Type == messagesv1.AllowedFastType {
+			a := new(messagesv1.AllowedFast)
+			if err := a.Deserialize(msg.Payload); err != nil {
+				return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
+			}
+			index, set = a.Index, &p.hints.allowed
+		} else {
+			s := new(messagesv1.SuggestPiece)
+			if err := s.Deserialize(msg.Payload); err != nil {
+				return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
+			}
+			index = s.Index
+		}
+		if int64(index) >= p.Bitfield.NumPieces() {
+			// hints are optional, a bogus one is dropped.
+			p.logger.Debug("ignoring hint for piece out of range",
+				slog.String("type", msg.Type.String()),
+				slog.Int64("piece", int64(index)),
+			)
+			return nil
+		}
+		set.add(int64(index))
+		if msg.Type == messagesv1.SuggestPieceType && p.onSuggest != nil {
+			p.onSuggest(int64(index))
+		}
+		return nil
+	case messagesv1.RejectRequestType:
+		if !p.fast {
+			return fmt.Errorf("received %s without the fast extension", msg.Type)
+		}
+		r := new(messagesv1.RejectRequest)
+		if err := r.Deserialize(msg.Payload); err != nil {
+			return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
+		}
+		// the request is scheduled again once it times out.
+		p.logger.Debug("peer rejected request", slog.String("req", fmt.Sprintf("%#v", r)))
+		return nil
+	case messagesv1.PortType: // peer advertised the port of its DHT node.
+		port := new(messagesv1.Port)
+		if err := port.Deserialize(msg.Payload); err != nil {
+			return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
+		}
+		if p.onDHTNode == nil || port.Port == 0 {
+			return nil
+		}
+		remote, err := netip.ParseAddrPort(p.conn.RemoteAddr().String())
+		if err != nil {
+			return fmt.Errorf("could not parse address of peer: %w", err)
+		}
+		p.onDHTNode(netip.AddrPortFrom(remote.Addr().Unmap(), port.Port))
+		return nil
 	case messagesv1.RequestType: //  peer send a request
 		if p.typ == leecher {
 			req := new(messagesv1.Request)
@@ -118,10 +232,10 @@ func (p *Peer) process(msg *messagesv1.Message) error {
 				return fmt.Errorf("could not deserialize message %s: %w", msg.Type, err)
 			}
 			if p.Status.This.Load() == uint32(Choked) {
-				return fmt.Errorf("dropped request as peer is choked")
+				return p.reject(req, "dropped request as peer is choked")
 			}
 			if p.Interest.Remote.Load() == uint32(NotInterested) {
-				return fmt.Errorf("dropped request a peer is not interested")
+				return p.reject(req, "dropped request a peer is not interested")
 			}
 
 			p.leecher.requests <- req
@@ -151,3 +265,44 @@ func (p *Peer) process(msg *messagesv1.Message) error {
 		return fmt.Errorf("no implementation for processing message type: %s", msg.Type)
 	}
 }
+
+// overwriteBitfield replaces the pieces of the peer with b and
+// passes the pieces gained and lost to the pieces handler, if set.
+func (p *Peer) overwriteBitfield(b []byte) {
+	if p.onPieces == nil {
+		p.Bitfield.Overwrite(b)
+		return
+	}
+	old := p.Bitfield.Clone()
+	p.Bitfield.Overwrite(b)
+
+	var gained, lost []int64
+	for i := range p.Bitfield.NumPieces() {
+		mask := byte(1) << (7 - i%8)
+		was, is := old[i/8]&mask != 0, b[i/8]&mask != 0
+		switch {
+		case is && !was:
+			gained = append(gained, i)
+		case was && !is:
+			lost = append(lost, i)
+		}
+	}
+	if len(gained) > 0 {
+		p.onPieces(gained, 1)
+	}
+	if len(lost) > 0 {
+		p.onPieces(lost, -1)
+	}
+}
+
+// reject answers the request dropped for reason with REJECT REQUEST, if
+// the Fast Extension was negotiated, which requires answering every request.
+func (p *Peer) reject(req *messagesv1.Request, reason string) error {
+	if p.fast {
+		r := &messagesv1.RejectRequest{Index: req.Index, Begin: req.Begin, Length: req.Length}
+		if err := p.writeMessage("reject request", r.Serialize()); err != nil {
+			return fmt.Errorf("%s, failed to reject it: %w", reason, err)
+		}
+	}
+	return errors.New(reason)
+}
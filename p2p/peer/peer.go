@@ -2,10 +2,14 @@ package peer
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -56,6 +60,85 @@ const (
 	ConnectionKilled
 )
 
+var (
+	// ErrChoked is returned when sending a request to a peer that choked this client.
+	ErrChoked = errors.New("peer choked this client")
+	// ErrDial is returned when the connection to a peer could not be established.
+	ErrDial = errors.New("failed to dial peer")
+	// ErrHandshake is returned when a peer accepted the connection but the handshake failed.
+	ErrHandshake = errors.New("handshake with peer failed")
+	// ErrSelfConnection is returned by NewSeederConnection when the peer
+	// answered the handshake with the peer id of this client, i.e. the
+	// address dialed is one of this client. As any failed handshake, it
+	// is wrapped in ErrHandshake.
+	ErrSelfConnection = errors.New("connected to this client")
+	// ErrWriteTimeout is returned when a peer did not accept a message in
+	// time, e.g. as its receive window stays closed. The peer is closed.
+	ErrWriteTimeout = errors.New("peer did not accept message in time")
+	// ErrQueueFull is returned when queueing a request to a peer whose
+	// queue of requests waiting to be written is full, e.g. as it stopped
+	// reading from the connection. The peer should be treated as busy.
+	ErrQueueFull = errors.New("request queue of peer is full")
+	// ErrProtocolViolation is returned when a peer sent a message the
+	// protocol forbids at that point, e.g. a bitfield after other
+	// messages. The peer is closed.
+	ErrProtocolViolation = errors.New("peer violated the protocol")
+)
+
+// DialFunc establishes the connection to a peer at addr.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialTimeout bounds establishing the connection to a seeder.
+const dialTimeout = 10 * time.Second
+
+// defaultWriteTimeout bounds writing a message, see WithWriteTimeout.
+const defaultWriteTimeout = 15 * time.Second
+
+// Option configures a Peer.
+type Option func(p *Peer)
+
+// WithDialer sets the function the connection to a seeder is established
+// with, e.g. to resolve host names through a cache. Defaults to a net.Dialer.
+func WithDialer(dial DialFunc) Option {
+	return func(p *Peer) { p.dial = dial }
+}
+
+// WithWriteTimeout sets the time after which a message the peer did not
+// accept is considered failed and the peer dead, extended for larger
+// messages by the time the peer needs for them at its rate.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(p *Peer) { p.writeTimeout = d }
+}
+
+// WithDHTNodeHandler calls handle with the address of the DHT node of
+// the remote peer, once it advertised its DHT port with a PORT message.
+func WithDHTNodeHandler(handle func(node netip.AddrPort)) Option {
+	return func(p *Peer) { p.onDHTNode = handle }
+}
+
+// WithSuggestHandler calls handle with the pieces the remote peer suggests
+// requesting with SUGGEST PIECE messages, e.g. as it holds them in its cache.
+// It is called from the goroutine reading the messages.
+func WithSuggestHandler(handle func(piece int64)) Option {
+	return func(p *Peer) { p.onSuggest = handle }
+}
+
+// WithFastExtension negotiates the Fast Extension, see BEP6, on a
+// connection accepted from a leecher whose handshake announced support
+// for it. Connections to seeders negotiate it on their own.
+func WithFastExtension() Option {
+	return func(p *Peer) { p.fast = true }
+}
+
+// WithPiecesHandler calls handle with the pieces the remote peer gained,
+// with a delta of 1, or no longer has, with a delta of -1, as announced by
+// its HAVE and BITFIELD messages. Once the connection is closed all pieces
+// of the peer are passed with a delta of -1, so that the counts of handle
+// stay balanced. It is called from the goroutine reading the messages.
+func WithPiecesHandler(handle func(pieces []int64, delta int)) Option {
+	return func(p *Peer) { p.onPieces = handle }
+}
+
 type peerType byte
 
 const (
@@ -73,19 +156,53 @@ type Peer struct {
 	conn             net.Conn
 	connectionStatus atomic.Uint32
 	typ              peerType
+	dial             DialFunc
+	onDHTNode        func(node netip.AddrPort)
+	onPieces         func(pieces []int64, delta int)
+	onSuggest        func(piece int64)
+	// writeTimeout bounds writing a message, extended for
+	// larger messages by the rate the peer accepts data at.
+	writeTimeout time.Duration
+	writeRate    writeRate
 
 	Status struct {
 		Remote atomic.Uint32
 		This   atomic.Uint32
 	}
 
+	// choke serializes the transitions of the remote status with
+	// sending requests, so that no request is sent once the remote
+	// peer choked this client.
+	choke sync.Mutex
+	// chokeCount counts the chokes received from the remote peer.
+	chokeCount atomic.Uint64
+
 	Interest struct {
 		Remote atomic.Uint32
 		This   atomic.Uint32
 	}
 
+	// messages is set once the peer sent a message other than a
+	// keep-alive, after which the pieces it has are only updated
+	// by HAVE messages. Only accessed by the listener goroutine.
+	messages bool
+
+	// fast is set if both peers support the Fast Extension, see BEP6.
+	// Connections to leechers only negotiate it if WithFastExtension is passed.
+	fast bool
+	// hints are the pieces the remote peer allowed to request while it
+	// chokes this client, and the ones it suggested to request.
+	hints struct {
+		allowed, suggested pieceSet
+	}
+
 	seeder struct {
 		pieces chan *messagesv1.Piece
+		chokes chan uint64
+		// requests are queued by QueueRequest and written by requestWriter.
+		requests chan *messagesv1.Request
+		// done is closed once the listener exits.
+		done chan struct{}
 	}
 
 	leecher struct {
@@ -96,20 +213,29 @@ type Peer struct {
 	Bitfield *bitfield.BitField
 }
 
+// NewSeederConnection dials the seeder at addr and performs the v1
+// handshake. Failed dials are wrapped in ErrDial, failed handshakes,
+// including ErrSelfConnection, in ErrHandshake.
 func NewSeederConnection(
 	logger *slog.Logger,
 	addr string,
 	numPieces int64,
 	infoHash string,
 	clientId string,
+	opts ...Option,
 ) (*Peer, error) {
 	p := &Peer{
-		logger:   logger,
-		Addr:     addr,
-		conn:     nil,
-		wg:       sync.WaitGroup{},
-		Bitfield: bitfield.NewBitfield(numPieces),
-		typ:      seeder,
+		logger:       logger,
+		Addr:         addr,
+		conn:         nil,
+		wg:           sync.WaitGroup{},
+		Bitfield:     bitfield.NewBitfield(numPieces),
+		typ:          seeder,
+		writeTimeout: defaultWriteTimeout,
+		dial:         (&net.Dialer{}).DialContext,
+	}
+	for _, o := range opts {
+		o(p)
 	}
 
 	p.Status.Remote.Store(uint32(Choked))
@@ -119,18 +245,24 @@ func NewSeederConnection(
 	p.Interest.This.Store(uint32(NotInterested))
 
 	if err := p.initiateHandshakeV1(infoHash, clientId); err != nil {
-		if p.conn != nil {
-			if errClose := p.conn.Close(); errClose != nil {
-				return nil, fmt.Errorf("%w: %w", err, errClose)
-			}
+		if p.conn == nil {
+			return nil, fmt.Errorf("%w: %w", ErrDial, err)
+		}
+		err = fmt.Errorf("%w: %w", ErrHandshake, err)
+		if errClose := p.conn.Close(); errClose != nil {
+			return nil, fmt.Errorf("%w: %w", err, errClose)
 		}
 		return nil, err
 	}
 
 	p.seeder.pieces = make(chan *messagesv1.Piece)
+	p.seeder.chokes = make(chan uint64, 1)
+	p.seeder.requests = make(chan *messagesv1.Request, requestQueueSize)
+	p.seeder.done = make(chan struct{})
 
-	p.wg.Add(1)
+	p.wg.Add(2)
 	go p.listener()
+	go p.requestWriter()
 
 	p.connectionStatus.Store(uint32(ConnectionEstablished))
 
@@ -143,15 +275,20 @@ func NewLeecherConnection(
 	numPieces int64,
 	conn net.Conn,
 	infoHash, clientId string,
+	opts ...Option,
 ) (*Peer, error) {
 	p := &Peer{
-		logger:   logger.With(slog.String("peer_id", peerID)),
-		Id:       peerID,
-		Addr:     addr,
-		conn:     conn,
-		wg:       sync.WaitGroup{},
-		Bitfield: bitfield.NewBitfield(numPieces),
-		typ:      leecher,
+		logger:       logger.With(slog.String("peer_id", peerID)),
+		Id:           peerID,
+		Addr:         addr,
+		conn:         conn,
+		wg:           sync.WaitGroup{},
+		Bitfield:     bitfield.NewBitfield(numPieces),
+		typ:          leecher,
+		writeTimeout: defaultWriteTimeout,
+	}
+	for _, o := range opts {
+		o(p)
 	}
 
 	p.Status.Remote.Store(uint32(Choked))
@@ -198,7 +335,10 @@ func (p *Peer) Close() error {
 	}
 	var err error
 	if p.conn != nil {
-		err = p.conn.Close()
+		// closed already if the peer timed out.
+		if err = p.conn.Close(); errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
 	}
 	p.wg.Wait()
 	p.connectionStatus.Store(uint32(ConnectionKilled))
@@ -207,6 +347,55 @@ func (p *Peer) Close() error {
 
 func (p *Peer) Pieces() <-chan *messagesv1.Piece { return p.seeder.pieces }
 
+// FastExtension reports whether the Fast Extension was negotiated with the peer.
+func (p *Peer) FastExtension() bool { return p.fast }
+
+// AllowedFast reports whether blocks of the piece at idx can be requested
+// while the peer chokes this client, as it allowed and has the piece.
+func (p *Peer) AllowedFast(idx int64) bool {
+	// hints for pieces the peer does not have are ignored.
+	return p.hints.allowed.has(idx) && p.Bitfield.Check(idx)
+}
+
+// Suggested reports whether the peer suggested requesting the piece at idx, and has it.
+func (p *Peer) Suggested(idx int64) bool {
+	return p.hints.suggested.has(idx) && p.Bitfield.Check(idx)
+}
+
+// pieceSet is a set of piece indexes, safe for concurrent use.
+type pieceSet struct {
+	l sync.Mutex
+	m map[int64]struct{}
+}
+
+func (s *pieceSet) add(idx int64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.m == nil {
+		s.m = make(map[int64]struct{})
+	}
+	s.m[idx] = struct{}{}
+}
+
+func (s *pieceSet) has(idx int64) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, ok := s.m[idx]
+	return ok
+}
+
+// Chokes returns a channel that receives the number of times the remote
+// peer choked this client, after it did so. Multiple chokes that were not
+// consumed yet are signaled once, with the latest count. All requests sent
+// while ChokeCount returned less than the received count should be
+// considered discarded, the ones sent later were not affected.
+func (p *Peer) Chokes() <-chan uint64 { return p.seeder.chokes }
+
+// ChokeCount returns the number of times the remote peer choked this
+// client so far. It is read before sending a request, so that the request
+// can be matched against the chokes received later, see Chokes.
+func (p *Peer) ChokeCount() uint64 { return p.chokeCount.Load() }
+
 func (p *Peer) Requests() (<-chan *messagesv1.Request, <-chan *messagesv1.Cancel) {
 	return p.leecher.requests, p.leecher.cancels
 }
@@ -222,7 +411,9 @@ func (p *Peer) initiateHandshakeV1(infoHash, peerID string) error {
 		return nil
 	}
 
-	conn, err := net.DialTimeout("tcp", p.Addr, 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	conn, err := p.dial(ctx, "tcp", p.Addr)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to re-connect to peer at %s: %w", p.Addr, err)
 	}
@@ -235,6 +426,7 @@ func (p *Peer) initiateHandshakeV1(infoHash, peerID string) error {
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
+	h.SetFastExtension()
 
 	msg := h.Serialize()
 
@@ -268,9 +460,13 @@ func (p *Peer) initiateHandshakeV1(infoHash, peerID string) error {
 	if err := h.Deserialize(resp[:]); err != nil {
 		return fmt.Errorf("failed to deserialize v1 handshake message: %w", err)
 	}
+	if h.PeerID == peerID {
+		return ErrSelfConnection // wrapped in ErrHandshake by the caller.
+	}
 
 	// adjust peer information.
 	p.Id = h.PeerID
+	p.fast = h.FastExtension()
 	p.logger = p.logger.With(slog.String("peer_id", p.Id))
 
 	return nil
@@ -287,6 +483,9 @@ func (p *Peer) sendHandshakeV1(infoHash, peerID string) error {
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
+	if p.fast {
+		h.SetFastExtension()
+	}
 
 	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
 		return err
@@ -318,19 +517,10 @@ func (p *Peer) SendKeepAlive() error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("keepalive", new(messagesv1.KeepAlive).Serialize()); err != nil {
 		return err
 	}
 
-	msg := new(messagesv1.KeepAlive).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write keepalive message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of the keepalive message")
-	}
-
 	return nil
 }
 
@@ -346,18 +536,9 @@ func (p *Peer) SendUnchoke() error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("unchoke", new(messagesv1.Unchoke).Serialize()); err != nil {
 		return err
 	}
-
-	msg := new(messagesv1.Unchoke).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write unchoke message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of the unchoke message")
-	}
 	p.Status.This.Store(uint32(UnChoked))
 	return nil
 }
@@ -374,18 +555,9 @@ func (p *Peer) SendChoke() error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("choke", new(messagesv1.Choke).Serialize()); err != nil {
 		return err
 	}
-
-	msg := new(messagesv1.Choke).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write choke message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of the choke message")
-	}
 	p.Status.This.Store(uint32(Choked))
 	return nil
 }
@@ -402,18 +574,9 @@ func (p *Peer) SendInterested() error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("interest", new(messagesv1.Interest).Serialize()); err != nil {
 		return err
 	}
-
-	msg := new(messagesv1.Interest).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write interest message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of the interest message")
-	}
 	p.Interest.This.Store(uint32(Interested))
 	return nil
 }
@@ -429,22 +592,15 @@ func (p *Peer) SendNotInterested() error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("not-interest", new(messagesv1.NotInterest).Serialize()); err != nil {
 		return err
 	}
-
-	msg := new(messagesv1.NotInterest).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write not-interest message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of the not-interest message")
-	}
 	p.Interest.This.Store(uint32(NotInterested))
 	return nil
 }
 
+// SendBitfield announces the pieces set in b, which must be sent
+// right after the handshake. Nothing is sent if b is empty.
 func (p *Peer) SendBitfield(b []byte) error {
 	if p == nil {
 		return nil
@@ -457,17 +613,16 @@ func (p *Peer) SendBitfield(b []byte) error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
-		return err
-	}
-
-	msg := (&messagesv1.Bitfield{Bitfield: b}).Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write bitfield message: %w", err)
+	if !slices.ContainsFunc(b, func(x byte) bool { return x != 0 }) {
+		// a peer without pieces may skip the bitfield, unless the
+		// fast extension requires announcing that with HAVE NONE.
+		if !p.fast {
+			return nil
+		}
+		return p.writeMessage("have none", messagesv1.HaveNone{}.Serialize())
 	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of bitfield message")
+	if err := p.writeMessage("bitfield", (&messagesv1.Bitfield{Bitfield: b}).Serialize()); err != nil {
+		return err
 	}
 	return nil
 }
@@ -488,19 +643,54 @@ func (p *Peer) SendRequest(req *messagesv1.Request) error {
 		return fmt.Errorf("invalid request: %w", err)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	p.choke.Lock()
+	defer p.choke.Unlock()
+
+	if p.Status.Remote.Load() == uint32(Choked) && !p.AllowedFast(req.PieceIndex()) {
+		return ErrChoked
+	}
+
+	if err := p.writeMessage("request", req.Serialize()); err != nil {
 		return err
 	}
+	return nil
+}
 
-	msg := req.Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write request message: %w", err)
+// QueueRequest queues the request to be written to the peer by its writer
+// goroutine, without waiting for the network. It fails with ErrChoked if
+// the peer chokes this client, and with ErrQueueFull if too many requests
+// wait to be written already. Queued requests are dropped if the peer
+// chokes this client before they are written, as those sent are discarded.
+func (p *Peer) QueueRequest(req *messagesv1.Request) error {
+	if p == nil {
+		return nil
 	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of request message")
+
+	if p.connectionStatus.Load() != uint32(ConnectionEstablished) {
+		return fmt.Errorf("invalid connection status %s, needed %s",
+			ConnectionStatus(p.connectionStatus.Load()),
+			ConnectionEstablished,
+		)
+	}
+	if p.typ != seeder {
+		return errors.New("requests are only queued on seeder connections")
+	}
+
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	if p.Status.Remote.Load() == uint32(Choked) && !p.AllowedFast(req.PieceIndex()) {
+		return ErrChoked
+	}
+
+	queued := *req
+	select {
+	case p.seeder.requests <- &queued:
+		return nil
+	default:
+		return ErrQueueFull
 	}
-	return nil
 }
 
 func (p *Peer) SendCancel(cancel *messagesv1.Cancel) error {
@@ -519,18 +709,9 @@ func (p *Peer) SendCancel(cancel *messagesv1.Cancel) error {
 		return fmt.Errorf("invalid request: %w", err)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("cancel", cancel.Serialize()); err != nil {
 		return err
 	}
-
-	msg := cancel.Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write request message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of request message")
-	}
 	return nil
 }
 
@@ -546,17 +727,36 @@ func (p *Peer) SendHave(have *messagesv1.Have) error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
+	if err := p.writeMessage("have", have.Serialize()); err != nil {
 		return err
 	}
+	return nil
+}
 
-	msg := have.Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
+// SendSuggestPiece suggests the leecher to request the piece at idx, e.g.
+// as it is cached. It fails unless the Fast Extension was negotiated.
+func (p *Peer) SendSuggestPiece(idx int64) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.connectionStatus.Load() != uint32(ConnectionEstablished) {
+		return fmt.Errorf("invalid connection status %s, needed %s",
+			ConnectionStatus(p.connectionStatus.Load()),
+			ConnectionEstablished,
+		)
+	}
+	if !p.fast {
+		return errors.New("suggest piece requires the fast extension")
+	}
+
+	i, err := messagesv1.WireIndex(idx)
 	if err != nil {
-		return fmt.Errorf("failed to write have message: %w", err)
+		return err
 	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of have message")
+
+	if err := p.writeMessage("suggest piece", (&messagesv1.SuggestPiece{Index: i}).Serialize()); err != nil {
+		return err
 	}
 	return nil
 }
@@ -573,17 +773,13 @@ func (p *Peer) SendPiece(piece *messagesv1.Piece) error {
 		)
 	}
 
-	if err := p.conn.SetWriteDeadline(time.Now().Add(15 * time.Second)); err != nil {
-		return err
-	}
+	// the serialized piece is not retained once written, or timed out.
+	buf := pieceBuffers.Get().(*[]byte)
+	defer pieceBuffers.Put(buf)
+	*buf = piece.AppendSerialize((*buf)[:0])
 
-	msg := piece.Serialize()
-	w, err := io.Copy(p.conn, bytes.NewReader(msg))
-	if err != nil {
-		return fmt.Errorf("failed to write have message: %w", err)
-	}
-	if int(w) != len(msg) {
-		return fmt.Errorf("failed to write all of have message")
+	if err := p.writeMessage("piece", *buf); err != nil {
+		return err
 	}
 	return nil
 }
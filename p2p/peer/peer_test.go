@@ -0,0 +1,510 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+	"github.com/Despire/tinytorrent/p2p/peer/peertest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testInfoHash = "01234567890123456789"
+	testPeerID   = "-TT0100-000000000000"
+	testRemoteID = "-TT0100-111111111111"
+)
+
+func TestNewLeecherConnection_DroppedHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := peertest.NewFlakyConn(server, 1, peertest.WithDropRate(1))
+	_, err := NewLeecherConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), testPeerID, "pipe", 8, conn, testInfoHash, testPeerID)
+	assert.ErrorIs(t, err, peertest.ErrDropped)
+
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPeer_PartialWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+
+	// the handshake goes through.
+	conn := peertest.NewFlakyConn(server, 1, peertest.WithPartialWriteRate(1), peertest.WithFaultsAfter(1))
+	p, err := NewLeecherConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), testPeerID, "pipe", 8, conn, testInfoHash, testPeerID)
+	assert.NoError(t, err)
+
+	assert.Error(t, p.SendHave(&messagesv1.Have{Index: 0}))
+
+	assert.NoError(t, p.Close())
+}
+
+func TestPeer_DHTPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	remote, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer remote.Close()
+	conn, err := ln.Accept()
+	assert.NoError(t, err)
+
+	nodes := make(chan netip.AddrPort, 1)
+	p, err := NewLeecherConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), testPeerID, remote.LocalAddr().String(), 8, conn, testInfoHash, testPeerID,
+		WithDHTNodeHandler(func(node netip.AddrPort) { nodes <- node }),
+	)
+	assert.NoError(t, err)
+	defer p.Close()
+
+	_, err = io.ReadFull(remote, make([]byte, messagesv1.HandshakeLength))
+	assert.NoError(t, err)
+	_, err = remote.Write((&messagesv1.Port{Port: 6881}).Serialize())
+	assert.NoError(t, err)
+
+	select {
+	case node := <-nodes:
+		assert.Equal(t, netip.MustParseAddrPort("127.0.0.1:6881"), node)
+	case <-time.After(5 * time.Second):
+		t.Fatal("DHT port was not reported")
+	}
+	assert.Equal(t, ConnectionEstablished, p.ConnectionStatus())
+}
+
+func TestPeer_WriteTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// the remote peer reads the handshake and then stops reading.
+	go io.ReadFull(client, make([]byte, messagesv1.HandshakeLength))
+	p, err := NewLeecherConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), testPeerID, "pipe", 8, server, testInfoHash, testPeerID,
+		WithWriteTimeout(50*time.Millisecond),
+	)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	err = p.SendPiece(&messagesv1.Piece{Index: 0, Begin: 0, Block: make([]byte, 1<<10)})
+	assert.ErrorIs(t, err, ErrWriteTimeout)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// the dead peer is disconnected.
+	assert.Eventually(t, func() bool { return p.ConnectionStatus() == ConnectionKilled }, 5*time.Second, 10*time.Millisecond)
+	assert.Error(t, p.SendHave(&messagesv1.Have{Index: 0}))
+	assert.NoError(t, p.Close())
+}
+
+func TestPeer_Timeout(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int64
+		size int
+		want time.Duration
+	}{
+		{name: "small message", size: 17, want: time.Second + 17*time.Second/minWriteRate},
+		{name: "unknown rate", size: 2 * minWriteRate, want: 3 * time.Second},
+		{name: "slow peer", rate: minWriteRate, size: 2 * minWriteRate, want: 3 * time.Second},
+		{name: "fast peer", rate: 1 << 20, size: 1 << 19, want: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Peer{writeTimeout: time.Second}
+			p.writeRate.rate.Store(tt.rate)
+			assert.Equal(t, tt.want, p.timeout(tt.size))
+		})
+	}
+}
+
+func TestNewSeederConnection_Self(t *testing.T) {
+	client, remote := net.Pipe()
+	defer remote.Close()
+
+	go func() {
+		var hs [messagesv1.HandshakeLength]byte
+		if _, err := io.ReadFull(remote, hs[:]); err != nil {
+			return
+		}
+		// the handshake is answered by this client itself.
+		remote.Write(hs[:])
+	}()
+
+	dial := func(context.Context, string, string) (net.Conn, error) { return client, nil }
+	_, err := NewSeederConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), "pipe", 8, testInfoHash, testPeerID, WithDialer(dial))
+	assert.ErrorIs(t, err, ErrHandshake)
+	assert.ErrorIs(t, err, ErrSelfConnection)
+
+	_, err = remote.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "connection was not closed")
+}
+
+func TestPeer_FastHints(t *testing.T) {
+	for _, fast := range []bool{true, false} {
+		t.Run(fmt.Sprintf("fast=%v", fast), func(t *testing.T) {
+			client, remote := net.Pipe()
+			defer remote.Close()
+
+			go func() {
+				var hs [messagesv1.HandshakeLength]byte
+				if _, err := io.ReadFull(remote, hs[:]); err != nil {
+					return
+				}
+				h := messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: testInfoHash, PeerID: testRemoteID}
+				if fast {
+					h.SetFastExtension()
+				}
+				remote.Write(h.Serialize())
+				remote.Write((&messagesv1.Bitfield{Bitfield: []byte{0b1110_0000}}).Serialize())
+				remote.Write((&messagesv1.AllowedFast{Index: 5}).Serialize())  // does not have it.
+				remote.Write((&messagesv1.AllowedFast{Index: 99}).Serialize()) // out of range.
+				remote.Write((&messagesv1.SuggestPiece{Index: 2}).Serialize())
+				remote.Write((&messagesv1.AllowedFast{Index: 1}).Serialize())
+				io.Copy(io.Discard, remote)
+			}()
+
+			dial := func(context.Context, string, string) (net.Conn, error) { return client, nil }
+			p, err := NewSeederConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), "pipe", 8, testInfoHash, testPeerID, WithDialer(dial))
+			assert.NoError(t, err)
+			defer p.Close()
+			assert.Equal(t, fast, p.FastExtension())
+
+			if !fast {
+				// hints are ignored, as they are not expected.
+				assert.Eventually(t, func() bool { return p.Bitfield.Check(2) }, 5*time.Second, 10*time.Millisecond)
+				time.Sleep(50 * time.Millisecond)
+				assert.False(t, p.AllowedFast(1))
+				assert.False(t, p.Suggested(2))
+				assert.ErrorIs(t, p.SendRequest(&messagesv1.Request{Index: 1, Length: messagesv1.RequestSize}), ErrChoked)
+				return
+			}
+
+			assert.Eventually(t, func() bool { return p.AllowedFast(1) }, 5*time.Second, 10*time.Millisecond)
+			assert.True(t, p.Suggested(2))
+			assert.False(t, p.Suggested(1))
+			assert.False(t, p.AllowedFast(5))
+			assert.False(t, p.AllowedFast(99))
+
+			// allowed fast pieces are requested while choked.
+			assert.NoError(t, p.SendRequest(&messagesv1.Request{Index: 1, Length: messagesv1.RequestSize}))
+			assert.ErrorIs(t, p.SendRequest(&messagesv1.Request{Index: 0, Length: messagesv1.RequestSize}), ErrChoked)
+		})
+	}
+}
+
+// pipeSeeder returns a connection to a seeder, whose end of the
+// connection is returned after the handshakes, with the fast
+// extension negotiated if fast is set.
+func pipeSeeder(t *testing.T, fast bool, opts ...Option) (*Peer, net.Conn) {
+	t.Helper()
+	client, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+
+	handshaked := make(chan error, 1)
+	go func() {
+		var hs [messagesv1.HandshakeLength]byte
+		if _, err := io.ReadFull(remote, hs[:]); err != nil {
+			handshaked <- err
+			return
+		}
+		h := messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: testInfoHash, PeerID: testRemoteID}
+		if fast {
+			h.SetFastExtension()
+		}
+		_, err := remote.Write(h.Serialize())
+		handshaked <- err
+	}()
+
+	dial := func(context.Context, string, string) (net.Conn, error) { return client, nil }
+	opts = append(opts, WithDialer(dial))
+	p, err := NewSeederConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), "pipe", 10, testInfoHash, testPeerID, opts...)
+	if !assert.NoError(t, err) || !assert.NoError(t, <-handshaked) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, remote
+}
+
+func TestPeer_HaveAllHaveNone(t *testing.T) {
+	p, remote := pipeSeeder(t, true)
+	remote.Write(messagesv1.HaveAll{}.Serialize())
+	assert.Eventually(t, func() bool { return len(p.Bitfield.ExistingPieces()) == 10 }, 5*time.Second, 10*time.Millisecond)
+
+	p, remote = pipeSeeder(t, true)
+	remote.Write(messagesv1.HaveNone{}.Serialize())
+	remote.Write((&messagesv1.Have{Index: 4}).Serialize())
+	assert.Eventually(t, func() bool { return p.Bitfield.Check(4) }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{4}, p.Bitfield.ExistingPieces())
+}
+
+func TestPeer_NoBitfield(t *testing.T) {
+	// a peer without pieces may skip the bitfield and announce them later.
+	p, remote := pipeSeeder(t, false)
+	remote.Write(messagesv1.Unchoke{}.Serialize())
+	remote.Write((&messagesv1.Have{Index: 7}).Serialize())
+	assert.Eventually(t, func() bool { return p.Bitfield.Check(7) }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, ConnectionEstablished, p.ConnectionStatus())
+}
+
+func TestPeer_LateBitfield(t *testing.T) {
+	tests := []struct {
+		name string
+		fast bool
+		msg  []byte
+	}{
+		{name: "bitfield", msg: (&messagesv1.Bitfield{Bitfield: []byte{0xff, 0xc0}}).Serialize()},
+		{name: "have all", fast: true, msg: messagesv1.HaveAll{}.Serialize()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, remote := pipeSeeder(t, tt.fast)
+			remote.Write(messagesv1.KeepAlive{}.Serialize())
+			remote.Write((&messagesv1.Have{Index: 1}).Serialize())
+			remote.Write(tt.msg)
+
+			assert.Eventually(t, func() bool { return p.ConnectionStatus() == ConnectionKilled }, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, []int64{1}, p.Bitfield.ExistingPieces())
+		})
+	}
+}
+
+func TestPeer_SendEmptyBitfield(t *testing.T) {
+	tests := []struct {
+		name     string
+		fast     bool
+		bitfield []byte
+		want     messagesv1.MessageType
+	}{
+		{name: "empty is skipped", bitfield: make([]byte, 2), want: messagesv1.KeepAliveType},
+		{name: "empty with fast extension", fast: true, bitfield: make([]byte, 2), want: messagesv1.HaveNoneType},
+		{name: "pieces", bitfield: []byte{0, 0x40}, want: messagesv1.BitfieldType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, remote := pipeSeeder(t, tt.fast)
+			sent := make(chan error, 1)
+			go func() {
+				if err := p.SendBitfield(tt.bitfield); err != nil {
+					sent <- err
+					return
+				}
+				sent <- p.SendKeepAlive()
+			}()
+
+			msg, err := messagesv1.Identify(remote)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, msg.Type)
+			}
+			if tt.want != messagesv1.KeepAliveType {
+				_, err = messagesv1.Identify(remote)
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, <-sent)
+		})
+	}
+}
+
+func TestPeer_PiecesHandler(t *testing.T) {
+	client, remote := net.Pipe()
+
+	msgs := make(chan []byte)
+	go func() {
+		defer remote.Close()
+		var hs [messagesv1.HandshakeLength]byte
+		if _, err := io.ReadFull(remote, hs[:]); err != nil {
+			return
+		}
+		h := messagesv1.Handshake{Pstr: messagesv1.ProtocolV1, InfoHash: testInfoHash, PeerID: testRemoteID}
+		remote.Write(h.Serialize())
+		for m := range msgs {
+			remote.Write(m)
+		}
+	}()
+
+	type update struct {
+		pieces []int64
+		delta  int
+	}
+	updates := make(chan update, 8)
+	handle := func(pieces []int64, delta int) { updates <- update{pieces, delta} }
+	dial := func(context.Context, string, string) (net.Conn, error) { return client, nil }
+	p, err := NewSeederConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), "pipe", 10, testInfoHash, testPeerID, WithDialer(dial), WithPiecesHandler(handle))
+	assert.NoError(t, err)
+	defer p.Close()
+
+	next := func() update {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("pieces were not reported")
+			return update{}
+		}
+	}
+
+	msgs <- (&messagesv1.Bitfield{Bitfield: []byte{0b1000_0000, 0b0100_0000}}).Serialize()
+	assert.Equal(t, update{[]int64{0, 9}, 1}, next())
+	msgs <- (&messagesv1.Have{Index: 3}).Serialize()
+	assert.Equal(t, update{[]int64{3}, 1}, next())
+	// a piece announced twice is counted once.
+	msgs <- (&messagesv1.Have{Index: 3}).Serialize()
+
+	// the pieces of the peer are released once it disconnects.
+	close(msgs)
+	assert.Equal(t, update{[]int64{0, 3, 9}, -1}, next())
+	assert.Empty(t, updates)
+}
+
+func TestPeer_SuggestHandler(t *testing.T) {
+	suggested := make(chan int64, 2)
+	_, remote := pipeSeeder(t, true, WithSuggestHandler(func(piece int64) { suggested <- piece }))
+	remote.Write(messagesv1.HaveAll{}.Serialize())
+	remote.Write((&messagesv1.SuggestPiece{Index: 99}).Serialize()) // out of range.
+	remote.Write((&messagesv1.SuggestPiece{Index: 3}).Serialize())
+
+	select {
+	case piece := <-suggested:
+		assert.Equal(t, int64(3), piece)
+	case <-time.After(5 * time.Second):
+		t.Fatal("suggestion was not reported")
+	}
+}
+
+func TestPeer_FastLeecher(t *testing.T) {
+	for _, fast := range []bool{true, false} {
+		t.Run(fmt.Sprintf("fast=%v", fast), func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			handshake := make(chan messagesv1.Handshake, 1)
+			go func() {
+				var hs [messagesv1.HandshakeLength]byte
+				io.ReadFull(client, hs[:])
+				var h messagesv1.Handshake
+				h.Deserialize(hs[:])
+				handshake <- h
+			}()
+			var opts []Option
+			if fast {
+				opts = append(opts, WithFastExtension())
+			}
+			p, err := NewLeecherConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), testPeerID, "pipe", 8, server, testInfoHash, testPeerID, opts...)
+			assert.NoError(t, err)
+			defer p.Close()
+			h := <-handshake
+			assert.Equal(t, fast, h.FastExtension())
+
+			if !fast {
+				assert.Error(t, p.SendSuggestPiece(2))
+				return
+			}
+
+			assert.ErrorIs(t, p.SendSuggestPiece(-1), messagesv1.ErrIndexOutOfRange)
+			assert.ErrorIs(t, p.SendSuggestPiece(1<<32), messagesv1.ErrIndexOutOfRange)
+
+			sent := make(chan error, 1)
+			go func() { sent <- p.SendSuggestPiece(2) }()
+			msg, err := messagesv1.Identify(client)
+			assert.NoError(t, err)
+			assert.Equal(t, messagesv1.SuggestPieceType, msg.Type)
+			assert.NoError(t, <-sent)
+
+			// requests of the choked leecher are rejected.
+			client.Write(messagesv1.Interest{}.Serialize())
+			req := &messagesv1.Request{Index: 2, Length: messagesv1.RequestSize}
+			client.Write(req.Serialize())
+			msg, err = messagesv1.Identify(client)
+			assert.NoError(t, err)
+			assert.Equal(t, messagesv1.RejectRequestType, msg.Type)
+			r := new(messagesv1.RejectRequest)
+			assert.NoError(t, r.Deserialize(msg.Payload))
+			assert.Equal(t, messagesv1.RejectRequest{Index: 2, Length: messagesv1.RequestSize}, *r)
+		})
+	}
+}
+
+func TestPeer_QueueRequest(t *testing.T) {
+	p, remote := pipeSeeder(t, false)
+	remote.Write(messagesv1.Unchoke{}.Serialize())
+	assert.Eventually(t, func() bool { return p.Status.Remote.Load() == uint32(UnChoked) }, 5*time.Second, 10*time.Millisecond)
+
+	// the remote peer does not read, queueing never blocks.
+	done := make(chan error, 1)
+	go func() {
+		for i := range requestQueueSize + maxRequestBatch + 1 {
+			if err := p.QueueRequest(&messagesv1.Request{Index: uint32(i % 10), Length: messagesv1.RequestSize}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrQueueFull)
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing requests blocked")
+	}
+
+	// the queued requests are written once the peer reads, in order.
+	for i := range 2 * maxRequestBatch {
+		msg, err := messagesv1.Identify(remote)
+		assert.NoError(t, err)
+		assert.Equal(t, messagesv1.RequestType, msg.Type)
+		req := new(messagesv1.Request)
+		assert.NoError(t, req.Deserialize(msg.Payload))
+		assert.Equal(t, uint32(i%10), req.Index)
+	}
+	assert.NoError(t, p.QueueRequest(&messagesv1.Request{Index: 0, Length: messagesv1.RequestSize}))
+}
+
+func TestPeer_QueuedRequestsDroppedOnChoke(t *testing.T) {
+	p, remote := pipeSeeder(t, false)
+	assert.ErrorIs(t, p.QueueRequest(&messagesv1.Request{Index: 0, Length: messagesv1.RequestSize}), ErrChoked)
+
+	remote.Write(messagesv1.Unchoke{}.Serialize())
+	assert.Eventually(t, func() bool { return p.Status.Remote.Load() == uint32(UnChoked) }, 5*time.Second, 10*time.Millisecond)
+	remote.Write(messagesv1.Choke{}.Serialize())
+	select {
+	case n := <-p.Chokes():
+		assert.Equal(t, uint64(1), n)
+		assert.Equal(t, uint64(1), p.ChokeCount())
+	case <-time.After(5 * time.Second):
+		t.Fatal("choke was not signaled")
+	}
+
+	// requests queued before the peer choked this client are not written.
+	written := make(chan error, 1)
+	go func() {
+		written <- p.writeRequests([]*messagesv1.Request{{Index: 0, Length: messagesv1.RequestSize}})
+	}()
+	assert.NoError(t, <-written)
+	assert.NoError(t, remote.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, err := messagesv1.Identify(remote)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestPeer_ChokesMergedWithLatestCount(t *testing.T) {
+	p, remote := pipeSeeder(t, false)
+
+	for range 3 {
+		remote.Write(messagesv1.Unchoke{}.Serialize())
+		remote.Write(messagesv1.Choke{}.Serialize())
+	}
+	assert.Eventually(t, func() bool { return p.ChokeCount() == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	// the chokes that were not consumed are signaled once, with the latest count.
+	assert.Equal(t, uint64(3), <-p.Chokes())
+	select {
+	case n := <-p.Chokes():
+		t.Fatalf("choke %d signaled twice", n)
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+package peertest_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Despire/tinytorrent/p2p/peer/peertest"
+)
+
+func ExampleFlakyConn() {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	conn := peertest.NewFlakyConn(client, 42, peertest.WithDropRate(0.1))
+
+	writes := 0
+	for {
+		if _, err := conn.Write([]byte("ping")); errors.Is(err, peertest.ErrDropped) {
+			break
+		}
+		writes++
+	}
+
+	fmt.Println(conn.Dropped(), writes < 100)
+	// Output: true true
+}
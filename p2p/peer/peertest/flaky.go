@@ -0,0 +1,129 @@
+// Package peertest provides fault injecting connections for
+// testing code that communicates with peers over the network.
+package peertest
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrDropped is returned by the write that dropped the connection
+// and by all subsequent operations on a FlakyConn.
+var ErrDropped = errors.New("connection dropped by fault injection")
+
+// Option configures a FlakyConn.
+type Option func(c *FlakyConn)
+
+// WithDropRate closes the connection on a write with the
+// given probability, between 0 and 1. Nothing is written.
+func WithDropRate(p float64) Option {
+	return func(c *FlakyConn) {
+		c.dropRate = p
+	}
+}
+
+// WithPartialWriteRate writes only a prefix of the data with the given
+// probability, between 0 and 1, after which the write fails with
+// io.ErrShortWrite. The connection stays open, as if the write deadline
+// expired midway.
+func WithPartialWriteRate(p float64) Option {
+	return func(c *FlakyConn) {
+		c.partialRate = p
+	}
+}
+
+// WithDelay delays every read and write by d.
+func WithDelay(d time.Duration) Option {
+	return func(c *FlakyConn) {
+		c.delay = d
+	}
+}
+
+// WithFaultsAfter injects faults only after the given number of writes
+// succeeded, e.g. to let a handshake through.
+func WithFaultsAfter(writes int) Option {
+	return func(c *FlakyConn) {
+		c.after = writes
+	}
+}
+
+// FlakyConn wraps a connection and injects faults into it. The faults are
+// chosen by a pseudo random generator, the same seed and the same sequence
+// of writes always inject the same faults.
+type FlakyConn struct {
+	net.Conn
+
+	dropRate, partialRate float64
+	delay                 time.Duration
+	after                 int
+
+	l       sync.Mutex
+	rng     *rand.Rand
+	dropped bool
+	writes  int
+}
+
+func NewFlakyConn(conn net.Conn, seed uint64, opts ...Option) *FlakyConn {
+	c := &FlakyConn{
+		Conn: conn,
+		rng:  rand.New(rand.NewPCG(seed, seed)),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Dropped reports whether the connection was dropped by an injected fault.
+func (c *FlakyConn) Dropped() bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.dropped
+}
+
+func (c *FlakyConn) Read(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	if c.Dropped() {
+		return 0, ErrDropped
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *FlakyConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay)
+
+	c.l.Lock()
+	if c.dropped {
+		c.l.Unlock()
+		return 0, ErrDropped
+	}
+	if c.writes < c.after {
+		c.writes++
+		c.l.Unlock()
+		return c.Conn.Write(b)
+	}
+	if c.dropRate > 0 && c.rng.Float64() < c.dropRate {
+		c.dropped = true
+		c.l.Unlock()
+		c.Conn.Close()
+		return 0, ErrDropped
+	}
+	partial := -1
+	if c.partialRate > 0 && len(b) > 1 && c.rng.Float64() < c.partialRate {
+		partial = 1 + c.rng.IntN(len(b)-1)
+	}
+	c.l.Unlock()
+
+	if partial < 0 {
+		return c.Conn.Write(b)
+	}
+	n, err := c.Conn.Write(b[:partial])
+	if err != nil {
+		return n, err
+	}
+	return n, io.ErrShortWrite
+}
@@ -0,0 +1,65 @@
+package peertest
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlakyConn_Drop(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := NewFlakyConn(client, 1, WithDropRate(1))
+	_, err := c.Write([]byte{0x1})
+	assert.ErrorIs(t, err, ErrDropped)
+	assert.True(t, c.Dropped())
+
+	// the other side observes the closed connection.
+	_, err = server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = c.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrDropped)
+}
+
+func TestFlakyConn_PartialWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := NewFlakyConn(client, 1, WithPartialWriteRate(1))
+
+	received := make(chan int)
+	go func() {
+		b := make([]byte, 8)
+		n, _ := server.Read(b)
+		received <- n
+	}()
+
+	n, err := c.Write([]byte("abcdefgh"))
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.Less(t, n, 8)
+	assert.Positive(t, n)
+	assert.Equal(t, n, <-received)
+	assert.False(t, c.Dropped())
+}
+
+func TestFlakyConn_Deterministic(t *testing.T) {
+	run := func(seed uint64) []int {
+		client, server := net.Pipe()
+		defer server.Close()
+		go io.Copy(io.Discard, server)
+
+		c := NewFlakyConn(client, seed, WithPartialWriteRate(0.5))
+		var out []int
+		for range 20 {
+			n, _ := c.Write([]byte("abcdefgh"))
+			out = append(out, n)
+		}
+		return out
+	}
+	assert.Equal(t, run(3), run(3))
+	assert.NotEqual(t, run(3), run(4))
+}
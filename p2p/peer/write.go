@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Despire/tinytorrent/p2p/messagesv1"
+)
+
+const (
+	// minWriteRate is the rate, in bytes per second, assumed for peers
+	// whose rate is not known yet, or slower. Messages larger than the
+	// socket buffers take longer to write to slow peers, so their write
+	// timeout is extended by the time they take at the rate.
+	minWriteRate = 4 << 10
+	// minRateSample is the size of the messages the rate is measured by,
+	// smaller ones are copied into the socket buffers right away.
+	minRateSample = 4 << 10
+
+	// requestQueueSize is the number of requests that can wait to be
+	// written to a seeder, see QueueRequest, enough for the deepest
+	// pipelines while the peer is accepting data.
+	requestQueueSize = 256
+	// maxRequestBatch is the number of queued requests written at once.
+	maxRequestBatch = 32
+)
+
+// pieceBuffers are the buffers the piece messages are serialized into.
+var pieceBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// writeRate is the smoothed rate, in bytes per second, at
+// which the peer accepted the larger messages written to it.
+type writeRate struct{ rate atomic.Int64 }
+
+func (r *writeRate) observe(n int, d time.Duration) {
+	if n < minRateSample {
+		return
+	}
+	sample := int64(float64(n) / max(d, time.Millisecond).Seconds())
+	if old := r.rate.Load(); old > 0 {
+		sample = (3*old + sample) / 4
+	}
+	r.rate.Store(sample)
+}
+
+// timeout returns the time writing a message of size bytes may take.
+func (p *Peer) timeout(size int) time.Duration {
+	// the measured rate is halved to tolerate a peer slowing down.
+	rate := max(p.writeRate.rate.Load()/2, minWriteRate)
+	return p.writeTimeout + time.Duration(float64(size)/float64(rate)*float64(time.Second))
+}
+
+// writeMessage writes msg to the connection. If the peer does not accept
+// the message in time, e.g. as its receive window stays closed behind a
+// dead NAT mapping, it is considered dead and its connection is closed, so
+// that the writers are not blocked by it again.
+func (p *Peer) writeMessage(name string, msg []byte) error {
+	start := time.Now()
+	if err := p.conn.SetWriteDeadline(start.Add(p.timeout(len(msg)))); err != nil {
+		return err
+	}
+	w, err := io.Copy(p.conn, bytes.NewReader(msg))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		p.logger.Debug("peer did not accept message in time, closing connection",
+			slog.String("type", name),
+			slog.Int("size", len(msg)),
+		)
+		if errClose := p.conn.Close(); errClose != nil {
+			p.logger.Debug("failed to close connection", slog.Any("err", errClose))
+		}
+		return fmt.Errorf("failed to write %s message: %w: %w", name, ErrWriteTimeout, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s message: %w", name, err)
+	}
+	if int(w) != len(msg) {
+		return fmt.Errorf("failed to write all of the %s message", name)
+	}
+	p.writeRate.observe(len(msg), time.Since(start))
+	return nil
+}
+
+// requestWriter writes the queued requests to the seeder until the
+// listener exits. The requests that queued up while the previous ones
+// were written are batched into a single write.
+func (p *Peer) requestWriter() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.seeder.done:
+			return
+		case req := <-p.seeder.requests:
+			batch := []*messagesv1.Request{req}
+		drain:
+			for len(batch) < maxRequestBatch {
+				select {
+				case req := <-p.seeder.requests:
+					batch = append(batch, req)
+				default:
+					break drain
+				}
+			}
+			if err := p.writeRequests(batch); err != nil {
+				// the requests are scheduled again once they time out.
+				p.logger.Debug("failed to write queued requests, closing connection", slog.Any("err", err))
+				if errClose := p.conn.Close(); errClose != nil && !errors.Is(errClose, net.ErrClosed) {
+					p.logger.Debug("failed to close connection", slog.Any("err", errClose))
+				}
+			}
+		}
+	}
+}
+
+// writeRequests writes the requests of batch that can still be sent, as
+// the peer did not choke this client meanwhile or allowed the piece fast.
+func (p *Peer) writeRequests(batch []*messagesv1.Request) error {
+	p.choke.Lock()
+	defer p.choke.Unlock()
+
+	var msg []byte
+	for _, req := range batch {
+		if p.Status.Remote.Load() == uint32(Choked) && !p.AllowedFast(req.PieceIndex()) {
+			continue
+		}
+		msg = append(msg, req.Serialize()...)
+	}
+	if len(msg) == 0 {
+		return nil
+	}
+	return p.writeMessage("request", msg)
+}
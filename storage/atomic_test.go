@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errCrash = errors.New("crashed")
+
+// crasher fails the operations of a PieceFiles from its crash point on,
+// as if the process died there. The write at the crash point is partial.
+type crasher struct {
+	ops, crashAt int
+}
+
+func (c *crasher) crashed() bool {
+	c.ops++
+	return c.ops > c.crashAt
+}
+
+type crashingFile struct {
+	*os.File
+	c *crasher
+}
+
+func (f *crashingFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.c.crashed() {
+		n, _ := f.File.WriteAt(b[:len(b)/2], off)
+		return n, errCrash
+	}
+	return f.File.WriteAt(b, off)
+}
+
+func (f *crashingFile) Close() error {
+	err := f.File.Close()
+	if f.c.crashed() {
+		return errCrash
+	}
+	return err
+}
+
+func TestPieceFiles_CrashSafety(t *testing.T) {
+	const (
+		pieces    = 16
+		syncEvery = 4
+	)
+	for seed := range uint64(50) {
+		rng := rand.New(rand.NewPCG(seed, seed))
+
+		data := make([][]byte, pieces)
+		for i := range data {
+			data[i] = make([]byte, 1000+rng.IntN(1000))
+			for j := range data[i] {
+				data[i][j] = byte(rng.Uint32())
+			}
+		}
+
+		dir := filepath.Join(t.TempDir(), "pieces")
+		c := &crasher{crashAt: rng.IntN(3 * pieces)}
+		s := NewPieceFiles(dir)
+		s.create = func(dir, pattern string) (pieceFile, error) {
+			f, err := os.CreateTemp(dir, pattern)
+			if err != nil {
+				return nil, err
+			}
+			return &crashingFile{File: f, c: c}, nil
+		}
+		s.syncFile = func(name string) error {
+			if c.crashed() {
+				return errCrash
+			}
+			return syncFile(name)
+		}
+
+		// pieces are written in random order, some of them again.
+		durable := make(map[int64]bool)
+		var written []int64
+		for n := 0; ; n++ {
+			i := int64(rng.IntN(pieces))
+			if err := s.WritePiece(i, data[i]); err != nil {
+				assert.ErrorIs(t, err, errCrash)
+				break
+			}
+			written = append(written, i)
+			if n%syncEvery != syncEvery-1 {
+				continue
+			}
+			if err := s.Sync(); err != nil {
+				assert.ErrorIs(t, err, errCrash)
+				break
+			}
+			for _, i := range written {
+				durable[i] = true
+			}
+			written = nil
+		}
+
+		// re-check the pieces after restarting.
+		s = NewPieceFiles(dir)
+		for i := range int64(pieces) {
+			b, err := s.ReadBlock(i, 0, uint32(len(data[i])))
+			if durable[i] {
+				assert.NoError(t, err, "seed %d: durable piece %d is missing", seed, i)
+			}
+			if err == nil {
+				assert.Equal(t, data[i], b, "seed %d: piece %d is corrupt", seed, i)
+			}
+		}
+	}
+}
+
+func TestPieceFiles_SyncFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pieces")
+	s := NewPieceFiles(dir)
+
+	var synced []string
+	fail := true
+	s.syncFile = func(name string) error {
+		if fail {
+			return errCrash
+		}
+		synced = append(synced, name)
+		return nil
+	}
+
+	assert.NoError(t, s.WritePiece(1, []byte{1}))
+	assert.NoError(t, s.WritePiece(0, []byte{0}))
+	assert.NoError(t, s.WritePiece(1, []byte{1}))
+	assert.ErrorIs(t, s.Sync(), errCrash)
+
+	// the pieces are synced again by the next call.
+	fail = false
+	assert.NoError(t, s.Sync())
+	assert.Equal(t, []string{s.path(0), s.path(1), dir}, synced)
+
+	synced = nil
+	assert.NoError(t, s.Sync())
+	assert.Empty(t, synced, "nothing was written since")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCacheSize is the default budget of a PieceCache in bytes.
+const DefaultCacheSize = 64 << 20
+
+// CacheStats are the counters of a PieceCache.
+type CacheStats struct {
+	// Hits is the number of blocks read from cached pieces.
+	Hits int64
+	// Misses is the number of blocks whose piece was read from the storage.
+	Misses int64
+	// Size is the number of bytes cached, Budget the number of bytes the cache may hold.
+	Size   int64
+	Budget int64
+}
+
+type cacheKey struct {
+	storage uint64
+	piece   int64
+}
+
+// cacheEntry is a cached piece. Its data is set once ready is closed,
+// unless the read failed with err.
+type cacheEntry struct {
+	key   cacheKey
+	data  []byte
+	err   error
+	ready chan struct{}
+	elem  *list.Element
+}
+
+// PieceCache caches the recently read pieces of all storages it wrapped,
+// within a single budget, so that the blocks of hot pieces requested
+// by many leechers are served without a disk read each. The least
+// recently used pieces are evicted once the budget is exceeded.
+type PieceCache struct {
+	l       sync.Mutex
+	budget  int64
+	size    int64
+	entries map[cacheKey]*cacheEntry
+	// lru holds the read pieces, the most recently used at the front.
+	lru list.List
+
+	storages     atomic.Uint64
+	hits, misses atomic.Int64
+}
+
+// NewPieceCache returns a cache that holds up to budget bytes of pieces.
+func NewPieceCache(budget int64) *PieceCache {
+	return &PieceCache{budget: budget, entries: make(map[cacheKey]*cacheEntry)}
+}
+
+func (c *PieceCache) Stats() CacheStats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   c.size,
+		Budget: c.budget,
+	}
+}
+
+// Wrap returns a Storage whose blocks are read from the cached pieces of
+// backend. On a miss the whole piece, of the size returned by pieceSize,
+// is read from backend. Written pieces are no longer served from the cache.
+func (c *PieceCache) Wrap(backend Storage, pieceSize func(piece int64) int64) *CachedStorage {
+	return &CachedStorage{cache: c, id: c.storages.Add(1), backend: backend, pieceSize: pieceSize}
+}
+
+// get returns the entry of the piece, and whether it has to be read by the caller.
+func (c *PieceCache) get(key cacheKey) (*cacheEntry, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[key]; ok {
+		if e.elem != nil {
+			c.lru.MoveToFront(e.elem)
+		}
+		return e, false
+	}
+	e := &cacheEntry{key: key, ready: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// fill completes the read of the entry, which is cached unless it failed
+// or the piece was invalidated meanwhile.
+func (c *PieceCache) fill(e *cacheEntry, data []byte, err error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	e.data, e.err = data, err
+	close(e.ready)
+
+	if c.entries[e.key] != e {
+		return // invalidated.
+	}
+	if err != nil {
+		delete(c.entries, e.key)
+		return
+	}
+	e.elem = c.lru.PushFront(e)
+	c.size += int64(len(data))
+	for c.size > c.budget {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+// invalidate drops the piece from the cache.
+func (c *PieceCache) invalidate(key cacheKey) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// purge drops all pieces of the storage from the cache.
+func (c *PieceCache) purge(storage uint64) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	for key, e := range c.entries {
+		if key.storage == storage {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *PieceCache) remove(e *cacheEntry) {
+	delete(c.entries, e.key)
+	if e.elem != nil {
+		c.lru.Remove(e.elem)
+		c.size -= int64(len(e.data))
+		e.elem = nil
+	}
+}
+
+// CachedStorage is a Storage wrapped by a PieceCache.
+type CachedStorage struct {
+	cache     *PieceCache
+	id        uint64
+	backend   Storage
+	pieceSize func(piece int64) int64
+}
+
+func (s *CachedStorage) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	size := s.pieceSize(piece)
+	if size > s.cache.budget {
+		return s.backend.ReadBlock(piece, begin, length)
+	}
+	if err := checkBlock(size, begin, length); err != nil {
+		return nil, err
+	}
+
+	e, read := s.cache.get(cacheKey{storage: s.id, piece: piece})
+	if read {
+		s.cache.misses.Add(1)
+		data, err := s.backend.ReadBlock(piece, 0, uint32(size))
+		s.cache.fill(e, data, err)
+	} else {
+		s.cache.hits.Add(1)
+		<-e.ready
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	b := make([]byte, length)
+	copy(b, e.data[begin:])
+	return b, nil
+}
+
+func (s *CachedStorage) WritePiece(piece int64, data []byte) error {
+	err := s.backend.WritePiece(piece, data)
+	// also on failure, as the piece may have been written partially.
+	s.cache.invalidate(cacheKey{storage: s.id, piece: piece})
+	return err
+}
+
+// Recent returns up to n pieces of the storage held by the cache,
+// the most recently used first.
+func (s *CachedStorage) Recent(n int) []int64 {
+	s.cache.l.Lock()
+	defer s.cache.l.Unlock()
+	var out []int64
+	for e := s.cache.lru.Front(); e != nil && len(out) < n; e = e.Next() {
+		if key := e.Value.(*cacheEntry).key; key.storage == s.id {
+			out = append(out, key.piece)
+		}
+	}
+	return out
+}
+
+// Sync syncs backend, see Syncer.
+func (s *CachedStorage) Sync() error { return Sync(s.backend) }
+
+// Purge drops the pieces of the storage from the cache,
+// e.g. once its torrent was removed.
+func (s *CachedStorage) Purge() { s.cache.purge(s.id) }
+
+func (s *CachedStorage) readBack(piece int64, length uint32) ([]byte, error) {
+	return ReadBack(s.backend, piece, length)
+}
+
+func (s *CachedStorage) readThrough(piece int64, begin, length uint32) ([]byte, error) {
+	return ReadThrough(s.backend, piece, begin, length)
+}
+
+// ReadThrough reads the block from s bypassing any PieceCache that wrapped
+// it, e.g. to verify the pieces as stored rather than as cached.
+func ReadThrough(s Storage, piece int64, begin, length uint32) ([]byte, error) {
+	if r, ok := s.(interface {
+		readThrough(piece int64, begin, length uint32) ([]byte, error)
+	}); ok {
+		return r.readThrough(piece, begin, length)
+	}
+	return s.ReadBlock(piece, begin, length)
+}
@@ -0,0 +1,159 @@
+package storage_test
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts the reads of each piece.
+type countingStorage struct {
+	storage.Storage
+	l     sync.Mutex
+	reads map[int64]int
+}
+
+func (s *countingStorage) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	s.l.Lock()
+	s.reads[piece]++
+	s.l.Unlock()
+	return s.Storage.ReadBlock(piece, begin, length)
+}
+
+func (s *countingStorage) count(piece int64) int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.reads[piece]
+}
+
+// newCountingStorage returns a storage of pieces of pieceLength bytes each.
+func newCountingStorage(t *testing.T, pieces, pieceLength int) *countingStorage {
+	t.Helper()
+	mem := storage.NewMemory()
+	for i := range pieces {
+		assert.NoError(t, mem.WritePiece(int64(i), bytes.Repeat([]byte{byte(i)}, pieceLength)))
+	}
+	return &countingStorage{Storage: mem, reads: make(map[int64]int)}
+}
+
+func fixedSize(n int64) func(int64) int64 { return func(int64) int64 { return n } }
+
+func TestPieceCache(t *testing.T) {
+	backend := newCountingStorage(t, 4, 64)
+	c := storage.NewPieceCache(128)
+	s := c.Wrap(backend, fixedSize(64))
+
+	for range 3 {
+		b, err := s.ReadBlock(0, 16, 8)
+		assert.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{0}, 8), b)
+	}
+	assert.Equal(t, 1, backend.count(0), "piece is read once")
+	assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 1, Size: 64, Budget: 128}, c.Stats())
+
+	_, err := s.ReadBlock(0, 60, 8)
+	assert.ErrorIs(t, err, storage.ErrInvalidBlock)
+
+	// the least recently used piece is evicted.
+	_, err = s.ReadBlock(1, 0, 8)
+	assert.NoError(t, err)
+	_, err = s.ReadBlock(0, 0, 8)
+	assert.NoError(t, err)
+	_, err = s.ReadBlock(2, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(128), c.Stats().Size)
+	_, err = s.ReadBlock(0, 0, 8)
+	assert.NoError(t, err)
+	_, err = s.ReadBlock(1, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, backend.count(0))
+	assert.Equal(t, 2, backend.count(1))
+	assert.Equal(t, []int64{1, 0}, s.Recent(4), "most recently used first")
+
+	// written pieces are read again.
+	assert.NoError(t, s.WritePiece(0, bytes.Repeat([]byte{0xff}, 64)))
+	b, err := s.ReadBlock(0, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xff}, 8), b)
+	assert.Equal(t, 2, backend.count(0))
+
+	// reading through the cache neither uses nor fills it.
+	stats := c.Stats()
+	_, err = storage.ReadThrough(s, 3, 0, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, stats, c.Stats())
+
+	s.Purge()
+	assert.Zero(t, c.Stats().Size)
+}
+
+func TestPieceCache_SharedBudget(t *testing.T) {
+	c := storage.NewPieceCache(128)
+	a := c.Wrap(newCountingStorage(t, 2, 64), fixedSize(64))
+	backend := newCountingStorage(t, 2, 64)
+	b := c.Wrap(backend, fixedSize(64))
+
+	for i := range int64(2) {
+		_, err := a.ReadBlock(i, 0, 8)
+		assert.NoError(t, err)
+		_, err = b.ReadBlock(i, 0, 8)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(128), c.Stats().Size)
+
+	// the same piece index of another torrent is a different piece.
+	got, err := b.ReadBlock(1, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 8), got)
+	assert.Equal(t, 1, backend.count(1))
+
+	// only the cached pieces of the storage are recent.
+	assert.Equal(t, []int64{1}, b.Recent(4))
+	assert.Equal(t, []int64{1}, a.Recent(4))
+	assert.Empty(t, a.Recent(0))
+
+	a.Purge()
+	assert.Equal(t, int64(64), c.Stats().Size)
+	b.Purge()
+	assert.Zero(t, c.Stats().Size)
+}
+
+func TestPieceCache_ConcurrentMisses(t *testing.T) {
+	backend := newCountingStorage(t, 1, 1024)
+	c := storage.NewPieceCache(4096)
+	s := c.Wrap(backend, fixedSize(1024))
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := s.ReadBlock(0, uint32(i*16), 16)
+			if err != nil || !bytes.Equal(make([]byte, 16), b) {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.False(t, failed.Load())
+	assert.Equal(t, 1, backend.count(0))
+	assert.Equal(t, int64(31), c.Stats().Hits)
+}
+
+func TestPieceCache_LargePiecesNotCached(t *testing.T) {
+	backend := newCountingStorage(t, 1, 256)
+	c := storage.NewPieceCache(128)
+	s := c.Wrap(backend, fixedSize(256))
+
+	for range 2 {
+		_, err := s.ReadBlock(0, 0, 16)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, backend.count(0))
+	assert.Equal(t, storage.CacheStats{Budget: 128}, c.Stats())
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Memory stores the pieces in memory. It is meant for tests
+// and for torrents that never need to outlive the process.
+type Memory struct {
+	l      sync.RWMutex
+	pieces map[int64][]byte
+}
+
+func NewMemory() *Memory { return &Memory{pieces: make(map[int64][]byte)} }
+
+func (m *Memory) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	data, ok := m.pieces[piece]
+	if !ok {
+		return nil, fmt.Errorf("piece %v is not stored", piece)
+	}
+	if err := checkBlock(int64(len(data)), begin, length); err != nil {
+		return nil, err
+	}
+	return slices.Clone(data[begin : begin+length]), nil
+}
+
+func (m *Memory) WritePiece(piece int64, data []byte) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.pieces[piece] = slices.Clone(data)
+	return nil
+}
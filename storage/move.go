@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrDestinationExists is returned when moving to a destination that already exists.
+var ErrDestinationExists = errors.New("destination already exists")
+
+// moveDir moves the directory src to dst, see PieceFiles.Move.
+func moveDir(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("failed to move %s: %w: %s", src, ErrDestinationExists, dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create parent of %s: %w", dst, err)
+	}
+
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("failed to move %s to %s: %w", src, dst, err)
+	}
+	return copyMove(src, dst)
+}
+
+// copyMove copies the directory src to dst and removes src afterwards. The
+// copy is made next to dst and renamed once synced, so that dst only ever
+// holds the complete data.
+func copyMove(src, dst string) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".partial")
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to remove stale partial copy %s: %w", tmp, err)
+	}
+
+	if err := copyDir(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to move copy of %s to %s: %w", src, dst, err)
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return fmt.Errorf("copied %s to %s but failed to remove the original: %w", src, dst, err)
+	}
+	return nil
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultWorkers is the number of disk operations executed concurrently.
+	defaultWorkers = 2
+	// defaultSaturationLatency is the latency of queued reads
+	// above which the disk is considered saturated.
+	defaultSaturationLatency = 100 * time.Millisecond
+	// How often the read throughput is updated.
+	throughputTick = 1 * time.Second
+	// How often a worker that holds off reads checks whether
+	// the disk is still saturated.
+	recheckInterval = 10 * time.Millisecond
+	// fdRetryMin and fdRetryMax bound the delay before an operation
+	// is retried that failed as no file descriptors were left.
+	fdRetryMin = 10 * time.Millisecond
+	fdRetryMax = 1 * time.Second
+)
+
+// Stats are the observed metrics of the disk scheduler.
+type Stats struct {
+	// FlushLatency is the smoothed time it takes for a verified piece
+	// to be written, including the time it waited in the queue.
+	FlushLatency time.Duration
+	// QueueLatency is the smoothed time reads wait before they are executed.
+	QueueLatency time.Duration
+	// ReadThroughput is the number of bytes read during the last second.
+	ReadThroughput int64
+	// Saturated is set while the queue latency of reads exceeds the saturation latency.
+	Saturated bool
+	// FDRetries is the number of operations retried as the
+	// process or the system ran out of file descriptors.
+	FDRetries int64
+}
+
+// SchedulerOption configures a Scheduler.
+type SchedulerOption func(s *Scheduler)
+
+// WithReadLimit limits the bytes read per second by all storages
+// wrapped by the scheduler. A non-positive value disables the limit.
+func WithReadLimit(bytesPerSecond int64) SchedulerOption {
+	return func(s *Scheduler) {
+		if bytesPerSecond > 0 {
+			s.limiter = newLimiter(bytesPerSecond)
+		}
+	}
+}
+
+// WithWorkers sets the number of disk operations executed concurrently.
+func WithWorkers(n int) SchedulerOption {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// WithMaxOpenFiles bounds the number of files opened concurrently by the
+// operations of all storages wrapped by the scheduler, as each operation
+// opens at most one file at a time. A non-positive value disables the cap.
+func WithMaxOpenFiles(n int) SchedulerOption {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.files = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithSaturationLatency sets the latency of queued reads above which
+// the disk is considered saturated. While saturated, reads are executed one at a
+// time and the remaining workers are kept for flushes.
+func WithSaturationLatency(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		if d > 0 {
+			s.saturation = d
+		}
+	}
+}
+
+type op struct {
+	do     func() error
+	queued time.Time
+	done   chan error
+}
+
+// Scheduler shares the disk among the storages of all torrents. Writes of
+// verified pieces always take precedence over reads issued by the upload
+// path, which are additionally rate limited.
+type Scheduler struct {
+	workers    int
+	saturation time.Duration
+	limiter    *limiter
+	// files holds a token per operation in progress, if the number of
+	// open files is capped.
+	files chan struct{}
+
+	writes, reads chan *op
+	activeReads   atomic.Int64
+	readBytes     atomic.Int64
+	fdRetries     atomic.Int64
+
+	metrics struct {
+		l              sync.Mutex
+		queue, flush   time.Duration
+		readThroughput int64
+	}
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewScheduler(opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		workers:    defaultWorkers,
+		saturation: defaultSaturationLatency,
+		writes:     make(chan *op),
+		reads:      make(chan *op),
+		stop:       make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	for range s.workers {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	s.wg.Add(1)
+	go s.measureThroughput()
+
+	return s
+}
+
+// Close stops the workers of the scheduler. Operations
+// issued after closing the scheduler are not executed.
+func (s *Scheduler) Close() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// Wrap returns a Storage that schedules the operations on backend.
+func (s *Scheduler) Wrap(backend Storage) Storage {
+	return &scheduled{scheduler: s, backend: backend}
+}
+
+func (s *Scheduler) Stats() Stats {
+	s.metrics.l.Lock()
+	defer s.metrics.l.Unlock()
+	return Stats{
+		FlushLatency:   s.metrics.flush,
+		QueueLatency:   s.metrics.queue,
+		ReadThroughput: s.metrics.readThroughput,
+		Saturated:      s.metrics.queue > s.saturation,
+		FDRetries:      s.fdRetries.Load(),
+	}
+}
+
+func (s *Scheduler) saturated() bool {
+	s.metrics.l.Lock()
+	defer s.metrics.l.Unlock()
+	return s.metrics.queue > s.saturation
+}
+
+// Smooth returns the exponentially weighted moving average of prev and
+// sample, weighting the sample by 1/8. A zero prev is no average yet.
+func Smooth(prev, sample time.Duration) time.Duration {
+	if prev == 0 {
+		return sample
+	}
+	return prev + (sample-prev)/8
+}
+
+func (s *Scheduler) submit(queue chan *op, do func() error) error {
+	o := &op{do: do, queued: time.Now(), done: make(chan error, 1)}
+	select {
+	case <-s.stop:
+		return ErrSchedulerClosed
+	case queue <- o:
+	}
+	return <-o.done
+}
+
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+	for {
+		// flushes always go first.
+		select {
+		case <-s.stop:
+			return
+		case o := <-s.writes:
+			s.execute(o, true)
+			continue
+		default:
+		}
+
+		// while saturated only a single read is executed at a time
+		// and the remaining workers are kept free for flushes.
+		reads, reserved := s.reads, false
+		var recheck <-chan time.Time
+		if s.saturated() {
+			if reserved = s.activeReads.CompareAndSwap(0, 1); !reserved {
+				reads = nil
+				recheck = time.After(recheckInterval)
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case o := <-s.writes:
+			if reserved {
+				s.activeReads.Add(-1)
+			}
+			s.execute(o, true)
+		case o := <-reads:
+			if !reserved {
+				s.activeReads.Add(1)
+			}
+			s.execute(o, false)
+			s.activeReads.Add(-1)
+		case <-recheck:
+		}
+	}
+}
+
+func (s *Scheduler) execute(o *op, write bool) {
+	start := time.Now()
+	err := s.run(o.do)
+
+	s.metrics.l.Lock()
+	if write {
+		s.metrics.flush = Smooth(s.metrics.flush, time.Since(o.queued))
+	} else {
+		s.metrics.queue = Smooth(s.metrics.queue, start.Sub(o.queued))
+	}
+	s.metrics.l.Unlock()
+
+	o.done <- err
+}
+
+// run executes do, once fewer operations than the cap on open files are in
+// progress. While the process has no file descriptors left the operation is
+// retried with a backoff instead of failing, which holds back the queued
+// operations until descriptors are released again.
+func (s *Scheduler) run(do func() error) error {
+	if s.files != nil {
+		select {
+		case <-s.stop:
+			return ErrSchedulerClosed
+		case s.files <- struct{}{}:
+		}
+		defer func() { <-s.files }()
+	}
+
+	delay := fdRetryMin
+	for {
+		err := do()
+		if !TooManyOpenFiles(err) {
+			return err
+		}
+		s.fdRetries.Add(1)
+		select {
+		case <-s.stop:
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, fdRetryMax)
+	}
+}
+
+func (s *Scheduler) measureThroughput() {
+	defer s.wg.Done()
+	tick := time.NewTicker(throughputTick)
+	defer tick.Stop()
+
+	last := int64(0)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+			current := s.readBytes.Load()
+			s.metrics.l.Lock()
+			s.metrics.readThroughput = current - last
+			s.metrics.l.Unlock()
+			last = current
+		}
+	}
+}
+
+type scheduled struct {
+	scheduler *Scheduler
+	backend   Storage
+}
+
+func (s *scheduled) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	if l := s.scheduler.limiter; l != nil {
+		l.wait(int64(length))
+	}
+
+	var b []byte
+	err := s.scheduler.submit(s.scheduler.reads, func() error {
+		var err error
+		b, err = s.backend.ReadBlock(piece, begin, length)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.scheduler.readBytes.Add(int64(len(b)))
+	return b, nil
+}
+
+func (s *scheduled) WritePiece(piece int64, data []byte) error {
+	return s.scheduler.submit(s.scheduler.writes, func() error {
+		return s.backend.WritePiece(piece, data)
+	})
+}
+
+// Sync is queued with the writes, so that it syncs the pieces written before.
+func (s *scheduled) Sync() error {
+	return s.scheduler.submit(s.scheduler.writes, func() error {
+		return Sync(s.backend)
+	})
+}
+
+// limiter is a token bucket that allows a burst of one second.
+type limiter struct {
+	l      sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(bytesPerSecond int64) *limiter {
+	return &limiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// wait blocks until n bytes can be consumed.
+func (l *limiter) wait(n int64) {
+	l.l.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.l.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+func (s *scheduled) readBack(piece int64, length uint32, read func(data []byte)) error {
+	return s.scheduler.submit(s.scheduler.writes, func() error {
+		b, err := s.backend.ReadBlock(piece, 0, length)
+		if err != nil {
+			return err
+		}
+		read(b)
+		return nil
+	})
+}
@@ -0,0 +1,184 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_FlushesPreemptReads(t *testing.T) {
+	const readDelay = 100 * time.Millisecond
+
+	s := storage.NewScheduler(storage.WithWorkers(2), storage.WithSaturationLatency(20*time.Millisecond))
+	defer s.Close()
+
+	mem := storage.NewMemory()
+	assert.NoError(t, mem.WritePiece(0, bytes.Repeat([]byte{0x1}, 1024)))
+	st := s.Wrap(storagetest.NewFlakyStorage(mem, 1, storagetest.WithReadFaults(storagetest.Faults{Latency: readDelay})))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_, err := st.ReadBlock(0, 0, 16)
+					assert.NoError(t, err)
+				}
+			}
+		}()
+	}
+
+	assert.Eventually(t, func() bool { return s.Stats().Saturated }, 5*time.Second, 10*time.Millisecond)
+
+	for i := range 5 {
+		start := time.Now()
+		assert.NoError(t, st.WritePiece(int64(i+1), []byte{0x2}))
+		assert.Less(t, time.Since(start), readDelay/2, "flush %d was delayed behind reads", i)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	assert.Less(t, s.Stats().FlushLatency, readDelay/2)
+}
+
+func TestScheduler_ReadLimit(t *testing.T) {
+	const limit = 64 * 1024
+
+	s := storage.NewScheduler(storage.WithReadLimit(limit))
+	defer s.Close()
+
+	mem := storage.NewMemory()
+	assert.NoError(t, mem.WritePiece(0, make([]byte, limit)))
+	st := s.Wrap(mem)
+
+	// the first second worth of data is allowed as a burst.
+	start := time.Now()
+	for range 6 {
+		_, err := st.ReadBlock(0, 0, limit/4)
+		assert.NoError(t, err)
+	}
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+
+	assert.Eventually(t, func() bool { return s.Stats().ReadThroughput > 0 }, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestScheduler_ReadBackNotLimited(t *testing.T) {
+	const limit = 1024
+
+	s := storage.NewScheduler(storage.WithReadLimit(limit))
+	defer s.Close()
+
+	st := s.Wrap(storage.NewMemory())
+	data := bytes.Repeat([]byte{0x7}, 4*limit)
+	assert.NoError(t, st.WritePiece(0, data))
+
+	start := time.Now()
+	for range 4 {
+		b, err := storage.ReadBack(st, 0, uint32(len(data)))
+		assert.NoError(t, err)
+		assert.Equal(t, data, b)
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Zero(t, s.Stats().QueueLatency, "read backs are not accounted as reads")
+
+	digest := sha1.Sum(data)
+	ok, err := storage.VerifyBack(st, 0, uint32(len(data)), digest[:])
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = storage.VerifyBack(st, 0, uint32(len(data)), make([]byte, sha1.Size))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestScheduler_Closed(t *testing.T) {
+	s := storage.NewScheduler()
+	st := s.Wrap(storage.NewMemory())
+	s.Close()
+
+	assert.ErrorIs(t, st.WritePiece(0, []byte{0x1}), storage.ErrSchedulerClosed)
+}
+
+// concurrentStorage measures the reads executed concurrently.
+type concurrentStorage struct {
+	storage.Storage
+	running, peak atomic.Int64
+}
+
+func (s *concurrentStorage) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	for p := s.peak.Load(); n > p && !s.peak.CompareAndSwap(p, n); p = s.peak.Load() {
+	}
+	time.Sleep(5 * time.Millisecond)
+	return s.Storage.ReadBlock(piece, begin, length)
+}
+
+func TestScheduler_MaxOpenFiles(t *testing.T) {
+	s := storage.NewScheduler(storage.WithWorkers(8), storage.WithMaxOpenFiles(2))
+	defer s.Close()
+
+	mem := storage.NewMemory()
+	assert.NoError(t, mem.WritePiece(0, []byte{0x1}))
+	backend := &concurrentStorage{Storage: mem}
+	st := s.Wrap(backend)
+
+	var wg sync.WaitGroup
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := st.ReadBlock(0, 0, 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Positive(t, backend.peak.Load())
+	assert.LessOrEqual(t, backend.peak.Load(), int64(2))
+}
+
+func TestScheduler_RetriesWithoutFileDescriptors(t *testing.T) {
+	s := storage.NewScheduler()
+	defer s.Close()
+
+	mem := storage.NewMemory()
+	flaky := storagetest.NewFlakyStorage(mem, 1,
+		storagetest.WithReadFaults(storagetest.Faults{ErrorRate: 0.5, Err: syscall.EMFILE}),
+		storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 0.5, Err: syscall.ENFILE}),
+	)
+	st := s.Wrap(flaky)
+
+	for i := range 10 {
+		assert.NoError(t, st.WritePiece(int64(i), []byte{byte(i)}), "write of piece %d failed", i)
+	}
+	for i := range 10 {
+		b, err := st.ReadBlock(int64(i), 0, 1)
+		assert.NoError(t, err, "read of piece %d failed", i)
+		assert.Equal(t, []byte{byte(i)}, b)
+	}
+
+	reads, writes := flaky.Injected()
+	assert.Positive(t, reads)
+	assert.Positive(t, writes)
+	assert.Equal(t, int64(reads+writes), s.Stats().FDRetries)
+
+	// other errors are not retried.
+	failing := s.Wrap(storagetest.NewFlakyStorage(mem, 1, storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 1})))
+	assert.ErrorIs(t, failing.WritePiece(0, []byte{0}), storagetest.ErrInjected)
+}
@@ -0,0 +1,261 @@
+// Package storage persists the verified pieces of a torrent
+// and serves the blocks requested by other peers.
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// Storage persists the pieces of a single torrent.
+type Storage interface {
+	// ReadBlock reads length bytes starting at offset begin within the piece.
+	ReadBlock(piece int64, begin, length uint32) ([]byte, error)
+	// WritePiece persists the data of a verified piece.
+	WritePiece(piece int64, data []byte) error
+}
+
+// Syncer is implemented by storages whose written pieces are not durable
+// until they are synced, e.g. as they may still be in the page cache.
+type Syncer interface {
+	// Sync makes all pieces written so far durable.
+	Sync() error
+}
+
+// Sync makes the pieces written to s so far durable, if s is a Syncer.
+// Storages that are not persist their pieces right away or never.
+func Sync(s Storage) error {
+	if s, ok := s.(Syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+var (
+	// ErrInvalidBlock is returned when a block outside of a stored piece is read.
+	ErrInvalidBlock = errors.New("invalid block")
+	// ErrSchedulerClosed is returned for operations issued after the Scheduler was closed.
+	ErrSchedulerClosed = errors.New("disk scheduler closed")
+)
+
+// TooManyOpenFiles reports whether err was caused by the process
+// or the system running out of file descriptors.
+func TooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// ReadBack reads the piece of the given length back from s, to verify
+// that it was persisted as written. If s was wrapped by a Scheduler the
+// read is queued together with the writes, so that it is neither rate
+// limited nor delayed by the reads serving uploads.
+func ReadBack(s Storage, piece int64, length uint32) ([]byte, error) {
+	var b []byte
+	err := readBack(s, piece, length, func(data []byte) { b = data })
+	return b, err
+}
+
+// VerifyBack reads the piece back like ReadBack and reports whether its
+// SHA-1 digest matches hash. If s was wrapped by a Scheduler the digest is
+// computed by the same queued operation, so that the caller is not kept
+// busy hashing the piece.
+func VerifyBack(s Storage, piece int64, length uint32, hash []byte) (bool, error) {
+	var ok bool
+	err := readBack(s, piece, length, func(data []byte) {
+		digest := sha1.Sum(data)
+		ok = bytes.Equal(digest[:], hash)
+	})
+	return ok && err == nil, err
+}
+
+// readBack reads the piece back and passes it to read.
+func readBack(s Storage, piece int64, length uint32, read func(data []byte)) error {
+	if r, ok := s.(interface {
+		readBack(piece int64, length uint32, read func(data []byte)) error
+	}); ok {
+		return r.readBack(piece, length, read)
+	}
+	data, err := s.ReadBlock(piece, 0, length)
+	if err != nil {
+		return err
+	}
+	read(data)
+	return nil
+}
+
+// checkBlock validates that the block is within a piece of the given size.
+func checkBlock(size int64, begin, length uint32) error {
+	if int64(begin) >= size {
+		return fmt.Errorf("%w: offset within piece larger than piece size", ErrInvalidBlock)
+	}
+	if int64(begin)+int64(length) > size {
+		return fmt.Errorf("%w: offset + length tries to request larger block than possible", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// PieceFiles stores each piece in a separate file named <index>.bin
+// within a directory, which is created on the first write. A piece is
+// written to a temporary file that replaces the file of the piece once
+// complete, so that the file of a piece is never left half written. The
+// files are only made durable by Sync, which syncs all of them at once.
+type PieceFiles struct {
+	// l guards dir, operations hold a read lock
+	// so that they do not interleave with Move.
+	l   sync.RWMutex
+	dir string
+
+	// unsynced are the names of the piece files written since the last Sync.
+	syncL    sync.Mutex
+	unsynced []string
+
+	// create and syncFile are replaced in tests to inject failures.
+	create   func(dir, pattern string) (pieceFile, error)
+	syncFile func(name string) error
+}
+
+// pieceFile is the temporary file a piece is written to.
+type pieceFile interface {
+	io.WriterAt
+	Name() string
+	Close() error
+}
+
+func NewPieceFiles(dir string) *PieceFiles {
+	return &PieceFiles{
+		dir:      dir,
+		create:   func(dir, pattern string) (pieceFile, error) { return os.CreateTemp(dir, pattern) },
+		syncFile: syncFile,
+	}
+}
+
+func (s *PieceFiles) path(piece int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%v.bin", piece))
+}
+
+// Move moves the directory of the pieces, including any other files
+// within it, to dst which must not exist yet. The pieces are read from
+// and written to dst afterwards. A rename is attempted first, if dst is
+// on a different file system the directory is copied and removed once
+// the copy was synced. The original directory is left intact on failure.
+func (s *PieceFiles) Move(dst string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	// the unsynced files are tracked by their path within the directory.
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := moveDir(s.dir, dst); err != nil {
+		return err
+	}
+	s.dir = dst
+	return nil
+}
+
+// Stat returns the metadata of the file of the piece.
+func (s *PieceFiles) Stat(piece int64) (os.FileInfo, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return os.Stat(s.path(piece))
+}
+
+func (s *PieceFiles) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	f, err := os.Open(s.path(piece))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open piece %v: %w", piece, err)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat piece %v: %w", piece, err)
+	}
+	if err := checkBlock(st.Size(), begin, length); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, length)
+	if _, err := f.ReadAt(b, int64(begin)); err != nil {
+		return nil, fmt.Errorf("failed to read piece %v: %w", piece, err)
+	}
+	return b, nil
+}
+
+func (s *PieceFiles) WritePiece(piece int64, data []byte) error {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := s.create(s.dir, fmt.Sprintf("%v.bin.*.tmp", piece))
+	if err != nil {
+		return fmt.Errorf("failed to create piece %v: %w", piece, err)
+	}
+	_, err = f.WriteAt(data, 0)
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(piece))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write piece %v: %w", piece, err)
+	}
+
+	s.syncL.Lock()
+	s.unsynced = append(s.unsynced, s.path(piece))
+	s.syncL.Unlock()
+	return nil
+}
+
+// Sync syncs the files of the pieces written since the last Sync and the
+// directory holding them, after which the pieces survive a system crash.
+func (s *PieceFiles) Sync() error {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.sync()
+}
+
+func (s *PieceFiles) sync() error {
+	s.syncL.Lock()
+	defer s.syncL.Unlock()
+	if len(s.unsynced) == 0 {
+		return nil
+	}
+
+	slices.Sort(s.unsynced)
+	s.unsynced = slices.Compact(s.unsynced)
+	for i, name := range s.unsynced {
+		if err := s.syncFile(name); err != nil {
+			s.unsynced = s.unsynced[i:]
+			return fmt.Errorf("failed to sync %s: %w", name, err)
+		}
+	}
+	// the renames are only durable once the directory is synced.
+	if err := s.syncFile(s.dir); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", s.dir, err)
+	}
+	s.unsynced = nil
+	return nil
+}
+
+// syncFile syncs the file or directory at name.
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
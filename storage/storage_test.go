@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_Blocks(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"piece-files": func(t *testing.T) Storage { return NewPieceFiles(filepath.Join(t.TempDir(), "pieces")) },
+		"memory":      func(t *testing.T) Storage { return NewMemory() },
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := backend(t)
+			assert.NoError(t, s.WritePiece(3, []byte{0x1, 0x2, 0x3, 0x4}))
+
+			b, err := s.ReadBlock(3, 1, 2)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte{0x2, 0x3}, b)
+
+			_, err = s.ReadBlock(3, 4, 1)
+			assert.ErrorIs(t, err, ErrInvalidBlock)
+			_, err = s.ReadBlock(3, 2, 3)
+			assert.ErrorIs(t, err, ErrInvalidBlock)
+
+			_, err = s.ReadBlock(1, 0, 1)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPieceFiles_Move(t *testing.T) {
+	moves := map[string]func(src, dst string) error{
+		"rename": moveDir,
+		"copy":   copyMove,
+	}
+	for name, move := range moves {
+		t.Run(name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "pieces")
+			s := NewPieceFiles(src)
+			assert.NoError(t, s.WritePiece(0, []byte{0x1, 0x2}))
+			assert.NoError(t, os.WriteFile(filepath.Join(src, "resume.json"), []byte("{}"), 0o644))
+
+			dst := filepath.Join(t.TempDir(), "library", "pieces")
+			assert.NoError(t, move(src, dst))
+
+			_, err := os.Stat(src)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			b, err := os.ReadFile(filepath.Join(dst, "resume.json"))
+			assert.NoError(t, err)
+			assert.Equal(t, "{}", string(b))
+			entries, err := os.ReadDir(filepath.Dir(dst))
+			assert.NoError(t, err)
+			assert.Len(t, entries, 1, "no partial copy is left behind")
+		})
+	}
+
+	src := filepath.Join(t.TempDir(), "pieces")
+	s := NewPieceFiles(src)
+	assert.NoError(t, s.WritePiece(0, []byte{0x1, 0x2}))
+
+	dst := t.TempDir()
+	assert.ErrorIs(t, s.Move(dst), ErrDestinationExists)
+	b, err := s.ReadBlock(0, 0, 2)
+	assert.NoError(t, err, "the original data is intact")
+	assert.Equal(t, []byte{0x1, 0x2}, b)
+
+	dst = filepath.Join(dst, "moved")
+	assert.NoError(t, s.Move(dst))
+	b, err = s.ReadBlock(0, 0, 2)
+	assert.NoError(t, err, "the pieces are served from the new location")
+	assert.Equal(t, []byte{0x1, 0x2}, b)
+	assert.NoError(t, s.WritePiece(1, []byte{0x3}))
+	_, err = os.Stat(filepath.Join(dst, "1.bin"))
+	assert.NoError(t, err)
+}
+
+func TestTooManyOpenFiles(t *testing.T) {
+	_, err := os.Open(filepath.Join(t.TempDir(), "missing"))
+	assert.False(t, TooManyOpenFiles(err))
+	assert.False(t, TooManyOpenFiles(nil))
+	assert.True(t, TooManyOpenFiles(&os.PathError{Op: "open", Path: "0.bin", Err: syscall.EMFILE}))
+	assert.True(t, TooManyOpenFiles(fmt.Errorf("failed to open piece: %w", syscall.ENFILE)))
+}
@@ -0,0 +1,26 @@
+package storagetest_test
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/Despire/tinytorrent/storage/storagetest"
+)
+
+func ExampleFlakyStorage() {
+	s := storagetest.NewFlakyStorage(storage.NewMemory(), 42,
+		storagetest.WithWriteFaults(storagetest.Faults{ErrorRate: 0.5}),
+	)
+
+	failed := 0
+	for i := range 100 {
+		if err := s.WritePiece(int64(i), []byte{0x1}); errors.Is(err, storagetest.ErrInjected) {
+			failed++
+		}
+	}
+
+	_, writes := s.Injected()
+	fmt.Println(failed == writes, failed > 0 && failed < 100)
+	// Output: true true
+}
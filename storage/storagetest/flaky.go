@@ -0,0 +1,142 @@
+// Package storagetest provides fault injecting storages
+// for testing code built on top of the storage package.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+)
+
+// ErrInjected is wrapped by every error injected by FlakyStorage.
+var ErrInjected = errors.New("injected storage fault")
+
+// Faults describe the faults injected into a single kind of operation.
+type Faults struct {
+	// ErrorRate is the probability, between 0 and 1,
+	// with which an operation fails.
+	ErrorRate float64
+	// Latency is added to every operation before it is executed.
+	Latency time.Duration
+	// Err is wrapped by the injected errors in addition to ErrInjected,
+	// e.g. syscall.EMFILE to simulate running out of file descriptors.
+	Err error
+}
+
+// err returns the error of a failed operation.
+func (f Faults) err() error {
+	if f.Err == nil {
+		return ErrInjected
+	}
+	return fmt.Errorf("%w: %w", ErrInjected, f.Err)
+}
+
+// Option configures a FlakyStorage.
+type Option func(s *FlakyStorage)
+
+// WithReadFaults injects the faults into ReadBlock.
+func WithReadFaults(f Faults) Option {
+	return func(s *FlakyStorage) {
+		s.read = f
+	}
+}
+
+// WithWriteFaults injects the faults into WritePiece.
+func WithWriteFaults(f Faults) Option {
+	return func(s *FlakyStorage) {
+		s.write = f
+	}
+}
+
+// WithWriteCorruption silently flips a byte of the data passed to
+// WritePiece with probability rate, between 0 and 1. The corrupted
+// write is reported as successful, as a faulty disk would.
+func WithWriteCorruption(rate float64) Option {
+	return func(s *FlakyStorage) {
+		s.corruptRate = rate
+	}
+}
+
+// FlakyStorage wraps a storage and injects faults into its operations.
+// The faults are chosen by a pseudo random generator, the same seed and
+// the same sequence of operations always inject the same faults. A failed
+// operation never reaches the wrapped storage, i.e. a failed write leaves
+// the previous data of the piece intact.
+type FlakyStorage struct {
+	backend     storage.Storage
+	read, write Faults
+	corruptRate float64
+
+	l         sync.Mutex
+	rng       *rand.Rand
+	injected  struct{ reads, writes int }
+	corrupted int
+}
+
+func NewFlakyStorage(backend storage.Storage, seed uint64, opts ...Option) *FlakyStorage {
+	s := &FlakyStorage{
+		backend: backend,
+		rng:     rand.New(rand.NewPCG(seed, seed)),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// fail decides whether the next operation fails.
+func (s *FlakyStorage) fail(f Faults, counter *int) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if f.ErrorRate <= 0 || s.rng.Float64() >= f.ErrorRate {
+		return false
+	}
+	*counter++
+	return true
+}
+
+func (s *FlakyStorage) ReadBlock(piece int64, begin, length uint32) ([]byte, error) {
+	time.Sleep(s.read.Latency)
+	if s.fail(s.read, &s.injected.reads) {
+		return nil, fmt.Errorf("failed to read block of piece %v: %w", piece, s.read.err())
+	}
+	return s.backend.ReadBlock(piece, begin, length)
+}
+
+func (s *FlakyStorage) WritePiece(piece int64, data []byte) error {
+	time.Sleep(s.write.Latency)
+	if s.fail(s.write, &s.injected.writes) {
+		return fmt.Errorf("failed to write piece %v: %w", piece, s.write.err())
+	}
+	if len(data) > 0 && s.fail(Faults{ErrorRate: s.corruptRate}, &s.corrupted) {
+		s.l.Lock()
+		i := s.rng.IntN(len(data))
+		s.l.Unlock()
+
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0xFF
+		data = corrupted
+	}
+	return s.backend.WritePiece(piece, data)
+}
+
+// Sync syncs the wrapped storage, if it is a storage.Syncer.
+func (s *FlakyStorage) Sync() error { return storage.Sync(s.backend) }
+
+// Corrupted returns the number of writes that were silently corrupted.
+func (s *FlakyStorage) Corrupted() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.corrupted
+}
+
+// Injected returns the number of reads and writes that failed due to injected faults.
+func (s *FlakyStorage) Injected() (reads, writes int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.injected.reads, s.injected.writes
+}
@@ -0,0 +1,56 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Despire/tinytorrent/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlakyStorage_Deterministic(t *testing.T) {
+	run := func(seed uint64) []bool {
+		s := NewFlakyStorage(storage.NewMemory(), seed, WithWriteFaults(Faults{ErrorRate: 0.3}))
+		var out []bool
+		for i := range 50 {
+			out = append(out, s.WritePiece(int64(i), []byte{0x1}) != nil)
+		}
+		return out
+	}
+	assert.Equal(t, run(7), run(7))
+	assert.NotEqual(t, run(7), run(8))
+}
+
+func TestFlakyStorage_FailedWriteKeepsData(t *testing.T) {
+	s := NewFlakyStorage(storage.NewMemory(), 1)
+	assert.NoError(t, s.WritePiece(0, []byte{0x1}))
+
+	s.write = Faults{ErrorRate: 1}
+	assert.ErrorIs(t, s.WritePiece(0, []byte{0x2}), ErrInjected)
+
+	b, err := s.ReadBlock(0, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1}, b)
+}
+
+func TestFlakyStorage_Latency(t *testing.T) {
+	s := NewFlakyStorage(storage.NewMemory(), 1, WithReadFaults(Faults{Latency: 20 * time.Millisecond}))
+	assert.NoError(t, s.WritePiece(0, []byte{0x1}))
+
+	start := time.Now()
+	_, err := s.ReadBlock(0, 0, 1)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestFlakyStorage_WriteCorruption(t *testing.T) {
+	s := NewFlakyStorage(storage.NewMemory(), 1, WithWriteCorruption(1))
+	data := []byte{0x1, 0x2, 0x3}
+	assert.NoError(t, s.WritePiece(0, data))
+	assert.Equal(t, []byte{0x1, 0x2, 0x3}, data, "passed data must not be modified")
+
+	b, err := s.ReadBlock(0, 0, 3)
+	assert.NoError(t, err)
+	assert.NotEqual(t, data, b)
+	assert.Equal(t, 1, s.Corrupted())
+}
@@ -0,0 +1,97 @@
+package torrent
+
+import (
+	"slices"
+	"time"
+)
+
+// Description is the metadata of a torrent as shown to users, derived
+// from the metainfo file alone, see Describe.
+type Description struct {
+	Name           string
+	InfoHash       string
+	InfoHashBase32 string
+	PieceLength    int64
+	Pieces         int64
+	// Size is the number of bytes of the pieces, including padding files.
+	Size    int64
+	Private bool
+	// Tiers are the trackers grouped by tier, see Tiers.
+	Tiers    [][]string
+	WebSeeds []string
+	Nodes    []string
+	// Files are the files of the torrent, see Files.
+	Files []FileInfo
+
+	// Optional, nil or empty if the torrent does not set them. The
+	// creation date is kept as found, however implausible it is.
+	CreationDate *time.Time
+	Comment      string
+	CreatedBy    string
+	Encoding     string
+}
+
+// Describe returns the description of the torrent.
+func (m *MetaInfoFile) Describe() Description {
+	d := Description{
+		Name:           m.Name(),
+		InfoHash:       m.HexHash(),
+		InfoHashBase32: m.Base32Hash(),
+		PieceLength:    m.PieceLength,
+		Pieces:         m.NumPieces(),
+		Size:           m.BytesToDownload(),
+		Private:        m.Private != nil && *m.Private == 1,
+		Tiers:          m.Tiers(),
+		WebSeeds:       slices.Clone(m.UrlList),
+		Nodes:          slices.Clone(m.Nodes),
+		Files:          m.Files(),
+		CreationDate:   m.CreationDate,
+	}
+	if m.Comment != nil {
+		d.Comment = *m.Comment
+	}
+	if m.CreatedBy != nil {
+		d.CreatedBy = *m.CreatedBy
+	}
+	if m.Encoding != nil {
+		d.Encoding = *m.Encoding
+	}
+	return d
+}
+
+// Tiers returns the trackers grouped by tier, see BEP12. The announce
+// URL is the only tier of torrents without an announce list.
+func (m *MetaInfoFile) Tiers() [][]string {
+	switch {
+	case len(m.AnnounceTiers) > 0:
+		out := make([][]string, len(m.AnnounceTiers))
+		for i, tier := range m.AnnounceTiers {
+			out[i] = slices.Clone(tier)
+		}
+		return out
+	case len(m.AnnounceList) > 0:
+		var out [][]string
+		for _, tr := range m.AnnounceList {
+			out = append(out, []string{tr})
+		}
+		return out
+	case m.Announce != "":
+		return [][]string{{m.Announce}}
+	default:
+		return nil
+	}
+}
+
+// Files returns the files of the torrent, including padding files, in
+// the order of the pieces. Their paths are relative to the directory
+// of a multi-file torrent; a single-file torrent has one named by Name.
+func (m *MetaInfoFile) Files() []FileInfo {
+	switch {
+	case m.InfoSingleFile != nil:
+		return []FileInfo{{Length: m.InfoSingleFile.Length, Path: m.InfoSingleFile.Name, Md5Sum: m.InfoSingleFile.Md5sum}}
+	case m.InfoMultiFile != nil:
+		return slices.Clone(m.InfoMultiFile.Files)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetaInfoFile_Describe(t *testing.T) {
+	b, err := os.ReadFile("./test_data/album.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+
+	d := got.Describe()
+	// the creation date is in milliseconds, which is kept as found.
+	created := time.Unix(1725106229000, 0)
+	want := Description{
+		Name:           "album",
+		InfoHash:       got.HexHash(),
+		InfoHashBase32: got.Base32Hash(),
+		PieceLength:    16,
+		Pieces:         1,
+		Size:           16,
+		Private:        true,
+		Tiers: [][]string{
+			{"http://a.example/announce", "http://b.example/announce"},
+			{"udp://c.example:6969"},
+		},
+		WebSeeds: []string{"http://seed.example/"},
+		Files: []FileInfo{
+			{Length: 5, Path: filepath.Join("disc 2", "b.ogg")},
+			{Length: 3, Path: "a.ogg"},
+			{Length: 6, Path: filepath.Join(".pad", "6"), Attr: "p"},
+			{Length: 2, Path: filepath.Join("disc 1", "c.ogg")},
+		},
+		CreationDate: &created,
+		Comment:      "hello",
+		CreatedBy:    "tinytorrent",
+		Encoding:     "UTF-8",
+	}
+	if diff := cmp.Diff(d, want); diff != "" {
+		t.Errorf("Describe() = %v", diff)
+	}
+}
+
+func TestMetaInfoFile_Tiers(t *testing.T) {
+	tests := []struct {
+		name string
+		mi   MetaInfoFile
+		want [][]string
+	}{
+		{name: "announce only", mi: MetaInfoFile{Announce: "http://a"}, want: [][]string{{"http://a"}}},
+		{name: "announce list replaces announce", mi: MetaInfoFile{
+			Announce:      "http://a",
+			AnnounceList:  []string{"http://b", "http://c"},
+			AnnounceTiers: [][]string{{"http://b", "http://c"}},
+		}, want: [][]string{{"http://b", "http://c"}}},
+		{name: "flat announce list", mi: MetaInfoFile{AnnounceList: []string{"http://b", "http://c"}}, want: [][]string{{"http://b"}, {"http://c"}}},
+		{name: "trackerless", mi: MetaInfoFile{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.mi.Tiers(), tt.want); diff != "" {
+				t.Errorf("Tiers() = %v", diff)
+			}
+		})
+	}
+}
+
+func TestMetaInfoFile_FilesOfSingleFile(t *testing.T) {
+	b, err := os.ReadFile("./test_data/debian.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+	want := []FileInfo{{Length: got.BytesToDownload(), Path: "debian-12.7.0-arm64-netinst.iso"}}
+	if diff := cmp.Diff(got.Files(), want); diff != "" {
+		t.Errorf("Files() = %v", diff)
+	}
+}
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// DefaultMaxSize is the size of the largest torrent file Load accepts,
+// unless WithMaxSize says otherwise. Torrents of many small files are
+// a few MiB large, anything larger is more likely hostile input.
+const DefaultMaxSize = 10 << 20
+
+// ErrTooLarge is returned by Load and LoadBytes for torrent
+// files larger than the maximum size, see WithMaxSize.
+var ErrTooLarge = errors.New("torrent file too large")
+
+type loadOptions struct {
+	maxSize int64
+}
+
+// LoadOption configures Load and LoadBytes.
+type LoadOption func(*loadOptions)
+
+// WithMaxSize sets the size of the largest torrent file accepted,
+// which defaults to DefaultMaxSize. A size of zero or less does
+// not limit it.
+func WithMaxSize(n int64) LoadOption {
+	return func(o *loadOptions) { o.maxSize = n }
+}
+
+func loadOptionsOf(opts []LoadOption) loadOptions {
+	o := loadOptions{maxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Load decodes the torrent file read from r. At most the maximum size
+// of a torrent file is read, larger ones fail with ErrTooLarge.
+func Load(r io.Reader, opts ...LoadOption) (*MetaInfoFile, error) {
+	o := loadOptionsOf(opts)
+	if o.maxSize > 0 {
+		// read one more byte to tell a file of the maximum size from a larger one.
+		r = io.LimitReader(r, o.maxSize+1)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if o.maxSize > 0 && int64(len(raw)) > o.maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, o.maxSize)
+	}
+	return parse(raw)
+}
+
+// LoadBytes decodes the torrent file b, see Load. The MetaInfoFile
+// does not retain b, which may be modified afterwards.
+func LoadBytes(b []byte, opts ...LoadOption) (*MetaInfoFile, error) {
+	o := loadOptionsOf(opts)
+	if o.maxSize > 0 && int64(len(b)) > o.maxSize {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(b), o.maxSize)
+	}
+	return parse(slices.Clone(b))
+}
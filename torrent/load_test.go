@@ -0,0 +1,65 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Despire/tinytorrent/bencoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_MaxSize(t *testing.T) {
+	b, err := os.ReadFile("./test_data/debian.torrent")
+	assert.NoError(t, err)
+	size := int64(len(b))
+
+	for _, n := range []int64{size, 0, -1} {
+		mi, err := Load(bytes.NewReader(b), WithMaxSize(n))
+		assert.NoError(t, err)
+		assert.Equal(t, b, mi.Raw)
+
+		mi, err = LoadBytes(b, WithMaxSize(n))
+		assert.NoError(t, err)
+		assert.Equal(t, b, mi.Raw)
+	}
+
+	_, err = Load(bytes.NewReader(b), WithMaxSize(size-1))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = LoadBytes(b, WithMaxSize(size-1))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// the default is far from being reached by real torrents.
+	_, err = Load(bytes.NewReader(b))
+	assert.NoError(t, err)
+	_, err = Load(strings.NewReader(strings.Repeat(" ", DefaultMaxSize+1)))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestLoadBytes_DoesNotRetainInput(t *testing.T) {
+	b, err := os.ReadFile("./test_data/debian.torrent")
+	assert.NoError(t, err)
+	mi, err := LoadBytes(b)
+	assert.NoError(t, err)
+
+	want := bytes.Clone(mi.Raw)
+	clear(b)
+	assert.Equal(t, want, mi.Raw)
+}
+
+func TestLoad_InfoBytes(t *testing.T) {
+	// the keys of the info dictionary are not sorted, which is hashed as is.
+	info := "d4:name5:a.bin6:lengthi1e12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "e"
+	raw := "d8:announce17:http://a/announce4:info" + info + "e"
+
+	mi, err := LoadBytes([]byte(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, info, string(mi.Metadata.Bytes))
+	assert.Equal(t, sha1.Sum([]byte(info)), mi.Metadata.Hash)
+
+	v, err := bencoding.Decode(strings.NewReader(info))
+	assert.NoError(t, err)
+	assert.NotEqual(t, sha1.Sum([]byte(v.Literal())), mi.Metadata.Hash, "the re-encoded info dictionary is sorted")
+}
@@ -0,0 +1,122 @@
+package torrent
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidMagnet is returned by ParseMagnet for links that are not
+// magnet links of a BitTorrent info hash.
+var ErrInvalidMagnet = errors.New("invalid magnet link")
+
+// btihPrefix prefixes the info hash in the exact topic of magnet links, see BEP9.
+const btihPrefix = "urn:btih:"
+
+// base32Hash is the RFC 4648 base32 encoding without padding info hashes are
+// encoded with. Being 20 bytes long, they are never padded anyway.
+var base32Hash = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// HexHash returns the lowercase hex encoding of the info hash.
+func (m *MetaInfoFile) HexHash() string { return hex.EncodeToString(m.Metadata.Hash[:]) }
+
+// Base32Hash returns the base32 encoding of the info hash, as found in older magnet links.
+func (m *MetaInfoFile) Base32Hash() string { return base32Hash.EncodeToString(m.Metadata.Hash[:]) }
+
+// Name returns the name of the file, or of the directory of a multi-file torrent.
+func (m *MetaInfoFile) Name() string {
+	switch {
+	case m.InfoSingleFile != nil:
+		return m.InfoSingleFile.Name
+	case m.InfoMultiFile != nil:
+		return m.InfoMultiFile.Name
+	default:
+		return ""
+	}
+}
+
+// Trackers returns the announce URL followed by those of
+// the announce list that differ from it, in their order.
+func (m *MetaInfoFile) Trackers() []string {
+	var out []string
+	for _, tr := range append([]string{m.Announce}, m.AnnounceList...) {
+		if tr != "" && !slices.Contains(out, tr) {
+			out = append(out, tr)
+		}
+	}
+	return out
+}
+
+// MagnetLink returns the magnet link of the torrent with the hex encoded
+// info hash and its name. If trackers is set, the link lists its trackers.
+func (m *MetaInfoFile) MagnetLink(trackers bool) string {
+	mg := Magnet{InfoHash: m.Metadata.Hash, Name: m.Name()}
+	if trackers {
+		mg.Trackers = m.Trackers()
+	}
+	return mg.String()
+}
+
+// Magnet is the torrent a magnet link refers to.
+type Magnet struct {
+	InfoHash [20]byte
+	// Name is the display name, empty if not set.
+	Name     string
+	Trackers []string
+}
+
+// String returns the magnet link with the hex encoded info hash.
+func (mg Magnet) String() string {
+	var b strings.Builder
+	b.WriteString("magnet:?xt=" + btihPrefix + hex.EncodeToString(mg.InfoHash[:]))
+	if mg.Name != "" {
+		b.WriteString("&dn=" + url.QueryEscape(mg.Name))
+	}
+	for _, tr := range mg.Trackers {
+		b.WriteString("&tr=" + url.QueryEscape(tr))
+	}
+	return b.String()
+}
+
+// ParseMagnet parses a magnet link of a BitTorrent info hash, encoded
+// either in hex or in base32.
+func ParseMagnet(link string) (*Magnet, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMagnet, err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("%w: scheme %q", ErrInvalidMagnet, u.Scheme)
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMagnet, err)
+	}
+
+	mg := &Magnet{Name: q.Get("dn"), Trackers: q["tr"]}
+	for _, xt := range q["xt"] {
+		hash, ok := strings.CutPrefix(strings.ToLower(xt), btihPrefix)
+		if !ok {
+			continue
+		}
+		var b []byte
+		switch len(hash) {
+		case hex.EncodedLen(len(mg.InfoHash)):
+			b, err = hex.DecodeString(hash)
+		case base32Hash.EncodedLen(len(mg.InfoHash)):
+			b, err = base32Hash.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("info hash %q has length %d", hash, len(hash))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMagnet, err)
+		}
+		copy(mg.InfoHash[:], b)
+		return mg, nil
+	}
+	return nil, fmt.Errorf("%w: no BitTorrent info hash", ErrInvalidMagnet)
+}
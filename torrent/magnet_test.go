@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"encoding/base32"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestTorrent(t *testing.T, name string) *MetaInfoFile {
+	t.Helper()
+	f, err := os.Open("./test_data/" + name)
+	assert.NoError(t, err)
+	defer f.Close()
+	mi, err := From(f)
+	assert.NoError(t, err)
+	return mi
+}
+
+func TestMetaInfoFile_Hashes(t *testing.T) {
+	mi := loadTestTorrent(t, "debian.torrent")
+	assert.Equal(t, "e37b64d85cf4aa93e0ec4aee2b44735b7cb63967", mi.HexHash())
+
+	b32 := mi.Base32Hash()
+	assert.Len(t, b32, 32)
+	assert.NotContains(t, b32, "=")
+	b, err := base32.StdEncoding.DecodeString(b32)
+	assert.NoError(t, err)
+	assert.Equal(t, mi.Metadata.Hash[:], b)
+}
+
+func TestMetaInfoFile_MagnetLink(t *testing.T) {
+	mi := loadTestTorrent(t, "ubuntu.torrent")
+	mi.InfoSingleFile.Name = "a b&c.iso"
+	mi.AnnounceList = append(mi.AnnounceList, mi.Announce, "http://tracker.example/announce?passkey=a&b=c d")
+
+	tests := []struct {
+		name     string
+		trackers bool
+		want     string
+		parsed   Magnet
+	}{
+		{
+			name: "without trackers",
+			want: "magnet:?xt=urn:btih:" + mi.HexHash() + "&dn=a+b%26c.iso",
+			parsed: Magnet{
+				InfoHash: mi.Metadata.Hash,
+				Name:     "a b&c.iso",
+			},
+		},
+		{
+			name:     "with escaped trackers",
+			trackers: true,
+			want: "magnet:?xt=urn:btih:" + mi.HexHash() + "&dn=a+b%26c.iso" +
+				"&tr=https%3A%2F%2Ftorrent.ubuntu.com%2Fannounce" +
+				"&tr=https%3A%2F%2Fipv6.torrent.ubuntu.com%2Fannounce" +
+				"&tr=http%3A%2F%2Ftracker.example%2Fannounce%3Fpasskey%3Da%26b%3Dc+d",
+			parsed: Magnet{
+				InfoHash: mi.Metadata.Hash,
+				Name:     "a b&c.iso",
+				Trackers: []string{
+					"https://torrent.ubuntu.com/announce",
+					"https://ipv6.torrent.ubuntu.com/announce",
+					"http://tracker.example/announce?passkey=a&b=c d",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := mi.MagnetLink(tt.trackers)
+			assert.Equal(t, tt.want, link)
+			got, err := ParseMagnet(link)
+			assert.NoError(t, err)
+			assert.Equal(t, &tt.parsed, got)
+		})
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	mi := loadTestTorrent(t, "debian.torrent")
+	want := &Magnet{InfoHash: mi.Metadata.Hash}
+
+	tests := []struct {
+		name    string
+		link    string
+		want    *Magnet
+		wantErr bool
+	}{
+		{name: "hex", link: "magnet:?xt=urn:btih:" + mi.HexHash(), want: want},
+		{name: "upper case hex", link: "magnet:?xt=urn:btih:" + strings.ToUpper(mi.HexHash()), want: want},
+		{name: "base32", link: "magnet:?xt=urn:btih:" + mi.Base32Hash(), want: want},
+		{name: "lower case base32", link: "magnet:?xt=urn:btih:" + strings.ToLower(mi.Base32Hash()), want: want},
+		{name: "other topics first", link: "magnet:?xt=urn:sha1:abc&xt=urn:btih:" + mi.HexHash(), want: want},
+		{name: "not a magnet link", link: "https://example.com/?xt=urn:btih:" + mi.HexHash(), wantErr: true},
+		{name: "no info hash", link: "magnet:?dn=name", wantErr: true},
+		{name: "short info hash", link: "magnet:?xt=urn:btih:abcd", wantErr: true},
+		{name: "invalid hex", link: "magnet:?xt=urn:btih:" + strings.Repeat("z", 40), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.link)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidMagnet)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
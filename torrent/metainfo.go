@@ -1,12 +1,16 @@
 package torrent
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Despire/tinytorrent/bencoding"
@@ -32,6 +36,10 @@ type (
 		// Optional.
 		// 32-character hex string corresponding to the MD5 sum of the file.
 		Md5Sum *string
+		// Optional.
+		// Attributes of the file, see IsPadding.
+		// BEP47: https://www.bittorrent.org/beps/bep_0047.html
+		Attr string
 	}
 
 	InfoMultiFile struct {
@@ -42,6 +50,11 @@ type (
 	}
 )
 
+// IsPadding reports whether the file is a padding file, which aligns the
+// next file to a piece boundary. Its bytes are zeros that are part of the
+// pieces, but the file is not meant to be stored.
+func (f FileInfo) IsPadding() bool { return strings.ContainsRune(f.Attr, 'p') }
+
 type Info struct {
 	*InfoSingleFile
 	*InfoMultiFile
@@ -50,6 +63,9 @@ type Info struct {
 		// Hash is the SHA1 Hash of the value of the info key in the torrent file.
 		// Use this when communicating with the tracker.
 		Hash [20]byte
+		// Bytes is the bencoded value of the info key as found in the
+		// torrent file, the metadata exchanged with peers, see BEP9.
+		Bytes []byte
 	}
 
 	// Number of bytes in each piece.
@@ -79,6 +95,13 @@ type MetaInfoFile struct {
 	UrlList []string
 	// This is an extention to the official specification, offering backwards-compatibility.
 	AnnounceList []string
+	// AnnounceTiers are the trackers of the announce-list grouped by
+	// their tier, in the order of the file. AnnounceList holds them flat.
+	// BEP12: https://www.bittorrent.org/beps/bep_0012.html
+	AnnounceTiers [][]string
+	// The host:port of DHT nodes to bootstrap from, included by trackerless torrents.
+	// BEP5: https://www.bittorrent.org/beps/bep_0005.html
+	Nodes []string
 	// The creation time of the torrent, in standard UNIX epoch format (seconds since 1-Jan-1970 00:00:00 UTC)
 	CreationDate *time.Time
 	// Free-form textual comments of the author.
@@ -87,6 +110,9 @@ type MetaInfoFile struct {
 	CreatedBy *string
 	// The string encoding format used to generate the pieces part of the info dictionary in the .torrent metafile.
 	Encoding *string
+
+	// Raw is the bencoded torrent file the MetaInfoFile was decoded from.
+	Raw []byte
 }
 
 func (m *MetaInfoFile) BytesToDownload() int64 {
@@ -104,6 +130,21 @@ func (m *MetaInfoFile) BytesToDownload() int64 {
 	}
 }
 
+// WantedBytes returns the number of bytes of the files of the torrent,
+// which excludes the padding files counted by BytesToDownload.
+func (m *MetaInfoFile) WantedBytes() int64 {
+	if m.InfoMultiFile == nil {
+		return m.BytesToDownload()
+	}
+	var total int64
+	for _, f := range m.InfoMultiFile.Files {
+		if !f.IsPadding() {
+			total += f.Length
+		}
+	}
+	return total
+}
+
 func (m *MetaInfoFile) NumPieces() int64 {
 	switch {
 	case m.InfoSingleFile != nil, m.InfoMultiFile != nil:
@@ -117,7 +158,14 @@ func (m *MetaInfoFile) NumPieces() int64 {
 	}
 }
 
-func (m *MetaInfoFile) PieceHash(piece uint32) []byte {
+// PieceSize returns the size of the piece, which is PieceLength for all
+// but the last piece, which holds the remaining bytes of the torrent.
+func (m *MetaInfoFile) PieceSize(piece int64) int64 {
+	start := piece * m.PieceLength
+	return min(start+m.PieceLength, m.BytesToDownload()) - start
+}
+
+func (m *MetaInfoFile) PieceHash(piece int64) []byte {
 	b, err := hex.DecodeString(m.Pieces)
 	if err != nil {
 		panic(err) // This should never happen as we always hexencode.
@@ -125,8 +173,12 @@ func (m *MetaInfoFile) PieceHash(piece uint32) []byte {
 	return b[piece*20 : piece*20+20]
 }
 
-func From(bencoded io.Reader) (*MetaInfoFile, error) {
-	v, err := bencoding.Decode(bencoded)
+// From decodes the torrent file read from bencoded, see Load.
+func From(bencoded io.Reader) (*MetaInfoFile, error) { return Load(bencoded) }
+
+// parse decodes the torrent file raw, which the MetaInfoFile retains.
+func parse(raw []byte) (*MetaInfoFile, error) {
+	v, err := bencoding.Decode(bytes.NewReader(raw))
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +189,7 @@ func From(bencoded io.Reader) (*MetaInfoFile, error) {
 
 	d := v.(*bencoding.Dictionary)
 
-	info := MetaInfoFile{}
+	info := MetaInfoFile{Raw: raw}
 
 	for k, v := range d.Dict {
 		if err := apply(k, v, &info); err != nil {
@@ -145,6 +197,17 @@ func From(bencoded io.Reader) (*MetaInfoFile, error) {
 		}
 	}
 
+	// the info hash is of the info dictionary as found in the file, which
+	// differs from the decoded one encoded again if it is not canonical.
+	b, ok, err := bencoding.RawValue(raw, "info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read 'info' dictionary: %w", err)
+	}
+	if ok {
+		info.Metadata.Bytes = b
+		info.Metadata.Hash = sha1.Sum(b)
+	}
+
 	if err := validate(&info); err != nil {
 		return nil, fmt.Errorf("failed to validate torrent file: %w", err)
 	}
@@ -160,8 +223,6 @@ func apply(key string, value bencoding.Value, info *MetaInfoFile) error {
 			return fmt.Errorf("expected 'Info' to be of type Dictionary but was %T", value)
 		}
 
-		info.Metadata.Hash = sha1.Sum([]byte(l.Literal()))
-
 		_, isMultiFile := l.Dict["files"]
 		if isMultiFile {
 			info.InfoMultiFile = new(InfoMultiFile)
@@ -194,13 +255,20 @@ func apply(key string, value bencoding.Value, info *MetaInfoFile) error {
 			case bencoding.ByteStringType:
 				addr := v.(*bencoding.ByteString)
 				info.AnnounceList = append(info.AnnounceList, string(*addr))
+				// a bare url is a tier of its own.
+				info.AnnounceTiers = append(info.AnnounceTiers, []string{string(*addr)})
 			case bencoding.ListType:
+				var tier []string
 				for _, v := range *v.(*bencoding.List) {
 					addr, ok := v.(*bencoding.ByteString)
 					if !ok {
 						return fmt.Errorf("expected list item inside announce-list to be of type ByteString but was %T", v)
 					}
 					info.AnnounceList = append(info.AnnounceList, string(*addr))
+					tier = append(tier, string(*addr))
+				}
+				if len(tier) > 0 {
+					info.AnnounceTiers = append(info.AnnounceTiers, tier)
 				}
 			default:
 				return fmt.Errorf("un-expected announce-list type %T", v)
@@ -209,9 +277,16 @@ func apply(key string, value bencoding.Value, info *MetaInfoFile) error {
 		}
 		return nil
 	case "url-list":
+		// BEP19 allows a single url instead of a list.
+		if addr, ok := value.(*bencoding.ByteString); ok {
+			if *addr != "" {
+				info.UrlList = append(info.UrlList, string(*addr))
+			}
+			return nil
+		}
 		l, ok := value.(*bencoding.List)
 		if !ok {
-			return fmt.Errorf("expected url-list to be of type []ByteString but was %T", value)
+			return fmt.Errorf("expected url-list to be of type []ByteString or ByteString but was %T", value)
 		}
 
 		for _, v := range *l {
@@ -222,6 +297,25 @@ func apply(key string, value bencoding.Value, info *MetaInfoFile) error {
 			info.UrlList = append(info.UrlList, string(*addr))
 		}
 		return nil
+	case "nodes":
+		// malformed entries are skipped, as the nodes are only a hint.
+		l, ok := value.(*bencoding.List)
+		if !ok {
+			return nil
+		}
+		if node, ok := parseNode(l); ok {
+			// a single pair instead of a list of pairs.
+			info.Nodes = append(info.Nodes, node)
+			return nil
+		}
+		for _, v := range *l {
+			if pair, ok := v.(*bencoding.List); ok {
+				if node, ok := parseNode(pair); ok {
+					info.Nodes = append(info.Nodes, node)
+				}
+			}
+		}
+		return nil
 	case "creation date":
 		l, ok := value.(*bencoding.Integer)
 		if !ok {
@@ -256,6 +350,22 @@ func apply(key string, value bencoding.Value, info *MetaInfoFile) error {
 	}
 }
 
+// parseNode returns the host:port of the [host, port] pair l.
+func parseNode(l *bencoding.List) (string, bool) {
+	if len(*l) != 2 {
+		return "", false
+	}
+	host, ok := (*l)[0].(*bencoding.ByteString)
+	if !ok || len(*host) == 0 {
+		return "", false
+	}
+	port, ok := (*l)[1].(*bencoding.Integer)
+	if !ok || *port <= 0 || *port > 65535 {
+		return "", false
+	}
+	return net.JoinHostPort(string(*host), strconv.FormatInt(int64(*port), 10)), true
+}
+
 func infoCommon(key string, value bencoding.Value, info *Info, isMultiFile bool) error {
 	switch key {
 	case "name":
@@ -304,12 +414,23 @@ func infoCommon(key string, value bencoding.Value, info *Info, isMultiFile bool)
 
 			fi := FileInfo{}
 
-			if l, ok := dict.Dict["length"]; ok {
-				l, ok := l.(*bencoding.Integer)
+			// zero is a valid length, so a missing one is reported here.
+			l, ok := dict.Dict["length"]
+			if !ok {
+				return errors.New("missing 'length' inside of 'Files'")
+			}
+			length, ok := l.(*bencoding.Integer)
+			if !ok {
+				return fmt.Errorf("expected 'Length' inside of 'Files' to be of type Integer but was %T", value)
+			}
+			fi.Length = int64(*length)
+
+			if a, ok := dict.Dict["attr"]; ok {
+				a, ok := a.(*bencoding.ByteString)
 				if !ok {
-					return fmt.Errorf("expected 'Length' inside of 'Files' to be of type Integer but was %T", value)
+					return fmt.Errorf("expected 'Attr' inside of 'Files' to be of type ByteString but was %T", value)
 				}
-				fi.Length = int64(*l)
+				fi.Attr = string(*a)
 			}
 
 			if s, ok := dict.Dict["md5sum"]; ok {
@@ -366,14 +487,35 @@ func infoCommon(key string, value bencoding.Value, info *Info, isMultiFile bool)
 	}
 }
 
+// MaxPieces is the number of pieces addressable by
+// the 32 bit piece index of the peer wire protocol.
+const MaxPieces = 1 << 32
+
+// MaxPieceLength is the length of the largest piece whose blocks are
+// addressable by the 32 bit offset of the peer wire protocol.
+const MaxPieceLength = 1 << 32
+
+func checkNumPieces(n int64) error {
+	if n > MaxPieces {
+		return fmt.Errorf("torrent has %d pieces, more than the %d addressable by peers", n, int64(MaxPieces))
+	}
+	return nil
+}
+
 func validate(i *MetaInfoFile) error {
-	if i.Announce == "" {
+	if i.Announce == "" && len(i.Nodes) == 0 {
+		// trackerless torrents find their peers in the DHT.
 		return errors.New("unspecified 'announce' in torrent file")
 	}
 	if i.InfoSingleFile == nil && i.InfoMultiFile == nil {
 		return errors.New("neither single file nor multi file mode specified")
 	}
-	if len(i.Info.Pieces) == 0 {
+	if i.PieceLength <= 0 || i.PieceLength > MaxPieceLength {
+		// pieces of any other length are downloaded, even if it is not a power of two.
+		return fmt.Errorf("invalid 'piece length' %d inside torrent file", i.PieceLength)
+	}
+	if len(i.Info.Pieces) == 0 && i.BytesToDownload() > 0 {
+		// a torrent of only empty files has no pieces.
 		return errors.New("missing 'pieces' inside torrent file")
 	}
 	h, err := hex.DecodeString(i.Info.Pieces)
@@ -383,6 +525,9 @@ func validate(i *MetaInfoFile) error {
 	if len(h)%20 != 0 {
 		return errors.New("invalid 'pieces' value inside torrent file")
 	}
+	if err := checkNumPieces(int64(len(h) / 20)); err != nil {
+		return err
+	}
 	if i.InfoSingleFile != nil {
 		if i.InfoSingleFile.Name == "" {
 			return errors.New("missing 'name' for single file torrent")
@@ -395,9 +540,12 @@ func validate(i *MetaInfoFile) error {
 		if i.InfoMultiFile.Name == "" {
 			return errors.New("missing directory 'name' for multi file torrent")
 		}
+		if len(i.InfoMultiFile.Files) == 0 {
+			return fmt.Errorf("missing 'files' inside %s for multi file torrent", i.InfoMultiFile.Name)
+		}
 		for _, f := range i.InfoMultiFile.Files {
-			if f.Length == 0 {
-				return fmt.Errorf("missing 'length' inside %s for multi file torrent", i.InfoMultiFile.Name)
+			if f.Length < 0 {
+				return fmt.Errorf("negative 'length' inside %s for multi file torrent", i.InfoMultiFile.Name)
 			}
 			if len(f.Path) == 0 {
 				return fmt.Errorf("missing 'Path' inside %s for multi file torrent", i.InfoMultiFile.Name)
@@ -2,12 +2,16 @@ package torrent
 
 import (
 	"bytes"
+	"encoding/hex"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestFrom(t *testing.T) {
@@ -34,7 +38,8 @@ func TestFrom(t *testing.T) {
 			want: &MetaInfoFile{
 				Info: Info{
 					Metadata: struct {
-						Hash [20]byte
+						Hash  [20]byte
+						Bytes []byte
 					}{
 						Hash: [20]byte{0xe3, 0x7b, 0x64, 0xd8, 0x5c, 0xf4, 0xaa, 0x93, 0xe0, 0xec, 0x4a, 0xee, 0x2b, 0x44, 0x73, 0x5b, 0x7c, 0xb6, 0x39, 0x67},
 					},
@@ -68,11 +73,215 @@ func TestFrom(t *testing.T) {
 				t.Errorf("From() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if diff := cmp.Diff(got, tt.want); diff != "" {
+			if diff := cmp.Diff(got, tt.want, cmpopts.IgnoreFields(MetaInfoFile{}, "Raw", "Metadata.Bytes")); diff != "" {
 				t.Errorf("From() = %v", diff)
 			}
 		})
 	}
 }
 
+func TestFrom_Raw(t *testing.T) {
+	b, err := os.ReadFile("./test_data/debian.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+	if !bytes.Equal(got.Raw, b) {
+		t.Errorf("From() Raw has %d bytes, want the %d bytes of the torrent file", len(got.Raw), len(b))
+	}
+}
+
 func ptrFor[T any](t T) *T { return &t }
+
+func TestFrom_UrlListSingleString(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	bencoded := "d8:announce23:http://tracker/announce" +
+		"8:url-list20:http://seed/file.iso" +
+		"4:infod6:lengthi1e4:name8:file.iso12:piece lengthi16384e6:pieces20:" + pieces + "ee"
+
+	got, err := From(strings.NewReader(bencoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got.UrlList, []string{"http://seed/file.iso"}); diff != "" {
+		t.Errorf("From() = %v", diff)
+	}
+}
+
+func TestCheckNumPieces(t *testing.T) {
+	for _, n := range []int64{1, MaxPieces} {
+		if err := checkNumPieces(n); err != nil {
+			t.Errorf("checkNumPieces(%d) = %v", n, err)
+		}
+	}
+	if err := checkNumPieces(MaxPieces + 1); err == nil {
+		t.Errorf("checkNumPieces(%d) accepted more pieces than addressable", int64(MaxPieces+1))
+	}
+}
+
+func TestMetaInfoFile_PieceHash(t *testing.T) {
+	hashes := make([]byte, 3*20)
+	for i := range hashes {
+		hashes[i] = byte(i / 20)
+	}
+	mi := &MetaInfoFile{Info: Info{Pieces: hex.EncodeToString(hashes)}}
+	for i := range int64(3) {
+		if diff := cmp.Diff(mi.PieceHash(i), bytes.Repeat([]byte{byte(i)}, 20)); diff != "" {
+			t.Errorf("PieceHash(%d) = %v", i, diff)
+		}
+	}
+}
+
+func TestMetaInfoFile_PieceSize(t *testing.T) {
+	const pieceLength = 32 * 1024
+	mi := &MetaInfoFile{Info: Info{
+		InfoSingleFile: &InfoSingleFile{Name: "test.bin", Length: 3*pieceLength + 13},
+		PieceLength:    pieceLength,
+	}}
+	for i, want := range []int64{pieceLength, pieceLength, pieceLength, 13} {
+		if got := mi.PieceSize(int64(i)); got != want {
+			t.Errorf("PieceSize(%d) = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestFrom_EmptyFiles(t *testing.T) {
+	b, err := os.ReadFile("./test_data/empty.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+	want := []FileInfo{{Path: "a.txt"}, {Path: filepath.Join("sub", "b.txt")}, {Path: "c.txt"}}
+	if diff := cmp.Diff(got.InfoMultiFile.Files, want); diff != "" {
+		t.Errorf("From() = %v", diff)
+	}
+	if n := got.NumPieces(); n != 0 {
+		t.Errorf("NumPieces() = %d, want 0", n)
+	}
+	if n := got.BytesToDownload(); n != 0 {
+		t.Errorf("BytesToDownload() = %d, want 0", n)
+	}
+}
+
+func TestFrom_PaddingFiles(t *testing.T) {
+	b, err := os.ReadFile("./test_data/padded.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+	var padding []bool
+	for _, f := range got.InfoMultiFile.Files {
+		padding = append(padding, f.IsPadding())
+	}
+	if diff := cmp.Diff(padding, []bool{false, true, false, true, false}); diff != "" {
+		t.Errorf("IsPadding() = %v", diff)
+	}
+	if n := got.BytesToDownload(); n != 39 {
+		t.Errorf("BytesToDownload() = %d, want 39", n)
+	}
+	if n := got.WantedBytes(); n != 29 {
+		t.Errorf("WantedBytes() = %d, want 29", n)
+	}
+	if n := got.NumPieces(); n != 3 {
+		t.Errorf("NumPieces() = %d, want 3", n)
+	}
+}
+
+func TestFrom_InvalidFiles(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	tests := []struct {
+		name  string
+		files string
+	}{
+		{name: "missing length", files: "ld4:pathl1:aeee"},
+		{name: "negative length", files: "ld6:lengthi-1e4:pathl1:aeee"},
+		{name: "no files", files: "le"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bencoded := "d8:announce23:http://tracker/announce" +
+				"4:infod5:files" + tt.files + "4:name3:dir12:piece lengthi16384e6:pieces20:" + pieces + "ee"
+			if _, err := From(strings.NewReader(bencoded)); err == nil {
+				t.Errorf("From() accepted invalid files %s", tt.files)
+			}
+		})
+	}
+}
+
+func TestFrom_PieceLength(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	tests := []struct {
+		name        string
+		pieceLength string
+		wantErr     bool
+	}{
+		{name: "power of two", pieceLength: "16384"},
+		{name: "not a power of two", pieceLength: "100000"},
+		{name: "largest addressable", pieceLength: "4294967296"},
+		{name: "zero", pieceLength: "0", wantErr: true},
+		{name: "negative", pieceLength: "-16384", wantErr: true},
+		{name: "beyond block offsets", pieceLength: "4294967297", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bencoded := "d8:announce23:http://tracker/announce" +
+				"4:infod6:lengthi1e4:name8:file.iso12:piece lengthi" + tt.pieceLength + "e6:pieces20:" + pieces + "ee"
+			_, err := From(strings.NewReader(bencoded))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("From() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFrom_Nodes(t *testing.T) {
+	b, err := os.ReadFile("./test_data/trackerless.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := From(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("From() error = %v", err)
+	}
+	want := []string{"router.example.org:6881", "192.0.2.7:6882", "[2001:db8::1]:6883"}
+	if diff := cmp.Diff(got.Nodes, want); diff != "" {
+		t.Errorf("From() = %v", diff)
+	}
+	if got.Announce != "" {
+		t.Errorf("From() Announce = %q, want none", got.Announce)
+	}
+
+	pieces := strings.Repeat("a", 20)
+	tests := []struct {
+		name  string
+		nodes string
+		want  []string
+	}{
+		{name: "single pair", nodes: "l9:192.0.2.1i6881ee", want: []string{"192.0.2.1:6881"}},
+		{name: "not a list", nodes: "5:nodes", want: nil},
+		{name: "empty", nodes: "le", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bencoded := "d8:announce23:http://tracker/announce" +
+				"4:infod6:lengthi1e4:name8:file.iso12:piece lengthi16384e6:pieces20:" + pieces + "e" +
+				"5:nodes" + tt.nodes + "e"
+			got, err := From(strings.NewReader(bencoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got.Nodes, tt.want); diff != "" {
+				t.Errorf("From() = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// Severity tells how a ValidationError affects the use of a torrent.
+type Severity uint8
+
+const (
+	// Cosmetic violations deviate from the specification,
+	// but the torrent can still be downloaded safely.
+	Cosmetic Severity = iota
+	// Security violations make the torrent unsafe to download,
+	// e.g. as its files would be created outside of the download
+	// directory.
+	Security
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Cosmetic:
+		return "cosmetic"
+	case Security:
+		return "security"
+	default:
+		return fmt.Sprintf("Severity(%d)", uint8(s))
+	}
+}
+
+var (
+	// ErrPieceLength is reported if the piece length is not a positive power of two.
+	ErrPieceLength = errors.New("piece length is not a positive power of two")
+	// ErrPieces is reported if the piece hashes do not match the length of the torrent.
+	ErrPieces = errors.New("invalid piece hashes")
+	// ErrPathTraversal is reported if a path would escape the download directory.
+	ErrPathTraversal = errors.New("path escapes the download directory")
+	// ErrEmptyName is reported if the torrent has no name.
+	ErrEmptyName = errors.New("empty name")
+	// ErrAnnounceURL is reported if the announce URL does not parse.
+	ErrAnnounceURL = errors.New("invalid announce url")
+)
+
+// ValidationError is a violation found by Validate.
+type ValidationError struct {
+	// Field is the key of the metainfo file that is invalid.
+	Field    string
+	Severity Severity
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Validate checks the metainfo file more strictly than From does and
+// returns all violations found, each a *ValidationError wrapping one of
+// the Err values of this package. Only violations of Security severity
+// make the torrent unsafe to download.
+func (m *MetaInfoFile) Validate() []error {
+	var out []error
+	report := func(field string, severity Severity, err error, format string, args ...any) {
+		if format != "" {
+			err = fmt.Errorf("%w: "+format, append([]any{err}, args...)...)
+		}
+		out = append(out, &ValidationError{Field: field, Severity: severity, Err: err})
+	}
+
+	switch {
+	case m.PieceLength <= 0:
+		report("piece length", Cosmetic, ErrPieceLength, "%d", m.PieceLength)
+	case m.PieceLength&(m.PieceLength-1) != 0:
+		// such pieces are downloaded all the same, but their last block is
+		// shorter than the others and they are not aligned to disk pages.
+		report("piece length", Cosmetic, ErrPieceLength, "%d, which may download and store slower", m.PieceLength)
+	}
+
+	switch hashes := len(m.Pieces) / 2; {
+	case len(m.Pieces)%2 != 0 || hashes%20 != 0:
+		report("pieces", Cosmetic, ErrPieces, "%d bytes are not a multiple of 20", hashes)
+	case m.PieceLength > 0:
+		want := (m.BytesToDownload() + m.PieceLength - 1) / m.PieceLength
+		if got := int64(hashes / 20); got != want {
+			report("pieces", Cosmetic, ErrPieces, "%d hashes for the %d pieces of the torrent", got, want)
+		}
+	}
+
+	switch name := m.Name(); {
+	case name == "":
+		report("name", Cosmetic, ErrEmptyName, "")
+	case !filepath.IsLocal(name):
+		report("name", Security, ErrPathTraversal, "%q", name)
+	}
+
+	if m.InfoMultiFile != nil {
+		for _, f := range m.InfoMultiFile.Files {
+			if !filepath.IsLocal(f.Path) {
+				report("files", Security, ErrPathTraversal, "%q", f.Path)
+			}
+		}
+	}
+
+	// the url is left out of the errors, as it may embed credentials.
+	if m.Announce != "" {
+		if u, err := url.Parse(m.Announce); err != nil || u.Scheme == "" || u.Host == "" {
+			report("announce", Cosmetic, ErrAnnounceURL, "")
+		}
+	}
+
+	return out
+}
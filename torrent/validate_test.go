@@ -0,0 +1,154 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// multiFileTorrent returns a bencoded torrent of a single file with the
+// bencoded path list, 16 bytes long and held by a single piece.
+func multiFileTorrent(name, path string) string {
+	return "d8:announce23:http://tracker/announce" +
+		"4:infod5:filesld6:lengthi16e4:path" + path + "ee" +
+		"4:name" + bencodeString(name) + "12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20) + "ee"
+}
+
+func bencodeString(s string) string { return strconv.Itoa(len(s)) + ":" + s }
+
+func TestMetaInfoFile_ValidatePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		path string
+		want error
+	}{
+		{name: "nested", dir: "dir", path: "l3:sub4:filee"},
+		{name: "dot dot in the middle", dir: "dir", path: "l1:a2:..1:be"},
+		{name: "parent traversal", dir: "dir", path: "l2:..2:..3:etc6:passwde", want: ErrPathTraversal},
+		{name: "escaping after descending", dir: "dir", path: "l1:a2:..2:..3:etce", want: ErrPathTraversal},
+		{name: "traversal within component", dir: "dir", path: "l9:../passwde", want: ErrPathTraversal},
+		{name: "absolute component", dir: "dir", path: "l4:/etc6:passwde", want: ErrPathTraversal},
+		{name: "only dot dot", dir: "dir", path: "l2:..e", want: ErrPathTraversal},
+		{name: "traversal in name", dir: "..", path: "l4:filee", want: ErrPathTraversal},
+		{name: "absolute name", dir: "/tmp", path: "l4:filee", want: ErrPathTraversal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mi, err := From(strings.NewReader(multiFileTorrent(tt.dir, tt.path)))
+			if err != nil {
+				t.Fatalf("From() error = %v", err)
+			}
+
+			var security []error
+			for _, err := range mi.Validate() {
+				var v *ValidationError
+				if !errors.As(err, &v) {
+					t.Fatalf("Validate() returned %T, want *ValidationError", err)
+				}
+				if v.Severity == Security {
+					security = append(security, err)
+				}
+			}
+			switch {
+			case tt.want == nil && len(security) > 0:
+				t.Errorf("Validate() = %v, want no security violation", security)
+			case tt.want != nil && (len(security) != 1 || !errors.Is(security[0], tt.want)):
+				t.Errorf("Validate() = %v, want %v", security, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetaInfoFile_Validate(t *testing.T) {
+	valid := func() *MetaInfoFile {
+		return &MetaInfoFile{
+			Announce: "udp://tracker.example.org:6969/announce",
+			Info: Info{
+				InfoSingleFile: &InfoSingleFile{Name: "file.bin", Length: 40},
+				PieceLength:    16,
+				Pieces:         strings.Repeat("ab", 3*20),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *MetaInfoFile)
+		want   []error
+	}{
+		{name: "valid", modify: func(*MetaInfoFile) {}},
+		{
+			name:   "piece length not a power of two",
+			modify: func(m *MetaInfoFile) { m.PieceLength = 20; m.Pieces = strings.Repeat("ab", 2*20) },
+			want:   []error{ErrPieceLength},
+		},
+		{
+			name:   "pieces not a multiple of 20",
+			modify: func(m *MetaInfoFile) { m.Pieces = strings.Repeat("ab", 3*20+1) },
+			want:   []error{ErrPieces},
+		},
+		{
+			name:   "too few pieces",
+			modify: func(m *MetaInfoFile) { m.Pieces = strings.Repeat("ab", 2*20) },
+			want:   []error{ErrPieces},
+		},
+		{
+			name:   "empty name",
+			modify: func(m *MetaInfoFile) { m.InfoSingleFile.Name = "" },
+			want:   []error{ErrEmptyName},
+		},
+		{
+			name:   "unparsable announce",
+			modify: func(m *MetaInfoFile) { m.Announce = "http://[::1" },
+			want:   []error{ErrAnnounceURL},
+		},
+		{
+			name:   "announce without scheme",
+			modify: func(m *MetaInfoFile) { m.Announce = "tracker.example.org/announce" },
+			want:   []error{ErrAnnounceURL},
+		},
+		{
+			name: "several",
+			modify: func(m *MetaInfoFile) {
+				m.PieceLength = 0
+				m.InfoSingleFile.Name = "../file.bin"
+			},
+			want: []error{ErrPieceLength, ErrPathTraversal},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid()
+			tt.modify(m)
+			got := m.Validate()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want %v", got, tt.want)
+			}
+			for i, err := range got {
+				if !errors.Is(err, tt.want[i]) {
+					t.Errorf("Validate()[%d] = %v, want %v", i, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMetaInfoFile_ValidateFixtures(t *testing.T) {
+	for _, name := range []string{"debian.torrent", "ubuntu.torrent", "empty.torrent", "padded.torrent", "trackerless.torrent"} {
+		b, err := os.ReadFile("./test_data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mi, err := From(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("From(%s) error = %v", name, err)
+		}
+		if errs := mi.Validate(); len(errs) > 0 {
+			t.Errorf("Validate(%s) = %v", name, errs)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// PieceStatus is the result of verifying a single piece against on-disk data.
+type PieceStatus uint8
+
+const (
+	// PieceMissing indicates that at least part of the piece is not present on disk.
+	PieceMissing PieceStatus = iota
+	// PieceOK indicates that the piece is present and its SHA1 hash matches.
+	PieceOK
+	// PieceBad indicates that the piece is present but its SHA1 hash differs.
+	PieceBad
+)
+
+// VerifyOptions tune the verification of on-disk data.
+type VerifyOptions struct {
+	// Workers is the number of pieces hashed in parallel.
+	// Defaults to the number of CPUs if not positive.
+	Workers int
+}
+
+// ByteRange is a range of bytes within the concatenated torrent data.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// FileReport describes how much of a single file was verified.
+type FileReport struct {
+	// Path of the file relative to the root directory.
+	Path string
+	// Length of the file in bytes.
+	Length int64
+	// Verified is the number of bytes of the file covered by pieces that passed verification.
+	Verified int64
+}
+
+// Completeness returns the percentage of the file covered by verified pieces.
+func (f FileReport) Completeness() float64 {
+	if f.Length == 0 {
+		return 100
+	}
+	return float64(f.Verified) / float64(f.Length) * 100
+}
+
+// Report is the outcome of verifying on-disk data against a torrent.
+type Report struct {
+	// Pieces holds the status of each piece, indexed by the piece index.
+	Pieces []PieceStatus
+	// OK, Bad and Missing are the number of pieces in the respective status.
+	OK, Bad, Missing int
+	// Files describes the completeness of each file of the torrent,
+	// except for the padding files.
+	Files []FileReport
+	// BadRanges are the merged byte ranges of the pieces whose data differs.
+	BadRanges []ByteRange
+}
+
+// fileSpan describes where a file is located within
+// the concatenated torrent data.
+type fileSpan struct {
+	path   string
+	offset int64
+	length int64
+	// padding is set for padding files, whose zeros
+	// are not expected to be present on disk.
+	padding bool
+}
+
+// spans returns the files of the torrent, relative to root, in the order
+// in which they appear within the concatenated torrent data.
+func (m *MetaInfoFile) spans(root string) []fileSpan {
+	switch {
+	case m.InfoSingleFile != nil:
+		return []fileSpan{{path: filepath.Join(root, m.InfoSingleFile.Name), length: m.InfoSingleFile.Length}}
+	case m.InfoMultiFile != nil:
+		var out []fileSpan
+		var offset int64
+		for _, f := range m.InfoMultiFile.Files {
+			out = append(out, fileSpan{
+				path:    filepath.Join(root, m.InfoMultiFile.Name, f.Path),
+				offset:  offset,
+				length:  f.Length,
+				padding: f.IsPadding(),
+			})
+			offset += f.Length
+		}
+		return out
+	default:
+		panic("malformed meta_info_file state")
+	}
+}
+
+// VerifyData hashes the data of the torrent located under root against the
+// piece hashes of the metainfo file. For single file torrents the file is
+// expected at root/name, for multi file torrents at root/name/path. The
+// torrent is not added to any client and no network communication happens.
+func VerifyData(ctx context.Context, mi *MetaInfoFile, root string, opts VerifyOptions) (Report, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	spans := mi.spans(root)
+	files := make([]*os.File, len(spans))
+	sizes := make([]int64, len(spans))
+	for i, s := range spans {
+		f, err := os.Open(s.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return Report{}, fmt.Errorf("failed to open %s: %w", s.path, err)
+		}
+		defer f.Close()
+
+		st, err := f.Stat()
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to stat %s: %w", s.path, err)
+		}
+		files[i], sizes[i] = f, st.Size()
+	}
+
+	numPieces := mi.NumPieces()
+	report := Report{Pieces: make([]PieceStatus, numPieces)}
+
+	indices := make(chan int64)
+	var wg sync.WaitGroup
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				report.Pieces[idx] = verifyPiece(mi, spans, files, sizes, idx)
+			}
+		}()
+	}
+
+	var err error
+send:
+	for idx := range numPieces {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break send
+		case indices <- idx:
+		}
+	}
+	close(indices)
+	wg.Wait()
+	if err != nil {
+		return Report{}, err
+	}
+
+	var last *ByteRange
+	for idx, s := range report.Pieces {
+		start := int64(idx) * mi.PieceLength
+		end := min(start+mi.PieceLength, mi.BytesToDownload())
+		switch s {
+		case PieceOK:
+			report.OK++
+		case PieceMissing:
+			report.Missing++
+		case PieceBad:
+			report.Bad++
+			if last != nil && last.Offset+last.Length == start {
+				last.Length += end - start
+				continue
+			}
+			report.BadRanges = append(report.BadRanges, ByteRange{Offset: start, Length: end - start})
+			last = &report.BadRanges[len(report.BadRanges)-1]
+		}
+	}
+
+	for _, s := range spans {
+		if s.padding {
+			continue
+		}
+		rel, err := filepath.Rel(root, s.path)
+		if err != nil {
+			rel = s.path
+		}
+		fr := FileReport{Path: rel, Length: s.length}
+		for off := s.offset; off < s.offset+s.length; {
+			idx := off / mi.PieceLength
+			pieceEnd := (idx + 1) * mi.PieceLength
+			chunk := min(pieceEnd, s.offset+s.length) - off
+			if report.Pieces[idx] == PieceOK {
+				fr.Verified += chunk
+			}
+			off += chunk
+		}
+		report.Files = append(report.Files, fr)
+	}
+
+	return report, nil
+}
+
+func verifyPiece(mi *MetaInfoFile, spans []fileSpan, files []*os.File, sizes []int64, idx int64) PieceStatus {
+	start := idx * mi.PieceLength
+	end := min(start+mi.PieceLength, mi.BytesToDownload())
+
+	data := make([]byte, 0, end-start)
+	for i, s := range spans {
+		if s.offset+s.length <= start || s.offset >= end {
+			continue
+		}
+		from := max(start, s.offset) - s.offset
+		to := min(end, s.offset+s.length) - s.offset
+		if s.padding && files[i] == nil {
+			data = append(data, make([]byte, to-from)...)
+			continue
+		}
+		if files[i] == nil || sizes[i] < to {
+			return PieceMissing
+		}
+		buf := make([]byte, to-from)
+		if _, err := files[i].ReadAt(buf, from); err != nil && !errors.Is(err, io.EOF) {
+			return PieceMissing
+		}
+		data = append(data, buf...)
+	}
+
+	digest := sha1.Sum(data)
+	if !bytes.Equal(digest[:], mi.PieceHash(idx)) {
+		return PieceBad
+	}
+	return PieceOK
+}
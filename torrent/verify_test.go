@@ -0,0 +1,205 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pieceHashes(data []byte, pieceLength int64) string {
+	var out []byte
+	for off := int64(0); off < int64(len(data)); off += pieceLength {
+		h := sha1.Sum(data[off:min(off+pieceLength, int64(len(data)))])
+		out = append(out, h[:]...)
+	}
+	return hex.EncodeToString(out)
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestVerifyData_SingleFile(t *testing.T) {
+	data := payload(10*16 + 7)
+	mi := &MetaInfoFile{Info: Info{
+		InfoSingleFile: &InfoSingleFile{Name: "file.bin", Length: int64(len(data))},
+		PieceLength:    16,
+		Pieces:         pieceHashes(data, 16),
+	}}
+
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "file.bin"), data, 0o644))
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{Workers: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 11, r.OK)
+	assert.Equal(t, 0, r.Bad)
+	assert.Equal(t, 0, r.Missing)
+	assert.Empty(t, r.BadRanges)
+	assert.Equal(t, []FileReport{{Path: "file.bin", Length: int64(len(data)), Verified: int64(len(data))}}, r.Files)
+	assert.Equal(t, 100.0, r.Files[0].Completeness())
+}
+
+func TestVerifyData_MultiFile(t *testing.T) {
+	data := payload(100)
+	mi := &MetaInfoFile{Info: Info{
+		InfoMultiFile: &InfoMultiFile{Name: "dir", Files: []FileInfo{
+			{Path: "a.bin", Length: 30},
+			{Path: filepath.Join("sub", "b.bin"), Length: 50},
+			{Path: "c.bin", Length: 20},
+		}},
+		PieceLength: 32,
+		Pieces:      pieceHashes(data, 32),
+	}}
+
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "dir", "sub"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "dir", "a.bin"), data[:30], 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "dir", "sub", "b.bin"), data[30:80], 0o644))
+	// c.bin is missing.
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{})
+	assert.Nil(t, err)
+	// pieces: [0,32) [32,64) [64,96) [96,100)
+	assert.Equal(t, []PieceStatus{PieceOK, PieceOK, PieceMissing, PieceMissing}, r.Pieces)
+	assert.Equal(t, 2, r.OK)
+	assert.Equal(t, 2, r.Missing)
+	assert.Equal(t, []FileReport{
+		{Path: filepath.Join("dir", "a.bin"), Length: 30, Verified: 30},
+		{Path: filepath.Join("dir", "sub", "b.bin"), Length: 50, Verified: 34},
+		{Path: filepath.Join("dir", "c.bin"), Length: 20, Verified: 0},
+	}, r.Files)
+}
+
+func TestVerifyData_OddPieceLength(t *testing.T) {
+	const pieceLength = 100_000
+	data := payload(2*pieceLength + 12_345)
+	mi := &MetaInfoFile{Info: Info{
+		InfoMultiFile: &InfoMultiFile{Name: "dir", Files: []FileInfo{
+			{Path: "a.bin", Length: 65_536},
+			{Path: "b.bin", Length: int64(len(data)) - 65_536},
+		}},
+		PieceLength: pieceLength,
+		Pieces:      pieceHashes(data, pieceLength),
+	}}
+
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "dir"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "dir", "a.bin"), data[:65_536], 0o644))
+	corrupt := bytes.Clone(data[65_536:])
+	// the byte is in the second piece.
+	corrupt[pieceLength] ^= 0xff
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "dir", "b.bin"), corrupt, 0o644))
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []PieceStatus{PieceOK, PieceBad, PieceOK}, r.Pieces)
+	assert.Equal(t, []ByteRange{{Offset: pieceLength, Length: pieceLength}}, r.BadRanges)
+	assert.Equal(t, []FileReport{
+		{Path: filepath.Join("dir", "a.bin"), Length: 65_536, Verified: 65_536},
+		{Path: filepath.Join("dir", "b.bin"), Length: int64(len(data)) - 65_536, Verified: int64(len(data)) - 65_536 - pieceLength},
+	}, r.Files)
+}
+
+func TestVerifyData_CorruptedRegion(t *testing.T) {
+	data := payload(8 * 16)
+	mi := &MetaInfoFile{Info: Info{
+		InfoSingleFile: &InfoSingleFile{Name: "file.bin", Length: int64(len(data))},
+		PieceLength:    16,
+		Pieces:         pieceHashes(data, 16),
+	}}
+
+	corrupted := append([]byte(nil), data...)
+	for i := 20; i < 40; i++ { // spans pieces 1 and 2.
+		corrupted[i] ^= 0xFF
+	}
+	corrupted[100] ^= 0xFF // piece 6.
+
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "file.bin"), corrupted, 0o644))
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{Workers: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, r.OK)
+	assert.Equal(t, 3, r.Bad)
+	assert.Equal(t, []ByteRange{{Offset: 16, Length: 32}, {Offset: 96, Length: 16}}, r.BadRanges)
+	assert.Equal(t, int64(5*16), r.Files[0].Verified)
+}
+
+func TestVerifyData_Canceled(t *testing.T) {
+	data := payload(64)
+	mi := &MetaInfoFile{Info: Info{
+		InfoSingleFile: &InfoSingleFile{Name: "file.bin", Length: int64(len(data))},
+		PieceLength:    16,
+		Pieces:         pieceHashes(data, 16),
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := VerifyData(ctx, mi, t.TempDir(), VerifyOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// readFixture parses the torrent file in test_data.
+func readFixture(t *testing.T, name string) *MetaInfoFile {
+	t.Helper()
+	f, err := os.Open(filepath.Join("test_data", name))
+	assert.Nil(t, err)
+	defer f.Close()
+	mi, err := From(f)
+	assert.Nil(t, err)
+	return mi
+}
+
+func TestVerifyData_EmptyFiles(t *testing.T) {
+	mi := readFixture(t, "empty.torrent")
+
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "empty", "sub"), os.ModePerm))
+	for _, f := range mi.InfoMultiFile.Files {
+		assert.Nil(t, os.WriteFile(filepath.Join(root, "empty", f.Path), nil, 0o644))
+	}
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, r.Pieces)
+	assert.Equal(t, []FileReport{
+		{Path: filepath.Join("empty", "a.txt")},
+		{Path: filepath.Join("empty", "sub", "b.txt")},
+		{Path: filepath.Join("empty", "c.txt")},
+	}, r.Files)
+	for _, f := range r.Files {
+		assert.Equal(t, 100.0, f.Completeness())
+	}
+}
+
+func TestVerifyData_PaddingFiles(t *testing.T) {
+	mi := readFixture(t, "padded.torrent")
+
+	// the padding files are not stored, their zeros still verify.
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "padded"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "padded", "a.txt"), bytes.Repeat([]byte("a"), 10), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "padded", "b.txt"), bytes.Repeat([]byte("b"), 12), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "padded", "c.txt"), bytes.Repeat([]byte("c"), 7), 0o644))
+
+	r, err := VerifyData(context.Background(), mi, root, VerifyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []PieceStatus{PieceOK, PieceOK, PieceOK}, r.Pieces)
+	assert.Equal(t, []FileReport{
+		{Path: filepath.Join("padded", "a.txt"), Length: 10, Verified: 10},
+		{Path: filepath.Join("padded", "b.txt"), Length: 12, Verified: 12},
+		{Path: filepath.Join("padded", "c.txt"), Length: 7, Verified: 7},
+	}, r.Files)
+}